@@ -5,8 +5,12 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"net"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"syscall"
 
 	"phantom-grid/internal/agent"
 	"phantom-grid/internal/config"
@@ -27,6 +31,26 @@ func main() {
 		fmt.Fprintf(os.Stderr, "  sudo %s -interface ens33\n\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "  # With Dynamic Asymmetric SPA\n")
 		fmt.Fprintf(os.Stderr, "  sudo %s -interface ens33 -spa-mode asymmetric -spa-key-dir ./keys\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  # With sk_lookup steering (no packet rewriting, any free honeypot port)\n")
+		fmt.Fprintf(os.Stderr, "  sudo %s -interface ens33 -steering sklookup -steer-passthrough 80,443\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  # With stricter SYN-flood protection (20 SYN/s, bursts of 40)\n")
+		fmt.Fprintf(os.Stderr, "  sudo %s -interface ens33 -rate-syn 20/40\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  # With stricter auto-ban (permanent ban after 3 trap hits, never ban the admin host)\n")
+		fmt.Fprintf(os.Stderr, "  sudo %s -interface ens33 -ban-trap-hits 3 -ban-ttl 0 -ban-exempt 192.168.1.10\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  # Answer stealth scans with random open/closed resets instead of dropping them\n")
+		fmt.Fprintf(os.Stderr, "  sudo %s -interface ens33 -stealth-response random\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  # With a tarpit on low ports (XDP) and VNC ports (honeypot trickle)\n")
+		fmt.Fprintf(os.Stderr, "  sudo %s -interface ens33 -tarpit 1-1023:xdp,5900-5910:honeypot\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  # Let SSH and Telnet bots in only with common IoT credentials\n")
+		fmt.Fprintf(os.Stderr, "  sudo %s -interface ens33 -login-policy list -login-credentials root:xc3511,root:vizxv,admin:admin\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  # Drop honeypot and database replies that match custom DLP rules\n")
+		fmt.Fprintf(os.Stderr, "  sudo %s -interface ens33 -dlp enforce -dlp-rules ./dlp.rules -dlp-ports 9999,3306\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  # Inspect everything a real service sends\n")
+		fmt.Fprintf(os.Stderr, "  sudo %s -interface ens33 -dlp monitor -dlp-cgroups nginx.service,mysql.service\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  # Restart without a gap in protection: the running agent hands over on SIGUSR2\n")
+		fmt.Fprintf(os.Stderr, "  sudo pkill -USR2 -x phantom-grid && sudo %s -interface ens33\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  # With an office allowlist and a mirrored denylist feed\n")
+		fmt.Fprintf(os.Stderr, "  sudo %s -interface ens33 -allow-list ./lists/office.txt -deny-list https://www.spamhaus.org/drop/drop.txt\n\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "  # With ELK integration\n")
 		fmt.Fprintf(os.Stderr, "  sudo %s -interface ens33 -output both -elk-address http://localhost:9200\n\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "See docs/GETTING_STARTED.md for detailed instructions.\n")
@@ -47,8 +71,105 @@ func main() {
 	spaKeyDirFlag := flag.String("spa-key-dir", "./keys", "Directory containing SPA keys")
 	spaTOTPSecretFlag := flag.String("spa-totp-secret", "", "TOTP secret (base64 encoded, 32 bytes). If not provided, auto-loads from keys/totp_secret.txt")
 	spaStaticTokenFlag := flag.String("spa-static-token", "", "Static SPA token (for static mode). If not provided, will prompt or use default")
+
+	// Honeypot steering flags
+	steeringFlag := flag.String("steering", "redirect", "Honeypot steering mode: 'redirect' (XDP port rewrite) or 'sklookup' (socket assignment)")
+	steerPassthroughFlag := flag.String("steer-passthrough", "", "Comma-separated ports sk_lookup must not steer (real services on this host)")
+
+	// Packet parsing flags
+	fragmentsFlag := flag.String("fragments", string(config.FragmentPolicyPass), "Non-first IPv4 fragments: 'pass' (first fragment is screened) or 'drop'")
+	stealthResponseFlag := flag.String("stealth-response", string(config.StealthResponseDrop), "Stealth scan response: 'drop', 'rst-open', 'rst-closed' or 'random', for all scans or per scan as e.g. 'xmas=random,ack=rst-closed'")
+
+	// OS personality flags
+	defaultOSPersonality := config.DefaultOSPersonalityConfig()
+	osProfileFlag := flag.String("os-profile", string(defaultOSPersonality.Mode), "Honeypot OS fingerprint: 'per-source', 'off', or a profile name ("+strings.Join(osProfileNames(), ", ")+")")
+	osProfilesFlag := flag.String("os-profiles", "", "Comma-separated profiles to choose from in per-source mode (default: all)")
+	osSessionFlag := flag.Int("os-session", defaultOSPersonality.SessionSeconds, "Seconds of inactivity before a source may be given a different OS profile (0 = never)")
+
+	// Tarpit flags
+	defaultTarpit := config.DefaultTarpitConfig()
+	tarpitFlag := flag.String("tarpit", "", "Comma-separated tarpit port ranges: 'port' or 'from-to', optionally ':xdp' (default) or ':honeypot'")
+	tarpitPerSourceFlag := flag.Int("tarpit-per-source", defaultTarpit.MaxPerSource, "Stalled tarpit connections per source (0 = unlimited)")
+	tarpitMaxConnsFlag := flag.Int("tarpit-max-conns", defaultTarpit.MaxConnections, "Connections the honeypot tarpit holds open at once (0 = unlimited)")
+	tarpitTimeoutFlag := flag.Int("tarpit-timeout", defaultTarpit.FlowTimeoutSeconds, "Seconds before an idle tarpit connection is released")
+	tarpitTrickleFlag := flag.Int("tarpit-trickle", defaultTarpit.TrickleIntervalSeconds, "Seconds between bytes sent by the honeypot tarpit")
+
+	// SSH persona flags
+	sshHostKeysFlag := flag.String("ssh-host-keys", config.DefaultSSHConfig().HostKeyDir, "Directory for the SSH persona's host keys, generated on first start (empty = new keys every start)")
+
+	// Login policy flags (SSH, Telnet and MySQL personas)
+	defaultLogin := config.DefaultLoginConfig()
+	loginPolicyFlag := flag.String("login-policy", string(defaultLogin.Policy), "Logins the SSH, Telnet and MySQL personas accept: 'any', 'list' (-login-credentials) or 'attempt' (-login-attempt)")
+	loginCredentialsFlag := flag.String("login-credentials", "", "Comma-separated 'user:password' pairs accepted by the list policy ('*' matches anything)")
+	loginAttemptFlag := flag.Int("login-attempt", defaultLogin.Attempt, "Login attempt that succeeds under the attempt policy")
+	loginMaxAttemptsFlag := flag.Int("login-max-attempts", defaultLogin.MaxAttempts, "Failed logins before the connection is closed")
+
+	// Fake file system flags (SSH and Telnet personas)
+	defaultFileSystem := config.DefaultFileSystemConfig()
+	fsImageFlag := flag.String("fs-image", defaultFileSystem.Image, "Directory or tar archive the fake shell's file system is loaded from, e.g. made with 'phantom image' (empty = built-in image)")
+	artifactDirFlag := flag.String("artifact-dir", defaultFileSystem.ArtifactDir, "Directory where files attackers write in the fake shell are kept, named by SHA-256 (empty = log only)")
+	sessionQuotaFlag := flag.Int64("session-quota", defaultFileSystem.SessionQuota, "Bytes a shell session may write before its disk is full")
+
+	// Session recording flags (SSH and Telnet personas)
+	defaultRecording := config.DefaultRecordingConfig()
+	sessionDirFlag := flag.String("session-dir", defaultRecording.Dir, "Directory where shell sessions are recorded as asciicast v2 files, for 'phantom sessions' and 'phantom replay' (empty = not recorded)")
+	sessionMaxSizeFlag := flag.Int64("session-max-size", defaultRecording.MaxBytes, "Bytes a session recording may have; the rest of the session is not recorded (0 = no limit)")
+
+	// Payload fetching flags (download commands in the fake shell)
+	defaultPayload := config.DefaultPayloadConfig()
+	fetchPayloadsFlag := flag.Bool("fetch-payloads", defaultPayload.Fetch, "Download the payloads wget, curl, tftp and ftpget in the fake shell point at, keeping them in -artifact-dir")
+	payloadProxyFlag := flag.String("payload-proxy", defaultPayload.Proxy, "HTTP proxy payloads are fetched through, e.g. http://proxy:3128 (empty = direct, public addresses only; tftp needs direct access)")
+	payloadMaxSizeFlag := flag.Int64("payload-max-size", defaultPayload.MaxBytes, "Bytes a fetched payload may have")
+	payloadTimeoutFlag := flag.Int("payload-timeout", defaultPayload.TimeoutSeconds, "Seconds a payload fetch may take")
+
+	// HTTP persona flags
+	defaultHTTP := config.DefaultHTTPConfig()
+	httpSitesFlag := flag.String("http-sites", defaultHTTP.SiteDir, "Directory with extra HTTP site templates, one subdirectory with a site.json per site (empty = built-in sites only)")
+	httpRulesFlag := flag.String("http-rules", defaultHTTP.RuleDir, "Directory with extra rule packs (.json) that classify HTTP requests; packs named like built-in ones replace them (empty = built-in rules only)")
+	httpSiteFlag := flag.String("http-site", defaultHTTP.DefaultSite, "HTTP site served on ports no site claims: wordpress, jenkins, gitlab, phpmyadmin, grafana or one in -http-sites")
+	httpCertDirFlag := flag.String("http-cert-dir", defaultHTTP.CertDir, "Directory for the HTTP persona's TLS certificate, generated on first start (empty = new certificate every start)")
+	httpMaxBodyFlag := flag.Int64("http-max-body", defaultHTTP.MaxBodyBytes, "Bytes of a request body that are captured")
+	httpMaxUploadFlag := flag.Int64("http-max-upload", defaultHTTP.MaxUploadBytes, "Bytes of a request body read for the files it uploads; larger files are kept cut short")
+
+	// MySQL persona flags
+	defaultMySQL := config.DefaultMySQLConfig()
+	mysqlSchemasFlag := flag.String("mysql-schemas", defaultMySQL.SchemaDir, "Directory with extra databases of the MySQL persona, one .json file per database; files named like built-in databases replace them (empty = built-in databases only)")
+	mysqlQuotaFlag := flag.Int64("mysql-session-quota", defaultMySQL.SessionQuota, "Bytes of rows a MySQL connection may insert or update before its tables are full (0 = no limit)")
+
+	// Egress DLP flags
+	defaultDLP := config.DefaultDLPConfig()
+	dlpModeFlag := flag.String("dlp", string(defaultDLP.Mode), "Egress DLP mode: 'monitor' (report matches), 'enforce' (drop matches) or 'off'")
+	dlpRulesFlag := flag.String("dlp-rules", "", "File with DLP rules, one 'name prefix|contains|base64 pattern' per line (default: built-in rules)")
+	dlpPortsFlag := flag.String("dlp-ports", formatPortList(defaultDLP.Ports), "Comma-separated local source ports whose outgoing payloads are inspected")
+	dlpCgroupsFlag := flag.String("dlp-cgroups", "", "Comma-separated cgroup v2 directories or systemd units (e.g. nginx.service) whose outgoing payloads are inspected on any interface")
+
+	// Rate limiting flags (packets per second per source, optional burst: 'pps' or 'pps/burst', 0 disables)
+	defaultLimits := config.DefaultRateLimitConfig()
+	rateSPAFlag := flag.String("rate-spa", formatRateLimit(defaultLimits.SPA), "Per-source SPA packet rate limit: 'pps' or 'pps/burst' (0 disables)")
+	rateSYNFlag := flag.String("rate-syn", formatRateLimit(defaultLimits.SYN), "Per-source SYN rate limit for honeypot ports: 'pps' or 'pps/burst' (0 disables)")
+	rateTotalFlag := flag.String("rate-total", formatRateLimit(defaultLimits.Total), "Per-source total packet rate limit: 'pps' or 'pps/burst' (0 disables)")
+
+	// Automatic ban flags
+	defaultBanPolicy := config.DefaultBanPolicyConfig()
+	autobanFlag := flag.Bool("autoban", defaultBanPolicy.Enabled, "Automatically ban hostile sources in XDP")
+	banTrapHitsFlag := flag.Int("ban-trap-hits", defaultBanPolicy.TrapHitThreshold, "Ban a source after this many honeypot trap hits within the ban window (0 disables)")
+	banFailedKnocksFlag := flag.Int("ban-failed-knocks", defaultBanPolicy.FailedKnockThreshold, "Ban a source after this many failed SPA knocks within the ban window (0 disables; knock sources can be forged, so exempt admin addresses first)")
+	banWindowFlag := flag.Int("ban-window", defaultBanPolicy.WindowSeconds, "Window in seconds for counting trap hits and failed knocks")
+	banTTLFlag := flag.Int("ban-ttl", defaultBanPolicy.BanDurationSeconds, "Ban duration in seconds (0 = permanent)")
+	banExemptFlag := flag.String("ban-exempt", "", "Comma-separated IPs that are never banned automatically")
+
+	// CIDR list flags
+	defaultLists := config.DefaultCIDRListConfig()
+	allowListFlag := flag.String("allow-list", "", "Comma-separated files or http(s) feed URLs with always-allowed prefixes")
+	denyListFlag := flag.String("deny-list", "", "Comma-separated files or http(s) feed URLs with always-dropped prefixes")
+	listMirrorDirFlag := flag.String("list-mirror-dir", defaultLists.MirrorDir, "Directory for local copies of CIDR feed URLs")
+	listRefreshFlag := flag.Int("list-refresh", defaultLists.RefreshSeconds, "Reload CIDR lists every N seconds (0 = load once)")
 	
 	// Help flag
+	defaultPersistence := config.DefaultPersistenceConfig()
+	pinFlag := flag.Bool("pin", defaultPersistence.Enabled, "Pin BPF maps and links under "+config.BPFPinDir+" and reuse them on start, so restarts keep whitelists and counters")
+	keepOnExitFlag := flag.Bool("keep-on-exit", defaultPersistence.KeepOnExit, "Leave XDP attached and state pinned whenever the agent exits (by default only on SIGUSR2, the restart signal)")
+
 	helpFlag := flag.Bool("h", false, "Show help message")
 	helpFlag2 := flag.Bool("help", false, "Show help message")
 	
@@ -172,13 +293,221 @@ func main() {
 		}
 	}
 
+	// Configure honeypot steering
+	agentConfig := config.DefaultAgentConfig()
+	switch strings.ToLower(*steeringFlag) {
+	case "redirect":
+		agentConfig.SteeringMode = config.SteeringModeRedirect
+	case "sklookup", "sk_lookup":
+		agentConfig.SteeringMode = config.SteeringModeSkLookup
+	default:
+		log.Fatalf("[!] Invalid steering mode: %s. Use 'redirect' or 'sklookup'", *steeringFlag)
+	}
+	if *steerPassthroughFlag != "" {
+		ports, err := parsePortList(*steerPassthroughFlag)
+		if err != nil {
+			log.Fatalf("[!] Invalid -steer-passthrough: %v", err)
+		}
+		agentConfig.PassthroughPorts = ports
+	}
+
+	// Configure fragment handling
+	switch strings.ToLower(*fragmentsFlag) {
+	case "pass":
+		agentConfig.FragmentPolicy = config.FragmentPolicyPass
+	case "drop":
+		agentConfig.FragmentPolicy = config.FragmentPolicyDrop
+	default:
+		log.Fatalf("[!] Invalid fragment policy: %s. Use 'pass' or 'drop'", *fragmentsFlag)
+	}
+
+	// Configure stealth scan responses
+	stealthResponses, err := config.ParseStealthResponses(*stealthResponseFlag, agentConfig.StealthResponses)
+	if err != nil {
+		log.Fatalf("[!] Invalid -stealth-response: %v", err)
+	}
+	agentConfig.StealthResponses = stealthResponses
+
+	// Configure OS personality
+	agentConfig.OSPersonality.SessionSeconds = *osSessionFlag
+	switch mode := strings.ToLower(*osProfileFlag); mode {
+	case string(config.OSPersonalityOff), string(config.OSPersonalityPerSource):
+		agentConfig.OSPersonality.Mode = config.OSPersonalityMode(mode)
+	default:
+		if _, ok := config.FindOSProfile(mode); !ok {
+			log.Fatalf("[!] Invalid OS profile: %s. Use 'per-source', 'off' or one of: %s", *osProfileFlag, strings.Join(osProfileNames(), ", "))
+		}
+		agentConfig.OSPersonality.Mode = config.OSPersonalityFixed
+		agentConfig.OSPersonality.Profile = mode
+	}
+	for _, name := range splitList(*osProfilesFlag) {
+		if _, ok := config.FindOSProfile(name); !ok {
+			log.Fatalf("[!] Invalid -os-profiles entry: %s", name)
+		}
+		agentConfig.OSPersonality.Profiles = append(agentConfig.OSPersonality.Profiles, name)
+	}
+
+	// Configure tarpit
+	if *tarpitFlag != "" {
+		ranges, err := config.ParseTarpitRanges(*tarpitFlag)
+		if err != nil {
+			log.Fatalf("[!] Invalid -tarpit: %v", err)
+		}
+		agentConfig.Tarpit.Enabled = true
+		agentConfig.Tarpit.Ranges = ranges
+	}
+	agentConfig.Tarpit.MaxPerSource = *tarpitPerSourceFlag
+	agentConfig.Tarpit.MaxConnections = *tarpitMaxConnsFlag
+	agentConfig.Tarpit.FlowTimeoutSeconds = *tarpitTimeoutFlag
+	agentConfig.Tarpit.TrickleIntervalSeconds = *tarpitTrickleFlag
+	if err := agentConfig.Tarpit.Validate(); err != nil {
+		log.Fatalf("[!] Invalid tarpit settings: %v", err)
+	}
+
+	// Configure SSH persona
+	agentConfig.SSH.HostKeyDir = *sshHostKeysFlag
+
+	// Configure login policy
+	agentConfig.Login.Policy = config.LoginPolicy(strings.ToLower(*loginPolicyFlag))
+	if *loginCredentialsFlag != "" {
+		creds, err := config.ParseCredentials(*loginCredentialsFlag)
+		if err != nil {
+			log.Fatalf("[!] Invalid -login-credentials: %v", err)
+		}
+		agentConfig.Login.Credentials = creds
+	}
+	agentConfig.Login.Attempt = *loginAttemptFlag
+	agentConfig.Login.MaxAttempts = *loginMaxAttemptsFlag
+	if err := agentConfig.Login.Validate(); err != nil {
+		log.Fatalf("[!] Invalid login policy: %v", err)
+	}
+
+	// Configure fake file system
+	agentConfig.FileSystem.Image = *fsImageFlag
+	agentConfig.FileSystem.ArtifactDir = *artifactDirFlag
+	agentConfig.FileSystem.SessionQuota = *sessionQuotaFlag
+
+	// Configure session recording
+	agentConfig.Recording.Dir = *sessionDirFlag
+	agentConfig.Recording.MaxBytes = *sessionMaxSizeFlag
+
+	// Configure payload fetching
+	agentConfig.Payload.Fetch = *fetchPayloadsFlag
+	agentConfig.Payload.Proxy = *payloadProxyFlag
+	agentConfig.Payload.MaxBytes = *payloadMaxSizeFlag
+	agentConfig.Payload.TimeoutSeconds = *payloadTimeoutFlag
+	if err := agentConfig.Payload.Validate(); err != nil {
+		log.Fatalf("[!] Invalid payload fetching: %v", err)
+	}
+
+	// Configure HTTP persona
+	agentConfig.HTTP.SiteDir = *httpSitesFlag
+	agentConfig.HTTP.DefaultSite = *httpSiteFlag
+	agentConfig.HTTP.RuleDir = *httpRulesFlag
+	agentConfig.HTTP.CertDir = *httpCertDirFlag
+	agentConfig.HTTP.MaxBodyBytes = *httpMaxBodyFlag
+	agentConfig.HTTP.MaxUploadBytes = *httpMaxUploadFlag
+	if err := agentConfig.HTTP.Validate(); err != nil {
+		log.Fatalf("[!] Invalid HTTP persona: %v", err)
+	}
+
+	// Configure MySQL persona
+	agentConfig.MySQL.SchemaDir = *mysqlSchemasFlag
+	agentConfig.MySQL.SessionQuota = *mysqlQuotaFlag
+
+	// Configure egress DLP
+	agentConfig.DLP.Mode = config.DLPMode(strings.ToLower(*dlpModeFlag))
+	if *dlpRulesFlag != "" {
+		f, err := os.Open(*dlpRulesFlag)
+		if err != nil {
+			log.Fatalf("[!] Failed to open DLP rules: %v", err)
+		}
+		rules, err := config.ParseDLPRules(f)
+		f.Close()
+		if err != nil {
+			log.Fatalf("[!] Invalid DLP rules in %s: %v", *dlpRulesFlag, err)
+		}
+		agentConfig.DLP.Rules = rules
+	}
+	dlpPorts, err := parsePortList(*dlpPortsFlag)
+	if err != nil {
+		log.Fatalf("[!] Invalid -dlp-ports: %v", err)
+	}
+	agentConfig.DLP.Ports = dlpPorts
+	agentConfig.DLP.Cgroups = splitList(*dlpCgroupsFlag)
+	if err := agentConfig.DLP.Validate(); err != nil {
+		log.Fatalf("[!] Invalid DLP settings: %v", err)
+	}
+
+	// Configure per-source rate limits
+	rateFlags := []struct {
+		name  string
+		value string
+		limit *config.RateLimit
+	}{
+		{"rate-spa", *rateSPAFlag, &agentConfig.RateLimits.SPA},
+		{"rate-syn", *rateSYNFlag, &agentConfig.RateLimits.SYN},
+		{"rate-total", *rateTotalFlag, &agentConfig.RateLimits.Total},
+	}
+	for _, rf := range rateFlags {
+		limit, err := parseRateLimit(rf.value)
+		if err != nil {
+			log.Fatalf("[!] Invalid -%s: %v", rf.name, err)
+		}
+		*rf.limit = limit
+	}
+
+	// Configure automatic bans
+	agentConfig.BanPolicy.Enabled = *autobanFlag
+	agentConfig.BanPolicy.TrapHitThreshold = *banTrapHitsFlag
+	agentConfig.BanPolicy.FailedKnockThreshold = *banFailedKnocksFlag
+	agentConfig.BanPolicy.WindowSeconds = *banWindowFlag
+	agentConfig.BanPolicy.BanDurationSeconds = *banTTLFlag
+	if *banExemptFlag != "" {
+		for _, ip := range strings.Split(*banExemptFlag, ",") {
+			ip = strings.TrimSpace(ip)
+			if net.ParseIP(ip) == nil {
+				log.Fatalf("[!] Invalid -ban-exempt address: %s", ip)
+			}
+			agentConfig.BanPolicy.ExemptIPs = append(agentConfig.BanPolicy.ExemptIPs, ip)
+		}
+	}
+
+	// Configure CIDR lists
+	agentConfig.CIDRLists.AllowSources = splitList(*allowListFlag)
+	agentConfig.CIDRLists.DenySources = splitList(*denyListFlag)
+	agentConfig.CIDRLists.MirrorDir = *listMirrorDirFlag
+	agentConfig.CIDRLists.RefreshSeconds = *listRefreshFlag
+
+	// Configure persistence
+	agentConfig.Persistence.Enabled = *pinFlag
+	agentConfig.Persistence.KeepOnExit = *keepOnExitFlag
+
 	// Create and start agent
-	agentInstance, err := agent.New(*interfaceFlag, outputMode, elkConfig, dashboardChan, spaConfig, staticToken)
+	agentInstance, err := agent.New(*interfaceFlag, outputMode, elkConfig, dashboardChan, spaConfig, staticToken, agentConfig)
 	if err != nil {
 		log.Fatalf("[!] Failed to initialize agent: %v", err)
 	}
 	defer agentInstance.Close()
 
+	// SIGINT and SIGTERM stop the agent and detach XDP. SIGUSR2 is the
+	// restart signal: XDP stays attached with the pinned state for the next
+	// agent to take over.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGUSR2)
+	go func() {
+		sig := <-signals
+		if sig == syscall.SIGUSR2 {
+			log.Printf("[SYSTEM] Restart requested: leaving XDP attached for the next agent")
+			agentInstance.HandOver()
+		}
+		if err := agentInstance.Close(); err != nil {
+			log.Printf("[!] Failed to close agent: %v", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}()
+
 	// Start agent services
 	if err := agentInstance.Start(); err != nil {
 		log.Fatalf("[!] Failed to start agent: %v", err)
@@ -196,6 +525,8 @@ func main() {
 			egressObjs,
 			dashboardChan,
 		)
+		dashboardInstance.SetTarpitSource(agentInstance.TarpitStats)
+		dashboardInstance.SetDLPSource(agentInstance.DLPStats)
 		dashboardInstance.Start()
 	} else {
 		// ELK-only mode: wait for interrupt
@@ -204,3 +535,79 @@ func main() {
 		select {} // Block forever
 	}
 }
+
+// parsePortList parses a comma-separated list of ports
+func parsePortList(value string) ([]int, error) {
+	var ports []int
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		port, err := strconv.Atoi(field)
+		if err != nil || port < 1 || port > 65535 {
+			return nil, fmt.Errorf("invalid port: %s", field)
+		}
+		ports = append(ports, port)
+	}
+	return ports, nil
+}
+
+// formatPortList formats ports as a comma-separated flag value
+func formatPortList(ports []int) string {
+	fields := make([]string, len(ports))
+	for i, port := range ports {
+		fields[i] = strconv.Itoa(port)
+	}
+	return strings.Join(fields, ",")
+}
+
+// parseRateLimit parses a rate limit in the form 'pps' or 'pps/burst'
+func parseRateLimit(value string) (config.RateLimit, error) {
+	var limit config.RateLimit
+	ppsField, burstField, hasBurst := strings.Cut(strings.TrimSpace(value), "/")
+
+	pps, err := strconv.ParseUint(strings.TrimSpace(ppsField), 10, 32)
+	if err != nil {
+		return limit, fmt.Errorf("invalid rate: %s", ppsField)
+	}
+	limit.PacketsPerSecond = uint32(pps)
+	limit.Burst = uint32(pps)
+
+	if hasBurst {
+		burst, err := strconv.ParseUint(strings.TrimSpace(burstField), 10, 32)
+		if err != nil || (burst == 0 && pps != 0) {
+			return limit, fmt.Errorf("invalid burst: %s", burstField)
+		}
+		limit.Burst = uint32(burst)
+	}
+	return limit, nil
+}
+
+// formatRateLimit formats a rate limit as accepted by parseRateLimit
+func formatRateLimit(limit config.RateLimit) string {
+	if limit.PacketsPerSecond == 0 {
+		return "0"
+	}
+	return fmt.Sprintf("%d/%d", limit.PacketsPerSecond, limit.Burst)
+}
+
+// splitList splits a comma-separated flag value, dropping empty fields
+func splitList(value string) []string {
+	var items []string
+	for _, field := range strings.Split(value, ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			items = append(items, field)
+		}
+	}
+	return items
+}
+
+// osProfileNames returns the names of the built-in OS profiles
+func osProfileNames() []string {
+	names := make([]string, len(config.OSProfiles))
+	for i, p := range config.OSProfiles {
+		names[i] = p.Name
+	}
+	return names
+}
@@ -26,20 +26,6 @@ const ebpfHeaderTemplate = `// AUTO-GENERATED FILE - DO NOT EDIT MANUALLY
 #define SPA_TOKEN_LEN {{.Constants.SPATokenLen}}
 #define SPA_WHITELIST_DURATION_NS ({{.Constants.SPAWhitelistDuration}}ULL * 1000000000ULL) // {{.Constants.SPAWhitelistDuration}} seconds in nanoseconds
 
-// OS Fingerprint Values (TTL)
-#define TTL_WINDOWS {{.Constants.TTLWindows}}
-#define TTL_LINUX {{.Constants.TTLLinux}}
-#define TTL_FREEBSD {{.Constants.TTLFreeBSD}}
-#define TTL_SOLARIS {{.Constants.TTLSolaris}}
-
-// OS Fingerprint Values (Window Size)
-#define WINDOW_WINDOWS {{.Constants.WindowWindows}}
-#define WINDOW_LINUX {{.Constants.WindowLinux}}
-#define WINDOW_FREEBSD {{.Constants.WindowFreeBSD}}
-
-// Egress DLP Configuration
-#define MAX_PAYLOAD_SCAN {{.Constants.MaxPayloadScan}}
-
 // Critical asset ports protected by Phantom Protocol (default: DROP all traffic)
 // Generated from CriticalPortDefinitions in internal/config/ports.go
 {{range .CriticalPorts}}
@@ -51,6 +37,18 @@ const ebpfHeaderTemplate = `// AUTO-GENERATED FILE - DO NOT EDIT MANUALLY
 {{range .FakePorts}}
 #define {{.Alias}} {{.Port}}  // {{.Name}} - {{.Description}}
 {{end}}
+
+// UDP services protected by Phantom Protocol (default: DROP unless whitelisted)
+// Generated from CriticalUDPPortDefinitions in internal/config/ports.go
+{{range .CriticalUDPPorts}}
+#define {{.Alias}} {{.Port}}  // {{.Name}} - {{.Description}}
+{{end}}
+
+// UDP services emulated by the honeypot
+// Generated from FakeUDPPortDefinitions in internal/config/ports.go
+{{range .FakeUDPPorts}}
+#define {{.Alias}} {{.Port}}  // {{.Name}} - {{.Description}}
+{{end}}
 `
 
 const ebpfFunctionTemplate = `// AUTO-GENERATED FUNCTION - DO NOT EDIT MANUALLY
@@ -79,6 +77,26 @@ static __always_inline int is_fake_port(__be16 port) {
     
     return 0;
 }
+
+static __always_inline int is_critical_udp_port(__be16 port) {
+    __u16 p = bpf_ntohs(port);
+    
+{{range .CriticalUDPPorts}}
+    if (p == {{.Alias}}) return 1;
+{{end}}
+    
+    return 0;
+}
+
+static __always_inline int is_fake_udp_port(__be16 port) {
+    __u16 p = bpf_ntohs(port);
+    
+{{range .FakeUDPPorts}}
+    if (p == {{.Alias}}) return 1;
+{{end}}
+    
+    return 0;
+}
 `
 
 type CategoryGroup struct {
@@ -150,21 +168,44 @@ func main() {
 		return fakePorts[i].Port < fakePorts[j].Port
 	})
 
+	// Sort UDP ports
+	udpPorts := udpPortLists{
+		Critical: sortedPorts(config.CriticalUDPPortDefinitions),
+		Fake:     sortedPorts(config.FakeUDPPortDefinitions),
+	}
+
 	// Get eBPF constants
 	constants := config.GetEBPFConstants()
 
 	// Generate eBPF header defines
-	generateEBPFHeader(categories, fakePorts, constants)
+	generateEBPFHeader(categories, fakePorts, udpPorts, constants)
 
 	// Generate eBPF function
-	generateEBPFFunction(categories, fakePorts)
+	generateEBPFFunction(categories, fakePorts, udpPorts)
 
 	fmt.Println("Configuration generation complete!")
 	fmt.Printf("Generated %d critical port definitions\n", len(config.CriticalPortDefinitions))
 	fmt.Printf("Generated %d fake port definitions\n", len(config.FakePortDefinitions))
+	fmt.Printf("Generated %d critical UDP and %d fake UDP port definitions\n",
+		len(config.CriticalUDPPortDefinitions), len(config.FakeUDPPortDefinitions))
+}
+
+// udpPortLists holds the sorted UDP port definitions for the templates
+type udpPortLists struct {
+	Critical []config.PortDefinition
+	Fake     []config.PortDefinition
+}
+
+func sortedPorts(defs []config.PortDefinition) []config.PortDefinition {
+	sorted := make([]config.PortDefinition, len(defs))
+	copy(sorted, defs)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Port < sorted[j].Port
+	})
+	return sorted
 }
 
-func generateEBPFHeader(categories []CategoryGroup, fakePorts []config.PortDefinition, constants config.EBFPConstants) {
+func generateEBPFHeader(categories []CategoryGroup, fakePorts []config.PortDefinition, udpPorts udpPortLists, constants config.EBFPConstants) {
 	tmpl, err := template.New("ebpfHeader").Parse(ebpfHeaderTemplate)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error parsing template: %v\n", err)
@@ -189,13 +230,17 @@ func generateEBPFHeader(categories []CategoryGroup, fakePorts []config.PortDefin
 	})
 
 	data := struct {
-		Constants     config.EBFPConstants
-		CriticalPorts []config.PortDefinition
-		FakePorts     []config.PortDefinition
+		Constants        config.EBFPConstants
+		CriticalPorts    []config.PortDefinition
+		FakePorts        []config.PortDefinition
+		CriticalUDPPorts []config.PortDefinition
+		FakeUDPPorts     []config.PortDefinition
 	}{
-		Constants:     constants,
-		CriticalPorts: allCriticalPorts,
-		FakePorts:     fakePorts,
+		Constants:        constants,
+		CriticalPorts:    allCriticalPorts,
+		FakePorts:        fakePorts,
+		CriticalUDPPorts: udpPorts.Critical,
+		FakeUDPPorts:     udpPorts.Fake,
 	}
 
 	if err := tmpl.Execute(file, data); err != nil {
@@ -206,7 +251,7 @@ func generateEBPFHeader(categories []CategoryGroup, fakePorts []config.PortDefin
 	fmt.Printf("Generated: %s\n", outputPath)
 }
 
-func generateEBPFFunction(categories []CategoryGroup, fakePorts []config.PortDefinition) {
+func generateEBPFFunction(categories []CategoryGroup, fakePorts []config.PortDefinition, udpPorts udpPortLists) {
 	tmpl, err := template.New("ebpfFunction").Parse(ebpfFunctionTemplate)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error parsing template: %v\n", err)
@@ -222,11 +267,15 @@ func generateEBPFFunction(categories []CategoryGroup, fakePorts []config.PortDef
 	defer file.Close()
 
 	data := struct {
-		Categories []CategoryGroup
-		FakePorts  []config.PortDefinition
+		Categories       []CategoryGroup
+		FakePorts        []config.PortDefinition
+		CriticalUDPPorts []config.PortDefinition
+		FakeUDPPorts     []config.PortDefinition
 	}{
-		Categories: categories,
-		FakePorts:  fakePorts,
+		Categories:       categories,
+		FakePorts:        fakePorts,
+		CriticalUDPPorts: udpPorts.Critical,
+		FakeUDPPorts:     udpPorts.Fake,
 	}
 
 	if err := tmpl.Execute(file, data); err != nil {
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+	"unicode"
+
+	"phantom-grid/internal/blocklist"
+	"phantom-grid/internal/config"
+	"phantom-grid/internal/honeypot/fsimage"
+	"phantom-grid/internal/honeypot/recording"
+)
+
+// runCommand runs a non-interactive subcommand and returns the exit code
+func runCommand(args []string) int {
+	switch args[0] {
+	case "ban":
+		return runBan(args[1:])
+	case "unban":
+		return runUnban(args[1:])
+	case "bans":
+		return runBans(args[1:])
+	case "detach":
+		return runDetach(args[1:])
+	case "image":
+		return runImage(args[1:])
+	case "sessions":
+		return runSessions(args[1:])
+	case "replay":
+		return runReplay(args[1:])
+	case "help", "-h", "--help":
+		printCommandUsage()
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command: %s\n\n", args[0])
+		printCommandUsage()
+		return 2
+	}
+}
+
+func printCommandUsage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [command]\n\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "Without a command, the interactive menu is started.\n\n")
+	fmt.Fprintf(os.Stderr, "Commands:\n")
+	fmt.Fprintf(os.Stderr, "  ban [-ttl 1h] <ip>   Block all traffic from an IP (ttl 0 = permanent)\n")
+	fmt.Fprintf(os.Stderr, "  unban <ip>           Remove an IP from the blocklist\n")
+	fmt.Fprintf(os.Stderr, "  bans                 List banned IPs\n")
+	fmt.Fprintf(os.Stderr, "  detach               Remove the XDP program and state a stopped agent left pinned\n")
+	fmt.Fprintf(os.Stderr, "  image [-o file] [path...]\n")
+	fmt.Fprintf(os.Stderr, "                       Make a sanitized shell file system image of this host (-fs-image)\n")
+	fmt.Fprintf(os.Stderr, "  sessions [-ip IP] [-service S] [text]\n")
+	fmt.Fprintf(os.Stderr, "                       List recorded shell sessions, or those with lines containing text\n")
+	fmt.Fprintf(os.Stderr, "  replay [-speed 1] [-idle 2s] <session>\n")
+	fmt.Fprintf(os.Stderr, "                       Replay a recorded shell session in this terminal\n")
+	fmt.Fprintf(os.Stderr, "\nCommands require root. Blocklist commands need an agent running or its state pinned.\n")
+}
+
+func runBan(args []string) int {
+	fs := flag.NewFlagSet("ban", flag.ContinueOnError)
+	ttl := fs.Duration("ttl", time.Duration(config.DefaultBanPolicyConfig().BanDurationSeconds)*time.Second, "Ban duration (0 = permanent)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	ip, ok := parseIPArg(fs.Args(), "ban")
+	if !ok {
+		return 2
+	}
+
+	bl, err := blocklist.OpenPinned(config.BlocklistPinPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, menuColorRed+"[!] "+err.Error()+menuColorReset)
+		return 1
+	}
+	defer bl.Close()
+
+	if err := bl.Ban(ip, *ttl, blocklist.ReasonManual); err != nil {
+		fmt.Fprintln(os.Stderr, menuColorRed+"[!] "+err.Error()+menuColorReset)
+		return 1
+	}
+
+	if *ttl > 0 {
+		fmt.Println(menuColorGreen + fmt.Sprintf("[+] Banned %s for %s", ip, *ttl) + menuColorReset)
+	} else {
+		fmt.Println(menuColorGreen + fmt.Sprintf("[+] Banned %s permanently", ip) + menuColorReset)
+	}
+	return 0
+}
+
+func runUnban(args []string) int {
+	ip, ok := parseIPArg(args, "unban")
+	if !ok {
+		return 2
+	}
+
+	bl, err := blocklist.OpenPinned(config.BlocklistPinPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, menuColorRed+"[!] "+err.Error()+menuColorReset)
+		return 1
+	}
+	defer bl.Close()
+
+	if err := bl.Unban(ip); err != nil {
+		fmt.Fprintln(os.Stderr, menuColorRed+"[!] "+err.Error()+menuColorReset)
+		return 1
+	}
+	fmt.Println(menuColorGreen + fmt.Sprintf("[+] Unbanned %s", ip) + menuColorReset)
+	return 0
+}
+
+func runBans(args []string) int {
+	bl, err := blocklist.OpenPinned(config.BlocklistPinPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, menuColorRed+"[!] "+err.Error()+menuColorReset)
+		return 1
+	}
+	defer bl.Close()
+
+	entries, err := bl.List()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, menuColorRed+"[!] "+err.Error()+menuColorReset)
+		return 1
+	}
+	if len(entries) == 0 {
+		fmt.Println(menuColorCyan + "[*] No banned IPs." + menuColorReset)
+		return 0
+	}
+
+	fmt.Printf(menuColorBold+"%-18s %-15s %-12s %s"+menuColorReset+"\n", "IP", "REASON", "EXPIRES IN", "DROPPED")
+	for _, e := range entries {
+		expires := "never"
+		if !e.Permanent {
+			expires = e.Remaining.Round(time.Second).String()
+		}
+		fmt.Printf("%-18s %-15s %-12s %d\n", e.IP, e.Reason, expires, e.Drops)
+	}
+	return 0
+}
+
+// runDetach removes the maps and links the agent keeps pinned between runs.
+// Unpinned programs are detached once no agent holds them.
+func runDetach(args []string) int {
+	if len(args) != 0 {
+		fmt.Fprintf(os.Stderr, "Usage: %s detach\n", os.Args[0])
+		return 2
+	}
+	if _, err := os.Stat(config.BPFPinDir); errors.Is(err, os.ErrNotExist) {
+		fmt.Println(menuColorCyan + "[*] Nothing pinned." + menuColorReset)
+		return 0
+	}
+	if err := os.RemoveAll(config.BPFPinDir); err != nil {
+		fmt.Fprintln(os.Stderr, menuColorRed+"[!] Failed to remove pinned state: "+err.Error()+menuColorReset)
+		return 1
+	}
+	fmt.Println(menuColorGreen + "[+] Removed pinned state in " + config.BPFPinDir + menuColorReset)
+	return 0
+}
+
+// runImage writes an image of this host for the fake shell's file system.
+// It is meant to run on a reference server the sensors should look like.
+func runImage(args []string) int {
+	fs := flag.NewFlagSet("image", flag.ContinueOnError)
+	output := fs.String("o", "image.tar.gz", "Image file to write")
+	root := fs.String("root", "/", "Where the file system to copy is mounted")
+	hostname := fs.String("hostname", "server", "Host name that replaces this host's in the copied files (empty = keep it)")
+	maxSize := fs.Int64("max-file-size", 1<<20, "Files larger than this many bytes are left out (0 = no limit)")
+	exclude := fs.String("exclude", "", "Comma-separated extra patterns of files to leave out; patterns without a '/' match file names, those without a leading '/' the end of paths")
+	verbose := fs.Bool("v", false, "List the files left out")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s image [flags] [path...]\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Copies the given trees (default %s) into an image for -fs-image.\n", strings.Join(fsimage.DefaultPaths, " "))
+		fmt.Fprintf(os.Stderr, "Keys, shell histories and credentials files are left out and password hashes replaced.\n\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	opts := fsimage.GenerateOptions{
+		Root:        *root,
+		Paths:       fs.Args(),
+		Exclude:     fsimage.DefaultExclude,
+		MaxFileSize: *maxSize,
+		Hostname:    *hostname,
+	}
+	if len(opts.Paths) == 0 {
+		opts.Paths = fsimage.DefaultPaths
+	}
+	if *exclude != "" {
+		opts.Exclude = append(opts.Exclude, strings.Split(*exclude, ",")...)
+	}
+	skipped := 0
+	opts.Skipped = func(p, reason string) {
+		skipped++
+		if *verbose {
+			fmt.Println(menuColorYellow + fmt.Sprintf("[*] Left out %s: %s", p, reason) + menuColorReset)
+		}
+	}
+
+	f, err := os.Create(*output)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, menuColorRed+"[!] "+err.Error()+menuColorReset)
+		return 1
+	}
+	err = fsimage.Generate(f, opts)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(*output)
+		fmt.Fprintln(os.Stderr, menuColorRed+"[!] Failed to write image: "+err.Error()+menuColorReset)
+		return 1
+	}
+	fmt.Println(menuColorGreen + fmt.Sprintf("[+] Wrote %s (%d files left out)", *output, skipped) + menuColorReset)
+	fmt.Println(menuColorCyan + "[*] Review the image before use: tar tzf " + *output + menuColorReset)
+	return 0
+}
+
+// runSessions lists the recorded shell sessions. With a text, only sessions
+// in which it was typed or shown are listed, with the lines that contain it.
+func runSessions(args []string) int {
+	fs := flag.NewFlagSet("sessions", flag.ContinueOnError)
+	dir := fs.String("dir", config.DefaultRecordingConfig().Dir, "Directory the agent records sessions in (-session-dir)")
+	ip := fs.String("ip", "", "Only sessions from this IP")
+	service := fs.String("service", "", "Only sessions of this service (ssh or telnet)")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s sessions [flags] [text]\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return 2
+	}
+	text := fs.Arg(0)
+
+	infos, err := recording.List(*dir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, menuColorRed+"[!] "+err.Error()+menuColorReset)
+		return 1
+	}
+
+	shown := 0
+	for _, info := range infos {
+		s := info.Header.Session
+		if (*ip != "" && s.IP != *ip) || (*service != "" && !strings.EqualFold(s.Service, *service)) {
+			continue
+		}
+		var matches []recording.Match
+		if text != "" {
+			matches, err = recording.Search(info.Path, text)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, menuColorYellow+"[*] "+err.Error()+menuColorReset)
+			}
+			if len(matches) == 0 {
+				continue
+			}
+		}
+		if shown == 0 {
+			fmt.Printf(menuColorBold+"%-24s %-19s %-7s %-15s %-10s %s"+menuColorReset+"\n", "SESSION", "STARTED", "SERVICE", "SOURCE", "USER", "DURATION")
+		}
+		shown++
+		user := printable(s.User)
+		if user == "" {
+			user = "-"
+		}
+		fmt.Printf("%-24s %-19s %-7s %-15s %-10s %s\n", s.ID, info.Header.Start().Format("2006-01-02 15:04:05"), s.Service, s.IP, user, info.Duration.Round(time.Second))
+		for _, m := range matches {
+			// Typed lines are marked like a shell prompt
+			mark := " "
+			if m.Type == recording.Input {
+				mark = ">"
+			}
+			fmt.Printf(menuColorCyan+"    %8.1fs %s %s"+menuColorReset+"\n", m.Time, mark, m.Line)
+		}
+	}
+	if shown == 0 {
+		fmt.Println(menuColorCyan + "[*] No recorded sessions." + menuColorReset)
+	}
+	return 0
+}
+
+// runReplay plays a recorded shell session back with its timing. Recordings
+// are asciicast v2 files, so asciinema can play them too.
+func runReplay(args []string) int {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	dir := fs.String("dir", config.DefaultRecordingConfig().Dir, "Directory the agent records sessions in (-session-dir)")
+	speed := fs.Float64("speed", 1, "Playback speed (2 = twice as fast)")
+	idle := fs.Duration("idle", 2*time.Second, "Longer pauses are cut to this (0 = keep them)")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s replay [flags] <session ID or .cast file>\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 || *speed <= 0 {
+		fs.Usage()
+		return 2
+	}
+
+	p, err := recording.Find(*dir, fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, menuColorRed+"[!] "+err.Error()+menuColorReset)
+		return 1
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, menuColorRed+"[!] "+err.Error()+menuColorReset)
+		return 1
+	}
+	defer f.Close()
+	r, err := recording.NewReader(f)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, menuColorRed+"[!] "+err.Error()+menuColorReset)
+		return 1
+	}
+
+	s := r.Header.Session
+	fmt.Println(menuColorCyan + fmt.Sprintf("[*] %s session %s from %s, started %s", s.Service, s.ID, s.IP, r.Header.Start().Format("2006-01-02 15:04:05")) + menuColorReset)
+	if err := recording.Play(os.Stdout, r, recording.PlayOptions{Speed: *speed, IdleLimit: *idle}); err != nil {
+		fmt.Fprintln(os.Stderr, "\n"+menuColorRed+"[!] "+err.Error()+menuColorReset)
+		return 1
+	}
+	fmt.Println("\n" + menuColorCyan + "[*] End of session." + menuColorReset)
+	return 0
+}
+
+// parseIPArg expects exactly one IPv4 address argument
+// printable drops the control characters of attacker-chosen text, as Search
+// does, so it cannot move the cursor or recolor the terminal
+func printable(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+func parseIPArg(args []string, command string) (net.IP, bool) {
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: %s %s <ip>\n", os.Args[0], command)
+		return nil, false
+	}
+	ip := net.ParseIP(args[0])
+	if ip == nil || ip.To4() == nil {
+		fmt.Fprintln(os.Stderr, menuColorRed+"[!] Invalid IPv4 address: "+args[0]+menuColorReset)
+		return nil, false
+	}
+	return ip, true
+}
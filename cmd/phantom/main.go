@@ -36,6 +36,10 @@ func init() {
 }
 
 func main() {
+	// Non-interactive subcommands (e.g. 'phantom ban 1.2.3.4')
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:]))
+	}
 
 	clearScreen()
 	showBanner()
@@ -766,6 +770,9 @@ func viewProtectedPorts() {
 			fmt.Printf("  Port %d: %s\n", port, getPortName(port))
 		}
 	}
+	for _, def := range config.CriticalUDPPortDefinitions {
+		fmt.Printf("  Port %d/udp: %s\n", def.Port, def.Name)
+	}
 
 	pause()
 }
@@ -782,6 +789,9 @@ func viewFakePorts() {
 			fmt.Printf("  Port %d: %s\n", port, getPortName(port))
 		}
 	}
+	for _, def := range config.FakeUDPPortDefinitions {
+		fmt.Printf("  Port %d/udp: %s\n", def.Port, def.Name)
+	}
 
 	pause()
 }
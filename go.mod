@@ -6,13 +6,14 @@ require (
 	github.com/cilium/ebpf v0.12.3
 	github.com/gizak/termui/v3 v3.1.0
 	github.com/vishvananda/netlink v1.3.1
-	golang.org/x/sys v0.14.1-0.20231108175955-e4099bfacb8c
+	github.com/vishvananda/netns v0.0.5
+	golang.org/x/crypto v0.17.0
+	golang.org/x/sys v0.15.0
 )
 
 require (
 	github.com/mattn/go-runewidth v0.0.2 // indirect
 	github.com/mitchellh/go-wordwrap v0.0.0-20150314170334-ad45545899c7 // indirect
 	github.com/nsf/termbox-go v0.0.0-20190121233118-02980233997d // indirect
-	github.com/vishvananda/netns v0.0.5 // indirect
 	golang.org/x/exp v0.0.0-20230224173230-c95f2b4c22f2 // indirect
 )
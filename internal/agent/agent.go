@@ -1,14 +1,20 @@
 package agent
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"net"
 	"os"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/vishvananda/netlink"
 	"golang.org/x/sys/unix"
 
+	"phantom-grid/internal/blocklist"
+	"phantom-grid/internal/cidrlist"
 	"phantom-grid/internal/config"
 	"phantom-grid/internal/ebpf"
 	"phantom-grid/internal/honeypot"
@@ -17,6 +23,24 @@ import (
 	"phantom-grid/internal/spa"
 )
 
+// rateLimitReportInterval is how often newly throttled sources are logged
+const rateLimitReportInterval = 10 * time.Second
+
+// metricsExportInterval is how often kernel drop counters are exported
+const metricsExportInterval = 30 * time.Second
+
+// Read errors of the event stream are retried after a delay that doubles up
+// to eventStreamMaxBackoff; the stream stops after eventStreamMaxFailures in
+// a row
+const (
+	eventStreamBackoff     = 100 * time.Millisecond
+	eventStreamMaxBackoff  = 10 * time.Second
+	eventStreamMaxFailures = 20
+)
+
+// tarpitSweepInterval is how often idle XDP tarpit flows are expired
+const tarpitSweepInterval = 10 * time.Second
+
 // Agent represents the main Phantom Grid agent
 type Agent struct {
 	ebpfLoader  *ebpf.Loader
@@ -29,20 +53,48 @@ type Agent struct {
 	spaConfig   *config.DynamicSPAConfig
 	spaHandler  *spa.Handler
 	staticToken string // Static token for legacy SPA mode
+	agentConfig config.AgentConfiguration
+	blocklist   *blocklist.Blocklist
+	banEngine   *blocklist.Engine
+	eventReader *ebpf.EventReader
+	stopChan    chan struct{}
+	tarpitMutex sync.RWMutex
+	tarpitStats ebpf.TarpitStats
+	pinned      bool // eBPF maps and links are pinned under config.BPFPinDir
+	handOver    bool // Keep the pinned state on exit for the next agent
+	closeOnce   sync.Once
+	closeErr    error
 }
 
 // New creates a new Agent instance
-func New(interfaceName string, outputMode config.OutputMode, elkConfig config.ELKConfiguration, dashboardChan chan<- string, spaConfig *config.DynamicSPAConfig, staticToken string) (*Agent, error) {
+func New(interfaceName string, outputMode config.OutputMode, elkConfig config.ELKConfiguration, dashboardChan chan<- string, spaConfig *config.DynamicSPAConfig, staticToken string, agentConfig config.AgentConfiguration) (*Agent, error) {
 	// Detect network interface
 	iface, ifaceName, err := network.DetectInterface(interfaceName)
 	if err != nil {
 		return nil, fmt.Errorf("failed to detect interface: %w", err)
 	}
 
-	// Initialize eBPF loader
-	ebpfLoader, err := ebpf.NewLoader()
-	if err != nil {
-		return nil, fmt.Errorf("failed to initialize eBPF loader: %w", err)
+	// Initialize eBPF loader, reusing the state of a previous agent if pinned
+	var ebpfLoader *ebpf.Loader
+	pinned := agentConfig.Persistence.Enabled
+	if pinned {
+		ebpfLoader, err = ebpf.NewPinnedLoader(config.BPFPinDir)
+		if err != nil {
+			log.Printf("[!] Warning: Failed to load pinned eBPF state from %s: %v", config.BPFPinDir, err)
+			log.Printf("[!] Removing it; state will not survive restarts.")
+			pinned = false
+		}
+	}
+	if !pinned {
+		// Links pinned by a previous agent would keep its XDP program
+		// attached, and its pins would collide with the new ones
+		if err := os.RemoveAll(config.BPFPinDir); err != nil {
+			return nil, fmt.Errorf("failed to remove pinned eBPF state in %s (run 'phantom detach'): %w", config.BPFPinDir, err)
+		}
+		ebpfLoader, err = ebpf.NewLoader()
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize eBPF loader: %w", err)
+		}
 	}
 
 	// Load egress program (optional)
@@ -65,6 +117,9 @@ func New(interfaceName string, outputMode config.OutputMode, elkConfig config.EL
 		logManager:  logManager,
 		spaConfig:   spaConfig,
 		staticToken: staticToken,
+		agentConfig: agentConfig,
+		stopChan:    make(chan struct{}),
+		pinned:      pinned,
 	}
 
 	return agent, nil
@@ -81,16 +136,108 @@ func (a *Agent) Start() error {
 
 	log.Printf("[*] XDP attached to interface: %s (index: %d)", a.ifaceName, a.iface.Index)
 	a.logChan <- fmt.Sprintf("[SYSTEM] XDP attached to interface: %s (index: %d)", a.ifaceName, a.iface.Index)
+	if a.ebpfLoader.TookOver() {
+		a.logChan <- "[SYSTEM] Upgraded the XDP program of the previous agent in place (whitelists and counters kept)"
+	}
+	if replaced := a.ebpfLoader.ReplacedMaps(); len(replaced) > 0 {
+		a.logChan <- fmt.Sprintf("[SYSTEM] Pinned maps reset after a layout change: %s", strings.Join(replaced, ", "))
+	}
+
+	// Select how unprotected ports reach the honeypot
+	if err := a.ebpfLoader.SetSteeringMode(a.agentConfig.SteeringMode); err != nil {
+		return fmt.Errorf("failed to configure steering: %w", err)
+	}
+	if a.agentConfig.SteeringMode == config.SteeringModeSkLookup {
+		if err := a.ebpfLoader.SetPassthroughPorts(a.agentConfig.PassthroughPorts); err != nil {
+			return fmt.Errorf("failed to configure sk_lookup passthrough ports: %w", err)
+		}
+		if _, err := a.ebpfLoader.AttachSkLookup(); err != nil {
+			return fmt.Errorf("failed to attach sk_lookup steering: %w", err)
+		}
+		a.logChan <- "[SYSTEM] sk_lookup steering attached (no packet rewriting)"
+	} else if err := a.ebpfLoader.RemovePinnedSkLookup(); err != nil {
+		return fmt.Errorf("failed to remove sk_lookup steering of the previous agent: %w", err)
+	}
+
+	if err := a.ebpfLoader.SetFragmentPolicy(a.agentConfig.FragmentPolicy); err != nil {
+		return fmt.Errorf("failed to configure fragment policy: %w", err)
+	}
+
+	if err := a.ebpfLoader.SetStealthResponses(a.agentConfig.StealthResponses); err != nil {
+		return fmt.Errorf("failed to configure stealth scan responses: %w", err)
+	}
+	stealth := a.agentConfig.StealthResponses
+	a.logChan <- fmt.Sprintf("[SYSTEM] Stealth scan responses: Xmas %s, Null %s, FIN %s, ACK %s",
+		stealth.Xmas, stealth.Null, stealth.FIN, stealth.ACK)
+
+	// Per-source rate limiting and SYN-flood protection
+	if err := a.ebpfLoader.SetRateLimits(a.agentConfig.RateLimits); err != nil {
+		return fmt.Errorf("failed to configure rate limits: %w", err)
+	}
+	limits := a.agentConfig.RateLimits
+	a.logChan <- fmt.Sprintf("[SYSTEM] Rate limits per source (pps/burst): SPA %d/%d, SYN %d/%d, total %d/%d",
+		limits.SPA.PacketsPerSecond, limits.SPA.Burst,
+		limits.SYN.PacketsPerSecond, limits.SYN.Burst,
+		limits.Total.PacketsPerSecond, limits.Total.Burst)
+	go a.monitorRateLimits()
+	go a.exportDropMetrics()
+
+	// Tarpit for scanners on unprotected ports
+	if a.agentConfig.Tarpit.Enabled {
+		if err := a.configureTarpit(); err != nil {
+			return fmt.Errorf("failed to configure tarpit: %w", err)
+		}
+	}
+
+	// Per-flow kernel events (drop reasons with real source addresses)
+	if err := a.startEventStream(); err != nil {
+		log.Printf("[!] Warning: %v", err)
+		a.logChan <- fmt.Sprintf("[!] Warning: Kernel event stream disabled: %v", err)
+	}
 
 	// Attach TC Egress (if loaded)
+	egressAttached := false
 	if a.ebpfLoader.EgressObjs != nil {
 		if err := a.attachTCEgress(); err != nil {
 			log.Printf("[!] Warning: Failed to attach TC egress: %v", err)
 			log.Printf("[!] TC Egress DLP will be disabled. Main XDP protection still active.")
 		} else {
 			a.logChan <- "[SYSTEM] TC Egress Hook attached (DLP Active)"
+			a.configureOSPersonality()
+			egressAttached = true
+		}
+		// cgroup DLP does not depend on the TC hook
+		a.configureDLP()
+	}
+	// The egress hook restores the original port on honeypot replies
+	if !egressAttached && a.agentConfig.SteeringMode == config.SteeringModeRedirect {
+		log.Printf("[!] Warning: Redirected connections cannot complete without the TC egress hook (use -steering sklookup)")
+		a.logChan <- "[!] Warning: Redirected connections cannot complete without the TC egress hook (use -steering sklookup)"
+	}
+
+	// CIDR allowlists and denylists
+	if err := a.initCIDRLists(); err != nil {
+		log.Printf("[!] Warning: %v", err)
+		a.logChan <- fmt.Sprintf("[!] Warning: %v", err)
+	}
+
+	// Dynamic blocklist and automatic ban policy
+	a.blocklist = blocklist.New(a.ebpfLoader.PhantomObjs.Blocklist)
+	// A pinned loader already pinned it at config.BlocklistPinPath
+	if !a.pinned {
+		if err := a.blocklist.Pin(config.BlocklistPinPath); err != nil {
+			log.Printf("[!] Warning: %v", err)
+			a.logChan <- "[!] Warning: Blocklist not pinned - 'phantom ban/unban' will not reach this agent"
 		}
 	}
+	a.banEngine = blocklist.NewEngine(a.blocklist, a.agentConfig.BanPolicy, a.logChan)
+	a.banEngine.SetExemptCheck(a.isSPAWhitelisted)
+	logger.SetAttackHook(a.banEngine.Observe)
+	if a.agentConfig.BanPolicy.Enabled {
+		policy := a.agentConfig.BanPolicy
+		a.logChan <- fmt.Sprintf("[SYSTEM] Auto-ban active: %d trap hits or %d failed knocks within %ds, %d command patterns",
+			policy.TrapHitThreshold, policy.FailedKnockThreshold, policy.WindowSeconds, len(policy.BanCommands))
+	}
 
 	// Start SPA Manager
 	spaWrapper := spa.NewWrapper(
@@ -140,27 +287,257 @@ func (a *Agent) Start() error {
 		a.logChan <- "[!] WARNING: Check if interface detection is working correctly"
 	}
 
-	// Start Honeypot
+	// Start Honeypot (binding is synchronous, accept loops run in background)
 	a.honeypot = honeypot.New(a.logChan)
-	honeypotErrChan := make(chan error, 1)
+	a.honeypot.SetPortResolver(ebpf.NewRedirectTable(a.ebpfLoader.PhantomObjs.RedirectMap))
+	a.honeypot.SetSteeringMode(a.agentConfig.SteeringMode)
+	a.honeypot.SetSSH(a.agentConfig.SSH)
+	a.honeypot.SetLogin(a.agentConfig.Login)
+	a.honeypot.SetFileSystem(a.agentConfig.FileSystem)
+	a.honeypot.SetPayloads(a.agentConfig.Payload)
+	a.honeypot.SetRecording(a.agentConfig.Recording)
+	a.honeypot.SetHTTP(a.agentConfig.HTTP)
+	a.honeypot.SetMySQL(a.agentConfig.MySQL)
+	// Captured requests and queries are too large for the dashboard log
+	a.honeypot.SetEventLogger(a.logManager.ExportEvent)
+	if a.agentConfig.Tarpit.Enabled {
+		a.honeypot.SetTarpit(a.agentConfig.Tarpit)
+	}
+	if err := a.honeypot.Start(); err != nil {
+		log.Printf("[!] Failed to start honeypot: %v", err)
+		return fmt.Errorf("honeypot failed to start: %w", err)
+	}
+
+	// Hand the fallback listener to sk_lookup
+	if a.agentConfig.SteeringMode == config.SteeringModeSkLookup {
+		if err := a.ebpfLoader.RegisterHoneypotSocket(a.honeypot.FallbackListener()); err != nil {
+			return fmt.Errorf("failed to register honeypot socket for sk_lookup: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// monitorRateLimits periodically reports sources whose packets were dropped by the XDP rate limiter
+func (a *Agent) monitorRateLimits() {
+	ticker := time.NewTicker(rateLimitReportInterval)
+	defer ticker.Stop()
+
+	reported := make(map[string]uint64)
+	for {
+		select {
+		case <-a.stopChan:
+			return
+		case <-ticker.C:
+		}
+
+		stats, err := ebpf.RateLimitStats(a.ebpfLoader.PhantomObjs.RateLimits)
+		if err != nil {
+			return
+		}
+		present := make(map[string]bool, len(stats))
+		for _, s := range stats {
+			dropped := s.Dropped()
+			if dropped == 0 {
+				break
+			}
+			ip := s.IP.String()
+			present[ip] = true
+			if dropped < reported[ip] {
+				reported[ip] = 0 // Entry was evicted from the LRU and counting restarted
+			}
+			if dropped <= reported[ip] {
+				continue
+			}
+			a.logChan <- fmt.Sprintf("[RATE] Throttled %s: %d packets dropped (SPA: %d, SYN: %d, total: %d)",
+				ip, dropped-reported[ip], s.SPADrops, s.SYNDrops, s.TotalDrops)
+			logger.LogAttack(ip, "RATE_LIMITED")
+			reported[ip] = dropped
+		}
+		// Forget sources evicted from the map, or that stopped dropping
+		for ip := range reported {
+			if !present[ip] {
+				delete(reported, ip)
+			}
+		}
+	}
+}
+
+// exportDropMetrics periodically exports per-reason drop counters from the XDP program
+func (a *Agent) exportDropMetrics() {
+	ticker := time.NewTicker(metricsExportInterval)
+	defer ticker.Stop()
+
+	var last ebpf.DropStats
+	for {
+		select {
+		case <-a.stopChan:
+			return
+		case <-ticker.C:
+		}
+
+		stats, err := ebpf.ReadDropStats(a.ebpfLoader.PhantomObjs.DropReasons)
+		if err != nil {
+			continue
+		}
+
+		event := logger.NewSecurityEvent(logger.EventTypeMetrics,
+			fmt.Sprintf("XDP dropped %d packets (%d since last report)", stats.Total(), stats.Total()-last.Total()))
+		event.WithRiskLevel("INFO").
+			WithMetadata("drops", stats.Map()).
+			WithMetadata("drops_total", stats.Total()).
+			WithMetadata("interval_seconds", int(metricsExportInterval.Seconds()))
+		for name, v := range a.ebpfLoader.Counters() {
+			event.WithMetadata(name, v)
+		}
+		a.logManager.ExportEvent(event)
+		last = stats
+	}
+}
+
+// configureTarpit loads the XDP tarpit ports and starts expiring idle flows
+func (a *Agent) configureTarpit() error {
+	cfg := a.agentConfig.Tarpit
+	if err := a.ebpfLoader.ConfigureTarpit(cfg); err != nil {
+		return err
+	}
+
+	ranges := make([]string, len(cfg.Ranges))
+	for i, r := range cfg.Ranges {
+		ranges[i] = fmt.Sprintf("%d-%d/%s", r.From, r.To, r.Mode)
+	}
+	a.logChan <- fmt.Sprintf("[SYSTEM] Tarpit active on %s (%d per source, %ds idle timeout)",
+		strings.Join(ranges, ", "), cfg.MaxPerSource, cfg.FlowTimeoutSeconds)
+
+	go a.sweepTarpit()
+	return nil
+}
+
+// sweepTarpit periodically releases idle XDP tarpit flows
+func (a *Agent) sweepTarpit() {
+	ticker := time.NewTicker(tarpitSweepInterval)
+	defer ticker.Stop()
+
+	timeout := time.Duration(a.agentConfig.Tarpit.FlowTimeoutSeconds) * time.Second
+	for {
+		select {
+		case <-a.stopChan:
+			return
+		case <-ticker.C:
+		}
+
+		stats, err := a.ebpfLoader.SweepTarpit(timeout)
+		if err != nil {
+			log.Printf("[!] Warning: Tarpit sweep failed: %v", err)
+			continue
+		}
+		if stats.Expired > 0 {
+			a.logChan <- fmt.Sprintf("[TARPIT] Released %d idle flows, %d stalled from %d sources", stats.Expired, stats.Flows, stats.Sources)
+		}
+
+		a.tarpitMutex.Lock()
+		a.tarpitStats = stats
+		a.tarpitMutex.Unlock()
+	}
+}
+
+// TarpitStats returns the XDP tarpit counters from the last sweep and the
+// number of connections held by the honeypot
+func (a *Agent) TarpitStats() (ebpf.TarpitStats, int) {
+	a.tarpitMutex.RLock()
+	stats := a.tarpitStats
+	a.tarpitMutex.RUnlock()
+
+	held := 0
+	if a.honeypot != nil {
+		held = a.honeypot.TarpitConnections()
+	}
+	return stats, held
+}
+
+// startEventStream forwards structured events from the XDP program to the log manager
+func (a *Agent) startEventStream() error {
+	reader, err := a.ebpfLoader.NewEventReader()
+	if err != nil {
+		return err
+	}
+	a.eventReader = reader
+
 	go func() {
-		if err := a.honeypot.Start(); err != nil {
-			honeypotErrChan <- err
-			log.Printf("[!] Failed to start honeypot: %v", err)
+		failures := 0
+		for {
+			ev, err := reader.Read()
+			if err != nil {
+				if errors.Is(err, ebpf.ErrEventsClosed) {
+					return
+				}
+				// A reader that keeps failing would spin and flood the log
+				failures++
+				if failures >= eventStreamMaxFailures {
+					log.Printf("[!] Event stream stopped after %d read errors: %v", failures, err)
+					return
+				}
+				log.Printf("[!] Event stream read error: %v", err)
+				select {
+				case <-a.stopChan:
+					return
+				case <-time.After(min(eventStreamBackoff<<(failures-1), eventStreamMaxBackoff)):
+				}
+				continue
+			}
+			failures = 0
+			a.logManager.LogEvent(ev.SecurityEvent())
 		}
 	}()
+	return nil
+}
 
-	// Check if honeypot started successfully (non-blocking)
-	select {
-	case err := <-honeypotErrChan:
-		return fmt.Errorf("honeypot failed to start: %w", err)
-	default:
-		// Honeypot started successfully
+// initCIDRLists loads the CIDR allow/deny lists and keeps them refreshed
+func (a *Agent) initCIDRLists() error {
+	lists := a.agentConfig.CIDRLists
+	if len(lists.AllowSources) == 0 && len(lists.DenySources) == 0 {
+		return nil
 	}
 
+	objs := a.ebpfLoader.PhantomObjs
+	maps := cidrlist.NewMaps(objs.CidrAllowV4, objs.CidrAllowV6, objs.CidrDenyV4, objs.CidrDenyV6)
+	manager := cidrlist.NewManager(maps, lists, a.logChan)
+	go manager.Run(a.stopChan)
+
+	if err := manager.Load(); err != nil {
+		return fmt.Errorf("failed to load CIDR lists: %w", err)
+	}
 	return nil
 }
 
+// observeFailedKnock feeds failed SPA knocks to the ban policy engine
+func (a *Agent) observeFailedKnock(ip net.IP) {
+	if a.banEngine != nil {
+		a.banEngine.Observe(ip.String(), blocklist.FailedKnockEvent)
+	}
+}
+
+// isSPAWhitelisted reports whether ip is currently authorized via SPA
+func (a *Agent) isSPAWhitelisted(ip net.IP) bool {
+	ipv4 := ip.To4()
+	if ipv4 == nil {
+		return false
+	}
+	var key [4]byte
+	var expiry uint64
+	copy(key[:], ipv4)
+	if a.ebpfLoader.PhantomObjs.SpaWhitelist.Lookup(key, &expiry) != nil {
+		return false
+	}
+	// XDP removes expired entries only when the source sends again, so
+	// the expiry is checked against the clock of bpf_ktime_get_ns()
+	var now unix.Timespec
+	if err := unix.ClockGettime(unix.CLOCK_MONOTONIC, &now); err != nil {
+		return false
+	}
+	return uint64(now.Nano()) <= expiry
+}
+
 // initDynamicSPA initializes dynamic SPA handler and loads configuration
 func (a *Agent) initDynamicSPA() error {
 	// Create verifier
@@ -192,6 +569,7 @@ func (a *Agent) initDynamicSPA() error {
 
 	// Create and start handler (static token not needed for dynamic mode)
 	handler := spa.NewHandler(verifier, mapLoader, a.logChan, a.spaConfig, "")
+	handler.SetFailureHook(a.observeFailedKnock)
 	if err := handler.Start(); err != nil {
 		return fmt.Errorf("failed to start SPA handler: %w", err)
 	}
@@ -219,6 +597,7 @@ func (a *Agent) initStaticSPA() error {
 
 	// Create and start handler with static token
 	handler := spa.NewHandler(verifier, mapLoader, a.logChan, staticConfig, a.staticToken)
+	handler.SetFailureHook(a.observeFailedKnock)
 	if err := handler.Start(); err != nil {
 		return fmt.Errorf("failed to start static SPA handler: %w", err)
 	}
@@ -235,6 +614,65 @@ func (a *Agent) initStaticSPA() error {
 	return nil
 }
 
+// configureOSPersonality loads the OS profiles that honeypot replies are rewritten to
+func (a *Agent) configureOSPersonality() {
+	cfg := a.agentConfig.OSPersonality
+	profiles, err := a.ebpfLoader.ConfigureOSPersonality(cfg)
+	if err != nil {
+		log.Printf("[!] Warning: Failed to configure OS personality: %v", err)
+		a.logChan <- fmt.Sprintf("[!] Warning: OS personality disabled: %v", err)
+		return
+	}
+
+	switch cfg.Mode {
+	case config.OSPersonalityOff:
+		a.logChan <- "[SYSTEM] OS personality disabled - honeypot replies keep the host fingerprint"
+	case config.OSPersonalityFixed:
+		a.logChan <- fmt.Sprintf("[SYSTEM] OS personality: all sources see %s", profiles[0].Name)
+	default:
+		names := make([]string, len(profiles))
+		for i, p := range profiles {
+			names[i] = p.Name
+		}
+		a.logChan <- fmt.Sprintf("[SYSTEM] OS personality: one of [%s] per source, %ds session", strings.Join(names, ", "), cfg.SessionSeconds)
+	}
+}
+
+// configureDLP loads the rules and scope of the egress DLP program
+func (a *Agent) configureDLP() {
+	cfg := a.agentConfig.DLP
+	if err := a.ebpfLoader.ConfigureDLP(cfg); err != nil {
+		log.Printf("[!] Warning: Failed to configure egress DLP: %v", err)
+		a.logChan <- fmt.Sprintf("[!] Warning: Egress DLP disabled: %v", err)
+		return
+	}
+	if cfg.Mode == config.DLPModeOff {
+		a.logChan <- "[SYSTEM] Egress DLP disabled"
+		return
+	}
+
+	names := make([]string, len(cfg.Rules))
+	for i, r := range cfg.Rules {
+		names[i] = r.Name
+	}
+	a.logChan <- fmt.Sprintf("[SYSTEM] Egress DLP %s: rules [%s] on ports %v",
+		cfg.Mode, strings.Join(names, ", "), cfg.Ports)
+
+	if len(cfg.Cgroups) == 0 {
+		return
+	}
+	a.logChan <- fmt.Sprintf("[SYSTEM] Egress DLP attached to cgroups [%s]", strings.Join(cfg.Cgroups, ", "))
+	if err := a.ebpfLoader.AttachDLPOwnerProbe(); err != nil {
+		log.Printf("[!] Warning: %v", err)
+		a.logChan <- "[!] Warning: cgroup DLP events will not name the sending process"
+	}
+}
+
+// DLPStats returns the egress DLP rule hits and the number of blocked payloads
+func (a *Agent) DLPStats() ([]ebpf.DLPRuleHits, uint64, error) {
+	return a.ebpfLoader.DLPStats()
+}
+
 // attachTCEgress attaches TC egress program using netlink
 func (a *Agent) attachTCEgress() error {
 	_, err := netlink.LinkByIndex(a.iface.Index)
@@ -270,7 +708,9 @@ func (a *Agent) attachTCEgress() error {
 		DirectAction: true,
 	}
 
-	if err := netlink.FilterAdd(filter); err != nil {
+	// Replace rather than add, so a filter left by a previous agent is
+	// swapped for the new program in one step
+	if err := netlink.FilterReplace(filter); err != nil {
 		return fmt.Errorf("failed to add filter: %w", err)
 	}
 
@@ -294,8 +734,31 @@ func (a *Agent) GetInterfaceName() string {
 	return a.ifaceName
 }
 
-// Close cleans up agent resources
+// HandOver makes Close leave XDP attached and the state pinned, so the next
+// agent takes over without a gap, as on a restart or upgrade
+func (a *Agent) HandOver() {
+	a.handOver = true
+}
+
+// Close cleans up agent resources. It may be called more than once.
 func (a *Agent) Close() error {
+	a.closeOnce.Do(func() { a.closeErr = a.close() })
+	return a.closeErr
+}
+
+func (a *Agent) close() error {
+	close(a.stopChan)
+	if a.banEngine != nil {
+		logger.SetAttackHook(nil)
+	}
+	// Keep the pinned state running for the next agent
+	keep := a.pinned && (a.handOver || a.agentConfig.Persistence.KeepOnExit)
+	if a.blocklist != nil && !a.pinned {
+		a.blocklist.Unpin()
+	}
+	if a.eventReader != nil {
+		a.eventReader.Close()
+	}
 	if a.spaHandler != nil {
 		if err := a.spaHandler.Stop(); err != nil {
 			return err
@@ -312,6 +775,9 @@ func (a *Agent) Close() error {
 		}
 	}
 	if a.ebpfLoader != nil {
+		if keep {
+			return a.ebpfLoader.Release()
+		}
 		return a.ebpfLoader.Close()
 	}
 	return nil
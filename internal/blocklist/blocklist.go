@@ -0,0 +1,253 @@
+package blocklist
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"golang.org/x/sys/unix"
+)
+
+// Reason records why a source was banned (stored in the kernel map)
+type Reason uint32
+
+const (
+	ReasonManual       Reason = 0 // Banned from the CLI
+	ReasonTrapHits     Reason = 1 // Too many honeypot trap hits
+	ReasonFailedKnocks Reason = 2 // Too many failed SPA knocks
+	ReasonCommand      Reason = 3 // Ran a banned command in a honeypot
+)
+
+func (r Reason) String() string {
+	switch r {
+	case ReasonManual:
+		return "manual"
+	case ReasonTrapHits:
+		return "trap-hits"
+	case ReasonFailedKnocks:
+		return "failed-knocks"
+	case ReasonCommand:
+		return "command"
+	default:
+		return fmt.Sprintf("unknown(%d)", uint32(r))
+	}
+}
+
+// banEntry mirrors struct ban_entry in internal/ebpf/programs/phantom.c
+type banEntry struct {
+	ExpiryNs uint64
+	Drops    uint64
+	Reason   uint32
+	Pad      uint32
+}
+
+// Entry is a banned source as seen from user space
+type Entry struct {
+	IP        net.IP
+	Reason    Reason
+	Permanent bool
+	Remaining time.Duration // Time until the ban expires (zero if permanent)
+	Drops     uint64        // Packets dropped since the ban was placed
+}
+
+// Blocklist manages the XDP blocklist map
+type Blocklist struct {
+	m *ebpf.Map
+}
+
+// New wraps the blocklist map from the phantom XDP program
+func New(m *ebpf.Map) *Blocklist {
+	return &Blocklist{m: m}
+}
+
+// OpenPinned opens the blocklist map pinned by a running agent
+func OpenPinned(path string) (*Blocklist, error) {
+	m, err := ebpf.LoadPinnedMap(path, nil)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("blocklist not found at %s (is the agent running?)", path)
+		}
+		return nil, fmt.Errorf("failed to open pinned blocklist: %w", err)
+	}
+	return &Blocklist{m: m}, nil
+}
+
+// Pin pins the blocklist map at path, replacing a stale pin left by a previous run
+func (b *Blocklist) Pin(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("failed to create pin directory: %w", err)
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove stale pin: %w", err)
+	}
+	if err := b.m.Pin(path); err != nil {
+		return fmt.Errorf("failed to pin blocklist: %w", err)
+	}
+	return nil
+}
+
+// Unpin removes the pin created by Pin
+func (b *Blocklist) Unpin() error {
+	return b.m.Unpin()
+}
+
+// Ban blocks all traffic from ip for ttl (0 = permanent)
+func (b *Blocklist) Ban(ip net.IP, ttl time.Duration, reason Reason) error {
+	key, err := ipKey(ip)
+	if err != nil {
+		return err
+	}
+
+	entry := banEntry{Reason: uint32(reason)}
+	if ttl > 0 {
+		now, err := monotonicNow()
+		if err != nil {
+			return err
+		}
+		entry.ExpiryNs = uint64(now + ttl)
+	}
+
+	err = b.m.Put(key, entry)
+	if errors.Is(err, unix.E2BIG) {
+		// The map is full: XDP deletes expired bans only when their source
+		// sends again, so make room by deleting them here
+		if _, perr := b.Prune(); perr == nil {
+			err = b.m.Put(key, entry)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to ban %s: %w", ip, err)
+	}
+	return nil
+}
+
+// Prune deletes expired bans and returns how many were deleted
+func (b *Blocklist) Prune() (int, error) {
+	now, err := monotonicNow()
+	if err != nil {
+		return 0, err
+	}
+	var (
+		key     [4]byte
+		entry   banEntry
+		expired [][4]byte
+	)
+	iter := b.m.Iterate()
+	for iter.Next(&key, &entry) {
+		if entry.ExpiryNs != 0 && entry.ExpiryNs <= uint64(now) {
+			expired = append(expired, key)
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return 0, fmt.Errorf("failed to iterate blocklist: %w", err)
+	}
+	pruned := 0
+	for _, k := range expired {
+		if err := b.m.Delete(k); err == nil {
+			pruned++
+		}
+	}
+	return pruned, nil
+}
+
+// Unban removes ip from the blocklist
+func (b *Blocklist) Unban(ip net.IP) error {
+	key, err := ipKey(ip)
+	if err != nil {
+		return err
+	}
+	if err := b.m.Delete(key); err != nil {
+		if errors.Is(err, ebpf.ErrKeyNotExist) {
+			return fmt.Errorf("%s is not banned", ip)
+		}
+		return fmt.Errorf("failed to unban %s: %w", ip, err)
+	}
+	return nil
+}
+
+// IsBanned reports whether ip currently has an active ban
+func (b *Blocklist) IsBanned(ip net.IP) bool {
+	key, err := ipKey(ip)
+	if err != nil {
+		return false
+	}
+	var entry banEntry
+	if err := b.m.Lookup(key, &entry); err != nil {
+		return false
+	}
+	if entry.ExpiryNs == 0 {
+		return true
+	}
+	now, err := monotonicNow()
+	return err == nil && uint64(now) < entry.ExpiryNs
+}
+
+// List returns all active bans, soonest to expire first and permanent bans last
+func (b *Blocklist) List() ([]Entry, error) {
+	now, err := monotonicNow()
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		key     [4]byte
+		entry   banEntry
+		entries []Entry
+	)
+	iter := b.m.Iterate()
+	for iter.Next(&key, &entry) {
+		e := Entry{
+			IP:        net.IPv4(key[0], key[1], key[2], key[3]),
+			Reason:    Reason(entry.Reason),
+			Permanent: entry.ExpiryNs == 0,
+			Drops:     entry.Drops,
+		}
+		if !e.Permanent {
+			if entry.ExpiryNs <= uint64(now) {
+				continue // Expired, XDP removes it on the next packet
+			}
+			e.Remaining = time.Duration(entry.ExpiryNs - uint64(now))
+		}
+		entries = append(entries, e)
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate blocklist: %w", err)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Permanent != entries[j].Permanent {
+			return !entries[i].Permanent
+		}
+		return entries[i].Remaining < entries[j].Remaining
+	})
+	return entries, nil
+}
+
+// Close releases the map handle (the kernel map stays alive while pinned or in use)
+func (b *Blocklist) Close() error {
+	return b.m.Close()
+}
+
+func ipKey(ip net.IP) ([4]byte, error) {
+	var key [4]byte
+	ipv4 := ip.To4()
+	if ipv4 == nil {
+		return key, fmt.Errorf("invalid IPv4 address: %s", ip)
+	}
+	copy(key[:], ipv4)
+	return key, nil
+}
+
+// monotonicNow returns the clock used by bpf_ktime_get_ns()
+func monotonicNow() (time.Duration, error) {
+	var ts unix.Timespec
+	if err := unix.ClockGettime(unix.CLOCK_MONOTONIC, &ts); err != nil {
+		return 0, fmt.Errorf("failed to read monotonic clock: %w", err)
+	}
+	return time.Duration(ts.Nano()), nil
+}
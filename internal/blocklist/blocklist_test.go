@@ -0,0 +1,100 @@
+package blocklist
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/cilium/ebpf"
+)
+
+// newTestBlocklist creates a standalone map with the same layout as the XDP blocklist
+func newTestBlocklist(t *testing.T) *Blocklist {
+	t.Helper()
+	m, err := ebpf.NewMap(&ebpf.MapSpec{
+		Type:       ebpf.Hash,
+		KeySize:    4,
+		ValueSize:  24,
+		MaxEntries: 16,
+	})
+	if err != nil {
+		t.Skipf("Cannot create BPF map (requires root): %v", err)
+	}
+	bl := New(m)
+	t.Cleanup(func() { bl.Close() })
+	return bl
+}
+
+func TestBlocklistBanUnban(t *testing.T) {
+	bl := newTestBlocklist(t)
+
+	temp := net.ParseIP("192.0.2.1")
+	perm := net.ParseIP("192.0.2.2")
+	if err := bl.Ban(temp, time.Hour, ReasonTrapHits); err != nil {
+		t.Fatalf("Ban() error: %v", err)
+	}
+	if err := bl.Ban(perm, 0, ReasonManual); err != nil {
+		t.Fatalf("Ban() error: %v", err)
+	}
+
+	if !bl.IsBanned(temp) || !bl.IsBanned(perm) {
+		t.Fatal("IsBanned() = false for banned sources")
+	}
+
+	entries, err := bl.List()
+	if err != nil {
+		t.Fatalf("List() error: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("List() returned %d entries, want 2", len(entries))
+	}
+	if !entries[0].IP.Equal(temp) || entries[0].Reason != ReasonTrapHits || entries[0].Permanent {
+		t.Errorf("entries[0] = %+v, want temporary trap-hits ban of %s", entries[0], temp)
+	}
+	if entries[0].Remaining <= 59*time.Minute || entries[0].Remaining > time.Hour {
+		t.Errorf("entries[0].Remaining = %s, want about 1h", entries[0].Remaining)
+	}
+	if !entries[1].IP.Equal(perm) || !entries[1].Permanent {
+		t.Errorf("entries[1] = %+v, want permanent ban of %s", entries[1], perm)
+	}
+
+	if err := bl.Unban(temp); err != nil {
+		t.Fatalf("Unban() error: %v", err)
+	}
+	if bl.IsBanned(temp) {
+		t.Error("IsBanned() = true after Unban()")
+	}
+	if err := bl.Unban(temp); err == nil {
+		t.Error("Unban() of an unbanned source should fail")
+	}
+}
+
+func TestBlocklistBanPrunesWhenFull(t *testing.T) {
+	bl := newTestBlocklist(t)
+
+	for i := 0; i < 16; i++ {
+		if err := bl.Ban(net.IPv4(192, 0, 2, byte(i)), time.Nanosecond, ReasonTrapHits); err != nil {
+			t.Fatalf("Ban() error: %v", err)
+		}
+	}
+	time.Sleep(time.Millisecond)
+	perm := net.ParseIP("198.51.100.1")
+	if err := bl.Ban(perm, 0, ReasonManual); err != nil {
+		t.Fatalf("Ban() into a map full of expired bans: %v", err)
+	}
+	entries, err := bl.List()
+	if err != nil {
+		t.Fatalf("List() error: %v", err)
+	}
+	if len(entries) != 1 || !entries[0].IP.Equal(perm) {
+		t.Errorf("List() = %+v, want only the permanent ban", entries)
+	}
+}
+
+func TestBlocklistRejectsIPv6(t *testing.T) {
+	bl := newTestBlocklist(t)
+
+	if err := bl.Ban(net.ParseIP("2001:db8::1"), time.Hour, ReasonManual); err == nil {
+		t.Error("Ban() of an IPv6 address should fail")
+	}
+}
@@ -0,0 +1,171 @@
+package blocklist
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"phantom-grid/internal/config"
+)
+
+// Attack actions recognized by the engine (as passed to logger.LogAttack)
+const (
+	trapHitPrefix    = "TRAP_HIT_PORT_"
+	FailedKnockEvent = "SPA_FAILED_KNOCK"
+)
+
+// maxTrackedSources bounds the per-source counters kept between bans
+const maxTrackedSources = 65536
+
+// Banner places and checks bans. Implemented by *Blocklist.
+type Banner interface {
+	Ban(ip net.IP, ttl time.Duration, reason Reason) error
+	IsBanned(ip net.IP) bool
+}
+
+// sourceState counts events from one source within the current window
+type sourceState struct {
+	windowStart  time.Time
+	trapHits     int
+	failedKnocks int
+}
+
+// Engine bans hostile sources based on honeypot and SPA events
+type Engine struct {
+	banner  Banner
+	policy  config.BanPolicyConfiguration
+	logChan chan<- string
+	exempt  func(ip net.IP) bool
+	now     func() time.Time
+
+	mu      sync.Mutex
+	sources map[string]*sourceState
+}
+
+// NewEngine creates a ban policy engine
+func NewEngine(banner Banner, policy config.BanPolicyConfiguration, logChan chan<- string) *Engine {
+	return &Engine{
+		banner:  banner,
+		policy:  policy,
+		logChan: logChan,
+		now:     time.Now,
+		sources: make(map[string]*sourceState),
+	}
+}
+
+// SetExemptCheck sets an additional check for sources that must never be banned
+// (e.g. currently SPA-whitelisted administrators)
+func (e *Engine) SetExemptCheck(exempt func(ip net.IP) bool) {
+	e.exempt = exempt
+}
+
+// Observe processes an attack event. It matches the logger.SetAttackHook signature.
+func (e *Engine) Observe(ipStr string, action string) {
+	if !e.policy.Enabled {
+		return
+	}
+
+	ip := net.ParseIP(ipStr)
+	if ip == nil || ip.To4() == nil || e.isExempt(ip) {
+		return
+	}
+
+	// Connections already open when the ban was placed keep producing events
+	if e.banner.IsBanned(ip) {
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := e.now()
+	key := ip.String()
+	window := time.Duration(e.policy.WindowSeconds) * time.Second
+	state := e.sources[key]
+	if state == nil || (window > 0 && now.Sub(state.windowStart) > window) {
+		if state == nil && len(e.sources) >= maxTrackedSources {
+			e.pruneLocked(now, window)
+		}
+		state = &sourceState{windowStart: now}
+		e.sources[key] = state
+	}
+
+	switch {
+	case strings.HasPrefix(action, trapHitPrefix):
+		state.trapHits++
+		if e.policy.TrapHitThreshold > 0 && state.trapHits >= e.policy.TrapHitThreshold {
+			e.banLocked(ip, ReasonTrapHits, fmt.Sprintf("%d trap hits", state.trapHits))
+		}
+	case action == FailedKnockEvent:
+		state.failedKnocks++
+		if e.policy.FailedKnockThreshold > 0 && state.failedKnocks >= e.policy.FailedKnockThreshold {
+			e.banLocked(ip, ReasonFailedKnocks, fmt.Sprintf("%d failed SPA knocks", state.failedKnocks))
+		}
+	default:
+		if pattern := e.matchCommand(action); pattern != "" {
+			e.banLocked(ip, ReasonCommand, fmt.Sprintf("command matched %q", strings.TrimSpace(pattern)))
+		}
+	}
+}
+
+func (e *Engine) matchCommand(action string) string {
+	lower := strings.ToLower(action)
+	for _, pattern := range e.policy.BanCommands {
+		if pattern != "" && strings.Contains(lower, strings.ToLower(pattern)) {
+			return pattern
+		}
+	}
+	return ""
+}
+
+func (e *Engine) isExempt(ip net.IP) bool {
+	if ip.IsLoopback() {
+		return true
+	}
+	for _, exempt := range e.policy.ExemptIPs {
+		if exemptIP := net.ParseIP(exempt); exemptIP != nil && exemptIP.Equal(ip) {
+			return true
+		}
+	}
+	return e.exempt != nil && e.exempt(ip)
+}
+
+// pruneLocked drops sources whose window has expired, or all of them if none has
+func (e *Engine) pruneLocked(now time.Time, window time.Duration) {
+	for key, state := range e.sources {
+		if window > 0 && now.Sub(state.windowStart) > window {
+			delete(e.sources, key)
+		}
+	}
+	if len(e.sources) >= maxTrackedSources {
+		e.sources = make(map[string]*sourceState)
+	}
+}
+
+func (e *Engine) banLocked(ip net.IP, reason Reason, detail string) {
+	ttl := time.Duration(e.policy.BanDurationSeconds) * time.Second
+	if err := e.banner.Ban(ip, ttl, reason); err != nil {
+		e.log(fmt.Sprintf("[BAN] Failed to ban %s (%s): %v", ip, detail, err))
+		return
+	}
+
+	delete(e.sources, ip.String())
+	if ttl > 0 {
+		e.log(fmt.Sprintf("[BAN] Banned %s for %s: %s", ip, ttl, detail))
+	} else {
+		e.log(fmt.Sprintf("[BAN] Banned %s permanently: %s", ip, detail))
+	}
+}
+
+// log sends without blocking; Observe runs on honeypot connection goroutines
+func (e *Engine) log(msg string) {
+	if e.logChan == nil {
+		return
+	}
+	select {
+	case e.logChan <- msg:
+	default:
+	}
+}
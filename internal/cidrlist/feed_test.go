@@ -0,0 +1,72 @@
+package cidrlist
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"phantom-grid/internal/config"
+)
+
+func newTestManager(t *testing.T) *Manager {
+	t.Helper()
+	cfg := config.DefaultCIDRListConfig()
+	cfg.MirrorDir = t.TempDir()
+	return NewManager(nil, cfg, nil)
+}
+
+func TestFetchFeedMirrors(t *testing.T) {
+	body := "203.0.113.0/24\n"
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	m := newTestManager(t)
+
+	prefixes, err := m.fetchFeed(server.URL)
+	if err != nil {
+		t.Fatalf("fetchFeed() error: %v", err)
+	}
+	if len(prefixes) != 1 || prefixes[0].String() != "203.0.113.0/24" {
+		t.Fatalf("fetchFeed() = %v, want [203.0.113.0/24]", prefixes)
+	}
+	mirrored, err := os.ReadFile(m.MirrorPath(server.URL))
+	if err != nil || string(mirrored) != body {
+		t.Fatalf("mirror = %q (err %v), want %q", mirrored, err, body)
+	}
+
+	// Feed errors fall back to the mirror
+	status = http.StatusInternalServerError
+	prefixes, err = m.fetchFeed(server.URL)
+	if err != nil || len(prefixes) != 1 {
+		t.Fatalf("fetchFeed() with failing feed = %v, %v; want mirrored list", prefixes, err)
+	}
+
+	// Garbage must not replace a good mirror
+	status = http.StatusOK
+	body = "<html>maintenance</html>"
+	prefixes, err = m.fetchFeed(server.URL)
+	if err != nil || len(prefixes) != 1 {
+		t.Fatalf("fetchFeed() with invalid feed = %v, %v; want mirrored list", prefixes, err)
+	}
+	mirrored, _ = os.ReadFile(m.MirrorPath(server.URL))
+	if string(mirrored) != "203.0.113.0/24\n" {
+		t.Errorf("invalid feed overwrote mirror: %q", mirrored)
+	}
+}
+
+func TestFetchFeedWithoutMirror(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "gone", http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	m := newTestManager(t)
+	if _, err := m.fetchFeed(server.URL); err == nil {
+		t.Error("fetchFeed() succeeded without feed or mirror")
+	}
+}
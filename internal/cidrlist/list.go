@@ -0,0 +1,123 @@
+package cidrlist
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Kind selects the allow or deny list
+type Kind int
+
+const (
+	Allow Kind = iota
+	Deny
+)
+
+func (k Kind) String() string {
+	if k == Allow {
+		return "allow"
+	}
+	return "deny"
+}
+
+// Parse reads one prefix or address per line. Blank lines and comments
+// starting with '#' or ';' are ignored, which also accepts common
+// feed formats such as "203.0.113.0/24 ; SBL123".
+func Parse(r io.Reader) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := scanner.Text()
+		if idx := strings.IndexAny(line, "#;"); idx != -1 {
+			line = line[:idx]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		// Tolerate trailing columns (e.g. "prefix ASN name")
+		if fields := strings.Fields(line); len(fields) > 1 {
+			line = fields[0]
+		}
+
+		prefix, err := parsePrefix(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNum, err)
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read list: %w", err)
+	}
+	return prefixes, nil
+}
+
+// ParseFile reads a prefix list from a file
+func ParseFile(path string) ([]netip.Prefix, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open list: %w", err)
+	}
+	defer file.Close()
+
+	prefixes, err := Parse(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return prefixes, nil
+}
+
+// parsePrefix accepts CIDR notation or a bare address (host prefix)
+func parsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid prefix: %s", s)
+		}
+		return normalize(prefix), nil
+	}
+
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid address: %s", s)
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// normalize masks host bits and maps IPv4-mapped IPv6 prefixes to IPv4
+func normalize(prefix netip.Prefix) netip.Prefix {
+	addr := prefix.Addr()
+	bits := prefix.Bits()
+	if addr.Is4In6() && bits >= 96 {
+		addr = addr.Unmap()
+		bits -= 96
+	}
+	return netip.PrefixFrom(addr, bits).Masked()
+}
+
+// dedupe returns the unique prefixes sorted by address and length
+func dedupe(prefixes []netip.Prefix) []netip.Prefix {
+	seen := make(map[netip.Prefix]bool, len(prefixes))
+	unique := make([]netip.Prefix, 0, len(prefixes))
+	for _, p := range prefixes {
+		if !seen[p] {
+			seen[p] = true
+			unique = append(unique, p)
+		}
+	}
+	sort.Slice(unique, func(i, j int) bool {
+		if c := unique[i].Addr().Compare(unique[j].Addr()); c != 0 {
+			return c < 0
+		}
+		return unique[i].Bits() < unique[j].Bits()
+	})
+	return unique
+}
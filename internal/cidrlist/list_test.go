@@ -0,0 +1,80 @@
+package cidrlist
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	input := `# Office networks
+198.51.100.0/24
+203.0.113.7            # single host
+192.0.2.77/24 ; SBL123
+2001:db8::/32
+2001:db8::1
+::ffff:198.51.100.0/120
+10.0.0.0/8 AS64500 Example
+
+`
+	want := []string{
+		"198.51.100.0/24",
+		"203.0.113.7/32",
+		"192.0.2.0/24",
+		"2001:db8::/32",
+		"2001:db8::1/128",
+		"198.51.100.0/24",
+		"10.0.0.0/8",
+	}
+
+	got, err := Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("Parse() error: %v", err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Parse() returned %d prefixes, want %d: %v", len(got), len(want), got)
+	}
+	for i, w := range want {
+		if got[i] != netip.MustParsePrefix(w) {
+			t.Errorf("prefix %d = %s, want %s", i, got[i], w)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"bad prefix", "198.51.100.0/33"},
+		{"bad address", "not-an-ip"},
+		{"bad line after good", "10.0.0.0/8\n300.1.1.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Parse(strings.NewReader(tt.input)); err == nil {
+				t.Errorf("Parse(%q) succeeded, want error", tt.input)
+			}
+		})
+	}
+}
+
+func TestDedupe(t *testing.T) {
+	in := []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("192.0.2.0/24"),
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("10.0.0.0/16"),
+	}
+	got := dedupe(in)
+	want := []string{"10.0.0.0/8", "10.0.0.0/16", "192.0.2.0/24"}
+	if len(got) != len(want) {
+		t.Fatalf("dedupe() = %v, want %v", got, want)
+	}
+	for i, w := range want {
+		if got[i].String() != w {
+			t.Errorf("dedupe()[%d] = %s, want %s", i, got[i], w)
+		}
+	}
+}
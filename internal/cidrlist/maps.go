@@ -0,0 +1,171 @@
+package cidrlist
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"sort"
+
+	"github.com/cilium/ebpf"
+)
+
+// lpmKey4 mirrors struct lpm_v4_key in internal/ebpf/programs/phantom.c
+type lpmKey4 struct {
+	PrefixLen uint32
+	Addr      [4]byte
+}
+
+// lpmKey6 mirrors struct lpm_v6_key in internal/ebpf/programs/phantom.c
+type lpmKey6 struct {
+	PrefixLen uint32
+	Addr      [16]byte
+}
+
+// cidrEntry mirrors struct cidr_entry in internal/ebpf/programs/phantom.c
+type cidrEntry struct {
+	Hits      uint64
+	PrefixLen uint32
+	Pad       uint32
+}
+
+// PrefixStats is the hit counter of one list entry
+type PrefixStats struct {
+	Kind   Kind
+	Prefix netip.Prefix
+	Hits   uint64
+}
+
+// Maps manages the XDP CIDR allow/deny LPM tries
+type Maps struct {
+	allow4, allow6 *ebpf.Map
+	deny4, deny6   *ebpf.Map
+}
+
+// NewMaps wraps the cidr_* maps from the phantom XDP program
+func NewMaps(allow4, allow6, deny4, deny6 *ebpf.Map) *Maps {
+	return &Maps{allow4: allow4, allow6: allow6, deny4: deny4, deny6: deny6}
+}
+
+func (m *Maps) maps(kind Kind) (v4, v6 *ebpf.Map) {
+	if kind == Allow {
+		return m.allow4, m.allow6
+	}
+	return m.deny4, m.deny6
+}
+
+// Sync makes the kernel list match prefixes. Entries that stay in the list
+// keep their hit counters; removed entries are deleted.
+func (m *Maps) Sync(kind Kind, prefixes []netip.Prefix) error {
+	v4, v6 := m.maps(kind)
+	if v4 == nil || v6 == nil {
+		return fmt.Errorf("%s maps not available", kind)
+	}
+
+	want := make(map[netip.Prefix]bool, len(prefixes))
+	for _, p := range prefixes {
+		want[normalize(p)] = true
+	}
+
+	// Remove stale entries first so capacity is available for new ones
+	current, err := m.list(kind)
+	if err != nil {
+		return err
+	}
+	for _, stats := range current {
+		if want[stats.Prefix] {
+			delete(want, stats.Prefix)
+			continue
+		}
+		if err := deletePrefix(v4, v6, stats.Prefix); err != nil {
+			return err
+		}
+	}
+
+	for prefix := range want {
+		if err := insertPrefix(v4, v6, prefix); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Stats returns hit counters for all entries, highest first
+func (m *Maps) Stats() ([]PrefixStats, error) {
+	var all []PrefixStats
+	for _, kind := range []Kind{Allow, Deny} {
+		stats, err := m.list(kind)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, stats...)
+	}
+
+	sort.SliceStable(all, func(i, j int) bool {
+		return all[i].Hits > all[j].Hits
+	})
+	return all, nil
+}
+
+func (m *Maps) list(kind Kind) ([]PrefixStats, error) {
+	v4, v6 := m.maps(kind)
+	var stats []PrefixStats
+
+	if v4 != nil {
+		var (
+			key   lpmKey4
+			entry cidrEntry
+		)
+		iter := v4.Iterate()
+		for iter.Next(&key, &entry) {
+			prefix := netip.PrefixFrom(netip.AddrFrom4(key.Addr), int(key.PrefixLen))
+			stats = append(stats, PrefixStats{Kind: kind, Prefix: prefix, Hits: entry.Hits})
+		}
+		if err := iter.Err(); err != nil {
+			return nil, fmt.Errorf("failed to iterate %s v4 list: %w", kind, err)
+		}
+	}
+
+	if v6 != nil {
+		var (
+			key   lpmKey6
+			entry cidrEntry
+		)
+		iter := v6.Iterate()
+		for iter.Next(&key, &entry) {
+			prefix := netip.PrefixFrom(netip.AddrFrom16(key.Addr), int(key.PrefixLen))
+			stats = append(stats, PrefixStats{Kind: kind, Prefix: prefix, Hits: entry.Hits})
+		}
+		if err := iter.Err(); err != nil {
+			return nil, fmt.Errorf("failed to iterate %s v6 list: %w", kind, err)
+		}
+	}
+	return stats, nil
+}
+
+func insertPrefix(v4, v6 *ebpf.Map, prefix netip.Prefix) error {
+	entry := cidrEntry{PrefixLen: uint32(prefix.Bits())}
+
+	var err error
+	if prefix.Addr().Is4() {
+		err = v4.Update(lpmKey4{PrefixLen: uint32(prefix.Bits()), Addr: prefix.Addr().As4()}, entry, ebpf.UpdateNoExist)
+	} else {
+		err = v6.Update(lpmKey6{PrefixLen: uint32(prefix.Bits()), Addr: prefix.Addr().As16()}, entry, ebpf.UpdateNoExist)
+	}
+	if err != nil && !errors.Is(err, ebpf.ErrKeyExist) {
+		return fmt.Errorf("failed to add %s: %w", prefix, err)
+	}
+	return nil
+}
+
+func deletePrefix(v4, v6 *ebpf.Map, prefix netip.Prefix) error {
+	var err error
+	if prefix.Addr().Is4() {
+		err = v4.Delete(lpmKey4{PrefixLen: uint32(prefix.Bits()), Addr: prefix.Addr().As4()})
+	} else {
+		err = v6.Delete(lpmKey6{PrefixLen: uint32(prefix.Bits()), Addr: prefix.Addr().As16()})
+	}
+	if err != nil && !errors.Is(err, ebpf.ErrKeyNotExist) {
+		return fmt.Errorf("failed to remove %s: %w", prefix, err)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package cidrlist
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/cilium/ebpf"
+)
+
+func newLPMMap(t *testing.T, keySize uint32) *ebpf.Map {
+	t.Helper()
+	m, err := ebpf.NewMap(&ebpf.MapSpec{
+		Type:       ebpf.LPMTrie,
+		KeySize:    keySize,
+		ValueSize:  16,
+		MaxEntries: 64,
+		Flags:      1, // BPF_F_NO_PREALLOC
+	})
+	if err != nil {
+		t.Skipf("Cannot create LPM trie (requires root): %v", err)
+	}
+	t.Cleanup(func() { m.Close() })
+	return m
+}
+
+func newTestMaps(t *testing.T) *Maps {
+	return NewMaps(newLPMMap(t, 8), newLPMMap(t, 20), newLPMMap(t, 8), newLPMMap(t, 20))
+}
+
+func prefixes(s ...string) []netip.Prefix {
+	var out []netip.Prefix
+	for _, p := range s {
+		out = append(out, netip.MustParsePrefix(p))
+	}
+	return out
+}
+
+func TestMapsSync(t *testing.T) {
+	m := newTestMaps(t)
+
+	if err := m.Sync(Deny, prefixes("203.0.113.0/24", "2001:db8::/32")); err != nil {
+		t.Fatalf("Sync() error: %v", err)
+	}
+	if err := m.Sync(Allow, prefixes("198.51.100.0/24")); err != nil {
+		t.Fatalf("Sync() error: %v", err)
+	}
+
+	// Simulate hits recorded by XDP
+	key := lpmKey4{PrefixLen: 24, Addr: netip.MustParseAddr("203.0.113.0").As4()}
+	if err := m.deny4.Put(key, cidrEntry{Hits: 42, PrefixLen: 24}); err != nil {
+		t.Fatalf("Put() error: %v", err)
+	}
+
+	// Re-sync: the kept prefix retains its counter, the dropped one disappears
+	if err := m.Sync(Deny, prefixes("203.0.113.0/24", "192.0.2.0/24")); err != nil {
+		t.Fatalf("Sync() error: %v", err)
+	}
+
+	stats, err := m.Stats()
+	if err != nil {
+		t.Fatalf("Stats() error: %v", err)
+	}
+	got := make(map[string]PrefixStats)
+	for _, s := range stats {
+		got[s.Kind.String()+" "+s.Prefix.String()] = s
+	}
+
+	if len(got) != 3 {
+		t.Fatalf("Stats() = %v, want 3 entries", stats)
+	}
+	if s, ok := got["deny 203.0.113.0/24"]; !ok || s.Hits != 42 {
+		t.Errorf("deny 203.0.113.0/24 = %+v, want 42 hits", s)
+	}
+	if _, ok := got["deny 2001:db8::/32"]; ok {
+		t.Error("removed prefix still present")
+	}
+	if _, ok := got["deny 192.0.2.0/24"]; !ok {
+		t.Error("added prefix missing")
+	}
+	if _, ok := got["allow 198.51.100.0/24"]; !ok {
+		t.Error("allow prefix missing")
+	}
+	if stats[0].Prefix.String() != "203.0.113.0/24" {
+		t.Errorf("Stats()[0] = %+v, want the prefix with most hits first", stats[0])
+	}
+}
@@ -1,5 +1,10 @@
 package config
 
+import (
+	"fmt"
+	"strings"
+)
+
 // SPA Configuration
 const (
 	SPAMagicPort         = 1337
@@ -25,9 +30,247 @@ var CriticalPorts = GetCriticalPorts()
 // To add/modify ports, update FakePortDefinitions in ports.go and run: make generate-config
 var FakePorts = GetFakePorts()
 
+// CriticalUDPPorts are UDP services protected by Phantom Protocol (SPA required)
+// Generated from CriticalUDPPortDefinitions in ports.go
+var CriticalUDPPorts = GetCriticalUDPPorts()
+
+// FakeUDPPorts are UDP services emulated by the honeypot
+// Generated from FakeUDPPortDefinitions in ports.go
+var FakeUDPPorts = GetFakeUDPPorts()
+
 // Fallback ports if honeypot port is unavailable
 var FallbackPorts = []int{9998, 9997, 9996, 8888, 7777}
 
+// SteeringMode defines how connections to unprotected ports reach the honeypot
+type SteeringMode string
+
+const (
+	SteeringModeRedirect SteeringMode = "redirect" // XDP rewrites destination port to HoneypotPort
+	SteeringModeSkLookup SteeringMode = "sklookup" // sk_lookup assigns the honeypot socket, no packet rewriting
+)
+
+// FragmentPolicy defines what XDP does with non-first IPv4 fragments, which carry no ports
+type FragmentPolicy string
+
+const (
+	FragmentPolicyPass FragmentPolicy = "pass" // Pass; the first fragment is screened, so dropping it blocks reassembly
+	FragmentPolicyDrop FragmentPolicy = "drop" // Drop every non-first fragment
+)
+
+// StealthResponse defines how XDP answers a stealth scan probe
+type StealthResponse string
+
+const (
+	StealthResponseDrop      StealthResponse = "drop"       // Silent drop, which nmap reports as filtered
+	StealthResponseRSTOpen   StealthResponse = "rst-open"   // As an open port: nothing for Xmas/Null/FIN, a RST with a window for ACK
+	StealthResponseRSTClosed StealthResponse = "rst-closed" // As a closed port: a RST
+	StealthResponseRandom    StealthResponse = "random"     // rst-open or rst-closed, chosen per packet
+)
+
+// StealthResponseConfiguration selects the response for each stealth scan type
+type StealthResponseConfiguration struct {
+	Xmas StealthResponse // FIN|PSH|URG
+	Null StealthResponse // No flags
+	FIN  StealthResponse // FIN only
+	ACK  StealthResponse // ACK without SYN/FIN/RST
+}
+
+// DefaultStealthResponseConfig returns default stealth scan responses
+func DefaultStealthResponseConfig() StealthResponseConfiguration {
+	return StealthResponseConfiguration{
+		Xmas: StealthResponseDrop,
+		Null: StealthResponseDrop,
+		FIN:  StealthResponseDrop,
+		ACK:  StealthResponseDrop,
+	}
+}
+
+// ParseStealthResponses parses either a single response for every scan type
+// or a comma-separated list of 'scan=response' entries (scan is xmas, null,
+// fin or ack). Scan types not listed keep their response from base.
+func ParseStealthResponses(value string, base StealthResponseConfiguration) (StealthResponseConfiguration, error) {
+	cfg := base
+	for _, field := range strings.Split(value, ",") {
+		field = strings.ToLower(strings.TrimSpace(field))
+		if field == "" {
+			continue
+		}
+
+		scan, response, hasScan := strings.Cut(field, "=")
+		if !hasScan {
+			response = scan
+		}
+		r := StealthResponse(strings.TrimSpace(response))
+		switch r {
+		case StealthResponseDrop, StealthResponseRSTOpen, StealthResponseRSTClosed, StealthResponseRandom:
+		default:
+			return base, fmt.Errorf("unknown stealth scan response: %s", response)
+		}
+
+		if !hasScan {
+			cfg = StealthResponseConfiguration{Xmas: r, Null: r, FIN: r, ACK: r}
+			continue
+		}
+		switch strings.TrimSpace(scan) {
+		case "xmas":
+			cfg.Xmas = r
+		case "null":
+			cfg.Null = r
+		case "fin":
+			cfg.FIN = r
+		case "ack":
+			cfg.ACK = r
+		default:
+			return base, fmt.Errorf("unknown stealth scan type: %s", scan)
+		}
+	}
+	return cfg, nil
+}
+
+// RateLimit is a per-source token bucket enforced in XDP
+type RateLimit struct {
+	PacketsPerSecond uint32 // Sustained rate (0 disables the limit)
+	Burst            uint32 // Bucket size (0 defaults to PacketsPerSecond)
+}
+
+// RateLimitConfiguration holds per-source rate limits; exceeding packets are dropped in XDP
+type RateLimitConfiguration struct {
+	SPA   RateLimit // Packets to SPAMagicPort
+	SYN   RateLimit // SYNs to fake and redirected (honeypot) ports
+	Total RateLimit // All packets from a source (SPA-whitelisted sources are exempt)
+}
+
+// DefaultRateLimitConfig returns default rate limits. The total limit is
+// off: it applies to every packet, so it would also throttle the host's own
+// clients and services that are not protected.
+func DefaultRateLimitConfig() RateLimitConfiguration {
+	return RateLimitConfiguration{
+		SPA: RateLimit{PacketsPerSecond: 5, Burst: 10},
+		SYN: RateLimit{PacketsPerSecond: 50, Burst: 100},
+	}
+}
+
+// BPFPinDir is where the agent pins its BPF maps and links
+const BPFPinDir = "/sys/fs/bpf/phantom-grid"
+
+// BlocklistPinPath is where the agent pins the blocklist map so the CLI can manage bans
+const BlocklistPinPath = BPFPinDir + "/blocklist"
+
+// PersistenceConfiguration controls whether kernel state outlives the agent
+type PersistenceConfiguration struct {
+	Enabled    bool // Pin maps and links under BPFPinDir and reuse them on start
+	KeepOnExit bool // Leave XDP attached and state pinned whenever the agent exits, not only on a restart
+}
+
+// DefaultPersistenceConfig returns default persistence: state is pinned, and
+// handed over to the next agent only when the agent is restarted. A plain
+// stop detaches XDP, since dynamic and asymmetric SPA knocks need the agent
+// and protected ports would stay closed to everyone.
+func DefaultPersistenceConfig() PersistenceConfiguration {
+	return PersistenceConfiguration{
+		Enabled:    true,
+		KeepOnExit: false,
+	}
+}
+
+// BanPolicyConfiguration holds rules for automatically banning hostile sources.
+// A failed SPA knock is a single UDP packet whose source is trivially spoofed,
+// so with FailedKnockThreshold set anyone who knows an administrator's address
+// can get it banned and lock the administrator out. Only set it when
+// administrators knock from ExemptIPs.
+//
+// BanCommands bans while the attacker is still connected, which ends the
+// session and with it the payload capture and session recording. The
+// patterns are matched against every honeypot event, including MySQL queries
+// and HTTP request lines, so the list is empty by default.
+type BanPolicyConfiguration struct {
+	Enabled              bool
+	TrapHitThreshold     int      // Ban after N honeypot trap hits within WindowSeconds (0 disables)
+	FailedKnockThreshold int      // Ban after N failed SPA knocks within WindowSeconds (0 disables; sources can be spoofed, see above)
+	BanCommands          []string // Ban immediately when a honeypot command contains any of these (case-insensitive)
+	WindowSeconds        int      // Counting window for thresholds
+	BanDurationSeconds   int      // Ban TTL (0 = permanent)
+	ExemptIPs            []string // Sources that are never banned automatically
+}
+
+// DefaultBanPolicyConfig returns default automatic ban rules
+func DefaultBanPolicyConfig() BanPolicyConfiguration {
+	return BanPolicyConfiguration{
+		Enabled:              true,
+		TrapHitThreshold:     10,
+		FailedKnockThreshold: 0,
+		BanCommands:          []string{},
+		WindowSeconds:        300,
+		BanDurationSeconds:   3600,
+		ExemptIPs:            []string{},
+	}
+}
+
+// CIDRListConfiguration holds sources for the XDP CIDR allow/deny lists.
+// A source is a local file or an http(s) feed URL with one prefix or address per line.
+type CIDRListConfiguration struct {
+	AllowSources   []string // Always allowed (bypass SPA, bans and rate limits)
+	DenySources    []string // Always dropped
+	MirrorDir      string   // Local copies of feed URLs, used when a feed is unreachable
+	RefreshSeconds int      // Reload interval for all sources (0 = load once at startup)
+}
+
+// DefaultCIDRListConfig returns default CIDR list configuration
+func DefaultCIDRListConfig() CIDRListConfiguration {
+	return CIDRListConfiguration{
+		AllowSources:   []string{},
+		DenySources:    []string{},
+		MirrorDir:      "./lists",
+		RefreshSeconds: 3600,
+	}
+}
+
+// AgentConfiguration holds runtime settings for the agent's kernel programs
+type AgentConfiguration struct {
+	SteeringMode     SteeringMode                 // How unprotected ports are steered to the honeypot
+	PassthroughPorts []int                        // Ports sk_lookup must not steer (real services on this host)
+	RateLimits       RateLimitConfiguration       // Per-source rate limits
+	BanPolicy        BanPolicyConfiguration       // Automatic banning of hostile sources
+	CIDRLists        CIDRListConfiguration        // CIDR allowlists and denylists
+	FragmentPolicy   FragmentPolicy               // Handling of non-first IPv4 fragments
+	OSPersonality    OSPersonalityConfiguration   // TCP/IP fingerprint of honeypot replies
+	Tarpit           TarpitConfiguration          // Stalling of scanners on unprotected ports
+	StealthResponses StealthResponseConfiguration // Replies to Xmas/Null/FIN/ACK scans
+	DLP              DLPConfiguration             // Egress data loss prevention
+	Persistence      PersistenceConfiguration     // Pinning of BPF state across restarts
+	SSH              SSHConfiguration             // SSH honeypot persona
+	Login            LoginConfiguration           // Credentials accepted by the SSH, Telnet and MySQL personas
+	FileSystem       FileSystemConfiguration      // Fake file system of the shell personas
+	Payload          PayloadConfiguration         // Fetching of payloads droppers download
+	Recording        RecordingConfiguration       // Recording of shell sessions
+	HTTP             HTTPConfiguration            // HTTP persona
+	MySQL            MySQLConfiguration           // MySQL persona
+}
+
+// DefaultAgentConfig returns default agent configuration
+func DefaultAgentConfig() AgentConfiguration {
+	return AgentConfiguration{
+		SteeringMode:     SteeringModeRedirect,
+		PassthroughPorts: []int{},
+		RateLimits:       DefaultRateLimitConfig(),
+		BanPolicy:        DefaultBanPolicyConfig(),
+		CIDRLists:        DefaultCIDRListConfig(),
+		FragmentPolicy:   FragmentPolicyPass,
+		OSPersonality:    DefaultOSPersonalityConfig(),
+		Tarpit:           DefaultTarpitConfig(),
+		StealthResponses: DefaultStealthResponseConfig(),
+		DLP:              DefaultDLPConfig(),
+		Persistence:      DefaultPersistenceConfig(),
+		SSH:              DefaultSSHConfig(),
+		Login:            DefaultLoginConfig(),
+		FileSystem:       DefaultFileSystemConfig(),
+		Payload:          DefaultPayloadConfig(),
+		Recording:        DefaultRecordingConfig(),
+		HTTP:             DefaultHTTPConfig(),
+		MySQL:            DefaultMySQLConfig(),
+	}
+}
+
 // OutputMode defines where logs and events are sent
 type OutputMode string
 
@@ -1,6 +1,10 @@
 package config
 
-import "testing"
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
 
 func TestSPASecretTokenLengthMatches(t *testing.T) {
 	if got := len(SPASecretToken); got != SPATokenLen {
@@ -14,4 +18,272 @@ func TestFakePortsNotEmpty(t *testing.T) {
 	}
 }
 
+func TestOSProfilesValid(t *testing.T) {
+	seen := make(map[string]bool)
+	for _, p := range OSProfiles {
+		if err := p.Validate(); err != nil {
+			t.Errorf("%v", err)
+		}
+		if seen[p.Name] {
+			t.Errorf("duplicate OS profile %s", p.Name)
+		}
+		seen[p.Name] = true
+	}
+
+	tooLong := OSProfile{Name: "bad", TTL: 64, Options: []TCPOption{TCPOptTimestamp, TCPOptTimestamp, TCPOptTimestamp, TCPOptTimestamp, TCPOptMSS}}
+	if err := tooLong.Validate(); err == nil {
+		t.Error("Validate() accepted 44 bytes of options")
+	}
+}
+
+func TestOSPersonalitySelectedProfiles(t *testing.T) {
+	cfg := DefaultOSPersonalityConfig()
+	if profiles, err := cfg.SelectedProfiles(); err != nil || len(profiles) != len(OSProfiles) {
+		t.Errorf("per-source default = %d profiles, %v; want all %d", len(profiles), err, len(OSProfiles))
+	}
+
+	cfg.Profiles = []string{"windows", "freebsd"}
+	profiles, err := cfg.SelectedProfiles()
+	if err != nil || len(profiles) != 2 || profiles[1].Name != "freebsd" {
+		t.Errorf("per-source subset = %v, %v", profiles, err)
+	}
+
+	cfg = OSPersonalityConfiguration{Mode: OSPersonalityFixed, Profile: "solaris"}
+	if profiles, err := cfg.SelectedProfiles(); err != nil || len(profiles) != 1 || profiles[0].TTL != 255 {
+		t.Errorf("fixed = %v, %v", profiles, err)
+	}
+
+	cfg.Profile = "amiga"
+	if _, err := cfg.SelectedProfiles(); err == nil {
+		t.Error("SelectedProfiles() accepted an unknown profile")
+	}
+}
+
+func TestParseTarpitRanges(t *testing.T) {
+	ranges, err := ParseTarpitRanges("1-1023, 5900:honeypot, 6000-6063:XDP")
+	if err != nil {
+		t.Fatalf("ParseTarpitRanges() error: %v", err)
+	}
+	want := []TarpitRange{
+		{1, 1023, TarpitModeXDP},
+		{5900, 5900, TarpitModeHoneypot},
+		{6000, 6063, TarpitModeXDP},
+	}
+	if len(ranges) != len(want) {
+		t.Fatalf("got %v, want %v", ranges, want)
+	}
+	for i := range want {
+		if ranges[i] != want[i] {
+			t.Errorf("range %d = %v, want %v", i, ranges[i], want[i])
+		}
+	}
+
+	for _, bad := range []string{"0-10", "10-5", "70000", "22:drop", "ssh"} {
+		if _, err := ParseTarpitRanges(bad); err == nil {
+			t.Errorf("ParseTarpitRanges(%q) accepted", bad)
+		}
+	}
+}
 
+func TestTarpitModeForPort(t *testing.T) {
+	cfg := DefaultTarpitConfig()
+	cfg.Ranges = []TarpitRange{{100, 200, TarpitModeHoneypot}, {150, 300, TarpitModeXDP}}
+	if _, ok := cfg.ModeForPort(150); ok {
+		t.Error("disabled tarpit matched a port")
+	}
+
+	cfg.Enabled = true
+	if mode, ok := cfg.ModeForPort(150); !ok || mode != TarpitModeHoneypot {
+		t.Errorf("ModeForPort(150) = %s, %v; want first matching range", mode, ok)
+	}
+	if mode, ok := cfg.ModeForPort(300); !ok || mode != TarpitModeXDP {
+		t.Errorf("ModeForPort(300) = %s, %v", mode, ok)
+	}
+	if _, ok := cfg.ModeForPort(301); ok {
+		t.Error("ModeForPort(301) matched")
+	}
+}
+
+func TestParseStealthResponses(t *testing.T) {
+	base := DefaultStealthResponseConfig()
+
+	cfg, err := ParseStealthResponses("random", base)
+	if err != nil || cfg.Xmas != StealthResponseRandom || cfg.ACK != StealthResponseRandom {
+		t.Errorf("single response = %+v, %v", cfg, err)
+	}
+
+	cfg, err = ParseStealthResponses("rst-closed, ack=rst-open", base)
+	if err != nil {
+		t.Fatalf("ParseStealthResponses() error: %v", err)
+	}
+	want := StealthResponseConfiguration{Xmas: StealthResponseRSTClosed, Null: StealthResponseRSTClosed, FIN: StealthResponseRSTClosed, ACK: StealthResponseRSTOpen}
+	if cfg != want {
+		t.Errorf("got %+v, want %+v", cfg, want)
+	}
+
+	if cfg, _ := ParseStealthResponses("fin=random", base); cfg.FIN != StealthResponseRandom || cfg.Null != StealthResponseDrop {
+		t.Errorf("per-scan entry = %+v, want only FIN changed", cfg)
+	}
+
+	for _, bad := range []string{"reject", "syn=drop", "xmas=rst"} {
+		if _, err := ParseStealthResponses(bad, base); err == nil {
+			t.Errorf("ParseStealthResponses(%q) accepted", bad)
+		}
+	}
+}
+
+func TestParseDLPRules(t *testing.T) {
+	input := `# Secrets the honeypot must not leak
+shadow prefix root:$
+aws contains AKIA
+nul_bytes contains "\x00\x00 key"
+blobs base64 90
+`
+	rules, err := ParseDLPRules(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("ParseDLPRules() error: %v", err)
+	}
+	want := []DLPRule{
+		{Name: "shadow", Kind: DLPRulePrefix, Pattern: []byte("root:$")},
+		{Name: "aws", Kind: DLPRuleContains, Pattern: []byte("AKIA")},
+		{Name: "nul_bytes", Kind: DLPRuleContains, Pattern: []byte("\x00\x00 key")},
+		{Name: "blobs", Kind: DLPRuleBase64, Threshold: 90},
+	}
+	if len(rules) != len(want) {
+		t.Fatalf("got %d rules, want %d", len(rules), len(want))
+	}
+	for i := range want {
+		if rules[i].Name != want[i].Name || rules[i].Kind != want[i].Kind ||
+			!bytes.Equal(rules[i].Pattern, want[i].Pattern) || rules[i].Threshold != want[i].Threshold {
+			t.Errorf("rule %d = %+v, want %+v", i, rules[i], want[i])
+		}
+	}
+
+	for _, bad := range []string{"x prefix", "x regex a.*", "x base64 0", "x prefix 0123456789abcdefg", `x contains "\q"`} {
+		if _, err := ParseDLPRule(bad); err == nil {
+			t.Errorf("ParseDLPRule(%q) accepted", bad)
+		}
+	}
+}
+
+func TestDefaultDLPConfigValid(t *testing.T) {
+	if err := DefaultDLPConfig().Validate(); err != nil {
+		t.Fatalf("default DLP configuration invalid: %v", err)
+	}
+
+	cfg := DefaultDLPConfig()
+	cfg.Rules = append(cfg.Rules, cfg.Rules[0])
+	if err := cfg.Validate(); err == nil {
+		t.Error("duplicate rule names accepted")
+	}
+
+	cfg = DefaultDLPConfig()
+	cfg.Cgroups = []string{"/sys/fs/cgroup/system.slice/nginx.service", "mysql.service"}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("cgroup path and systemd unit rejected: %v", err)
+	}
+	cfg.Cgroups = []string{"system.slice/nginx.service"}
+	if err := cfg.Validate(); err == nil {
+		t.Error("relative cgroup path accepted")
+	}
+}
+
+func TestLoginPolicies(t *testing.T) {
+	creds, err := ParseCredentials("root:root, admin:*, *:xc3511, pi:a:b")
+	if err != nil {
+		t.Fatalf("ParseCredentials() error: %v", err)
+	}
+	if len(creds) != 4 || creds[3] != (Credential{"pi", "a:b"}) {
+		t.Fatalf("ParseCredentials() = %v", creds)
+	}
+	for _, bad := range []string{"root", ":pass"} {
+		if _, err := ParseCredentials(bad); err == nil {
+			t.Errorf("ParseCredentials(%q) accepted", bad)
+		}
+	}
+
+	list := LoginConfiguration{Policy: LoginPolicyList, Credentials: creds, MaxAttempts: 3}
+	tests := []struct {
+		user, password string
+		want           bool
+	}{
+		{"root", "root", true},
+		{"root", "toor", false},
+		{"admin", "anything", true},
+		{"guest", "xc3511", true},
+		{"pi", "a:b", true},
+	}
+	for _, tt := range tests {
+		if got := list.Accepts(tt.user, tt.password, 1); got != tt.want {
+			t.Errorf("list Accepts(%q, %q) = %v, want %v", tt.user, tt.password, got, tt.want)
+		}
+	}
+
+	attempt := LoginConfiguration{Policy: LoginPolicyAttempt, Attempt: 5, MaxAttempts: 3}
+	if attempt.Accepts("root", "root", 4) || !attempt.Accepts("x", "y", 5) {
+		t.Error("attempt policy did not accept exactly the fifth attempt")
+	}
+	if attempt.AttemptLimit() != 5 {
+		t.Errorf("AttemptLimit() = %d, want the accepted attempt", attempt.AttemptLimit())
+	}
+
+	if err := DefaultLoginConfig().Validate(); err != nil {
+		t.Errorf("default login config invalid: %v", err)
+	}
+	if err := (LoginConfiguration{Policy: LoginPolicyList, MaxAttempts: 3}).Validate(); err == nil {
+		t.Error("list policy without credentials accepted")
+	}
+}
+
+func TestPayloadConfigValidate(t *testing.T) {
+	if err := DefaultPayloadConfig().Validate(); err != nil {
+		t.Errorf("default payload config invalid: %v", err)
+	}
+	for _, proxy := range []string{"http://127.0.0.1:3128", "http://user:pw@proxy:8080"} {
+		cfg := DefaultPayloadConfig()
+		cfg.Proxy = proxy
+		if err := cfg.Validate(); err != nil {
+			t.Errorf("proxy %s rejected: %v", proxy, err)
+		}
+	}
+	for _, proxy := range []string{"socks5://127.0.0.1:1080", "proxy:3128", "http://"} {
+		cfg := DefaultPayloadConfig()
+		cfg.Proxy = proxy
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("proxy %s accepted", proxy)
+		}
+	}
+	cfg := DefaultPayloadConfig()
+	cfg.MaxBytes = 0
+	if err := cfg.Validate(); err == nil {
+		t.Error("zero size limit accepted")
+	}
+}
+
+func TestHTTPConfigValidate(t *testing.T) {
+	if err := DefaultHTTPConfig().Validate(); err != nil {
+		t.Errorf("default HTTP config invalid: %v", err)
+	}
+	cfg := DefaultHTTPConfig()
+	cfg.MaxBodyBytes = 0
+	if err := cfg.Validate(); err == nil {
+		t.Error("zero body size limit accepted")
+	}
+	cfg = DefaultHTTPConfig()
+	cfg.MaxUploadBytes = cfg.MaxBodyBytes - 1
+	if err := cfg.Validate(); err == nil {
+		t.Error("upload size limit below the body size limit accepted")
+	}
+}
+
+// Failed knocks can be forged, and command bans cut sessions short, so the
+// default policy uses neither
+func TestDefaultBanPolicy(t *testing.T) {
+	cfg := DefaultBanPolicyConfig()
+	if cfg.FailedKnockThreshold != 0 {
+		t.Errorf("FailedKnockThreshold = %d, want 0 (off)", cfg.FailedKnockThreshold)
+	}
+	if len(cfg.BanCommands) != 0 {
+		t.Errorf("BanCommands = %q, want none", cfg.BanCommands)
+	}
+}
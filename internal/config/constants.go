@@ -12,20 +12,6 @@ type EBFPConstants struct {
 	SPASecretToken       string
 	SPATokenLen          int
 	SPAWhitelistDuration int // in seconds
-
-	// OS Fingerprint Values (TTL)
-	TTLWindows int
-	TTLLinux   int
-	TTLFreeBSD int
-	TTLSolaris int
-
-	// OS Fingerprint Values (Window Size)
-	WindowWindows int
-	WindowLinux   int
-	WindowFreeBSD int
-
-	// Egress DLP
-	MaxPayloadScan int
 }
 
 // GetEBPFConstants returns all eBPF constants
@@ -37,13 +23,5 @@ func GetEBPFConstants() EBFPConstants {
 		SPASecretToken:       SPASecretToken,
 		SPATokenLen:          SPATokenLen,
 		SPAWhitelistDuration: SPAWhitelistDuration,
-		TTLWindows:           128,
-		TTLLinux:             64,
-		TTLFreeBSD:           64,
-		TTLSolaris:           255,
-		WindowWindows:        65535,
-		WindowLinux:          29200,
-		WindowFreeBSD:        65535,
-		MaxPayloadScan:       512,
 	}
 }
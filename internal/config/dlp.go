@@ -0,0 +1,207 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// DLPMode defines what the TC egress program does with payloads matching a rule
+type DLPMode string
+
+const (
+	DLPModeOff     DLPMode = "off"     // Payloads are not inspected
+	DLPModeMonitor DLPMode = "monitor" // Matches are counted and reported
+	DLPModeEnforce DLPMode = "enforce" // Matches are counted, reported and dropped
+)
+
+// DLPRuleKind defines how a rule's pattern is matched against the start of a payload
+type DLPRuleKind string
+
+const (
+	DLPRulePrefix   DLPRuleKind = "prefix"   // Payload starts with the pattern
+	DLPRuleContains DLPRuleKind = "contains" // Pattern occurs within the inspected bytes
+	DLPRuleBase64   DLPRuleKind = "base64"   // Inspected bytes are mostly base64 characters
+)
+
+// Limits of the egress program (must match DLP_* in programs/phantom_egress.c)
+const (
+	MaxDLPRules      = 16
+	MaxDLPPatternLen = 16
+	DLPScanLen       = 64 // Payload bytes inspected per packet
+	MaxDLPPorts      = 256
+	MaxDLPCgroups    = 256
+)
+
+// DLPRule is a pattern looked for in outgoing payloads
+type DLPRule struct {
+	Name      string
+	Kind      DLPRuleKind
+	Pattern   []byte // Prefix and contains rules
+	Threshold int    // Base64 rules: minimum percentage of base64 characters
+}
+
+// DLPConfiguration controls egress DLP. Traffic leaving the monitored
+// interface from one of Ports is inspected by the TC egress program; traffic
+// of the processes in Cgroups, on any interface, by a cgroup_skb program.
+type DLPConfiguration struct {
+	Mode    DLPMode
+	Rules   []DLPRule // Checked in order; the first match wins
+	Ports   []int     // Local source ports to inspect
+	Cgroups []string  // cgroup v2 directories or systemd units, e.g. /sys/fs/cgroup/system.slice/nginx.service or nginx.service
+}
+
+// DefaultDLPRules returns the built-in rules for data a compromised honeypot
+// should never send
+func DefaultDLPRules() []DLPRule {
+	return []DLPRule{
+		{Name: "passwd", Kind: DLPRulePrefix, Pattern: []byte("root:x:0:0:")},
+		{Name: "pem", Kind: DLPRulePrefix, Pattern: []byte("-----BEGIN")},
+		{Name: "base64", Kind: DLPRuleBase64, Threshold: 95},
+		{Name: "sql_dump", Kind: DLPRulePrefix, Pattern: []byte("INSERT INTO")},
+	}
+}
+
+// DefaultDLPConfig returns default DLP configuration: honeypot replies are
+// monitored with the built-in rules
+func DefaultDLPConfig() DLPConfiguration {
+	return DLPConfiguration{
+		Mode:    DLPModeMonitor,
+		Rules:   DefaultDLPRules(),
+		Ports:   []int{HoneypotPort},
+		Cgroups: []string{},
+	}
+}
+
+// Validate checks the rules and scope against the limits of the egress program
+func (c DLPConfiguration) Validate() error {
+	switch c.Mode {
+	case DLPModeOff, DLPModeMonitor, DLPModeEnforce:
+	default:
+		return fmt.Errorf("unknown DLP mode: %s", c.Mode)
+	}
+	if len(c.Rules) > MaxDLPRules {
+		return fmt.Errorf("too many DLP rules: %d (max %d)", len(c.Rules), MaxDLPRules)
+	}
+	names := make(map[string]bool)
+	for _, r := range c.Rules {
+		if err := r.Validate(); err != nil {
+			return err
+		}
+		if names[r.Name] {
+			return fmt.Errorf("duplicate DLP rule: %s", r.Name)
+		}
+		names[r.Name] = true
+	}
+	if len(c.Ports) > MaxDLPPorts {
+		return fmt.Errorf("too many DLP ports: %d (max %d)", len(c.Ports), MaxDLPPorts)
+	}
+	if len(c.Cgroups) > MaxDLPCgroups {
+		return fmt.Errorf("too many DLP cgroups: %d (max %d)", len(c.Cgroups), MaxDLPCgroups)
+	}
+	for _, p := range c.Ports {
+		if p < 1 || p > 65535 {
+			return fmt.Errorf("invalid DLP port: %d", p)
+		}
+	}
+	for _, cg := range c.Cgroups {
+		if !strings.HasPrefix(cg, "/") && !isSystemdUnit(cg) {
+			return fmt.Errorf("invalid DLP cgroup %s: want an absolute cgroup v2 path or a systemd unit", cg)
+		}
+	}
+	return nil
+}
+
+// isSystemdUnit reports whether name looks like a unit that owns a cgroup
+func isSystemdUnit(name string) bool {
+	if strings.Contains(name, "/") {
+		return false
+	}
+	for _, suffix := range []string{".service", ".scope", ".slice"} {
+		if strings.HasSuffix(name, suffix) && len(name) > len(suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks that the rule can be encoded for the egress program
+func (r DLPRule) Validate() error {
+	if r.Name == "" {
+		return fmt.Errorf("DLP rule has no name")
+	}
+	switch r.Kind {
+	case DLPRulePrefix, DLPRuleContains:
+		if len(r.Pattern) == 0 || len(r.Pattern) > MaxDLPPatternLen {
+			return fmt.Errorf("DLP rule %s: pattern must be 1-%d bytes", r.Name, MaxDLPPatternLen)
+		}
+	case DLPRuleBase64:
+		if r.Threshold < 1 || r.Threshold > 100 {
+			return fmt.Errorf("DLP rule %s: threshold must be 1-100 percent", r.Name)
+		}
+	default:
+		return fmt.Errorf("DLP rule %s: unknown kind %s", r.Name, r.Kind)
+	}
+	return nil
+}
+
+// ParseDLPRule parses a rule written as 'name kind pattern'. The pattern may
+// be a double-quoted Go string for spaces and escapes such as \x00; for
+// base64 rules it is the threshold percentage.
+func ParseDLPRule(line string) (DLPRule, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 3 {
+		return DLPRule{}, fmt.Errorf("invalid DLP rule %q: want 'name kind pattern'", line)
+	}
+
+	r := DLPRule{Name: fields[0], Kind: DLPRuleKind(strings.ToLower(fields[1]))}
+	// Everything after the kind, so quoted patterns may contain spaces
+	pattern := strings.TrimSpace(line)
+	pattern = strings.TrimSpace(pattern[len(fields[0]):])
+	pattern = strings.TrimSpace(pattern[len(fields[1]):])
+
+	if r.Kind == DLPRuleBase64 {
+		threshold, err := strconv.Atoi(pattern)
+		if err != nil {
+			return DLPRule{}, fmt.Errorf("DLP rule %s: invalid threshold: %s", r.Name, pattern)
+		}
+		r.Threshold = threshold
+	} else if strings.HasPrefix(pattern, `"`) {
+		unquoted, err := strconv.Unquote(pattern)
+		if err != nil {
+			return DLPRule{}, fmt.Errorf("DLP rule %s: invalid quoted pattern: %w", r.Name, err)
+		}
+		r.Pattern = []byte(unquoted)
+	} else {
+		r.Pattern = []byte(pattern)
+	}
+
+	if err := r.Validate(); err != nil {
+		return DLPRule{}, err
+	}
+	return r, nil
+}
+
+// ParseDLPRules reads one rule per line. Blank lines and lines starting
+// with '#' are skipped.
+func ParseDLPRules(r io.Reader) ([]DLPRule, error) {
+	var rules []DLPRule
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule, err := ParseDLPRule(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		rules = append(rules, rule)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
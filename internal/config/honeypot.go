@@ -0,0 +1,233 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// SSHConfiguration controls the SSH honeypot persona
+type SSHConfiguration struct {
+	HostKeyDir string // Host keys are generated here on first start and reused, so the fingerprint stays stable
+}
+
+// DefaultSSHConfig returns default SSH persona configuration
+func DefaultSSHConfig() SSHConfiguration {
+	return SSHConfiguration{
+		HostKeyDir: "/var/lib/phantom-grid/ssh",
+	}
+}
+
+// HTTPConfiguration controls the HTTP honeypot persona
+type HTTPConfiguration struct {
+	SiteDir        string // Site templates are loaded from the subdirectories of this directory, next to the built-in ones (empty = built-in only)
+	RuleDir        string // Classification rule packs are loaded from the .json files of this directory, next to the built-in ones (empty = built-in only)
+	DefaultSite    string // Site served on ports no site claims
+	CertDir        string // The TLS certificate is generated here on first start and reused
+	MaxBodyBytes   int64  // Larger request bodies are captured up to this size
+	MaxUploadBytes int64  // Bodies are read up to this size for the files they upload; at least MaxBodyBytes
+}
+
+// DefaultHTTPConfig returns default HTTP persona configuration
+func DefaultHTTPConfig() HTTPConfiguration {
+	return HTTPConfiguration{
+		DefaultSite:    "wordpress",
+		CertDir:        "/var/lib/phantom-grid/http",
+		MaxBodyBytes:   1 << 20,
+		MaxUploadBytes: 32 << 20,
+	}
+}
+
+// Validate checks the body size limits
+func (c HTTPConfiguration) Validate() error {
+	if c.MaxBodyBytes < 1 {
+		return fmt.Errorf("invalid HTTP body size limit: %d", c.MaxBodyBytes)
+	}
+	if c.MaxUploadBytes < c.MaxBodyBytes {
+		return fmt.Errorf("HTTP upload size limit %d is below the body size limit %d", c.MaxUploadBytes, c.MaxBodyBytes)
+	}
+	if c.DefaultSite == "" {
+		return fmt.Errorf("no default HTTP site")
+	}
+	return nil
+}
+
+// MySQLConfiguration controls the MySQL honeypot persona
+type MySQLConfiguration struct {
+	SchemaDir    string // Databases are loaded from the .json files of this directory, next to the built-in ones (empty = built-in only)
+	SessionQuota int64  // Bytes of rows a connection may insert or update before its tables are full (0 = no limit)
+}
+
+// DefaultMySQLConfig returns default MySQL persona configuration
+func DefaultMySQLConfig() MySQLConfiguration {
+	return MySQLConfiguration{
+		SessionQuota: 1 << 20,
+	}
+}
+
+// FileSystemConfiguration controls the fake file system of the shell personas
+type FileSystemConfiguration struct {
+	Image        string // Directory or tar archive the file system is loaded from (empty = built-in image)
+	ArtifactDir  string // Files attackers write are kept here, named by SHA-256 (empty = not kept)
+	SessionQuota int64  // Bytes a session may write before writes fail with "No space left on device"
+}
+
+// DefaultFileSystemConfig returns default file system configuration
+func DefaultFileSystemConfig() FileSystemConfiguration {
+	return FileSystemConfiguration{
+		ArtifactDir:  "/var/lib/phantom-grid/artifacts",
+		SessionQuota: 16 << 20,
+	}
+}
+
+// RecordingConfiguration controls the recording of shell sessions
+type RecordingConfiguration struct {
+	Dir      string // Sessions are recorded here as asciicast v2 files, named by session ID (empty = not recorded)
+	MaxBytes int64  // A recording stops when it reaches this size (0 = no limit)
+}
+
+// DefaultRecordingConfig returns default session recording configuration
+func DefaultRecordingConfig() RecordingConfiguration {
+	return RecordingConfiguration{
+		Dir:      "/var/lib/phantom-grid/sessions",
+		MaxBytes: 10 << 20,
+	}
+}
+
+// PayloadConfiguration controls the fetching of the payloads download
+// commands in the fake shell point at
+type PayloadConfiguration struct {
+	Fetch          bool   // Download payloads; otherwise only their URLs are logged
+	Proxy          string // HTTP proxy downloads go through, e.g. http://proxy:3128 (empty = direct to public addresses)
+	MaxBytes       int64  // Larger payloads are abandoned
+	TimeoutSeconds int    // Time limit of a download
+}
+
+// DefaultPayloadConfig returns default payload configuration: fetching is
+// off, as it makes the sensor contact attacker infrastructure
+func DefaultPayloadConfig() PayloadConfiguration {
+	return PayloadConfiguration{
+		MaxBytes:       10 << 20,
+		TimeoutSeconds: 30,
+	}
+}
+
+// Validate checks the limits and the proxy URL
+func (c PayloadConfiguration) Validate() error {
+	if c.MaxBytes < 1 {
+		return fmt.Errorf("invalid payload size limit: %d", c.MaxBytes)
+	}
+	if c.TimeoutSeconds < 1 {
+		return fmt.Errorf("invalid payload timeout: %d", c.TimeoutSeconds)
+	}
+	if c.Proxy != "" {
+		u, err := url.Parse(c.Proxy)
+		if err != nil {
+			return fmt.Errorf("invalid payload proxy: %w", err)
+		}
+		if u.Scheme != "http" || u.Host == "" {
+			return fmt.Errorf("invalid payload proxy %q: want http://host:port", c.Proxy)
+		}
+	}
+	return nil
+}
+
+// LoginPolicy defines which credentials the SSH, Telnet and MySQL personas accept
+type LoginPolicy string
+
+const (
+	LoginPolicyAny     LoginPolicy = "any"     // Every password is accepted
+	LoginPolicyList    LoginPolicy = "list"    // Only the configured credentials are accepted
+	LoginPolicyAttempt LoginPolicy = "attempt" // The Nth password of a connection is accepted, whatever it is
+)
+
+// Credential is a user and password pair. "*" matches any value.
+type Credential struct {
+	User     string
+	Password string
+}
+
+// LoginConfiguration controls when a login to a honeypot persona "succeeds"
+// and drops the attacker into the fake shell
+type LoginConfiguration struct {
+	Policy      LoginPolicy
+	Credentials []Credential // List policy
+	Attempt     int          // Attempt policy: the attempt that succeeds, counting from 1
+	MaxAttempts int          // Attempts before the connection is closed (raised to Attempt if lower)
+}
+
+// DefaultLoginConfig returns default login configuration: botnets usually
+// try a single credential per connection, so every password is accepted
+func DefaultLoginConfig() LoginConfiguration {
+	return LoginConfiguration{
+		Policy:      LoginPolicyAny,
+		Credentials: []Credential{},
+		Attempt:     2,
+		MaxAttempts: 3,
+	}
+}
+
+// Accepts reports whether the attempt-th login of a connection, with user and
+// password, succeeds
+func (c LoginConfiguration) Accepts(user, password string, attempt int) bool {
+	switch c.Policy {
+	case LoginPolicyAny:
+		return true
+	case LoginPolicyAttempt:
+		return attempt == c.Attempt
+	case LoginPolicyList:
+		for _, cred := range c.Credentials {
+			if (cred.User == "*" || cred.User == user) && (cred.Password == "*" || cred.Password == password) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// AttemptLimit returns the number of attempts allowed per connection
+func (c LoginConfiguration) AttemptLimit() int {
+	if c.Policy == LoginPolicyAttempt && c.Attempt > c.MaxAttempts {
+		return c.Attempt
+	}
+	return c.MaxAttempts
+}
+
+// Validate checks that the policy can let someone in
+func (c LoginConfiguration) Validate() error {
+	switch c.Policy {
+	case LoginPolicyAny:
+	case LoginPolicyList:
+		if len(c.Credentials) == 0 {
+			return fmt.Errorf("login policy list needs at least one credential")
+		}
+	case LoginPolicyAttempt:
+		if c.Attempt < 1 {
+			return fmt.Errorf("invalid login attempt: %d", c.Attempt)
+		}
+	default:
+		return fmt.Errorf("unknown login policy: %s", c.Policy)
+	}
+	if c.MaxAttempts < 1 {
+		return fmt.Errorf("invalid login attempt limit: %d", c.MaxAttempts)
+	}
+	return nil
+}
+
+// ParseCredentials parses a comma-separated list of 'user:password' pairs.
+// The password may contain colons; either side may be "*".
+func ParseCredentials(s string) ([]Credential, error) {
+	var creds []Credential
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		user, password, ok := strings.Cut(part, ":")
+		if !ok || user == "" {
+			return nil, fmt.Errorf("invalid credential %q: want 'user:password'", part)
+		}
+		creds = append(creds, Credential{User: user, Password: password})
+	}
+	return creds, nil
+}
@@ -0,0 +1,163 @@
+package config
+
+import "fmt"
+
+// TCPOption is a TCP option kind as it appears on the wire
+type TCPOption uint8
+
+const (
+	TCPOptEOL       TCPOption = 0
+	TCPOptNOP       TCPOption = 1
+	TCPOptMSS       TCPOption = 2
+	TCPOptWScale    TCPOption = 3
+	TCPOptSACKOK    TCPOption = 4
+	TCPOptTimestamp TCPOption = 8
+)
+
+// MaxOSProfileOptions is the longest option layout a profile may have
+// (must match OS_PROFILE_MAX_OPTS in programs/phantom_egress.c)
+const MaxOSProfileOptions = 12
+
+// Len returns the encoded size of the option in bytes
+func (o TCPOption) Len() int {
+	switch o {
+	case TCPOptEOL, TCPOptNOP:
+		return 1
+	case TCPOptMSS:
+		return 4
+	case TCPOptWScale:
+		return 3
+	case TCPOptSACKOK:
+		return 2
+	case TCPOptTimestamp:
+		return 10
+	default:
+		return 0
+	}
+}
+
+// OSProfile describes the TCP/IP fingerprint of an operating system as seen
+// by nmap -O and p0f: the fields of a SYN-ACK and the IP header of every reply.
+type OSProfile struct {
+	Name    string
+	TTL     uint8
+	DF      bool        // Don't Fragment bit
+	Window  uint16      // SYN-ACK window
+	MSS     uint16      // Upper bound; the real MSS is kept if smaller
+	Options []TCPOption // SYN-ACK option order, including NOP padding
+}
+
+// OSProfiles are the built-in OS personalities. Window scaling, SACK and
+// timestamps are sent when the kernel negotiated them, with the kernel's
+// values, in the order of Options or after them if Options leaves them out.
+var OSProfiles = []OSProfile{
+	{
+		Name: "windows", TTL: 128, DF: true, Window: 65535, MSS: 1460,
+		Options: []TCPOption{TCPOptMSS, TCPOptNOP, TCPOptWScale, TCPOptSACKOK, TCPOptTimestamp},
+	},
+	{
+		Name: "linux", TTL: 64, DF: true, Window: 65160, MSS: 1460,
+		Options: []TCPOption{TCPOptMSS, TCPOptSACKOK, TCPOptTimestamp, TCPOptNOP, TCPOptWScale},
+	},
+	{
+		Name: "freebsd", TTL: 64, DF: true, Window: 65535, MSS: 1460,
+		Options: []TCPOption{TCPOptMSS, TCPOptNOP, TCPOptWScale, TCPOptSACKOK, TCPOptTimestamp},
+	},
+	{
+		Name: "solaris", TTL: 255, DF: true, Window: 64436, MSS: 1460,
+		Options: []TCPOption{TCPOptNOP, TCPOptNOP, TCPOptTimestamp, TCPOptMSS, TCPOptNOP, TCPOptWScale, TCPOptNOP, TCPOptNOP, TCPOptSACKOK},
+	},
+	{
+		Name: "cisco-ios", TTL: 255, DF: false, Window: 4128, MSS: 536,
+		Options: []TCPOption{TCPOptMSS},
+	},
+}
+
+// FindOSProfile returns the built-in profile with the given name
+func FindOSProfile(name string) (OSProfile, bool) {
+	for _, p := range OSProfiles {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return OSProfile{}, false
+}
+
+// Validate checks that the profile can be encoded for the egress program
+func (p OSProfile) Validate() error {
+	if p.Name == "" {
+		return fmt.Errorf("OS profile has no name")
+	}
+	if p.TTL == 0 {
+		return fmt.Errorf("OS profile %s: TTL must be non-zero", p.Name)
+	}
+	if len(p.Options) > MaxOSProfileOptions {
+		return fmt.Errorf("OS profile %s: %d options exceed the limit of %d", p.Name, len(p.Options), MaxOSProfileOptions)
+	}
+	length := 0
+	for _, opt := range p.Options {
+		if opt.Len() == 0 || opt == TCPOptEOL {
+			return fmt.Errorf("OS profile %s: unsupported TCP option kind %d", p.Name, opt)
+		}
+		length += opt.Len()
+	}
+	if length > 40 {
+		return fmt.Errorf("OS profile %s: options need %d bytes, TCP allows 40", p.Name, length)
+	}
+	return nil
+}
+
+// OSPersonalityMode defines how OS profiles are assigned to sources
+type OSPersonalityMode string
+
+const (
+	OSPersonalityOff       OSPersonalityMode = "off"        // Replies keep the host's real fingerprint
+	OSPersonalityFixed     OSPersonalityMode = "fixed"      // Every source sees Profile
+	OSPersonalityPerSource OSPersonalityMode = "per-source" // Each source gets one profile from Profiles for a session
+)
+
+// OSPersonalityConfiguration controls the fingerprint of honeypot replies
+type OSPersonalityConfiguration struct {
+	Mode           OSPersonalityMode
+	Profile        string   // Profile name in fixed mode
+	Profiles       []string // Candidates in per-source mode (empty = all built-in profiles)
+	SessionSeconds int      // Idle time after which a source may get a different profile
+}
+
+// DefaultOSPersonalityConfig returns default OS personality configuration
+func DefaultOSPersonalityConfig() OSPersonalityConfiguration {
+	return OSPersonalityConfiguration{
+		Mode:           OSPersonalityPerSource,
+		Profile:        "linux",
+		Profiles:       []string{},
+		SessionSeconds: 3600,
+	}
+}
+
+// SelectedProfiles returns the profiles to load into the kernel, in table order
+func (c OSPersonalityConfiguration) SelectedProfiles() ([]OSProfile, error) {
+	var names []string
+	switch c.Mode {
+	case OSPersonalityOff:
+		return nil, nil
+	case OSPersonalityFixed:
+		names = []string{c.Profile}
+	case OSPersonalityPerSource:
+		names = c.Profiles
+		if len(names) == 0 {
+			return OSProfiles, nil
+		}
+	default:
+		return nil, fmt.Errorf("unknown OS personality mode: %s", c.Mode)
+	}
+
+	profiles := make([]OSProfile, 0, len(names))
+	for _, name := range names {
+		p, ok := FindOSProfile(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown OS profile: %s", name)
+		}
+		profiles = append(profiles, p)
+	}
+	return profiles, nil
+}
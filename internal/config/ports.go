@@ -2,6 +2,14 @@ package config
 
 import "fmt"
 
+// Protocol is the transport protocol a port definition applies to
+type Protocol string
+
+const (
+	ProtocolTCP Protocol = "tcp"
+	ProtocolUDP Protocol = "udp"
+)
+
 // PortDefinition represents a port with metadata
 type PortDefinition struct {
 	Port        int
@@ -9,6 +17,7 @@ type PortDefinition struct {
 	Description string
 	Category    string
 	Alias       string // For generating C macro name
+	Protocol    Protocol
 }
 
 // PortCategory groups ports by purpose
@@ -29,36 +38,51 @@ const (
 // This list is used to generate both Go config and eBPF C code
 var CriticalPortDefinitions = []PortDefinition{
 	// Core Services - Only FTP and SSH are protected
-	{21, "FTP", "File Transfer Protocol", CategoryFile, "FTP_PORT"},
-	{22, "SSH", "Secure Shell", CategoryCore, "SSH_PORT"},
+	{21, "FTP", "File Transfer Protocol", CategoryFile, "FTP_PORT", ProtocolTCP},
+	{22, "SSH", "Secure Shell", CategoryCore, "SSH_PORT", ProtocolTCP},
 }
 
 // FakePortDefinitions defines ports for honeypot deception
 var FakePortDefinitions = []PortDefinition{
-	{80, "HTTP", "HTTP Web Server", CategoryApplication, "HTTP_PORT"},
-	{443, "HTTPS", "HTTPS Web Server", CategoryApplication, "HTTPS_PORT"},
-	{3306, "MySQL Fake", "MySQL (fake honeypot)", CategoryDatabase, "MYSQL_FAKE_PORT"},
-	{5432, "PostgreSQL Fake", "PostgreSQL (fake honeypot)", CategoryDatabase, "POSTGRES_FAKE_PORT"},
-	{6379, "Redis Fake", "Redis (fake honeypot)", CategoryDatabase, "REDIS_FAKE_PORT"},
-	{27017, "MongoDB Fake", "MongoDB (fake honeypot)", CategoryDatabase, "MONGODB_FAKE_PORT"},
-	{8080, "Admin Panel Fake", "Admin Panel (fake)", CategoryAdmin, "ADMIN_PANEL_FAKE_PORT"},
-	{8443, "HTTPS Alt Fake", "HTTPS Alternative (fake)", CategoryAdmin, "HTTPS_ALT_FAKE_PORT"},
-	{9000, "Admin Panel Fake 2", "Admin Panel (fake)", CategoryAdmin, "ADMIN_PANEL_FAKE_PORT_2"},
-	{21, "FTP", "FTP Server", CategoryFile, "FTP_PORT"},
-	{23, "Telnet", "Telnet Server", CategoryRemote, "TELNET_PORT"},
-	{3389, "RDP Fake", "RDP (fake honeypot)", CategoryRemote, "RDP_FAKE_PORT"},
-	{5900, "VNC", "VNC Server", CategoryRemote, "VNC_PORT"},
-	{1433, "MSSQL Fake", "MSSQL (fake honeypot)", CategoryDatabase, "MSSQL_FAKE_PORT"},
-	{1521, "Oracle Fake", "Oracle (fake honeypot)", CategoryDatabase, "ORACLE_FAKE_PORT"},
-	{5433, "PostgreSQL Alt Fake", "PostgreSQL Alternative (fake)", CategoryDatabase, "POSTGRES_ALT_FAKE_PORT"},
-	{11211, "Memcached Fake", "Memcached (fake)", CategoryCache, "MEMCACHED_FAKE_PORT"},
-	{27018, "MongoDB Shard Fake", "MongoDB Shard (fake)", CategoryDatabase, "MONGODB_SHARD_FAKE_PORT"},
-	{9200, "Elasticsearch Fake", "Elasticsearch (fake)", CategoryAdmin, "ELASTICSEARCH_FAKE_PORT"},
-	{5601, "Kibana Fake", "Kibana (fake)", CategoryAdmin, "KIBANA_FAKE_PORT"},
-	{3000, "Node.js Fake", "Node.js (fake)", CategoryApplication, "NODEJS_FAKE_PORT"},
-	{5000, "Flask Fake", "Flask (fake)", CategoryApplication, "FLASK_FAKE_PORT"},
-	{8000, "Django Fake", "Django (fake)", CategoryApplication, "DJANGO_FAKE_PORT"},
-	{8888, "Jupyter Fake", "Jupyter (fake)", CategoryApplication, "JUPYTER_FAKE_PORT"},
+	{80, "HTTP", "HTTP Web Server", CategoryApplication, "HTTP_PORT", ProtocolTCP},
+	{443, "HTTPS", "HTTPS Web Server", CategoryApplication, "HTTPS_PORT", ProtocolTCP},
+	{3306, "MySQL Fake", "MySQL (fake honeypot)", CategoryDatabase, "MYSQL_FAKE_PORT", ProtocolTCP},
+	{5432, "PostgreSQL Fake", "PostgreSQL (fake honeypot)", CategoryDatabase, "POSTGRES_FAKE_PORT", ProtocolTCP},
+	{6379, "Redis Fake", "Redis (fake honeypot)", CategoryDatabase, "REDIS_FAKE_PORT", ProtocolTCP},
+	{27017, "MongoDB Fake", "MongoDB (fake honeypot)", CategoryDatabase, "MONGODB_FAKE_PORT", ProtocolTCP},
+	{8080, "Admin Panel Fake", "Admin Panel (fake)", CategoryAdmin, "ADMIN_PANEL_FAKE_PORT", ProtocolTCP},
+	{8443, "HTTPS Alt Fake", "HTTPS Alternative (fake)", CategoryAdmin, "HTTPS_ALT_FAKE_PORT", ProtocolTCP},
+	{9000, "Admin Panel Fake 2", "Admin Panel (fake)", CategoryAdmin, "ADMIN_PANEL_FAKE_PORT_2", ProtocolTCP},
+	{21, "FTP", "FTP Server", CategoryFile, "FTP_PORT", ProtocolTCP},
+	{23, "Telnet", "Telnet Server", CategoryRemote, "TELNET_PORT", ProtocolTCP},
+	{3389, "RDP Fake", "RDP (fake honeypot)", CategoryRemote, "RDP_FAKE_PORT", ProtocolTCP},
+	{5900, "VNC", "VNC Server", CategoryRemote, "VNC_PORT", ProtocolTCP},
+	{1433, "MSSQL Fake", "MSSQL (fake honeypot)", CategoryDatabase, "MSSQL_FAKE_PORT", ProtocolTCP},
+	{1521, "Oracle Fake", "Oracle (fake honeypot)", CategoryDatabase, "ORACLE_FAKE_PORT", ProtocolTCP},
+	{5433, "PostgreSQL Alt Fake", "PostgreSQL Alternative (fake)", CategoryDatabase, "POSTGRES_ALT_FAKE_PORT", ProtocolTCP},
+	{11211, "Memcached Fake", "Memcached (fake)", CategoryCache, "MEMCACHED_FAKE_PORT", ProtocolTCP},
+	{27018, "MongoDB Shard Fake", "MongoDB Shard (fake)", CategoryDatabase, "MONGODB_SHARD_FAKE_PORT", ProtocolTCP},
+	{9200, "Elasticsearch Fake", "Elasticsearch (fake)", CategoryAdmin, "ELASTICSEARCH_FAKE_PORT", ProtocolTCP},
+	{5601, "Kibana Fake", "Kibana (fake)", CategoryAdmin, "KIBANA_FAKE_PORT", ProtocolTCP},
+	{3000, "Node.js Fake", "Node.js (fake)", CategoryApplication, "NODEJS_FAKE_PORT", ProtocolTCP},
+	{5000, "Flask Fake", "Flask (fake)", CategoryApplication, "FLASK_FAKE_PORT", ProtocolTCP},
+	{8000, "Django Fake", "Django (fake)", CategoryApplication, "DJANGO_FAKE_PORT", ProtocolTCP},
+	{8888, "Jupyter Fake", "Jupyter (fake)", CategoryApplication, "JUPYTER_FAKE_PORT", ProtocolTCP},
+}
+
+// CriticalUDPPortDefinitions are UDP services hidden behind SPA: XDP drops
+// packets to them unless the source is whitelisted
+var CriticalUDPPortDefinitions = []PortDefinition{
+	{51820, "WireGuard", "WireGuard VPN", CategoryRemote, "WIREGUARD_UDP_PORT", ProtocolUDP},
+}
+
+// FakeUDPPortDefinitions defines UDP services emulated by the honeypot
+var FakeUDPPortDefinitions = []PortDefinition{
+	{53, "DNS Fake", "DNS Server (fake)", CategoryDirectory, "DNS_UDP_FAKE_PORT", ProtocolUDP},
+	{123, "NTP Fake", "NTP Server (fake)", CategoryCore, "NTP_UDP_FAKE_PORT", ProtocolUDP},
+	{161, "SNMP Fake", "SNMP Agent (fake)", CategoryAdmin, "SNMP_UDP_FAKE_PORT", ProtocolUDP},
+	{1900, "SSDP Fake", "UPnP SSDP (fake)", CategoryApplication, "SSDP_UDP_FAKE_PORT", ProtocolUDP},
+	{11211, "Memcached UDP Fake", "Memcached UDP (fake)", CategoryCache, "MEMCACHED_UDP_FAKE_PORT", ProtocolUDP},
 }
 
 // GetCriticalPorts returns list of critical port numbers
@@ -79,7 +103,40 @@ func GetFakePorts() []int {
 	return ports
 }
 
-// FindPortDefinition finds a port definition by port number
+// GetCriticalUDPPorts returns list of critical UDP port numbers
+func GetCriticalUDPPorts() []int {
+	ports := make([]int, len(CriticalUDPPortDefinitions))
+	for i, def := range CriticalUDPPortDefinitions {
+		ports[i] = def.Port
+	}
+	return ports
+}
+
+// GetFakeUDPPorts returns list of fake UDP port numbers
+func GetFakeUDPPorts() []int {
+	ports := make([]int, len(FakeUDPPortDefinitions))
+	for i, def := range FakeUDPPortDefinitions {
+		ports[i] = def.Port
+	}
+	return ports
+}
+
+// FindUDPPortDefinition finds a UDP port definition by port number
+func FindUDPPortDefinition(port int) *PortDefinition {
+	for i := range CriticalUDPPortDefinitions {
+		if CriticalUDPPortDefinitions[i].Port == port {
+			return &CriticalUDPPortDefinitions[i]
+		}
+	}
+	for i := range FakeUDPPortDefinitions {
+		if FakeUDPPortDefinitions[i].Port == port {
+			return &FakeUDPPortDefinitions[i]
+		}
+	}
+	return nil
+}
+
+// FindPortDefinition finds a TCP port definition by port number
 func FindPortDefinition(port int) *PortDefinition {
 	for i := range CriticalPortDefinitions {
 		if CriticalPortDefinitions[i].Port == port {
@@ -112,5 +169,35 @@ func ValidatePorts() error {
 		}
 	}
 
+	// Check UDP definitions
+	seenUDP := make(map[int]bool)
+	for _, def := range CriticalUDPPortDefinitions {
+		if seenUDP[def.Port] {
+			return fmt.Errorf("duplicate critical UDP port: %d (%s)", def.Port, def.Name)
+		}
+		seenUDP[def.Port] = true
+		if def.Port == SPAMagicPort {
+			return fmt.Errorf("critical UDP port %d (%s) conflicts with the SPA magic port", def.Port, def.Name)
+		}
+	}
+	udpDefs := append(append([]PortDefinition{}, CriticalUDPPortDefinitions...), FakeUDPPortDefinitions...)
+	for _, def := range udpDefs {
+		if def.Port < 1 || def.Port > 65535 {
+			return fmt.Errorf("invalid port range: %d (%s)", def.Port, def.Name)
+		}
+	}
+
+	// Each list holds a single protocol
+	for _, def := range append(append([]PortDefinition{}, CriticalPortDefinitions...), FakePortDefinitions...) {
+		if def.Protocol != ProtocolTCP {
+			return fmt.Errorf("port %d (%s) must be %s, got %q", def.Port, def.Name, ProtocolTCP, def.Protocol)
+		}
+	}
+	for _, def := range udpDefs {
+		if def.Protocol != ProtocolUDP {
+			return fmt.Errorf("port %d (%s) must be %s, got %q", def.Port, def.Name, ProtocolUDP, def.Protocol)
+		}
+	}
+
 	return nil
 }
@@ -126,3 +126,27 @@ func TestPortAliases(t *testing.T) {
 	}
 }
 
+
+func TestUDPPortDefinitions(t *testing.T) {
+	if len(GetFakeUDPPorts()) == 0 {
+		t.Fatal("FakeUDPPorts should not be empty")
+	}
+
+	// UDP and TCP lookups are independent
+	def := FindUDPPortDefinition(161) // SNMP
+	if def == nil {
+		t.Fatal("Should find SNMP UDP port definition")
+	}
+	if def.Protocol != ProtocolUDP {
+		t.Errorf("SNMP protocol = %q, want %q", def.Protocol, ProtocolUDP)
+	}
+	if FindPortDefinition(161) != nil {
+		t.Error("SNMP should not be a TCP port definition")
+	}
+
+	for _, port := range GetCriticalUDPPorts() {
+		if port == SPAMagicPort {
+			t.Errorf("critical UDP port %d conflicts with the SPA magic port", port)
+		}
+	}
+}
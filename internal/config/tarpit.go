@@ -0,0 +1,115 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// TarpitMode defines how connections to a tarpit port are stalled
+type TarpitMode string
+
+const (
+	TarpitModeXDP      TarpitMode = "xdp"      // XDP answers SYNs with zero-window SYN-ACKs; no socket is used
+	TarpitModeHoneypot TarpitMode = "honeypot" // The honeypot accepts and trickles a byte at a time
+)
+
+// TarpitRange is an inclusive port range handled by the tarpit
+type TarpitRange struct {
+	From int
+	To   int
+	Mode TarpitMode
+}
+
+// TarpitConfiguration controls the tarpit for unprotected ports. Critical and
+// fake ports are never tarpitted.
+type TarpitConfiguration struct {
+	Enabled                bool
+	Ranges                 []TarpitRange
+	MaxPerSource           int // Stalled connections per source, per mode (0 = unlimited)
+	MaxConnections         int // Connections the honeypot holds open at once
+	FlowTimeoutSeconds     int // Idle time after which a stalled connection is released
+	TrickleIntervalSeconds int // Delay between bytes in honeypot mode
+}
+
+// DefaultTarpitConfig returns default tarpit configuration
+func DefaultTarpitConfig() TarpitConfiguration {
+	return TarpitConfiguration{
+		Enabled:                false,
+		Ranges:                 []TarpitRange{},
+		MaxPerSource:           32,
+		MaxConnections:         1024,
+		FlowTimeoutSeconds:     600,
+		TrickleIntervalSeconds: 10,
+	}
+}
+
+// ModeForPort returns the tarpit mode of port, if a range covers it.
+// The first matching range wins.
+func (c TarpitConfiguration) ModeForPort(port int) (TarpitMode, bool) {
+	if !c.Enabled {
+		return "", false
+	}
+	for _, r := range c.Ranges {
+		if port >= r.From && port <= r.To {
+			return r.Mode, true
+		}
+	}
+	return "", false
+}
+
+// Validate checks the ranges and limits
+func (c TarpitConfiguration) Validate() error {
+	for _, r := range c.Ranges {
+		if r.From < 1 || r.To > 65535 || r.From > r.To {
+			return fmt.Errorf("invalid tarpit port range: %d-%d", r.From, r.To)
+		}
+		if r.Mode != TarpitModeXDP && r.Mode != TarpitModeHoneypot {
+			return fmt.Errorf("unknown tarpit mode: %s", r.Mode)
+		}
+	}
+	if c.MaxPerSource < 0 || c.MaxConnections < 0 {
+		return fmt.Errorf("tarpit connection limits must not be negative")
+	}
+	if c.FlowTimeoutSeconds <= 0 || c.TrickleIntervalSeconds <= 0 {
+		return fmt.Errorf("tarpit timeout and trickle interval must be positive")
+	}
+	return nil
+}
+
+// ParseTarpitRanges parses a comma-separated list of 'port', 'from-to',
+// 'port:mode' or 'from-to:mode' entries. The mode defaults to xdp.
+func ParseTarpitRanges(value string) ([]TarpitRange, error) {
+	var ranges []TarpitRange
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+
+		ports, mode, hasMode := strings.Cut(field, ":")
+		r := TarpitRange{Mode: TarpitModeXDP}
+		if hasMode {
+			r.Mode = TarpitMode(strings.ToLower(strings.TrimSpace(mode)))
+		}
+
+		from, to, isRange := strings.Cut(ports, "-")
+		var err error
+		if r.From, err = strconv.Atoi(strings.TrimSpace(from)); err != nil {
+			return nil, fmt.Errorf("invalid tarpit port: %s", from)
+		}
+		r.To = r.From
+		if isRange {
+			if r.To, err = strconv.Atoi(strings.TrimSpace(to)); err != nil {
+				return nil, fmt.Errorf("invalid tarpit port: %s", to)
+			}
+		}
+		ranges = append(ranges, r)
+	}
+
+	cfg := TarpitConfiguration{Ranges: ranges, FlowTimeoutSeconds: 1, TrickleIntervalSeconds: 1}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return ranges, nil
+}
@@ -8,6 +8,7 @@ import (
 
 	ui "github.com/gizak/termui/v3"
 
+	"phantom-grid/internal/cidrlist"
 	"phantom-grid/internal/ebpf"
 )
 
@@ -22,17 +23,37 @@ type Dashboard struct {
 	activeSessions uint64
 	totalCommands  uint64
 	logChan       <-chan string
+	cidrMaps      *cidrlist.Maps
+	tarpitSource  func() (ebpf.TarpitStats, int)
+	dlpSource     func() ([]ebpf.DLPRuleHits, uint64, error)
 }
 
 // New creates a new Dashboard instance
 func New(iface string, phantomObjs *ebpf.PhantomObjects, egressObjs *ebpf.EgressObjects, logChan <-chan string) *Dashboard {
-	return &Dashboard{
+	d := &Dashboard{
 		phantomObjs: phantomObjs,
 		egressObjs:  egressObjs,
 		iface:       iface,
 		startTime:   time.Now(),
 		logChan:     logChan,
 	}
+	if phantomObjs != nil {
+		d.cidrMaps = cidrlist.NewMaps(phantomObjs.CidrAllowV4, phantomObjs.CidrAllowV6,
+			phantomObjs.CidrDenyV4, phantomObjs.CidrDenyV6)
+	}
+	return d
+}
+
+// SetTarpitSource sets where stalled tarpit connections are read from:
+// the XDP tarpit counters and the number of connections held by the honeypot
+func (d *Dashboard) SetTarpitSource(source func() (ebpf.TarpitStats, int)) {
+	d.tarpitSource = source
+}
+
+// SetDLPSource sets where the egress DLP counters are read from:
+// the hits of each rule and the number of payloads blocked
+func (d *Dashboard) SetDLPSource(source func() ([]ebpf.DLPRuleHits, uint64, error)) {
+	d.dlpSource = source
 }
 
 // Start initializes and runs the dashboard
@@ -2,16 +2,21 @@ package dashboard
 
 import (
 	"fmt"
+	"sort"
+	"strings"
 	"time"
 
 	ui 	"github.com/gizak/termui/v3"
+
+	"phantom-grid/internal/cidrlist"
+	"phantom-grid/internal/ebpf"
 )
 
 // runEventLoop runs the main dashboard event loop
 func (d *Dashboard) runEventLoop(w *DashboardWidgets) {
 	// Initial render
 	ui.Render(w.header, w.logList, w.gauge, w.redirectedBox, w.stealthBox, w.egressBox,
-		w.osMutationsBox, w.spaSuccessBox, w.spaFailedBox, w.systemInfoBox, w.connStatsBox, w.footer)
+		w.osMutationsBox, w.spaSuccessBox, w.spaFailedBox, w.systemInfoBox, w.cidrBox, w.connStatsBox, w.dropReasonsBox, w.footer)
 
 	ticker := time.NewTicker(200 * time.Millisecond)
 	statsTicker := time.NewTicker(1 * time.Second)
@@ -93,9 +98,9 @@ func (d *Dashboard) runEventLoop(w *DashboardWidgets) {
 
 // updateStatistics updates all dashboard statistics
 func (d *Dashboard) updateStatistics(w *DashboardWidgets, lastAttackCount *uint64) {
-	var attackKey uint32 = 0
-	var attackVal uint64
-	if err := d.phantomObjs.AttackStats.Lookup(attackKey, &attackVal); err == nil {
+	// Kernel counters are per-CPU; SumCounter aggregates them
+	attackVal, err := ebpf.SumCounter(d.phantomObjs.AttackStats, 0)
+	if err == nil {
 		w.redirectedBox.Text = fmt.Sprintf("\n\n   %d", attackVal)
 
 		if attackVal > *lastAttackCount {
@@ -103,38 +108,36 @@ func (d *Dashboard) updateStatistics(w *DashboardWidgets, lastAttackCount *uint6
 		}
 	}
 
-	var stealthKey uint32 = 0
-	var stealthVal uint64
-	if err := d.phantomObjs.StealthDrops.Lookup(stealthKey, &stealthVal); err == nil {
-		w.stealthBox.Text = fmt.Sprintf("\n\n   %d", stealthVal)
+	stealthVal, err := ebpf.SumCounter(d.phantomObjs.StealthDrops, 0)
+	if err == nil {
+		replies, _ := ebpf.SumCounter(d.phantomObjs.StealthReplies, 0)
+		w.stealthBox.Text = fmt.Sprintf("\n   %d\n   RST: %d", stealthVal, replies)
 	}
 
-	var osKey uint32 = 0
-	var osVal uint64
-	if err := d.phantomObjs.OsMutations.Lookup(osKey, &osVal); err == nil {
+	if osVal, err := ebpf.SumCounter(d.phantomObjs.OsMutations, 0); err == nil {
 		w.osMutationsBox.Text = fmt.Sprintf("\n\n   %d", osVal)
 	}
 
-	var spaSuccessKey uint32 = 0
-	var spaSuccessVal uint64
-	if err := d.phantomObjs.SpaAuthSuccess.Lookup(spaSuccessKey, &spaSuccessVal); err == nil {
+	if spaSuccessVal, err := ebpf.SumCounter(d.phantomObjs.SpaAuthSuccess, 0); err == nil {
 		w.spaSuccessBox.Text = fmt.Sprintf("\n\n   %d", spaSuccessVal)
 	}
 
-	var spaFailedKey uint32 = 0
-	var spaFailedVal uint64
-	if err := d.phantomObjs.SpaAuthFailed.Lookup(spaFailedKey, &spaFailedVal); err == nil {
+	if spaFailedVal, err := ebpf.SumCounter(d.phantomObjs.SpaAuthFailed, 0); err == nil {
 		w.spaFailedBox.Text = fmt.Sprintf("\n\n   %d", spaFailedVal)
 	}
 
-	if d.egressObjs != nil && d.egressObjs.EgressBlocks != nil {
-		var egressKey uint32 = 0
-		var egressVal uint64
-		if err := d.egressObjs.EgressBlocks.Lookup(egressKey, &egressVal); err == nil {
-			w.egressBox.Text = fmt.Sprintf("\n\n   %d", egressVal)
+	if d.dlpSource != nil {
+		if hits, blocked, err := d.dlpSource(); err == nil {
+			w.egressBox.Text = formatDLP(hits, blocked)
 		}
 	}
 
+	if drops, err := ebpf.ReadDropStats(d.phantomObjs.DropReasons); err == nil {
+		w.dropReasonsBox.Text = formatDropReasons(drops)
+	}
+
+	d.updateCIDRHits(w)
+
 	// Update connection statistics
 	d.statsMutex.RLock()
 	connCount := d.honeypotConns
@@ -142,8 +145,14 @@ func (d *Dashboard) updateStatistics(w *DashboardWidgets, lastAttackCount *uint6
 	cmdCount := d.totalCommands
 	d.statsMutex.RUnlock()
 
-	w.connStatsBox.Text = fmt.Sprintf("\n\nHoneypot Connections: %d\nActive Sessions: %d\nTotal Commands: %d",
-		connCount, sessionCount, cmdCount)
+	var tarpit ebpf.TarpitStats
+	var tarpitHeld int
+	if d.tarpitSource != nil {
+		tarpit, tarpitHeld = d.tarpitSource()
+	}
+
+	w.connStatsBox.Text = fmt.Sprintf("Honeypot Connections: %d | Active Sessions: %d\nTotal Commands: %d\n%s",
+		connCount, sessionCount, cmdCount, formatTarpit(tarpit, tarpitHeld))
 
 	// Calculate threat level
 	totalThreats := attackVal + stealthVal
@@ -166,7 +175,61 @@ func (d *Dashboard) updateStatistics(w *DashboardWidgets, lastAttackCount *uint6
 	}
 
 	ui.Render(w.redirectedBox, w.stealthBox, w.egressBox, w.osMutationsBox,
-		w.spaSuccessBox, w.spaFailedBox, w.gauge, w.connStatsBox)
+		w.spaSuccessBox, w.spaFailedBox, w.gauge, w.cidrBox, w.connStatsBox, w.dropReasonsBox)
+}
+
+// formatTarpit summarizes connections stalled by the XDP tarpit and the honeypot
+func formatTarpit(s ebpf.TarpitStats, held int) string {
+	return fmt.Sprintf("Tarpit Stalled: %d (XDP: %d, honeypot: %d)  Capped: %d",
+		s.Flows+held, s.Flows, held, s.Capped)
+}
+
+// formatDLP shows the blocked payload count and the rules matched most often
+func formatDLP(hits []ebpf.DLPRuleHits, blocked uint64) string {
+	var total uint64
+	matched := make([]ebpf.DLPRuleHits, 0, len(hits))
+	for _, h := range hits {
+		total += h.Hits
+		if h.Hits > 0 {
+			matched = append(matched, h)
+		}
+	}
+	sort.SliceStable(matched, func(i, j int) bool { return matched[i].Hits > matched[j].Hits })
+
+	top := make([]string, 0, 3)
+	for i := 0; i < len(matched) && i < 3; i++ {
+		top = append(top, fmt.Sprintf("%s: %d", matched[i].Rule, matched[i].Hits))
+	}
+	return fmt.Sprintf("\n   Blocked: %d  Matches: %d\n   %s", blocked, total, strings.Join(top, "  "))
+}
+
+// formatDropReasons lays out the per-reason drop counters in three rows
+func formatDropReasons(s ebpf.DropStats) string {
+	return fmt.Sprintf("Critical: %d  Rate: %d  Banned: %d  CIDR: %d\nXmas: %d  Null: %d  FIN: %d  ACK: %d\nMalformed: %d  Fragments: %d",
+		s.Get(ebpf.DropCriticalPort), s.Get(ebpf.DropRateLimited), s.Get(ebpf.DropBlocklisted), s.Get(ebpf.DropCIDRDeny),
+		s.Get(ebpf.DropStealthXmas), s.Get(ebpf.DropStealthNull), s.Get(ebpf.DropStealthFIN), s.Get(ebpf.DropStealthACK),
+		s.Get(ebpf.DropMalformed), s.Get(ebpf.DropFragment))
+}
+
+// updateCIDRHits lists CIDR allow/deny prefixes by hit count
+func (d *Dashboard) updateCIDRHits(w *DashboardWidgets) {
+	if d.cidrMaps == nil {
+		return
+	}
+	stats, err := d.cidrMaps.Stats()
+	if err != nil || len(stats) == 0 {
+		return
+	}
+
+	rows := make([]string, 0, len(stats))
+	for _, s := range stats {
+		color := "green"
+		if s.Kind == cidrlist.Deny {
+			color = "red"
+		}
+		rows = append(rows, fmt.Sprintf("[%-5s](fg:%s) %-20s %d", strings.ToUpper(s.Kind.String()), color, s.Prefix, s.Hits))
+	}
+	w.cidrBox.Rows = rows
 }
 
 // handleLogMessage processes log messages and updates UI
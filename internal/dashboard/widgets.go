@@ -7,6 +7,7 @@ import (
 	"github.com/gizak/termui/v3/widgets"
 
 	"phantom-grid/internal/config"
+	"phantom-grid/internal/ebpf"
 )
 
 // DashboardWidgets holds all UI widgets
@@ -21,7 +22,9 @@ type DashboardWidgets struct {
 	spaSuccessBox *widgets.Paragraph
 	spaFailedBox  *widgets.Paragraph
 	systemInfoBox *widgets.Paragraph
+	cidrBox       *widgets.List
 	connStatsBox  *widgets.Paragraph
+	dropReasonsBox *widgets.Paragraph
 	footer        *widgets.Paragraph
 }
 
@@ -72,15 +75,15 @@ func (d *Dashboard) createWidgets(termWidth, termHeight int) *DashboardWidgets {
 	w.redirectedBox.BorderStyle.Fg = ui.ColorYellow
 
 	w.stealthBox = widgets.NewParagraph()
-	w.stealthBox.Title = " ═══ STEALTH SCAN DROPS ═══ "
+	w.stealthBox.Title = " ═══ STEALTH SCANS ═══ "
 	w.stealthBox.Text = "\n\n       0"
 	w.stealthBox.SetRect(termWidth/2+25, 6, termWidth/2+40, 11)
 	w.stealthBox.TextStyle.Fg = ui.ColorRed
 	w.stealthBox.BorderStyle.Fg = ui.ColorRed
 
 	w.egressBox = widgets.NewParagraph()
-	w.egressBox.Title = " ═══ EGRESS BLOCKS (DLP) ═══ "
-	w.egressBox.Text = "\n\n       0"
+	w.egressBox.Title = " ═══ EGRESS DLP ═══ "
+	w.egressBox.Text = formatDLP(nil, 0)
 	w.egressBox.SetRect(termWidth/2+40, 6, termWidth, 11)
 	w.egressBox.TextStyle.Fg = ui.ColorMagenta
 	w.egressBox.BorderStyle.Fg = ui.ColorMagenta
@@ -117,16 +120,36 @@ func (d *Dashboard) createWidgets(termWidth, termHeight int) *DashboardWidgets {
 	}
 	w.systemInfoBox.Text = fmt.Sprintf("\nInterface: %s\nXDP Hook: [ACTIVE](fg:green)\nTC Egress: [%s](fg:%s)\nHoneypot: [LISTENING](fg:green)\nPort: %d\nSPA Port: %d\nSSH Port: %d (Protected)",
 		d.iface, egressStatus, egressColor, config.HoneypotPort, config.SPAMagicPort, config.SSHPort)
-	w.systemInfoBox.SetRect(termWidth/2+10, 16, termWidth, termHeight-8)
+	// System info takes what it needs; the CIDR list hit counters get the rest
+	infoBottom := termHeight - 8
+	if infoBottom > 26 {
+		infoBottom = 26
+	}
+	w.systemInfoBox.SetRect(termWidth/2+10, 16, termWidth, infoBottom)
 	w.systemInfoBox.BorderStyle.Fg = ui.ColorBlue
 
+	// CIDR list hits
+	w.cidrBox = widgets.NewList()
+	w.cidrBox.Title = " ═══ CIDR LIST HITS ═══ "
+	w.cidrBox.Rows = []string{"No CIDR lists loaded"}
+	w.cidrBox.SetRect(termWidth/2+10, infoBottom, termWidth, termHeight-8)
+	w.cidrBox.TextStyle.Fg = ui.ColorWhite
+	w.cidrBox.BorderStyle.Fg = ui.ColorBlue
+
 	// Connection stats
 	w.connStatsBox = widgets.NewParagraph()
 	w.connStatsBox.Title = " ═══ CONNECTION STATISTICS ═══ "
-	w.connStatsBox.Text = "\n\nHoneypot Connections: 0\nActive Sessions: 0\nTotal Commands: 0"
+	w.connStatsBox.Text = "Honeypot Connections: 0 | Active Sessions: 0\nTotal Commands: 0\n" + formatTarpit(ebpf.TarpitStats{}, 0)
 	w.connStatsBox.SetRect(0, termHeight-8, termWidth/2+10, termHeight-3)
 	w.connStatsBox.BorderStyle.Fg = ui.ColorMagenta
 
+	// Drops by reason
+	w.dropReasonsBox = widgets.NewParagraph()
+	w.dropReasonsBox.Title = " ═══ DROPS BY REASON ═══ "
+	w.dropReasonsBox.Text = formatDropReasons(ebpf.DropStats{})
+	w.dropReasonsBox.SetRect(termWidth/2+10, termHeight-8, termWidth, termHeight-3)
+	w.dropReasonsBox.BorderStyle.Fg = ui.ColorRed
+
 	// Footer
 	w.footer = widgets.NewParagraph()
 	w.footer.Title = " CONTROLS "
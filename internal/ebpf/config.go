@@ -0,0 +1,112 @@
+package ebpf
+
+import (
+	"fmt"
+
+	"phantom-grid/internal/config"
+)
+
+// xdp_config keys (must match XDP_CONFIG_* in programs/phantom.c)
+const (
+	xdpConfigSteeringMode   uint32 = 0
+	xdpConfigRateSPAPPS     uint32 = 1
+	xdpConfigRateSPABurst   uint32 = 2
+	xdpConfigRateSYNPPS     uint32 = 3
+	xdpConfigRateSYNBurst   uint32 = 4
+	xdpConfigRateTotalPPS   uint32 = 5
+	xdpConfigRateTotalBurst uint32 = 6
+	xdpConfigFragmentPolicy uint32 = 7
+	xdpConfigStealthXmas    uint32 = 9 // XDP_CONFIG_STEALTH_RESPONSE
+	xdpConfigStealthNull    uint32 = 10
+	xdpConfigStealthFIN     uint32 = 11
+	xdpConfigStealthACK     uint32 = 12
+)
+
+// Steering mode values (must match STEERING_MODE_* in programs/phantom.c)
+const (
+	steeringModeRedirect uint32 = 0
+	steeringModeSkLookup uint32 = 1
+)
+
+// Fragment policy values (must match FRAGMENT_POLICY_* in programs/phantom.c)
+const (
+	fragmentPolicyPass uint32 = 0
+	fragmentPolicyDrop uint32 = 1
+)
+
+// Stealth scan responses (must match STEALTH_RESPONSE_* in programs/phantom.c)
+const (
+	stealthResponseDrop      uint32 = 0
+	stealthResponseRSTOpen   uint32 = 1
+	stealthResponseRSTClosed uint32 = 2
+	stealthResponseRandom    uint32 = 3
+)
+
+// SetSteeringMode tells the XDP program how unprotected ports reach the honeypot
+func (l *Loader) SetSteeringMode(mode config.SteeringMode) error {
+	var value uint32
+	switch mode {
+	case config.SteeringModeRedirect:
+		value = steeringModeRedirect
+	case config.SteeringModeSkLookup:
+		value = steeringModeSkLookup
+	default:
+		return fmt.Errorf("unknown steering mode: %s", mode)
+	}
+
+	if err := l.PhantomObjs.XdpConfig.Put(xdpConfigSteeringMode, value); err != nil {
+		return fmt.Errorf("failed to set steering mode: %w", err)
+	}
+	return nil
+}
+
+// SetFragmentPolicy sets how the XDP program handles non-first IPv4 fragments
+func (l *Loader) SetFragmentPolicy(policy config.FragmentPolicy) error {
+	var value uint32
+	switch policy {
+	case config.FragmentPolicyPass:
+		value = fragmentPolicyPass
+	case config.FragmentPolicyDrop:
+		value = fragmentPolicyDrop
+	default:
+		return fmt.Errorf("unknown fragment policy: %s", policy)
+	}
+
+	if err := l.PhantomObjs.XdpConfig.Put(xdpConfigFragmentPolicy, value); err != nil {
+		return fmt.Errorf("failed to set fragment policy: %w", err)
+	}
+	return nil
+}
+
+// SetStealthResponses sets how the XDP program answers each stealth scan type
+func (l *Loader) SetStealthResponses(cfg config.StealthResponseConfiguration) error {
+	responses := []struct {
+		key      uint32
+		response config.StealthResponse
+	}{
+		{xdpConfigStealthXmas, cfg.Xmas},
+		{xdpConfigStealthNull, cfg.Null},
+		{xdpConfigStealthFIN, cfg.FIN},
+		{xdpConfigStealthACK, cfg.ACK},
+	}
+	for _, r := range responses {
+		var value uint32
+		switch r.response {
+		case config.StealthResponseDrop:
+			value = stealthResponseDrop
+		case config.StealthResponseRSTOpen:
+			value = stealthResponseRSTOpen
+		case config.StealthResponseRSTClosed:
+			value = stealthResponseRSTClosed
+		case config.StealthResponseRandom:
+			value = stealthResponseRandom
+		default:
+			return fmt.Errorf("unknown stealth scan response: %s", r.response)
+		}
+
+		if err := l.PhantomObjs.XdpConfig.Put(r.key, value); err != nil {
+			return fmt.Errorf("failed to set stealth scan response: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,329 @@
+package ebpf
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/link"
+	"golang.org/x/sys/unix"
+
+	"phantom-grid/internal/config"
+)
+
+// egress_config keys for DLP (must match EGRESS_CONFIG_DLP_* in programs/phantom_egress.c)
+const (
+	egressConfigDLPMode      uint32 = 4
+	egressConfigDLPRuleCount uint32 = 5
+)
+
+// cgroupRoot is where the cgroup v2 hierarchy is mounted
+const cgroupRoot = "/sys/fs/cgroup"
+
+// DLP modes (must match DLP_MODE_* in programs/phantom_egress.c)
+const (
+	dlpModeOff     uint32 = 0
+	dlpModeMonitor uint32 = 1
+	dlpModeEnforce uint32 = 2
+)
+
+// DLP rule kinds (must match DLP_RULE_* in programs/phantom_egress.c)
+const (
+	dlpKindPrefix   uint8 = 1
+	dlpKindContains uint8 = 2
+	dlpKindBase64   uint8 = 3
+)
+
+// dlpRuleEntry mirrors struct dlp_rule in programs/phantom_egress.c
+type dlpRuleEntry struct {
+	Kind    uint8
+	Len     uint8
+	_       [2]uint8
+	Pattern [config.MaxDLPPatternLen]uint8
+}
+
+// DLPRuleHits is the number of payloads that matched a rule
+type DLPRuleHits struct {
+	Rule string
+	Hits uint64
+}
+
+func encodeDLPRule(r config.DLPRule) (dlpRuleEntry, error) {
+	if err := r.Validate(); err != nil {
+		return dlpRuleEntry{}, err
+	}
+
+	var entry dlpRuleEntry
+	switch r.Kind {
+	case config.DLPRulePrefix:
+		entry.Kind = dlpKindPrefix
+	case config.DLPRuleContains:
+		entry.Kind = dlpKindContains
+	case config.DLPRuleBase64:
+		entry.Kind = dlpKindBase64
+		entry.Len = uint8(r.Threshold)
+		return entry, nil
+	}
+	entry.Len = uint8(len(r.Pattern))
+	copy(entry.Pattern[:], r.Pattern)
+	return entry, nil
+}
+
+// ConfigureDLP loads the DLP rules and the ports whose traffic is inspected,
+// attaches the cgroup DLP program to the configured cgroups, then sets the
+// mode. Hit counters of rules loaded earlier are reset, since rule indexes
+// may have changed.
+func (l *Loader) ConfigureDLP(cfg config.DLPConfiguration) error {
+	if l.EgressObjs == nil {
+		return ErrEgressNotLoaded
+	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	objs := l.EgressObjs
+	// Stop inspecting while the rules change
+	if err := objs.EgressConfig.Put(egressConfigDLPMode, dlpModeOff); err != nil {
+		return fmt.Errorf("failed to set DLP mode: %w", err)
+	}
+	l.setDLPRules(nil)
+	if cfg.Mode == config.DLPModeOff {
+		return l.detachDLPCgroups()
+	}
+
+	names := make([]string, len(cfg.Rules))
+	for i, r := range cfg.Rules {
+		entry, err := encodeDLPRule(r)
+		if err != nil {
+			return err
+		}
+		if err := objs.DlpRules.Put(uint32(i), entry); err != nil {
+			return fmt.Errorf("failed to load DLP rule %s: %w", r.Name, err)
+		}
+		if err := resetPerCPUCounter(objs.DlpHits, uint32(i)); err != nil {
+			return fmt.Errorf("failed to reset DLP rule hits: %w", err)
+		}
+		names[i] = r.Name
+	}
+
+	if err := clearMap[uint16, uint8](objs.DlpPorts); err != nil {
+		return fmt.Errorf("failed to clear DLP ports: %w", err)
+	}
+	for _, port := range cfg.Ports {
+		if err := objs.DlpPorts.Put(uint16(port), uint8(1)); err != nil {
+			return fmt.Errorf("failed to add DLP port %d: %w", port, err)
+		}
+	}
+
+	if err := clearMap[uint64, uint8](objs.DlpCgroups); err != nil {
+		return fmt.Errorf("failed to clear DLP cgroups: %w", err)
+	}
+	paths := make([]string, len(cfg.Cgroups))
+	for i, name := range cfg.Cgroups {
+		path, err := resolveCgroup(name)
+		if err != nil {
+			return err
+		}
+		id, err := cgroupID(path)
+		if err != nil {
+			return err
+		}
+		if err := objs.DlpCgroups.Put(id, uint8(1)); err != nil {
+			return fmt.Errorf("failed to add DLP cgroup %s: %w", path, err)
+		}
+		paths[i] = path
+	}
+	if err := l.attachDLPCgroups(paths); err != nil {
+		return err
+	}
+
+	mode := dlpModeMonitor
+	if cfg.Mode == config.DLPModeEnforce {
+		mode = dlpModeEnforce
+	}
+	settings := []struct {
+		key   uint32
+		value uint32
+	}{
+		{egressConfigDLPRuleCount, uint32(len(cfg.Rules))},
+		{egressConfigDLPMode, mode},
+	}
+	for _, s := range settings {
+		if err := objs.EgressConfig.Put(s.key, s.value); err != nil {
+			return fmt.Errorf("failed to configure DLP: %w", err)
+		}
+	}
+	l.setDLPRules(names)
+	return nil
+}
+
+// DLPStats returns the hits of each loaded rule, in rule order, and the
+// number of payloads dropped in enforce mode
+func (l *Loader) DLPStats() ([]DLPRuleHits, uint64, error) {
+	if l.EgressObjs == nil {
+		return nil, 0, ErrEgressNotLoaded
+	}
+
+	var blocked uint64
+	if err := l.EgressObjs.EgressBlocks.Lookup(uint32(0), &blocked); err != nil {
+		return nil, 0, fmt.Errorf("failed to read egress blocks: %w", err)
+	}
+
+	l.dlpMutex.RLock()
+	names := l.dlpRules
+	l.dlpMutex.RUnlock()
+
+	hits := make([]DLPRuleHits, len(names))
+	for i, name := range names {
+		n, err := SumCounter(l.EgressObjs.DlpHits, uint32(i))
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to read DLP rule hits: %w", err)
+		}
+		hits[i] = DLPRuleHits{Rule: name, Hits: n}
+	}
+	return hits, blocked, nil
+}
+
+func (l *Loader) setDLPRules(names []string) {
+	l.dlpMutex.Lock()
+	l.dlpRules = names
+	l.dlpMutex.Unlock()
+}
+
+// dlpRuleName returns the name of the rule at index, as loaded by ConfigureDLP
+func (l *Loader) dlpRuleName(index uint8) string {
+	l.dlpMutex.RLock()
+	defer l.dlpMutex.RUnlock()
+	if int(index) < len(l.dlpRules) {
+		return l.dlpRules[index]
+	}
+	return fmt.Sprintf("rule_%d", index)
+}
+
+// attachDLPCgroups replaces the cgroup DLP attachments with one per path
+func (l *Loader) attachDLPCgroups(paths []string) error {
+	if err := l.detachDLPCgroups(); err != nil {
+		return err
+	}
+	for _, path := range paths {
+		lnk, err := link.AttachCgroup(link.CgroupOptions{
+			Path:    path,
+			Attach:  ebpf.AttachCGroupInetEgress,
+			Program: l.EgressObjs.PhantomDlpCgroup,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to attach DLP to cgroup %s: %w", path, err)
+		}
+		l.dlpCgroupLinks = append(l.dlpCgroupLinks, lnk)
+	}
+	return nil
+}
+
+func (l *Loader) detachDLPCgroups() error {
+	for len(l.dlpCgroupLinks) > 0 {
+		if err := l.dlpCgroupLinks[0].Close(); err != nil {
+			return fmt.Errorf("failed to detach DLP from cgroup: %w", err)
+		}
+		l.dlpCgroupLinks = l.dlpCgroupLinks[1:]
+	}
+	return nil
+}
+
+// AttachDLPOwnerProbe loads and attaches the probe that records which
+// process sends on each socket of the DLP cgroups, so cgroup DLP events carry
+// a PID and command name. It needs kernel BTF (Linux 5.5+); without it, events
+// are reported without a process.
+func (l *Loader) AttachDLPOwnerProbe() error {
+	if l.EgressObjs == nil {
+		return ErrEgressNotLoaded
+	}
+	if l.dlpOwnerLink != nil {
+		return nil
+	}
+
+	objs := &DlpOwnerObjects{}
+	opts := &ebpf.CollectionOptions{
+		MapReplacements: map[string]*ebpf.Map{
+			"dlp_cgroups":       l.EgressObjs.DlpCgroups,
+			"dlp_socket_owners": l.EgressObjs.DlpSocketOwners,
+		},
+	}
+	if err := LoadDlpOwnerObjects(objs, opts); err != nil {
+		return fmt.Errorf("failed to load DLP owner probe: %w", err)
+	}
+	lnk, err := link.AttachTracing(link.TracingOptions{Program: objs.PhantomDlpSendmsg})
+	if err != nil {
+		objs.Close()
+		return fmt.Errorf("failed to attach DLP owner probe: %w", err)
+	}
+	l.dlpOwnerObjs = objs
+	l.dlpOwnerLink = lnk
+	return nil
+}
+
+// resolveCgroup returns the cgroup v2 directory of a systemd unit such as
+// nginx.service, or name itself if it is a path
+func resolveCgroup(name string) (string, error) {
+	if filepath.IsAbs(name) {
+		return name, nil
+	}
+	out, err := exec.Command("systemctl", "show", "--property=ControlGroup", "--value", name).Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve systemd unit %s: %w", name, err)
+	}
+	cgroup := strings.TrimSpace(string(out))
+	if cgroup == "" {
+		return "", fmt.Errorf("systemd unit %s has no cgroup (is it running?)", name)
+	}
+	return filepath.Join(cgroupRoot, cgroup), nil
+}
+
+// cgroupID returns the ID BPF helpers report for a cgroup v2 directory,
+// which is the directory's inode number
+func cgroupID(path string) (uint64, error) {
+	var st unix.Stat_t
+	if err := unix.Stat(path, &st); err != nil {
+		return 0, fmt.Errorf("failed to resolve cgroup %s: %w", path, err)
+	}
+	if st.Mode&unix.S_IFMT != unix.S_IFDIR {
+		return 0, fmt.Errorf("cgroup %s is not a directory", path)
+	}
+	return st.Ino, nil
+}
+
+// resetPerCPUCounter zeroes a slot of a per-CPU counter map on every CPU
+func resetPerCPUCounter(m *ebpf.Map, key uint32) error {
+	var values []uint64
+	if err := m.Lookup(key, &values); err != nil {
+		return err
+	}
+	for i := range values {
+		values[i] = 0
+	}
+	return m.Put(key, values)
+}
+
+// clearMap deletes every entry of a hash map
+func clearMap[K comparable, V any](m *ebpf.Map) error {
+	var (
+		key    K
+		value  V
+		keys   []K
+		cursor = m.Iterate()
+	)
+	for cursor.Next(&key, &value) {
+		keys = append(keys, key)
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if err := m.Delete(k); err != nil && !errors.Is(err, ebpf.ErrKeyNotExist) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,338 @@
+package ebpf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/perf"
+	"github.com/cilium/ebpf/ringbuf"
+
+	"phantom-grid/internal/logger"
+)
+
+// eventSize is sizeof(struct phantom_event) in programs/phantom.c
+const eventSize = 72
+
+// perfBufferPages is the per-CPU perf buffer size used on kernels without ring buffers
+const perfBufferPages = 64
+
+const (
+	afInet  = 2
+	afInet6 = 10
+
+	ipProtoTCP = 6
+	ipProtoUDP = 17
+)
+
+// EventAction is the verdict the XDP program applied to a packet
+type EventAction uint8
+
+const (
+	EventActionPass     EventAction = 0
+	EventActionDrop     EventAction = 1
+	EventActionRedirect EventAction = 2
+	EventActionTarpit   EventAction = 3
+	EventActionReset    EventAction = 4
+)
+
+func (a EventAction) String() string {
+	switch a {
+	case EventActionPass:
+		return "pass"
+	case EventActionDrop:
+		return "drop"
+	case EventActionRedirect:
+		return "redirect"
+	case EventActionTarpit:
+		return "tarpit"
+	case EventActionReset:
+		return "reset"
+	default:
+		return fmt.Sprintf("action_%d", uint8(a))
+	}
+}
+
+// EventReason is why the XDP program emitted an event
+type EventReason uint8
+
+const (
+	EventReasonCriticalPort EventReason = 1
+	EventReasonStealthScan  EventReason = 2
+	EventReasonFakePort     EventReason = 3
+	EventReasonRedirect     EventReason = 4
+	EventReasonSPAAuth      EventReason = 5
+	EventReasonRateLimited  EventReason = 6
+	EventReasonBlocklisted  EventReason = 7
+	EventReasonCIDRDeny     EventReason = 8
+	EventReasonTarpit       EventReason = 9
+	EventReasonDLP          EventReason = 10
+)
+
+func (r EventReason) String() string {
+	switch r {
+	case EventReasonCriticalPort:
+		return "critical_port"
+	case EventReasonStealthScan:
+		return "stealth_scan"
+	case EventReasonFakePort:
+		return "fake_port"
+	case EventReasonRedirect:
+		return "redirect"
+	case EventReasonSPAAuth:
+		return "spa_auth"
+	case EventReasonRateLimited:
+		return "rate_limited"
+	case EventReasonBlocklisted:
+		return "blocklisted"
+	case EventReasonCIDRDeny:
+		return "cidr_deny"
+	case EventReasonTarpit:
+		return "tarpit"
+	case EventReasonDLP:
+		return "dlp"
+	default:
+		return fmt.Sprintf("reason_%d", uint8(r))
+	}
+}
+
+// Event is a decoded struct phantom_event from the XDP program, or from the
+// TC egress program for DLP matches
+type Event struct {
+	TimestampNs uint64 // CLOCK_MONOTONIC, from bpf_ktime_get_ns()
+	SrcIP       net.IP
+	DstIP       net.IP
+	SrcPort     uint16 // 0 if the event was emitted before L4 parsing
+	DstPort     uint16 // Port as sent by the source, before any redirection
+	Protocol    uint8
+	TCPFlags    uint8
+	Action      EventAction
+	Reason      EventReason
+	Rule        uint8  // Index of the matched DLP rule
+	RuleName    string // Name of the matched DLP rule, set by EventReader
+	PID         uint32 // Process that sent a DLP match, 0 if unknown
+	Comm        string // Command name of PID
+}
+
+// decodeEvent parses a raw phantom_event record
+func decodeEvent(raw []byte) (Event, error) {
+	if len(raw) < eventSize {
+		return Event{}, fmt.Errorf("short event: %d bytes, want %d", len(raw), eventSize)
+	}
+
+	ev := Event{
+		TimestampNs: binary.LittleEndian.Uint64(raw[0:8]),
+		SrcPort:     binary.BigEndian.Uint16(raw[40:42]),
+		DstPort:     binary.BigEndian.Uint16(raw[42:44]),
+		Protocol:    raw[45],
+		TCPFlags:    raw[46],
+		Action:      EventAction(raw[47]),
+		Reason:      EventReason(raw[48]),
+		Rule:        raw[49],
+		PID:         binary.LittleEndian.Uint32(raw[52:56]),
+		Comm:        string(bytes.TrimRight(raw[56:72], "\x00")),
+	}
+
+	switch family := raw[44]; family {
+	case afInet:
+		ev.SrcIP = net.IP(append([]byte(nil), raw[8:12]...))
+		ev.DstIP = net.IP(append([]byte(nil), raw[24:28]...))
+	case afInet6:
+		ev.SrcIP = net.IP(append([]byte(nil), raw[8:24]...))
+		ev.DstIP = net.IP(append([]byte(nil), raw[24:40]...))
+	default:
+		return Event{}, fmt.Errorf("unknown address family %d", family)
+	}
+	return ev, nil
+}
+
+// protocolName returns the L4 protocol as "tcp", "udp" or its number
+func (e Event) protocolName() string {
+	switch e.Protocol {
+	case ipProtoTCP:
+		return "tcp"
+	case ipProtoUDP:
+		return "udp"
+	default:
+		return fmt.Sprintf("%d", e.Protocol)
+	}
+}
+
+// processName returns " by comm[pid]" for events with a known sender
+func (e Event) processName() string {
+	if e.PID == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" by %s[%d]", e.Comm, e.PID)
+}
+
+// tcpFlagNames returns the TCP flags byte as e.g. "SYN|ACK"
+func tcpFlagNames(flags uint8) string {
+	names := []string{"FIN", "SYN", "RST", "PSH", "ACK", "URG", "ECE", "CWR"}
+	var set []string
+	for i, name := range names {
+		if flags&(1<<i) != 0 {
+			set = append(set, name)
+		}
+	}
+	return strings.Join(set, "|")
+}
+
+// SecurityEvent converts the kernel event into a structured log event
+func (e Event) SecurityEvent() *logger.SecurityEvent {
+	var eventType logger.EventType
+	var risk, message string
+	remote, local := e.SrcIP, e.DstIP
+	port, remotePort := e.DstPort, e.SrcPort
+
+	switch e.Reason {
+	case EventReasonCriticalPort:
+		eventType, risk = logger.EventTypeAccessDenied, "HIGH"
+		message = fmt.Sprintf("Dropped unauthenticated access to critical port %d/%s", e.DstPort, e.protocolName())
+	case EventReasonStealthScan:
+		eventType, risk = logger.EventTypeStealthDrop, "MEDIUM"
+		message = fmt.Sprintf("Dropped stealth scan packet (%s) to port %d", tcpFlagNames(e.TCPFlags), e.DstPort)
+		if e.Action == EventActionReset {
+			message = fmt.Sprintf("Answered stealth scan packet (%s) to port %d with RST", tcpFlagNames(e.TCPFlags), e.DstPort)
+		}
+	case EventReasonFakePort:
+		eventType, risk = logger.EventTypeConnection, "MEDIUM"
+		message = fmt.Sprintf("Connection attempt to honeypot port %d/%s", e.DstPort, e.protocolName())
+	case EventReasonRedirect:
+		eventType, risk = logger.EventTypeConnection, "MEDIUM"
+		message = fmt.Sprintf("Connection to port %d redirected to honeypot", e.DstPort)
+	case EventReasonSPAAuth:
+		eventType, risk = logger.EventTypeSPAAuth, "INFO"
+		message = "SPA authentication succeeded, source whitelisted"
+	case EventReasonRateLimited:
+		eventType, risk = logger.EventTypeRateLimited, "MEDIUM"
+		message = "Packets dropped by per-source rate limit"
+	case EventReasonBlocklisted:
+		eventType, risk = logger.EventTypeBlocked, "HIGH"
+		message = "Packets dropped from banned source"
+	case EventReasonCIDRDeny:
+		eventType, risk = logger.EventTypeBlocked, "HIGH"
+		message = "Packet dropped by CIDR denylist"
+	case EventReasonTarpit:
+		eventType, risk = logger.EventTypeConnection, "MEDIUM"
+		message = fmt.Sprintf("Connection to port %d stalled in tarpit", e.DstPort)
+	case EventReasonDLP:
+		eventType, risk = logger.EventTypeEgressBlock, "HIGH"
+		message = fmt.Sprintf("Egress payload from port %d%s matched DLP rule %s", e.SrcPort, e.processName(), e.RuleName)
+		if e.Action == EventActionDrop {
+			message = fmt.Sprintf("Blocked egress payload from port %d%s matching DLP rule %s", e.SrcPort, e.processName(), e.RuleName)
+		}
+		// The packet is outgoing, so the remote peer is its destination
+		remote, local = e.DstIP, e.SrcIP
+		port, remotePort = e.SrcPort, e.DstPort
+	default:
+		eventType, risk = logger.EventTypeSystem, "INFO"
+		message = fmt.Sprintf("Kernel event %s", e.Reason)
+	}
+
+	event := logger.NewSecurityEvent(eventType, message).
+		WithSourceIP(remote.String()).
+		WithDestinationIP(local.String()).
+		WithPort(int(port)).
+		WithRiskLevel(risk).
+		WithMetadata("reason", e.Reason.String()).
+		WithMetadata("action", e.Action.String()).
+		WithMetadata("protocol", e.Protocol)
+
+	if remotePort != 0 {
+		event.WithMetadata("source_port", int(remotePort))
+	}
+	if e.Reason == EventReasonDLP {
+		event.WithMetadata("dlp_rule", e.RuleName)
+	}
+	if e.PID != 0 {
+		event.WithMetadata("pid", int(e.PID)).WithMetadata("process", e.Comm)
+	}
+	if e.Protocol == ipProtoTCP && e.TCPFlags != 0 {
+		event.WithMetadata("tcp_flags", tcpFlagNames(e.TCPFlags))
+	}
+	return event
+}
+
+// ErrEventsClosed is returned by EventReader.Read after Close
+var ErrEventsClosed = os.ErrClosed
+
+// EventReader reads structured events from the XDP and egress programs,
+// using the ring buffer or, on older kernels, the perf buffer
+type EventReader struct {
+	ringbuf *ringbuf.Reader
+	perf    *perf.Reader
+	loader  *Loader
+}
+
+// NewEventReader opens a reader on the events map of the loaded XDP program
+func (l *Loader) NewEventReader() (*EventReader, error) {
+	if l.useRingbuf {
+		rd, err := ringbuf.NewReader(l.PhantomObjs.Events)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open event ring buffer: %w", err)
+		}
+		return &EventReader{ringbuf: rd, loader: l}, nil
+	}
+
+	rd, err := perf.NewReader(l.PhantomObjs.Events, perfBufferPages*os.Getpagesize())
+	if err != nil {
+		return nil, fmt.Errorf("failed to open event perf buffer: %w", err)
+	}
+	return &EventReader{perf: rd, loader: l}, nil
+}
+
+// Read blocks until the next event is available.
+// Returns an error wrapping ErrEventsClosed once the reader is closed.
+func (r *EventReader) Read() (Event, error) {
+	for {
+		var raw []byte
+		if r.ringbuf != nil {
+			record, err := r.ringbuf.Read()
+			if err != nil {
+				return Event{}, err
+			}
+			raw = record.RawSample
+		} else {
+			record, err := r.perf.Read()
+			if err != nil {
+				return Event{}, err
+			}
+			if record.LostSamples > 0 {
+				continue // Samples overwritten before they could be read
+			}
+			raw = record.RawSample
+		}
+
+		ev, err := decodeEvent(raw)
+		if err != nil {
+			continue
+		}
+		if ev.Reason == EventReasonDLP {
+			ev.RuleName = r.loader.dlpRuleName(ev.Rule)
+		}
+		return ev, nil
+	}
+}
+
+// Close unblocks any pending Read and releases the buffer
+func (r *EventReader) Close() error {
+	if r.ringbuf != nil {
+		return r.ringbuf.Close()
+	}
+	return r.perf.Close()
+}
+
+// EventsLost returns the number of events the kernel could not queue
+// because the buffer was full
+func EventsLost(m *ebpf.Map) (uint64, error) {
+	lost, err := SumCounter(m, 0)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read events_lost: %w", err)
+	}
+	return lost, nil
+}
@@ -0,0 +1,131 @@
+package ebpf
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"phantom-grid/internal/logger"
+)
+
+// rawEvent builds a struct phantom_event record as the kernel writes it
+func rawEvent(family uint8, src, dst net.IP, sport, dport uint16, flags uint8, action EventAction, reason EventReason) []byte {
+	raw := make([]byte, eventSize)
+	binary.LittleEndian.PutUint64(raw[0:8], 123456789)
+	if family == afInet {
+		copy(raw[8:12], src.To4())
+		copy(raw[24:28], dst.To4())
+	} else {
+		copy(raw[8:24], src.To16())
+		copy(raw[24:40], dst.To16())
+	}
+	binary.BigEndian.PutUint16(raw[40:42], sport)
+	binary.BigEndian.PutUint16(raw[42:44], dport)
+	raw[44] = family
+	raw[45] = ipProtoTCP
+	raw[46] = flags
+	raw[47] = uint8(action)
+	raw[48] = uint8(reason)
+	return raw
+}
+
+func TestDecodeEvent(t *testing.T) {
+	src := net.ParseIP("198.51.100.7")
+	dst := net.ParseIP("10.0.0.1")
+
+	ev, err := decodeEvent(rawEvent(afInet, src, dst, 51234, 22, 0x02, EventActionDrop, EventReasonCriticalPort))
+	if err != nil {
+		t.Fatalf("decodeEvent() error: %v", err)
+	}
+	if !ev.SrcIP.Equal(src) || !ev.DstIP.Equal(dst) {
+		t.Errorf("addresses = %s -> %s, want %s -> %s", ev.SrcIP, ev.DstIP, src, dst)
+	}
+	if ev.SrcPort != 51234 || ev.DstPort != 22 {
+		t.Errorf("ports = %d -> %d, want 51234 -> 22", ev.SrcPort, ev.DstPort)
+	}
+	if ev.TimestampNs != 123456789 || ev.Action != EventActionDrop || ev.Reason != EventReasonCriticalPort {
+		t.Errorf("decoded = %+v", ev)
+	}
+
+	se := ev.SecurityEvent()
+	if se.EventType != logger.EventTypeAccessDenied || se.SourceIP != "198.51.100.7" || se.Port != 22 {
+		t.Errorf("SecurityEvent() = %+v", se)
+	}
+	if se.Metadata["tcp_flags"] != "SYN" {
+		t.Errorf("tcp_flags = %v, want SYN", se.Metadata["tcp_flags"])
+	}
+}
+
+func TestDecodeEventIPv6(t *testing.T) {
+	src := net.ParseIP("2001:db8::1")
+	ev, err := decodeEvent(rawEvent(afInet6, src, net.ParseIP("2001:db8::2"), 0, 0, 0, EventActionDrop, EventReasonCIDRDeny))
+	if err != nil {
+		t.Fatalf("decodeEvent() error: %v", err)
+	}
+	if !ev.SrcIP.Equal(src) {
+		t.Errorf("SrcIP = %s, want %s", ev.SrcIP, src)
+	}
+	if se := ev.SecurityEvent(); se.EventType != logger.EventTypeBlocked {
+		t.Errorf("EventType = %s, want %s", se.EventType, logger.EventTypeBlocked)
+	}
+}
+
+func TestDecodeEventInvalid(t *testing.T) {
+	if _, err := decodeEvent(make([]byte, eventSize-1)); err == nil {
+		t.Error("decodeEvent() accepted a short record")
+	}
+	if _, err := decodeEvent(make([]byte, eventSize)); err == nil {
+		t.Error("decodeEvent() accepted an unknown address family")
+	}
+}
+
+func TestTCPFlagNames(t *testing.T) {
+	tests := map[uint8]string{
+		0x00: "",
+		0x02: "SYN",
+		0x12: "SYN|ACK",
+		0x29: "FIN|PSH|URG",
+	}
+	for flags, want := range tests {
+		if got := tcpFlagNames(flags); got != want {
+			t.Errorf("tcpFlagNames(%#x) = %q, want %q", flags, got, want)
+		}
+	}
+}
+
+func TestEventStreamStealthScan(t *testing.T) {
+	loader := loadTestLoader(t)
+
+	reader, err := loader.NewEventReader()
+	if err != nil {
+		t.Fatalf("NewEventReader() error: %v", err)
+	}
+	defer reader.Close()
+
+	src := net.IPv4(192, 0, 2, 30)
+	pkt := buildTCPPacket(src, 40000, 47123, 0x29) // Xmas scan
+	ret, _, err := loader.PhantomObjs.PhantomProg.Test(pkt)
+	if err != nil {
+		t.Skipf("BPF_PROG_TEST_RUN not supported: %v", err)
+	}
+	if ret != xdpDrop {
+		t.Fatalf("got verdict %d, want XDP_DROP", ret)
+	}
+
+	done := make(chan Event, 1)
+	go func() {
+		if ev, err := reader.Read(); err == nil {
+			done <- ev
+		}
+	}()
+
+	select {
+	case ev := <-done:
+		if !ev.SrcIP.Equal(src) || ev.Reason != EventReasonStealthScan || ev.DstPort != 47123 {
+			t.Errorf("event = %+v, want stealth scan from %s to port 47123", ev, src)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no event received")
+	}
+}
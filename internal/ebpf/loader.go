@@ -2,49 +2,147 @@ package ebpf
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
 
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/features"
 	"github.com/cilium/ebpf/link"
 	"github.com/cilium/ebpf/rlimit"
 )
 
 //go:generate go run github.com/cilium/ebpf/cmd/bpf2go -cc clang -cflags "-O2 -g -Wall -Werror" Phantom ./programs/phantom.c
 //go:generate go run github.com/cilium/ebpf/cmd/bpf2go -cc clang -cflags "-O2 -g -Wall -Werror" Egress ./programs/phantom_egress.c
+//go:generate go run github.com/cilium/ebpf/cmd/bpf2go -cc clang -cflags "-O2 -g -Wall -Werror" DlpOwner ./programs/phantom_dlp_owner.c
 
 // Loader manages eBPF program loading and attachment
 type Loader struct {
-	PhantomObjs *PhantomObjects
-	EgressObjs  *EgressObjects
-	xdpLink     link.Link
+	PhantomObjs  *PhantomObjects
+	EgressObjs   *EgressObjects
+	xdpLink      link.Link
+	skLookupLink link.Link
+	useRingbuf   bool
+	pinDir       string   // Where maps and links are pinned, empty if they are not
+	replacedMaps []string // Pinned maps recreated because their layout changed
+	tookOver     bool     // AttachXDP updated a link left by a previous agent
+
+	dlpMutex       sync.RWMutex
+	dlpRules       []string    // Names of the loaded DLP rules, by index
+	dlpCgroupLinks []link.Link // cgroup_skb DLP attachments
+	dlpOwnerObjs   *DlpOwnerObjects
+	dlpOwnerLink   link.Link
 }
 
 // NewLoader creates a new eBPF loader
 func NewLoader() (*Loader, error) {
+	return NewPinnedLoader("")
+}
+
+// NewPinnedLoader creates an eBPF loader that pins its maps and links under
+// pinDir. Maps and links pinned there by a previous agent are reused, so
+// whitelists, bans and counters survive restarts and upgrades. An empty
+// pinDir pins nothing.
+func NewPinnedLoader(pinDir string) (*Loader, error) {
 	if err := rlimit.RemoveMemlock(); err != nil {
 		return nil, fmt.Errorf("failed to lock memory: %w", err)
 	}
 
+	spec, err := LoadPhantom()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load phantom spec: %w", err)
+	}
+
+	// Ring buffers need Linux 5.8+; older kernels stream events over a perf buffer
+	useRingbuf := features.HaveMapType(ebpf.RingBuf) == nil
+	if err := configureEventStream(spec, useRingbuf); err != nil {
+		return nil, err
+	}
+
+	l := &Loader{
+		useRingbuf: useRingbuf,
+		pinDir:     pinDir,
+	}
+	opts, err := l.collectionOptions(spec, nil)
+	if err != nil {
+		return nil, err
+	}
 	phantomObjs := &PhantomObjects{}
-	if err := LoadPhantomObjects(phantomObjs, nil); err != nil {
+	if err := spec.LoadAndAssign(phantomObjs, opts); err != nil {
 		return nil, fmt.Errorf("failed to load phantom objects: %w", err)
 	}
+	l.PhantomObjs = phantomObjs
+	return l, nil
+}
 
-	return &Loader{
-		PhantomObjs: phantomObjs,
-	}, nil
+// configureEventStream switches the events map of spec to a perf buffer if
+// ring buffers are unavailable and tells the program which one to use
+func configureEventStream(spec *ebpf.CollectionSpec, useRingbuf bool) error {
+	if !useRingbuf {
+		events := spec.Maps["events"]
+		events.Type = ebpf.PerfEventArray
+		events.MaxEntries = 0 // One slot per possible CPU
+	}
+	var ringbufFlag uint32
+	if useRingbuf {
+		ringbufFlag = 1
+	}
+	if err := spec.RewriteConstants(map[string]interface{}{"use_ringbuf": ringbufFlag}); err != nil {
+		return fmt.Errorf("failed to configure event stream: %w", err)
+	}
+	return nil
 }
 
-// LoadEgress loads the egress eBPF program
+// LoadEgress loads the egress eBPF program. It shares the os_mutations
+// counter with the XDP program so the dashboard sees a single count, the
+// event stream so DLP matches are reported with the XDP events, and the
+// redirect_map so replies of redirected connections get their original
+// source port back.
 func (l *Loader) LoadEgress() error {
+	spec, err := LoadEgress()
+	if err != nil {
+		return fmt.Errorf("failed to load egress spec: %w", err)
+	}
+	if err := configureEventStream(spec, l.useRingbuf); err != nil {
+		return err
+	}
+
 	egressObjs := &EgressObjects{}
-	if err := LoadEgressObjects(egressObjs, nil); err != nil {
+	opts, err := l.collectionOptions(spec, map[string]*ebpf.Map{
+		"os_mutations": l.PhantomObjs.OsMutations,
+		"events":       l.PhantomObjs.Events,
+		"events_lost":  l.PhantomObjs.EventsLost,
+		"redirect_map": l.PhantomObjs.RedirectMap,
+	})
+	if err != nil {
+		return err
+	}
+	if err := spec.LoadAndAssign(egressObjs, opts); err != nil {
 		return fmt.Errorf("failed to load egress objects: %w", err)
 	}
 	l.EgressObjs = egressObjs
 	return nil
 }
 
-// AttachXDP attaches XDP program to network interface
+// AttachXDP attaches XDP program to network interface. With a pin directory,
+// an XDP link pinned on the same interface by a previous agent is updated to
+// the new program in place, so the interface is never left unprotected.
 func (l *Loader) AttachXDP(ifaceIndex int) (link.Link, error) {
+	if l.pinDir != "" {
+		xdpLink, err := takeOverLink(filepath.Join(l.pinDir, xdpLinkPin), l.PhantomObjs.PhantomProg, func(info *link.Info) bool {
+			xdp := info.XDP()
+			return xdp == nil || int(xdp.Ifindex) == ifaceIndex
+		})
+		if err != nil {
+			return nil, err
+		}
+		if xdpLink != nil {
+			l.xdpLink = xdpLink
+			l.tookOver = true
+			return xdpLink, nil
+		}
+	}
+
 	xdpLink, err := link.AttachXDP(link.XDPOptions{
 		Program:   l.PhantomObjs.PhantomProg,
 		Interface: ifaceIndex,
@@ -53,12 +151,49 @@ func (l *Loader) AttachXDP(ifaceIndex int) (link.Link, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to attach XDP: %w", err)
 	}
+	if err := l.pinLink(xdpLink, xdpLinkPin); err != nil {
+		xdpLink.Close()
+		return nil, err
+	}
 	l.xdpLink = xdpLink
 	return xdpLink, nil
 }
 
-// Close cleans up eBPF resources
+// Close detaches the programs and cleans up eBPF resources, including
+// everything pinned
 func (l *Loader) Close() error {
+	if l.pinDir != "" {
+		if err := os.RemoveAll(l.pinDir); err != nil {
+			return fmt.Errorf("failed to remove pins: %w", err)
+		}
+	}
+	return l.closeObjects()
+}
+
+// Release closes the loader but leaves pinned maps and links in place, so
+// XDP keeps enforcing with the current whitelists until the next agent
+// takes over. Without a pin directory it is the same as Close.
+func (l *Loader) Release() error {
+	return l.closeObjects()
+}
+
+func (l *Loader) closeObjects() error {
+	if err := l.detachDLPCgroups(); err != nil {
+		return err
+	}
+	if l.dlpOwnerLink != nil {
+		if err := l.dlpOwnerLink.Close(); err != nil {
+			return err
+		}
+	}
+	if l.dlpOwnerObjs != nil {
+		l.dlpOwnerObjs.Close()
+	}
+	if l.skLookupLink != nil {
+		if err := l.skLookupLink.Close(); err != nil {
+			return err
+		}
+	}
 	if l.xdpLink != nil {
 		if err := l.xdpLink.Close(); err != nil {
 			return err
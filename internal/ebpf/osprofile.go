@@ -0,0 +1,155 @@
+package ebpf
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"phantom-grid/internal/config"
+)
+
+// egress_config keys (must match EGRESS_CONFIG_* in programs/phantom_egress.c)
+const (
+	egressConfigOSMode         uint32 = 0
+	egressConfigOSProfile      uint32 = 1
+	egressConfigOSProfileCount uint32 = 2
+	egressConfigOSSessionSec   uint32 = 3
+)
+
+// OS personality modes (must match OS_MODE_* in programs/phantom_egress.c)
+const (
+	osModeOff       uint32 = 0
+	osModeFixed     uint32 = 1
+	osModePerSource uint32 = 2
+)
+
+const (
+	osProfileMax   = 16 // OS_PROFILE_MAX
+	osAssignPinned = 1  // OS_ASSIGN_PINNED
+)
+
+// ErrEgressNotLoaded is returned by OS personality calls when the TC egress
+// program, which rewrites honeypot replies, is not loaded
+var ErrEgressNotLoaded = errors.New("egress program not loaded")
+
+// osProfileEntry mirrors struct os_profile in programs/phantom_egress.c
+type osProfileEntry struct {
+	Window   uint16
+	MSS      uint16
+	TTL      uint8
+	DF       uint8
+	Pad      uint8
+	OptCount uint8
+	Opts     [config.MaxOSProfileOptions]uint8
+}
+
+// osAssignment mirrors struct os_assignment in programs/phantom_egress.c
+type osAssignment struct {
+	LastSeenNs uint64
+	Profile    uint32
+	Flags      uint32
+}
+
+func encodeOSProfile(p config.OSProfile) (osProfileEntry, error) {
+	if err := p.Validate(); err != nil {
+		return osProfileEntry{}, err
+	}
+
+	entry := osProfileEntry{
+		Window:   p.Window,
+		MSS:      p.MSS,
+		TTL:      p.TTL,
+		OptCount: uint8(len(p.Options)),
+	}
+	if p.DF {
+		entry.DF = 1
+	}
+	for i, opt := range p.Options {
+		entry.Opts[i] = uint8(opt)
+	}
+	return entry, nil
+}
+
+// ConfigureOSPersonality loads the selected OS profiles into the egress
+// program and sets how they are assigned to remote addresses. Existing
+// per-source assignments are cleared, since profile indexes may have changed.
+// Returns the loaded profiles in table order.
+func (l *Loader) ConfigureOSPersonality(cfg config.OSPersonalityConfiguration) ([]config.OSProfile, error) {
+	if l.EgressObjs == nil {
+		return nil, ErrEgressNotLoaded
+	}
+
+	profiles, err := cfg.SelectedProfiles()
+	if err != nil {
+		return nil, err
+	}
+	if len(profiles) > osProfileMax {
+		return nil, fmt.Errorf("too many OS profiles: %d (max %d)", len(profiles), osProfileMax)
+	}
+
+	objs := l.EgressObjs
+	// Disable rewriting while the table changes
+	if err := objs.EgressConfig.Put(egressConfigOSMode, osModeOff); err != nil {
+		return nil, fmt.Errorf("failed to set OS personality mode: %w", err)
+	}
+	if cfg.Mode == config.OSPersonalityOff {
+		return nil, nil
+	}
+
+	for i, p := range profiles {
+		entry, err := encodeOSProfile(p)
+		if err != nil {
+			return nil, err
+		}
+		if err := objs.OsProfiles.Put(uint32(i), entry); err != nil {
+			return nil, fmt.Errorf("failed to load OS profile %s: %w", p.Name, err)
+		}
+	}
+	if err := clearMap[[4]byte, osAssignment](objs.OsAssignments); err != nil {
+		return nil, fmt.Errorf("failed to clear OS profile assignments: %w", err)
+	}
+
+	mode := osModePerSource
+	if cfg.Mode == config.OSPersonalityFixed {
+		mode = osModeFixed
+	}
+	settings := []struct {
+		key   uint32
+		value uint32
+	}{
+		{egressConfigOSProfile, 0},
+		{egressConfigOSProfileCount, uint32(len(profiles))},
+		{egressConfigOSSessionSec, uint32(cfg.SessionSeconds)},
+		{egressConfigOSMode, mode},
+	}
+	for _, s := range settings {
+		if err := objs.EgressConfig.Put(s.key, s.value); err != nil {
+			return nil, fmt.Errorf("failed to configure OS personality: %w", err)
+		}
+	}
+	return profiles, nil
+}
+
+// AssignOSProfile pins the profile at index (as returned by
+// ConfigureOSPersonality) to a remote IPv4 address in per-source mode.
+// The assignment does not expire with the session.
+func (l *Loader) AssignOSProfile(ip net.IP, index int) error {
+	if l.EgressObjs == nil {
+		return ErrEgressNotLoaded
+	}
+	ip4 := ip.To4()
+	if ip4 == nil {
+		return fmt.Errorf("OS profiles can only be assigned to IPv4 addresses: %s", ip)
+	}
+	if index < 0 || index >= osProfileMax {
+		return fmt.Errorf("invalid OS profile index: %d", index)
+	}
+
+	var key [4]byte
+	copy(key[:], ip4)
+	value := osAssignment{Profile: uint32(index), Flags: osAssignPinned}
+	if err := l.EgressObjs.OsAssignments.Put(key, value); err != nil {
+		return fmt.Errorf("failed to assign OS profile to %s: %w", ip, err)
+	}
+	return nil
+}
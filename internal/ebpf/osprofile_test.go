@@ -0,0 +1,141 @@
+package ebpf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"phantom-grid/internal/config"
+)
+
+const tcActOK = 0
+
+func TestEncodeOSProfile(t *testing.T) {
+	p, _ := config.FindOSProfile("windows")
+	entry, err := encodeOSProfile(p)
+	if err != nil {
+		t.Fatalf("encodeOSProfile() error: %v", err)
+	}
+	if entry.TTL != 128 || entry.Window != 65535 || entry.DF != 1 {
+		t.Errorf("entry = %+v", entry)
+	}
+	want := []uint8{2, 1, 3, 4, 8}
+	if int(entry.OptCount) != len(want) || !bytes.Equal(entry.Opts[:entry.OptCount], want) {
+		t.Errorf("options = %v, want %v", entry.Opts[:entry.OptCount], want)
+	}
+
+	p.Options = append(p.Options, make([]config.TCPOption, config.MaxOSProfileOptions)...)
+	if _, err := encodeOSProfile(p); err == nil {
+		t.Error("encodeOSProfile() accepted too many options")
+	}
+}
+
+// linuxSynAck builds a SYN-ACK from the honeypot as a Linux stack sends it:
+// MSS, SACK permitted, timestamps, NOP, window scale
+func linuxSynAck(dst net.IP) []byte {
+	opts := []byte{
+		2, 4, 0x05, 0xb4,
+		4, 2,
+		8, 10, 0, 0, 0, 1, 0, 0, 0, 2,
+		1,
+		3, 3, 7,
+	}
+	pkt := make([]byte, 14+20+20+len(opts))
+	binary.BigEndian.PutUint16(pkt[12:14], 0x0800)
+
+	ip := pkt[14:34]
+	ip[0] = 0x45
+	binary.BigEndian.PutUint16(ip[2:4], uint16(20+20+len(opts)))
+	ip[8] = 64
+	ip[9] = 6
+	copy(ip[12:16], net.IPv4(10, 0, 0, 1).To4())
+	copy(ip[16:20], dst.To4())
+
+	tcp := pkt[34:]
+	binary.BigEndian.PutUint16(tcp[0:2], config.HoneypotPort)
+	binary.BigEndian.PutUint16(tcp[2:4], 40000)
+	tcp[12] = uint8((20+len(opts))/4) << 4
+	tcp[13] = 0x12 // SYN|ACK
+	binary.BigEndian.PutUint16(tcp[14:16], 65160)
+	copy(tcp[20:], opts)
+	binary.BigEndian.PutUint16(tcp[16:18], tcpChecksum(ip, tcp))
+	return pkt
+}
+
+func tcpChecksum(ip, tcp []byte) uint16 {
+	var sum uint32
+	add := func(b []byte) {
+		for i := 0; i+1 < len(b); i += 2 {
+			sum += uint32(binary.BigEndian.Uint16(b[i:]))
+		}
+		if len(b)%2 == 1 {
+			sum += uint32(b[len(b)-1]) << 8
+		}
+	}
+	add(ip[12:20])
+	sum += 6 + uint32(len(tcp))
+	add(tcp)
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
+
+func TestEgressOSPersonality(t *testing.T) {
+	loader := loadTestLoader(t)
+	if err := loader.LoadEgress(); err != nil {
+		t.Skipf("Cannot load egress objects: %v", err)
+	}
+
+	tests := []struct {
+		profile string
+		ttl     uint8
+		df      bool
+		window  uint16
+		opts    []byte
+	}{
+		// Same option length as the Linux original, reordered
+		{"windows", 128, true, 65535, []byte{2, 4, 0x05, 0xb4, 1, 3, 3, 8, 4, 2, 8, 10, 0, 0, 0, 1, 0, 0, 0, 2}},
+		// Header shrinks; MSS is capped by the profile
+		{"cisco-ios", 255, false, 4128, []byte{2, 4, 0x02, 0x18}},
+		// Header grows
+		{"solaris", 255, true, 64436, []byte{1, 1, 8, 10, 0, 0, 0, 1, 0, 0, 0, 2, 2, 4, 0x05, 0xb4, 1, 3, 3, 1, 1, 1, 4, 2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.profile, func(t *testing.T) {
+			cfg := config.OSPersonalityConfiguration{Mode: config.OSPersonalityFixed, Profile: tt.profile}
+			if _, err := loader.ConfigureOSPersonality(cfg); err != nil {
+				t.Fatalf("ConfigureOSPersonality() error: %v", err)
+			}
+
+			ret, out, err := loader.EgressObjs.PhantomEgressProg.Test(linuxSynAck(net.IPv4(192, 0, 2, 50)))
+			if err != nil {
+				t.Skipf("BPF_PROG_TEST_RUN not supported: %v", err)
+			}
+			if ret != tcActOK {
+				t.Fatalf("got verdict %d, want TC_ACT_OK", ret)
+			}
+
+			ip, tcp := out[14:34], out[34:]
+			if ip[8] != tt.ttl || (ip[6]&0x40 != 0) != tt.df {
+				t.Errorf("TTL = %d, DF = %v; want %d, %v", ip[8], ip[6]&0x40 != 0, tt.ttl, tt.df)
+			}
+			if got := binary.BigEndian.Uint16(tcp[14:16]); got != tt.window {
+				t.Errorf("window = %d, want %d", got, tt.window)
+			}
+
+			hdrLen := int(tcp[12]>>4) * 4
+			if hdrLen > len(tcp) || !bytes.Equal(tcp[20:hdrLen], tt.opts) {
+				t.Fatalf("options = %v, want %v", tcp[20:], tt.opts)
+			}
+			if got := int(binary.BigEndian.Uint16(ip[2:4])); got != 20+hdrLen || len(out) != 14+20+hdrLen {
+				t.Errorf("IP length = %d, frame = %d bytes, TCP header = %d bytes", got, len(out), hdrLen)
+			}
+			if tcpChecksum(ip, tcp) != 0 {
+				t.Error("TCP checksum invalid after rewrite")
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package ebpf
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"phantom-grid/internal/config"
+)
+
+const (
+	tpid8021Q  = 0x8100
+	tpid8021AD = 0x88A8
+)
+
+// frameSpec describes an Ethernet/IPv4 frame for parser evasion tests
+type frameSpec struct {
+	vlanTPIDs []uint16 // Outer to inner VLAN tag protocol IDs
+	ipOptions []byte   // Padded to a multiple of 4 bytes
+	ihl       uint8    // Overrides the computed header length when set
+	fragOff   uint16   // Fragment offset in 8-byte units
+	moreFrags bool
+	l4        []byte
+}
+
+// tcpHeader returns a 20-byte TCP header
+func tcpHeader(sport, dport uint16, flags uint8) []byte {
+	tcp := make([]byte, 20)
+	binary.BigEndian.PutUint16(tcp[0:2], sport)
+	binary.BigEndian.PutUint16(tcp[2:4], dport)
+	tcp[12] = 5 << 4
+	tcp[13] = flags
+	binary.BigEndian.PutUint16(tcp[14:16], 64240)
+	return tcp
+}
+
+func buildFrame(src net.IP, f frameSpec) []byte {
+	var pkt []byte
+
+	// Ethernet, with the EtherType filled in after the VLAN tags
+	pkt = append(pkt, make([]byte, 12)...)
+	for _, tpid := range f.vlanTPIDs {
+		pkt = binary.BigEndian.AppendUint16(pkt, tpid)
+		pkt = binary.BigEndian.AppendUint16(pkt, 100) // TCI: VLAN 100
+	}
+	pkt = binary.BigEndian.AppendUint16(pkt, 0x0800)
+
+	// IPv4
+	opts := append([]byte(nil), f.ipOptions...)
+	for len(opts)%4 != 0 {
+		opts = append(opts, 0x01) // NOP
+	}
+	ip := make([]byte, 20+len(opts))
+	ihl := uint8(len(ip) / 4)
+	if f.ihl != 0 {
+		ihl = f.ihl
+	}
+	ip[0] = 0x40 | ihl
+	binary.BigEndian.PutUint16(ip[2:4], uint16(len(ip)+len(f.l4)))
+	frag := f.fragOff
+	if f.moreFrags {
+		frag |= 0x2000
+	}
+	binary.BigEndian.PutUint16(ip[6:8], frag)
+	ip[8] = 64
+	ip[9] = 6
+	copy(ip[12:16], src.To4())
+	copy(ip[16:20], net.IPv4(10, 0, 0, 1).To4())
+	copy(ip[20:], opts)
+
+	pkt = append(pkt, ip...)
+	return append(pkt, f.l4...)
+}
+
+func TestParserEvasion(t *testing.T) {
+	loader := loadTestLoader(t)
+
+	ssh := uint16(config.SSHPort)
+	honeypot := honeypotOnlyPort(t)
+	syn := func(dport uint16) []byte { return tcpHeader(40000, dport, 0x02) }
+
+	tests := []struct {
+		name   string
+		policy config.FragmentPolicy
+		frame  frameSpec
+		want   uint32
+	}{
+		{"plain SYN to critical port", config.FragmentPolicyPass, frameSpec{l4: syn(ssh)}, xdpDrop},
+		{"IP options hide critical port", config.FragmentPolicyPass, frameSpec{ipOptions: []byte{0x01, 0x01, 0x01, 0x01, 0x01, 0x01, 0x01, 0x01}, l4: syn(ssh)}, xdpDrop},
+		{"IP options to honeypot port", config.FragmentPolicyPass, frameSpec{ipOptions: []byte{0x01, 0x01, 0x01, 0x01}, l4: syn(honeypot)}, xdpPass},
+		{"ihl below minimum", config.FragmentPolicyPass, frameSpec{ihl: 4, l4: syn(honeypot)}, xdpDrop},
+		{"ihl beyond packet", config.FragmentPolicyPass, frameSpec{ihl: 15, l4: syn(honeypot)[:8]}, xdpDrop},
+		{"802.1Q SYN to critical port", config.FragmentPolicyPass, frameSpec{vlanTPIDs: []uint16{tpid8021Q}, l4: syn(ssh)}, xdpDrop},
+		{"802.1Q SYN to honeypot port", config.FragmentPolicyPass, frameSpec{vlanTPIDs: []uint16{tpid8021Q}, l4: syn(honeypot)}, xdpPass},
+		{"QinQ SYN to critical port", config.FragmentPolicyPass, frameSpec{vlanTPIDs: []uint16{tpid8021AD, tpid8021Q}, l4: syn(ssh)}, xdpDrop},
+		{"three VLAN tags", config.FragmentPolicyPass, frameSpec{vlanTPIDs: []uint16{tpid8021AD, tpid8021Q, tpid8021Q}, l4: syn(honeypot)}, xdpDrop},
+		{"first fragment to critical port", config.FragmentPolicyPass, frameSpec{moreFrags: true, l4: syn(ssh)}, xdpDrop},
+		{"tiny first fragment", config.FragmentPolicyPass, frameSpec{moreFrags: true, l4: syn(ssh)[:8]}, xdpDrop},
+		{"overlapping fragment at offset 1", config.FragmentPolicyPass, frameSpec{fragOff: 1, l4: syn(ssh)[8:]}, xdpDrop},
+		{"non-first fragment, pass policy", config.FragmentPolicyPass, frameSpec{fragOff: 185, l4: make([]byte, 64)}, xdpPass},
+		{"non-first fragment, drop policy", config.FragmentPolicyDrop, frameSpec{fragOff: 185, l4: make([]byte, 64)}, xdpDrop},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := loader.SetFragmentPolicy(tt.policy); err != nil {
+				t.Fatalf("SetFragmentPolicy() error: %v", err)
+			}
+
+			pkt := buildFrame(net.IPv4(192, 0, 2, byte(100+i)), tt.frame)
+			ret, _, err := loader.PhantomObjs.PhantomProg.Test(pkt)
+			if err != nil {
+				t.Skipf("BPF_PROG_TEST_RUN not supported: %v", err)
+			}
+			if ret != tt.want {
+				t.Errorf("got verdict %d, want %d", ret, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package ebpf
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/link"
+)
+
+// Pin names of the links a pinned loader keeps attached between agents
+const (
+	xdpLinkPin      = "xdp_link"
+	skLookupLinkPin = "sk_lookup_link"
+)
+
+// unpinnedMaps belong to one agent process: the event stream is read by it
+// and the socket map holds its honeypot listener
+var unpinnedMaps = map[string]bool{
+	"events":          true,
+	"events_lost":     true,
+	"honeypot_socket": true,
+}
+
+// preparePinnedMaps marks the maps of spec to be pinned in dir. A map pinned
+// by a previous agent is reused unless its type, key, value or size changed;
+// such pins are removed so the map is recreated empty. Maps in skip are
+// replaced by the caller and left alone. Returns the names of the removed maps.
+func preparePinnedMaps(spec *ebpf.CollectionSpec, dir string, skip map[string]*ebpf.Map) ([]string, error) {
+	var replaced []string
+	for name, ms := range spec.Maps {
+		// Sections such as .rodata are not real maps
+		if strings.HasPrefix(name, ".") || unpinnedMaps[name] || skip[name] != nil {
+			continue
+		}
+		ms.Pinning = ebpf.PinByName
+
+		path := filepath.Join(dir, ms.Name)
+		m, err := ebpf.LoadPinnedMap(path, nil)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to open pinned map %s: %w", name, err)
+		}
+		compatible := ms.Compatible(m)
+		m.Close()
+		if compatible == nil {
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("failed to remove incompatible pinned map %s: %w", name, err)
+		}
+		replaced = append(replaced, name)
+	}
+	return replaced, nil
+}
+
+// takeOverLink loads the link a previous agent pinned at path and swaps in
+// prog atomically, so traffic is never left unfiltered. A link attached
+// elsewhere, as reported by sameTarget, is unpinned, which detaches it.
+// Returns nil if there is no usable link.
+func takeOverLink(path string, prog *ebpf.Program, sameTarget func(*link.Info) bool) (link.Link, error) {
+	lnk, err := link.LoadPinnedLink(path, nil)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open pinned link %s: %w", path, err)
+	}
+
+	// Kernels too old to describe the link are assumed to match
+	if info, err := lnk.Info(); err == nil && !sameTarget(info) {
+		lnk.Close()
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("failed to remove stale link %s: %w", path, err)
+		}
+		return nil, nil
+	}
+
+	if err := lnk.Update(prog); err != nil {
+		lnk.Close()
+		return nil, fmt.Errorf("failed to update pinned link %s: %w", path, err)
+	}
+	return lnk, nil
+}
+
+// pinLink pins a newly attached link under the loader's pin directory
+func (l *Loader) pinLink(lnk link.Link, name string) error {
+	if l.pinDir == "" {
+		return nil
+	}
+	path := filepath.Join(l.pinDir, name)
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove stale pin %s: %w", path, err)
+	}
+	if err := lnk.Pin(path); err != nil {
+		return fmt.Errorf("failed to pin %s: %w", name, err)
+	}
+	return nil
+}
+
+// collectionOptions returns the options for loading spec with replacements,
+// pinning its maps if the loader has a pin directory
+func (l *Loader) collectionOptions(spec *ebpf.CollectionSpec, replacements map[string]*ebpf.Map) (*ebpf.CollectionOptions, error) {
+	opts := &ebpf.CollectionOptions{MapReplacements: replacements}
+	if l.pinDir == "" {
+		return opts, nil
+	}
+
+	if err := os.MkdirAll(l.pinDir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create pin directory: %w", err)
+	}
+	replaced, err := preparePinnedMaps(spec, l.pinDir, replacements)
+	if err != nil {
+		return nil, err
+	}
+	l.replacedMaps = append(l.replacedMaps, replaced...)
+	opts.Maps.PinPath = l.pinDir
+	return opts, nil
+}
+
+// ReplacedMaps returns the pinned maps that were recreated empty because
+// their layout changed since the previous agent
+func (l *Loader) ReplacedMaps() []string {
+	return l.replacedMaps
+}
+
+// TookOver reports whether AttachXDP updated the program of an XDP link left
+// by a previous agent instead of attaching a new one
+func (l *Loader) TookOver() bool {
+	return l.tookOver
+}
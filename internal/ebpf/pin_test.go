@@ -0,0 +1,149 @@
+package ebpf
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/link"
+	"golang.org/x/sys/unix"
+)
+
+// testPinDir returns a scratch directory on bpffs
+func testPinDir(t *testing.T) string {
+	t.Helper()
+	if os.Geteuid() != 0 {
+		t.Skip("pinning requires root")
+	}
+	var fs unix.Statfs_t
+	if err := unix.Statfs("/sys/fs/bpf", &fs); err != nil || fs.Type != unix.BPF_FS_MAGIC {
+		t.Skip("bpffs not mounted at /sys/fs/bpf")
+	}
+	dir, err := os.MkdirTemp("/sys/fs/bpf", "phantom-test-")
+	if err != nil {
+		t.Skipf("Cannot create pin directory: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func TestPreparePinnedMaps(t *testing.T) {
+	dir := testPinDir(t)
+
+	hash := func(name string, maxEntries uint32) *ebpf.MapSpec {
+		return &ebpf.MapSpec{Name: name, Type: ebpf.Hash, KeySize: 4, ValueSize: 8, MaxEntries: maxEntries}
+	}
+	spec := &ebpf.CollectionSpec{Maps: map[string]*ebpf.MapSpec{
+		"spa_whitelist": hash("spa_whitelist", 100),
+		"blocklist":     hash("blocklist", 100),
+		"events":        {Name: "events", Type: ebpf.RingBuf, MaxEntries: 4096},
+		"os_mutations":  {Name: "os_mutations", Type: ebpf.Array, KeySize: 4, ValueSize: 8, MaxEntries: 1},
+	}}
+
+	// A previous agent pinned both hash maps, the blocklist with a smaller size
+	for _, ms := range []*ebpf.MapSpec{hash("spa_whitelist", 100), hash("blocklist", 50)} {
+		m, err := ebpf.NewMap(ms)
+		if err != nil {
+			t.Skipf("Cannot create map: %v", err)
+		}
+		if err := m.Pin(filepath.Join(dir, ms.Name)); err != nil {
+			t.Fatalf("Pin() error: %v", err)
+		}
+		m.Close()
+	}
+
+	shared, err := ebpf.NewMap(spec.Maps["os_mutations"])
+	if err != nil {
+		t.Skipf("Cannot create map: %v", err)
+	}
+	defer shared.Close()
+
+	replaced, err := preparePinnedMaps(spec, dir, map[string]*ebpf.Map{"os_mutations": shared})
+	if err != nil {
+		t.Fatalf("preparePinnedMaps() error: %v", err)
+	}
+	if len(replaced) != 1 || replaced[0] != "blocklist" {
+		t.Errorf("replaced = %v, want [blocklist]", replaced)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "spa_whitelist")); err != nil {
+		t.Errorf("compatible pin removed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "blocklist")); !os.IsNotExist(err) {
+		t.Errorf("incompatible pin kept: %v", err)
+	}
+
+	for name, want := range map[string]ebpf.PinType{
+		"spa_whitelist": ebpf.PinByName,
+		"blocklist":     ebpf.PinByName,
+		"events":        ebpf.PinNone,
+		"os_mutations":  ebpf.PinNone,
+	} {
+		if got := spec.Maps[name].Pinning; got != want {
+			t.Errorf("%s pinning = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestTakeOverLink(t *testing.T) {
+	dir := testPinDir(t)
+
+	newProg := func() *ebpf.Program {
+		prog, err := ebpf.NewProgram(&ebpf.ProgramSpec{
+			Type: ebpf.XDP,
+			Instructions: asm.Instructions{
+				asm.Mov.Imm(asm.R0, 2), // XDP_PASS
+				asm.Return(),
+			},
+			License: "GPL",
+		})
+		if err != nil {
+			t.Skipf("Cannot load XDP program: %v", err)
+		}
+		t.Cleanup(func() { prog.Close() })
+		return prog
+	}
+	old, upgraded := newProg(), newProg()
+
+	lo, err := net.InterfaceByName("lo")
+	if err != nil {
+		t.Skipf("No loopback interface: %v", err)
+	}
+	lnk, err := link.AttachXDP(link.XDPOptions{Program: old, Interface: lo.Index, Flags: link.XDPGenericMode})
+	if err != nil {
+		t.Skipf("Cannot attach XDP: %v", err)
+	}
+	path := filepath.Join(dir, xdpLinkPin)
+	if err := lnk.Pin(path); err != nil {
+		t.Fatalf("Pin() error: %v", err)
+	}
+	lnk.Close()
+
+	onLoopback := func(info *link.Info) bool {
+		return info.XDP() == nil || int(info.XDP().Ifindex) == lo.Index
+	}
+	taken, err := takeOverLink(path, upgraded, onLoopback)
+	if err != nil || taken == nil {
+		t.Fatalf("takeOverLink() = %v, %v; want the pinned link", taken, err)
+	}
+	info, err := taken.Info()
+	if err != nil {
+		t.Fatalf("Info() error: %v", err)
+	}
+	want, _ := upgraded.Info()
+	if id, _ := want.ID(); info.Program != id {
+		t.Errorf("link runs program %d, want %d", info.Program, id)
+	}
+	taken.Close()
+
+	// A link on another interface is detached rather than reused
+	taken, err = takeOverLink(path, upgraded, func(*link.Info) bool { return false })
+	if err != nil || taken != nil {
+		t.Errorf("takeOverLink() = %v, %v; want nil for another target", taken, err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("stale link still pinned: %v", err)
+	}
+}
@@ -4,6 +4,7 @@
 #include <bpf/bpf_endian.h>
 #include <linux/if_ether.h>
 #include <linux/ip.h>
+#include <linux/ipv6.h>
 #include <linux/tcp.h>
 #include <linux/udp.h>
 #include <linux/in.h>
@@ -36,24 +37,172 @@
 #ifndef IPPROTO_ICMP
 #define IPPROTO_ICMP 1
 #endif
+#ifndef AF_INET
+#define AF_INET 2
+#endif
+#ifndef AF_INET6
+#define AF_INET6 10
+#endif
+#ifndef ETH_P_8021Q
+#define ETH_P_8021Q 0x8100
+#endif
+#ifndef ETH_P_8021AD
+#define ETH_P_8021AD 0x88A8
+#endif
+
+#define VLAN_MAX_DEPTH 2          // 802.1Q, or QinQ (802.1ad outer + 802.1Q inner)
+#define IP_OFFSET 0x1FFF          // Fragment offset mask (8-byte units)
+
+struct vlan_hdr {
+    __be16 h_vlan_TCI;
+    __be16 h_vlan_encapsulated_proto;
+};
+
+// Runtime configuration keys for xdp_config (set from Go, see internal/ebpf/config.go)
+#define XDP_CONFIG_STEERING_MODE 0
+#define XDP_CONFIG_RATE_SPA_PPS 1     // SPA packets per second per source (0 = unlimited)
+#define XDP_CONFIG_RATE_SPA_BURST 2
+#define XDP_CONFIG_RATE_SYN_PPS 3     // SYNs to honeypot ports per second per source
+#define XDP_CONFIG_RATE_SYN_BURST 4
+#define XDP_CONFIG_RATE_TOTAL_PPS 5   // All packets per second per source
+#define XDP_CONFIG_RATE_TOTAL_BURST 6
+#define XDP_CONFIG_FRAGMENT_POLICY 7  // FRAGMENT_POLICY_*
+#define XDP_CONFIG_TARPIT_MAX_PER_SOURCE 8  // Open tarpit flows per source (0 = unlimited)
+#define XDP_CONFIG_STEALTH_RESPONSE 9  // 9-12: STEALTH_RESPONSE_* for Xmas, Null, FIN and ACK scans
+
+#define NS_PER_SEC 1000000000ULL
+
+// Steering modes (must match config.SteeringMode values in Go)
+#define STEERING_MODE_REDIRECT 0  // XDP rewrites destination port to HONEYPOT_PORT
+#define STEERING_MODE_SK_LOOKUP 1 // sk_lookup assigns the honeypot socket, packets untouched
+
+// Non-first fragment policies (must match config.FragmentPolicy values in Go)
+#define FRAGMENT_POLICY_PASS 0  // Pass; the first fragment was already screened
+#define FRAGMENT_POLICY_DROP 1  // Drop all non-first fragments
+
+// Stealth scan responses
+#define STEALTH_RESPONSE_DROP 0        // Silent drop
+#define STEALTH_RESPONSE_RST_OPEN 1    // What an open port sends
+#define STEALTH_RESPONSE_RST_CLOSED 2  // What a closed port sends (RFC 793 reset)
+#define STEALTH_RESPONSE_RANDOM 3      // Open or closed, chosen per packet
+#define STEALTH_OPEN_WINDOW 4096       // RST window that nmap's window scan reads as open
+
+// Event actions (must match EventAction in internal/ebpf/events.go)
+#define EVENT_ACTION_PASS 0
+#define EVENT_ACTION_DROP 1
+#define EVENT_ACTION_REDIRECT 2
+#define EVENT_ACTION_TARPIT 3
+#define EVENT_ACTION_RESET 4
+
+// Event reasons (must match EventReason in internal/ebpf/events.go)
+#define EVENT_REASON_CRITICAL_PORT 1  // Unwhitelisted access to a critical port
+#define EVENT_REASON_STEALTH_SCAN 2   // Xmas/Null/FIN/ACK scan
+#define EVENT_REASON_FAKE_PORT 3      // New connection to a fake (honeypot) port
+#define EVENT_REASON_REDIRECT 4       // New connection steered to the honeypot fallback
+#define EVENT_REASON_SPA_AUTH 5       // Source whitelisted by the default SPA token
+#define EVENT_REASON_RATE_LIMITED 6   // Per-source rate limit exceeded (sampled)
+#define EVENT_REASON_BLOCKLISTED 7    // Source is banned (sampled)
+#define EVENT_REASON_CIDR_DENY 8      // Source matched a CIDR denylist
+#define EVENT_REASON_TARPIT 9         // New connection held by the XDP tarpit
+#define EVENT_REASON_DLP 10           // Egress payload matched a DLP rule (phantom_egress.c)
+
+// Drop reasons, used as keys of drop_reasons (must match DropReason in internal/ebpf/stats.go)
+#define DROP_REASON_CRITICAL_PORT 0  // Unwhitelisted access to a critical port
+#define DROP_REASON_STEALTH_XMAS 1
+#define DROP_REASON_STEALTH_NULL 2
+#define DROP_REASON_STEALTH_FIN 3
+#define DROP_REASON_STEALTH_ACK 4
+#define DROP_REASON_RATE_LIMITED 5
+#define DROP_REASON_BLOCKLISTED 6
+#define DROP_REASON_MALFORMED 7     // Truncated or invalid IP/TCP/UDP header
+#define DROP_REASON_CIDR_DENY 8
+#define DROP_REASON_FRAGMENT 9      // Non-first fragment dropped by FRAGMENT_POLICY_DROP
+#define DROP_REASON_MAX 10
+
+// Tarpit modes (tarpit_ports values). Ports in honeypot mode are held open
+// by user space and need no kernel entry.
+#define TARPIT_MODE_NONE 0
+#define TARPIT_MODE_XDP 1
+
+// tarpit_stats slots
+#define TARPIT_STAT_FLOWS 0   // Flows opened with a zero-window SYN-ACK
+#define TARPIT_STAT_PROBES 1  // Data segments and window probes answered
+#define TARPIT_STAT_CAPPED 2  // SYNs dropped by the per-source cap
+#define TARPIT_STAT_MAX 3
+
+#define TCP_FLAGS_RST 0x04
+#define TCP_FLAGS_ACK 0x10
+#define TCP_FLAGS_SYN_ACK 0x12
+#define TCP_FLAGS_RST_ACK 0x14
+
+// Set from Go before loading: 1 = ring buffer, 0 = perf buffer (kernels < 5.8).
+// In perf mode the loader turns the events map into a PERF_EVENT_ARRAY and the
+// verifier prunes the ring buffer branch as dead code.
+volatile const __u32 use_ringbuf = 1;
+
+// Structured kernel event. Layout must match decodeEvent in internal/ebpf/events.go
+struct phantom_event {
+    __u64 timestamp_ns;  // bpf_ktime_get_ns()
+    __u8 saddr[16];      // IPv4 uses the first 4 bytes
+    __u8 daddr[16];
+    __be16 sport;
+    __be16 dport;        // Destination port as sent (before any redirection)
+    __u8 family;         // AF_INET / AF_INET6
+    __u8 protocol;
+    __u8 tcp_flags;
+    __u8 action;         // EVENT_ACTION_*
+    __u8 reason;         // EVENT_REASON_*
+    __u8 rule;           // DLP rule index (EVENT_REASON_DLP only)
+    __u8 pad[2];
+    __u32 pid;           // Sending process, if known (EVENT_REASON_DLP only)
+    char comm[16];
+};
 
 // MAP DEFINITIONS
+
+// Kernel event stream to user space
 struct {
-    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __uint(type, BPF_MAP_TYPE_RINGBUF);
+    __uint(max_entries, 256 * 1024);
+} events SEC(".maps");
+
+// Events that could not be queued because the ring buffer was full
+// Single-slot counters are per-CPU to avoid cache-line contention at line rate;
+// user space sums the per-CPU values
+struct {
+    __uint(type, BPF_MAP_TYPE_PERCPU_ARRAY);
+    __uint(max_entries, 1);
+    __type(key, __u32);
+    __type(value, __u64);
+} events_lost SEC(".maps");
+
+struct {
+    __uint(type, BPF_MAP_TYPE_PERCPU_ARRAY);
     __uint(max_entries, 1);
     __type(key, __u32);
     __type(value, __u64);
 } attack_stats SEC(".maps");
 
+// Stealth scan packets, whether dropped or answered
 struct {
-    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __uint(type, BPF_MAP_TYPE_PERCPU_ARRAY);
     __uint(max_entries, 1);
     __type(key, __u32);
     __type(value, __u64);
 } stealth_drops SEC(".maps");
 
+// Stealth scans answered with a RST instead of being dropped
 struct {
-    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __uint(type, BPF_MAP_TYPE_PERCPU_ARRAY);
+    __uint(max_entries, 1);
+    __type(key, __u32);
+    __type(value, __u64);
+} stealth_replies SEC(".maps");
+
+// Honeypot replies rewritten to an OS profile. Updated by phantom_egress.c,
+// which shares this map (see Loader.LoadEgress)
+struct {
+    __uint(type, BPF_MAP_TYPE_PERCPU_ARRAY);
     __uint(max_entries, 1);
     __type(key, __u32);
     __type(value, __u64);
@@ -67,57 +216,415 @@ struct {
 } spa_whitelist SEC(".maps");
 
 struct {
-    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __uint(type, BPF_MAP_TYPE_PERCPU_ARRAY);
     __uint(max_entries, 1);
     __type(key, __u32);
     __type(value, __u64);
 } spa_auth_success SEC(".maps");
 
 struct {
-    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __uint(type, BPF_MAP_TYPE_PERCPU_ARRAY);
     __uint(max_entries, 1);
     __type(key, __u32);
     __type(value, __u64);
 } spa_auth_failed SEC(".maps");
 
+// Connection tracking key for transparent redirection.
+// Identifies a redirected flow from the honeypot's point of view (remote address + port).
+// Layout must match redirectKey in internal/ebpf/redirect.go
+struct redirect_key {
+    __be32 saddr;
+    __be16 sport;
+    __u16 pad;
+};
+
 // Connection tracking map for transparent redirection
+// Maps attacker flow -> original destination port (network byte order)
 struct {
     __uint(type, BPF_MAP_TYPE_LRU_HASH);
     __uint(max_entries, 10000);
-    __type(key, __u64);
+    __type(key, struct redirect_key);
     __type(value, __be16);
 } redirect_map SEC(".maps");
 
-static __always_inline void mutate_os_personality(struct iphdr *ip, struct tcphdr *tcp) {
-    __u16 src_port = bpf_ntohs(tcp->source);
-    __u8 os_type = (src_port % 4);
-    
-    __u8 old_ttl = ip->ttl;
-    __u8 new_ttl;
-    __be16 old_window = tcp->window;
-    __be16 new_window;
-    
-    switch (os_type) {
-        case 0: new_ttl = TTL_WINDOWS; new_window = bpf_htons(WINDOW_WINDOWS); break;
-        case 1: new_ttl = TTL_LINUX; new_window = bpf_htons(WINDOW_LINUX); break;
-        case 2: new_ttl = TTL_FREEBSD; new_window = bpf_htons(WINDOW_FREEBSD); break;
-        case 3: new_ttl = TTL_SOLARIS; new_window = bpf_htons(WINDOW_LINUX); break;
-        default: new_ttl = TTL_WINDOWS; new_window = bpf_htons(WINDOW_WINDOWS);
+// Identifies a TCP flow by its remote end and the destination port it was
+// opened to (before any redirect rewrite)
+struct flow_key {
+    __be32 saddr;
+    __be16 sport;
+    __be16 dport;
+};
+
+// Flows whose opening SYN or SYN-ACK passed through XDP. Bare ACKs of these
+// flows are handshake completions and data, not ACK scans.
+struct {
+    __uint(type, BPF_MAP_TYPE_LRU_HASH);
+    __uint(max_entries, 65536);
+    __type(key, struct flow_key);
+    __type(value, __u8);
+} known_flows SEC(".maps");
+
+// Runtime configuration (loaded from user-space)
+struct {
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __uint(max_entries, 16);
+    __type(key, __u32);
+    __type(value, __u32);
+} xdp_config SEC(".maps");
+// Config keys:
+// 0: Steering mode (STEERING_MODE_REDIRECT / STEERING_MODE_SK_LOOKUP)
+// 1-6: Rate limits (packets per second, burst) for SPA, SYN and total traffic
+// 7: Non-first fragment policy
+// 8: Tarpit flows per source
+// 9-12: Stealth scan responses (Xmas, Null, FIN, ACK)
+
+// Token bucket. Tokens are kept in nanosecond units (one packet = NS_PER_SEC)
+// so slow refill rates do not lose precision between closely spaced packets.
+struct token_bucket {
+    __u64 tokens;
+    __u64 last_ns;
+};
+
+// Per-source rate limiting state and counters.
+// Layout must match rateState in internal/ebpf/ratelimit.go
+struct rate_state {
+    struct token_bucket spa;
+    struct token_bucket syn;
+    struct token_bucket total;
+    __u64 spa_packets;
+    __u64 syn_packets;
+    __u64 total_packets;
+    __u64 spa_drops;
+    __u64 syn_drops;
+    __u64 total_drops;
+};
+
+// CIDR allow/deny list keys. Layout must match lpmKey4/lpmKey6 in internal/cidrlist/maps.go
+struct lpm_v4_key {
+    __u32 prefixlen;
+    __u8 addr[4];
+};
+
+struct lpm_v6_key {
+    __u32 prefixlen;
+    __u8 addr[16];
+};
+
+// Per-prefix entry. Layout must match cidrEntry in internal/cidrlist/maps.go
+struct cidr_entry {
+    __u64 hits;       // Packets matched by this prefix
+    __u32 prefixlen;  // Copy of the key's prefix length (LPM lookups don't return the match)
+    __u32 pad;
+};
+
+#define CIDR_NONE 0
+#define CIDR_ALLOW 1
+#define CIDR_DENY 2
+
+// CIDR allowlists/denylists, consulted before SPA and the blocklist
+struct {
+    __uint(type, BPF_MAP_TYPE_LPM_TRIE);
+    __uint(max_entries, 16384);
+    __uint(map_flags, BPF_F_NO_PREALLOC);
+    __type(key, struct lpm_v4_key);
+    __type(value, struct cidr_entry);
+} cidr_allow_v4 SEC(".maps");
+
+struct {
+    __uint(type, BPF_MAP_TYPE_LPM_TRIE);
+    __uint(max_entries, 16384);
+    __uint(map_flags, BPF_F_NO_PREALLOC);
+    __type(key, struct lpm_v6_key);
+    __type(value, struct cidr_entry);
+} cidr_allow_v6 SEC(".maps");
+
+struct {
+    __uint(type, BPF_MAP_TYPE_LPM_TRIE);
+    __uint(max_entries, 262144);
+    __uint(map_flags, BPF_F_NO_PREALLOC);
+    __type(key, struct lpm_v4_key);
+    __type(value, struct cidr_entry);
+} cidr_deny_v4 SEC(".maps");
+
+struct {
+    __uint(type, BPF_MAP_TYPE_LPM_TRIE);
+    __uint(max_entries, 65536);
+    __uint(map_flags, BPF_F_NO_PREALLOC);
+    __type(key, struct lpm_v6_key);
+    __type(value, struct cidr_entry);
+} cidr_deny_v6 SEC(".maps");
+
+// Banned source. Layout must match banEntry in internal/blocklist/blocklist.go
+struct ban_entry {
+    __u64 expiry_ns;  // bpf_ktime_get_ns() deadline, 0 = permanent
+    __u64 drops;      // Packets dropped since the ban was placed
+    __u32 reason;     // Why the source was banned (see blocklist.Reason)
+    __u32 pad;
+};
+
+// Dynamic blocklist, checked before anything else (key: source IP, network byte order)
+// Pinned by the agent so the CLI can ban/unban while it runs. A plain hash, so
+// bans are never evicted: when it is full, user space prunes expired bans.
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __uint(max_entries, 65536);
+    __type(key, __be32);
+    __type(value, struct ban_entry);
+} blocklist SEC(".maps");
+
+// Per-source rate limiting (key: source IP, network byte order)
+struct {
+    __uint(type, BPF_MAP_TYPE_LRU_HASH);
+    __uint(max_entries, 65536);
+    __type(key, __be32);
+    __type(value, struct rate_state);
+} rate_limits SEC(".maps");
+
+// Dropped packets per reason (key: DROP_REASON_*)
+struct {
+    __uint(type, BPF_MAP_TYPE_PERCPU_ARRAY);
+    __uint(max_entries, DROP_REASON_MAX);
+    __type(key, __u32);
+    __type(value, __u64);
+} drop_reasons SEC(".maps");
+
+// Honeypot listening socket used by sk_lookup steering (key 0)
+struct {
+    __uint(type, BPF_MAP_TYPE_SOCKMAP);
+    __uint(max_entries, 1);
+    __type(key, __u32);
+    __type(value, __u64);
+} honeypot_socket SEC(".maps");
+
+// Ports that sk_lookup must never steer (real services on this host)
+// Key: port in host byte order, value: unused
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __uint(max_entries, 256);
+    __type(key, __u16);
+    __type(value, __u8);
+} steer_passthrough SEC(".maps");
+
+// Ports answered by the XDP tarpit
+// Key: port in host byte order, value: TARPIT_MODE_*
+struct {
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __uint(max_entries, 65536);
+    __type(key, __u32);
+    __type(value, __u32);
+} tarpit_ports SEC(".maps");
+
+// Layout must match tarpitFlowKey in internal/ebpf/tarpit.go
+struct tarpit_flow_key {
+    __be32 saddr;
+    __be16 sport;
+    __be16 dport;
+};
+
+// Layout must match tarpitFlow in internal/ebpf/tarpit.go
+struct tarpit_flow {
+    __u64 last_seen_ns;
+    __u32 seq;  // Our next sequence number (host byte order)
+    __u32 ack;  // Next sequence number expected from the source
+};
+
+// Connections held open by the XDP tarpit. There is no socket behind them;
+// user space expires idle flows.
+struct {
+    __uint(type, BPF_MAP_TYPE_LRU_HASH);
+    __uint(max_entries, 65536);
+    __type(key, struct tarpit_flow_key);
+    __type(value, struct tarpit_flow);
+} tarpit_flows SEC(".maps");
+
+// Open tarpit flows per source, for XDP_CONFIG_TARPIT_MAX_PER_SOURCE.
+// User space recounts them from tarpit_flows when it expires flows.
+struct {
+    __uint(type, BPF_MAP_TYPE_LRU_HASH);
+    __uint(max_entries, 65536);
+    __type(key, __be32);
+    __type(value, __u32);
+} tarpit_sources SEC(".maps");
+
+// Tarpit counters (TARPIT_STAT_*)
+struct {
+    __uint(type, BPF_MAP_TYPE_PERCPU_ARRAY);
+    __uint(max_entries, TARPIT_STAT_MAX);
+    __type(key, __u32);
+    __type(value, __u64);
+} tarpit_stats SEC(".maps");
+
+static __always_inline __u32 get_xdp_config(__u32 key) {
+    __u32 *val = bpf_map_lookup_elem(&xdp_config, &key);
+    if (val == NULL) {
+        return 0;
     }
-    
-    if (old_ttl != new_ttl) {
-        ip->ttl = new_ttl;
-        ip->check = 0; // Kernel will recalculate checksum
+    return *val;
+}
+
+// Look up a source in an allow/deny pair. The most specific prefix wins;
+// on equal prefix length deny takes precedence.
+static __always_inline int cidr_verdict(void *allow, void *deny, void *key) {
+    struct cidr_entry *a = bpf_map_lookup_elem(allow, key);
+    struct cidr_entry *d = bpf_map_lookup_elem(deny, key);
+
+    if (d && (!a || d->prefixlen >= a->prefixlen)) {
+        __sync_fetch_and_add(&d->hits, 1);
+        return CIDR_DENY;
     }
-    
-    if (old_window != new_window) {
-        tcp->window = new_window;
-        tcp->check = 0; // Kernel will recalculate checksum
+    if (a) {
+        __sync_fetch_and_add(&a->hits, 1);
+        return CIDR_ALLOW;
     }
-    
+    return CIDR_NONE;
+}
+
+// Increment slot 0 of a single-slot per-CPU counter map. No atomics are
+// needed: XDP runs with preemption disabled on the local CPU's copy.
+static __always_inline void count_stat(void *map) {
     __u32 key = 0;
-    __u64 *val = bpf_map_lookup_elem(&os_mutations, &key);
-    if (val) __sync_fetch_and_add(val, 1);
+    __u64 *val = bpf_map_lookup_elem(map, &key);
+    if (val) *val += 1;
+}
+
+static __always_inline void count_drop(__u32 reason) {
+    __u64 *val = bpf_map_lookup_elem(&drop_reasons, &reason);
+    if (val) *val += 1;
+}
+
+static __always_inline void submit_event(struct xdp_md *ctx, struct phantom_event *ev) {
+    long err;
+    if (use_ringbuf) {
+        err = bpf_ringbuf_output(&events, ev, sizeof(*ev), 0);
+    } else {
+        err = bpf_perf_event_output(ctx, &events, BPF_F_CURRENT_CPU, ev, sizeof(*ev));
+    }
+
+    if (err) {
+        count_stat(&events_lost);
+    }
+}
+
+// Emit an IPv4 event. Ports and flags are 0 when the L4 header was not parsed.
+static __always_inline void emit_event(struct xdp_md *ctx, struct iphdr *ip, __be16 sport, __be16 dport,
+                                       __u8 tcp_flags, __u8 action, __u8 reason) {
+    struct phantom_event ev = {};
+    ev.timestamp_ns = bpf_ktime_get_ns();
+    __builtin_memcpy(ev.saddr, &ip->saddr, 4);
+    __builtin_memcpy(ev.daddr, &ip->daddr, 4);
+    ev.sport = sport;
+    ev.dport = dport;
+    ev.family = AF_INET;
+    ev.protocol = ip->protocol;
+    ev.tcp_flags = tcp_flags;
+    ev.action = action;
+    ev.reason = reason;
+    submit_event(ctx, &ev);
+}
+
+static __always_inline void emit_tcp_event(struct xdp_md *ctx, struct iphdr *ip, struct tcphdr *tcp,
+                                           __u8 action, __u8 reason) {
+    emit_event(ctx, ip, tcp->source, tcp->dest, ((__u8 *)tcp)[13], action, reason);
+}
+
+// Flood-related drops are sampled at powers of two of the per-source count
+// so a flood cannot saturate the event stream
+static __always_inline int should_sample(__u64 count) {
+    return count != 0 && (count & (count - 1)) == 0;
+}
+
+// IPv6 is only subject to the CIDR lists; everything else passes as before
+static __always_inline int handle_ipv6(struct xdp_md *ctx, struct ipv6hdr *ip6, void *data_end) {
+    if ((void *)(ip6 + 1) > data_end) {
+        count_drop(DROP_REASON_MALFORMED);
+        return XDP_DROP;
+    }
+
+    struct lpm_v6_key key = { .prefixlen = 128 };
+    __builtin_memcpy(key.addr, &ip6->saddr, sizeof(key.addr));
+
+    if (cidr_verdict(&cidr_allow_v6, &cidr_deny_v6, &key) == CIDR_DENY) {
+        count_drop(DROP_REASON_CIDR_DENY);
+        struct phantom_event ev = {};
+        ev.timestamp_ns = bpf_ktime_get_ns();
+        __builtin_memcpy(ev.saddr, &ip6->saddr, sizeof(ev.saddr));
+        __builtin_memcpy(ev.daddr, &ip6->daddr, sizeof(ev.daddr));
+        ev.family = AF_INET6;
+        ev.protocol = ip6->nexthdr;
+        ev.action = EVENT_ACTION_DROP;
+        ev.reason = EVENT_REASON_CIDR_DENY;
+        submit_event(ctx, &ev);
+        return XDP_DROP;
+    }
+    return XDP_PASS;
+}
+
+// Returns the number of packets dropped for a banned source (including this one), 0 if not banned
+static __always_inline __u64 is_banned(__be32 src_ip, __u64 now) {
+    struct ban_entry *ban = bpf_map_lookup_elem(&blocklist, &src_ip);
+    if (ban == NULL) {
+        return 0;
+    }
+
+    if (ban->expiry_ns != 0 && now > ban->expiry_ns) {
+        bpf_map_delete_elem(&blocklist, &src_ip);
+        return 0;
+    }
+
+    __u64 drops = __sync_fetch_and_add(&ban->drops, 1) + 1;
+    count_drop(DROP_REASON_BLOCKLISTED);
+    return drops;
+}
+
+static __always_inline struct rate_state *get_rate_state(__be32 src_ip) {
+    struct rate_state *rs = bpf_map_lookup_elem(&rate_limits, &src_ip);
+    if (rs) {
+        return rs;
+    }
+
+    struct rate_state zero = {};
+    bpf_map_update_elem(&rate_limits, &src_ip, &zero, BPF_NOEXIST);
+    return bpf_map_lookup_elem(&rate_limits, &src_ip);
+}
+
+// Consume one token. Returns 0 when the bucket is empty and the packet should be dropped.
+// The bucket is not updated atomically; under contention a source may get a few
+// extra packets through, which is acceptable for flood protection.
+static __always_inline int rate_allow(struct token_bucket *b, __u32 pps_key, __u64 now) {
+    __u32 rate = get_xdp_config(pps_key);
+    if (rate == 0) {
+        return 1;
+    }
+
+    __u32 burst = get_xdp_config(pps_key + 1);
+    if (burst == 0) {
+        burst = rate;
+    }
+
+    __u64 max_tokens = (__u64)burst * NS_PER_SEC;
+    __u64 elapsed = now - b->last_ns;
+    if (b->last_ns == 0 || elapsed >= max_tokens / rate) {
+        b->tokens = max_tokens;
+    } else {
+        b->tokens += elapsed * rate;
+        if (b->tokens > max_tokens) {
+            b->tokens = max_tokens;
+        }
+    }
+    b->last_ns = now;
+
+    if (b->tokens < NS_PER_SEC) {
+        return 0;
+    }
+    b->tokens -= NS_PER_SEC;
+    return 1;
+}
+
+// Returns the source's drop count for this limit, including this packet
+static __always_inline __u64 count_rate_limit_drop(__u64 *source_drops) {
+    __u64 drops = __sync_fetch_and_add(source_drops, 1) + 1;
+    count_drop(DROP_REASON_RATE_LIMITED);
+    return drops;
 }
 
 static __always_inline int is_spa_whitelisted(__be32 src_ip) {
@@ -162,12 +669,11 @@ static __always_inline void spa_whitelist_ip(__be32 src_ip) {
     
     bpf_map_update_elem(&spa_whitelist, &src_ip, &expiry, BPF_ANY);
     
-    __u32 key = 0;
-    __u64 *val = bpf_map_lookup_elem(&spa_auth_success, &key);
-    if (val) __sync_fetch_and_add(val, 1);
+    count_stat(&spa_auth_success);
 }
 
-static __always_inline int is_stealth_scan(struct tcphdr *tcp) {
+// Returns the DROP_REASON_STEALTH_* for the scan type, or -1 if the flags are not a stealth scan
+static __always_inline int stealth_scan_type(struct tcphdr *tcp) {
     __u8 *flags_byte = ((__u8 *)tcp + 13);
     __u8 flags = *flags_byte;
     
@@ -178,11 +684,302 @@ static __always_inline int is_stealth_scan(struct tcphdr *tcp) {
     __u8 ack = flags & 0x10;
     __u8 urg = flags & 0x20;
     
-    if (fin && urg && psh && !syn && !rst) return 1; // Xmas scan
-    if (flags == 0) return 1; // Null scan
-    if (fin && !syn && !rst && !psh && !ack && !urg) return 1; // FIN scan
-    if (ack && !syn && !fin && !rst) return 1; // ACK scan
-    return 0;
+    if (fin && urg && psh && !syn && !rst) return DROP_REASON_STEALTH_XMAS;
+    if (flags == 0) return DROP_REASON_STEALTH_NULL;
+    if (fin && !syn && !rst && !psh && !ack && !urg) return DROP_REASON_STEALTH_FIN;
+    if (ack && !syn && !fin && !rst) return DROP_REASON_STEALTH_ACK;
+    return -1;
+}
+
+static __always_inline void remember_flow(__be32 saddr, struct tcphdr *tcp) {
+    struct flow_key key = {
+        .saddr = saddr,
+        .sport = tcp->source,
+        .dport = tcp->dest,
+    };
+    __u8 seen = 1;
+    bpf_map_update_elem(&known_flows, &key, &seen, BPF_ANY);
+}
+
+static __always_inline int is_known_flow(__be32 saddr, struct tcphdr *tcp) {
+    struct flow_key key = {
+        .saddr = saddr,
+        .sport = tcp->source,
+        .dport = tcp->dest,
+    };
+    return bpf_map_lookup_elem(&known_flows, &key) != NULL;
+}
+
+// SYN-flood protection for honeypot ports. Returns non-zero (the source's SYN
+// drop count) if the SYN must be dropped.
+static __always_inline __u64 syn_flood_exceeded(struct rate_state *rs, struct tcphdr *tcp, __u64 now) {
+    if (!rs || !tcp->syn || tcp->ack) {
+        return 0;
+    }
+
+    __sync_fetch_and_add(&rs->syn_packets, 1);
+    if (rate_allow(&rs->syn, XDP_CONFIG_RATE_SYN_PPS, now)) {
+        return 0;
+    }
+    return count_rate_limit_drop(&rs->syn_drops);
+}
+
+static __always_inline void count_tarpit(__u32 stat) {
+    __u64 *val = bpf_map_lookup_elem(&tarpit_stats, &stat);
+    if (val) *val += 1;
+}
+
+static __always_inline int is_tarpit_port(__be16 port) {
+    __u32 key = bpf_ntohs(port);
+    __u32 *mode = bpf_map_lookup_elem(&tarpit_ports, &key);
+    return mode && *mode == TARPIT_MODE_XDP;
+}
+
+// Count a new tarpit flow for the source. Returns 0 if the source already
+// holds XDP_CONFIG_TARPIT_MAX_PER_SOURCE flows.
+static __always_inline int tarpit_admit(__be32 src_ip) {
+    __u32 *flows = bpf_map_lookup_elem(&tarpit_sources, &src_ip);
+    if (!flows) {
+        __u32 one = 1;
+        bpf_map_update_elem(&tarpit_sources, &src_ip, &one, BPF_NOEXIST);
+        return 1;
+    }
+
+    __u32 max = get_xdp_config(XDP_CONFIG_TARPIT_MAX_PER_SOURCE);
+    if (max && *flows >= max) {
+        return 0;
+    }
+    __sync_fetch_and_add(flows, 1);
+    return 1;
+}
+
+static __always_inline void tarpit_release(__be32 src_ip) {
+    __u32 *flows = bpf_map_lookup_elem(&tarpit_sources, &src_ip);
+    if (flows && *flows > 0) {
+        __sync_fetch_and_sub(flows, 1);
+    }
+}
+
+static __always_inline __u16 csum_fold(__u64 csum) {
+    #pragma unroll
+    for (int i = 0; i < 4; i++) {
+        if (csum >> 16) csum = (csum & 0xffff) + (csum >> 16);
+    }
+    return (__u16)~csum;
+}
+
+// Incremental checksum update for a changed 16-bit field (RFC 1624). The
+// packet's full sum is unchanged, so a CHECKSUM_COMPLETE skb stays valid.
+static __always_inline __u16 csum_replace2(__u16 check, __be16 old, __be16 new) {
+    return csum_fold((__u64)(__u16)~check + (__u16)~old + new);
+}
+
+// Turn the received segment into a bare TCP reply (no options, no payload)
+// and bounce it out of the receiving interface. The IP header must have no
+// options. XDP_TX replies bypass the egress program, so they keep a plain
+// TTL of 64.
+static __always_inline int tcp_reply(struct xdp_md *ctx, __u32 l3_off, __u32 seq, __u32 ack,
+                                     __u8 flags, __u16 window) {
+    // Ethernet plus at most VLAN_MAX_DEPTH tags; also bounds the offset for the verifier
+    if (l3_off > sizeof(struct ethhdr) + VLAN_MAX_DEPTH * sizeof(struct vlan_hdr)) {
+        return XDP_DROP;
+    }
+
+    __u32 reply_len = l3_off + sizeof(struct iphdr) + sizeof(struct tcphdr);
+    int delta = (int)reply_len - (int)(ctx->data_end - ctx->data);
+    if (delta < 0 && bpf_xdp_adjust_tail(ctx, delta)) {
+        return XDP_DROP;
+    }
+
+    void *data_end = (void *)(long)ctx->data_end;
+    void *data = (void *)(long)ctx->data;
+    struct ethhdr *eth = data;
+    struct iphdr *ip = data + l3_off;
+    struct tcphdr *tcp = (void *)(ip + 1);
+    if ((void *)(eth + 1) > data_end || (void *)(tcp + 1) > data_end) {
+        return XDP_DROP;
+    }
+
+    __u8 mac[ETH_ALEN];
+    __builtin_memcpy(mac, eth->h_source, ETH_ALEN);
+    __builtin_memcpy(eth->h_source, eth->h_dest, ETH_ALEN);
+    __builtin_memcpy(eth->h_dest, mac, ETH_ALEN);
+
+    __be32 addr = ip->saddr;
+    ip->saddr = ip->daddr;
+    ip->daddr = addr;
+    ip->tos = 0;
+    ip->tot_len = bpf_htons(sizeof(*ip) + sizeof(*tcp));
+    ip->id = 0;
+    ip->frag_off = bpf_htons(0x4000);  // DF
+    ip->ttl = 64;
+    ip->check = 0;
+    ip->check = csum_fold(bpf_csum_diff(NULL, 0, (__be32 *)ip, sizeof(*ip), 0));
+
+    __be16 port = tcp->source;
+    tcp->source = tcp->dest;
+    tcp->dest = port;
+    tcp->seq = bpf_htonl(seq);
+    tcp->ack_seq = bpf_htonl(ack);
+    ((__u8 *)tcp)[12] = (sizeof(*tcp) / 4) << 4;
+    ((__u8 *)tcp)[13] = flags;
+    tcp->window = bpf_htons(window);
+    tcp->check = 0;
+    tcp->urg_ptr = 0;
+
+    struct {
+        __be32 saddr;
+        __be32 daddr;
+        __u8 zero;
+        __u8 protocol;
+        __be16 len;
+    } pseudo = {
+        .saddr = ip->saddr,
+        .daddr = ip->daddr,
+        .protocol = IPPROTO_TCP,
+        .len = bpf_htons(sizeof(*tcp)),
+    };
+    __s64 sum = bpf_csum_diff(NULL, 0, (__be32 *)&pseudo, sizeof(pseudo), 0);
+    tcp->check = csum_fold(bpf_csum_diff(NULL, 0, (__be32 *)tcp, sizeof(*tcp), sum));
+
+    return XDP_TX;
+}
+
+// LaBrea-style tarpit. SYNs to tarpit ports get a zero-window SYN-ACK from
+// XDP. The scanner's stack completes the handshake and then sends window
+// probes until it gives up, each answered with a zero-window ACK. Nothing
+// reaches the stack. Returns -1 if the packet does not belong to the tarpit.
+static __always_inline int handle_tarpit(struct xdp_md *ctx, struct iphdr *ip, struct tcphdr *tcp,
+                                         struct rate_state *rs, __u64 now) {
+    if (!is_tarpit_port(tcp->dest) || ip->ihl != 5) {
+        return -1;
+    }
+
+    __u32 l3_off = (void *)ip - (void *)(long)ctx->data;
+    struct tarpit_flow_key key = {
+        .saddr = ip->saddr,
+        .sport = tcp->source,
+        .dport = tcp->dest,
+    };
+    struct tarpit_flow *flow = bpf_map_lookup_elem(&tarpit_flows, &key);
+
+    if (tcp->syn && !tcp->ack) {
+        // Retransmitted SYN: repeat the SYN-ACK
+        if (flow) {
+            flow->last_seen_ns = now;
+            return tcp_reply(ctx, l3_off, flow->seq - 1, flow->ack, TCP_FLAGS_SYN_ACK, 0);
+        }
+
+        __u64 syn_drops = syn_flood_exceeded(rs, tcp, now);
+        if (syn_drops) {
+            if (should_sample(syn_drops)) {
+                emit_tcp_event(ctx, ip, tcp, EVENT_ACTION_DROP, EVENT_REASON_RATE_LIMITED);
+            }
+            return XDP_DROP;
+        }
+        if (!tarpit_admit(ip->saddr)) {
+            count_tarpit(TARPIT_STAT_CAPPED);
+            return XDP_DROP;
+        }
+
+        __u32 isn = bpf_get_prandom_u32();
+        struct tarpit_flow new_flow = {
+            .last_seen_ns = now,
+            .seq = isn + 1,
+            .ack = bpf_ntohl(tcp->seq) + 1,
+        };
+        bpf_map_update_elem(&tarpit_flows, &key, &new_flow, BPF_ANY);
+        count_tarpit(TARPIT_STAT_FLOWS);
+        emit_tcp_event(ctx, ip, tcp, EVENT_ACTION_TARPIT, EVENT_REASON_TARPIT);
+        return tcp_reply(ctx, l3_off, isn, new_flow.ack, TCP_FLAGS_SYN_ACK, 0);
+    }
+
+    // Not a tarpit flow: stealth scans and strays are handled as usual
+    if (!flow) {
+        return -1;
+    }
+
+    if (tcp->rst || tcp->fin) {
+        bpf_map_delete_elem(&tarpit_flows, &key);
+        tarpit_release(ip->saddr);
+        return XDP_DROP;
+    }
+
+    flow->last_seen_ns = now;
+
+    // The handshake ACK needs no answer. Data and window probes (which
+    // resend the last acknowledged byte) get a zero-window ACK.
+    int payload = (int)bpf_ntohs(ip->tot_len) - (int)sizeof(*ip) - (int)tcp->doff * 4;
+    if (payload <= 0 && bpf_ntohl(tcp->seq) == flow->ack) {
+        return XDP_DROP;
+    }
+    count_tarpit(TARPIT_STAT_PROBES);
+    return tcp_reply(ctx, l3_off, flow->seq, flow->ack, TCP_FLAGS_ACK, 0);
+}
+
+// Resolve the configured response to a scan type into DROP, RST_OPEN or RST_CLOSED
+static __always_inline __u32 stealth_response(int scan) {
+    __u32 response = get_xdp_config(XDP_CONFIG_STEALTH_RESPONSE + scan - DROP_REASON_STEALTH_XMAS);
+    if (response == STEALTH_RESPONSE_RANDOM) {
+        response = (bpf_get_prandom_u32() & 1) ? STEALTH_RESPONSE_RST_OPEN : STEALTH_RESPONSE_RST_CLOSED;
+    }
+
+    // An open port ignores Xmas, Null and FIN probes (RFC 793); only ACK
+    // probes get a reset from an open port
+    if (response == STEALTH_RESPONSE_RST_OPEN && scan != DROP_REASON_STEALTH_ACK) {
+        return STEALTH_RESPONSE_DROP;
+    }
+    return response;
+}
+
+// Answer a stealth scan probe with a reset as RFC 793 prescribes. The window
+// tells nmap's window scan whether the port is open or closed.
+static __always_inline int stealth_reset(struct xdp_md *ctx, struct iphdr *ip, struct tcphdr *tcp, __u32 response) {
+    __u32 l3_off = (void *)ip - (void *)(long)ctx->data;
+    __u16 window = response == STEALTH_RESPONSE_RST_OPEN ? STEALTH_OPEN_WINDOW : 0;
+
+    if (tcp->ack) {
+        return tcp_reply(ctx, l3_off, bpf_ntohl(tcp->ack_seq), 0, TCP_FLAGS_RST, window);
+    }
+    __u32 seg_len = bpf_ntohs(ip->tot_len) - sizeof(*ip) - tcp->doff * 4 + tcp->fin;
+    return tcp_reply(ctx, l3_off, 0, bpf_ntohl(tcp->seq) + seg_len, TCP_FLAGS_RST_ACK, window);
+}
+
+// Skip the Ethernet header and up to VLAN_MAX_DEPTH 802.1Q/802.1ad tags so
+// VLAN trunk traffic is filtered like untagged traffic. Returns the network
+// header and stores its EtherType in *proto, or NULL if a header is truncated.
+static __always_inline void *parse_ethhdr(void *data, void *data_end, __be16 *proto) {
+    struct ethhdr *eth = data;
+    if ((void *)(eth + 1) > data_end) return NULL;
+
+    void *cursor = eth + 1;
+    __be16 h_proto = eth->h_proto;
+
+    #pragma unroll
+    for (int i = 0; i < VLAN_MAX_DEPTH; i++) {
+        if (h_proto != bpf_htons(ETH_P_8021Q) && h_proto != bpf_htons(ETH_P_8021AD)) {
+            break;
+        }
+        struct vlan_hdr *vlan = cursor;
+        if ((void *)(vlan + 1) > data_end) return NULL;
+        h_proto = vlan->h_vlan_encapsulated_proto;
+        cursor = vlan + 1;
+    }
+
+    *proto = h_proto;
+    return cursor;
+}
+
+// Returns the L4 header of an IPv4 packet, skipping any IP options (ihl),
+// or NULL if the IP header is invalid or truncated
+static __always_inline void *parse_iphdr(struct iphdr *ip, void *data_end) {
+    if ((void *)(ip + 1) > data_end) return NULL;
+
+    __u32 hdr_len = ip->ihl * 4;
+    if (hdr_len < sizeof(*ip)) return NULL;
+    if ((void *)ip + hdr_len > data_end) return NULL;
+
+    return (void *)ip + hdr_len;
 }
 
 SEC("xdp")
@@ -190,15 +987,88 @@ int phantom_prog(struct xdp_md *ctx) {
     void *data_end = (void *)(long)ctx->data_end;
     void *data = (void *)(long)ctx->data;
 
-    struct ethhdr *eth = data;
-    if ((void *)(eth + 1) > data_end) return XDP_PASS;
+    // Headers that claim a protocol but are cut short or invalid would be
+    // discarded by the stack anyway; drop them here and count them
+    __be16 h_proto = 0;
+    void *l3 = parse_ethhdr(data, data_end, &h_proto);
+    if (!l3) {
+        count_drop(DROP_REASON_MALFORMED);
+        return XDP_DROP;
+    }
+    // More VLAN tags than we unwrap: the stack would not deliver these either
+    if (h_proto == bpf_htons(ETH_P_8021Q) || h_proto == bpf_htons(ETH_P_8021AD)) {
+        count_drop(DROP_REASON_MALFORMED);
+        return XDP_DROP;
+    }
 
-    if (eth->h_proto != bpf_htons(ETH_P_IP)) return XDP_PASS;
+    if (h_proto == bpf_htons(ETH_P_IPV6)) {
+        return handle_ipv6(ctx, l3, data_end);
+    }
+    if (h_proto != bpf_htons(ETH_P_IP)) return XDP_PASS;
 
-    struct iphdr *ip = (void *)(eth + 1);
-    if ((void *)(ip + 1) > data_end) return XDP_PASS;
+    struct iphdr *ip = l3;
+    void *l4 = parse_iphdr(ip, data_end);
+    if (!l4) {
+        count_drop(DROP_REASON_MALFORMED);
+        return XDP_DROP;
+    }
 
     __be32 src_ip = ip->saddr;
+    __u64 now = bpf_ktime_get_ns();
+
+    // CIDR lists come first: denied ranges are dropped, allowed ranges
+    // (e.g. the office network) bypass bans, rate limits and SPA
+    struct lpm_v4_key cidr_key = { .prefixlen = 32 };
+    __builtin_memcpy(cidr_key.addr, &src_ip, sizeof(cidr_key.addr));
+    int cidr = cidr_verdict(&cidr_allow_v4, &cidr_deny_v4, &cidr_key);
+    if (cidr == CIDR_DENY) {
+        count_drop(DROP_REASON_CIDR_DENY);
+        emit_event(ctx, ip, 0, 0, 0, EVENT_ACTION_DROP, EVENT_REASON_CIDR_DENY);
+        return XDP_DROP;
+    }
+    if (cidr == CIDR_ALLOW) {
+        return XDP_PASS;
+    }
+
+    // Banned sources are dropped before any other processing
+    __u64 ban_drops = is_banned(src_ip, now);
+    if (ban_drops) {
+        if (should_sample(ban_drops)) {
+            emit_event(ctx, ip, 0, 0, 0, EVENT_ACTION_DROP, EVENT_REASON_BLOCKLISTED);
+        }
+        return XDP_DROP;
+    }
+
+    // Per-source rate limiting. SPA-whitelisted sources are exempt from the
+    // total packet limit so authorized sessions are never throttled.
+    struct rate_state *rs = get_rate_state(src_ip);
+    if (rs) {
+        __sync_fetch_and_add(&rs->total_packets, 1);
+        if (!rate_allow(&rs->total, XDP_CONFIG_RATE_TOTAL_PPS, now) && !is_spa_whitelisted(src_ip)) {
+            if (should_sample(count_rate_limit_drop(&rs->total_drops))) {
+                emit_event(ctx, ip, 0, 0, 0, EVENT_ACTION_DROP, EVENT_REASON_RATE_LIMITED);
+            }
+            return XDP_DROP;
+        }
+    }
+
+    // Non-first fragments carry no L4 header, so ports cannot be checked.
+    // The first fragment (offset 0) is screened like any packet below; since a
+    // dropped first fragment prevents reassembly, passing the rest is safe.
+    __u16 frag_off = bpf_ntohs(ip->frag_off) & IP_OFFSET;
+    if (frag_off != 0) {
+        // Offset 1 overlaps bytes 8-15 of the TCP header (flags) and is only
+        // used to rewrite an already screened header (RFC 1858)
+        if (frag_off == 1 && ip->protocol == IPPROTO_TCP) {
+            count_drop(DROP_REASON_MALFORMED);
+            return XDP_DROP;
+        }
+        if (get_xdp_config(XDP_CONFIG_FRAGMENT_POLICY) == FRAGMENT_POLICY_DROP) {
+            count_drop(DROP_REASON_FRAGMENT);
+            return XDP_DROP;
+        }
+        return XDP_PASS;
+    }
 
     // Allow all ICMP traffic (ping, etc.)
     if (ip->protocol == IPPROTO_ICMP) {
@@ -207,16 +1077,31 @@ int phantom_prog(struct xdp_md *ctx) {
 
     // SPA Logic (UDP)
     if (ip->protocol == IPPROTO_UDP) {
-        struct udphdr *udp = (void *)(ip + 1);
-        if ((void *)(udp + 1) > data_end) return XDP_PASS;
+        struct udphdr *udp = l4;
+        if ((void *)(udp + 1) > data_end) {
+            count_drop(DROP_REASON_MALFORMED);
+            return XDP_DROP;
+        }
         
         if (udp->dest == bpf_htons(SPA_MAGIC_PORT)) {
+            // Throttle SPA floods before they reach the token check or user space
+            if (rs) {
+                __sync_fetch_and_add(&rs->spa_packets, 1);
+                if (!rate_allow(&rs->spa, XDP_CONFIG_RATE_SPA_PPS, now)) {
+                    if (should_sample(count_rate_limit_drop(&rs->spa_drops))) {
+                        emit_event(ctx, ip, udp->source, udp->dest, 0, EVENT_ACTION_DROP, EVENT_REASON_RATE_LIMITED);
+                    }
+                    return XDP_DROP;
+                }
+            }
+
             void *payload = (void *)(udp + 1);
             
             // Check if packet matches default token
             if (verify_magic_packet(payload, data_end)) {
                 // Default token matched - whitelist in eBPF
                 spa_whitelist_ip(src_ip);
+                emit_event(ctx, ip, udp->source, udp->dest, 0, EVENT_ACTION_DROP, EVENT_REASON_SPA_AUTH);
                 return XDP_DROP;
             } else {
                 // Token doesn't match default - pass to user-space handler
@@ -225,13 +1110,34 @@ int phantom_prog(struct xdp_md *ctx) {
                 return XDP_PASS;
             }
         }
+
+        // Protected UDP services (WireGuard, DNS, SNMP, ...) - only allow if whitelisted via SPA
+        if (is_critical_udp_port(udp->dest)) {
+            if (!is_spa_whitelisted(src_ip)) {
+                count_drop(DROP_REASON_CRITICAL_PORT);
+                emit_event(ctx, ip, udp->source, udp->dest, 0, EVENT_ACTION_DROP, EVENT_REASON_CRITICAL_PORT);
+                return XDP_DROP;
+            }
+            return XDP_PASS;
+        }
+
+        // UDP honeypot services are bound directly by user space; no redirection
+        if (is_fake_udp_port(udp->dest)) {
+            count_stat(&attack_stats);
+            emit_event(ctx, ip, udp->source, udp->dest, 0, EVENT_ACTION_PASS, EVENT_REASON_FAKE_PORT);
+        }
         return XDP_PASS;
     }
 
     // TCP Logic (Defense & Redirection)
     if (ip->protocol == IPPROTO_TCP) {
-        struct tcphdr *tcp = (void *)(ip + 1);
-        if ((void *)(tcp + 1) > data_end) return XDP_PASS;
+        // A first fragment too short to hold the whole TCP header
+        // (tiny fragment attack) is dropped here as well
+        struct tcphdr *tcp = l4;
+        if ((void *)(tcp + 1) > data_end) {
+            count_drop(DROP_REASON_MALFORMED);
+            return XDP_DROP;
+        }
 
         // Pass all packets to honeypot port (before other checks)
         if (tcp->dest == bpf_htons(HONEYPOT_PORT)) {
@@ -245,6 +1151,8 @@ int phantom_prog(struct xdp_md *ctx) {
         // If a port is both critical AND fake, priority goes to protection (SPA required)
         if (is_critical_asset_port(tcp->dest)) {
             if (!is_spa_whitelisted(src_ip)) {
+                count_drop(DROP_REASON_CRITICAL_PORT);
+                emit_tcp_event(ctx, ip, tcp, EVENT_ACTION_DROP, EVENT_REASON_CRITICAL_PORT);
                 return XDP_DROP;  // Server appears "dead" to attackers
             }
             return XDP_PASS;  // Whitelisted IP can access
@@ -253,36 +1161,137 @@ int phantom_prog(struct xdp_md *ctx) {
         // Pass fake ports directly (The Mirage) - these are honeypot ports
         // These ports are NOT critical assets, so they can be accessed without SPA
         if (is_fake_port(tcp->dest)) {
-            __u32 key = 0;
-            __u64 *val = bpf_map_lookup_elem(&attack_stats, &key);
-            if (val) __sync_fetch_and_add(val, 1);
+            __u64 syn_drops = syn_flood_exceeded(rs, tcp, now);
+            if (syn_drops) {
+                if (should_sample(syn_drops)) {
+                    emit_tcp_event(ctx, ip, tcp, EVENT_ACTION_DROP, EVENT_REASON_RATE_LIMITED);
+                }
+                return XDP_DROP;
+            }
+
+            count_stat(&attack_stats);
+            if (tcp->syn && !tcp->ack) {
+                emit_tcp_event(ctx, ip, tcp, EVENT_ACTION_PASS, EVENT_REASON_FAKE_PORT);
+            }
             return XDP_PASS;
         }
 
-        // Block stealth scans
-        if (is_stealth_scan(tcp)) {
-            __u32 key = 0;
-            __u64 *val = bpf_map_lookup_elem(&stealth_drops, &key);
-            if (val) __sync_fetch_and_add(val, 1);
-            return XDP_DROP;
+        // Tarpit ports hold scanners in stalled connections answered from XDP
+        int tarpit = handle_tarpit(ctx, ip, tcp, rs, now);
+        if (tarpit >= 0) {
+            return tarpit;
+        }
+
+        // A SYN-ACK answers a connection this host opened; its ACKs belong
+        // to that flow
+        if (tcp->syn && tcp->ack) {
+            remember_flow(src_ip, tcp);
+        }
+
+        // Stealth scans are dropped or answered with a RST, as configured
+        // per scan type, so the scan result is noise rather than "filtered".
+        // A bare ACK is only a scan when no handshake was seen for its flow.
+        int scan = stealth_scan_type(tcp);
+        if (scan == DROP_REASON_STEALTH_ACK && is_known_flow(src_ip, tcp)) {
+            scan = -1;
+        }
+        if (scan >= 0) {
+            count_stat(&stealth_drops);
+            __u32 response = stealth_response(scan);
+            if (response == STEALTH_RESPONSE_DROP || ip->ihl != 5) {
+                count_drop(scan);
+                emit_tcp_event(ctx, ip, tcp, EVENT_ACTION_DROP, EVENT_REASON_STEALTH_SCAN);
+                return XDP_DROP;
+            }
+            count_stat(&stealth_replies);
+            emit_tcp_event(ctx, ip, tcp, EVENT_ACTION_RESET, EVENT_REASON_STEALTH_SCAN);
+            return stealth_reset(ctx, ip, tcp, response);
         }
 
         // Redirect other ports to honeypot fallback
-        __u32 key = 0;
-        __u64 *val = bpf_map_lookup_elem(&attack_stats, &key);
-        if (val) __sync_fetch_and_add(val, 1);
+        __u64 syn_drops = syn_flood_exceeded(rs, tcp, now);
+        if (syn_drops) {
+            if (should_sample(syn_drops)) {
+                emit_tcp_event(ctx, ip, tcp, EVENT_ACTION_DROP, EVENT_REASON_RATE_LIMITED);
+            }
+            return XDP_DROP;
+        }
+
+        count_stat(&attack_stats);
+        if (tcp->syn && !tcp->ack) {
+            remember_flow(src_ip, tcp);
+            emit_tcp_event(ctx, ip, tcp, EVENT_ACTION_REDIRECT, EVENT_REASON_REDIRECT);
+        }
+
+        // In sk_lookup mode the socket lookup hands the connection to the
+        // honeypot, so the packet is passed up unmodified
+        if (get_xdp_config(XDP_CONFIG_STEERING_MODE) == STEERING_MODE_SK_LOOKUP) {
+            return XDP_PASS;
+        }
+
+        // Remember the original destination port so the honeypot can pick a
+        // matching persona and TC egress can restore it on the replies. Only
+        // the SYN opens an entry; later segments are rewritten only if they
+        // belong to a redirected flow, so replies to connections the host
+        // opened pass untouched.
+        struct redirect_key rkey = {
+            .saddr = src_ip,
+            .sport = tcp->source,
+            .pad = 0,
+        };
+        if (tcp->syn && !tcp->ack) {
+            __be16 orig_port = tcp->dest;
+            bpf_map_update_elem(&redirect_map, &rkey, &orig_port, BPF_ANY);
+        } else {
+            __be16 *orig_port = bpf_map_lookup_elem(&redirect_map, &rkey);
+            if (!orig_port || *orig_port != tcp->dest) {
+                return XDP_PASS;
+            }
+        }
 
         __be16 new_port = bpf_htons(HONEYPOT_PORT);
-        
+        tcp->check = csum_replace2(tcp->check, tcp->dest, new_port);
         tcp->dest = new_port;
-        tcp->check = 0; // Kernel will recalculate checksum
-        
-        mutate_os_personality(ip, tcp);
-        
+
         return XDP_PASS;
     }
     
     return XDP_PASS;
 }
 
+// sk_lookup steering: assign connections for any unprotected port to the
+// honeypot's listening socket without rewriting packets. The original
+// destination port stays visible to the honeypot as the local address.
+SEC("sk_lookup")
+int phantom_sk_lookup(struct bpf_sk_lookup *ctx) {
+    if (ctx->protocol != IPPROTO_TCP || ctx->family != AF_INET) {
+        return SK_PASS;
+    }
+
+    // Never steer loopback traffic (local services, ELK, etc.)
+    if ((bpf_ntohl(ctx->local_ip4) >> 24) == 127) {
+        return SK_PASS;
+    }
+
+    // Protected services keep their own sockets; XDP already enforces SPA
+    __u16 port = (__u16)ctx->local_port;
+    if (is_critical_asset_port(bpf_htons(port))) {
+        return SK_PASS;
+    }
+    if (bpf_map_lookup_elem(&steer_passthrough, &port)) {
+        return SK_PASS;
+    }
+
+    __u32 key = 0;
+    struct bpf_sock *sk = bpf_map_lookup_elem(&honeypot_socket, &key);
+    if (!sk) {
+        return SK_PASS;
+    }
+
+    // On failure fall back to the regular socket lookup
+    bpf_sk_assign(ctx, sk, 0);
+    bpf_sk_release(sk);
+    return SK_PASS;
+}
+
 char _license[] SEC("license") = "GPL";
\ No newline at end of file
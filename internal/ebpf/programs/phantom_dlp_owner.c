@@ -0,0 +1,72 @@
+//go:build ignore
+#include <linux/bpf.h>
+#include <bpf/bpf_helpers.h>
+
+/*
+ * PHANTOM GRID - DLP SOCKET OWNER PROBE
+ *
+ * Records which process sends on each socket of the cgroups protected by the
+ * cgroup_skb DLP program in phantom_egress.c, so matches can be reported with
+ * a PID and command name. The cgroup_skb program runs without a reliable
+ * process context; tcp_sendmsg() runs in the sender's.
+ *
+ * Both maps are replaced by the loader with the egress program's.
+ */
+
+#define DLP_CGROUP_MAX_LEVEL 8  // Ancestor levels checked against dlp_cgroups
+
+struct sock;
+
+// Same layout as struct dlp_owner in phantom_egress.c
+struct dlp_owner {
+    __u32 pid;
+    char comm[16];
+};
+
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __uint(max_entries, 256);
+    __type(key, __u64);
+    __type(value, __u8);
+} dlp_cgroups SEC(".maps");
+
+struct {
+    __uint(type, BPF_MAP_TYPE_LRU_HASH);
+    __uint(max_entries, 65536);
+    __type(key, __u64);
+    __type(value, struct dlp_owner);
+} dlp_socket_owners SEC(".maps");
+
+// Whether the current task is in one of dlp_cgroups or below one
+static __always_inline int current_in_dlp_cgroup(void) {
+    __u64 id = bpf_get_current_cgroup_id();
+    if (bpf_map_lookup_elem(&dlp_cgroups, &id)) return 1;
+
+    for (int level = 1; level <= DLP_CGROUP_MAX_LEVEL; level++) {
+        id = bpf_get_current_ancestor_cgroup_id(level);
+        if (id == 0) break;
+        if (bpf_map_lookup_elem(&dlp_cgroups, &id)) return 1;
+    }
+    return 0;
+}
+
+SEC("fentry/tcp_sendmsg")
+int phantom_dlp_sendmsg(unsigned long long *ctx) {
+    struct sock *sk = (struct sock *)ctx[0];
+
+    if (!current_in_dlp_cgroup()) return 0;
+
+    __u64 cookie = bpf_get_socket_cookie(sk);
+    __u32 pid = bpf_get_current_pid_tgid() >> 32;
+    struct dlp_owner *known = bpf_map_lookup_elem(&dlp_socket_owners, &cookie);
+    if (known && known->pid == pid) return 0;
+
+    struct dlp_owner owner = {
+        .pid = pid,
+    };
+    bpf_get_current_comm(owner.comm, sizeof(owner.comm));
+    bpf_map_update_elem(&dlp_socket_owners, &cookie, &owner, BPF_ANY);
+    return 0;
+}
+
+char _license[] SEC("license") = "GPL";
@@ -10,7 +10,7 @@
 #include <linux/in.h>
 
 /*
- * PHANTOM GRID - EGRESS DATA LOSS PREVENTION (DLP) MODULE
+ * PHANTOM GRID - EGRESS DATA LOSS PREVENTION (DLP) & OS PERSONALITY MODULE
  * 
  * ALL CONFIGURATION IS AUTO-GENERATED FROM Go CONFIG
  * Do not edit constants manually - update internal/config/config.go instead
@@ -19,7 +19,149 @@
 
 // Include auto-generated configuration
 #include "phantom_ports.h"
+#include "phantom_ports_functions.c"
+
+#ifndef TCPOPT_EOL
+#define TCPOPT_EOL 0
+#define TCPOPT_NOP 1
+#define TCPOPT_MSS 2
+#define TCPOPT_WINDOW 3
+#define TCPOPT_SACK_PERM 4
+#define TCPOPT_TIMESTAMP 8
+#endif
+
+#define TCP_MAX_HDR_LEN 60
+#define TCP_MAX_OPT_LEN 40
+#define NS_PER_SEC 1000000000ULL
+
+// Runtime configuration keys for egress_config (set from Go, see internal/ebpf/osprofile.go)
+#define EGRESS_CONFIG_OS_MODE 0           // OS_MODE_*
+#define EGRESS_CONFIG_OS_PROFILE 1        // Profile index in fixed mode
+#define EGRESS_CONFIG_OS_PROFILE_COUNT 2  // Profiles loaded into os_profiles
+#define EGRESS_CONFIG_OS_SESSION_SEC 3    // Idle seconds before a source may get another profile (0 = never)
+#define EGRESS_CONFIG_DLP_MODE 4          // DLP_MODE_*
+#define EGRESS_CONFIG_DLP_RULE_COUNT 5    // Rules loaded into dlp_rules
+
+// OS personality modes (must match osMode* in internal/ebpf/osprofile.go)
+#define OS_MODE_OFF 0
+#define OS_MODE_FIXED 1
+#define OS_MODE_PER_SOURCE 2
+
+#define OS_PROFILE_MAX 16
+#define OS_PROFILE_MAX_OPTS 12
+
+#define OS_ASSIGN_PINNED 1  // Set from Go; never expires
+
+// DLP modes (must match dlpMode* in internal/ebpf/dlp.go)
+#define DLP_MODE_OFF 0
+#define DLP_MODE_MONITOR 1  // Count and report matches, let the packet through
+#define DLP_MODE_ENFORCE 2  // Count, report and drop
+
+// DLP rule kinds (must match dlpKind* in internal/ebpf/dlp.go)
+#define DLP_RULE_PREFIX 1    // Payload starts with the pattern
+#define DLP_RULE_CONTAINS 2  // Pattern occurs within the scan window
+#define DLP_RULE_BASE64 3    // Scan window is at least len percent base64 characters
+
+#define DLP_RULE_MAX 16
+#define DLP_PATTERN_MAX 16
+#define DLP_SCAN_LEN 64  // Payload bytes inspected per packet (power of two)
+
+#define CGROUP_SKB_DROP 0
+#define CGROUP_SKB_ALLOW 1
+
+// Event actions and reasons used here (must match EventAction and
+// EventReason in internal/ebpf/events.go)
+#define EVENT_ACTION_PASS 0
+#define EVENT_ACTION_DROP 1
+#define EVENT_REASON_DLP 10
+
+// In perf mode the loader turns the shared events map into a PERF_EVENT_ARRAY
+volatile const __u32 use_ringbuf = 1;
+
+// Same layout as struct phantom_event in phantom.c
+struct phantom_event {
+    __u64 timestamp_ns;
+    __u8 saddr[16];
+    __u8 daddr[16];
+    __be16 sport;
+    __be16 dport;
+    __u8 family;
+    __u8 protocol;
+    __u8 tcp_flags;
+    __u8 action;
+    __u8 reason;
+    __u8 rule;
+    __u8 pad[2];
+    __u32 pid;
+    char comm[16];
+};
+
+// Process that sent on a socket, recorded by programs/phantom_dlp_owner.c
+struct dlp_owner {
+    __u32 pid;
+    char comm[16];
+};
+
+// Layout must match dlpRuleEntry in internal/ebpf/dlp.go
+struct dlp_rule {
+    __u8 kind;   // DLP_RULE_*
+    __u8 len;    // Pattern length; minimum percentage for DLP_RULE_BASE64
+    __u8 pad[2];
+    __u8 pattern[DLP_PATTERN_MAX];
+};
+
+// TCP/IP fingerprint of an operating system. Layout must match osProfileEntry
+// in internal/ebpf/osprofile.go
+struct os_profile {
+    __u16 window;      // SYN-ACK window (host byte order)
+    __u16 mss;         // Upper bound for the MSS option (host byte order)
+    __u8 ttl;
+    __u8 df;           // 1 = set Don't Fragment
+    __u8 pad;
+    __u8 opt_count;
+    __u8 opts[OS_PROFILE_MAX_OPTS];  // TCPOPT_* kinds in the order they are sent
+};
+
+// Profile assigned to a remote address. Layout must match osAssignment in
+// internal/ebpf/osprofile.go
+struct os_assignment {
+    __u64 last_seen_ns;
+    __u32 profile;
+    __u32 flags;       // OS_ASSIGN_*
+};
+
+struct {
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __uint(max_entries, 8);
+    __type(key, __u32);
+    __type(value, __u32);
+} egress_config SEC(".maps");
 
+struct {
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __uint(max_entries, OS_PROFILE_MAX);
+    __type(key, __u32);
+    __type(value, struct os_profile);
+} os_profiles SEC(".maps");
+
+// Per-source profile, so every reply a scanner sees comes from the same "OS"
+// (key: remote IP, network byte order)
+struct {
+    __uint(type, BPF_MAP_TYPE_LRU_HASH);
+    __uint(max_entries, 65536);
+    __type(key, __be32);
+    __type(value, struct os_assignment);
+} os_assignments SEC(".maps");
+
+// Shared with phantom.c: the loader replaces this map with PhantomObjs.OsMutations
+struct {
+    __uint(type, BPF_MAP_TYPE_PERCPU_ARRAY);
+    __uint(max_entries, 1);
+    __type(key, __u32);
+    __type(value, __u64);
+} os_mutations SEC(".maps");
+
+// Payloads that matched a DLP rule and were dropped in enforce mode
 struct {
     __uint(type, BPF_MAP_TYPE_ARRAY);
     __uint(max_entries, 1);
@@ -27,58 +169,556 @@ struct {
     __type(value, __u64);
 } egress_blocks SEC(".maps");
 
+// DLP rules in match order, loaded from Go (see internal/ebpf/dlp.go)
 struct {
     __uint(type, BPF_MAP_TYPE_ARRAY);
-    __uint(max_entries, 10);
+    __uint(max_entries, DLP_RULE_MAX);
+    __type(key, __u32);
+    __type(value, struct dlp_rule);
+} dlp_rules SEC(".maps");
+
+// Matches per rule, in monitor and enforce mode
+struct {
+    __uint(type, BPF_MAP_TYPE_PERCPU_ARRAY);
+    __uint(max_entries, DLP_RULE_MAX);
     __type(key, __u32);
     __type(value, __u64);
-} suspicious_patterns SEC(".maps");
+} dlp_hits SEC(".maps");
 
-// Manual string comparison for eBPF verification
-static __always_inline int check_pattern(void *data, const char *pattern, __u32 len) {
-    unsigned char *d = (unsigned char *)data;
-    const unsigned char *p = (const unsigned char *)pattern;
-    
-    #pragma clang loop unroll(full)
-    for (__u32 i = 0; i < 20; i++) { 
-        if (i >= len) break;
-        if (d[i] != p[i]) return 0;
+// Local source ports whose traffic is inspected (key: port, host byte order)
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __uint(max_entries, 256);
+    __type(key, __u16);
+    __type(value, __u8);
+} dlp_ports SEC(".maps");
+
+// cgroup v2 IDs the cgroup_skb program is attached to. Shared with the
+// owner probe, which only records senders in these cgroups.
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __uint(max_entries, 256);
+    __type(key, __u64);
+    __type(value, __u8);
+} dlp_cgroups SEC(".maps");
+
+// Socket cookie -> sending process, filled by the owner probe
+struct {
+    __uint(type, BPF_MAP_TYPE_LRU_HASH);
+    __uint(max_entries, 65536);
+    __type(key, __u64);
+    __type(value, struct dlp_owner);
+} dlp_socket_owners SEC(".maps");
+
+// Shared with phantom.c: the loader replaces these maps with PhantomObjs.Events
+// and PhantomObjs.EventsLost, so DLP events arrive on the same stream
+struct {
+    __uint(type, BPF_MAP_TYPE_RINGBUF);
+    __uint(max_entries, 256 * 1024);
+} events SEC(".maps");
+
+struct {
+    __uint(type, BPF_MAP_TYPE_PERCPU_ARRAY);
+    __uint(max_entries, 1);
+    __type(key, __u32);
+    __type(value, __u64);
+} events_lost SEC(".maps");
+
+// Layout must match struct redirect_key in phantom.c
+struct redirect_key {
+    __be32 saddr;
+    __be16 sport;
+    __u16 pad;
+};
+
+// Shared with phantom.c: the loader replaces this map with
+// PhantomObjs.RedirectMap, where XDP records the original destination port
+// of each redirected flow
+struct {
+    __uint(type, BPF_MAP_TYPE_LRU_HASH);
+    __uint(max_entries, 10000);
+    __type(key, struct redirect_key);
+    __type(value, __be16);
+} redirect_map SEC(".maps");
+
+static __always_inline __u32 get_egress_config(__u32 key) {
+    __u32 *val = bpf_map_lookup_elem(&egress_config, &key);
+    if (val == NULL) {
+        return 0;
+    }
+    return *val;
+}
+
+// Returns the profile for a remote address, assigning one on first contact
+// in per-source mode. NULL means replies are left untouched.
+static __always_inline struct os_profile *select_os_profile(__be32 daddr) {
+    __u32 mode = get_egress_config(EGRESS_CONFIG_OS_MODE);
+    __u32 idx;
+
+    if (mode == OS_MODE_FIXED) {
+        idx = get_egress_config(EGRESS_CONFIG_OS_PROFILE);
+        return bpf_map_lookup_elem(&os_profiles, &idx);
+    }
+    if (mode != OS_MODE_PER_SOURCE) {
+        return NULL;
+    }
+
+    __u64 now = bpf_ktime_get_ns();
+    __u64 session_ns = (__u64)get_egress_config(EGRESS_CONFIG_OS_SESSION_SEC) * NS_PER_SEC;
+    struct os_assignment *a = bpf_map_lookup_elem(&os_assignments, &daddr);
+    if (a && ((a->flags & OS_ASSIGN_PINNED) || session_ns == 0 || now - a->last_seen_ns < session_ns)) {
+        a->last_seen_ns = now;
+        idx = a->profile;
+        return bpf_map_lookup_elem(&os_profiles, &idx);
+    }
+
+    __u32 count = get_egress_config(EGRESS_CONFIG_OS_PROFILE_COUNT);
+    if (count == 0 || count > OS_PROFILE_MAX) {
+        return NULL;
+    }
+    struct os_assignment fresh = {
+        .last_seen_ns = now,
+        .profile = bpf_get_prandom_u32() % count,
+    };
+    bpf_map_update_elem(&os_assignments, &daddr, &fresh, BPF_ANY);
+    idx = fresh.profile;
+    return bpf_map_lookup_elem(&os_profiles, &idx);
+}
+
+// Size of a profile option in the SYN-ACK. Options the kernel did not
+// negotiate are left out, as a real stack would.
+static __always_inline __u32 os_opt_len(__u8 kind, int has_ws, int has_sack, int has_ts) {
+    switch (kind) {
+    case TCPOPT_NOP: return 1;
+    case TCPOPT_MSS: return 4;
+    case TCPOPT_WINDOW: return has_ws ? 3 : 0;
+    case TCPOPT_SACK_PERM: return has_sack ? 2 : 0;
+    case TCPOPT_TIMESTAMP: return has_ts ? 10 : 0;
+    default: return 0;
+    }
+}
+
+static __always_inline __u16 csum_fold(__u64 csum) {
+    #pragma unroll
+    for (int i = 0; i < 4; i++) {
+        if (csum >> 16) csum = (csum & 0xffff) + (csum >> 16);
+    }
+    return (__u16)~csum;
+}
+
+// Whether the profile sends option kind
+static __always_inline int os_profile_has(struct os_profile *p, __u8 kind) {
+    #pragma unroll
+    for (int i = 0; i < OS_PROFILE_MAX_OPTS; i++) {
+        if (i >= p->opt_count) break;
+        if (p->opts[i] == kind) return 1;
+    }
+    return 0;
+}
+
+// Rebuild the options of a SYN-ACK (window, MSS and option order) to match
+// the profile. What the kernel negotiated is kept, since it goes on to use
+// it for the connection: the window scale shift, SACK and timestamps the
+// kernel sent are sent again, after the profile's options if the profile
+// has none of them, and the window never exceeds the kernel's. The TCP
+// header may grow or shrink, so the packet is resized. Returns 1 if
+// rewritten, 0 if left alone, -1 if the packet was damaged and must be
+// dropped.
+static __always_inline int rewrite_synack(struct __sk_buff *skb, struct os_profile *p) {
+    void *data_end = (void *)(long)skb->data_end;
+    void *data = (void *)(long)skb->data;
+    __u32 tcp_off = sizeof(struct ethhdr) + sizeof(struct iphdr);
+
+    struct iphdr *ip = data + sizeof(struct ethhdr);
+    struct tcphdr *tcp = data + tcp_off;
+    if ((void *)(tcp + 1) > data_end) return 0;
+
+    __u32 old_len = tcp->doff * 4;
+    if (old_len < sizeof(*tcp) || old_len > TCP_MAX_HDR_LEN) return 0;
+    // SYN-ACKs carrying data (TCP Fast Open) are left alone
+    if (bpf_ntohs(ip->tot_len) != sizeof(*ip) + old_len) return 0;
+    if (skb->len != tcp_off + old_len) return 0;
+
+    // Options the kernel negotiated with the client
+    __u16 mss = p->mss;
+    int has_ws = 0, has_sack = 0, has_ts = 0;
+    __u8 wscale = 0;
+    __u32 tsval = 0, tsecr = 0;
+    __u8 *opt = (__u8 *)(tcp + 1);
+    __u8 *opt_end = (__u8 *)tcp + old_len;
+
+    #pragma unroll
+    for (int i = 0; i < TCP_MAX_OPT_LEN; i++) {
+        if (opt >= opt_end || (void *)(opt + 1) > data_end) break;
+        __u8 kind = opt[0];
+        if (kind == TCPOPT_EOL) break;
+        if (kind == TCPOPT_NOP) {
+            opt++;
+            continue;
+        }
+        if ((void *)(opt + 2) > data_end) break;
+        __u8 len = opt[1];
+        if (len < 2 || opt + len > opt_end) break;
+
+        if (kind == TCPOPT_MSS && len == 4 && (void *)(opt + 4) <= data_end) {
+            __u16 real_mss = (opt[2] << 8) | opt[3];
+            if (real_mss < mss) mss = real_mss;  // Never advertise more than the link allows
+        } else if (kind == TCPOPT_WINDOW && len == 3 && (void *)(opt + 3) <= data_end) {
+            has_ws = 1;
+            wscale = opt[2];
+        } else if (kind == TCPOPT_SACK_PERM) {
+            has_sack = 1;
+        } else if (kind == TCPOPT_TIMESTAMP && len == 10 && (void *)(opt + 10) <= data_end) {
+            has_ts = 1;
+            __builtin_memcpy(&tsval, opt + 2, 4);
+            __builtin_memcpy(&tsecr, opt + 6, 4);
+        }
+        opt += len;
+    }
+
+    __u32 opt_len = 0;
+    #pragma unroll
+    for (int i = 0; i < OS_PROFILE_MAX_OPTS; i++) {
+        if (i >= p->opt_count) break;
+        opt_len += os_opt_len(p->opts[i], has_ws, has_sack, has_ts);
+    }
+    // Negotiated options the profile leaves out are appended
+    int add_ws = has_ws && !os_profile_has(p, TCPOPT_WINDOW);
+    int add_sack = has_sack && !os_profile_has(p, TCPOPT_SACK_PERM);
+    int add_ts = has_ts && !os_profile_has(p, TCPOPT_TIMESTAMP);
+    opt_len += (add_ws ? 3 : 0) + (add_sack ? 2 : 0) + (add_ts ? 10 : 0);
+    __u32 new_len = sizeof(*tcp) + ((opt_len + 3) & ~3U);
+    if (new_len > TCP_MAX_HDR_LEN) return 0;
+
+    // Checksum of the old header, subtracted from tcp->check below. The check
+    // field itself is unchanged until then, so it cancels out.
+    __u32 hdr[TCP_MAX_HDR_LEN / 4] = {};
+    if (bpf_skb_load_bytes(skb, tcp_off, hdr, old_len) < 0) return 0;
+    __s64 diff = bpf_csum_diff(hdr, old_len, NULL, 0, 0);
+
+    if (new_len != old_len) {
+        if (bpf_skb_change_tail(skb, tcp_off + new_len, 0) < 0) return 0;
+    }
+
+    // From here on the packet is being modified
+    data_end = (void *)(long)skb->data_end;
+    data = (void *)(long)skb->data;
+    ip = data + sizeof(struct ethhdr);
+    tcp = data + tcp_off;
+    if ((void *)(tcp + 1) > data_end) return -1;
+
+    ip->tot_len = bpf_htons(sizeof(*ip) + new_len);
+    tcp->doff = new_len / 4;
+    // A larger window would let the client send what the kernel drops
+    if (p->window < bpf_ntohs(tcp->window)) tcp->window = bpf_htons(p->window);
+
+    __u8 *o = (__u8 *)(tcp + 1);
+    #pragma unroll
+    for (int i = 0; i < OS_PROFILE_MAX_OPTS; i++) {
+        if (i >= p->opt_count) break;
+        switch (p->opts[i]) {
+        case TCPOPT_NOP:
+            if ((void *)(o + 1) > data_end) return -1;
+            o[0] = TCPOPT_NOP;
+            o += 1;
+            break;
+        case TCPOPT_MSS:
+            if ((void *)(o + 4) > data_end) return -1;
+            o[0] = TCPOPT_MSS;
+            o[1] = 4;
+            o[2] = mss >> 8;
+            o[3] = mss & 0xff;
+            o += 4;
+            break;
+        case TCPOPT_WINDOW:
+            if (!has_ws) break;
+            if ((void *)(o + 3) > data_end) return -1;
+            o[0] = TCPOPT_WINDOW;
+            o[1] = 3;
+            o[2] = wscale;
+            o += 3;
+            break;
+        case TCPOPT_SACK_PERM:
+            if (!has_sack) break;
+            if ((void *)(o + 2) > data_end) return -1;
+            o[0] = TCPOPT_SACK_PERM;
+            o[1] = 2;
+            o += 2;
+            break;
+        case TCPOPT_TIMESTAMP:
+            if (!has_ts) break;
+            if ((void *)(o + 10) > data_end) return -1;
+            o[0] = TCPOPT_TIMESTAMP;
+            o[1] = 10;
+            __builtin_memcpy(o + 2, &tsval, 4);
+            __builtin_memcpy(o + 6, &tsecr, 4);
+            o += 10;
+            break;
+        }
+    }
+    if (add_ws) {
+        if ((void *)(o + 3) > data_end) return -1;
+        o[0] = TCPOPT_WINDOW;
+        o[1] = 3;
+        o[2] = wscale;
+        o += 3;
+    }
+    if (add_sack) {
+        if ((void *)(o + 2) > data_end) return -1;
+        o[0] = TCPOPT_SACK_PERM;
+        o[1] = 2;
+        o += 2;
+    }
+    if (add_ts) {
+        if ((void *)(o + 10) > data_end) return -1;
+        o[0] = TCPOPT_TIMESTAMP;
+        o[1] = 10;
+        __builtin_memcpy(o + 2, &tsval, 4);
+        __builtin_memcpy(o + 6, &tsecr, 4);
+        o += 10;
+    }
+    // Pad to a 4-byte boundary
+    #pragma unroll
+    for (int i = 0; i < 3; i++) {
+        if (o >= (__u8 *)tcp + new_len || (void *)(o + 1) > data_end) break;
+        o[0] = TCPOPT_EOL;
+        o++;
+    }
+
+    __builtin_memset(hdr, 0, sizeof(hdr));
+    if (bpf_skb_load_bytes(skb, tcp_off, hdr, new_len) < 0) return -1;
+    diff = bpf_csum_diff(NULL, 0, hdr, new_len, diff);
+
+    // Header bytes are only folded in when the checksum is complete; with
+    // offload (CHECKSUM_PARTIAL) the NIC sums the new bytes itself. The
+    // pseudo-header length changes either way.
+    __u32 check_off = tcp_off + __builtin_offsetof(struct tcphdr, check);
+    if (bpf_l4_csum_replace(skb, check_off, 0, diff, 0) < 0) return -1;
+    if (new_len != old_len) {
+        if (bpf_l4_csum_replace(skb, check_off, bpf_htons(old_len), bpf_htons(new_len),
+                                BPF_F_PSEUDO_HDR | sizeof(__u16)) < 0) return -1;
     }
     return 1;
 }
 
-// Detect suspicious data patterns
-static __always_inline int detect_suspicious_pattern(void *data, __u32 data_len) {
-    if (data_len == 0 || data_len > MAX_PAYLOAD_SCAN) return 0;
-    
-    // Check for /etc/passwd format
-    char p1[] = "root:x:0:0:";
-    if (data_len >= 11 && check_pattern(data, p1, 11)) return 1;
-    
-    // Check for SSH private key
-    char p2[] = "-----BEGIN";
-    if (data_len >= 10 && check_pattern(data, p2, 10)) return 2;
-    
-    // Check for Base64 (threshold 95% to avoid false positives)
-    __u32 base64_count = 0;
-    for (__u32 i = 0; i < data_len && i < 64; i++) {
-        char c = ((char *)data)[i];
-        if ((c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || 
-            (c >= '0' && c <= '9') || c == '+' || c == '/' || c == '=') {
-            base64_count++;
+// Set the profile's TTL and DF bit and recompute the IP header checksum
+static __always_inline void rewrite_iphdr(struct __sk_buff *skb, struct os_profile *p) {
+    void *data_end = (void *)(long)skb->data_end;
+    void *data = (void *)(long)skb->data;
+    struct iphdr *ip = data + sizeof(struct ethhdr);
+    if ((void *)(ip + 1) > data_end) return;
+
+    ip->ttl = p->ttl;
+    if (p->df) {
+        ip->frag_off |= bpf_htons(0x4000);
+    } else {
+        ip->frag_off &= ~bpf_htons(0x4000);
+    }
+
+    ip->check = 0;
+    ip->check = csum_fold(bpf_csum_diff(NULL, 0, (__be32 *)ip, sizeof(*ip), 0));
+}
+
+// Make honeypot replies look like the OS profile assigned to the remote
+// address. Replies are recognized by their source port, the honeypot port or
+// a fake port. In sk_lookup steering mode, replies to steered connections
+// come from the port the attacker targeted, which is neither, so they keep
+// the kernel's fingerprint. Returns TC_ACT_SHOT if the packet was damaged,
+// TC_ACT_OK otherwise.
+static __always_inline int apply_os_personality(struct __sk_buff *skb) {
+    void *data_end = (void *)(long)skb->data_end;
+    void *data = (void *)(long)skb->data;
+
+    struct ethhdr *eth = data;
+    if ((void *)(eth + 1) > data_end) return TC_ACT_OK;
+    if (eth->h_proto != bpf_htons(ETH_P_IP)) return TC_ACT_OK;
+
+    // Locally generated packets never carry IP options
+    struct iphdr *ip = (void *)(eth + 1);
+    if ((void *)(ip + 1) > data_end) return TC_ACT_OK;
+    if (ip->ihl != 5 || ip->protocol != IPPROTO_TCP) return TC_ACT_OK;
+
+    struct tcphdr *tcp = (void *)(ip + 1);
+    if ((void *)(tcp + 1) > data_end) return TC_ACT_OK;
+    if (tcp->source != bpf_htons(HONEYPOT_PORT) && !is_fake_port(tcp->source)) return TC_ACT_OK;
+
+    struct os_profile *p = select_os_profile(ip->daddr);
+    if (p == NULL) return TC_ACT_OK;
+
+    if (tcp->syn && tcp->ack) {
+        int ret = rewrite_synack(skb, p);
+        if (ret < 0) return TC_ACT_SHOT;
+        if (ret > 0) {
+            __u32 key = 0;
+            __u64 *val = bpf_map_lookup_elem(&os_mutations, &key);
+            if (val) *val += 1;
         }
     }
-    if (base64_count * 100 > data_len * 95 && data_len > 64) return 3;
-    
-    // Check for SQL dump
-    char p4[] = "INSERT INTO";
-    if (data_len >= 11 && check_pattern(data, p4, 11)) return 4;
-    
-    return 0;
+
+    rewrite_iphdr(skb, p);
+    return TC_ACT_OK;
 }
 
-SEC("tc")
-int phantom_egress_prog(struct __sk_buff *skb) {
+static __always_inline int is_base64_char(__u8 c) {
+    return (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') ||
+           (c >= '0' && c <= '9') || c == '+' || c == '/' || c == '=';
+}
+
+// Whether rule r's pattern occurs in buf at off
+static __always_inline int dlp_match_at(const __u8 *buf, __u32 len, __u32 off, const struct dlp_rule *r) {
+    if (r->len == 0 || off + r->len > len) return 0;
+
+    for (__u32 i = 0; i < DLP_PATTERN_MAX; i++) {
+        if (i >= r->len) break;
+        if (buf[(off + i) & (DLP_SCAN_LEN - 1)] != r->pattern[i]) return 0;
+    }
+    return 1;
+}
+
+// Returns the index of the first rule matching the start of the payload,
+// or -1. buf holds the first len (at most DLP_SCAN_LEN) payload bytes.
+static __always_inline int dlp_match(const __u8 *buf, __u32 len) {
+    __u32 count = get_egress_config(EGRESS_CONFIG_DLP_RULE_COUNT);
+
+    // Base64 rules only judge full windows; short payloads are mostly protocol chatter
+    __u32 b64 = 0;
+    if (len == DLP_SCAN_LEN) {
+        for (__u32 i = 0; i < DLP_SCAN_LEN; i++) {
+            b64 += is_base64_char(buf[i]);
+        }
+    }
+
+    for (__u32 i = 0; i < DLP_RULE_MAX; i++) {
+        if (i >= count) break;
+        __u32 key = i;
+        struct dlp_rule *r = bpf_map_lookup_elem(&dlp_rules, &key);
+        if (r == NULL) break;
+
+        switch (r->kind) {
+        case DLP_RULE_PREFIX:
+            if (dlp_match_at(buf, len, 0, r)) return i;
+            break;
+        case DLP_RULE_CONTAINS:
+            for (__u32 off = 0; off < DLP_SCAN_LEN; off++) {
+                if (off + r->len > len) break;
+                if (dlp_match_at(buf, len, off, r)) return i;
+            }
+            break;
+        case DLP_RULE_BASE64:
+            if (len == DLP_SCAN_LEN && b64 * 100 >= (__u32)r->len * DLP_SCAN_LEN) return i;
+            break;
+        }
+    }
+    return -1;
+}
+
+static __always_inline void emit_dlp_event(struct __sk_buff *skb, struct iphdr *ip, struct tcphdr *tcp,
+                                           __u8 action, __u32 rule, struct dlp_owner *owner) {
+    struct phantom_event ev = {};
+    ev.timestamp_ns = bpf_ktime_get_ns();
+    __builtin_memcpy(ev.saddr, &ip->saddr, 4);
+    __builtin_memcpy(ev.daddr, &ip->daddr, 4);
+    ev.sport = tcp->source;
+    ev.dport = tcp->dest;
+    ev.family = AF_INET;
+    ev.protocol = IPPROTO_TCP;
+    ev.tcp_flags = ((__u8 *)tcp)[13];
+    ev.action = action;
+    ev.reason = EVENT_REASON_DLP;
+    ev.rule = (__u8)rule;
+    if (owner) {
+        ev.pid = owner->pid;
+        __builtin_memcpy(ev.comm, owner->comm, sizeof(ev.comm));
+    }
+
+    long err;
+    if (use_ringbuf) {
+        err = bpf_ringbuf_output(&events, &ev, sizeof(ev), 0);
+    } else {
+        err = bpf_perf_event_output(skb, &events, BPF_F_CURRENT_CPU, &ev, sizeof(ev));
+    }
+    if (err) {
+        __u32 key = 0;
+        __u64 *val = bpf_map_lookup_elem(&events_lost, &key);
+        if (val) *val += 1;
+    }
+}
+
+// Match the start of the TCP payload at payload_off against the DLP rules,
+// count and report a match. Returns 1 if the packet is to be dropped.
+static __always_inline int dlp_check(struct __sk_buff *skb, struct iphdr *ip, struct tcphdr *tcp,
+                                     __u32 payload_off, __u32 mode, struct dlp_owner *owner) {
+    // The payload may sit in paged fragments, so it is copied out rather than
+    // read through data_end. Only the start of each segment is inspected.
+    if (skb->len <= payload_off) return 0;
+    __u32 len = skb->len - payload_off;
+    if (len > DLP_SCAN_LEN) len = DLP_SCAN_LEN;
+
+    __u8 buf[DLP_SCAN_LEN] = {};
+    if (bpf_skb_load_bytes(skb, payload_off, buf, len) < 0) return 0;
+
+    int rule = dlp_match(buf, len);
+    if (rule < 0) return 0;
+
+    __u32 key = (__u32)rule;
+    __u64 *hits = bpf_map_lookup_elem(&dlp_hits, &key);
+    if (hits) *hits += 1;
+
+    if (mode != DLP_MODE_ENFORCE) {
+        emit_dlp_event(skb, ip, tcp, EVENT_ACTION_PASS, key, owner);
+        return 0;
+    }
+
+    __u32 zero = 0;
+    __u64 *blocked = bpf_map_lookup_elem(&egress_blocks, &zero);
+    if (blocked) __sync_fetch_and_add(blocked, 1);
+    emit_dlp_event(skb, ip, tcp, EVENT_ACTION_DROP, key, owner);
+    return 1;
+}
+
+// In redirect steering mode XDP rewrote the destination port of the
+// attacker's segments to the honeypot port. The replies get the port the
+// attacker targeted back as their source port, or the attacker's stack would
+// answer them with a RST.
+static __always_inline void restore_redirected_port(struct __sk_buff *skb) {
+    void *data_end = (void *)(long)skb->data_end;
+    void *data = (void *)(long)skb->data;
+
+    struct ethhdr *eth = data;
+    if ((void *)(eth + 1) > data_end) return;
+    if (eth->h_proto != bpf_htons(ETH_P_IP)) return;
+
+    struct iphdr *ip = (void *)(eth + 1);
+    if ((void *)(ip + 1) > data_end) return;
+    if (ip->ihl != 5 || ip->protocol != IPPROTO_TCP) return;
+
+    struct tcphdr *tcp = (void *)(ip + 1);
+    if ((void *)(tcp + 1) > data_end) return;
+    if (tcp->source != bpf_htons(HONEYPOT_PORT)) return;
+
+    struct redirect_key key = {
+        .saddr = ip->daddr,
+        .sport = tcp->dest,
+        .pad = 0,
+    };
+    __be16 *orig_port = bpf_map_lookup_elem(&redirect_map, &key);
+    if (orig_port == NULL) return;
+
+    __be16 old_port = tcp->source;
+    __be16 new_port = *orig_port;
+    __u32 tcp_off = sizeof(struct ethhdr) + sizeof(struct iphdr);
+    if (bpf_l4_csum_replace(skb, tcp_off + __builtin_offsetof(struct tcphdr, check),
+                            old_port, new_port, sizeof(__u16)) < 0) return;
+    bpf_skb_store_bytes(skb, tcp_off + __builtin_offsetof(struct tcphdr, source),
+                        &new_port, sizeof(new_port), 0);
+}
+
+// DLP for traffic leaving the interface from the DLP ports. Returns
+// TC_ACT_SHOT if the packet must be blocked, TC_ACT_OK otherwise.
+static __always_inline int dlp_egress(struct __sk_buff *skb) {
+    __u32 mode = get_egress_config(EGRESS_CONFIG_DLP_MODE);
+    if (mode == DLP_MODE_OFF) return TC_ACT_OK;
+
     void *data_end = (void *)(long)skb->data_end;
     void *data = (void *)(long)skb->data;
     
@@ -88,39 +728,55 @@ int phantom_egress_prog(struct __sk_buff *skb) {
     
     struct iphdr *ip = (void *)(eth + 1);
     if ((void *)(ip + 1) > data_end) return TC_ACT_OK;
-    if (ip->protocol != IPPROTO_TCP) return TC_ACT_OK;
+    if (ip->ihl != 5 || ip->protocol != IPPROTO_TCP) return TC_ACT_OK;
     
     struct tcphdr *tcp = (void *)(ip + 1);
     if ((void *)(tcp + 1) > data_end) return TC_ACT_OK;
     
-    // Only check data from honeypot port
-    if (tcp->source != bpf_htons(HONEYPOT_PORT)) return TC_ACT_OK;
-    
-    __u32 tcp_hdr_len = (tcp->doff) * 4;
-    void *tcp_start = (void *)tcp;
-    void *payload = (void *)((char *)tcp_start + tcp_hdr_len);
-    if (payload > data_end) return TC_ACT_OK;
-    
-    __u32 payload_len = (__u32)(data_end - payload);
-    if (payload_len == 0) return TC_ACT_OK;
-    if (payload_len > MAX_PAYLOAD_SCAN) payload_len = MAX_PAYLOAD_SCAN;
-    
-    int pattern_type = detect_suspicious_pattern(payload, payload_len);
-    
-    if (pattern_type > 0) {
-        __u32 key = 0;
-        __u64 *val = bpf_map_lookup_elem(&egress_blocks, &key);
-        if (val) __sync_fetch_and_add(val, 1);
-        
-        __u32 pattern_key = (__u32)pattern_type;
-        __u64 *pattern_val = bpf_map_lookup_elem(&suspicious_patterns, &pattern_key);
-        if (pattern_val) __sync_fetch_and_add(pattern_val, 1);
+    // Only traffic from the DLP ports; cgroups have their own program
+    __u16 port = bpf_ntohs(tcp->source);
+    if (!bpf_map_lookup_elem(&dlp_ports, &port)) return TC_ACT_OK;
 
-        // Return TC_ACT_OK for demo mode (use TC_ACT_SHOT to actually block)
-        return TC_ACT_OK; 
-    }
+    __u32 payload_off = sizeof(struct ethhdr) + sizeof(struct iphdr) + tcp->doff * 4;
+    if (dlp_check(skb, ip, tcp, payload_off, mode, NULL)) return TC_ACT_SHOT;
+    return TC_ACT_OK;
+}
+
+SEC("tc")
+int phantom_egress_prog(struct __sk_buff *skb) {
+    // Rewrite honeypot replies first; this may resize the packet, so headers
+    // are parsed afterwards
+    if (apply_os_personality(skb) == TC_ACT_SHOT) return TC_ACT_SHOT;
+    if (dlp_egress(skb) == TC_ACT_SHOT) return TC_ACT_SHOT;
 
+    // Last, since the OS personality and DLP recognize honeypot replies by
+    // the honeypot port
+    restore_redirected_port(skb);
     return TC_ACT_OK;
 }
 
+// DLP for everything the processes of a cgroup send, on any interface.
+// Attached by the agent to each configured cgroup; descendants are covered.
+SEC("cgroup_skb/egress")
+int phantom_dlp_cgroup(struct __sk_buff *skb) {
+    __u32 mode = get_egress_config(EGRESS_CONFIG_DLP_MODE);
+    if (mode == DLP_MODE_OFF) return CGROUP_SKB_ALLOW;
+    if (skb->protocol != bpf_htons(ETH_P_IP)) return CGROUP_SKB_ALLOW;
+
+    // skb->data starts at the IP header here
+    struct iphdr ip;
+    if (bpf_skb_load_bytes(skb, 0, &ip, sizeof(ip)) < 0) return CGROUP_SKB_ALLOW;
+    if (ip.protocol != IPPROTO_TCP || ip.ihl < 5) return CGROUP_SKB_ALLOW;
+
+    __u32 ip_len = ip.ihl * 4;
+    struct tcphdr tcp;
+    if (bpf_skb_load_bytes(skb, ip_len, &tcp, sizeof(tcp)) < 0) return CGROUP_SKB_ALLOW;
+
+    __u64 cookie = bpf_get_socket_cookie(skb);
+    struct dlp_owner *owner = bpf_map_lookup_elem(&dlp_socket_owners, &cookie);
+
+    if (dlp_check(skb, &ip, &tcp, ip_len + tcp.doff * 4, mode, owner)) return CGROUP_SKB_DROP;
+    return CGROUP_SKB_ALLOW;
+}
+
 char _license[] SEC("license") = "GPL";
\ No newline at end of file
@@ -0,0 +1,101 @@
+package ebpf
+
+import (
+	"fmt"
+	"net"
+	"sort"
+
+	"github.com/cilium/ebpf"
+
+	"phantom-grid/internal/config"
+)
+
+// tokenBucket mirrors struct token_bucket in programs/phantom.c
+type tokenBucket struct {
+	Tokens uint64
+	LastNs uint64
+}
+
+// rateState mirrors struct rate_state in programs/phantom.c
+type rateState struct {
+	SPA          tokenBucket
+	SYN          tokenBucket
+	Total        tokenBucket
+	SPAPackets   uint64
+	SYNPackets   uint64
+	TotalPackets uint64
+	SPADrops     uint64
+	SYNDrops     uint64
+	TotalDrops   uint64
+}
+
+// SourceRateStats holds per-source packet and drop counts from the XDP rate limiter
+type SourceRateStats struct {
+	IP           net.IP
+	SPAPackets   uint64
+	SYNPackets   uint64
+	TotalPackets uint64
+	SPADrops     uint64
+	SYNDrops     uint64
+	TotalDrops   uint64
+}
+
+// Dropped returns the number of packets dropped for this source across all limits
+func (s SourceRateStats) Dropped() uint64 {
+	return s.SPADrops + s.SYNDrops + s.TotalDrops
+}
+
+// SetRateLimits loads per-source rate limits into the XDP program
+func (l *Loader) SetRateLimits(cfg config.RateLimitConfiguration) error {
+	values := map[uint32]uint32{
+		xdpConfigRateSPAPPS:     cfg.SPA.PacketsPerSecond,
+		xdpConfigRateSPABurst:   cfg.SPA.Burst,
+		xdpConfigRateSYNPPS:     cfg.SYN.PacketsPerSecond,
+		xdpConfigRateSYNBurst:   cfg.SYN.Burst,
+		xdpConfigRateTotalPPS:   cfg.Total.PacketsPerSecond,
+		xdpConfigRateTotalBurst: cfg.Total.Burst,
+	}
+	for key, value := range values {
+		if err := l.PhantomObjs.XdpConfig.Put(key, value); err != nil {
+			return fmt.Errorf("failed to set rate limit config key %d: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// RateLimitStats returns per-source counters from the rate_limits map,
+// sorted by dropped packets (highest first)
+func RateLimitStats(m *ebpf.Map) ([]SourceRateStats, error) {
+	if m == nil {
+		return nil, fmt.Errorf("rate_limits map not available")
+	}
+
+	var (
+		key   [4]byte
+		state rateState
+		stats []SourceRateStats
+	)
+	iter := m.Iterate()
+	for iter.Next(&key, &state) {
+		stats = append(stats, SourceRateStats{
+			IP:           net.IPv4(key[0], key[1], key[2], key[3]),
+			SPAPackets:   state.SPAPackets,
+			SYNPackets:   state.SYNPackets,
+			TotalPackets: state.TotalPackets,
+			SPADrops:     state.SPADrops,
+			SYNDrops:     state.SYNDrops,
+			TotalDrops:   state.TotalDrops,
+		})
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate rate_limits: %w", err)
+	}
+
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Dropped() != stats[j].Dropped() {
+			return stats[i].Dropped() > stats[j].Dropped()
+		}
+		return stats[i].TotalPackets > stats[j].TotalPackets
+	})
+	return stats, nil
+}
@@ -0,0 +1,126 @@
+package ebpf
+
+import (
+	"encoding/binary"
+	"net"
+	"os"
+	"testing"
+
+	"phantom-grid/internal/config"
+)
+
+const (
+	xdpDrop = 1
+	xdpPass = 2
+)
+
+// buildTCPPacket builds an Ethernet/IPv4/TCP frame for BPF_PROG_TEST_RUN
+func buildTCPPacket(src net.IP, sport, dport uint16, flags uint8) []byte {
+	pkt := make([]byte, 14+20+20)
+
+	// Ethernet
+	binary.BigEndian.PutUint16(pkt[12:14], 0x0800)
+
+	// IPv4
+	ip := pkt[14:34]
+	ip[0] = 0x45
+	binary.BigEndian.PutUint16(ip[2:4], 40)
+	ip[8] = 64
+	ip[9] = 6
+	copy(ip[12:16], src.To4())
+	copy(ip[16:20], net.IPv4(10, 0, 0, 1).To4())
+
+	// TCP
+	tcp := pkt[34:54]
+	binary.BigEndian.PutUint16(tcp[0:2], sport)
+	binary.BigEndian.PutUint16(tcp[2:4], dport)
+	tcp[12] = 5 << 4
+	tcp[13] = flags
+	binary.BigEndian.PutUint16(tcp[14:16], 64240)
+	return pkt
+}
+
+// honeypotOnlyPort returns a fake port that is not also a critical port
+func honeypotOnlyPort(t *testing.T) uint16 {
+	t.Helper()
+	critical := make(map[int]bool)
+	for _, p := range config.CriticalPorts {
+		critical[p] = true
+	}
+	for _, p := range config.FakePorts {
+		if !critical[p] {
+			return uint16(p)
+		}
+	}
+	t.Skip("no fake port outside the critical set")
+	return 0
+}
+
+func loadTestLoader(t *testing.T) *Loader {
+	t.Helper()
+	if os.Geteuid() != 0 {
+		t.Skip("BPF_PROG_TEST_RUN requires root")
+	}
+	loader, err := NewLoader()
+	if err != nil {
+		t.Skipf("Cannot load eBPF objects: %v", err)
+	}
+	t.Cleanup(func() { loader.Close() })
+	return loader
+}
+
+func TestRateLimitSYNFlood(t *testing.T) {
+	loader := loadTestLoader(t)
+
+	limits := config.RateLimitConfiguration{
+		SYN: config.RateLimit{PacketsPerSecond: 1, Burst: 2},
+	}
+	if err := loader.SetRateLimits(limits); err != nil {
+		t.Fatalf("SetRateLimits() error: %v", err)
+	}
+
+	src := net.IPv4(192, 0, 2, 10)
+	port := honeypotOnlyPort(t)
+	want := []uint32{xdpPass, xdpPass, xdpDrop, xdpDrop}
+	for i, w := range want {
+		pkt := buildTCPPacket(src, uint16(40000+i), port, 0x02)
+		ret, _, err := loader.PhantomObjs.PhantomProg.Test(pkt)
+		if err != nil {
+			t.Skipf("BPF_PROG_TEST_RUN not supported: %v", err)
+		}
+		if ret != w {
+			t.Errorf("SYN %d: got verdict %d, want %d", i, ret, w)
+		}
+	}
+
+	stats, err := RateLimitStats(loader.PhantomObjs.RateLimits)
+	if err != nil {
+		t.Fatalf("RateLimitStats() error: %v", err)
+	}
+	if len(stats) == 0 || !stats[0].IP.Equal(src) {
+		t.Fatalf("RateLimitStats() missing source %s: %+v", src, stats)
+	}
+	if stats[0].SYNPackets != 4 || stats[0].SYNDrops != 2 {
+		t.Errorf("stats = %+v, want 4 SYN packets and 2 drops", stats[0])
+	}
+}
+
+func TestRateLimitDisabled(t *testing.T) {
+	loader := loadTestLoader(t)
+
+	if err := loader.SetRateLimits(config.RateLimitConfiguration{}); err != nil {
+		t.Fatalf("SetRateLimits() error: %v", err)
+	}
+
+	port := honeypotOnlyPort(t)
+	for i := 0; i < 20; i++ {
+		pkt := buildTCPPacket(net.IPv4(192, 0, 2, 20), uint16(40000+i), port, 0x02)
+		ret, _, err := loader.PhantomObjs.PhantomProg.Test(pkt)
+		if err != nil {
+			t.Skipf("BPF_PROG_TEST_RUN not supported: %v", err)
+		}
+		if ret != xdpPass {
+			t.Fatalf("SYN %d: got verdict %d with limits disabled, want XDP_PASS", i, ret)
+		}
+	}
+}
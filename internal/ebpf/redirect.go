@@ -0,0 +1,54 @@
+package ebpf
+
+import (
+	"encoding/binary"
+	"net"
+
+	"github.com/cilium/ebpf"
+)
+
+// redirectKey mirrors struct redirect_key in programs/phantom.c
+// Address and port are kept in network byte order, exactly as XDP sees them
+type redirectKey struct {
+	SrcIP   [4]byte
+	SrcPort [2]byte
+	Pad     uint16
+}
+
+// RedirectTable looks up the original destination port of connections
+// that XDP redirected to the honeypot fallback port
+type RedirectTable struct {
+	m *ebpf.Map
+}
+
+// NewRedirectTable wraps the redirect_map from the phantom XDP program
+func NewRedirectTable(m *ebpf.Map) *RedirectTable {
+	return &RedirectTable{m: m}
+}
+
+// OriginalPort returns the port the remote peer originally targeted
+func (r *RedirectTable) OriginalPort(remote *net.TCPAddr) (int, bool) {
+	if r == nil || r.m == nil || remote == nil {
+		return 0, false
+	}
+
+	ipv4 := remote.IP.To4()
+	if ipv4 == nil {
+		return 0, false
+	}
+
+	var key redirectKey
+	copy(key.SrcIP[:], ipv4)
+	binary.BigEndian.PutUint16(key.SrcPort[:], uint16(remote.Port))
+
+	var value [2]byte
+	if err := r.m.Lookup(&key, &value); err != nil {
+		return 0, false
+	}
+
+	port := int(binary.BigEndian.Uint16(value[:]))
+	if port == 0 {
+		return 0, false
+	}
+	return port, true
+}
@@ -0,0 +1,96 @@
+package ebpf
+
+import (
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+
+	"phantom-grid/internal/config"
+)
+
+// TestRedirectSteeringNetNs redirects a connection to the honeypot port with
+// XDP and TC egress attached to lo in a throwaway network namespace: the SYN
+// is rewritten to HoneypotPort, the honeypot accepts, resolves the original
+// port from redirect_map and exchanges data with the client, which only ever
+// sees the port it dialed. Requires root.
+func TestRedirectSteeringNetNs(t *testing.T) {
+	_, lo := enterTestNetNs(t)
+
+	loader, err := NewLoader()
+	if err != nil {
+		t.Skipf("Cannot load eBPF objects: %v", err)
+	}
+	defer loader.Close()
+
+	if err := loader.SetSteeringMode(config.SteeringModeRedirect); err != nil {
+		t.Fatalf("SetSteeringMode failed: %v", err)
+	}
+	if err := loader.LoadEgress(); err != nil {
+		t.Skipf("Cannot load egress objects: %v", err)
+	}
+
+	honeypotLn, err := net.Listen("tcp", net.JoinHostPort(skLookupTestAddr, strconv.Itoa(config.HoneypotPort)))
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer honeypotLn.Close()
+
+	if _, err := loader.AttachXDP(lo.Attrs().Index); err != nil {
+		t.Skipf("Cannot attach XDP: %v", err)
+	}
+	attachTestEgress(t, lo.Attrs().Index, loader.EgressObjs.PhantomEgressProg)
+
+	server, client := dialAndAcceptConn(t, honeypotLn, skLookupTestAddr+":5985")
+	if local := server.LocalAddr().(*net.TCPAddr); local.Port != config.HoneypotPort {
+		t.Errorf("Redirected connection local port = %d, want %d", local.Port, config.HoneypotPort)
+	}
+	if remote := client.RemoteAddr().(*net.TCPAddr); remote.Port != 5985 {
+		t.Errorf("Client remote port = %d, want 5985", remote.Port)
+	}
+
+	table := NewRedirectTable(loader.PhantomObjs.RedirectMap)
+	port, ok := table.OriginalPort(server.RemoteAddr().(*net.TCPAddr))
+	if !ok || port != 5985 {
+		t.Errorf("OriginalPort() = %d, %v; want 5985, true", port, ok)
+	}
+
+	exchange(t, client, server)
+}
+
+// attachTestEgress attaches prog to the egress hook of the interface,
+// the same way the agent does
+func attachTestEgress(t *testing.T, ifaceIndex int, prog *ebpf.Program) {
+	t.Helper()
+
+	qdisc := &netlink.GenericQdisc{
+		QdiscAttrs: netlink.QdiscAttrs{
+			LinkIndex: ifaceIndex,
+			Handle:    netlink.MakeHandle(0xffff, 0),
+			Parent:    netlink.HANDLE_CLSACT,
+		},
+		QdiscType: "clsact",
+	}
+	if err := netlink.QdiscAdd(qdisc); err != nil {
+		t.Skipf("Cannot add clsact qdisc: %v", err)
+	}
+
+	filter := &netlink.BpfFilter{
+		FilterAttrs: netlink.FilterAttrs{
+			LinkIndex: ifaceIndex,
+			Parent:    netlink.HANDLE_MIN_EGRESS,
+			Handle:    1,
+			Protocol:  unix.ETH_P_ALL,
+			Priority:  1,
+		},
+		Fd:           prog.FD(),
+		Name:         "phantom_egress",
+		DirectAction: true,
+	}
+	if err := netlink.FilterReplace(filter); err != nil {
+		t.Skipf("Cannot attach TC egress: %v", err)
+	}
+}
@@ -0,0 +1,107 @@
+package ebpf
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/link"
+	"golang.org/x/sys/unix"
+)
+
+// AttachSkLookup attaches the sk_lookup steering program to the agent's network namespace
+func (l *Loader) AttachSkLookup() (link.Link, error) {
+	netns, err := os.Open("/proc/self/ns/net")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open network namespace: %w", err)
+	}
+	defer netns.Close()
+
+	return l.AttachSkLookupToNetNs(int(netns.Fd()))
+}
+
+// AttachSkLookupToNetNs attaches the sk_lookup steering program to the given network namespace
+func (l *Loader) AttachSkLookupToNetNs(netnsFD int) (link.Link, error) {
+	if l.pinDir != "" {
+		var st unix.Stat_t
+		if err := unix.Fstat(netnsFD, &st); err != nil {
+			return nil, fmt.Errorf("failed to inspect network namespace: %w", err)
+		}
+		skLookupLink, err := takeOverLink(filepath.Join(l.pinDir, skLookupLinkPin), l.PhantomObjs.PhantomSkLookup, func(info *link.Info) bool {
+			netns := info.NetNs()
+			return netns == nil || uint64(netns.NetnsIno) == st.Ino
+		})
+		if err != nil {
+			return nil, err
+		}
+		if skLookupLink != nil {
+			l.skLookupLink = skLookupLink
+			return skLookupLink, nil
+		}
+	}
+
+	skLookupLink, err := link.AttachNetNs(netnsFD, l.PhantomObjs.PhantomSkLookup)
+	if err != nil {
+		return nil, fmt.Errorf("failed to attach sk_lookup: %w", err)
+	}
+	if err := l.pinLink(skLookupLink, skLookupLinkPin); err != nil {
+		skLookupLink.Close()
+		return nil, err
+	}
+	l.skLookupLink = skLookupLink
+	return skLookupLink, nil
+}
+
+// RemovePinnedSkLookup detaches sk_lookup steering left by a previous agent,
+// for when this agent steers by redirecting packets instead
+func (l *Loader) RemovePinnedSkLookup() error {
+	if l.pinDir == "" {
+		return nil
+	}
+	path := filepath.Join(l.pinDir, skLookupLinkPin)
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove pinned sk_lookup link: %w", err)
+	}
+	return nil
+}
+
+// RegisterHoneypotSocket stores the honeypot listener in the steering socket map
+func (l *Loader) RegisterHoneypotSocket(ln net.Listener) error {
+	tcpListener, ok := ln.(*net.TCPListener)
+	if !ok {
+		return fmt.Errorf("honeypot listener is not a TCP listener")
+	}
+
+	rawConn, err := tcpListener.SyscallConn()
+	if err != nil {
+		return fmt.Errorf("failed to access honeypot socket: %w", err)
+	}
+
+	var updateErr error
+	err = rawConn.Control(func(fd uintptr) {
+		updateErr = l.PhantomObjs.HoneypotSocket.Update(uint32(0), uint64(fd), ebpf.UpdateAny)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to access honeypot socket: %w", err)
+	}
+	if updateErr != nil {
+		return fmt.Errorf("failed to register honeypot socket: %w", updateErr)
+	}
+	return nil
+}
+
+// SetPassthroughPorts sets ports that sk_lookup must leave to the regular socket lookup
+func (l *Loader) SetPassthroughPorts(ports []int) error {
+	for _, port := range ports {
+		if port < 1 || port > 65535 {
+			return fmt.Errorf("invalid passthrough port: %d", port)
+		}
+		if err := l.PhantomObjs.SteerPassthrough.Put(uint16(port), uint8(1)); err != nil {
+			return fmt.Errorf("failed to add passthrough port %d: %w", port, err)
+		}
+	}
+	return nil
+}
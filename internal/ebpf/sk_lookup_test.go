@@ -0,0 +1,238 @@
+package ebpf
+
+import (
+	"io"
+	"net"
+	"os"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+
+	"phantom-grid/internal/config"
+)
+
+const skLookupTestAddr = "10.200.0.1"
+
+// TestSkLookupSteeringNetNs runs sk_lookup steering inside a throwaway network namespace.
+// Requires root and a kernel with sk_lookup support (5.9+).
+func TestSkLookupSteeringNetNs(t *testing.T) {
+	testNs, _ := enterTestNetNs(t)
+
+	loader, err := NewLoader()
+	if err != nil {
+		t.Skipf("Cannot load eBPF objects: %v", err)
+	}
+	defer loader.Close()
+
+	if err := loader.SetSteeringMode(config.SteeringModeSkLookup); err != nil {
+		t.Fatalf("SetSteeringMode failed: %v", err)
+	}
+
+	honeypotLn, err := net.Listen("tcp", skLookupTestAddr+":0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer honeypotLn.Close()
+
+	serviceLn, err := net.Listen("tcp", skLookupTestAddr+":8081")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer serviceLn.Close()
+
+	if err := loader.RegisterHoneypotSocket(honeypotLn); err != nil {
+		t.Fatalf("RegisterHoneypotSocket failed: %v", err)
+	}
+	if err := loader.SetPassthroughPorts([]int{8081}); err != nil {
+		t.Fatalf("SetPassthroughPorts failed: %v", err)
+	}
+	if _, err := loader.AttachSkLookupToNetNs(int(testNs)); err != nil {
+		t.Skipf("Cannot attach sk_lookup: %v", err)
+	}
+
+	t.Run("unprotected port is steered to honeypot", func(t *testing.T) {
+		local := dialAndAccept(t, honeypotLn, skLookupTestAddr+":5985")
+		if local.Port != 5985 {
+			t.Errorf("Steered connection local port = %d, want 5985", local.Port)
+		}
+	})
+
+	t.Run("passthrough port keeps its own listener", func(t *testing.T) {
+		local := dialAndAccept(t, serviceLn, skLookupTestAddr+":8081")
+		if local.Port != 8081 {
+			t.Errorf("Passthrough connection local port = %d, want 8081", local.Port)
+		}
+	})
+
+	t.Run("critical port is not steered", func(t *testing.T) {
+		conn, err := net.DialTimeout("tcp", net.JoinHostPort(skLookupTestAddr, "22"), time.Second)
+		if err == nil {
+			conn.Close()
+			t.Fatal("Connection to critical port without listener should be refused")
+		}
+	})
+}
+
+// enterTestNetNs moves the test onto a locked thread in a throwaway network
+// namespace with lo up and skLookupTestAddr assigned to it. The original
+// namespace is restored when the test ends.
+func enterTestNetNs(t *testing.T) (netns.NsHandle, netlink.Link) {
+	t.Helper()
+	if os.Geteuid() != 0 {
+		t.Skip("netns integration test requires root")
+	}
+
+	// Namespaces are per-thread; keep the test on one thread
+	runtime.LockOSThread()
+	t.Cleanup(runtime.UnlockOSThread)
+
+	origNs, err := netns.Get()
+	if err != nil {
+		t.Fatalf("Failed to get current netns: %v", err)
+	}
+	t.Cleanup(func() { origNs.Close() })
+
+	testNs, err := netns.New()
+	if err != nil {
+		t.Skipf("Cannot create network namespace: %v", err)
+	}
+	t.Cleanup(func() {
+		netns.Set(origNs)
+		testNs.Close()
+	})
+
+	lo, err := netlink.LinkByName("lo")
+	if err != nil {
+		t.Fatalf("Failed to find loopback: %v", err)
+	}
+	if err := netlink.LinkSetUp(lo); err != nil {
+		t.Fatalf("Failed to bring up loopback: %v", err)
+	}
+	// sk_lookup ignores 127.0.0.0/8, so use a non-loopback address on lo
+	addr, err := netlink.ParseAddr(skLookupTestAddr + "/32")
+	if err != nil {
+		t.Fatalf("Failed to parse address: %v", err)
+	}
+	if err := netlink.AddrAdd(lo, addr); err != nil {
+		t.Fatalf("Failed to add address: %v", err)
+	}
+	return testNs, lo
+}
+
+// TestSkLookupWithXDPNetNs steers a connection with sk_lookup while XDP is
+// attached to the same interface, so every segment of the flow also passes
+// the XDP stealth scan checks. The handshake ACK and data ACKs must not be
+// taken for an ACK scan.
+func TestSkLookupWithXDPNetNs(t *testing.T) {
+	testNs, lo := enterTestNetNs(t)
+
+	loader, err := NewLoader()
+	if err != nil {
+		t.Skipf("Cannot load eBPF objects: %v", err)
+	}
+	defer loader.Close()
+
+	if err := loader.SetSteeringMode(config.SteeringModeSkLookup); err != nil {
+		t.Fatalf("SetSteeringMode failed: %v", err)
+	}
+	// Dropping ACK scans silently would make a failure look like a timeout;
+	// a RST makes it fail fast
+	responses := config.StealthResponseConfiguration{
+		Xmas: config.StealthResponseDrop,
+		Null: config.StealthResponseDrop,
+		FIN:  config.StealthResponseDrop,
+		ACK:  config.StealthResponseRSTClosed,
+	}
+	if err := loader.SetStealthResponses(responses); err != nil {
+		t.Fatalf("SetStealthResponses failed: %v", err)
+	}
+
+	honeypotLn, err := net.Listen("tcp", skLookupTestAddr+":0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer honeypotLn.Close()
+
+	if err := loader.RegisterHoneypotSocket(honeypotLn); err != nil {
+		t.Fatalf("RegisterHoneypotSocket failed: %v", err)
+	}
+	if _, err := loader.AttachSkLookupToNetNs(int(testNs)); err != nil {
+		t.Skipf("Cannot attach sk_lookup: %v", err)
+	}
+	if _, err := loader.AttachXDP(lo.Attrs().Index); err != nil {
+		t.Skipf("Cannot attach XDP: %v", err)
+	}
+
+	server, client := dialAndAcceptConn(t, honeypotLn, skLookupTestAddr+":5985")
+	if local := server.LocalAddr().(*net.TCPAddr); local.Port != 5985 {
+		t.Errorf("Steered connection local port = %d, want 5985", local.Port)
+	}
+	exchange(t, client, server)
+}
+
+// exchange sends a request from client to server and a reply back
+func exchange(t *testing.T, client, server net.Conn) {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	client.SetDeadline(deadline)
+	server.SetDeadline(deadline)
+
+	buf := make([]byte, 4)
+	if _, err := client.Write([]byte("ping")); err != nil {
+		t.Fatalf("Client write failed: %v", err)
+	}
+	if _, err := io.ReadFull(server, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("Server read = %q, %v; want \"ping\"", buf, err)
+	}
+	if _, err := server.Write([]byte("pong")); err != nil {
+		t.Fatalf("Server write failed: %v", err)
+	}
+	if _, err := io.ReadFull(client, buf); err != nil || string(buf) != "pong" {
+		t.Fatalf("Client read = %q, %v; want \"pong\"", buf, err)
+	}
+}
+
+// dialAndAccept connects to target and returns the local address of the accepted connection
+func dialAndAccept(t *testing.T, ln net.Listener, target string) *net.TCPAddr {
+	t.Helper()
+	server, _ := dialAndAcceptConn(t, ln, target)
+	return server.LocalAddr().(*net.TCPAddr)
+}
+
+// dialAndAcceptConn connects to target and returns both ends of the
+// connection; they are closed when the test ends
+func dialAndAcceptConn(t *testing.T, ln net.Listener, target string) (server, client net.Conn) {
+	t.Helper()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			close(accepted)
+			return
+		}
+		accepted <- conn
+	}()
+
+	client, err := net.DialTimeout("tcp", target, time.Second)
+	if err != nil {
+		t.Fatalf("Dial %s failed: %v", target, err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	select {
+	case conn, ok := <-accepted:
+		if !ok {
+			t.Fatal("Accept failed")
+		}
+		t.Cleanup(func() { conn.Close() })
+		return conn, client
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Connection to %s was not accepted by expected listener", target)
+	}
+	return nil, nil
+}
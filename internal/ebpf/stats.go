@@ -0,0 +1,150 @@
+package ebpf
+
+import (
+	"fmt"
+
+	"github.com/cilium/ebpf"
+)
+
+// DropReason identifies why the XDP program dropped a packet.
+// Values are the keys of the drop_reasons map in programs/phantom.c
+type DropReason uint32
+
+const (
+	DropCriticalPort DropReason = 0
+	DropStealthXmas  DropReason = 1
+	DropStealthNull  DropReason = 2
+	DropStealthFIN   DropReason = 3
+	DropStealthACK   DropReason = 4
+	DropRateLimited  DropReason = 5
+	DropBlocklisted  DropReason = 6
+	DropMalformed    DropReason = 7
+	DropCIDRDeny     DropReason = 8
+	DropFragment     DropReason = 9
+
+	dropReasonMax = 10
+)
+
+// DropReasons lists every drop reason in map key order
+var DropReasons = []DropReason{
+	DropCriticalPort,
+	DropStealthXmas,
+	DropStealthNull,
+	DropStealthFIN,
+	DropStealthACK,
+	DropRateLimited,
+	DropBlocklisted,
+	DropMalformed,
+	DropCIDRDeny,
+	DropFragment,
+}
+
+func (r DropReason) String() string {
+	switch r {
+	case DropCriticalPort:
+		return "critical_port"
+	case DropStealthXmas:
+		return "stealth_xmas"
+	case DropStealthNull:
+		return "stealth_null"
+	case DropStealthFIN:
+		return "stealth_fin"
+	case DropStealthACK:
+		return "stealth_ack"
+	case DropRateLimited:
+		return "rate_limited"
+	case DropBlocklisted:
+		return "blocklisted"
+	case DropMalformed:
+		return "malformed"
+	case DropCIDRDeny:
+		return "cidr_deny"
+	case DropFragment:
+		return "fragment"
+	default:
+		return fmt.Sprintf("reason_%d", uint32(r))
+	}
+}
+
+// DropStats holds packet drop counts indexed by DropReason
+type DropStats [dropReasonMax]uint64
+
+// Get returns the count for a single reason
+func (s DropStats) Get(r DropReason) uint64 {
+	if int(r) >= len(s) {
+		return 0
+	}
+	return s[r]
+}
+
+// Stealth returns drops for all stealth scan types combined
+func (s DropStats) Stealth() uint64 {
+	return s[DropStealthXmas] + s[DropStealthNull] + s[DropStealthFIN] + s[DropStealthACK]
+}
+
+// Total returns drops across all reasons
+func (s DropStats) Total() uint64 {
+	var total uint64
+	for _, v := range s {
+		total += v
+	}
+	return total
+}
+
+// Map returns the counts keyed by reason name, for structured export
+func (s DropStats) Map() map[string]uint64 {
+	m := make(map[string]uint64, len(s))
+	for _, r := range DropReasons {
+		m[r.String()] = s[r]
+	}
+	return m
+}
+
+// SumCounter reads slot key of a per-CPU counter map and sums the per-CPU values
+func SumCounter(m *ebpf.Map, key uint32) (uint64, error) {
+	var values []uint64
+	if err := m.Lookup(key, &values); err != nil {
+		return 0, err
+	}
+	var sum uint64
+	for _, v := range values {
+		sum += v
+	}
+	return sum, nil
+}
+
+// ReadDropStats aggregates the per-CPU drop_reasons map
+func ReadDropStats(m *ebpf.Map) (DropStats, error) {
+	var stats DropStats
+	for _, r := range DropReasons {
+		v, err := SumCounter(m, uint32(r))
+		if err != nil {
+			return stats, fmt.Errorf("failed to read drop reason %s: %w", r, err)
+		}
+		stats[r] = v
+	}
+	return stats, nil
+}
+
+// Counters returns the single-slot counters of the XDP program by name.
+// Counters that cannot be read are omitted.
+func (l *Loader) Counters() map[string]uint64 {
+	objs := l.PhantomObjs
+	maps := map[string]*ebpf.Map{
+		"redirected":       objs.AttackStats,
+		"stealth_drops":    objs.StealthDrops,
+		"stealth_replies":  objs.StealthReplies,
+		"os_mutations":     objs.OsMutations,
+		"spa_auth_success": objs.SpaAuthSuccess,
+		"spa_auth_failed":  objs.SpaAuthFailed,
+		"events_lost":      objs.EventsLost,
+	}
+
+	counters := make(map[string]uint64, len(maps))
+	for name, m := range maps {
+		if v, err := SumCounter(m, 0); err == nil {
+			counters[name] = v
+		}
+	}
+	return counters
+}
@@ -0,0 +1,224 @@
+package ebpf
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/cilium/ebpf"
+
+	"phantom-grid/internal/config"
+)
+
+func TestDropStats(t *testing.T) {
+	var s DropStats
+	s[DropCriticalPort] = 3
+	s[DropStealthXmas] = 1
+	s[DropStealthNull] = 2
+	s[DropStealthACK] = 4
+	s[DropMalformed] = 5
+
+	if got := s.Stealth(); got != 7 {
+		t.Errorf("Stealth() = %d, want 7", got)
+	}
+	if got := s.Total(); got != 15 {
+		t.Errorf("Total() = %d, want 15", got)
+	}
+	if got := s.Get(DropReason(dropReasonMax)); got != 0 {
+		t.Errorf("Get(out of range) = %d, want 0", got)
+	}
+
+	m := s.Map()
+	if len(m) != len(DropReasons) || m["malformed"] != 5 || m["stealth_fin"] != 0 {
+		t.Errorf("Map() = %v", m)
+	}
+}
+
+func TestSumCounter(t *testing.T) {
+	m, err := ebpf.NewMap(&ebpf.MapSpec{
+		Type:       ebpf.PerCPUArray,
+		KeySize:    4,
+		ValueSize:  8,
+		MaxEntries: 2,
+	})
+	if err != nil {
+		t.Skipf("Cannot create per-CPU map: %v", err)
+	}
+	defer m.Close()
+
+	// Reading an unset slot yields one zero value per possible CPU
+	var values []uint64
+	if err := m.Lookup(uint32(0), &values); err != nil {
+		t.Fatalf("Lookup() error: %v", err)
+	}
+	for i := range values {
+		values[i] = uint64(i + 1)
+	}
+	if err := m.Put(uint32(1), values); err != nil {
+		t.Fatalf("Put() error: %v", err)
+	}
+
+	want := uint64(len(values) * (len(values) + 1) / 2)
+	got, err := SumCounter(m, 1)
+	if err != nil {
+		t.Fatalf("SumCounter() error: %v", err)
+	}
+	if got != want {
+		t.Errorf("SumCounter() = %d, want %d", got, want)
+	}
+}
+
+func TestDropReasonsByScanType(t *testing.T) {
+	loader := loadTestLoader(t)
+
+	scans := []struct {
+		flags  uint8
+		reason DropReason
+	}{
+		{0x29, DropStealthXmas},
+		{0x00, DropStealthNull},
+		{0x01, DropStealthFIN},
+		{0x10, DropStealthACK},
+	}
+	for i, sc := range scans {
+		pkt := buildTCPPacket(net.IPv4(192, 0, 2, 40), uint16(40000+i), 47123, sc.flags)
+		ret, _, err := loader.PhantomObjs.PhantomProg.Test(pkt)
+		if err != nil {
+			t.Skipf("BPF_PROG_TEST_RUN not supported: %v", err)
+		}
+		if ret != xdpDrop {
+			t.Errorf("%s: got verdict %d, want XDP_DROP", sc.reason, ret)
+		}
+	}
+
+	// Truncated TCP header
+	pkt := buildTCPPacket(net.IPv4(192, 0, 2, 41), 40000, 47123, 0x02)[:14+20+10]
+	if ret, _, err := loader.PhantomObjs.PhantomProg.Test(pkt); err == nil && ret != xdpDrop {
+		t.Errorf("truncated TCP: got verdict %d, want XDP_DROP", ret)
+	}
+
+	stats, err := ReadDropStats(loader.PhantomObjs.DropReasons)
+	if err != nil {
+		t.Fatalf("ReadDropStats() error: %v", err)
+	}
+	for _, sc := range scans {
+		if stats.Get(sc.reason) != 1 {
+			t.Errorf("%s = %d, want 1", sc.reason, stats.Get(sc.reason))
+		}
+	}
+	if stats.Get(DropMalformed) != 1 {
+		t.Errorf("malformed = %d, want 1", stats.Get(DropMalformed))
+	}
+}
+
+func TestStealthScanResponses(t *testing.T) {
+	loader := loadTestLoader(t)
+
+	tests := []struct {
+		name     string
+		response config.StealthResponse
+		flags    uint8
+		verdict  uint32
+		rstFlags uint8
+		window   uint16
+	}{
+		{"xmas closed", config.StealthResponseRSTClosed, 0x29, xdpTX, 0x14, 0},
+		{"null closed", config.StealthResponseRSTClosed, 0x00, xdpTX, 0x14, 0},
+		{"fin open", config.StealthResponseRSTOpen, 0x01, xdpDrop, 0, 0},
+		{"ack open", config.StealthResponseRSTOpen, 0x10, xdpTX, 0x04, 4096},
+		{"ack closed", config.StealthResponseRSTClosed, 0x10, xdpTX, 0x04, 0},
+		{"ack drop", config.StealthResponseDrop, 0x10, xdpDrop, 0, 0},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := tt.response
+			if err := loader.SetStealthResponses(config.StealthResponseConfiguration{Xmas: r, Null: r, FIN: r, ACK: r}); err != nil {
+				t.Fatalf("SetStealthResponses() error: %v", err)
+			}
+
+			pkt := buildTCPPacket(net.IPv4(192, 0, 2, 42), uint16(40000+i), 47123, tt.flags)
+			binary.BigEndian.PutUint32(pkt[38:42], 5000) // seq
+			binary.BigEndian.PutUint32(pkt[42:46], 7000) // ack
+			ret, out, err := loader.PhantomObjs.PhantomProg.Test(pkt)
+			if err != nil {
+				t.Skipf("BPF_PROG_TEST_RUN not supported: %v", err)
+			}
+			if ret != tt.verdict {
+				t.Fatalf("got verdict %d, want %d", ret, tt.verdict)
+			}
+			if ret != xdpTX {
+				return
+			}
+
+			ip, tcp := out[14:34], out[34:54]
+			if tcp[13] != tt.rstFlags || binary.BigEndian.Uint16(tcp[14:16]) != tt.window {
+				t.Errorf("flags = %#x, window = %d; want %#x, %d", tcp[13], binary.BigEndian.Uint16(tcp[14:16]), tt.rstFlags, tt.window)
+			}
+			// RFC 793: a RST answers an ACK with its ack number, anything else with seq 0 acking the segment
+			seq, ack := binary.BigEndian.Uint32(tcp[4:8]), binary.BigEndian.Uint32(tcp[8:12])
+			if tt.flags&0x10 != 0 && seq != 7000 {
+				t.Errorf("seq = %d, want 7000", seq)
+			}
+			if tt.flags&0x10 == 0 && (seq != 0 || ack != 5000+uint32(tt.flags&0x01)) {
+				t.Errorf("seq = %d, ack = %d; want 0, %d", seq, ack, 5000+uint32(tt.flags&0x01))
+			}
+			if tcpChecksum(ip, tcp) != 0 {
+				t.Error("TCP checksum invalid")
+			}
+		})
+	}
+}
+
+// An ACK is only an ACK scan when XDP has not seen its flow open. ACKs of
+// steered flows and of connections the host opened must pass, whatever the
+// configured response.
+func TestStealthResponseKnownFlows(t *testing.T) {
+	loader := loadTestLoader(t)
+
+	r := config.StealthResponseRSTClosed
+	if err := loader.SetStealthResponses(config.StealthResponseConfiguration{Xmas: r, Null: r, FIN: r, ACK: r}); err != nil {
+		t.Fatalf("SetStealthResponses() error: %v", err)
+	}
+
+	for i, mode := range []config.SteeringMode{config.SteeringModeRedirect, config.SteeringModeSkLookup} {
+		t.Run(string(mode), func(t *testing.T) {
+			if err := loader.SetSteeringMode(mode); err != nil {
+				t.Fatalf("SetSteeringMode() error: %v", err)
+			}
+			run := func(pkt []byte) (uint32, uint16) {
+				t.Helper()
+				ret, out, err := loader.PhantomObjs.PhantomProg.Test(pkt)
+				if err != nil {
+					t.Skipf("BPF_PROG_TEST_RUN not supported: %v", err)
+				}
+				return ret, binary.BigEndian.Uint16(out[36:38])
+			}
+			steeredPort := uint16(config.HoneypotPort)
+			if mode == config.SteeringModeSkLookup {
+				steeredPort = 47123
+			}
+
+			src := net.IPv4(192, 0, 2, byte(80+i))
+			if ret, dport := run(buildTCPPacket(src, 41000, 47123, 0x02)); ret != xdpPass || dport != steeredPort {
+				t.Fatalf("SYN: verdict %d, dport %d; want %d, %d", ret, dport, xdpPass, steeredPort)
+			}
+			for _, flags := range []uint8{0x10, 0x18} {
+				if ret, dport := run(buildTCPPacket(src, 41000, 47123, flags)); ret != xdpPass || dport != steeredPort {
+					t.Errorf("ACK %#x of steered flow: verdict %d, dport %d; want %d, %d", flags, ret, dport, xdpPass, steeredPort)
+				}
+			}
+			if ret, _ := run(buildTCPPacket(src, 41001, 47123, 0x10)); ret != xdpTX {
+				t.Errorf("ACK without handshake: verdict %d, want %d", ret, xdpTX)
+			}
+
+			// Reply to a connection the host opened: neither reset nor steered
+			server := net.IPv4(192, 0, 2, byte(90+i))
+			for _, flags := range []uint8{0x12, 0x10} {
+				if ret, dport := run(buildTCPPacket(server, 443, 47124, flags)); ret != xdpPass || dport != 47124 {
+					t.Errorf("flags %#x from server: verdict %d, dport %d; want %d, 47124", flags, ret, dport, xdpPass)
+				}
+			}
+		})
+	}
+}
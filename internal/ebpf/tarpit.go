@@ -0,0 +1,174 @@
+package ebpf
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"golang.org/x/sys/unix"
+
+	"phantom-grid/internal/config"
+)
+
+// xdp_config key for the per-source tarpit cap (must match XDP_CONFIG_TARPIT_MAX_PER_SOURCE)
+const xdpConfigTarpitMaxPerSource uint32 = 8
+
+// Tarpit modes (must match TARPIT_MODE_* in programs/phantom.c)
+const (
+	tarpitModeNone uint32 = 0
+	tarpitModeXDP  uint32 = 1
+)
+
+// tarpit_stats slots (must match TARPIT_STAT_* in programs/phantom.c)
+const (
+	tarpitStatFlows  uint32 = 0
+	tarpitStatProbes uint32 = 1
+	tarpitStatCapped uint32 = 2
+)
+
+// tarpitFlowKey mirrors struct tarpit_flow_key in programs/phantom.c
+type tarpitFlowKey struct {
+	SAddr [4]byte
+	SPort uint16 // Network byte order
+	DPort uint16 // Network byte order
+}
+
+// tarpitFlow mirrors struct tarpit_flow in programs/phantom.c
+type tarpitFlow struct {
+	LastSeenNs uint64
+	Seq        uint32
+	Ack        uint32
+}
+
+// TarpitStats summarizes connections stalled by the XDP tarpit
+type TarpitStats struct {
+	Flows   int    // Open flows after expiry
+	Sources int    // Distinct sources holding open flows
+	Opened  uint64 // Flows answered with a zero-window SYN-ACK
+	Probes  uint64 // Window probes and data segments answered
+	Capped  uint64 // SYNs dropped by the per-source cap
+	Expired int    // Idle flows released by this sweep
+}
+
+// ConfigureTarpit loads the ports answered by the XDP tarpit and the
+// per-source cap. Only ranges in xdp mode are loaded; honeypot mode ranges
+// are redirected as usual and stalled by the honeypot.
+func (l *Loader) ConfigureTarpit(cfg config.TarpitConfiguration) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	if err := l.PhantomObjs.XdpConfig.Put(xdpConfigTarpitMaxPerSource, uint32(cfg.MaxPerSource)); err != nil {
+		return fmt.Errorf("failed to set tarpit source limit: %w", err)
+	}
+
+	keys := make([]uint32, 65536)
+	values := make([]uint32, 65536)
+	for port := range keys {
+		keys[port] = uint32(port)
+		if mode, ok := cfg.ModeForPort(port); ok && mode == config.TarpitModeXDP {
+			values[port] = tarpitModeXDP
+		} else {
+			values[port] = tarpitModeNone
+		}
+	}
+
+	_, err := l.PhantomObjs.TarpitPorts.BatchUpdate(keys, values, nil)
+	if errors.Is(err, ebpf.ErrNotSupported) {
+		// Kernels before 5.6 have no batch operations
+		for port := range keys {
+			if err = l.PhantomObjs.TarpitPorts.Put(keys[port], values[port]); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load tarpit ports: %w", err)
+	}
+	return nil
+}
+
+// SweepTarpit releases XDP tarpit flows idle for longer than timeout and
+// recounts the flows held by each source, which LRU eviction and the kernel's
+// own bookkeeping can leave behind.
+func (l *Loader) SweepTarpit(timeout time.Duration) (TarpitStats, error) {
+	var stats TarpitStats
+	objs := l.PhantomObjs
+
+	now, err := monotonicNow()
+	if err != nil {
+		return stats, err
+	}
+
+	var (
+		key     tarpitFlowKey
+		flow    tarpitFlow
+		expired []tarpitFlowKey
+		perIP   = make(map[[4]byte]uint32)
+		iter    = objs.TarpitFlows.Iterate()
+	)
+	for iter.Next(&key, &flow) {
+		if now-time.Duration(flow.LastSeenNs) > timeout {
+			expired = append(expired, key)
+			continue
+		}
+		perIP[key.SAddr]++
+	}
+	if err := iter.Err(); err != nil {
+		return stats, fmt.Errorf("failed to iterate tarpit flows: %w", err)
+	}
+
+	for _, k := range expired {
+		if err := objs.TarpitFlows.Delete(k); err != nil && !errors.Is(err, ebpf.ErrKeyNotExist) {
+			return stats, fmt.Errorf("failed to expire tarpit flow: %w", err)
+		}
+	}
+
+	// Flows opened since the iteration are not counted here and may
+	// briefly let a source exceed its cap by a few connections
+	var (
+		src     [4]byte
+		count   uint32
+		sources [][4]byte
+	)
+	iter = objs.TarpitSources.Iterate()
+	for iter.Next(&src, &count) {
+		sources = append(sources, src)
+	}
+	if err := iter.Err(); err != nil {
+		return stats, fmt.Errorf("failed to iterate tarpit sources: %w", err)
+	}
+	for _, s := range sources {
+		if _, ok := perIP[s]; ok {
+			continue
+		}
+		if err := objs.TarpitSources.Delete(s); err != nil && !errors.Is(err, ebpf.ErrKeyNotExist) {
+			return stats, fmt.Errorf("failed to update tarpit source counts: %w", err)
+		}
+	}
+	for s, n := range perIP {
+		if err := objs.TarpitSources.Put(s, n); err != nil {
+			return stats, fmt.Errorf("failed to update tarpit source counts: %w", err)
+		}
+	}
+
+	for _, n := range perIP {
+		stats.Flows += int(n)
+	}
+	stats.Sources = len(perIP)
+	stats.Expired = len(expired)
+	stats.Opened, _ = SumCounter(objs.TarpitStats, tarpitStatFlows)
+	stats.Probes, _ = SumCounter(objs.TarpitStats, tarpitStatProbes)
+	stats.Capped, _ = SumCounter(objs.TarpitStats, tarpitStatCapped)
+	return stats, nil
+}
+
+// monotonicNow returns the clock used by bpf_ktime_get_ns()
+func monotonicNow() (time.Duration, error) {
+	var ts unix.Timespec
+	if err := unix.ClockGettime(unix.CLOCK_MONOTONIC, &ts); err != nil {
+		return 0, fmt.Errorf("failed to read monotonic clock: %w", err)
+	}
+	return time.Duration(ts.Nano()), nil
+}
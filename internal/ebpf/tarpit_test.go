@@ -0,0 +1,100 @@
+package ebpf
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"phantom-grid/internal/config"
+)
+
+const xdpTX = 3
+
+// tarpitTestPort is not a critical or fake port
+const tarpitTestPort = 47001
+
+func TestXDPTarpit(t *testing.T) {
+	loader := loadTestLoader(t)
+
+	cfg := config.DefaultTarpitConfig()
+	cfg.Enabled = true
+	cfg.MaxPerSource = 1
+	cfg.Ranges = []config.TarpitRange{{From: tarpitTestPort, To: tarpitTestPort, Mode: config.TarpitModeXDP}}
+	if err := loader.ConfigureTarpit(cfg); err != nil {
+		t.Fatalf("ConfigureTarpit() error: %v", err)
+	}
+
+	src := net.IPv4(192, 0, 2, 60)
+	run := func(pkt []byte) (uint32, []byte) {
+		t.Helper()
+		ret, out, err := loader.PhantomObjs.PhantomProg.Test(pkt)
+		if err != nil {
+			t.Skipf("BPF_PROG_TEST_RUN not supported: %v", err)
+		}
+		return ret, out
+	}
+	segment := func(sport uint16, seq uint32, flags uint8) []byte {
+		pkt := buildTCPPacket(src, sport, tarpitTestPort, flags)
+		binary.BigEndian.PutUint32(pkt[38:42], seq)
+		return pkt
+	}
+
+	// SYN gets a zero-window SYN-ACK without options
+	ret, out := run(segment(40000, 1000, 0x02))
+	if ret != xdpTX {
+		t.Fatalf("SYN: got verdict %d, want XDP_TX", ret)
+	}
+	ip, tcp := out[14:34], out[34:54]
+	if !net.IP(ip[16:20]).Equal(src) || binary.BigEndian.Uint16(tcp[0:2]) != tarpitTestPort {
+		t.Errorf("reply not addressed back to the source: %v:%d", net.IP(ip[16:20]), binary.BigEndian.Uint16(tcp[2:4]))
+	}
+	if tcp[13] != 0x12 || tcp[12]>>4 != 5 || binary.BigEndian.Uint16(tcp[14:16]) != 0 {
+		t.Errorf("flags = %#x, data offset = %d, window = %d; want SYN|ACK, 5, 0", tcp[13], tcp[12]>>4, binary.BigEndian.Uint16(tcp[14:16]))
+	}
+	if ack := binary.BigEndian.Uint32(tcp[8:12]); ack != 1001 {
+		t.Errorf("ack = %d, want 1001", ack)
+	}
+	if len(out) != 54 || tcpChecksum(ip, tcp) != 0 {
+		t.Errorf("reply is %d bytes, TCP checksum valid = %v", len(out), tcpChecksum(ip, tcp) == 0)
+	}
+	isn := binary.BigEndian.Uint32(tcp[4:8])
+
+	// A second connection from the same source exceeds the cap
+	if ret, _ := run(segment(40001, 5000, 0x02)); ret != xdpDrop {
+		t.Errorf("capped SYN: got verdict %d, want XDP_DROP", ret)
+	}
+
+	// The handshake ACK is absorbed; a window probe is answered
+	if ret, _ := run(segment(40000, 1001, 0x10)); ret != xdpDrop {
+		t.Errorf("handshake ACK: got verdict %d, want XDP_DROP", ret)
+	}
+	ret, out = run(segment(40000, 1000, 0x10))
+	if ret != xdpTX {
+		t.Fatalf("window probe: got verdict %d, want XDP_TX", ret)
+	}
+	if tcp := out[34:54]; tcp[13] != 0x10 || binary.BigEndian.Uint32(tcp[4:8]) != isn+1 || binary.BigEndian.Uint16(tcp[14:16]) != 0 {
+		t.Errorf("probe reply flags = %#x, seq = %d; want ACK, %d, zero window", tcp[13], binary.BigEndian.Uint32(tcp[4:8]), isn+1)
+	}
+
+	stats, err := loader.SweepTarpit(time.Hour)
+	if err != nil {
+		t.Fatalf("SweepTarpit() error: %v", err)
+	}
+	if stats.Flows != 1 || stats.Opened != 1 || stats.Probes != 1 || stats.Capped != 1 {
+		t.Errorf("stats = %+v, want 1 flow, 1 opened, 1 probe, 1 capped", stats)
+	}
+
+	// RST releases the flow and the source's slot
+	if ret, _ := run(segment(40000, 1001, 0x04)); ret != xdpDrop {
+		t.Errorf("RST: got verdict %d, want XDP_DROP", ret)
+	}
+	if ret, _ := run(segment(40001, 5000, 0x02)); ret != xdpTX {
+		t.Errorf("SYN after RST: got verdict %d, want XDP_TX", ret)
+	}
+
+	// Idle flows are expired
+	if stats, err := loader.SweepTarpit(0); err != nil || stats.Flows != 0 || stats.Expired != 1 {
+		t.Errorf("SweepTarpit(0) = %+v, %v; want the flow expired", stats, err)
+	}
+}
@@ -0,0 +1,135 @@
+package honeypot
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"phantom-grid/internal/honeypot/vfs"
+	"phantom-grid/internal/logger"
+)
+
+// sessionFS returns the file system of one connection: an overlay of the
+// base image, so its changes are its own
+func (h *Handler) sessionFS() *vfs.FS {
+	image := h.image
+	if image == nil {
+		image = baseImage()
+	}
+	fsys := image.Overlay()
+	fsys.Quota = h.fs.SessionQuota
+	return fsys
+}
+
+// captureArtifacts logs the files written on fsys during a session and keeps
+// their contents in the artifact directory. Files deleted again, as droppers
+// do after running their payload, are captured too.
+func (h *Handler) captureArtifacts(fsys *vfs.FS, service, ip, t string) {
+	for _, a := range fsys.Artifacts() {
+		if len(a.Data) == 0 {
+			continue
+		}
+		hash := sha256Hex(a.Data)
+		h.logChan <- fmt.Sprintf("[%s] %s ARTIFACT: %s | Path: %s | Size: %d | SHA256: %s | Removed: %t", t, service, ip, a.Path, len(a.Data), hash, a.Removed)
+		logger.LogAttack(ip, fmt.Sprintf("%s_ARTIFACT: path=%s, size=%d, sha256=%s", service, a.Path, len(a.Data), hash))
+
+		if h.fs.ArtifactDir == "" {
+			continue
+		}
+		s := sighting{Time: time.Now().UTC(), IP: ip, Service: service, Path: a.Path}
+		if err := storeArtifact(h.fs.ArtifactDir, hash, a.Data, s); err != nil {
+			h.logChan <- fmt.Sprintf("[WARN] Cannot store artifact %s: %v", hash, err)
+		}
+	}
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// artifactMeta is kept next to each stored artifact, as <sha256>.json
+type artifactMeta struct {
+	SHA256    string     `json:"sha256"`
+	Size      int        `json:"size"`
+	Sightings []sighting `json:"sightings"`
+}
+
+// sighting is one time an artifact was written in a session or fetched by
+// one of its commands
+type sighting struct {
+	Time        time.Time `json:"time"`
+	IP          string    `json:"ip"`
+	Service     string    `json:"service"`
+	Path        string    `json:"path,omitempty"`
+	URL         string    `json:"url,omitempty"`
+	ContentType string    `json:"content_type,omitempty"`
+	Truncated   bool      `json:"truncated,omitempty"` // Only the start of the file was received
+}
+
+// artifactMu serializes updates of the metadata of stored artifacts
+var artifactMu sync.Mutex
+
+// storeArtifact writes data to dir under its hash and adds s to its
+// metadata. The same payload dropped by many attackers is stored once.
+func storeArtifact(dir, hash string, data []byte, s sighting) error {
+	artifactMu.Lock()
+	defer artifactMu.Unlock()
+
+	p := filepath.Join(dir, hash)
+	if _, err := os.Stat(p); errors.Is(err, fs.ErrNotExist) {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return err
+		}
+		// Payloads are kept for analysis, never to be run
+		if err := writeAtomic(p, data, 0400); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+
+	meta := artifactMeta{SHA256: hash, Size: len(data)}
+	metaPath := p + ".json"
+	if b, err := os.ReadFile(metaPath); err == nil {
+		if err := json.Unmarshal(b, &meta); err != nil {
+			return fmt.Errorf("%s: %w", metaPath, err)
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	meta.Sightings = append(meta.Sightings, s)
+	b, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeAtomic(metaPath, append(b, '\n'), 0600)
+}
+
+// writeAtomic writes data to p under a temporary name first, so a crash
+// leaves no truncated file behind
+func writeAtomic(p string, data []byte, perm fs.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".artifact-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), p)
+}
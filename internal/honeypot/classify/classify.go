@@ -0,0 +1,403 @@
+// Package classify tags HTTP requests with the attacks they carry. Rules
+// come in packs, JSON files of regular expressions over the parts of a
+// request, e.g.
+//
+//	{
+//	  "category": "rce",
+//	  "risk": "HIGH",
+//	  "rules": [
+//	    {"id": "log4shell", "description": "Log4j JNDI lookup",
+//	     "cves": ["CVE-2021-44228"],
+//	     "match": [{"field": "any", "pattern": "(?i)\\$\\{jndi:"}]},
+//	    {"id": "jenkins-script-console", "description": "Groovy run in the Jenkins script console",
+//	     "match": [{"field": "method", "pattern": "^POST$"},
+//	               {"field": "path", "pattern": "^/script(Text)?$"}]}
+//	  ]
+//	}
+//
+// A rule matches when all its conditions do. The fields are method, uri,
+// path, query, body, headers (each "Name: value" line), header:Name (the
+// values of one header) and any (uri, headers and body). The uri, path,
+// query and body are matched both as sent and URL-decoded, so encoded
+// payloads are seen too. Packs for common exploits, injection, traversal,
+// discovery and scanners are built in; more can be loaded from a directory.
+package classify
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"maps"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+//go:embed rules
+var builtin embed.FS
+
+// Risk levels of rules, lowest first; events use the same levels
+var risks = []string{"INFO", "LOW", "MEDIUM", "HIGH"}
+
+// defaultRisk is the risk of rules in packs that set none
+const defaultRisk = "MEDIUM"
+
+// Pack is a file of rules
+type Pack struct {
+	Name     string  `json:"-"`        // Name of the file without .json
+	Category string  `json:"category"` // Category of rules that set none
+	Risk     string  `json:"risk"`     // Risk of rules that set none
+	Rules    []*Rule `json:"rules"`
+}
+
+// Rule is a kind of attack and how to recognize it
+type Rule struct {
+	ID          string       `json:"id"`
+	Description string       `json:"description"`
+	Category    string       `json:"category"` // e.g. rce, sqli, traversal, scanner
+	CVEs        []string     `json:"cves"`
+	Risk        string       `json:"risk"`  // INFO, LOW, MEDIUM or HIGH
+	Match       []*Condition `json:"match"` // All must match
+}
+
+// Condition is a pattern looked for in a field of the request
+type Condition struct {
+	Field   string `json:"field"`
+	Pattern string `json:"pattern"` // RE2 syntax
+	re      *regexp.Regexp
+}
+
+// builtinPacks parses the built-in packs once
+var builtinPacks = sync.OnceValue(func() map[string]*Pack {
+	packs, err := Load(builtin, "rules")
+	if err != nil {
+		panic(err)
+	}
+	return packs
+})
+
+// Builtin returns the built-in packs by name. Packs are not modified once
+// loaded, so they are shared; the map is the caller's.
+func Builtin() map[string]*Pack {
+	return maps.Clone(builtinPacks())
+}
+
+// LoadDir loads the packs in the .json files of dir
+func LoadDir(dir string) (map[string]*Pack, error) {
+	return Load(os.DirFS(dir), ".")
+}
+
+// Load loads the packs in the .json files of dir in fsys
+func Load(fsys fs.FS, dir string) (map[string]*Pack, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	packs := make(map[string]*Pack)
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), ".json")
+		if !ok || e.IsDir() {
+			continue
+		}
+		data, err := fs.ReadFile(fsys, path.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		pack, err := parsePack(name, data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", e.Name(), err)
+		}
+		packs[name] = pack
+	}
+	return packs, nil
+}
+
+// parsePack parses a pack and compiles its patterns
+func parsePack(name string, data []byte) (*Pack, error) {
+	pack := &Pack{Name: name}
+	if err := json.Unmarshal(data, pack); err != nil {
+		return nil, err
+	}
+	if pack.Risk == "" {
+		pack.Risk = defaultRisk
+	}
+	seen := make(map[string]bool)
+	for i, r := range pack.Rules {
+		if r.ID == "" {
+			return nil, fmt.Errorf("rule %d has no id", i)
+		}
+		if seen[r.ID] {
+			return nil, fmt.Errorf("duplicate rule %s", r.ID)
+		}
+		seen[r.ID] = true
+		if r.Category == "" {
+			r.Category = pack.Category
+		}
+		if r.Category == "" {
+			return nil, fmt.Errorf("rule %s has no category", r.ID)
+		}
+		if r.Risk == "" {
+			r.Risk = pack.Risk
+		}
+		if riskRank(r.Risk) < 0 {
+			return nil, fmt.Errorf("rule %s: invalid risk %q (must be one of %s)", r.ID, r.Risk, strings.Join(risks, ", "))
+		}
+		if len(r.Match) == 0 {
+			return nil, fmt.Errorf("rule %s has no conditions", r.ID)
+		}
+		for _, c := range r.Match {
+			if !validField(c.Field) {
+				return nil, fmt.Errorf("rule %s: unknown field %q", r.ID, c.Field)
+			}
+			re, err := regexp.Compile(c.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("rule %s: %w", r.ID, err)
+			}
+			c.re = re
+		}
+	}
+	return pack, nil
+}
+
+// validField reports whether a condition can match field
+func validField(field string) bool {
+	switch field {
+	case "method", "uri", "path", "query", "body", "headers", "any":
+		return true
+	}
+	name, ok := strings.CutPrefix(field, "header:")
+	return ok && name != ""
+}
+
+// riskRank returns the position of risk in risks, or -1
+func riskRank(risk string) int {
+	for i, r := range risks {
+		if r == risk {
+			return i
+		}
+	}
+	return -1
+}
+
+// MaxRisk returns the higher of two risk levels. Unknown levels rank lowest.
+func MaxRisk(a, b string) string {
+	if riskRank(b) > riskRank(a) {
+		return b
+	}
+	return a
+}
+
+// Classifier matches requests against the rules of a set of packs
+type Classifier struct {
+	rules []*Rule
+}
+
+// New returns a classifier for the rules of packs, checked in the order of
+// the pack names
+func New(packs map[string]*Pack) *Classifier {
+	names := make([]string, 0, len(packs))
+	for name := range packs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	c := &Classifier{}
+	for _, name := range names {
+		c.rules = append(c.rules, packs[name].Rules...)
+	}
+	return c
+}
+
+// Len returns the number of rules
+func (c *Classifier) Len() int {
+	return len(c.rules)
+}
+
+// Result is what a request was classified as
+type Result struct {
+	Rules      []string // IDs of the matching rules
+	Categories []string // Sorted, without duplicates
+	CVEs       []string // Sorted, without duplicates
+	Risk       string   // Highest risk of the matching rules; empty if none matched
+}
+
+// Matched reports whether any rule matched
+func (r Result) Matched() bool {
+	return len(r.Rules) > 0
+}
+
+// Classify matches r, whose body has been read into body, against the rules
+func (c *Classifier) Classify(r *http.Request, body []byte) Result {
+	req := newRequest(r, body)
+	var res Result
+	categories := make(map[string]bool)
+	cves := make(map[string]bool)
+	for _, rule := range c.rules {
+		if !req.matches(rule) {
+			continue
+		}
+		res.Rules = append(res.Rules, rule.ID)
+		res.Risk = MaxRisk(res.Risk, rule.Risk)
+		categories[rule.Category] = true
+		for _, cve := range rule.CVEs {
+			cves[cve] = true
+		}
+	}
+	res.Categories = sortedKeys(categories)
+	res.CVEs = sortedKeys(cves)
+	return res
+}
+
+// request holds the values of each field of a request
+type request struct {
+	method                 string
+	uri, path, query, body []string // As sent, then decoded
+	header                 http.Header
+	headers                []string
+}
+
+func newRequest(r *http.Request, body []byte) *request {
+	uri := r.RequestURI
+	if uri == "" {
+		uri = r.URL.RequestURI()
+	}
+	p, q, hasQuery := strings.Cut(uri, "?")
+	req := &request{
+		method: r.Method,
+		uri:    []string{uri},
+		path:   decodings(p, false),
+		query:  decodings(q, true),
+		body:   decodings(string(body), true),
+		header: r.Header,
+	}
+	// The path and query decode differently, '+' being a space only in the
+	// query
+	for i := 1; i < max(len(req.path), len(req.query)); i++ {
+		decoded := req.path[min(i, len(req.path)-1)]
+		if hasQuery {
+			decoded += "?" + req.query[min(i, len(req.query)-1)]
+		}
+		req.uri = append(req.uri, decoded)
+	}
+	for name, values := range r.Header {
+		for _, v := range values {
+			req.headers = append(req.headers, name+": "+v)
+		}
+	}
+	sort.Strings(req.headers)
+	return req
+}
+
+// matches reports whether all conditions of rule match
+func (req *request) matches(rule *Rule) bool {
+	for _, c := range rule.Match {
+		if !matchAny(c.re, req.values(c.Field)...) {
+			return false
+		}
+	}
+	return true
+}
+
+// values returns the values of field
+func (req *request) values(field string) []string {
+	switch field {
+	case "method":
+		return []string{req.method}
+	case "uri":
+		return req.uri
+	case "path":
+		return req.path
+	case "query":
+		return req.query
+	case "body":
+		return req.body
+	case "headers":
+		return req.headers
+	case "any":
+		all := append([]string{}, req.uri...)
+		all = append(all, req.headers...)
+		return append(all, req.body...)
+	}
+	name, _ := strings.CutPrefix(field, "header:")
+	return req.header.Values(name)
+}
+
+func matchAny(re *regexp.Regexp, values ...string) bool {
+	for _, v := range values {
+		if v != "" && re.MatchString(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// maxDecodings bounds how often s is decoded, which catches double and
+// triple encoding
+const maxDecodings = 3
+
+// decodings returns s and its successive URL decodings that differ from it
+func decodings(s string, plus bool) []string {
+	values := []string{s}
+	for i := 0; i < maxDecodings; i++ {
+		decoded := unescape(s, plus)
+		if decoded == s {
+			break
+		}
+		values = append(values, decoded)
+		s, plus = decoded, false
+	}
+	return values
+}
+
+// unescape decodes the valid %XX escapes of s, and '+' as a space if plus is
+// set. Unlike url.QueryUnescape it keeps invalid escapes instead of failing,
+// as attackers send them on purpose.
+func unescape(s string, plus bool) string {
+	if !strings.ContainsAny(s, "%+") {
+		return s
+	}
+	var b strings.Builder
+	b.Grow(len(s))
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '%' && i+2 < len(s) && isHex(s[i+1]) && isHex(s[i+2]):
+			b.WriteByte(unhex(s[i+1])<<4 | unhex(s[i+2]))
+			i += 2
+		case s[i] == '+' && plus:
+			b.WriteByte(' ')
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func unhex(c byte) byte {
+	switch {
+	case c >= 'a':
+		return c - 'a' + 10
+	case c >= 'A':
+		return c - 'A' + 10
+	}
+	return c - '0'
+}
+
+func sortedKeys(set map[string]bool) []string {
+	if len(set) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
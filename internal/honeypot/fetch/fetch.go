@@ -0,0 +1,279 @@
+// Package fetch downloads the payloads attackers point droppers at, within
+// size and time limits, so they can be kept for analysis.
+//
+// HTTP and HTTPS downloads go through an HTTP proxy if one is set, and FTP
+// through CONNECT tunnels of it. TFTP runs over UDP, which an HTTP proxy
+// cannot carry, so it is refused when a proxy is set. Without a proxy only
+// public addresses are contacted, so attackers cannot make the sensor reach
+// into its own network.
+package fetch
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var (
+	// ErrTooLarge is returned for payloads larger than the size limit
+	ErrTooLarge = errors.New("payload exceeds the size limit")
+	// ErrForbidden is returned for addresses that may not be contacted
+	ErrForbidden = errors.New("address is not public")
+	// ErrUnsupported is returned for URLs whose scheme cannot be fetched
+	ErrUnsupported = errors.New("unsupported scheme")
+)
+
+// Fetcher downloads payloads
+type Fetcher struct {
+	Proxy    *url.URL      // HTTP proxy downloads go through; nil for direct
+	MaxBytes int64         // Larger payloads are abandoned; 0 is no limit
+	Timeout  time.Duration // Time limit of a download, including connecting; 0 is none
+
+	// allowPrivate lets tests contact stand-ins on loopback
+	allowPrivate bool
+}
+
+// Result is a downloaded payload
+type Result struct {
+	URL         string // The URL fetched, with a scheme
+	Data        []byte
+	Status      int // HTTP status; 0 for FTP and TFTP
+	ContentType string
+}
+
+// Fetch downloads rawURL. URLs without a scheme are taken as HTTP, as wget
+// does. userAgent is sent with HTTP requests; servers that drop payloads
+// often answer only the tools they expect.
+func (f *Fetcher) Fetch(rawURL, userAgent string) (*Result, error) {
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "http://" + rawURL
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("%s: missing host", rawURL)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if f.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, f.Timeout)
+	}
+	defer cancel()
+	var res *Result
+	switch u.Scheme {
+	case "http", "https":
+		res, err = f.fetchHTTP(ctx, u, userAgent)
+	case "ftp":
+		res, err = f.fetchFTP(ctx, u)
+	case "tftp":
+		res, err = f.fetchTFTP(ctx, u)
+	default:
+		return nil, fmt.Errorf("%s: %w", u.Scheme, ErrUnsupported)
+	}
+	if err != nil {
+		return nil, err
+	}
+	res.URL = u.String()
+	return res, nil
+}
+
+func (f *Fetcher) fetchHTTP(ctx context.Context, u *url.URL, userAgent string) (*Result, error) {
+	transport := &http.Transport{
+		DialContext: f.dialDirect,
+		// Droppers are often served with self-signed certificates, which
+		// their download commands are told to accept
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+		DisableKeepAlives: true,
+	}
+	if f.Proxy != nil {
+		transport.Proxy = http.ProxyURL(f.Proxy)
+		transport.DialContext = (&net.Dialer{}).DialContext
+	}
+	defer transport.CloseIdleConnections()
+	client := &http.Client{Transport: transport}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if userAgent != "" {
+		req.Header.Set("User-Agent", userAgent)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := f.readAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return &Result{Data: data, Status: resp.StatusCode, ContentType: resp.Header.Get("Content-Type")}, nil
+}
+
+// readAll reads r up to the size limit
+func (f *Fetcher) readAll(r io.Reader) ([]byte, error) {
+	if f.MaxBytes <= 0 {
+		return io.ReadAll(r)
+	}
+	data, err := io.ReadAll(io.LimitReader(r, f.MaxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > f.MaxBytes {
+		return nil, ErrTooLarge
+	}
+	return data, nil
+}
+
+// dialTCP connects to addr through the proxy if one is set, and directly
+// otherwise
+func (f *Fetcher) dialTCP(ctx context.Context, addr string) (net.Conn, error) {
+	if f.Proxy == nil {
+		return f.dialDirect(ctx, "tcp", addr)
+	}
+	return f.dialConnect(ctx, addr)
+}
+
+// dialDirect connects to addr if it is a public address
+func (f *Fetcher) dialDirect(ctx context.Context, network, addr string) (net.Conn, error) {
+	d := &net.Dialer{
+		// The resolved address is checked, so host names cannot point inside
+		Control: func(network, address string, c syscall.RawConn) error {
+			return f.checkAddr(address)
+		},
+	}
+	return d.DialContext(ctx, network, addr)
+}
+
+// specialNets are special-purpose ranges (RFC 6890) that are global unicast
+// to net.IP but not public: shared address space that carrier-grade NAT and
+// clouds use internally, benchmarking, documentation and reserved ranges, and
+// the IPv6 transition prefixes that embed IPv4 addresses.
+var specialNets = func() []*net.IPNet {
+	var nets []*net.IPNet
+	for _, cidr := range []string{
+		"0.0.0.0/8", "100.64.0.0/10", "192.0.0.0/24", "192.0.2.0/24", "192.88.99.0/24",
+		"198.18.0.0/15", "198.51.100.0/24", "203.0.113.0/24", "240.0.0.0/4",
+		"64:ff9b::/96", "64:ff9b:1::/48", "100::/64", "2001::/23", "2001:db8::/32",
+		"2002::/16", "fec0::/10",
+	} {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}()
+
+// checkAddr fails with ErrForbidden unless the host of addr is a public
+// address that is not one of this host's own
+func (f *Fetcher) checkAddr(addr string) error {
+	if f.allowPrivate {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !ip.IsGlobalUnicast() || ip.IsPrivate() || isSpecial(ip) || isLocal(ip) {
+		return fmt.Errorf("%s: %w", host, ErrForbidden)
+	}
+	return nil
+}
+
+// isSpecial reports whether ip is in a special-purpose range
+func isSpecial(ip net.IP) bool {
+	for _, n := range specialNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// isLocal reports whether ip is an address of one of this host's interfaces,
+// which reaches the host itself however public it is
+func isLocal(ip net.IP) bool {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		// Without the list, no address can be known to be safe
+		return true
+	}
+	for _, a := range addrs {
+		if n, ok := a.(*net.IPNet); ok && n.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// dialConnect opens a tunnel to addr with an HTTP CONNECT request to the
+// proxy
+func (f *Fetcher) dialConnect(ctx context.Context, addr string) (net.Conn, error) {
+	proxyAddr := f.Proxy.Host
+	if f.Proxy.Port() == "" {
+		proxyAddr = net.JoinHostPort(f.Proxy.Hostname(), "80")
+	}
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if user := f.Proxy.User; user != nil {
+		password, _ := user.Password()
+		req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(user.Username()+":"+password)))
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy refused CONNECT to %s: %s", addr, resp.Status)
+	}
+	// Servers that speak first, as FTP servers do, may have been read into
+	// the buffer with the response
+	return &bufferedConn{Conn: conn, r: br}, nil
+}
+
+// bufferedConn is a connection whose first bytes were read into a buffer
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
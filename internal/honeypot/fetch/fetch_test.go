@@ -0,0 +1,333 @@
+package fetch
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestFetcher() *Fetcher {
+	return &Fetcher{MaxBytes: 1 << 20, Timeout: 5 * time.Second, allowPrivate: true}
+}
+
+func TestFetchHTTP(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.UserAgent() != "Wget/1.21.2" {
+			http.Error(w, "go away", http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/bins/x86":
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Write([]byte("\x7fELF payload"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	f := newTestFetcher()
+	res, err := f.Fetch(host+"/bins/x86", "Wget/1.21.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(res.Data) != "\x7fELF payload" || res.Status != 200 || res.ContentType != "application/octet-stream" {
+		t.Errorf("Fetch = %+v", res)
+	}
+	if res.URL != srv.URL+"/bins/x86" {
+		t.Errorf("URL = %q, want the scheme added", res.URL)
+	}
+
+	res, err = f.Fetch(srv.URL+"/nope", "Wget/1.21.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != 404 {
+		t.Errorf("Status = %d, want 404", res.Status)
+	}
+
+	f.MaxBytes = 4
+	if _, err := f.Fetch(srv.URL+"/bins/x86", "Wget/1.21.2"); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("Fetch over the limit: err = %v, want ErrTooLarge", err)
+	}
+}
+
+func TestFetchForbidden(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("private address contacted")
+	}))
+	defer srv.Close()
+
+	f := newTestFetcher()
+	f.allowPrivate = false
+	for _, u := range []string{srv.URL + "/x", "http://localhost:" + srv.URL[strings.LastIndex(srv.URL, ":")+1:] + "/x", "tftp://127.0.0.1/x"} {
+		if _, err := f.Fetch(u, ""); !errors.Is(err, ErrForbidden) {
+			t.Errorf("Fetch(%s): err = %v, want ErrForbidden", u, err)
+		}
+	}
+	if _, err := f.Fetch("gopher://example.com/x", ""); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("Fetch(gopher): err = %v, want ErrUnsupported", err)
+	}
+}
+
+func TestCheckAddr(t *testing.T) {
+	f := &Fetcher{}
+	for addr, want := range map[string]bool{
+		"93.184.216.34:80":          true,
+		"[2606:4700::6810:84e5]:80": true,
+		"127.0.0.1:80":              false,
+		"10.1.2.3:80":               false,
+		"169.254.169.254:80":        false,
+		"100.64.0.1:80":             false,
+		"100.127.255.254:80":        false,
+		"0.1.2.3:80":                false,
+		"192.0.0.8:80":              false,
+		"198.18.0.1:80":             false,
+		"203.0.113.9:80":            false,
+		"240.0.0.1:80":              false,
+		"255.255.255.255:80":        false,
+		"[::1]:80":                  false,
+		"[fd00::1]:80":              false,
+		"[64:ff9b::a00:1]:80":       false,
+		"[2002:a00:1::]:80":         false,
+		"[2001:db8::1]:80":          false,
+	} {
+		if err := f.checkAddr(addr); (err == nil) != want {
+			t.Errorf("checkAddr(%s) = %v, want allowed %t", addr, err, want)
+		}
+	}
+
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, a := range addrs {
+		if n, ok := a.(*net.IPNet); ok {
+			if err := f.checkAddr(net.JoinHostPort(n.IP.String(), "80")); !errors.Is(err, ErrForbidden) {
+				t.Errorf("checkAddr(%s) = %v, want this host's address forbidden", n.IP, err)
+			}
+		}
+	}
+}
+
+func TestFetchFTPLineBreak(t *testing.T) {
+	f := newTestFetcher()
+	for _, u := range []string{"ftp://127.0.0.1:1/bot%0D%0ADELE%20x", "ftp://a%0Ab@127.0.0.1:1/bot"} {
+		if _, err := f.Fetch(u, ""); err == nil || !strings.Contains(err.Error(), "line break") {
+			t.Errorf("Fetch(%s): err = %v, want a line break error", u, err)
+		}
+	}
+}
+
+func TestFetchHTTPProxy(t *testing.T) {
+	var requested string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = r.URL.String()
+		w.Write([]byte("#!/bin/sh\n"))
+	}))
+	defer proxy.Close()
+
+	f := newTestFetcher()
+	f.allowPrivate = false
+	f.Proxy, _ = url.Parse(proxy.URL)
+	res, err := f.Fetch("http://203.0.113.9/x.sh", "curl/7.81.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requested != "http://203.0.113.9/x.sh" || string(res.Data) != "#!/bin/sh\n" {
+		t.Errorf("proxy got %q and returned %q", requested, res.Data)
+	}
+
+	if _, err := f.Fetch("tftp://203.0.113.9/x", ""); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("TFTP through a proxy: err = %v, want ErrUnsupported", err)
+	}
+}
+
+// serveFTP runs a stand-in FTP server with one file, and returns its address
+func serveFTP(t *testing.T, name, content string) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		fmt.Fprintf(conn, "220 ready\r\n")
+		var data net.Listener
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			cmd, arg, _ := strings.Cut(strings.TrimSpace(line), " ")
+			switch cmd {
+			case "USER":
+				fmt.Fprintf(conn, "331 password please\r\n")
+			case "PASS":
+				fmt.Fprintf(conn, "230 logged in\r\n")
+			case "TYPE":
+				fmt.Fprintf(conn, "200 binary\r\n")
+			case "PASV":
+				data, _ = net.Listen("tcp", "127.0.0.1:0")
+				port := data.Addr().(*net.TCPAddr).Port
+				// An address elsewhere, which must not be used
+				fmt.Fprintf(conn, "227 Entering Passive Mode (10,9,8,7,%d,%d)\r\n", port>>8, port&0xff)
+			case "RETR":
+				if arg != name {
+					fmt.Fprintf(conn, "550 not found\r\n")
+					continue
+				}
+				dc, err := data.Accept()
+				if err != nil {
+					return
+				}
+				fmt.Fprintf(conn, "150 sending\r\n")
+				dc.Write([]byte(content))
+				dc.Close()
+				data.Close()
+				fmt.Fprintf(conn, "226 done\r\n")
+			case "QUIT":
+				fmt.Fprintf(conn, "221 bye\r\n")
+				return
+			}
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestFetchFTP(t *testing.T) {
+	addr := serveFTP(t, "pub/bot.arm7", "\x7fELF arm")
+	res, err := newTestFetcher().Fetch("ftp://"+addr+"/pub/bot.arm7", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(res.Data) != "\x7fELF arm" {
+		t.Errorf("Data = %q", res.Data)
+	}
+}
+
+func TestFetchFTPProxy(t *testing.T) {
+	// Both the control and the data connection go through CONNECT tunnels
+	addr := serveFTP(t, "bot", "payload")
+	var mu sync.Mutex
+	var tunnels []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			http.Error(w, "CONNECT only", http.StatusMethodNotAllowed)
+			return
+		}
+		mu.Lock()
+		tunnels = append(tunnels, r.Host)
+		mu.Unlock()
+		_, port, _ := net.SplitHostPort(r.Host)
+		upstream, err := net.Dial("tcp", "127.0.0.1:"+port)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			upstream.Close()
+			return
+		}
+		fmt.Fprintf(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+		go func() {
+			io.Copy(upstream, conn)
+			upstream.Close()
+		}()
+		io.Copy(conn, upstream)
+		conn.Close()
+	}))
+	defer proxy.Close()
+
+	f := newTestFetcher()
+	f.allowPrivate = false
+	f.Proxy, _ = url.Parse(proxy.URL)
+	_, port, _ := net.SplitHostPort(addr)
+	res, err := f.Fetch("ftp://203.0.113.9:"+port+"/bot", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(res.Data) != "payload" {
+		t.Errorf("Data = %q", res.Data)
+	}
+	if len(tunnels) != 2 || tunnels[0] != "203.0.113.9:"+port {
+		t.Errorf("tunnels = %v", tunnels)
+	}
+}
+
+func TestFetchTFTP(t *testing.T) {
+	// Two full blocks and a short one
+	content := bytes.Repeat([]byte("0123456789abcdef"), 70)
+	ln, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		buf := make([]byte, 600)
+		n, client, err := ln.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if string(buf[2:n]) != "mips\x00octet\x00" {
+			t.Errorf("RRQ = %q", buf[:n])
+			return
+		}
+		// Transfers run on a port of their own
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for block := 1; ; block++ {
+			chunk := content[min((block-1)*tftpBlockSize, len(content)):min(block*tftpBlockSize, len(content))]
+			pkt := binary.BigEndian.AppendUint16(binary.BigEndian.AppendUint16(nil, tftpDATA), uint16(block))
+			conn.WriteTo(append(pkt, chunk...), client)
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			if _, _, err := conn.ReadFrom(buf); err != nil {
+				return
+			}
+			if len(chunk) < tftpBlockSize {
+				return
+			}
+		}
+	}()
+
+	res, err := newTestFetcher().Fetch("tftp://"+ln.LocalAddr().String()+"/mips", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(res.Data, content) {
+		t.Errorf("Data has %d bytes, want %d", len(res.Data), len(content))
+	}
+}
+
+func TestPasvPort(t *testing.T) {
+	if port, err := pasvPort("Entering Passive Mode (192,0,2,1,195,80)."); err != nil || port != 50000 {
+		t.Errorf("pasvPort = %d, %v; want 50000", port, err)
+	}
+	for _, msg := range []string{"Entering Passive Mode", "(1,2,3,4,5)", "(1,2,3,4,256,0)"} {
+		if _, err := pasvPort(msg); err == nil {
+			t.Errorf("pasvPort(%q) succeeded", msg)
+		}
+	}
+}
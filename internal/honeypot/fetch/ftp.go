@@ -0,0 +1,125 @@
+package fetch
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// fetchFTP downloads u in passive mode. The data connection goes to the
+// server's own address, whatever the PASV reply names, so a server cannot
+// send the sensor elsewhere.
+func (f *Fetcher) fetchFTP(ctx context.Context, u *url.URL) (*Result, error) {
+	user, password := "anonymous", "anonymous@"
+	if u.User != nil {
+		user = u.User.Username()
+		password, _ = u.User.Password()
+	}
+	file := strings.TrimPrefix(u.Path, "/")
+	// Decoded line breaks would end a command and start another
+	for name, arg := range map[string]string{"user": user, "password": password, "path": file} {
+		if strings.ContainsAny(arg, "\r\n") {
+			return nil, fmt.Errorf("FTP URL has a line break in its %s", name)
+		}
+	}
+
+	host := u.Hostname()
+	port := u.Port()
+	if port == "" {
+		port = "21"
+	}
+	conn, err := f.dialTCP(ctx, net.JoinHostPort(host, port))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	c := textproto.NewConn(conn)
+
+	if _, _, err := c.ReadResponse(220); err != nil {
+		return nil, err
+	}
+	code, _, err := ftpCmd(c, 0, "USER %s", user)
+	if err != nil {
+		return nil, err
+	}
+	if code == 331 {
+		if _, _, err := ftpCmd(c, 230, "PASS %s", password); err != nil {
+			return nil, err
+		}
+	} else if code != 230 {
+		return nil, fmt.Errorf("USER: unexpected reply %d", code)
+	}
+	if _, _, err := ftpCmd(c, 200, "TYPE I"); err != nil {
+		return nil, err
+	}
+	_, msg, err := ftpCmd(c, 227, "PASV")
+	if err != nil {
+		return nil, err
+	}
+	dataPort, err := pasvPort(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := f.dialTCP(ctx, net.JoinHostPort(host, strconv.Itoa(dataPort)))
+	if err != nil {
+		return nil, err
+	}
+	defer data.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		data.SetDeadline(deadline)
+	}
+	code, msg, err = ftpCmd(c, 0, "RETR %s", file)
+	if err != nil {
+		return nil, err
+	}
+	if code != 125 && code != 150 {
+		return nil, fmt.Errorf("RETR: %d %s", code, msg)
+	}
+	payload, err := f.readAll(data)
+	if err != nil {
+		return nil, err
+	}
+	data.Close()
+	if _, _, err := c.ReadResponse(226); err != nil {
+		return nil, err
+	}
+	c.Cmd("QUIT")
+	return &Result{Data: payload}, nil
+}
+
+// ftpCmd sends a command and reads its reply, which must have expectCode
+// unless it is 0
+func ftpCmd(c *textproto.Conn, expectCode int, format string, args ...any) (int, string, error) {
+	if _, err := c.Cmd(format, args...); err != nil {
+		return 0, "", err
+	}
+	return c.ReadResponse(expectCode)
+}
+
+// pasvPort returns the port of a PASV reply such as
+// "Entering Passive Mode (192,0,2,1,195,80)"
+func pasvPort(msg string) (int, error) {
+	start := strings.IndexByte(msg, '(')
+	end := strings.LastIndexByte(msg, ')')
+	if start < 0 || end < start {
+		return 0, fmt.Errorf("PASV: malformed reply %q", msg)
+	}
+	fields := strings.Split(msg[start+1:end], ",")
+	if len(fields) != 6 {
+		return 0, fmt.Errorf("PASV: malformed reply %q", msg)
+	}
+	hi, err1 := strconv.Atoi(strings.TrimSpace(fields[4]))
+	lo, err2 := strconv.Atoi(strings.TrimSpace(fields[5]))
+	if err1 != nil || err2 != nil || hi > 255 || lo > 255 {
+		return 0, fmt.Errorf("PASV: malformed reply %q", msg)
+	}
+	return hi<<8 | lo, nil
+}
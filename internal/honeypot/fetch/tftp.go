@@ -0,0 +1,121 @@
+package fetch
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TFTP opcodes (RFC 1350)
+const (
+	tftpRRQ   = 1
+	tftpDATA  = 3
+	tftpACK   = 4
+	tftpERROR = 5
+)
+
+const (
+	tftpBlockSize = 512
+	// tftpRetransmit is how long to wait for a packet before sending the
+	// last one again, at most tftpRetries times in a row
+	tftpRetransmit = 2 * time.Second
+	tftpRetries    = 5
+)
+
+// fetchTFTP downloads u with a read request in octet mode
+func (f *Fetcher) fetchTFTP(ctx context.Context, u *url.URL) (*Result, error) {
+	if f.Proxy != nil {
+		return nil, fmt.Errorf("tftp cannot go through an HTTP proxy: %w", ErrUnsupported)
+	}
+	port := u.Port()
+	if port == "" {
+		port = "69"
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, u.Hostname())
+	if err != nil {
+		return nil, err
+	}
+	server := &net.UDPAddr{IP: addrs[0].IP, Zone: addrs[0].Zone}
+	if server.Port, err = net.LookupPort("udp", port); err != nil {
+		return nil, err
+	}
+	if err := f.checkAddr(server.String()); err != nil {
+		return nil, err
+	}
+
+	conn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	deadline, _ := ctx.Deadline()
+
+	var rrq bytes.Buffer
+	binary.Write(&rrq, binary.BigEndian, uint16(tftpRRQ))
+	rrq.WriteString(strings.TrimPrefix(u.Path, "/") + "\x00octet\x00")
+	last := rrq.Bytes()
+	lastTo := server
+
+	// The server answers from a port of its own, its transfer ID
+	var peer *net.UDPAddr
+	var payload []byte
+	buf := make([]byte, 4+tftpBlockSize)
+	retries := 0
+	for block := uint16(1); ; {
+		if _, err := conn.WriteToUDP(last, lastTo); err != nil {
+			return nil, err
+		}
+		wait := time.Now().Add(tftpRetransmit)
+		if !deadline.IsZero() && deadline.Before(wait) {
+			wait = deadline
+		}
+		conn.SetReadDeadline(wait)
+
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() && retries < tftpRetries && (deadline.IsZero() || time.Now().Before(deadline)) {
+				retries++
+				continue
+			}
+			return nil, err
+		}
+		if !from.IP.Equal(server.IP) || (peer != nil && from.Port != peer.Port) || n < 4 {
+			continue
+		}
+
+		switch binary.BigEndian.Uint16(buf) {
+		case tftpERROR:
+			return nil, fmt.Errorf("tftp: %s", strings.TrimRight(string(buf[4:n]), "\x00"))
+		case tftpDATA:
+		default:
+			continue
+		}
+		if binary.BigEndian.Uint16(buf[2:]) != block {
+			// A duplicate of a block already acknowledged
+			continue
+		}
+		peer = from
+		retries = 0
+		payload = append(payload, buf[4:n]...)
+		if f.MaxBytes > 0 && int64(len(payload)) > f.MaxBytes {
+			return nil, ErrTooLarge
+		}
+
+		ack := make([]byte, 4)
+		binary.BigEndian.PutUint16(ack, tftpACK)
+		binary.BigEndian.PutUint16(ack[2:], block)
+		if n-4 < tftpBlockSize {
+			conn.WriteToUDP(ack, peer)
+			return &Result{Data: payload}, nil
+		}
+		last, lastTo = ack, peer
+		block++
+	}
+}
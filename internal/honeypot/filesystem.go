@@ -1,39 +1,234 @@
 package honeypot
 
 import (
-	"fmt"
+	"hash/fnv"
+	"io/fs"
 	"strings"
+	"sync"
+	"time"
+
+	"phantom-grid/internal/honeypot/vfs"
 )
 
-// VirtualFileSystem represents a fake file system for honeypot
-type VirtualFileSystem struct {
-	files map[string]string
-	dirs  map[string][]string
+// baseImage is the file system every shell session starts from. It is built
+// once and shared; sessions work on copy-on-write overlays of it.
+var baseImage = sync.OnceValue(newBaseImage)
+
+// imageTime is when the files of the base image were last changed
+var imageTime = time.Date(2021, time.December, 15, 10, 23, 0, 0, time.UTC)
+
+const (
+	typeDir     = fs.ModeDir
+	typeSymlink = fs.ModeSymlink
+	typeCharDev = fs.ModeDevice | fs.ModeCharDevice
+)
+
+// imageFile is a file of the base image. The data of a symlink is its
+// target.
+type imageFile struct {
+	path     string
+	mode     fs.FileMode
+	uid, gid int
+	data     string
+}
+
+// imageBinaries are the programs in /usr/bin and /usr/sbin. The shell
+// emulates them by name; the files are there for ls and stat to find.
+var imageBinaries = map[string][]string{
+	"/usr/bin": {
+		"awk", "base64", "bash", "busybox", "cat", "chattr", "chgrp", "chmod", "chown", "cp",
+		"crontab", "curl", "dash", "date", "dd", "df", "dir", "echo", "env", "find", "free",
+		"grep", "head", "hostname", "id", "kill", "killall", "last", "ln", "ls", "lscpu",
+		"mkdir", "mv", "nano", "netstat", "nohup", "nproc", "passwd", "perl", "ps", "pwd",
+		"python3", "readlink", "rm", "rmdir", "scp", "sed", "sleep", "ssh", "stat", "sudo",
+		"tail", "tar", "tee", "top", "touch", "uname", "uptime", "vi", "w", "wc", "wget",
+		"which", "whoami",
+	},
+	"/usr/sbin": {"ifconfig", "iptables", "reboot", "service", "shutdown", "sshd", "useradd"},
+}
+
+// setuidBinaries run as their owner, root
+var setuidBinaries = map[string]bool{"/usr/bin/passwd": true, "/usr/bin/sudo": true}
+
+// imageFiles are the files of the base image, parents before children
+var imageFiles = []imageFile{
+	{path: "/bin", mode: typeSymlink, data: "usr/bin"},
+	{path: "/boot", mode: typeDir | 0755},
+	{path: "/dev", mode: typeDir | 0755},
+	{path: "/dev/null", mode: typeCharDev | 0666},
+	{path: "/dev/random", mode: typeCharDev | 0666},
+	{path: "/dev/tty", mode: typeCharDev | 0666, gid: 5},
+	{path: "/dev/urandom", mode: typeCharDev | 0666},
+	{path: "/dev/zero", mode: typeCharDev | 0666},
+	{path: "/etc", mode: typeDir | 0755},
+	{path: "/etc/apache2", mode: typeDir | 0755},
+	{path: "/etc/group", mode: 0644, data: etcGroup},
+	{path: "/etc/hostname", mode: 0644, data: "server\n"},
+	{path: "/etc/hosts", mode: 0644, data: etcHosts},
+	{path: "/etc/mysql", mode: typeDir | 0755},
+	{path: "/etc/nginx", mode: typeDir | 0755},
+	{path: "/etc/nginx/nginx.conf", mode: 0644, data: nginxConf},
+	{path: "/etc/passwd", mode: 0644, data: etcPasswd},
+	{path: "/etc/shadow", mode: 0640, gid: 42, data: etcShadow},
+	{path: "/home", mode: typeDir | 0755},
+	{path: "/home/ubuntu", mode: typeDir | 0750, uid: 1000, gid: 1000},
+	{path: "/home/ubuntu/.bashrc", mode: 0644, uid: 1000, gid: 1000, data: bashrc},
+	{path: "/home/ubuntu/.profile", mode: 0644, uid: 1000, gid: 1000, data: profile},
+	{path: "/lib", mode: typeSymlink, data: "usr/lib"},
+	{path: "/opt", mode: typeDir | 0755},
+	{path: "/root", mode: typeDir | 0700},
+	{path: "/root/.bash_history", mode: 0600, data: bashHistory},
+	{path: "/root/.bashrc", mode: 0644, data: bashrc},
+	{path: "/root/.profile", mode: 0644, data: profile},
+	{path: "/root/.ssh", mode: typeDir | 0700},
+	{path: "/root/.ssh/authorized_keys", mode: 0600, data: "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIH5dmlqL0Ngp3Xyo6Kx0fjDPS3zcY8Rb2E4JkDkUeS1v deploy@build\n"},
+	{path: "/root/backup.tar.gz", mode: 0600, data: "\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\x03" + strings.Repeat("\x00", 2038)},
+	{path: "/root/config.txt", mode: 0600, data: rootConfig},
+	{path: "/root/logs", mode: typeDir | 0755},
+	{path: "/run", mode: typeDir | 0755},
+	{path: "/sbin", mode: typeSymlink, data: "usr/sbin"},
+	{path: "/srv", mode: typeDir | 0755},
+	{path: "/tmp", mode: typeDir | fs.ModeSticky | 0777},
+	{path: "/usr", mode: typeDir | 0755},
+	{path: "/usr/bin", mode: typeDir | 0755},
+	{path: "/usr/lib", mode: typeDir | 0755},
+	{path: "/usr/local", mode: typeDir | 0755},
+	{path: "/usr/local/bin", mode: typeDir | 0755},
+	{path: "/usr/sbin", mode: typeDir | 0755},
+	{path: "/usr/share", mode: typeDir | 0755},
+	{path: "/var", mode: typeDir | 0755},
+	{path: "/var/backups", mode: typeDir | 0755},
+	{path: "/var/log", mode: typeDir | 0775, gid: 110},
+	{path: "/var/log/apache2", mode: typeDir | 0750, gid: 4},
+	{path: "/var/log/auth.log", mode: 0640, uid: 104, gid: 4, data: authLog},
+	{path: "/var/log/nginx", mode: typeDir | 0755, uid: 33, gid: 4},
+	{path: "/var/log/syslog", mode: 0640, uid: 104, gid: 4, data: syslog},
+	{path: "/var/tmp", mode: typeDir | fs.ModeSticky | 0777},
+	{path: "/var/www", mode: typeDir | 0755},
+	{path: "/var/www/config.php", mode: 0640, uid: 33, gid: 33, data: configPHP},
+	{path: "/var/www/html", mode: typeDir | 0755, uid: 33, gid: 33},
+	{path: "/var/www/uploads", mode: typeDir | 0775, uid: 33, gid: 33},
+}
+
+func newBaseImage() *vfs.FS {
+	f := vfs.New()
+	f.Now = func() time.Time { return imageTime }
+	for _, file := range imageFiles {
+		if err := addImageFile(f, file); err != nil {
+			// The image is fixed, so this is a bug in the table
+			panic(err)
+		}
+	}
+	for dir, names := range imageBinaries {
+		for _, name := range names {
+			p := dir + "/" + name
+			mode := fs.FileMode(0755)
+			if setuidBinaries[p] {
+				mode |= fs.ModeSetuid
+			}
+			if err := addImageFile(f, imageFile{path: p, mode: mode, data: elfStub(name)}); err != nil {
+				panic(err)
+			}
+		}
+	}
+	f.Now = time.Now
+	return f
 }
 
-// NewVirtualFileSystem creates a new virtual file system
-func NewVirtualFileSystem() *VirtualFileSystem {
-	vfs := &VirtualFileSystem{
-		files: make(map[string]string),
-		dirs:  make(map[string][]string),
+func addImageFile(f *vfs.FS, file imageFile) error {
+	var err error
+	switch {
+	case file.mode&typeSymlink != 0:
+		return f.Symlink(file.data, file.path)
+	case file.mode.IsDir():
+		err = f.Mkdir(file.path, file.mode)
+	case file.mode&fs.ModeDevice != 0:
+		err = f.Mknod(file.path, file.mode)
+	default:
+		err = f.WriteFile(file.path, []byte(file.data), file.mode)
+	}
+	if err == nil {
+		// Creation drops the setuid and sticky bits
+		err = f.Chmod(file.path, file.mode)
+	}
+	if err == nil {
+		err = f.Chown(file.path, file.uid, file.gid)
 	}
-	vfs.init()
-	return vfs
+	return err
 }
 
-func (vfs *VirtualFileSystem) init() {
-	// Root directory structure
-	vfs.dirs["/"] = []string{"root", "home", "etc", "var", "tmp", "usr", "opt", "boot"}
-	vfs.dirs["/root"] = []string{".bashrc", ".bash_history", ".ssh", "backup.tar.gz", "config.txt", "logs"}
-	vfs.dirs["/home"] = []string{"user", "admin", "www"}
-	vfs.dirs["/etc"] = []string{"passwd", "shadow", "hosts", "nginx", "apache2", "mysql"}
-	vfs.dirs["/var"] = []string{"log", "www", "backup", "tmp"}
-	vfs.dirs["/var/log"] = []string{"auth.log", "syslog", "nginx", "apache2"}
-	vfs.dirs["/var/www"] = []string{"html", "uploads", "config.php"}
-	vfs.dirs["/tmp"] = []string{"session.tmp", "cache.tmp"}
-
-	// File contents
-	vfs.files["/etc/passwd"] = `root:x:0:0:root:/root:/bin/bash
+// elfStub returns the contents of a program: an ELF header, padded to a
+// size that differs from program to program
+func elfStub(name string) string {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	size := 18000 + int(h.Sum32()%140000)
+	return "\x7fELF\x02\x01\x01" + strings.Repeat("\x00", size-7)
+}
+
+const etcGroup = `root:x:0:
+daemon:x:1:
+bin:x:2:
+sys:x:3:
+adm:x:4:syslog,ubuntu
+tty:x:5:
+disk:x:6:
+lp:x:7:
+mail:x:8:
+news:x:9:
+uucp:x:10:
+man:x:12:
+proxy:x:13:
+kmem:x:15:
+sudo:x:27:ubuntu
+www-data:x:33:
+backup:x:34:
+shadow:x:42:
+utmp:x:43:
+syslog:x:110:
+ubuntu:x:1000:
+nogroup:x:65534:
+`
+
+const etcShadow = `root:$6$Vx3kq9Zp$8gK1sN0rJ2fQ5yT7wE4uI6oP9aS3dF1gH5jK7lZ2xC4vB6nM8qW0eR2tY4uI6oP8aS0dF2gH4jK6lZ8xC0vB2n.:18976:0:99999:7:::
+daemon:*:18375:0:99999:7:::
+bin:*:18375:0:99999:7:::
+sys:*:18375:0:99999:7:::
+www-data:*:18375:0:99999:7:::
+nobody:*:18375:0:99999:7:::
+syslog:*:18375:0:99999:7:::
+sshd:*:18375:0:99999:7:::
+ubuntu:$6$Lm2pQ8rT$3hJ5kL7zX9cV1bN3mQ5wE7rT9yU1iO3pA5sD7fG9hJ1kL3zX5cV7bN9mQ1wE3rT5yU7iO9pA1sD3fG5hJ7kL9z.:18976:0:99999:7:::
+`
+
+const bashrc = `# ~/.bashrc: executed by bash(1) for non-login shells.
+
+# If not running interactively, don't do anything
+[ -z "$PS1" ] && return
+
+HISTCONTROL=ignoredups:ignorespace
+HISTSIZE=1000
+HISTFILESIZE=2000
+
+alias ls='ls --color=auto'
+alias ll='ls -alF'
+alias la='ls -A'
+`
+
+const profile = `# ~/.profile: executed by the command interpreter for login shells.
+
+if [ -n "$BASH_VERSION" ]; then
+    if [ -f "$HOME/.bashrc" ]; then
+	. "$HOME/.bashrc"
+    fi
+fi
+
+if [ -d "$HOME/bin" ] ; then
+    PATH="$HOME/bin:$PATH"
+fi
+`
+
+const etcPasswd = `root:x:0:0:root:/root:/bin/bash
 daemon:x:1:1:daemon:/usr/sbin:/usr/sbin/nologin
 bin:x:2:2:bin:/bin:/usr/sbin/nologin
 sys:x:3:3:sys:/dev:/usr/sbin/nologin
@@ -68,21 +263,21 @@ ubuntu:x:1000:1000:Ubuntu:/home/ubuntu:/bin/bash
 lxd:x:999:100::/var/snap/lxd/common/lxd:/bin/false
 `
 
-	vfs.files["/etc/hosts"] = `127.0.0.1	localhost
+const etcHosts = `127.0.0.1	localhost
 127.0.1.1	server
 ::1		localhost ip6-localhost ip6-loopback
 ff02::1		ip6-allnodes
 ff02::2		ip6-allrouters
 `
 
-	vfs.files["/var/log/auth.log"] = `Dec 15 10:23:15 server sshd[1234]: Accepted publickey for root from 192.168.1.100 port 54321 ssh2
+const authLog = `Dec 15 10:23:15 server sshd[1234]: Accepted publickey for root from 192.168.1.100 port 54321 ssh2
 Dec 15 10:25:30 server sshd[1235]: Failed password for invalid user admin from 192.168.1.101 port 54322 ssh2
 Dec 15 10:26:45 server sshd[1236]: Accepted publickey for ubuntu from 192.168.1.102 port 54323 ssh2
 Dec 15 10:28:12 server sudo:     root : TTY=pts/0 ; PWD=/root ; USER=root ; COMMAND=/usr/bin/apt update
 Dec 15 10:30:22 server sshd[1237]: Invalid user test from 192.168.1.103 port 54324 ssh2
 `
 
-	vfs.files["/var/log/syslog"] = `Dec 15 10:20:01 server systemd[1]: Started Daily apt upgrade and clean activities.
+const syslog = `Dec 15 10:20:01 server systemd[1]: Started Daily apt upgrade and clean activities.
 Dec 15 10:20:15 server systemd[1]: Starting Cleanup of Temporary Directories...
 Dec 15 10:20:15 server systemd[1]: Started Cleanup of Temporary Directories.
 Dec 15 10:23:15 server sshd[1234]: Server listening on 0.0.0.0 port 22.
@@ -90,14 +285,14 @@ Dec 15 10:23:15 server sshd[1234]: Server listening on :: port 22.
 Dec 15 10:25:30 server kernel: [12345.678901] audit: type=1106 audit(1639561530.123:456): pid=1235 uid=0 auid=4294967295 ses=4294967295 msg='op=PAM:authentication acct="admin" exe="/usr/sbin/sshd" hostname=? addr=192.168.1.101 terminal=ssh res=failed'
 `
 
-	vfs.files["/root/.bash_history"] = `cd /var/www
+const bashHistory = `cd /var/www
 ls -la
 cat config.php
 mysql -u root -p
 exit
 `
 
-	vfs.files["/root/config.txt"] = `# Database Configuration
+const rootConfig = `# Database Configuration
 DB_HOST=localhost
 DB_USER=admin
 DB_PASS=********
@@ -108,15 +303,16 @@ API_KEY=sk_live_51H3ll0W0rld
 SECRET_KEY=sk_test_4BcDeFgHiJkLmNoPqRsTuVwXyZ
 `
 
-	vfs.files["/var/www/config.php"] = `<?php
+const configPHP = `<?php
 define('DB_HOST', 'localhost');
 define('DB_USER', 'admin');
 define('DB_PASS', 'P@ssw0rd123');
 define('DB_NAME', 'wordpress');
 define('WP_DEBUG', false);
-?>`
+?>
+`
 
-	vfs.files["/etc/nginx/nginx.conf"] = `user www-data;
+const nginxConf = `user www-data;
 worker_processes auto;
 pid /run/nginx.pid;
 
@@ -149,54 +345,3 @@ http {
 	}
 }
 `
-}
-
-// ListFiles returns file listing for a directory
-func (vfs *VirtualFileSystem) ListFiles(path string) string {
-	path = vfs.normalizePath(path)
-
-	if files, ok := vfs.dirs[path]; ok {
-		output := fmt.Sprintf("total %d\r\n", len(files))
-		for _, file := range files {
-			// Simulate file permissions and sizes
-			if strings.HasPrefix(file, ".") {
-				output += fmt.Sprintf("-rw-r--r-- 1 root root  1024 Dec 15 10:23 %s\r\n", file)
-			} else if strings.Contains(file, ".") {
-				output += fmt.Sprintf("-rw-r--r-- 1 root root  2048 Dec 15 10:23 %s\r\n", file)
-			} else {
-				output += fmt.Sprintf("drwxr-xr-x 2 root root  4096 Dec 15 10:23 %s\r\n", file)
-			}
-		}
-		return output
-	}
-	return fmt.Sprintf("ls: cannot access '%s': No such file or directory\r\n", path)
-}
-
-// ReadFile returns file content
-func (vfs *VirtualFileSystem) ReadFile(path string) (string, bool) {
-	path = vfs.normalizePath(path)
-	content, ok := vfs.files[path]
-	return content, ok
-}
-
-// FileExists checks if file exists
-func (vfs *VirtualFileSystem) FileExists(path string) bool {
-	path = vfs.normalizePath(path)
-	_, exists := vfs.files[path]
-	if !exists {
-		_, exists = vfs.dirs[path]
-	}
-	return exists
-}
-
-// normalizePath normalizes file path
-func (vfs *VirtualFileSystem) normalizePath(path string) string {
-	path = strings.TrimSpace(path)
-	if path == "" || path == "." {
-		return "/root"
-	}
-	if !strings.HasPrefix(path, "/") {
-		path = "/root/" + path
-	}
-	return path
-}
@@ -0,0 +1,291 @@
+// Package fsimage loads base images of the honeypot's virtual file system
+// and generates them from real hosts, so sensors need not all look like the
+// same server.
+//
+// An image is a directory or a tar archive, gzip-compressed or not, holding
+// the file tree under rootfs/ and optionally a manifest: a text file listing
+// the mode, owner and modification time of files (see ParseEntry). The
+// manifest overrides what the tree records, so an image unpacked or edited by
+// an unprivileged user keeps its owners. It may also list directories and
+// device files missing from the tree, which such a user cannot create.
+package fsimage
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"phantom-grid/internal/honeypot/vfs"
+)
+
+const (
+	// RootDir holds the file tree of an image
+	RootDir = "rootfs"
+	// ManifestFile is the name of the manifest of an image
+	ManifestFile = "manifest"
+)
+
+// Load reads the image at p, a directory or a tar archive
+func Load(p string) (*vfs.FS, error) {
+	fi, err := os.Stat(p)
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		return LoadDir(p)
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return LoadTar(f)
+}
+
+// LoadDir reads an image unpacked in dir. Owners are taken from the files.
+func LoadDir(dir string) (*vfs.FS, error) {
+	l := newLoader()
+	root := filepath.Join(dir, RootDir)
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		e := Entry{
+			Path:    path.Join("/", filepath.ToSlash(rel)),
+			Mode:    info.Mode(),
+			ModTime: info.ModTime(),
+		}
+		if st, ok := info.Sys().(*syscall.Stat_t); ok {
+			e.UID, e.GID = int(st.Uid), int(st.Gid)
+		}
+
+		var data []byte
+		var target string
+		switch info.Mode().Type() {
+		case 0:
+			data, err = os.ReadFile(p)
+		case fs.ModeSymlink:
+			target, err = os.Readlink(p)
+		}
+		if err != nil {
+			return err
+		}
+		return l.add(e, data, target)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest []Entry
+	f, err := os.Open(filepath.Join(dir, ManifestFile))
+	switch {
+	case err == nil:
+		manifest, err = ParseManifest(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", ManifestFile, err)
+		}
+	case !errors.Is(err, fs.ErrNotExist):
+		return nil, err
+	}
+	return l.finish(manifest)
+}
+
+// LoadTar reads an image from a tar archive, which may be gzip-compressed.
+// Owners are taken from the archive headers.
+func LoadTar(r io.Reader) (*vfs.FS, error) {
+	br := bufio.NewReader(r)
+	if magic, _ := br.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	} else {
+		r = br
+	}
+
+	l := newLoader()
+	var manifest []Entry
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		name := strings.TrimPrefix(path.Clean("/"+hdr.Name), "/")
+		if name == ManifestFile {
+			if manifest, err = ParseManifest(tr); err != nil {
+				return nil, fmt.Errorf("%s: %w", ManifestFile, err)
+			}
+			continue
+		}
+		p, ok := treePath(name)
+		if !ok {
+			// Anything else in the archive, e.g. a README
+			continue
+		}
+
+		e := Entry{
+			Path:    p,
+			Mode:    hdr.FileInfo().Mode(),
+			UID:     hdr.Uid,
+			GID:     hdr.Gid,
+			ModTime: hdr.ModTime,
+		}
+		var data []byte
+		var target string
+		switch hdr.Typeflag {
+		case tar.TypeReg:
+			data, err = io.ReadAll(tr)
+		case tar.TypeLink:
+			// The virtual file system has no hard links, so the file is copied
+			linked, ok := treePath(strings.TrimPrefix(path.Clean("/"+hdr.Linkname), "/"))
+			if !ok {
+				return nil, fmt.Errorf("%s: hard link outside %s/: %s", hdr.Name, RootDir, hdr.Linkname)
+			}
+			data, err = l.f.ReadFile(linked)
+		case tar.TypeSymlink:
+			target = hdr.Linkname
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := l.add(e, data, target); err != nil {
+			return nil, err
+		}
+	}
+	return l.finish(manifest)
+}
+
+// treePath returns the path in the image of the archive member name, which
+// is under RootDir
+func treePath(name string) (string, bool) {
+	if name == RootDir {
+		return "/", true
+	}
+	if rest, ok := strings.CutPrefix(name, RootDir+"/"); ok {
+		return "/" + rest, true
+	}
+	return "", false
+}
+
+// loader builds a file system from the files of an image. Metadata is set
+// once all files exist, as creating a file changes its directory's
+// modification time.
+type loader struct {
+	f       *vfs.FS
+	entries []Entry
+}
+
+func newLoader() *loader {
+	return &loader{f: vfs.New()}
+}
+
+// add creates the file e describes. Its data is the contents of a regular
+// file; target is the target of a symlink. Sockets and pipes are left out.
+func (l *loader) add(e Entry, data []byte, target string) error {
+	// Symlinks keep the time they are created with
+	l.f.Now = func() time.Time { return e.ModTime }
+	if e.Path != "/" {
+		if err := l.f.MkdirAll(path.Dir(e.Path), 0755); err != nil {
+			return err
+		}
+	}
+
+	var err error
+	switch e.Mode.Type() {
+	case 0:
+		err = l.f.WriteFile(e.Path, data, e.Mode)
+	case fs.ModeDir:
+		err = l.f.MkdirAll(e.Path, e.Mode)
+	case fs.ModeSymlink:
+		err = l.f.Symlink(target, e.Path)
+	case fs.ModeDevice, fs.ModeDevice | fs.ModeCharDevice:
+		err = l.f.Mknod(e.Path, e.Mode)
+	default:
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	l.entries = append(l.entries, e)
+	return nil
+}
+
+// finish sets the metadata of the files, then that of the manifest, and
+// returns the file system
+func (l *loader) finish(manifest []Entry) (*vfs.FS, error) {
+	for _, e := range manifest {
+		fi, err := l.f.Lstat(e.Path)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			if e.Mode.Type() != fs.ModeDir && e.Mode&fs.ModeDevice == 0 {
+				return nil, fmt.Errorf("%s: %s is not in the image", ManifestFile, e.Path)
+			}
+			if err := l.add(e, nil, ""); err != nil {
+				return nil, err
+			}
+			continue
+		case err != nil:
+			return nil, err
+		case fi.Mode.Type() != e.Mode.Type():
+			return nil, fmt.Errorf("%s: %s is a %s in the manifest but a %s in the image", ManifestFile, e.Path, typeName(e.Mode), typeName(fi.Mode))
+		}
+		l.entries = append(l.entries, e)
+	}
+
+	for _, e := range l.entries {
+		// The virtual file system keeps no owner or mode of symlinks
+		if e.Mode.Type() == fs.ModeSymlink {
+			continue
+		}
+		if err := l.f.Chmod(e.Path, e.Mode); err != nil {
+			return nil, err
+		}
+		if err := l.f.Chown(e.Path, e.UID, e.GID); err != nil {
+			return nil, err
+		}
+		if err := l.f.Chtimes(e.Path, e.ModTime); err != nil {
+			return nil, err
+		}
+	}
+	l.f.Now = time.Now
+	return l.f, nil
+}
+
+func typeName(mode fs.FileMode) string {
+	switch {
+	case mode.IsDir():
+		return "directory"
+	case mode&fs.ModeSymlink != 0:
+		return "symlink"
+	case mode&fs.ModeDevice != 0:
+		return "device"
+	}
+	return "file"
+}
@@ -0,0 +1,309 @@
+package fsimage
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+)
+
+// DefaultPaths are the trees copied from a reference host when none are
+// given. Binaries and libraries are left out, as they make images large.
+var DefaultPaths = []string{"/etc", "/home", "/root", "/opt", "/srv", "/var/www"}
+
+// DefaultExclude are files that hold secrets or reveal the people and hosts
+// around a reference host. Patterns without a slash match base names, those
+// with a slash but not a leading one match the end of a path, such as a file
+// in any home directory.
+var DefaultExclude = []string{
+	"ssh_host_*", "id_*", "authorized_keys", "known_hosts", "*.key", "*.keytab",
+	"shadow-", "gshadow", "gshadow-", "/etc/ssl/private",
+	".bash_history", ".zsh_history", ".mysql_history", ".psql_history", ".python_history",
+	".lesshst", ".viminfo", ".git-credentials", ".netrc", ".pgpass", ".my.cnf", "*.swp",
+	"/etc/mysql/debian.cnf", "/etc/NetworkManager/system-connections", "wpa_supplicant*.conf",
+	"/etc/ppp/*-secrets", ".aws/credentials", ".docker/config.json", ".kube/config",
+	".env", "wp-config.php",
+}
+
+// GenerateOptions control how an image is made from a host
+type GenerateOptions struct {
+	Root        string   // Where the host's file system is, usually /
+	Paths       []string // Trees to copy; their parent directories are included
+	Exclude     []string // Patterns of files to leave out, see DefaultExclude
+	MaxFileSize int64    // Larger files are left out; 0 is unlimited
+	Hostname    string   // Replaces the host's name in text files; empty keeps it
+
+	// Skipped is called with each file left out and why; it may be nil
+	Skipped func(p, reason string)
+}
+
+// generator writes an image as a gzip-compressed tar archive
+type generator struct {
+	opts     GenerateOptions
+	tw       *tar.Writer
+	hostname string // Name of the reference host
+	entries  []Entry
+	written  map[string]bool
+}
+
+// Generate writes a sanitized image of the host at opts.Root to w, as a
+// gzip-compressed tar archive with a manifest. Files matching opts.Exclude
+// and private keys are left out, password hashes in /etc/shadow are replaced
+// with random ones, machine IDs are regenerated and the host's name is
+// replaced with opts.Hostname.
+func Generate(w io.Writer, opts GenerateOptions) error {
+	if opts.Root == "" {
+		opts.Root = "/"
+	}
+	gz := gzip.NewWriter(w)
+	g := &generator{
+		opts:    opts,
+		tw:      tar.NewWriter(gz),
+		written: make(map[string]bool),
+	}
+	if data, err := os.ReadFile(g.hostPath("/etc/hostname")); err == nil {
+		g.hostname = strings.TrimSpace(string(data))
+	}
+
+	for _, p := range opts.Paths {
+		p = path.Clean("/" + p)
+		if err := g.addParents(p); err != nil {
+			return err
+		}
+		if err := g.addTree(p); err != nil {
+			return err
+		}
+	}
+
+	var manifest bytes.Buffer
+	if err := WriteManifest(&manifest, g.entries); err != nil {
+		return err
+	}
+	hdr := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     ManifestFile,
+		Mode:     0644,
+		Size:     int64(manifest.Len()),
+		ModTime:  time.Now(),
+	}
+	if err := g.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if _, err := g.tw.Write(manifest.Bytes()); err != nil {
+		return err
+	}
+	if err := g.tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func (g *generator) hostPath(p string) string {
+	return filepath.Join(g.opts.Root, filepath.FromSlash(p))
+}
+
+func (g *generator) skip(p, reason string) {
+	if g.opts.Skipped != nil {
+		g.opts.Skipped(p, reason)
+	}
+}
+
+// addParents adds the directories above p
+func (g *generator) addParents(p string) error {
+	if p == "/" {
+		return nil
+	}
+	var dirs []string
+	for dir := path.Dir(p); ; dir = path.Dir(dir) {
+		dirs = append([]string{dir}, dirs...)
+		if dir == "/" {
+			break
+		}
+	}
+	for _, dir := range dirs {
+		info, err := os.Lstat(g.hostPath(dir))
+		if err != nil {
+			return err
+		}
+		if err := g.add(dir, info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addTree adds p and, if it is a directory, everything below it. Files that
+// cannot be read are skipped.
+func (g *generator) addTree(p string) error {
+	return filepath.WalkDir(g.hostPath(p), func(hp string, d fs.DirEntry, err error) error {
+		rel, relErr := filepath.Rel(g.opts.Root, hp)
+		if relErr != nil {
+			return relErr
+		}
+		ip := path.Join("/", filepath.ToSlash(rel))
+		if err != nil {
+			g.skip(ip, err.Error())
+			if d != nil && d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if g.excluded(ip) {
+			g.skip(ip, "excluded")
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			g.skip(ip, err.Error())
+			return nil
+		}
+		return g.add(ip, info)
+	})
+}
+
+// excluded reports whether p matches an exclude pattern
+func (g *generator) excluded(p string) bool {
+	elems := strings.Split(p, "/")
+	for _, pattern := range g.opts.Exclude {
+		name := p
+		// Relative patterns match as many trailing elements of p as they have
+		if n := strings.Count(pattern, "/") + 1; !strings.HasPrefix(pattern, "/") && n < len(elems) {
+			name = strings.Join(elems[len(elems)-n:], "/")
+		}
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// add writes the file p of the image, described by info
+func (g *generator) add(p string, info fs.FileInfo) error {
+	if g.written[p] {
+		return nil
+	}
+
+	var data []byte
+	var target string
+	switch info.Mode().Type() {
+	case 0:
+		if g.opts.MaxFileSize > 0 && info.Size() > g.opts.MaxFileSize {
+			g.skip(p, fmt.Sprintf("larger than %d bytes", g.opts.MaxFileSize))
+			return nil
+		}
+		var err error
+		if data, err = os.ReadFile(g.hostPath(p)); err != nil {
+			g.skip(p, err.Error())
+			return nil
+		}
+		if bytes.Contains(data, []byte("PRIVATE KEY-----")) {
+			g.skip(p, "private key")
+			return nil
+		}
+		data = g.sanitize(p, data)
+	case fs.ModeSymlink:
+		var err error
+		if target, err = os.Readlink(g.hostPath(p)); err != nil {
+			g.skip(p, err.Error())
+			return nil
+		}
+	case fs.ModeDir, fs.ModeDevice, fs.ModeDevice | fs.ModeCharDevice:
+	default:
+		// Sockets and pipes
+		return nil
+	}
+
+	hdr, err := tar.FileInfoHeader(info, target)
+	if err != nil {
+		return err
+	}
+	hdr.Name = RootDir + p
+	if p == "/" {
+		hdr.Name = RootDir
+	}
+	if info.IsDir() {
+		hdr.Name += "/"
+	}
+	// The names of the host's users are in its /etc/passwd if copied
+	hdr.Uname, hdr.Gname = "", ""
+	hdr.Size = int64(len(data))
+	if err := g.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if _, err := g.tw.Write(data); err != nil {
+		return err
+	}
+
+	e := Entry{Path: p, Mode: info.Mode(), ModTime: info.ModTime()}
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		e.UID, e.GID = int(st.Uid), int(st.Gid)
+	}
+	g.entries = append(g.entries, e)
+	g.written[p] = true
+	return nil
+}
+
+// sanitize returns the contents of the file p with secrets and identifiers
+// of the reference host replaced
+func (g *generator) sanitize(p string, data []byte) []byte {
+	switch p {
+	case "/etc/shadow":
+		data = fakeHashes(data)
+	case "/etc/machine-id", "/var/lib/dbus/machine-id":
+		return []byte(randomHex(16) + "\n")
+	}
+	if g.hostname != "" && g.opts.Hostname != "" && isText(data) {
+		data = bytes.ReplaceAll(data, []byte(g.hostname), []byte(g.opts.Hostname))
+	}
+	return data
+}
+
+// fakeHashes replaces the password hashes of a shadow file with random
+// SHA-512 crypt hashes. Locked and passwordless accounts are left as they are.
+func fakeHashes(shadow []byte) []byte {
+	lines := strings.SplitAfter(string(shadow), "\n")
+	for i, line := range lines {
+		fields := strings.Split(line, ":")
+		if len(fields) > 1 && strings.HasPrefix(fields[1], "$") {
+			fields[1] = "$6$" + randomCrypt(16) + "$" + randomCrypt(86)
+			lines[i] = strings.Join(fields, ":")
+		}
+	}
+	return []byte(strings.Join(lines, ""))
+}
+
+// randomCrypt returns n random characters of the crypt(3) alphabet
+func randomCrypt(n int) string {
+	const alphabet = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	b := make([]byte, n)
+	rand.Read(b)
+	for i := range b {
+		b[i] = alphabet[int(b[i])%len(alphabet)]
+	}
+	return string(b)
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// isText reports whether data looks like a text file
+func isText(data []byte) bool {
+	return utf8.Valid(data) && bytes.IndexByte(data, 0) < 0
+}
@@ -0,0 +1,163 @@
+package fsimage
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Entry is a line of a manifest: the metadata of one file of the image
+type Entry struct {
+	Path    string
+	Mode    fs.FileMode // Type, permission and setuid, setgid and sticky bits
+	UID     int
+	GID     int
+	ModTime time.Time
+}
+
+// ParseEntry parses a manifest line written as 'mode uid:gid mtime path'.
+// The mode is written as ls -l shows it, e.g. -rw-r----- or drwxrwxrwt, and
+// the modification time in RFC 3339 format. The path is the rest of the line,
+// so it may contain spaces.
+func ParseEntry(line string) (Entry, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 4 {
+		return Entry{}, fmt.Errorf("invalid manifest entry %q: want 'mode uid:gid mtime path'", line)
+	}
+
+	var e Entry
+	var err error
+	if e.Mode, err = ParseMode(fields[0]); err != nil {
+		return Entry{}, err
+	}
+	uid, gid, ok := strings.Cut(fields[1], ":")
+	if e.UID, err = strconv.Atoi(uid); !ok || err != nil || e.UID < 0 {
+		return Entry{}, fmt.Errorf("invalid owner %q: want uid:gid", fields[1])
+	}
+	if e.GID, err = strconv.Atoi(gid); err != nil || e.GID < 0 {
+		return Entry{}, fmt.Errorf("invalid owner %q: want uid:gid", fields[1])
+	}
+	if e.ModTime, err = time.Parse(time.RFC3339, fields[2]); err != nil {
+		return Entry{}, fmt.Errorf("invalid modification time %q: %w", fields[2], err)
+	}
+
+	// Everything after the time, so paths may contain spaces
+	rest := strings.TrimSpace(line)
+	for _, field := range fields[:3] {
+		rest = strings.TrimSpace(rest[strings.Index(rest, field)+len(field):])
+	}
+	if !strings.HasPrefix(rest, "/") {
+		return Entry{}, fmt.Errorf("invalid path %q: must be absolute", rest)
+	}
+	e.Path = path.Clean(rest)
+	return e, nil
+}
+
+// ParseManifest reads one entry per line. Blank lines and lines starting
+// with '#' are skipped.
+func ParseManifest(r io.Reader) ([]Entry, error) {
+	var entries []Entry
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		e, err := ParseEntry(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		entries = append(entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// WriteManifest writes entries in the format ParseManifest reads
+func WriteManifest(w io.Writer, entries []Entry) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "# mode uid:gid mtime path")
+	for _, e := range entries {
+		fmt.Fprintf(bw, "%s %d:%d %s %s\n", FormatMode(e.Mode), e.UID, e.GID, e.ModTime.UTC().Format(time.RFC3339), e.Path)
+	}
+	return bw.Flush()
+}
+
+// typeChars are the file types a mode string may start with
+var typeChars = map[byte]fs.FileMode{
+	'-': 0,
+	'd': fs.ModeDir,
+	'l': fs.ModeSymlink,
+	'c': fs.ModeDevice | fs.ModeCharDevice,
+	'b': fs.ModeDevice,
+}
+
+// FormatMode returns mode as ls -l shows it
+func FormatMode(mode fs.FileMode) string {
+	b := []byte("----------")
+	for c, t := range typeChars {
+		if t != 0 && mode&fs.ModeType == t {
+			b[0] = c
+		}
+	}
+	const rwx = "rwxrwxrwx"
+	for i := 0; i < 9; i++ {
+		if mode&(1<<uint(8-i)) != 0 {
+			b[i+1] = rwx[i]
+		}
+	}
+	special := func(i int, bit fs.FileMode, set, unset byte) {
+		if mode&bit == 0 {
+			return
+		}
+		if b[i] == '-' {
+			b[i] = unset
+		} else {
+			b[i] = set
+		}
+	}
+	special(3, fs.ModeSetuid, 's', 'S')
+	special(6, fs.ModeSetgid, 's', 'S')
+	special(9, fs.ModeSticky, 't', 'T')
+	return string(b)
+}
+
+// ParseMode parses a mode written as ls -l shows it
+func ParseMode(s string) (fs.FileMode, error) {
+	if len(s) != 10 {
+		return 0, fmt.Errorf("invalid mode %q: want e.g. -rw-r--r--", s)
+	}
+	mode, ok := typeChars[s[0]]
+	if !ok {
+		return 0, fmt.Errorf("invalid mode %q: unknown file type %q", s, s[0])
+	}
+	const rwx = "rwxrwxrwx"
+	for i := 0; i < 9; i++ {
+		c := s[i+1]
+		switch {
+		case c == rwx[i]:
+			mode |= 1 << uint(8-i)
+		case c == '-':
+		case i == 2 && (c == 's' || c == 'S'):
+			mode |= fs.ModeSetuid
+		case i == 5 && (c == 's' || c == 'S'):
+			mode |= fs.ModeSetgid
+		case i == 8 && (c == 't' || c == 'T'):
+			mode |= fs.ModeSticky
+		default:
+			return 0, fmt.Errorf("invalid mode %q", s)
+		}
+		// Lower case s and t also grant execute
+		if c == 's' || c == 't' {
+			mode |= 1 << uint(8-i)
+		}
+	}
+	return mode, nil
+}
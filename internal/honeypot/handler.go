@@ -1,18 +1,51 @@
 package honeypot
 
 import (
+	"crypto/tls"
 	"net"
+
+	"golang.org/x/crypto/ssh"
+
+	"phantom-grid/internal/config"
+	"phantom-grid/internal/honeypot/classify"
+	"phantom-grid/internal/honeypot/fetch"
+	"phantom-grid/internal/honeypot/mysql"
+	"phantom-grid/internal/honeypot/vfs"
+	"phantom-grid/internal/honeypot/web"
+	"phantom-grid/internal/logger"
 )
 
 // Handler handles different service interactions
 type Handler struct {
-	logChan chan<- string
+	logChan      chan<- string
+	sshHostKeys  []ssh.Signer
+	sshVersion   string // Version line announced by the SSH persona
+	telnetBanner string // Issue shown before the Telnet login prompt
+	login        config.LoginConfiguration
+	fs           config.FileSystemConfiguration
+	image        *vfs.FS        // Base image of the shell's file system; nil for the built-in one
+	fetcher      *fetch.Fetcher // Downloads payloads; nil if they are not fetched
+	recording    config.RecordingConfiguration
+	http         config.HTTPConfiguration
+	mysqlConfig  config.MySQLConfiguration
+	site         *web.Site            // Site the HTTP persona serves; nil for the default site
+	httpCert     *tls.Certificate     // Served to TLS clients; nil if TLS is not offered
+	classifier   *classify.Classifier // Tags HTTP requests with attacks; nil if they are not classified
+	mysqlVersion string               // Version the MySQL persona announces
+	mysqlSchema  *mysql.Schema        // Databases of the MySQL persona; nil for the built-in ones
+	port         int                  // Port the attacker connected to
+	events       func(*logger.SecurityEvent)
 }
 
 // NewHandler creates a new handler instance
 func NewHandler(logChan chan<- string) *Handler {
 	return &Handler{
-		logChan: logChan,
+		logChan:     logChan,
+		login:       config.DefaultLoginConfig(),
+		fs:          config.DefaultFileSystemConfig(),
+		recording:   config.DefaultRecordingConfig(),
+		http:        config.DefaultHTTPConfig(),
+		mysqlConfig: config.DefaultMySQLConfig(),
 	}
 }
 
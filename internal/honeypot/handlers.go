@@ -14,56 +14,7 @@ import (
 
 // handleHTTP is implemented in http_handler.go
 
-// handleTelnet simulates Telnet login interaction
-func (h *Handler) handleTelnet(conn net.Conn, remote, t string) {
-	conn.Write([]byte("\r\nUbuntu 20.04.3 LTS\r\n\r\n"))
-	time.Sleep(200 * time.Millisecond)
-	conn.Write([]byte("server login: "))
-
-	buf := make([]byte, 1024)
-	loginAttempts := 0
-
-	n, err := conn.Read(buf)
-	if err != nil || n == 0 {
-		return
-	}
-	username := strings.TrimSpace(string(buf[:n]))
-	h.logChan <- fmt.Sprintf("[%s] TELNET LOGIN ATTEMPT: username='%s'", t, username)
-
-	conn.Write([]byte("\r\nPassword: "))
-
-	n, err = conn.Read(buf)
-	if err != nil || n == 0 {
-		return
-	}
-	password := strings.TrimSpace(string(buf[:n]))
-	loginAttempts++
-
-	ip := strings.Split(remote, ":")[0]
-	h.logChan <- fmt.Sprintf("[%s] TELNET PASSWORD ATTEMPT #%d from %s (password length: %d)", t, loginAttempts, ip, len(password))
-	logger.LogAttack(ip, fmt.Sprintf("TELNET_LOGIN: user=%s, pass=***", username))
-
-	time.Sleep(500 * time.Millisecond)
-
-	if loginAttempts < 3 {
-		conn.Write([]byte("\r\nLogin incorrect\r\n\r\n"))
-		conn.Write([]byte("server login: "))
-		n, err = conn.Read(buf)
-		if err != nil {
-			return
-		}
-		username = strings.TrimSpace(string(buf[:n]))
-		conn.Write([]byte("\r\nPassword: "))
-		n, err = conn.Read(buf)
-		if err != nil {
-			return
-		}
-		loginAttempts++
-		h.logChan <- fmt.Sprintf("[%s] TELNET PASSWORD ATTEMPT #%d from %s", t, loginAttempts, ip)
-	}
-
-	conn.Write([]byte("\r\nToo many login attempts. Connection closed.\r\n"))
-}
+// handleTelnet is implemented in telnet.go
 
 // handleMySQL is implemented in mysql_handler.go
 
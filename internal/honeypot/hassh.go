@@ -0,0 +1,149 @@
+package honeypot
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+)
+
+// msgKexInit is the SSH_MSG_KEXINIT message number
+const msgKexInit = 20
+
+// maxKexRecord bounds how much of the stream is buffered looking for the
+// client's KEXINIT
+const maxKexRecord = 64 * 1024
+
+// clientKexInit holds the client algorithm lists of an SSH_MSG_KEXINIT that
+// make up a HASSH fingerprint
+type clientKexInit struct {
+	kex         []string
+	ciphers     []string // Client to server
+	macs        []string // Client to server
+	compression []string // Client to server
+}
+
+// hassh returns the HASSH of the client: the MD5 of its key exchange,
+// cipher, MAC and compression lists. It identifies the client implementation
+// regardless of the version string it claims.
+func (k clientKexInit) hassh() string {
+	lists := []string{
+		strings.Join(k.kex, ","),
+		strings.Join(k.ciphers, ","),
+		strings.Join(k.macs, ","),
+		strings.Join(k.compression, ","),
+	}
+	sum := md5.Sum([]byte(strings.Join(lists, ";")))
+	return hex.EncodeToString(sum[:])
+}
+
+// parseKexInit parses the payload of an SSH_MSG_KEXINIT sent by a client
+func parseKexInit(payload []byte) (clientKexInit, error) {
+	if len(payload) < 17 || payload[0] != msgKexInit {
+		return clientKexInit{}, errors.New("not a KEXINIT message")
+	}
+	rest := payload[17:] // Message number and cookie
+
+	// kex, host key, ciphers c2s/s2c, MACs c2s/s2c, compression c2s/s2c
+	var lists [8][]string
+	for i := range lists {
+		if len(rest) < 4 {
+			return clientKexInit{}, errors.New("truncated KEXINIT")
+		}
+		n := binary.BigEndian.Uint32(rest)
+		if uint32(len(rest)-4) < n {
+			return clientKexInit{}, errors.New("truncated KEXINIT")
+		}
+		if n > 0 {
+			lists[i] = strings.Split(string(rest[4:4+n]), ",")
+		}
+		rest = rest[4+n:]
+	}
+	return clientKexInit{kex: lists[0], ciphers: lists[2], macs: lists[4], compression: lists[6]}, nil
+}
+
+// kexRecorder wraps an SSH server connection and fingerprints the client
+// from its first binary packet, which is the unencrypted KEXINIT
+type kexRecorder struct {
+	net.Conn
+	mu      sync.Mutex
+	buf     []byte
+	done    bool
+	version string
+	kex     clientKexInit
+	found   bool
+}
+
+func newKexRecorder(conn net.Conn) *kexRecorder {
+	return &kexRecorder{Conn: conn}
+}
+
+func (r *kexRecorder) Read(p []byte) (int, error) {
+	n, err := r.Conn.Read(p)
+	if n > 0 {
+		r.mu.Lock()
+		if !r.done {
+			r.buf = append(r.buf, p[:n]...)
+			r.parse()
+		}
+		r.mu.Unlock()
+	}
+	return n, err
+}
+
+// parse looks for the KEXINIT after the client's version line
+func (r *kexRecorder) parse() {
+	if len(r.buf) > maxKexRecord {
+		r.finish()
+		return
+	}
+	eol := bytes.IndexByte(r.buf, '\n')
+	if eol < 0 {
+		return
+	}
+	r.version = strings.TrimSpace(string(r.buf[:eol]))
+	pkt := r.buf[eol+1:]
+	if len(pkt) < 5 {
+		return
+	}
+	length := binary.BigEndian.Uint32(pkt)
+	padding := uint32(pkt[4])
+	if length > maxKexRecord || padding+1 > length {
+		r.finish()
+		return
+	}
+	if uint32(len(pkt)-4) < length {
+		return
+	}
+	if kex, err := parseKexInit(pkt[5 : 4+length-padding]); err == nil {
+		r.kex = kex
+		r.found = true
+	}
+	r.finish()
+}
+
+func (r *kexRecorder) finish() {
+	r.done = true
+	r.buf = nil
+}
+
+// Version returns the client's version line, or "" if none was read
+func (r *kexRecorder) Version() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.version
+}
+
+// HASSH returns the client's HASSH, or "" if no KEXINIT was seen
+func (r *kexRecorder) HASSH() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.found {
+		return ""
+	}
+	return r.kex.hassh()
+}
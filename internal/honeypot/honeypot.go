@@ -1,36 +1,155 @@
 package honeypot
 
 import (
+	"crypto/tls"
 	"fmt"
 	"net"
+	"net/url"
+	"runtime/debug"
 	"strings"
 	"sync"
 	"time"
 
+	"golang.org/x/crypto/ssh"
+
 	"phantom-grid/internal/config"
+	"phantom-grid/internal/honeypot/classify"
+	"phantom-grid/internal/honeypot/fetch"
+	"phantom-grid/internal/honeypot/fsimage"
+	"phantom-grid/internal/honeypot/mysql"
+	"phantom-grid/internal/honeypot/vfs"
+	"phantom-grid/internal/honeypot/web"
 	"phantom-grid/internal/logger"
 	"phantom-grid/internal/mirage"
 )
 
+// PortResolver recovers the port an attacker originally targeted
+// before the connection was steered to the honeypot fallback port
+type PortResolver interface {
+	OriginalPort(remote *net.TCPAddr) (int, bool)
+}
+
 // Honeypot manages multiple fake port listeners
 type Honeypot struct {
-	logChan   chan<- string
-	listeners []net.Listener
-	wg        sync.WaitGroup
+	logChan          chan<- string
+	listeners        []net.Listener
+	packetConns      []net.PacketConn
+	wg               sync.WaitGroup
+	portResolver     PortResolver
+	steeringMode     config.SteeringMode
+	fallbackListener net.Listener
+	fallbackPort     int
+	tarpit           *tarpit
+	sshConfig        config.SSHConfiguration
+	sshHostKeys      []ssh.Signer
+	login            config.LoginConfiguration
+	fsConfig         config.FileSystemConfiguration
+	image            *vfs.FS // Loaded from fsConfig.Image; nil for the built-in image
+	payloadConfig    config.PayloadConfiguration
+	fetcher          *fetch.Fetcher // Set up from payloadConfig; nil if payloads are not fetched
+	recording        config.RecordingConfiguration
+	httpConfig       config.HTTPConfiguration
+	sites            map[string]*web.Site // Built-in sites and those loaded from httpConfig.SiteDir
+	httpCert         *tls.Certificate
+	classifier       *classify.Classifier // Built-in rule packs and those loaded from httpConfig.RuleDir
+	mysqlConfig      config.MySQLConfiguration
+	mysqlSchema      *mysql.Schema // Built-in databases and those loaded from mysqlConfig.SchemaDir
+	events           func(*logger.SecurityEvent) // Receives structured events; may be nil
 }
 
 // New creates a new Honeypot instance
 func New(logChan chan<- string) *Honeypot {
 	return &Honeypot{
-		logChan:   logChan,
-		listeners: make([]net.Listener, 0),
+		logChan:       logChan,
+		listeners:     make([]net.Listener, 0),
+		steeringMode:  config.SteeringModeRedirect,
+		fallbackPort:  config.HoneypotPort,
+		sshConfig:     config.DefaultSSHConfig(),
+		login:         config.DefaultLoginConfig(),
+		fsConfig:      config.DefaultFileSystemConfig(),
+		payloadConfig: config.DefaultPayloadConfig(),
+		recording:     config.DefaultRecordingConfig(),
+		httpConfig:    config.DefaultHTTPConfig(),
+		mysqlConfig:   config.DefaultMySQLConfig(),
 	}
 }
 
+// SetSSH configures the SSH persona
+func (h *Honeypot) SetSSH(cfg config.SSHConfiguration) {
+	h.sshConfig = cfg
+}
+
+// SetLogin sets the credentials the SSH, Telnet and MySQL personas accept
+func (h *Honeypot) SetLogin(cfg config.LoginConfiguration) {
+	h.login = cfg
+}
+
+// SetFileSystem configures the file system of the shell personas and where
+// the files attackers write are kept
+func (h *Honeypot) SetFileSystem(cfg config.FileSystemConfiguration) {
+	h.fsConfig = cfg
+}
+
+// SetPayloads configures the fetching of the payloads download commands in
+// the fake shell point at
+func (h *Honeypot) SetPayloads(cfg config.PayloadConfiguration) {
+	h.payloadConfig = cfg
+}
+
+// SetRecording configures where the sessions of the shell personas are
+// recorded
+func (h *Honeypot) SetRecording(cfg config.RecordingConfiguration) {
+	h.recording = cfg
+}
+
+// SetHTTP configures the HTTP persona
+func (h *Honeypot) SetHTTP(cfg config.HTTPConfiguration) {
+	h.httpConfig = cfg
+}
+
+// SetMySQL configures the MySQL persona
+func (h *Honeypot) SetMySQL(cfg config.MySQLConfiguration) {
+	h.mysqlConfig = cfg
+}
+
+// SetEventLogger sets where structured events, such as captured HTTP
+// requests and MySQL queries, are sent
+func (h *Honeypot) SetEventLogger(fn func(*logger.SecurityEvent)) {
+	h.events = fn
+}
+
+// SetSteeringMode sets how the kernel steers unprotected ports to the fallback listener.
+// In sk_lookup mode the fallback port number is irrelevant, so alternatives are tried
+// instead of failing when HoneypotPort is taken.
+func (h *Honeypot) SetSteeringMode(mode config.SteeringMode) {
+	h.steeringMode = mode
+}
+
+// FallbackListener returns the listener that receives steered connections
+func (h *Honeypot) FallbackListener() net.Listener {
+	return h.fallbackListener
+}
+
+// SetPortResolver sets the resolver used to recover the original destination
+// port of connections arriving on the fallback port
+func (h *Honeypot) SetPortResolver(r PortResolver) {
+	h.portResolver = r
+}
+
 // Start binds to fake ports and starts accepting connections
 func (h *Honeypot) Start() error {
 	var boundPorts []int
 
+	if err := h.loadSSHHostKeys(); err != nil {
+		return err
+	}
+	h.loadImage()
+	h.setupFetcher()
+	h.loadSites()
+	h.loadRules()
+	h.loadHTTPCertificate()
+	h.loadDatabases()
+
 	// Try to bind all fake ports
 	for _, port := range config.FakePorts {
 		ln, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
@@ -51,14 +170,152 @@ func (h *Honeypot) Start() error {
 		return err
 	}
 
-	h.logChan <- fmt.Sprintf("[SYSTEM] Honeypot bound to %d ports (%d direct, 1 fallback) - The Mirage active", len(h.listeners), len(boundPorts))
-	h.logChan <- "[SYSTEM] Honeypot is now ACCEPTING connections on port 9999"
+	udpPorts := h.startUDP()
+
+	h.logChan <- fmt.Sprintf("[SYSTEM] Honeypot bound to %d ports (%d direct, 1 fallback, %d UDP) - The Mirage active", len(h.listeners)+udpPorts, len(boundPorts), udpPorts)
+	h.logChan <- fmt.Sprintf("[SYSTEM] Honeypot is now ACCEPTING connections on port %d", h.fallbackPort)
 	h.logChan <- "[SYSTEM] Ready to receive traffic from external hosts"
 
 	return nil
 }
 
+// loadSSHHostKeys loads the persistent SSH host keys, falling back to
+// temporary ones if the key directory cannot be used
+func (h *Honeypot) loadSSHHostKeys() error {
+	keys, err := loadHostKeys(h.sshConfig.HostKeyDir)
+	if err != nil {
+		h.logChan <- fmt.Sprintf("[WARN] Cannot use SSH host keys in %s: %v (using temporary keys)", h.sshConfig.HostKeyDir, err)
+		if keys, err = loadHostKeys(""); err != nil {
+			return fmt.Errorf("failed to generate SSH host keys: %w", err)
+		}
+	}
+	h.sshHostKeys = keys
+	return nil
+}
+
+// loadImage loads the configured file system image, falling back to the
+// built-in one if it cannot be loaded
+func (h *Honeypot) loadImage() {
+	if h.fsConfig.Image == "" {
+		return
+	}
+	image, err := fsimage.Load(h.fsConfig.Image)
+	if err != nil {
+		h.logChan <- fmt.Sprintf("[WARN] Cannot load file system image %s: %v (using the built-in image)", h.fsConfig.Image, err)
+		return
+	}
+	h.image = image
+	h.logChan <- fmt.Sprintf("[SYSTEM] Shell file system loaded from %s", h.fsConfig.Image)
+}
+
+// setupFetcher prepares the fetching of payloads if it is enabled
+func (h *Honeypot) setupFetcher() {
+	cfg := h.payloadConfig
+	if !cfg.Fetch {
+		return
+	}
+	f := &fetch.Fetcher{
+		MaxBytes: cfg.MaxBytes,
+		Timeout:  time.Duration(cfg.TimeoutSeconds) * time.Second,
+	}
+	if cfg.Proxy == "" {
+		h.logChan <- "[SYSTEM] Payloads are fetched directly from public addresses"
+	} else {
+		proxy, err := url.Parse(cfg.Proxy)
+		if err != nil {
+			h.logChan <- fmt.Sprintf("[WARN] Cannot use payload proxy: %v (payloads are not fetched)", err)
+			return
+		}
+		f.Proxy = proxy
+		h.logChan <- fmt.Sprintf("[SYSTEM] Payloads are fetched through %s", proxy.Redacted())
+	}
+	h.fetcher = f
+}
+
+// loadSites loads the sites of the HTTP persona: the built-in ones and those
+// in the configured directory, which replace built-in sites of the same name
+func (h *Honeypot) loadSites() {
+	h.sites = web.Builtin()
+	if dir := h.httpConfig.SiteDir; dir != "" {
+		sites, err := web.LoadDir(dir)
+		if err != nil {
+			h.logChan <- fmt.Sprintf("[WARN] Cannot load HTTP sites from %s: %v (using the built-in sites)", dir, err)
+		}
+		for name, site := range sites {
+			h.sites[name] = site
+		}
+	}
+	if h.sites[h.httpConfig.DefaultSite] == nil {
+		h.logChan <- fmt.Sprintf("[WARN] Unknown HTTP site %s (using %s)", h.httpConfig.DefaultSite, config.DefaultHTTPConfig().DefaultSite)
+		h.httpConfig.DefaultSite = config.DefaultHTTPConfig().DefaultSite
+	}
+	h.logChan <- fmt.Sprintf("[SYSTEM] HTTP sites: %s (default %s)", strings.Join(web.Names(h.sites), ", "), h.httpConfig.DefaultSite)
+}
+
+// loadRules loads the rules HTTP requests are classified with: the built-in
+// packs and those in the rule directory, which replace built-in ones of the
+// same name
+func (h *Honeypot) loadRules() {
+	packs := classify.Builtin()
+	if dir := h.httpConfig.RuleDir; dir != "" {
+		extra, err := classify.LoadDir(dir)
+		if err != nil {
+			h.logChan <- fmt.Sprintf("[WARN] Cannot load HTTP rules from %s: %v (using the built-in rules)", dir, err)
+		}
+		for name, pack := range extra {
+			packs[name] = pack
+		}
+	}
+	h.classifier = classify.New(packs)
+	h.logChan <- fmt.Sprintf("[SYSTEM] HTTP rules: %d rules in %d packs", h.classifier.Len(), len(packs))
+}
+
+// loadDatabases loads the databases of the MySQL persona: the built-in ones
+// and those in the schema directory, which replace built-in databases of the
+// same name
+func (h *Honeypot) loadDatabases() {
+	dbs := mysql.Builtin()
+	if dir := h.mysqlConfig.SchemaDir; dir != "" {
+		extra, err := mysql.LoadDir(dir)
+		if err != nil {
+			h.logChan <- fmt.Sprintf("[WARN] Cannot load MySQL databases from %s: %v (using the built-in databases)", dir, err)
+		}
+		for name, db := range extra {
+			dbs[name] = db
+		}
+	}
+	h.mysqlSchema = mysql.NewSchema(dbs)
+	h.logChan <- fmt.Sprintf("[SYSTEM] MySQL databases: %s", strings.Join(h.mysqlSchema.Names(), ", "))
+}
+
+// loadHTTPCertificate loads the persistent TLS certificate of the HTTP
+// persona, falling back to a temporary one if the directory cannot be used
+func (h *Honeypot) loadHTTPCertificate() {
+	cert, err := loadCertificate(h.httpConfig.CertDir)
+	if err != nil {
+		h.logChan <- fmt.Sprintf("[WARN] Cannot use TLS certificate in %s: %v (using a temporary certificate)", h.httpConfig.CertDir, err)
+		if cert, err = loadCertificate(""); err != nil {
+			h.logChan <- fmt.Sprintf("[WARN] Cannot generate TLS certificate: %v (TLS is not offered)", err)
+			return
+		}
+	}
+	h.httpCert = cert
+}
+
+// siteFor returns the site served on port: the one that claims it, or the
+// default site
+func (h *Honeypot) siteFor(port int) *web.Site {
+	if site := web.ForPort(h.sites, port); site != nil {
+		return site
+	}
+	return h.sites[h.httpConfig.DefaultSite]
+}
+
 func (h *Honeypot) bindFallback() error {
+	if h.steeringMode == config.SteeringModeSkLookup {
+		return h.bindSteeredFallback()
+	}
+
 	h.logChan <- fmt.Sprintf("[SYSTEM] Attempting to bind honeypot fallback port %d...", config.HoneypotPort)
 	ln9999, err := net.Listen("tcp", fmt.Sprintf(":%d", config.HoneypotPort))
 	if err != nil {
@@ -68,16 +325,47 @@ func (h *Honeypot) bindFallback() error {
 		h.logChan <- fmt.Sprintf("[ERROR] To free port %d, run: sudo lsof -i :%d && sudo kill -9 <PID>", config.HoneypotPort, config.HoneypotPort)
 		h.logChan <- fmt.Sprintf("[ERROR] Or change HONEYPOT_PORT in internal/ebpf/programs/phantom.c and rebuild")
 		h.logChan <- "[ERROR] FAILING FAST: Cannot use alternative ports due to eBPF hardcoded redirect"
+		h.logChan <- "[ERROR] Or run the agent with -steering sklookup, which works with any free port"
 		return fmt.Errorf("failed to bind honeypot fallback port %d (required for XDP redirect): %w", config.HoneypotPort, err)
 	}
 
 	h.listeners = append(h.listeners, ln9999)
+	h.fallbackListener = ln9999
+	h.fallbackPort = config.HoneypotPort
 	h.logChan <- fmt.Sprintf("[SYSTEM] Honeypot listening on port %d (fallback for redirected ports)", config.HoneypotPort)
 	h.wg.Add(1)
 	go h.acceptLoop(ln9999, config.HoneypotPort)
 	return nil
 }
 
+// bindSteeredFallback binds the fallback listener for sk_lookup steering.
+// The kernel assigns connections to the socket directly, so any free port works.
+func (h *Honeypot) bindSteeredFallback() error {
+	candidates := append([]int{config.HoneypotPort}, config.FallbackPorts...)
+	candidates = append(candidates, 0) // Let the kernel pick as a last resort
+
+	var lastErr error
+	for _, port := range candidates {
+		ln, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+		if err != nil {
+			h.logChan <- fmt.Sprintf("[WARN] Cannot bind fallback port %d: %v", port, err)
+			lastErr = err
+			continue
+		}
+
+		boundPort := ln.Addr().(*net.TCPAddr).Port
+		h.listeners = append(h.listeners, ln)
+		h.fallbackListener = ln
+		h.fallbackPort = boundPort
+		h.logChan <- fmt.Sprintf("[SYSTEM] Honeypot listening on port %d (sk_lookup steering target)", boundPort)
+		h.wg.Add(1)
+		go h.acceptLoop(ln, boundPort)
+		return nil
+	}
+
+	return fmt.Errorf("failed to bind honeypot fallback listener: %w", lastErr)
+}
+
 func (h *Honeypot) acceptLoop(ln net.Listener, port int) {
 	defer h.wg.Done()
 	for {
@@ -96,6 +384,12 @@ func (h *Honeypot) acceptLoop(ln net.Listener, port int) {
 
 func (h *Honeypot) handleConnection(conn net.Conn, originalPort int) {
 	defer conn.Close()
+	// A bug in one persona ends that connection, not the agent
+	defer func() {
+		if r := recover(); r != nil {
+			h.logChan <- fmt.Sprintf("[WARN] Honeypot handler for %s panicked: %v\n%s", conn.RemoteAddr(), r, debug.Stack())
+		}
+	}()
 	remoteAddr := conn.RemoteAddr()
 	if remoteAddr == nil {
 		return
@@ -105,26 +399,91 @@ func (h *Honeypot) handleConnection(conn net.Conn, originalPort int) {
 	ip := extractIP(remote)
 	t := time.Now().Format("15:04:05")
 
+	targetPort, redirected := h.resolveTargetPort(conn, originalPort)
+	if redirected && h.isTarpitPort(targetPort) {
+		h.handleTarpit(conn, ip, targetPort, t)
+		return
+	}
+
 	var serviceType string
-	if originalPort == config.HoneypotPort {
+	if targetPort == h.fallbackPort {
 		serviceType = mirage.SelectRandomService()
 	} else {
-		serviceType = mirage.SelectServiceByPort(originalPort)
+		serviceType = mirage.SelectServiceByPort(targetPort)
 	}
 	banner := mirage.GetRandomBanner(serviceType)
 
-	h.logChan <- fmt.Sprintf("[%s] TRAP HIT! IP: %s | Port: %d | Service: %s", t, ip, originalPort, strings.ToUpper(serviceType))
-	logger.LogAttack(ip, fmt.Sprintf("TRAP_HIT_PORT_%d", originalPort))
+	if redirected {
+		h.logChan <- fmt.Sprintf("[%s] TRAP HIT! IP: %s | Port: %d (redirected) | Service: %s", t, ip, targetPort, strings.ToUpper(serviceType))
+	} else {
+		h.logChan <- fmt.Sprintf("[%s] TRAP HIT! IP: %s | Port: %d | Service: %s", t, ip, targetPort, strings.ToUpper(serviceType))
+	}
+	logger.LogAttack(ip, fmt.Sprintf("TRAP_HIT_PORT_%d", targetPort))
 
-	if _, err := conn.Write([]byte(banner)); err != nil {
+	handler := NewHandler(h.logChan)
+	handler.login = h.login
+	handler.fs = h.fsConfig
+	handler.image = h.image
+	handler.fetcher = h.fetcher
+	handler.recording = h.recording
+	handler.port = targetPort
+	handler.events = h.events
+	if serviceType == "ssh" {
+		// The SSH server sends the banner as its version line
+		handler.sshHostKeys = h.sshHostKeys
+		handler.sshVersion = strings.TrimSpace(banner)
+	} else if serviceType == "telnet" {
+		// Option negotiation has to come first
+		handler.telnetBanner = banner
+	} else if serviceType == "http" {
+		// The site sends its own headers, after the client's request
+		handler.http = h.httpConfig
+		handler.site = h.siteFor(targetPort)
+		handler.httpCert = h.httpCert
+		handler.classifier = h.classifier
+	} else if serviceType == "mysql" {
+		// The handshake carries the version along with the scramble
+		handler.mysqlVersion = mysqlVersion(banner)
+		handler.mysqlSchema = h.mysqlSchema
+		handler.mysqlConfig = h.mysqlConfig
+	} else if _, err := conn.Write([]byte(banner)); err != nil {
 		h.logChan <- fmt.Sprintf("[%s] Error sending banner to %s: %v", t, ip, err)
 		return
 	}
-
-	handler := NewHandler(h.logChan)
 	handler.Handle(conn, remote, serviceType, t)
 }
 
+// resolveTargetPort returns the port the attacker actually targeted.
+// Connections steered by sk_lookup keep their original local port. Connections
+// redirected by XDP have it rewritten, so the original port is looked up in
+// the kernel flow map. If the lookup fails the fallback port is returned and
+// a random persona is used.
+func (h *Honeypot) resolveTargetPort(conn net.Conn, listenPort int) (int, bool) {
+	if listenPort != h.fallbackPort {
+		return listenPort, false
+	}
+
+	if h.steeringMode == config.SteeringModeSkLookup {
+		if local, ok := conn.LocalAddr().(*net.TCPAddr); ok && local.Port != listenPort {
+			return local.Port, true
+		}
+	}
+
+	if h.portResolver == nil {
+		return listenPort, false
+	}
+
+	remote, ok := conn.RemoteAddr().(*net.TCPAddr)
+	if !ok {
+		return listenPort, false
+	}
+
+	if port, ok := h.portResolver.OriginalPort(remote); ok {
+		return port, true
+	}
+	return listenPort, false
+}
+
 func extractIP(remote string) string {
 	if strings.HasPrefix(remote, "[") {
 		endBracket := strings.Index(remote, "]")
@@ -143,6 +502,11 @@ func (h *Honeypot) Close() error {
 			return err
 		}
 	}
+	for _, conn := range h.packetConns {
+		if err := conn.Close(); err != nil {
+			return err
+		}
+	}
 	h.wg.Wait()
 	return nil
 }
@@ -0,0 +1,114 @@
+package honeypot
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"phantom-grid/internal/config"
+)
+
+type staticResolver struct {
+	port int
+	ok   bool
+}
+
+func (r staticResolver) OriginalPort(remote *net.TCPAddr) (int, bool) {
+	return r.port, r.ok
+}
+
+// dialLoopback returns the server side of a loopback TCP connection
+func dialLoopback(t *testing.T) net.Conn {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer ln.Close()
+
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	server, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("Failed to accept: %v", err)
+	}
+	t.Cleanup(func() { server.Close() })
+	return server
+}
+
+func TestResolveTargetPort(t *testing.T) {
+	conn := dialLoopback(t)
+
+	localPort := conn.LocalAddr().(*net.TCPAddr).Port
+
+	tests := []struct {
+		name           string
+		mode           config.SteeringMode
+		resolver       PortResolver
+		listenPort     int
+		wantPort       int
+		wantRedirected bool
+	}{
+		{"direct fake port", config.SteeringModeRedirect, staticResolver{5985, true}, 3306, 3306, false},
+		{"fallback without resolver", config.SteeringModeRedirect, nil, config.HoneypotPort, config.HoneypotPort, false},
+		{"fallback resolved", config.SteeringModeRedirect, staticResolver{5985, true}, config.HoneypotPort, 5985, true},
+		{"fallback unresolved", config.SteeringModeRedirect, staticResolver{0, false}, config.HoneypotPort, config.HoneypotPort, false},
+		{"sk_lookup keeps local port", config.SteeringModeSkLookup, nil, config.HoneypotPort, localPort, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := New(make(chan string, 10))
+			h.SetSteeringMode(tt.mode)
+			if tt.resolver != nil {
+				h.SetPortResolver(tt.resolver)
+			}
+
+			port, redirected := h.resolveTargetPort(conn, tt.listenPort)
+			if port != tt.wantPort || redirected != tt.wantRedirected {
+				t.Errorf("resolveTargetPort() = (%d, %v), want (%d, %v)", port, redirected, tt.wantPort, tt.wantRedirected)
+			}
+		})
+	}
+}
+
+func TestLoadImage(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "rootfs", "etc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "rootfs", "etc", "hostname"), []byte("web01\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	logChan := make(chan string, 10)
+	h := New(logChan)
+	h.SetFileSystem(config.FileSystemConfiguration{Image: dir})
+	h.loadImage()
+	handler := NewHandler(logChan)
+	handler.image = h.image
+	if data, err := handler.sessionFS().ReadFile("/etc/hostname"); err != nil || string(data) != "web01\n" {
+		t.Errorf("/etc/hostname = %q, %v; want the image's", data, err)
+	}
+
+	// An image that cannot be loaded leaves the built-in one in use
+	h = New(logChan)
+	h.SetFileSystem(config.FileSystemConfiguration{Image: filepath.Join(dir, "missing.tar")})
+	h.loadImage()
+	if h.image != nil {
+		t.Error("a missing image was loaded")
+	}
+	if msg := <-logChan; !strings.Contains(msg, "[SYSTEM]") {
+		t.Errorf("first log = %q, want the image to be loaded", msg)
+	}
+	if msg := <-logChan; !strings.Contains(msg, "[WARN] Cannot load file system image") {
+		t.Errorf("second log = %q, want a warning", msg)
+	}
+}
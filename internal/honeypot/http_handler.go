@@ -1,272 +1,381 @@
 package honeypot
 
 import (
+	"bufio"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
 	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"mime"
+	"mime/multipart"
 	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
 	"strings"
+	"sync"
 	"time"
+	"unicode/utf8"
 
+	"phantom-grid/internal/config"
+	"phantom-grid/internal/honeypot/classify"
+	"phantom-grid/internal/honeypot/web"
 	"phantom-grid/internal/logger"
 )
 
-// handleHTTP simulates professional HTTP server interaction
+// httpTimeout bounds reading a request and keeping an idle connection open
+const httpTimeout = 60 * time.Second
+
+// httpCertHostname is the name in the generated certificate, the host name
+// the fake shell shows
+const httpCertHostname = "server"
+
+// tlsRecordHandshake starts the ClientHello of a TLS client
+const tlsRecordHandshake = 0x16
+
+// loadCertificate returns the TLS certificate stored in dir, generating a
+// self-signed one if there is none. With an empty dir, a temporary
+// certificate is generated and not stored.
+func loadCertificate(dir string) (*tls.Certificate, error) {
+	if dir == "" {
+		certPEM, keyPEM, err := generateCertificate()
+		if err != nil {
+			return nil, err
+		}
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		return &cert, err
+	}
+
+	certPath, keyPath := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err == nil {
+		return &cert, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create certificate directory: %w", err)
+	}
+	certPEM, keyPEM, err := generateCertificate()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(keyPath, keyPEM, 0o600); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(certPath, certPEM, 0o644); err != nil {
+		return nil, err
+	}
+	cert, err = tls.X509KeyPair(certPEM, keyPEM)
+	return &cert, err
+}
+
+// generateCertificate makes a self-signed certificate like the one a
+// distribution installs for a fresh web server
+func generateCertificate() (certPEM, keyPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 127))
+	if err != nil {
+		return nil, nil, err
+	}
+	// Issued some time ago, as if at installation
+	notBefore := time.Now().Add(-time.Duration(30+serial.Int64()%300) * 24 * time.Hour).Truncate(time.Second)
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: httpCertHostname},
+		DNSNames:              []string{httpCertHostname},
+		NotBefore:             notBefore,
+		NotAfter:              notBefore.AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM, nil
+}
+
+// httpSite returns the site the HTTP persona serves on this connection
+func (h *Handler) httpSite() *web.Site {
+	if h.site != nil {
+		return h.site
+	}
+	sites := web.Builtin()
+	if site := sites[h.http.DefaultSite]; site != nil {
+		return site
+	}
+	return sites[config.DefaultHTTPConfig().DefaultSite]
+}
+
+// handleHTTP serves a site on conn with a real HTTP server, so keep-alive,
+// pipelining and chunked bodies work as clients expect. Connections that
+// start with a TLS handshake are served over TLS, whatever the port.
 func (h *Handler) handleHTTP(conn net.Conn, remote, t string) {
-	buf := make([]byte, 8192)
-	n, err := conn.Read(buf)
+	ip := extractIP(remote)
+	site := h.httpSite()
+
+	// Clients speak first, so the first byte tells TLS from plain HTTP
+	br := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(httpTimeout))
+	first, err := br.Peek(1)
 	if err != nil {
 		return
 	}
+	conn.SetReadDeadline(time.Time{})
 
-	request := string(buf[:n])
-	ip := strings.Split(remote, ":")[0]
+	hc := &httpConn{Conn: conn, r: br, closed: make(chan struct{})}
+	var served net.Conn = hc
+	if first[0] == tlsRecordHandshake && h.httpCert != nil {
+		served = tls.Server(hc, &tls.Config{Certificates: []tls.Certificate{*h.httpCert}})
+	}
 
-	lines := strings.Split(request, "\r\n")
-	if len(lines) == 0 {
-		return
+	srv := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h.serveHTTP(w, r, site, ip, t)
+		}),
+		ReadHeaderTimeout: httpTimeout,
+		ReadTimeout:       httpTimeout,
+		IdleTimeout:       httpTimeout,
+		MaxHeaderBytes:    64 << 10,
+		// Handshake failures and malformed requests are what scanners send
+		ErrorLog: log.New(io.Discard, "", 0),
 	}
+	srv.Serve(&connListener{conn: served, done: hc.closed})
+}
 
-	requestLine := lines[0]
-	h.logChan <- fmt.Sprintf("[%s] HTTP REQUEST: %s", t, requestLine)
-	logger.LogAttack(ip, fmt.Sprintf("HTTP: %s", requestLine))
+// httpUpload is a file a request uploaded
+type httpUpload struct {
+	Field       string `json:"field,omitempty"`
+	Name        string `json:"name"`
+	Size        int    `json:"size"`
+	SHA256      string `json:"sha256"`
+	ContentType string `json:"content_type,omitempty"`
+	Truncated   bool   `json:"truncated,omitempty"`
+	data        []byte
+}
 
-	parts := strings.Fields(requestLine)
-	if len(parts) < 2 {
-		return
+// serveHTTP captures a request, then lets the site answer it. The request,
+// its headers, body and uploaded files go into a structured event.
+func (h *Handler) serveHTTP(w http.ResponseWriter, r *http.Request, site *web.Site, ip, t string) {
+	// The body is read further than it is captured, so uploaded files are
+	// kept whole
+	data, cut := readBody(r.Body, max(h.http.MaxUploadBytes, h.http.MaxBodyBytes))
+	body := data[:min(int64(len(data)), h.http.MaxBodyBytes)]
+	truncated := cut || len(body) < len(data)
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	var uploads []httpUpload
+	mediaType, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		uploads = parseMultipart(r, data, params["boundary"])
+	} else {
+		r.ParseForm()
+	}
+	// PUT is how webshells are dropped on servers that allow it
+	if r.Method == http.MethodPut && len(data) > 0 {
+		uploads = append(uploads, httpUpload{Name: path.Base(r.URL.Path), Size: len(data), SHA256: sha256Hex(data), ContentType: r.Header.Get("Content-Type"), Truncated: cut, data: data})
+	}
+
+	userAgent := r.UserAgent()
+	if userAgent == "" {
+		userAgent = "none"
+	}
+	h.logChan <- fmt.Sprintf("[%s] HTTP REQUEST: %s | %s %s | Site: %s | User-Agent: %s", t, ip, r.Method, r.RequestURI, site.Name, userAgent)
+	logger.LogAttack(ip, fmt.Sprintf("HTTP: %s %s %s", r.Method, r.RequestURI, r.Proto))
+
+	event := logger.NewSecurityEvent(logger.EventTypeHTTPRequest, fmt.Sprintf("%s %s", r.Method, r.RequestURI)).
+		WithSourceIP(ip).
+		WithService("http").
+		WithRiskLevel("LOW").
+		WithMetadata("site", site.Name).
+		WithMetadata("method", r.Method).
+		WithMetadata("uri", r.RequestURI).
+		WithMetadata("proto", r.Proto).
+		WithMetadata("host", r.Host).
+		WithMetadata("tls", r.TLS != nil).
+		WithMetadata("headers", flattenHeader(r.Header)).
+		WithMetadata("body_size", len(body)).
+		WithMetadata("body_truncated", truncated)
+	if h.port > 0 {
+		event.WithPort(h.port)
+	}
+	if len(body) > 0 && mediaType != "multipart/form-data" {
+		if utf8.Valid(body) {
+			event.WithMetadata("body", string(body))
+		} else {
+			event.WithMetadata("body_base64", base64.StdEncoding.EncodeToString(body))
+		}
+	}
+	if r.MultipartForm != nil && len(r.MultipartForm.Value) > 0 {
+		event.WithMetadata("form", r.MultipartForm.Value)
 	}
 
-	method := parts[0]
-	path := parts[1]
-	path = strings.Split(path, "?")[0] // Remove query string
+	if user, password, ok := site.Credentials(r, body); ok {
+		h.logChan <- fmt.Sprintf("[%s] HTTP LOGIN: %s | Site: %s | User: %s | Password: %q", t, ip, site.Name, user, password)
+		logger.LogAttack(ip, fmt.Sprintf("HTTP_LOGIN: site=%s, user=%s, pass=%s", site.Name, user, password))
+		event.WithMetadata("user", user).WithMetadata("password", password).WithRiskLevel("MEDIUM")
+	}
 
-	// Extract User-Agent
-	userAgent := "Unknown"
-	for _, line := range lines {
-		if strings.HasPrefix(strings.ToLower(line), "user-agent:") {
-			userAgent = strings.TrimSpace(strings.TrimPrefix(line, "User-Agent:"))
-			break
+	if len(uploads) > 0 {
+		for _, u := range uploads {
+			h.logChan <- fmt.Sprintf("[%s] HTTP UPLOAD: %s | File: %s | Size: %d | SHA256: %s | Truncated: %t", t, ip, u.Name, u.Size, u.SHA256, u.Truncated)
+			logger.LogAttack(ip, fmt.Sprintf("HTTP_UPLOAD: name=%s, size=%d, sha256=%s, truncated=%t", u.Name, u.Size, u.SHA256, u.Truncated))
+			if h.fs.ArtifactDir == "" {
+				continue
+			}
+			seen := sighting{Time: time.Now().UTC(), IP: ip, Service: "HTTP", Path: u.Name, URL: r.RequestURI, ContentType: u.ContentType, Truncated: u.Truncated}
+			if err := storeArtifact(h.fs.ArtifactDir, u.SHA256, u.data, seen); err != nil {
+				h.logChan <- fmt.Sprintf("[WARN] Cannot store artifact %s: %v", u.SHA256, err)
+			}
 		}
+		event.WithMetadata("files", uploads).WithRiskLevel("HIGH")
 	}
 
-	// Extract credentials from POST
-	if method == "POST" {
-		bodyStart := strings.Index(request, "\r\n\r\n")
-		if bodyStart > 0 {
-			body := request[bodyStart+4:]
-			if strings.Contains(body, "password") || strings.Contains(body, "pass") {
-				h.logChan <- fmt.Sprintf("[%s] HTTP POST with credentials detected! User-Agent: %s", t, userAgent)
-				logger.LogAttack(ip, fmt.Sprintf("HTTP_POST_CREDENTIALS: %s", body))
+	if h.classifier != nil {
+		if res := h.classifier.Classify(r, body); res.Matched() {
+			cves := strings.Join(res.CVEs, ", ")
+			if cves == "" {
+				cves = "none"
 			}
+			h.logChan <- fmt.Sprintf("[%s] HTTP ATTACK: %s | %s %s | Categories: %s | CVEs: %s | Rules: %s", t, ip, r.Method, r.RequestURI, strings.Join(res.Categories, ", "), cves, strings.Join(res.Rules, ", "))
+			logger.LogAttack(ip, fmt.Sprintf("HTTP_ATTACK: categories=%s, cves=%s, rules=%s", strings.Join(res.Categories, ","), strings.Join(res.CVEs, ","), strings.Join(res.Rules, ",")))
+			event.WithMetadata("categories", res.Categories).
+				WithMetadata("cves", res.CVEs).
+				WithMetadata("rules", res.Rules).
+				WithRiskLevel(classify.MaxRisk(event.RiskLevel, res.Risk))
 		}
 	}
 
-	var response string
-	time.Sleep(time.Duration(50+len(path)*2) * time.Millisecond) // Realistic delay
-
-	switch path {
-	case "/", "/index.html", "/index.php":
-		response = "HTTP/1.1 200 OK\r\n"
-		response += "Server: nginx/1.18.0 (Ubuntu)\r\n"
-		response += "Content-Type: text/html; charset=UTF-8\r\n"
-		response += "Connection: keep-alive\r\n"
-		response += "X-Powered-By: PHP/7.4.3\r\n"
-		response += "\r\n"
-		response += `<!DOCTYPE html>
-<html lang="en">
-<head>
-    <meta charset="UTF-8">
-    <meta name="viewport" content="width=device-width, initial-scale=1.0">
-    <title>Welcome - Server Management</title>
-    <style>
-        body { font-family: Arial, sans-serif; margin: 40px; background: #f5f5f5; }
-        .container { background: white; padding: 30px; border-radius: 8px; box-shadow: 0 2px 4px rgba(0,0,0,0.1); }
-        h1 { color: #333; }
-        .nav { margin: 20px 0; }
-        .nav a { margin-right: 15px; color: #0066cc; text-decoration: none; }
-        .nav a:hover { text-decoration: underline; }
-    </style>
-</head>
-<body>
-    <div class="container">
-        <h1>Welcome to Server Management Portal</h1>
-        <p>System is running normally. All services are operational.</p>
-        <div class="nav">
-            <a href="/admin">Admin Panel</a>
-            <a href="/login">Login</a>
-            <a href="/dashboard">Dashboard</a>
-            <a href="/api">API</a>
-        </div>
-        <p>Server Time: ` + time.Now().Format("2006-01-02 15:04:05") + `</p>
-    </div>
-</body>
-</html>`
-
-	case "/admin", "/admin.php", "/admin.html", "/administrator", "/wp-admin":
-		response = "HTTP/1.1 200 OK\r\n"
-		response += "Server: nginx/1.18.0 (Ubuntu)\r\n"
-		response += "Content-Type: text/html; charset=UTF-8\r\n"
-		response += "Set-Cookie: session_id=abc123xyz; Path=/; HttpOnly\r\n"
-		response += "\r\n"
-		response += `<!DOCTYPE html>
-<html>
-<head>
-    <title>Administration Panel</title>
-    <style>
-        body { font-family: Arial, sans-serif; margin: 40px; background: #2c3e50; color: white; }
-        .login-box { background: #34495e; padding: 30px; border-radius: 8px; max-width: 400px; margin: 100px auto; }
-        input { width: 100%; padding: 10px; margin: 10px 0; border: none; border-radius: 4px; }
-        button { width: 100%; padding: 12px; background: #3498db; color: white; border: none; border-radius: 4px; cursor: pointer; }
-        button:hover { background: #2980b9; }
-    </style>
-</head>
-<body>
-    <div class="login-box">
-        <h2>Administration Panel</h2>
-        <form method="POST" action="/admin/login">
-            <input type="text" name="username" placeholder="Username" required>
-            <input type="password" name="password" placeholder="Password" required>
-            <button type="submit">Login</button>
-        </form>
-        <p style="font-size: 12px; color: #95a5a6;">Forgot password? <a href="/admin/reset" style="color: #3498db;">Reset here</a></p>
-    </div>
-</body>
-</html>`
-
-	case "/login", "/login.php", "/signin":
-		response = "HTTP/1.1 200 OK\r\n"
-		response += "Server: Apache/2.4.41 (Debian)\r\n"
-		response += "Content-Type: text/html; charset=UTF-8\r\n"
-		response += "\r\n"
-		response += `<!DOCTYPE html>
-<html>
-<head>
-    <title>User Login</title>
-    <style>
-        body { font-family: Arial, sans-serif; margin: 40px; background: #ecf0f1; }
-        .form-container { background: white; padding: 30px; border-radius: 8px; max-width: 350px; margin: 50px auto; box-shadow: 0 2px 4px rgba(0,0,0,0.1); }
-        input { width: 100%; padding: 10px; margin: 10px 0; border: 1px solid #ddd; border-radius: 4px; box-sizing: border-box; }
-        button { width: 100%; padding: 12px; background: #27ae60; color: white; border: none; border-radius: 4px; cursor: pointer; }
-    </style>
-</head>
-<body>
-    <div class="form-container">
-        <h2>User Login</h2>
-        <form method="POST" action="/login/check">
-            <input type="text" name="user" placeholder="Username" required>
-            <input type="password" name="pass" placeholder="Password" required>
-            <button type="submit">Sign In</button>
-        </form>
-    </div>
-</body>
-</html>`
-
-	case "/api", "/api/v1", "/api/v1/users", "/api/users":
-		response = "HTTP/1.1 200 OK\r\n"
-		response += "Content-Type: application/json\r\n"
-		response += "Access-Control-Allow-Origin: *\r\n"
-		response += "\r\n"
-		response += `{"status":"ok","data":[{"id":1,"username":"admin","email":"admin@server.com","role":"administrator"},{"id":2,"username":"user","email":"user@server.com","role":"user"}],"timestamp":"` + time.Now().Format(time.RFC3339) + `"}`
-
-	case "/api/v1/config", "/api/config":
-		response = "HTTP/1.1 200 OK\r\n"
-		response += "Content-Type: application/json\r\n"
-		response += "\r\n"
-		response += `{"database":{"host":"localhost","port":3306,"name":"production"},"api_key":"sk_live_51H3ll0W0rld","version":"1.2.3"}`
-
-	case "/robots.txt":
-		response = "HTTP/1.1 200 OK\r\n"
-		response += "Content-Type: text/plain\r\n"
-		response += "\r\n"
-		response += "User-agent: *\nDisallow: /admin/\nDisallow: /private/\nDisallow: /config/\nAllow: /public/"
-
-	case "/.git/config", "/.git/HEAD", "/.git":
-		response = "HTTP/1.1 403 Forbidden\r\n"
-		response += "Server: nginx/1.18.0 (Ubuntu)\r\n"
-		response += "Content-Type: text/html\r\n"
-		response += "\r\n"
-		response += "<h1>403 Forbidden</h1><p>You don't have permission to access this resource.</p>"
-
-	case "/phpinfo.php", "/info.php":
-		response = "HTTP/1.1 200 OK\r\n"
-		response += "Server: Apache/2.4.41 (Debian)\r\n"
-		response += "Content-Type: text/html\r\n"
-		response += "\r\n"
-		response += `<html><head><title>phpinfo()</title></head><body>
-<h1>PHP Version 7.4.3</h1>
-<p>System: Linux server 5.4.0-74-generic</p>
-<p>Server API: Apache 2.0 Handler</p>
-<p>Document Root: /var/www/html</p>
-</body></html>`
-
-	case "/wp-login.php", "/wordpress/wp-admin":
-		response = "HTTP/1.1 200 OK\r\n"
-		response += "Server: nginx/1.18.0 (Ubuntu)\r\n"
-		response += "Content-Type: text/html; charset=UTF-8\r\n"
-		response += "\r\n"
-		response += `<!DOCTYPE html>
-<html>
-<head>
-    <title>WordPress Login</title>
-    <style>
-        body { font-family: Arial, sans-serif; margin: 40px; background: #f0f0f1; }
-        .login { background: white; padding: 30px; border-radius: 4px; max-width: 320px; margin: 50px auto; }
-        input { width: 100%; padding: 10px; margin: 10px 0; border: 1px solid #ddd; }
-        button { width: 100%; padding: 12px; background: #0073aa; color: white; border: none; }
-    </style>
-</head>
-<body>
-    <div class="login">
-        <h1>WordPress</h1>
-        <form method="POST">
-            <input type="text" name="log" placeholder="Username or Email">
-            <input type="password" name="pwd" placeholder="Password">
-            <button type="submit">Log In</button>
-        </form>
-    </div>
-</body>
-</html>`
-
-	case "/dashboard", "/panel":
-		response = "HTTP/1.1 302 Found\r\n"
-		response += "Location: /login\r\n"
-		response += "\r\n"
-
-	default:
-		if method == "POST" {
-			h.logChan <- fmt.Sprintf("[%s] HTTP POST to %s - credentials may be present", t, path)
-			response = "HTTP/1.1 302 Found\r\n"
-			response += "Location: /admin/dashboard\r\n"
-			response += "Set-Cookie: auth_token=invalid; Path=/\r\n"
-			response += "\r\n"
-		} else if strings.Contains(path, ".php") || strings.Contains(path, ".jsp") || strings.Contains(path, ".asp") {
-			response = "HTTP/1.1 200 OK\r\n"
-			response += "Server: Apache/2.4.41 (Debian)\r\n"
-			response += "Content-Type: text/html\r\n"
-			response += "\r\n"
-			response += "<html><body><h1>Page Not Found</h1><p>The requested page could not be found.</p></body></html>"
-		} else {
-			response = "HTTP/1.1 404 Not Found\r\n"
-			response += "Server: nginx/1.18.0 (Ubuntu)\r\n"
-			response += "Content-Type: text/html; charset=UTF-8\r\n"
-			response += "\r\n"
-			response += `<!DOCTYPE html>
-<html>
-<head>
-    <title>404 Not Found</title>
-    <style>
-        body { font-family: Arial, sans-serif; margin: 40px; text-align: center; }
-        h1 { color: #e74c3c; }
-    </style>
-</head>
-<body>
-    <h1>404 Not Found</h1>
-    <p>The requested URL ` + path + ` was not found on this server.</p>
-    <p><a href="/">Return to homepage</a></p>
-</body>
-</html>`
+	if h.events != nil {
+		h.events(event)
+	}
+	site.ServeHTTP(w, r)
+}
+
+// readBody reads up to limit bytes of body, reporting whether there was more
+func readBody(body io.Reader, limit int64) ([]byte, bool) {
+	data, _ := io.ReadAll(io.LimitReader(body, limit+1))
+	if int64(len(data)) > limit {
+		return data[:limit], true
+	}
+	return data, false
+}
+
+// parseMultipart parses the multipart body data of r, which may have been
+// cut short, and returns the files it uploads. Complete fields are kept, and
+// a file cut short is kept marked as truncated. The fields become the form
+// of r, as ParseMultipartForm would make it.
+func parseMultipart(r *http.Request, data []byte, boundary string) []httpUpload {
+	var uploads []httpUpload
+	values := make(url.Values)
+	mr := multipart.NewReader(bytes.NewReader(data), boundary)
+	for {
+		part, err := mr.NextPart()
+		if err != nil {
+			break
 		}
+		content, err := io.ReadAll(part)
+		if part.FileName() == "" {
+			if err == nil && part.FormName() != "" {
+				values.Add(part.FormName(), string(content))
+			}
+		} else if len(content) > 0 {
+			uploads = append(uploads, httpUpload{
+				Field:       part.FormName(),
+				Name:        part.FileName(),
+				Size:        len(content),
+				SHA256:      sha256Hex(content),
+				ContentType: part.Header.Get("Content-Type"),
+				Truncated:   err != nil,
+				data:        content,
+			})
+		}
+		if err != nil {
+			break
+		}
+	}
+	r.MultipartForm = &multipart.Form{Value: values}
+	r.PostForm = values
+	r.ParseForm()
+	return uploads
+}
+
+// flattenHeader joins repeated header fields, as they would appear on the wire
+func flattenHeader(header http.Header) map[string]string {
+	flat := make(map[string]string, len(header))
+	for k, v := range header {
+		flat[k] = strings.Join(v, ", ")
 	}
+	return flat
+}
 
-	conn.Write([]byte(response))
-	time.Sleep(100 * time.Millisecond)
+// httpConn is a connection an HTTP server serves. It returns the bytes
+// peeked at first, and tells when the server closes it.
+type httpConn struct {
+	net.Conn
+	r      *bufio.Reader
+	once   sync.Once
+	closed chan struct{}
 }
 
+func (c *httpConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+func (c *httpConn) Close() error {
+	c.once.Do(func() { close(c.closed) })
+	return c.Conn.Close()
+}
+
+// connListener hands one connection to an HTTP server, then blocks until
+// done is closed, so that Serve returns when the connection ends
+type connListener struct {
+	mu       sync.Mutex
+	conn     net.Conn
+	accepted bool
+	done     <-chan struct{}
+}
+
+func (l *connListener) Accept() (net.Conn, error) {
+	l.mu.Lock()
+	if !l.accepted {
+		l.accepted = true
+		l.mu.Unlock()
+		return l.conn, nil
+	}
+	l.mu.Unlock()
+	<-l.done
+	return nil, net.ErrClosed
+}
+
+func (l *connListener) Close() error {
+	return nil
+}
+
+func (l *connListener) Addr() net.Addr {
+	return l.conn.LocalAddr()
+}
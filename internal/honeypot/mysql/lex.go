@@ -0,0 +1,214 @@
+package mysql
+
+import (
+	"encoding/hex"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokQuotedIdent // `name`
+	tokString
+	tokNumber
+	tokHex     // 0x41 or x'41', decoded
+	tokSysVar  // @@name, without the @@
+	tokUserVar // @name, without the @
+	tokOp
+)
+
+// token is a token of a statement, with its position in it
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+// is reports whether t is the keyword kw, given in upper case
+func (t token) is(kw string) bool {
+	return t.kind == tokIdent && strings.EqualFold(t.text, kw)
+}
+
+// isOp reports whether t is the operator or punctuation op
+func (t token) isOp(op string) bool {
+	return t.kind == tokOp && t.text == op
+}
+
+// operators lists multi-character operators before their prefixes
+var operators = []string{"<=>", "<=", ">=", "<>", "!=", "||", "&&", ":=", "<<", ">>",
+	"(", ")", ",", ".", ";", "*", "=", "<", ">", "+", "-", "/", "%", "!", "~", "&", "|", "^"}
+
+// lex splits q into tokens. Comments are skipped, except the text of
+// executable comments (/*! ... */), which the server runs. pos is the
+// offset of the first character that could not be read on error.
+func lex(q string) ([]token, int, bool) {
+	var tokens []token
+	executable := false
+	i := 0
+	for i < len(q) {
+		c := q[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v':
+			i++
+		case c == '#' || strings.HasPrefix(q[i:], "--") && (i+2 == len(q) || strings.IndexByte(" \t\r\n", q[i+2]) >= 0):
+			if j := strings.IndexByte(q[i:], '\n'); j >= 0 {
+				i += j + 1
+			} else {
+				i = len(q)
+			}
+		case strings.HasPrefix(q[i:], "/*!"):
+			i += 3
+			for i < len(q) && q[i] >= '0' && q[i] <= '9' {
+				i++
+			}
+			executable = true
+		case strings.HasPrefix(q[i:], "*/") && executable:
+			i += 2
+			executable = false
+		case strings.HasPrefix(q[i:], "/*"):
+			j := strings.Index(q[i+2:], "*/")
+			if j < 0 {
+				return nil, i, false
+			}
+			i += j + 4
+		case c == '\'' || c == '"':
+			s, n, ok := lexString(q[i:])
+			if !ok {
+				return nil, i, false
+			}
+			tokens = append(tokens, token{kind: tokString, text: s, pos: i})
+			i += n
+		case c == '`':
+			j := strings.IndexByte(q[i+1:], '`')
+			if j < 0 {
+				return nil, i, false
+			}
+			tokens = append(tokens, token{kind: tokQuotedIdent, text: q[i+1 : i+1+j], pos: i})
+			i += j + 2
+		case (c == 'x' || c == 'X') && i+1 < len(q) && q[i+1] == '\'':
+			j := strings.IndexByte(q[i+2:], '\'')
+			if j < 0 {
+				return nil, i, false
+			}
+			b, err := hex.DecodeString(q[i+2 : i+2+j])
+			if err != nil {
+				return nil, i, false
+			}
+			tokens = append(tokens, token{kind: tokHex, text: string(b), pos: i})
+			i += j + 3
+		case c == '0' && i+1 < len(q) && (q[i+1] == 'x' || q[i+1] == 'X') && i+2 < len(q) && isHexDigit(q[i+2]):
+			j := i + 2
+			for j < len(q) && isHexDigit(q[j]) {
+				j++
+			}
+			digits := q[i+2 : j]
+			if len(digits)%2 == 1 {
+				digits = "0" + digits
+			}
+			b, _ := hex.DecodeString(digits)
+			tokens = append(tokens, token{kind: tokHex, text: string(b), pos: i})
+			i = j
+		case c >= '0' && c <= '9' || c == '.' && i+1 < len(q) && q[i+1] >= '0' && q[i+1] <= '9':
+			j := i
+			for j < len(q) && (q[j] >= '0' && q[j] <= '9' || q[j] == '.') {
+				j++
+			}
+			if j < len(q) && (q[j] == 'e' || q[j] == 'E') {
+				k := j + 1
+				if k < len(q) && (q[k] == '+' || q[k] == '-') {
+					k++
+				}
+				if k < len(q) && q[k] >= '0' && q[k] <= '9' {
+					for j = k; j < len(q) && q[j] >= '0' && q[j] <= '9'; j++ {
+					}
+				}
+			}
+			tokens = append(tokens, token{kind: tokNumber, text: q[i:j], pos: i})
+			i = j
+		case c == '@':
+			kind, j := tokUserVar, i+1
+			if strings.HasPrefix(q[i:], "@@") {
+				kind, j = tokSysVar, i+2
+			}
+			start := j
+			for j < len(q) && (isIdentChar(q[j]) || q[j] == '.' && kind == tokSysVar) {
+				j++
+			}
+			tokens = append(tokens, token{kind: kind, text: strings.ToLower(q[start:j]), pos: i})
+			i = j
+		case isIdentChar(c):
+			j := i
+			for j < len(q) && isIdentChar(q[j]) {
+				j++
+			}
+			tokens = append(tokens, token{kind: tokIdent, text: q[i:j], pos: i})
+			i = j
+		default:
+			op := ""
+			for _, o := range operators {
+				if strings.HasPrefix(q[i:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				return nil, i, false
+			}
+			tokens = append(tokens, token{kind: tokOp, text: op, pos: i})
+			i += len(op)
+		}
+	}
+	return append(tokens, token{kind: tokEOF, pos: len(q)}), 0, true
+}
+
+// lexString reads the quoted string at the start of q, returning its value
+// and length
+func lexString(q string) (string, int, bool) {
+	quote := q[0]
+	var b strings.Builder
+	for i := 1; i < len(q); i++ {
+		c := q[i]
+		switch {
+		case c == quote && i+1 < len(q) && q[i+1] == quote:
+			b.WriteByte(quote)
+			i++
+		case c == quote:
+			return b.String(), i + 1, true
+		case c == '\\' && i+1 < len(q):
+			i++
+			switch q[i] {
+			case '0':
+				b.WriteByte(0)
+			case 'b':
+				b.WriteByte('\b')
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case 'Z':
+				b.WriteByte(0x1a)
+			case '%', '_':
+				// Kept escaped for LIKE
+				b.WriteByte('\\')
+				b.WriteByte(q[i])
+			default:
+				b.WriteByte(q[i])
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", 0, false
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || c == '$' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c >= 0x80
+}
+
+func isHexDigit(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
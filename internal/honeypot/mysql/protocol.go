@@ -0,0 +1,412 @@
+// Package mysql implements the server side of the MySQL client/server
+// protocol for the MySQL persona: packets, the v10 handshake and its
+// authentication methods, and the text protocol's result sets. Queries are
+// answered from a fake schema by a small SQL engine (see Schema and Session).
+package mysql
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Capability flags
+const (
+	ClientLongPassword               uint32 = 1 << 0
+	ClientFoundRows                  uint32 = 1 << 1
+	ClientLongFlag                   uint32 = 1 << 2
+	ClientConnectWithDB              uint32 = 1 << 3
+	ClientNoSchema                   uint32 = 1 << 4
+	ClientODBC                       uint32 = 1 << 6
+	ClientLocalFiles                 uint32 = 1 << 7
+	ClientIgnoreSpace                uint32 = 1 << 8
+	ClientProtocol41                 uint32 = 1 << 9
+	ClientInteractive                uint32 = 1 << 10
+	ClientSSL                        uint32 = 1 << 11
+	ClientTransactions               uint32 = 1 << 13
+	ClientSecureConnection           uint32 = 1 << 15
+	ClientMultiStatements            uint32 = 1 << 16
+	ClientMultiResults               uint32 = 1 << 17
+	ClientPluginAuth                 uint32 = 1 << 19
+	ClientConnectAttrs               uint32 = 1 << 20
+	ClientPluginAuthLenencClientData uint32 = 1 << 21
+)
+
+// ServerCapabilities are the capabilities the persona announces. TLS is not
+// offered, so clients that prefer it fall back to plain text, and classic
+// EOF packets end result sets.
+const ServerCapabilities = ClientLongPassword | ClientFoundRows | ClientLongFlag | ClientConnectWithDB |
+	ClientNoSchema | ClientODBC | ClientLocalFiles | ClientIgnoreSpace | ClientProtocol41 | ClientInteractive |
+	ClientTransactions | ClientSecureConnection | ClientMultiResults | ClientPluginAuth | ClientConnectAttrs |
+	ClientPluginAuthLenencClientData
+
+// Commands
+const (
+	ComQuit            byte = 0x01
+	ComInitDB          byte = 0x02
+	ComQuery           byte = 0x03
+	ComFieldList       byte = 0x04
+	ComStatistics      byte = 0x09
+	ComPing            byte = 0x0e
+	ComSetOption       byte = 0x1b
+	ComResetConnection byte = 0x1f
+)
+
+// statusAutocommit is the only server status flag the persona sets
+const statusAutocommit uint16 = 0x0002
+
+// MaxPacketSize is the largest payload read; larger packets end the
+// connection like exceeding max_allowed_packet does
+const MaxPacketSize = 1 << 20
+
+// ErrPacketTooLarge is returned for packets larger than MaxPacketSize
+var ErrPacketTooLarge = errors.New("packet too large")
+
+// Conn reads and writes the packets of a connection, numbering them
+type Conn struct {
+	rw  io.ReadWriter
+	seq byte
+}
+
+// NewConn returns a connection over rw
+func NewConn(rw io.ReadWriter) *Conn {
+	return &Conn{rw: rw}
+}
+
+// ReadPacket reads a packet and returns its payload. Payloads split over
+// several packets are joined.
+func (c *Conn) ReadPacket() ([]byte, error) {
+	var payload []byte
+	for {
+		var hdr [4]byte
+		if _, err := io.ReadFull(c.rw, hdr[:]); err != nil {
+			return nil, err
+		}
+		n := int(uint32(hdr[0]) | uint32(hdr[1])<<8 | uint32(hdr[2])<<16)
+		if len(payload)+n > MaxPacketSize {
+			return nil, ErrPacketTooLarge
+		}
+		c.seq = hdr[3] + 1
+		start := len(payload)
+		payload = append(payload, make([]byte, n)...)
+		if _, err := io.ReadFull(c.rw, payload[start:]); err != nil {
+			return nil, err
+		}
+		if n < 0xffffff {
+			return payload, nil
+		}
+	}
+}
+
+// ReadCommand reads the packet that starts a command, which restarts the
+// numbering
+func (c *Conn) ReadCommand() ([]byte, error) {
+	c.seq = 0
+	return c.ReadPacket()
+}
+
+// WritePacket writes payload as the next packet
+func (c *Conn) WritePacket(payload []byte) error {
+	for {
+		n := min(len(payload), 0xffffff)
+		pkt := make([]byte, 4, 4+n)
+		pkt[0], pkt[1], pkt[2], pkt[3] = byte(n), byte(n>>8), byte(n>>16), c.seq
+		c.seq++
+		if _, err := c.rw.Write(append(pkt, payload[:n]...)); err != nil {
+			return err
+		}
+		payload = payload[n:]
+		if n < 0xffffff {
+			return nil
+		}
+	}
+}
+
+// WriteOK writes an OK packet
+func (c *Conn) WriteOK(affectedRows, lastInsertID uint64) error {
+	b := []byte{0x00}
+	b = appendLenencInt(b, affectedRows)
+	b = appendLenencInt(b, lastInsertID)
+	b = binary.LittleEndian.AppendUint16(b, statusAutocommit)
+	b = binary.LittleEndian.AppendUint16(b, 0) // Warnings
+	return c.WritePacket(b)
+}
+
+// WriteEOF writes an EOF packet
+func (c *Conn) WriteEOF() error {
+	b := []byte{0xfe, 0x00, 0x00}
+	b = binary.LittleEndian.AppendUint16(b, statusAutocommit)
+	return c.WritePacket(b)
+}
+
+// WriteError writes an ERR packet for err
+func (c *Conn) WriteError(err *Error) error {
+	b := []byte{0xff}
+	b = binary.LittleEndian.AppendUint16(b, err.Code)
+	b = append(b, '#')
+	b = append(b, err.State...)
+	b = append(b, err.Message...)
+	return c.WritePacket(b)
+}
+
+// WriteResult writes a result set in the text protocol
+func (c *Conn) WriteResult(res *Result) error {
+	if err := c.WritePacket(appendLenencInt(nil, uint64(len(res.Columns)))); err != nil {
+		return err
+	}
+	if err := c.WriteColumns(res.Columns, false); err != nil {
+		return err
+	}
+	for _, row := range res.Rows {
+		var b []byte
+		for _, v := range row {
+			if v == nil {
+				b = append(b, 0xfb)
+			} else {
+				b = appendLenencString(b, *v)
+			}
+		}
+		if err := c.WritePacket(b); err != nil {
+			return err
+		}
+	}
+	return c.WriteEOF()
+}
+
+// WriteColumns writes the definitions of columns followed by an EOF packet,
+// with their default values for COM_FIELD_LIST
+func (c *Conn) WriteColumns(columns []*Column, defaults bool) error {
+	for _, col := range columns {
+		b := appendLenencString(nil, "def")
+		b = appendLenencString(b, col.Schema)
+		b = appendLenencString(b, col.Table)
+		b = appendLenencString(b, col.Table)
+		b = appendLenencString(b, col.Name)
+		b = appendLenencString(b, col.Name)
+		b = append(b, 0x0c)
+		t := col.wireType()
+		b = binary.LittleEndian.AppendUint16(b, t.charset)
+		b = binary.LittleEndian.AppendUint32(b, t.length)
+		b = append(b, t.code)
+		b = binary.LittleEndian.AppendUint16(b, col.flags())
+		b = append(b, t.decimals, 0x00, 0x00)
+		if defaults {
+			if col.Default == nil {
+				b = append(b, 0xfb)
+			} else {
+				b = appendLenencString(b, *col.Default)
+			}
+		}
+		if err := c.WritePacket(b); err != nil {
+			return err
+		}
+	}
+	return c.WriteEOF()
+}
+
+// Handshake is the initial handshake packet (protocol version 10)
+type Handshake struct {
+	Version      string
+	ConnectionID uint32
+	Scramble     []byte // 20 bytes
+	Plugin       string
+}
+
+// WriteHandshake writes the initial handshake
+func (c *Conn) WriteHandshake(hs Handshake) error {
+	charset := byte(0x21) // utf8_general_ci
+	if strings.HasPrefix(hs.Version, "8.") {
+		charset = 0xff // utf8mb4_0900_ai_ci
+	}
+	b := []byte{0x0a}
+	b = append(b, hs.Version...)
+	b = append(b, 0x00)
+	b = binary.LittleEndian.AppendUint32(b, hs.ConnectionID)
+	b = append(b, hs.Scramble[:8]...)
+	b = append(b, 0x00)
+	b = binary.LittleEndian.AppendUint16(b, uint16(ServerCapabilities&0xffff))
+	b = append(b, charset)
+	b = binary.LittleEndian.AppendUint16(b, statusAutocommit)
+	b = binary.LittleEndian.AppendUint16(b, uint16(ServerCapabilities>>16))
+	b = append(b, byte(len(hs.Scramble)+1))
+	b = append(b, make([]byte, 10)...)
+	b = append(b, hs.Scramble[8:]...)
+	b = append(b, 0x00)
+	b = append(b, hs.Plugin...)
+	b = append(b, 0x00)
+	return c.WritePacket(b)
+}
+
+// WriteAuthSwitch asks the client to authenticate again with plugin
+func (c *Conn) WriteAuthSwitch(plugin string, scramble []byte) error {
+	b := []byte{0xfe}
+	b = append(b, plugin...)
+	b = append(b, 0x00)
+	b = append(b, scramble...)
+	b = append(b, 0x00)
+	return c.WritePacket(b)
+}
+
+// WriteAuthMoreData sends plugin specific data during authentication
+func (c *Conn) WriteAuthMoreData(data []byte) error {
+	return c.WritePacket(append([]byte{0x01}, data...))
+}
+
+// HandshakeResponse is the client's answer to the handshake
+type HandshakeResponse struct {
+	Capabilities uint32
+	Charset      byte
+	User         string
+	AuthResponse []byte
+	Database     string
+	Plugin       string
+	Attributes   map[string]string // Connection attributes, e.g. _client_name and program_name
+}
+
+// ErrSSLRequest is returned for clients that ask for TLS, which is not
+// offered
+var ErrSSLRequest = errors.New("client requested TLS")
+
+// ParseHandshakeResponse parses a HandshakeResponse41 packet
+func ParseHandshakeResponse(payload []byte) (*HandshakeResponse, error) {
+	r := &reader{b: payload}
+	resp := &HandshakeResponse{}
+	resp.Capabilities = r.uint32()
+	if resp.Capabilities&ClientProtocol41 == 0 {
+		return nil, errors.New("pre-4.1 protocol")
+	}
+	r.skip(4) // Max packet size
+	resp.Charset = r.byte()
+	r.skip(23)
+	if r.err == nil && len(r.b) == 0 && resp.Capabilities&ClientSSL != 0 {
+		return nil, ErrSSLRequest
+	}
+	resp.User = r.nulString()
+	switch {
+	case resp.Capabilities&ClientPluginAuthLenencClientData != 0:
+		resp.AuthResponse = r.lenencBytes()
+	case resp.Capabilities&ClientSecureConnection != 0:
+		resp.AuthResponse = r.bytes(int(r.byte()))
+	default:
+		resp.AuthResponse = []byte(r.nulString())
+	}
+	if resp.Capabilities&ClientConnectWithDB != 0 && len(r.b) > 0 {
+		resp.Database = r.nulString()
+	}
+	if resp.Capabilities&ClientPluginAuth != 0 && len(r.b) > 0 {
+		resp.Plugin = r.nulString()
+	}
+	if resp.Capabilities&ClientConnectAttrs != 0 && len(r.b) > 0 {
+		attrs := &reader{b: r.lenencBytes()}
+		resp.Attributes = make(map[string]string)
+		for len(attrs.b) > 0 && attrs.err == nil {
+			k := string(attrs.lenencBytes())
+			v := string(attrs.lenencBytes())
+			if attrs.err == nil {
+				resp.Attributes[k] = v
+			}
+		}
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("malformed handshake response: %w", r.err)
+	}
+	return resp, nil
+}
+
+// reader decodes the fields of a payload, remembering the first error
+type reader struct {
+	b   []byte
+	err error
+}
+
+var errShort = errors.New("short packet")
+
+func (r *reader) bytes(n int) []byte {
+	if r.err != nil || n > len(r.b) {
+		r.err = errShort
+		return nil
+	}
+	b := r.b[:n]
+	r.b = r.b[n:]
+	return b
+}
+
+func (r *reader) skip(n int) { r.bytes(n) }
+
+func (r *reader) byte() byte {
+	if b := r.bytes(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *reader) uint32() uint32 {
+	if b := r.bytes(4); b != nil {
+		return binary.LittleEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (r *reader) nulString() string {
+	if r.err != nil {
+		return ""
+	}
+	i := bytes.IndexByte(r.b, 0)
+	if i < 0 {
+		// The last field may lack its terminator
+		s := string(r.b)
+		r.b = nil
+		return s
+	}
+	s := string(r.b[:i])
+	r.b = r.b[i+1:]
+	return s
+}
+
+func (r *reader) lenencInt() uint64 {
+	switch first := r.byte(); first {
+	case 0xfc:
+		if b := r.bytes(2); b != nil {
+			return uint64(binary.LittleEndian.Uint16(b))
+		}
+	case 0xfd:
+		if b := r.bytes(3); b != nil {
+			return uint64(b[0]) | uint64(b[1])<<8 | uint64(b[2])<<16
+		}
+	case 0xfe:
+		if b := r.bytes(8); b != nil {
+			return binary.LittleEndian.Uint64(b)
+		}
+	default:
+		return uint64(first)
+	}
+	return 0
+}
+
+func (r *reader) lenencBytes() []byte {
+	n := r.lenencInt()
+	if n > uint64(len(r.b)) {
+		r.err = errShort
+		return nil
+	}
+	return r.bytes(int(n))
+}
+
+func appendLenencInt(b []byte, n uint64) []byte {
+	switch {
+	case n < 0xfb:
+		return append(b, byte(n))
+	case n < 1<<16:
+		return append(b, 0xfc, byte(n), byte(n>>8))
+	case n < 1<<24:
+		return append(b, 0xfd, byte(n), byte(n>>8), byte(n>>16))
+	}
+	return binary.LittleEndian.AppendUint64(append(b, 0xfe), n)
+}
+
+func appendLenencString(b []byte, s string) []byte {
+	return append(appendLenencInt(b, uint64(len(s))), s...)
+}
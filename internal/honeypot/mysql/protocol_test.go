@@ -0,0 +1,215 @@
+package mysql
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"net"
+	"testing"
+)
+
+// handshakeResponse builds a HandshakeResponse41 packet
+func handshakeResponse(user string, auth []byte, db, plugin string) []byte {
+	caps := ClientProtocol41 | ClientSecureConnection | ClientPluginAuth | ClientPluginAuthLenencClientData | ClientConnectAttrs
+	if db != "" {
+		caps |= ClientConnectWithDB
+	}
+	b := binary.LittleEndian.AppendUint32(nil, caps)
+	b = binary.LittleEndian.AppendUint32(b, MaxPacketSize)
+	b = append(b, 0x21)
+	b = append(b, make([]byte, 23)...)
+	b = append(append(b, user...), 0)
+	b = appendLenencString(b, string(auth))
+	if db != "" {
+		b = append(append(b, db...), 0)
+	}
+	b = append(append(b, plugin...), 0)
+	attrs := appendLenencString(appendLenencString(nil, "_client_name"), "libmysql")
+	return append(appendLenencInt(b, uint64(len(attrs))), attrs...)
+}
+
+// serve runs fn on the server side of a pipe and returns the client side
+func serve(t *testing.T, fn func(c *Conn)) *Conn {
+	t.Helper()
+	server, client := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer server.Close()
+		fn(NewConn(server))
+	}()
+	t.Cleanup(func() {
+		client.Close()
+		<-done
+	})
+	return NewConn(client)
+}
+
+func TestHandshake(t *testing.T) {
+	scramble := NewScramble()
+	client := serve(t, func(c *Conn) {
+		c.WriteHandshake(Handshake{Version: "8.0.27-0ubuntu0.20.04.1", ConnectionID: 42, Scramble: scramble, Plugin: PluginCachingSHA2})
+	})
+	pkt, err := client.ReadPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &reader{b: pkt}
+	if v := r.byte(); v != 10 {
+		t.Errorf("protocol version %d", v)
+	}
+	if v := r.nulString(); v != "8.0.27-0ubuntu0.20.04.1" {
+		t.Errorf("version %q", v)
+	}
+	if id := r.uint32(); id != 42 {
+		t.Errorf("connection id %d", id)
+	}
+	got := append([]byte{}, r.bytes(8)...)
+	r.skip(1 + 2 + 1 + 2 + 2 + 1 + 10)
+	got = append(got, r.bytes(12)...)
+	if string(got) != string(scramble) {
+		t.Errorf("scramble %x, want %x", got, scramble)
+	}
+	r.skip(1)
+	if plugin := r.nulString(); plugin != PluginCachingSHA2 {
+		t.Errorf("plugin %q", plugin)
+	}
+}
+
+func TestParseHandshakeResponse(t *testing.T) {
+	resp, err := ParseHandshakeResponse(handshakeResponse("root", []byte{1, 2, 3}, "wordpress", PluginNative))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.User != "root" || string(resp.AuthResponse) != "\x01\x02\x03" || resp.Database != "wordpress" || resp.Plugin != PluginNative {
+		t.Errorf("got %+v", resp)
+	}
+	if resp.Attributes["_client_name"] != "libmysql" {
+		t.Errorf("attributes %v", resp.Attributes)
+	}
+
+	ssl := binary.LittleEndian.AppendUint32(nil, ClientProtocol41|ClientSSL)
+	ssl = append(ssl, make([]byte, 28)...)
+	if _, err := ParseHandshakeResponse(ssl); err != ErrSSLRequest {
+		t.Errorf("got %v, want ErrSSLRequest", err)
+	}
+}
+
+func TestAuthenticateNative(t *testing.T) {
+	scramble := NewScramble()
+	resp := &HandshakeResponse{User: "root", Plugin: PluginNative, AuthResponse: NativePassword(scramble, "toor")}
+	login, err := (&Conn{}).Authenticate(resp, scramble, PluginNative, nil, []string{"admin", "toor"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !login.Known || login.Password != "toor" {
+		t.Errorf("got %+v", login)
+	}
+
+	resp.AuthResponse = NativePassword(scramble, "unguessable")
+	if login, _ := (&Conn{}).Authenticate(resp, scramble, PluginNative, nil, []string{"toor"}); login.Known {
+		t.Errorf("recovered %q", login.Password)
+	}
+}
+
+func TestAuthenticateSwitch(t *testing.T) {
+	scramble := NewScramble()
+	client := serve(t, func(c *Conn) {
+		resp := &HandshakeResponse{User: "root", Plugin: PluginCachingSHA2, AuthResponse: CachingSHA2Password(scramble, "x")}
+		login, err := c.Authenticate(resp, scramble, PluginNative, nil, []string{"secret"})
+		if err != nil || login.Password != "secret" || login.Plugin != PluginNative {
+			t.Errorf("got %+v, %v", login, err)
+		}
+	})
+	pkt, err := client.ReadPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pkt[0] != 0xfe || string(pkt[1:1+len(PluginNative)]) != PluginNative {
+		t.Fatalf("got %q, want an auth switch", pkt)
+	}
+	if err := client.WritePacket(NativePassword(scramble, "secret")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAuthenticateCachingSHA2(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scramble := NewScramble()
+	client := serve(t, func(c *Conn) {
+		resp := &HandshakeResponse{User: "admin", Plugin: PluginCachingSHA2, AuthResponse: CachingSHA2Password(scramble, "Winter2024!")}
+		login, err := c.Authenticate(resp, scramble, PluginCachingSHA2, key, nil)
+		if err != nil || !login.Known || login.Password != "Winter2024!" {
+			t.Errorf("got %+v, %v", login, err)
+		}
+	})
+
+	// The client is asked for the full password, asks for the key and
+	// sends the password encrypted with it
+	pkt, err := client.ReadPacket()
+	if err != nil || string(pkt) != "\x01\x04" {
+		t.Fatalf("got %x, %v, want perform full authentication", pkt, err)
+	}
+	if err := client.WritePacket([]byte{sha2RequestPublicKey}); err != nil {
+		t.Fatal(err)
+	}
+	pkt, err = client.ReadPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(pkt[1:])
+	if block == nil {
+		t.Fatalf("got %q, want a PEM key", pkt)
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain := []byte("Winter2024!\x00")
+	for i := range plain {
+		plain[i] ^= scramble[i%len(scramble)]
+	}
+	ciphertext, err := rsa.EncryptOAEP(sha1.New(), rand.Reader, pub.(*rsa.PublicKey), plain, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.WritePacket(ciphertext); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWriteResult(t *testing.T) {
+	client := serve(t, func(c *Conn) {
+		s := newTestSession()
+		res, _, err := s.Query("SELECT 'a' AS x, NULL")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		c.WriteResult(res)
+	})
+	// Column count, two definitions, EOF, the row and EOF
+	var packets [][]byte
+	for i := 0; i < 6; i++ {
+		pkt, err := client.ReadPacket()
+		if err != nil {
+			t.Fatal(err)
+		}
+		packets = append(packets, pkt)
+	}
+	if packets[0][0] != 2 {
+		t.Errorf("column count %d", packets[0][0])
+	}
+	if packets[3][0] != 0xfe || packets[5][0] != 0xfe {
+		t.Errorf("missing EOF packets")
+	}
+	if string(packets[4]) != "\x01a\xfb" {
+		t.Errorf("row %q", packets[4])
+	}
+}
@@ -0,0 +1,411 @@
+package mysql
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//go:embed schemas
+var builtin embed.FS
+
+// infoSchema is the database that describes the others
+const infoSchema = "information_schema"
+
+// Database is a fake database. It is loaded from a JSON file named after it:
+//
+//	{
+//	  "tables": [
+//	    {"name": "wp_users",
+//	     "columns": [
+//	       {"name": "ID", "type": "bigint(20) unsigned", "key": "PRI", "extra": "auto_increment"},
+//	       {"name": "user_login", "type": "varchar(60)", "default": ""},
+//	       {"name": "user_url", "type": "varchar(100)", "null": true}
+//	     ],
+//	     "rows": [[1, "admin", null]]}
+//	  ]
+//	}
+type Database struct {
+	Name   string
+	Tables []*Table
+}
+
+// Table is a table of a fake database
+type Table struct {
+	Name    string      `json:"name"`
+	Columns []*Column   `json:"columns"`
+	Rows    [][]*string `json:"-"` // NULL values are nil
+}
+
+// Column is a column of a table or of a result set
+type Column struct {
+	Name    string  `json:"name"`
+	Type    string  `json:"type"` // Column type, e.g. int(11) or varchar(255); varchar(255) if empty
+	Key     string  `json:"key"`  // PRI, UNI or MUL
+	Null    bool    `json:"null"` // NULL is allowed
+	Default *string `json:"default"`
+	Extra   string  `json:"extra"` // e.g. auto_increment
+
+	Schema string `json:"-"` // Database of the table, in result sets
+	Table  string `json:"-"` // Table of the column, in result sets
+}
+
+// Table returns the table called name, or nil
+func (d *Database) Table(name string) *Table {
+	for _, t := range d.Tables {
+		if t.Name == name || (d.Name == infoSchema && strings.EqualFold(t.Name, name)) {
+			return t
+		}
+	}
+	return nil
+}
+
+// Column returns the position of the column called name, or -1. Column
+// names are not case sensitive.
+func (t *Table) Column(name string) int {
+	for i, c := range t.Columns {
+		if strings.EqualFold(c.Name, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// DataType returns the type of the column without its length and
+// attributes, e.g. varchar
+func (c *Column) DataType() string {
+	t := strings.ToLower(c.Type)
+	if t == "" {
+		return "varchar"
+	}
+	if i := strings.IndexAny(t, "( "); i >= 0 {
+		t = t[:i]
+	}
+	return t
+}
+
+// ColumnType returns the full type of the column, e.g. varchar(255)
+func (c *Column) ColumnType() string {
+	if c.Type == "" {
+		return "varchar(255)"
+	}
+	// Values of enum and set types keep their case
+	if i, j := strings.IndexByte(c.Type, '('), strings.LastIndexByte(c.Type, ')'); i >= 0 && j > i {
+		return strings.ToLower(c.Type[:i]) + c.Type[i:j+1] + strings.ToLower(c.Type[j+1:])
+	}
+	return strings.ToLower(c.Type)
+}
+
+// wireType is how a column type is described in result sets
+type wireType struct {
+	code     byte
+	length   uint32
+	charset  uint16
+	decimals byte
+}
+
+// Charsets of column definitions
+const (
+	charsetUTF8   = 33 // utf8_general_ci
+	charsetBinary = 63
+)
+
+// Column definition flags
+const (
+	flagNotNull       = 1
+	flagPrimaryKey    = 2
+	flagUniqueKey     = 4
+	flagMultipleKey   = 8
+	flagBlob          = 16
+	flagUnsigned      = 32
+	flagBinary        = 128
+	flagAutoIncrement = 512
+	flagNum           = 32768
+)
+
+// wireTypes are the protocol's types of the column types
+var wireTypes = map[string]wireType{
+	"tinyint":    {code: 0x01, length: 4},
+	"smallint":   {code: 0x02, length: 6},
+	"mediumint":  {code: 0x09, length: 9},
+	"int":        {code: 0x03, length: 11},
+	"integer":    {code: 0x03, length: 11},
+	"bigint":     {code: 0x08, length: 20},
+	"float":      {code: 0x04, length: 12, decimals: 31},
+	"double":     {code: 0x05, length: 22, decimals: 31},
+	"decimal":    {code: 0xf6, length: 12, decimals: 2},
+	"date":       {code: 0x0a, length: 10},
+	"datetime":   {code: 0x0c, length: 19},
+	"timestamp":  {code: 0x07, length: 19},
+	"time":       {code: 0x0b, length: 10},
+	"year":       {code: 0x0d, length: 4},
+	"char":       {code: 0xfe, length: 3, charset: charsetUTF8},
+	"varchar":    {code: 0xfd, length: 765, charset: charsetUTF8},
+	"tinytext":   {code: 0xfc, length: 255, charset: charsetUTF8},
+	"text":       {code: 0xfc, length: 65535, charset: charsetUTF8},
+	"mediumtext": {code: 0xfc, length: 16777215, charset: charsetUTF8},
+	"longtext":   {code: 0xfc, length: 4294967295, charset: charsetUTF8},
+	"blob":       {code: 0xfc, length: 65535},
+	"longblob":   {code: 0xfc, length: 4294967295},
+	"enum":       {code: 0xfe, length: 21, charset: charsetUTF8},
+	"json":       {code: 0xf5, length: 4294967295},
+}
+
+func (c *Column) wireType() wireType {
+	t, ok := wireTypes[c.DataType()]
+	if !ok {
+		t = wireTypes["varchar"]
+	}
+	if t.charset == 0 {
+		t.charset = charsetBinary
+	}
+	// The length of strings is in bytes of utf8, three per character
+	if n, ok := typeLength(c.Type); ok && t.charset == charsetUTF8 {
+		t.length = uint32(n) * 3
+	}
+	return t
+}
+
+// typeLength returns the length in a column type such as varchar(60)
+func typeLength(t string) (int, bool) {
+	_, rest, ok := strings.Cut(t, "(")
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(strings.Split(strings.SplitN(rest, ")", 2)[0], ",")[0]))
+	return n, err == nil
+}
+
+func (c *Column) flags() uint16 {
+	var f uint16
+	if !c.Null {
+		f |= flagNotNull
+	}
+	switch c.Key {
+	case "PRI":
+		f |= flagPrimaryKey
+	case "UNI":
+		f |= flagUniqueKey
+	case "MUL":
+		f |= flagMultipleKey
+	}
+	t := c.wireType()
+	if t.code == 0xfc {
+		f |= flagBlob
+	}
+	if t.charset == charsetBinary {
+		f |= flagBinary
+	}
+	if strings.Contains(strings.ToLower(c.Type), "unsigned") {
+		f |= flagUnsigned
+	}
+	if strings.Contains(strings.ToLower(c.Extra), "auto_increment") {
+		f |= flagAutoIncrement
+	}
+	if isNumericType(c.DataType()) {
+		f |= flagNum
+	}
+	return f
+}
+
+func isNumericType(t string) bool {
+	switch t {
+	case "tinyint", "smallint", "mediumint", "int", "integer", "bigint", "float", "double", "decimal", "year":
+		return true
+	}
+	return false
+}
+
+// builtinDatabases parses the built-in databases once
+var builtinDatabases = sync.OnceValue(func() map[string]*Database {
+	dbs, err := Load(builtin, "schemas")
+	if err != nil {
+		panic(err)
+	}
+	return dbs
+})
+
+// Builtin returns the built-in databases by name: a WordPress site, a shop
+// and MySQL's own. Databases are not modified once loaded, so they are
+// shared; the map is the caller's.
+func Builtin() map[string]*Database {
+	return maps.Clone(builtinDatabases())
+}
+
+// LoadDir loads the databases in the .json files of dir
+func LoadDir(dir string) (map[string]*Database, error) {
+	return Load(os.DirFS(dir), ".")
+}
+
+// Load loads the databases in the .json files of dir in fsys
+func Load(fsys fs.FS, dir string) (map[string]*Database, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	dbs := make(map[string]*Database)
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), ".json")
+		if !ok || e.IsDir() {
+			continue
+		}
+		if strings.EqualFold(name, infoSchema) {
+			return nil, fmt.Errorf("%s: %s is generated from the other databases", e.Name(), infoSchema)
+		}
+		data, err := fs.ReadFile(fsys, path.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		db, err := parseDatabase(name, data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", e.Name(), err)
+		}
+		dbs[name] = db
+	}
+	return dbs, nil
+}
+
+// parseDatabase parses a database and converts its rows to strings
+func parseDatabase(name string, data []byte) (*Database, error) {
+	var file struct {
+		Tables []struct {
+			*Table
+			Rows [][]any `json:"rows"`
+		} `json:"tables"`
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&file); err != nil {
+		return nil, err
+	}
+	db := &Database{Name: name}
+	for _, ft := range file.Tables {
+		t := ft.Table
+		if t == nil || t.Name == "" {
+			return nil, fmt.Errorf("table with no name")
+		}
+		if len(t.Columns) == 0 {
+			return nil, fmt.Errorf("table %s has no columns", t.Name)
+		}
+		for i, raw := range ft.Rows {
+			if len(raw) != len(t.Columns) {
+				return nil, fmt.Errorf("table %s: row %d has %d values for %d columns", t.Name, i+1, len(raw), len(t.Columns))
+			}
+			row := make([]*string, len(raw))
+			for j, v := range raw {
+				switch v := v.(type) {
+				case nil:
+				case string:
+					row[j] = &v
+				case json.Number:
+					s := v.String()
+					row[j] = &s
+				case bool:
+					s := "0"
+					if v {
+						s = "1"
+					}
+					row[j] = &s
+				default:
+					return nil, fmt.Errorf("table %s: row %d: value %d is not a string, number or null", t.Name, i+1, j+1)
+				}
+			}
+			t.Rows = append(t.Rows, row)
+		}
+		db.Tables = append(db.Tables, t)
+	}
+	return db, nil
+}
+
+// Schema is the set of databases of the server
+type Schema struct {
+	dbs map[string]*Database
+}
+
+// NewSchema returns the schema of dbs, with an information_schema that
+// describes them
+func NewSchema(dbs map[string]*Database) *Schema {
+	s := &Schema{dbs: maps.Clone(dbs)}
+	s.dbs[infoSchema] = s.infoSchema()
+	return s
+}
+
+// Names returns the names of the databases, sorted
+func (s *Schema) Names() []string {
+	names := make([]string, 0, len(s.dbs))
+	for name := range s.dbs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Database returns the database called name, or nil
+func (s *Schema) Database(name string) *Database {
+	if strings.EqualFold(name, infoSchema) {
+		return s.dbs[infoSchema]
+	}
+	return s.dbs[name]
+}
+
+// infoSchema builds the tables of information_schema that clients and
+// tools such as sqlmap enumerate databases with
+func (s *Schema) infoSchema() *Database {
+	col := func(name, typ string) *Column { return &Column{Name: name, Type: typ, Default: new(string)} }
+	str := func(v string) *string { return &v }
+	schemata := &Table{Name: "SCHEMATA", Columns: []*Column{
+		col("CATALOG_NAME", "varchar(64)"), col("SCHEMA_NAME", "varchar(64)"),
+		col("DEFAULT_CHARACTER_SET_NAME", "varchar(64)"), col("DEFAULT_COLLATION_NAME", "varchar(64)"),
+		{Name: "SQL_PATH", Type: "varchar(512)", Null: true},
+	}}
+	tables := &Table{Name: "TABLES", Columns: []*Column{
+		col("TABLE_CATALOG", "varchar(64)"), col("TABLE_SCHEMA", "varchar(64)"), col("TABLE_NAME", "varchar(64)"),
+		col("TABLE_TYPE", "varchar(64)"), {Name: "ENGINE", Type: "varchar(64)", Null: true},
+		{Name: "TABLE_ROWS", Type: "bigint(21) unsigned", Null: true}, col("TABLE_COMMENT", "varchar(2048)"),
+	}}
+	columns := &Table{Name: "COLUMNS", Columns: []*Column{
+		col("TABLE_CATALOG", "varchar(64)"), col("TABLE_SCHEMA", "varchar(64)"), col("TABLE_NAME", "varchar(64)"),
+		col("COLUMN_NAME", "varchar(64)"), col("ORDINAL_POSITION", "bigint(21) unsigned"),
+		{Name: "COLUMN_DEFAULT", Type: "longtext", Null: true}, col("IS_NULLABLE", "varchar(3)"),
+		col("DATA_TYPE", "varchar(64)"), col("COLUMN_TYPE", "longtext"), col("COLUMN_KEY", "varchar(3)"),
+		col("EXTRA", "varchar(30)"),
+	}}
+	is := &Database{Name: infoSchema, Tables: []*Table{schemata, tables, columns}}
+
+	names := append(s.Names(), infoSchema)
+	sort.Strings(names)
+	for _, name := range names {
+		db := s.dbs[name]
+		if name == infoSchema {
+			db = is
+		}
+		schemata.Rows = append(schemata.Rows, []*string{str("def"), str(name), str("utf8"), str("utf8_general_ci"), nil})
+		for _, t := range db.Tables {
+			typ, engine, rows := "BASE TABLE", str("InnoDB"), str(strconv.Itoa(len(t.Rows)))
+			if db == is {
+				typ, engine, rows = "SYSTEM VIEW", str("MEMORY"), nil
+			}
+			tables.Rows = append(tables.Rows, []*string{str("def"), str(name), str(t.Name), str(typ), engine, rows, str("")})
+			for i, c := range t.Columns {
+				nullable := "NO"
+				if c.Null {
+					nullable = "YES"
+				}
+				columns.Rows = append(columns.Rows, []*string{
+					str("def"), str(name), str(t.Name), str(c.Name), str(strconv.Itoa(i + 1)),
+					c.Default, str(nullable), str(c.DataType()), str(c.ColumnType()), str(c.Key), str(c.Extra),
+				})
+			}
+		}
+	}
+	return is
+}
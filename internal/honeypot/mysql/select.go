@@ -0,0 +1,542 @@
+package mysql
+
+import (
+	"sort"
+	"strings"
+)
+
+// selectStmt is a SELECT statement
+type selectStmt struct {
+	distinct bool
+	fields   []*selectField
+	from     *tableRef // nil for none
+	where    expr
+	groupBy  []expr
+	having   expr
+	orderBy  []orderItem
+	limit    int // -1 for none
+	offset   int
+	outfile  bool // INTO OUTFILE or DUMPFILE
+
+	union    *selectStmt
+	unionAll bool
+}
+
+// selectField is an expression of the select list, or * or table.*
+type selectField struct {
+	x     expr
+	name  string // Alias, or the text of the expression
+	star  bool
+	table string // table of table.*
+}
+
+type tableRef struct {
+	db, name, alias string
+}
+
+type orderItem struct {
+	x    expr
+	desc bool
+}
+
+// selectModifiers are the options between SELECT and the select list
+var selectModifiers = []string{"ALL", "DISTINCTROW", "HIGH_PRIORITY", "STRAIGHT_JOIN", "SQL_SMALL_RESULT",
+	"SQL_BIG_RESULT", "SQL_BUFFER_RESULT", "SQL_CACHE", "SQL_NO_CACHE", "SQL_CALC_FOUND_ROWS"}
+
+func (p *parser) parseSelect() (*selectStmt, error) {
+	if err := p.expect("SELECT"); err != nil {
+		return nil, err
+	}
+	st := &selectStmt{limit: -1}
+	for more := true; more; {
+		more = false
+		if p.accept("DISTINCT") {
+			st.distinct, more = true, true
+		}
+		for _, m := range selectModifiers {
+			if p.accept(m) {
+				st.distinct = st.distinct || m == "DISTINCTROW"
+				more = true
+			}
+		}
+	}
+
+	for {
+		f, err := p.parseField()
+		if err != nil {
+			return nil, err
+		}
+		st.fields = append(st.fields, f)
+		if !p.acceptOp(",") {
+			break
+		}
+	}
+	if err := p.parseInto(st); err != nil {
+		return nil, err
+	}
+
+	if p.accept("FROM") {
+		if !p.accept("DUAL") {
+			db, name, err := p.tableName()
+			if err != nil {
+				return nil, err
+			}
+			st.from = &tableRef{db: db, name: name}
+			if st.from.alias, err = p.alias(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	var err error
+	if p.accept("WHERE") {
+		if st.where, err = p.parseExpr(); err != nil {
+			return nil, err
+		}
+	}
+	if p.accept("GROUP") {
+		if err := p.expect("BY"); err != nil {
+			return nil, err
+		}
+		if st.groupBy, err = p.exprList(); err != nil {
+			return nil, err
+		}
+		if p.accept("WITH") {
+			if err := p.expect("ROLLUP"); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if p.accept("HAVING") {
+		if st.having, err = p.parseExpr(); err != nil {
+			return nil, err
+		}
+	}
+	if p.accept("ORDER") {
+		if err := p.expect("BY"); err != nil {
+			return nil, err
+		}
+		for {
+			x, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			item := orderItem{x: x, desc: p.accept("DESC")}
+			if !item.desc {
+				p.accept("ASC")
+			}
+			st.orderBy = append(st.orderBy, item)
+			if !p.acceptOp(",") {
+				break
+			}
+		}
+	}
+	if p.accept("LIMIT") {
+		if st.limit, err = p.limitValue(); err != nil {
+			return nil, err
+		}
+		if p.acceptOp(",") {
+			st.offset = st.limit
+			if st.limit, err = p.limitValue(); err != nil {
+				return nil, err
+			}
+		} else if p.accept("OFFSET") {
+			if st.offset, err = p.limitValue(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := p.parseInto(st); err != nil {
+		return nil, err
+	}
+	if p.accept("FOR") {
+		if err := p.expect("UPDATE"); err != nil {
+			return nil, err
+		}
+	} else if p.accept("LOCK") {
+		for _, kw := range []string{"IN", "SHARE", "MODE"} {
+			if err := p.expect(kw); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if p.accept("UNION") {
+		st.unionAll = p.accept("ALL")
+		if !st.unionAll {
+			p.accept("DISTINCT")
+		}
+		if st.union, err = p.parseSelect(); err != nil {
+			return nil, err
+		}
+	}
+	return st, nil
+}
+
+// parseField parses an element of the select list
+func (p *parser) parseField() (*selectField, error) {
+	if p.acceptOp("*") {
+		return &selectField{star: true}, nil
+	}
+	if t := p.peek(); (t.kind == tokIdent || t.kind == tokQuotedIdent) && p.peekAt(1).isOp(".") && p.peekAt(2).isOp("*") {
+		p.i += 3
+		return &selectField{star: true, table: t.text}, nil
+	}
+	start := p.peek().pos
+	x, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	f := &selectField{x: x, name: strings.TrimSpace(p.q[start:p.peek().pos])}
+	switch x := x.(type) {
+	case *columnRef:
+		f.name = x.name
+	case *literal:
+		if !x.v.null && !x.v.num {
+			f.name = x.v.s
+		}
+	}
+	alias, err := p.alias()
+	if err != nil {
+		return nil, err
+	}
+	if alias != "" {
+		f.name = alias
+	}
+	return f, nil
+}
+
+// parseInto parses INTO OUTFILE, INTO DUMPFILE and INTO @variables
+func (p *parser) parseInto(st *selectStmt) error {
+	if !p.accept("INTO") {
+		return nil
+	}
+	if p.accept("OUTFILE") || p.accept("DUMPFILE") {
+		st.outfile = true
+		if p.next().kind != tokString {
+			return p.errSyntax()
+		}
+		// Export options up to the next clause
+		for t := p.peek(); t.kind != tokEOF && !t.isOp(";") && !t.is("FROM") && !t.is("LIMIT"); t = p.peek() {
+			p.i++
+		}
+		return nil
+	}
+	for {
+		if p.next().kind != tokUserVar {
+			return p.errSyntax()
+		}
+		if !p.acceptOp(",") {
+			return nil
+		}
+	}
+}
+
+// outputRow is a row of a result being built, with its sort keys
+type outputRow struct {
+	values []value
+	keys   []value
+}
+
+// runSelect runs a SELECT statement
+func (s *Session) runSelect(st *selectStmt) (*Result, error) {
+	if st.outfile {
+		return nil, errSecureFilePriv
+	}
+	ctx := &evalCtx{s: s}
+	rows := [][]*string{nil}
+	if st.from != nil {
+		db, t, err := s.table(st.from.db, st.from.name)
+		if err != nil {
+			return nil, err
+		}
+		ctx.table, ctx.alias, rows = t, st.from.name, t.Rows
+		if st.from.alias != "" {
+			ctx.alias = st.from.alias
+		}
+		ctx.db = db
+	}
+
+	// Expand the stars
+	var fields []*selectField
+	for _, f := range st.fields {
+		if !f.star {
+			fields = append(fields, f)
+			continue
+		}
+		if ctx.table == nil {
+			return nil, errorf(1096, "HY000", "No tables used")
+		}
+		if f.table != "" && !strings.EqualFold(f.table, ctx.alias) && !strings.EqualFold(f.table, ctx.table.Name) {
+			return nil, errorf(1051, "42S02", "Unknown table '%s'", f.table)
+		}
+		for _, c := range ctx.table.Columns {
+			fields = append(fields, &selectField{x: &columnRef{name: c.Name}, name: c.Name})
+		}
+	}
+
+	ctx.clause = "where clause"
+	var matched [][]*string
+	for _, row := range rows {
+		ctx.row = row
+		if st.where != nil {
+			v, err := ctx.eval(st.where)
+			if err != nil {
+				return nil, err
+			}
+			if !v.truth() {
+				continue
+			}
+		}
+		matched = append(matched, row)
+	}
+
+	// Rows are evaluated one by one, or by group with aggregates
+	groups := make([][][]*string, 0, len(matched))
+	aggregate := len(st.groupBy) > 0 || st.having != nil && hasAggregate(st.having)
+	for _, f := range fields {
+		aggregate = aggregate || hasAggregate(f.x)
+	}
+	switch {
+	case len(st.groupBy) > 0:
+		ctx.clause = "group statement"
+		index := make(map[string]int)
+		for _, row := range matched {
+			ctx.row = row
+			var key strings.Builder
+			for _, x := range st.groupBy {
+				v, err := ctx.eval(x)
+				if err != nil {
+					return nil, err
+				}
+				key.WriteString(strings.ToLower(v.s))
+				if v.null {
+					key.WriteString("\x00NULL")
+				}
+				key.WriteByte(0)
+			}
+			if i, ok := index[key.String()]; ok {
+				groups[i] = append(groups[i], row)
+			} else {
+				index[key.String()] = len(groups)
+				groups = append(groups, [][]*string{row})
+			}
+		}
+	case aggregate:
+		groups = append(groups, matched)
+	default:
+		for _, row := range matched {
+			groups = append(groups, [][]*string{row})
+		}
+	}
+
+	var out []outputRow
+	var size int
+	for _, group := range groups {
+		ctx.row, ctx.group = nil, nil
+		if len(group) > 0 {
+			ctx.row = group[0]
+		}
+		if aggregate {
+			ctx.group = group
+		}
+		r := outputRow{values: make([]value, len(fields))}
+		ctx.clause = "field list"
+		for i, f := range fields {
+			v, err := ctx.eval(f.x)
+			if err != nil {
+				return nil, err
+			}
+			r.values[i] = v
+			if size += len(v.s); size > maxResultBytes {
+				return nil, errOutOfMemory
+			}
+		}
+		if st.having != nil {
+			ctx.clause = "having clause"
+			v, err := ctx.eval(st.having)
+			if err != nil {
+				return nil, err
+			}
+			if !v.truth() {
+				continue
+			}
+		}
+		ctx.clause = "order clause"
+		for _, item := range st.orderBy {
+			k, err := orderKey(ctx, item.x, fields, r.values)
+			if err != nil {
+				return nil, err
+			}
+			r.keys = append(r.keys, k)
+		}
+		out = append(out, r)
+	}
+
+	if st.distinct {
+		out = distinct(out)
+	}
+	if len(st.orderBy) > 0 {
+		sort.SliceStable(out, func(i, j int) bool {
+			for k, item := range st.orderBy {
+				a, b := out[i].keys[k], out[j].keys[k]
+				c := compareNullsFirst(a, b)
+				if c == 0 {
+					continue
+				}
+				return c < 0 != item.desc
+			}
+			return false
+		})
+	}
+	if st.limit >= 0 {
+		// The sum of offset and limit may overflow
+		start := min(st.offset, len(out))
+		out = out[start : start+min(st.limit, len(out)-start)]
+	}
+
+	res := &Result{Columns: make([]*Column, len(fields))}
+	for i, f := range fields {
+		res.Columns[i] = resultColumn(ctx, f, out, i)
+	}
+	for _, r := range out {
+		row := make([]*string, len(r.values))
+		for i, v := range r.values {
+			row[i] = v.ptr()
+		}
+		res.Rows = append(res.Rows, row)
+	}
+
+	if st.union != nil {
+		next, err := s.runSelect(st.union)
+		if err != nil {
+			return nil, err
+		}
+		if len(next.Columns) != len(res.Columns) {
+			return nil, errorf(1222, "21000", "The used SELECT statements have a different number of columns")
+		}
+		if resultSize(res)+resultSize(next) > maxResultBytes {
+			return nil, errOutOfMemory
+		}
+		res.Rows = append(res.Rows, next.Rows...)
+		if !st.unionAll {
+			res.Rows = distinctRows(res.Rows)
+		}
+	}
+	return res, nil
+}
+
+// resultSize returns the bytes of the values of res
+func resultSize(res *Result) int {
+	n := 0
+	for _, row := range res.Rows {
+		n += int(rowSize(row))
+	}
+	return n
+}
+
+// orderKey returns the sort key of an ORDER BY item for a row: a column of
+// the result by position or name, or an expression
+func orderKey(ctx *evalCtx, x expr, fields []*selectField, values []value) (value, error) {
+	switch x := x.(type) {
+	case *literal:
+		if x.v.num {
+			n := int(x.v.float())
+			if n < 1 || n > len(values) {
+				return null, errorf(1054, "42S22", "Unknown column '%s' in 'order clause'", x.v.s)
+			}
+			return values[n-1], nil
+		}
+	case *columnRef:
+		if x.table == "" {
+			for i, f := range fields {
+				if strings.EqualFold(f.name, x.name) {
+					return values[i], nil
+				}
+			}
+		}
+	}
+	return ctx.eval(x)
+}
+
+// compareNullsFirst compares sort keys, NULL sorting first like in MySQL
+func compareNullsFirst(a, b value) int {
+	switch {
+	case a.null && b.null:
+		return 0
+	case a.null:
+		return -1
+	case b.null:
+		return 1
+	}
+	return compare(a, b)
+}
+
+func rowKey(values []*string) string {
+	var b strings.Builder
+	for _, v := range values {
+		if v == nil {
+			b.WriteString("\x00NULL")
+		} else {
+			b.WriteString(strings.ToLower(*v))
+		}
+		b.WriteByte(0)
+	}
+	return b.String()
+}
+
+func distinct(rows []outputRow) []outputRow {
+	seen := make(map[string]bool)
+	var out []outputRow
+	for _, r := range rows {
+		ptrs := make([]*string, len(r.values))
+		for i, v := range r.values {
+			ptrs[i] = v.ptr()
+		}
+		if k := rowKey(ptrs); !seen[k] {
+			seen[k] = true
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+func distinctRows(rows [][]*string) [][]*string {
+	seen := make(map[string]bool)
+	var out [][]*string
+	for _, r := range rows {
+		if k := rowKey(r); !seen[k] {
+			seen[k] = true
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// resultColumn describes the column of a result for field i: the table's
+// column for a column reference, else a type guessed from the values
+func resultColumn(ctx *evalCtx, f *selectField, rows []outputRow, i int) *Column {
+	if ref, ok := f.x.(*columnRef); ok && ctx.table != nil {
+		if j := ctx.table.Column(ref.name); j >= 0 {
+			c := *ctx.table.Columns[j]
+			c.Name, c.Schema, c.Table = f.name, ctx.db, ctx.alias
+			return &c
+		}
+	}
+	c := &Column{Name: f.name, Type: "varchar(255)", Null: true}
+	numeric, seen := true, false
+	for _, r := range rows {
+		if v := r.values[i]; !v.null {
+			seen = true
+			numeric = numeric && v.num
+		}
+	}
+	if seen && numeric {
+		c.Type = "bigint(21)"
+		for _, r := range rows {
+			if strings.ContainsAny(r.values[i].s, ".e") {
+				c.Type = "double"
+			}
+		}
+	}
+	return c
+}
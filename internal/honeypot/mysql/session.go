@@ -0,0 +1,857 @@
+package mysql
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Session is the state of a connection after login: its database and
+// variables, and its own view of the schema. Statements that write change
+// only that view, so attackers see their tables and rows while every other
+// session still sees the configured schema.
+type Session struct {
+	Schema       *Schema
+	Version      string // Server version, e.g. 5.7.35-0ubuntu0.18.04.1
+	User         string
+	Host         string // Client address, for USER() and errors
+	Hostname     string // Server host name
+	Database     string // Current database; empty for none
+	ConnectionID uint32
+	Quota        int64 // Bytes of rows the session may insert or update; 0 is no limit
+
+	written      int64             // Bytes of rows inserted or updated
+	vars         map[string]string // Variables set by the client
+	userVars     map[string]value
+	lastInsertID uint64
+	sleep        func(time.Duration) // time.Sleep if nil
+}
+
+// errSecureFilePriv refuses to read or write server files, as a server
+// configured with secure_file_priv does
+var errSecureFilePriv = errorf(1290, "HY000", "The MySQL server is running with the --secure-file-priv option so it cannot execute this statement")
+
+// maxAllowedPacket is the longest string functions build, as the server's
+// max_allowed_packet limits them
+const maxAllowedPacket = 16 << 20
+
+// groupConcatMaxLen is where GROUP_CONCAT cuts its result, the default of
+// group_concat_max_len
+const groupConcatMaxLen = 1024
+
+// maxResultBytes bounds the data of a result set, which is built in memory
+// rather than streamed
+const maxResultBytes = 32 << 20
+
+// errOutOfMemory fails statements whose result would exceed maxResultBytes
+var errOutOfMemory = errorf(1041, "HY000", "Out of memory; check if mysqld or some other process uses all available memory; if not, you may have to use 'ulimit' to allow mysqld to use more memory or you can add more swap space")
+
+// started is when uptime counts from
+var started = time.Now()
+
+// okStatements are the statements that succeed without effect: transactions,
+// privileges and administration
+var okStatements = make(map[string]bool)
+
+func init() {
+	for _, kw := range strings.Fields(`ALTER ANALYZE BEGIN BINLOG CACHE CHANGE CHECK CHECKSUM COMMIT
+		DEALLOCATE EXECUTE FLUSH GRANT HANDLER HELP INSTALL KILL LOCK OPTIMIZE PREPARE PURGE RELEASE
+		RENAME REPAIR RESET REVOKE ROLLBACK SAVEPOINT SHUTDOWN START STOP UNINSTALL UNLOCK XA`) {
+		okStatements[kw] = true
+	}
+}
+
+// product is the name of the server in messages
+func (s *Session) product() string {
+	if strings.Contains(s.Version, "MariaDB") {
+		return "MariaDB"
+	}
+	return "MySQL"
+}
+
+func (s *Session) pause(d time.Duration) {
+	if s.sleep != nil {
+		s.sleep(d)
+	} else {
+		time.Sleep(d)
+	}
+}
+
+// Query runs a statement. It returns a result set, or nil for statements
+// answered with OK and the number of rows they affected. Errors are *Error.
+func (s *Session) Query(q string) (*Result, uint64, error) {
+	toks, pos, ok := lex(q)
+	if !ok {
+		return nil, 0, syntaxError(q, pos, s.product())
+	}
+	p := &parser{q: q, toks: toks, product: s.product()}
+	if p.peek().kind == tokEOF {
+		return nil, 0, errorf(1065, "42000", "Query was empty")
+	}
+	res, affected, err := s.exec(p)
+	var e *Error
+	if err != nil && !errors.As(err, &e) {
+		err = errorf(1105, "HY000", "Unknown error")
+	}
+	return res, affected, err
+}
+
+// end checks that the statement is complete
+func (p *parser) end() error {
+	p.acceptOp(";")
+	if p.peek().kind != tokEOF {
+		return p.errSyntax()
+	}
+	return nil
+}
+
+func (s *Session) exec(p *parser) (*Result, uint64, error) {
+	t := p.peek()
+	if t.kind != tokIdent {
+		return nil, 0, p.errSyntax()
+	}
+	switch kw := strings.ToUpper(t.text); kw {
+	case "SELECT":
+		st, err := p.parseSelect()
+		if err != nil {
+			return nil, 0, err
+		}
+		if err := p.end(); err != nil {
+			return nil, 0, err
+		}
+		res, err := s.runSelect(st)
+		return res, 0, err
+	case "SHOW":
+		p.i++
+		res, err := s.show(p)
+		return res, 0, err
+	case "DESCRIBE", "DESC", "EXPLAIN":
+		p.i++
+		res, err := s.describe(p)
+		return res, 0, err
+	case "USE":
+		p.i++
+		name, err := p.ident()
+		if err != nil {
+			return nil, 0, err
+		}
+		if err := p.end(); err != nil {
+			return nil, 0, err
+		}
+		return nil, 0, s.UseDatabase(name)
+	case "SET":
+		p.i++
+		return nil, 0, s.set(p)
+	case "DO":
+		p.i++
+		list, err := p.exprList()
+		if err != nil {
+			return nil, 0, err
+		}
+		if err := p.end(); err != nil {
+			return nil, 0, err
+		}
+		ctx := &evalCtx{s: s, clause: "field list"}
+		for _, x := range list {
+			if _, err := ctx.eval(x); err != nil {
+				return nil, 0, err
+			}
+		}
+		return nil, 0, nil
+	case "CREATE":
+		p.i++
+		return s.create(p)
+	case "DROP":
+		p.i++
+		return s.drop(p)
+	case "INSERT", "REPLACE":
+		p.i++
+		return s.insert(p, kw == "REPLACE")
+	case "UPDATE":
+		p.i++
+		return s.update(p)
+	case "DELETE":
+		p.i++
+		return s.delete(p)
+	case "TRUNCATE":
+		p.i++
+		return s.truncate(p)
+	case "CALL":
+		p.i++
+		db, name, err := p.tableName()
+		if err != nil {
+			return nil, 0, err
+		}
+		if db == "" {
+			db = s.Database
+		}
+		if db == "" {
+			return nil, 0, errorf(1046, "3D000", "No database selected")
+		}
+		return nil, 0, errorf(1305, "42000", "PROCEDURE %s.%s does not exist", db, name)
+	case "LOAD":
+		p.i++
+		if p.accept("DATA") || p.accept("XML") {
+			if !p.accept("LOW_PRIORITY") {
+				p.accept("CONCURRENT")
+			}
+			if p.accept("LOCAL") {
+				return nil, 0, errorf(1148, "42000", "The used command is not allowed with this %s version", s.product())
+			}
+			return nil, 0, errSecureFilePriv
+		}
+		p.skipRest()
+		return nil, 0, nil
+	}
+	if okStatements[strings.ToUpper(t.text)] {
+		p.skipRest()
+		return nil, 0, nil
+	}
+	return nil, 0, p.errSyntax()
+}
+
+// LastInsertID returns the AUTO_INCREMENT value the last INSERT generated,
+// for the OK packet
+func (s *Session) LastInsertID() uint64 {
+	return s.lastInsertID
+}
+
+// Statistics returns the status line of COM_STATISTICS
+func (s *Session) Statistics() string {
+	return fmt.Sprintf("Uptime: %d  Threads: 2  Questions: %d  Slow queries: 0  Opens: 167  Flush tables: 3  Open tables: 86  Queries per second avg: 0.004",
+		int(time.Since(started).Seconds()), 1200+s.ConnectionID)
+}
+
+// UseDatabase makes name the current database
+func (s *Session) UseDatabase(name string) error {
+	db := s.Schema.Database(name)
+	if db == nil {
+		return errorf(1049, "42000", "Unknown database '%s'", name)
+	}
+	s.Database = db.Name
+	return nil
+}
+
+// FieldList returns the columns of a table of the current database, for
+// COM_FIELD_LIST
+func (s *Session) FieldList(table string) ([]*Column, error) {
+	db, t, err := s.table("", table)
+	if err != nil {
+		return nil, err
+	}
+	columns := make([]*Column, len(t.Columns))
+	for i, c := range t.Columns {
+		col := *c
+		col.Schema, col.Table = db, t.Name
+		columns[i] = &col
+	}
+	return columns, nil
+}
+
+// table returns a table and the name of its database, the current one if
+// db is empty
+func (s *Session) table(db, name string) (string, *Table, error) {
+	if db == "" {
+		db = s.Database
+	}
+	if db == "" {
+		return "", nil, errorf(1046, "3D000", "No database selected")
+	}
+	d := s.Schema.Database(db)
+	if d == nil {
+		return "", nil, errorf(1146, "42S02", "Table '%s.%s' doesn't exist", db, name)
+	}
+	t := d.Table(name)
+	if t == nil {
+		return "", nil, errorf(1146, "42S02", "Table '%s.%s' doesn't exist", db, name)
+	}
+	return d.Name, t, nil
+}
+
+// set runs SET for variables, character sets and transactions
+func (s *Session) set(p *parser) error {
+	if p.accept("NAMES") || p.accept("CHARSET") || p.peek().is("CHARACTER") && p.peekAt(1).is("SET") {
+		p.accept("CHARACTER")
+		p.accept("SET")
+		t := p.next()
+		if t.kind != tokIdent && t.kind != tokString && t.kind != tokQuotedIdent {
+			return syntaxError(p.q, t.pos, p.product)
+		}
+		p.skipRest()
+		s.setVar("character_set_client", strings.ToLower(t.text))
+		s.setVar("character_set_connection", strings.ToLower(t.text))
+		s.setVar("character_set_results", strings.ToLower(t.text))
+		return nil
+	}
+	if p.peek().is("GLOBAL") || p.peek().is("SESSION") {
+		if p.peekAt(1).is("TRANSACTION") {
+			p.skipRest()
+			return nil
+		}
+	}
+	if p.accept("PASSWORD") || p.accept("TRANSACTION") || p.accept("ROLE") || p.accept("DEFAULT") {
+		p.skipRest()
+		return nil
+	}
+
+	ctx := &evalCtx{s: s, clause: "field list"}
+	for {
+		t := p.next()
+		name, user := "", false
+		switch {
+		case t.kind == tokUserVar:
+			name, user = t.text, true
+		case t.kind == tokSysVar:
+			name = t.text
+			for _, scope := range []string{"global.", "session.", "local.", "persist."} {
+				name = strings.TrimPrefix(name, scope)
+			}
+		case t.kind == tokIdent:
+			if t.is("GLOBAL") || t.is("SESSION") || t.is("LOCAL") || t.is("PERSIST") {
+				t = p.next()
+			}
+			if t.kind != tokIdent {
+				return syntaxError(p.q, t.pos, p.product)
+			}
+			name = strings.ToLower(t.text)
+		default:
+			return syntaxError(p.q, t.pos, p.product)
+		}
+		if !p.acceptOp("=") && !p.acceptOp(":=") {
+			return p.errSyntax()
+		}
+		x, err := p.parseExpr()
+		if err != nil {
+			return err
+		}
+		var v value
+		// Bare words such as ON or utf8 are values, not columns
+		if ref, ok := x.(*columnRef); ok && ref.table == "" {
+			v = str(ref.name)
+		} else if v, err = ctx.eval(x); err != nil {
+			return err
+		}
+		if user {
+			if s.userVars == nil {
+				s.userVars = make(map[string]value)
+			}
+			s.userVars[name] = v
+		} else {
+			if _, ok := s.variables()[name]; !ok {
+				return errorf(1193, "HY000", "Unknown system variable '%s'", name)
+			}
+			if v.null {
+				v.s = ""
+			}
+			s.setVar(name, v.s)
+		}
+		if !p.acceptOp(",") {
+			return p.end()
+		}
+	}
+}
+
+func (s *Session) setVar(name, v string) {
+	if s.vars == nil {
+		s.vars = make(map[string]string)
+	}
+	s.vars[name] = v
+}
+
+// variables returns the system variables: the server's, then those the
+// client set
+func (s *Session) variables() map[string]string {
+	v8 := strings.HasPrefix(s.Version, "8.")
+	mariadb := strings.Contains(s.Version, "MariaDB")
+	comment, charset, collation, plugin := "MySQL Community Server - GPL", "utf8", "utf8_general_ci", PluginNative
+	switch {
+	case mariadb:
+		comment = "mariadb.org binary distribution"
+	case strings.Contains(s.Version, "ubuntu"):
+		comment = "(Ubuntu)"
+	}
+	if v8 {
+		charset, collation, plugin = "utf8mb4", "utf8mb4_0900_ai_ci", PluginCachingSHA2
+	}
+	number, _, _ := strings.Cut(s.Version, "-")
+	hostname := s.Hostname
+	if hostname == "" {
+		hostname = "localhost"
+	}
+	vars := map[string]string{
+		"auto_increment_increment":        "1",
+		"auto_increment_offset":           "1",
+		"autocommit":                      "1",
+		"basedir":                         "/usr/",
+		"character_set_client":            charset,
+		"character_set_connection":        charset,
+		"character_set_database":          charset,
+		"character_set_results":           charset,
+		"character_set_server":            charset,
+		"character_set_system":            "utf8",
+		"collation_connection":            collation,
+		"collation_database":              collation,
+		"collation_server":                collation,
+		"datadir":                         "/var/lib/mysql/",
+		"default_authentication_plugin":   plugin,
+		"general_log":                     "OFF",
+		"group_concat_max_len":            "1024",
+		"have_ssl":                        "DISABLED",
+		"hostname":                        hostname,
+		"init_connect":                    "",
+		"innodb_version":                  number,
+		"interactive_timeout":             "28800",
+		"license":                         "GPL",
+		"log_bin":                         "OFF",
+		"lower_case_table_names":          "0",
+		"max_allowed_packet":              "16777216",
+		"max_connections":                 "151",
+		"net_buffer_length":               "16384",
+		"net_write_timeout":               "60",
+		"performance_schema":              "ON",
+		"pid_file":                        "/var/run/mysqld/mysqld.pid",
+		"plugin_dir":                      "/usr/lib/mysql/plugin/",
+		"port":                            "3306",
+		"protocol_version":                "10",
+		"query_cache_size":                "0",
+		"query_cache_type":                "OFF",
+		"secure_file_priv":                "/var/lib/mysql-files/",
+		"server_id":                       "1",
+		"skip_networking":                 "OFF",
+		"socket":                          "/var/run/mysqld/mysqld.sock",
+		"sql_mode":                        "ONLY_FULL_GROUP_BY,STRICT_TRANS_TABLES,NO_ZERO_IN_DATE,NO_ZERO_DATE,ERROR_FOR_DIVISION_BY_ZERO,NO_ENGINE_SUBSTITUTION",
+		"sql_select_limit":                "18446744073709551615",
+		"system_time_zone":                "UTC",
+		"time_zone":                       "SYSTEM",
+		"tmpdir":                          "/tmp",
+		"transaction_isolation":           "REPEATABLE-READ",
+		"tx_isolation":                    "REPEATABLE-READ",
+		"version":                         s.Version,
+		"version_comment":                 comment,
+		"version_compile_machine":         "x86_64",
+		"version_compile_os":              "Linux",
+		"wait_timeout":                    "28800",
+		"require_secure_transport":        "OFF",
+		"local_infile":                    "OFF",
+		"log_error":                       "/var/log/mysql/error.log",
+		"slow_query_log":                  "OFF",
+		"default_storage_engine":          "InnoDB",
+		"explicit_defaults_for_timestamp": "ON",
+	}
+	if mariadb {
+		vars["secure_file_priv"] = ""
+		vars["version_compile_os"] = "debian-linux-gnu"
+	}
+	for name, v := range s.vars {
+		vars[name] = v
+	}
+	return vars
+}
+
+// filter parses the LIKE or WHERE clause of SHOW and applies it to res. LIKE
+// matches the first column.
+func (s *Session) filter(p *parser, res *Result) (*Result, error) {
+	var where expr
+	switch {
+	case p.accept("LIKE"):
+		t := p.next()
+		if t.kind != tokString {
+			return nil, syntaxError(p.q, t.pos, p.product)
+		}
+		where = &likeExpr{x: &columnRef{name: res.Columns[0].Name}, pattern: &literal{v: str(t.text)}}
+	case p.accept("WHERE"):
+		var err error
+		if where, err = p.parseExpr(); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.end(); err != nil {
+		return nil, err
+	}
+	if where == nil {
+		return res, nil
+	}
+	ctx := &evalCtx{s: s, table: &Table{Columns: res.Columns}, clause: "where clause"}
+	rows := res.Rows
+	res.Rows = nil
+	for _, row := range rows {
+		ctx.row = row
+		v, err := ctx.eval(where)
+		if err != nil {
+			return nil, err
+		}
+		if v.truth() {
+			res.Rows = append(res.Rows, row)
+		}
+	}
+	return res, nil
+}
+
+// newResult returns an empty result with text columns
+func newResult(names ...string) *Result {
+	res := &Result{}
+	for _, name := range names {
+		res.Columns = append(res.Columns, &Column{Name: name, Type: "varchar(64)", Null: true})
+	}
+	return res
+}
+
+func (r *Result) add(values ...string) {
+	row := make([]*string, len(values))
+	for i := range values {
+		row[i] = &values[i]
+	}
+	r.Rows = append(r.Rows, row)
+}
+
+// fromDB parses the optional FROM or IN clause naming a database
+func (p *parser) fromDB() (string, error) {
+	if p.accept("FROM") || p.accept("IN") {
+		return p.ident()
+	}
+	return "", nil
+}
+
+func (s *Session) show(p *parser) (*Result, error) {
+	full := p.accept("FULL")
+	if !p.accept("GLOBAL") && !p.accept("SESSION") {
+		p.accept("LOCAL")
+	}
+	switch {
+	case p.accept("DATABASES"), p.accept("SCHEMAS"):
+		res := newResult("Database")
+		for _, name := range s.Schema.Names() {
+			res.add(name)
+		}
+		return s.filter(p, res)
+	case p.accept("TABLES"):
+		name, err := p.fromDB()
+		if err != nil {
+			return nil, err
+		}
+		if name == "" {
+			name = s.Database
+		}
+		if name == "" {
+			return nil, errorf(1046, "3D000", "No database selected")
+		}
+		db := s.Schema.Database(name)
+		if db == nil {
+			return nil, errorf(1049, "42000", "Unknown database '%s'", name)
+		}
+		res := newResult("Tables_in_" + db.Name)
+		if full {
+			res = newResult("Tables_in_"+db.Name, "Table_type")
+		}
+		names := make([]string, 0, len(db.Tables))
+		for _, t := range db.Tables {
+			names = append(names, t.Name)
+		}
+		sort.Strings(names)
+		for _, t := range names {
+			if full {
+				typ := "BASE TABLE"
+				if db.Name == infoSchema {
+					typ = "SYSTEM VIEW"
+				}
+				res.add(t, typ)
+			} else {
+				res.add(t)
+			}
+		}
+		return s.filter(p, res)
+	case p.accept("COLUMNS"), p.accept("FIELDS"):
+		if !p.accept("FROM") {
+			if err := p.expect("IN"); err != nil {
+				return nil, err
+			}
+		}
+		db, name, err := p.tableName()
+		if err != nil {
+			return nil, err
+		}
+		if in, err := p.fromDB(); err != nil {
+			return nil, err
+		} else if in != "" {
+			db = in
+		}
+		res, err := s.columns(db, name, full)
+		if err != nil {
+			return nil, err
+		}
+		return s.filter(p, res)
+	case p.accept("INDEX"), p.accept("INDEXES"), p.accept("KEYS"):
+		if !p.accept("FROM") {
+			if err := p.expect("IN"); err != nil {
+				return nil, err
+			}
+		}
+		db, name, err := p.tableName()
+		if err != nil {
+			return nil, err
+		}
+		if in, err := p.fromDB(); err != nil {
+			return nil, err
+		} else if in != "" {
+			db = in
+		}
+		_, t, err := s.table(db, name)
+		if err != nil {
+			return nil, err
+		}
+		res := newResult("Table", "Non_unique", "Key_name", "Seq_in_index", "Column_name", "Collation", "Cardinality", "Index_type")
+		for _, c := range t.Columns {
+			switch c.Key {
+			case "PRI":
+				res.add(t.Name, "0", "PRIMARY", "1", c.Name, "A", strconv.Itoa(len(t.Rows)), "BTREE")
+			case "UNI":
+				res.add(t.Name, "0", c.Name, "1", c.Name, "A", strconv.Itoa(len(t.Rows)), "BTREE")
+			case "MUL":
+				res.add(t.Name, "1", c.Name, "1", c.Name, "A", strconv.Itoa(len(t.Rows)), "BTREE")
+			}
+		}
+		return s.filter(p, res)
+	case p.accept("CREATE"):
+		return s.showCreate(p)
+	case p.accept("VARIABLES"):
+		res := newResult("Variable_name", "Value")
+		vars := s.variables()
+		names := make([]string, 0, len(vars))
+		for name := range vars {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			res.add(name, vars[name])
+		}
+		return s.filter(p, res)
+	case p.accept("STATUS"):
+		uptime := strconv.Itoa(int(time.Since(started).Seconds()))
+		res := newResult("Variable_name", "Value")
+		res.add("Aborted_clients", "3")
+		res.add("Aborted_connects", "41")
+		res.add("Bytes_received", "1873544")
+		res.add("Bytes_sent", "20894731")
+		res.add("Connections", strconv.Itoa(int(s.ConnectionID)))
+		res.add("Max_used_connections", "12")
+		res.add("Open_tables", "114")
+		res.add("Queries", "48213")
+		res.add("Questions", "48177")
+		res.add("Slow_queries", "0")
+		res.add("Threads_connected", "1")
+		res.add("Threads_running", "1")
+		res.add("Uptime", uptime)
+		return s.filter(p, res)
+	case p.accept("TABLE"):
+		if err := p.expect("STATUS"); err != nil {
+			return nil, err
+		}
+		name, err := p.fromDB()
+		if err != nil {
+			return nil, err
+		}
+		if name == "" {
+			name = s.Database
+		}
+		if name == "" {
+			return nil, errorf(1046, "3D000", "No database selected")
+		}
+		db := s.Schema.Database(name)
+		if db == nil {
+			return nil, errorf(1049, "42000", "Unknown database '%s'", name)
+		}
+		res := newResult("Name", "Engine", "Version", "Row_format", "Rows", "Collation", "Comment")
+		for _, t := range db.Tables {
+			res.add(t.Name, "InnoDB", "10", "Dynamic", strconv.Itoa(len(t.Rows)), s.variables()["collation_server"], "")
+		}
+		return s.filter(p, res)
+	case p.accept("GRANTS"):
+		p.skipRest()
+		res := newResult(fmt.Sprintf("Grants for %s@%%", s.User))
+		res.add(fmt.Sprintf("GRANT ALL PRIVILEGES ON *.* TO '%s'@'%%' WITH GRANT OPTION", s.User))
+		return res, nil
+	case p.accept("PROCESSLIST"):
+		res := newResult("Id", "User", "Host", "db", "Command", "Time", "State", "Info")
+		res.add("1", "event_scheduler", "localhost", "", "Daemon", strconv.Itoa(int(time.Since(started).Seconds())), "Waiting on empty queue", "")
+		res.Rows[0][3], res.Rows[0][7] = nil, nil
+		res.add(strconv.Itoa(int(s.ConnectionID)), s.User, s.Host, s.Database, "Query", "0", "starting", "SHOW PROCESSLIST")
+		if s.Database == "" {
+			res.Rows[1][3] = nil
+		}
+		return res, p.end()
+	case p.accept("WARNINGS"), p.accept("ERRORS"):
+		p.skipRest()
+		return newResult("Level", "Code", "Message"), nil
+	case p.accept("ENGINES"), p.accept("STORAGE") && p.accept("ENGINES"):
+		res := newResult("Engine", "Support", "Comment", "Transactions", "XA", "Savepoints")
+		res.add("InnoDB", "DEFAULT", "Supports transactions, row-level locking, and foreign keys", "YES", "YES", "YES")
+		res.add("MyISAM", "YES", "MyISAM storage engine", "NO", "NO", "NO")
+		res.add("MEMORY", "YES", "Hash based, stored in memory, useful for temporary tables", "NO", "NO", "NO")
+		res.add("CSV", "YES", "CSV storage engine", "NO", "NO", "NO")
+		return s.filter(p, res)
+	}
+	return nil, p.errSyntax()
+}
+
+// columns describes a table for SHOW COLUMNS and DESCRIBE
+func (s *Session) columns(db, name string, full bool) (*Result, error) {
+	_, t, err := s.table(db, name)
+	if err != nil {
+		return nil, err
+	}
+	res := newResult("Field", "Type", "Null", "Key", "Default", "Extra")
+	if full {
+		res = newResult("Field", "Type", "Collation", "Null", "Key", "Default", "Extra", "Privileges", "Comment")
+	}
+	for _, c := range t.Columns {
+		null := "NO"
+		if c.Null {
+			null = "YES"
+		}
+		if full {
+			collation := s.variables()["collation_server"]
+			if c.wireType().charset != charsetUTF8 {
+				collation = ""
+			}
+			res.add(c.Name, c.ColumnType(), collation, null, c.Key, "", c.Extra, "select,insert,update,references", "")
+			res.Rows[len(res.Rows)-1][5] = c.Default
+			if collation == "" {
+				res.Rows[len(res.Rows)-1][2] = nil
+			}
+		} else {
+			res.add(c.Name, c.ColumnType(), null, c.Key, "", c.Extra)
+			res.Rows[len(res.Rows)-1][4] = c.Default
+		}
+	}
+	return res, nil
+}
+
+// describe runs DESCRIBE table and EXPLAIN
+func (s *Session) describe(p *parser) (*Result, error) {
+	if t := p.peek(); t.is("SELECT") || t.is("INSERT") || t.is("UPDATE") || t.is("DELETE") || t.is("REPLACE") {
+		p.skipRest()
+		res := newResult("id", "select_type", "table", "type", "possible_keys", "key", "key_len", "ref", "rows", "Extra")
+		res.add("1", "SIMPLE", "", "", "", "", "", "", "", "No tables used")
+		for i := 2; i < 9; i++ {
+			res.Rows[0][i] = nil
+		}
+		return res, nil
+	}
+	db, name, err := p.tableName()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind == tokIdent || t.kind == tokQuotedIdent || t.kind == tokString {
+		// DESCRIBE table column
+		p.i++
+		res, err := s.columns(db, name, false)
+		if err != nil {
+			return nil, err
+		}
+		var rows [][]*string
+		for _, row := range res.Rows {
+			if likeMatch(t.text, *row[0]) {
+				rows = append(rows, row)
+			}
+		}
+		res.Rows = rows
+		return res, p.end()
+	}
+	if err := p.end(); err != nil {
+		return nil, err
+	}
+	return s.columns(db, name, false)
+}
+
+// showCreate runs SHOW CREATE TABLE and SHOW CREATE DATABASE
+func (s *Session) showCreate(p *parser) (*Result, error) {
+	if p.accept("DATABASE") || p.accept("SCHEMA") {
+		if p.accept("IF") {
+			for _, kw := range []string{"NOT", "EXISTS"} {
+				if err := p.expect(kw); err != nil {
+					return nil, err
+				}
+			}
+		}
+		name, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.end(); err != nil {
+			return nil, err
+		}
+		db := s.Schema.Database(name)
+		if db == nil {
+			return nil, errorf(1049, "42000", "Unknown database '%s'", name)
+		}
+		vars := s.variables()
+		res := newResult("Database", "Create Database")
+		res.add(db.Name, fmt.Sprintf("CREATE DATABASE `%s` /*!40100 DEFAULT CHARACTER SET %s */", db.Name, vars["character_set_server"]))
+		return res, nil
+	}
+	if err := p.expect("TABLE"); err != nil {
+		return nil, err
+	}
+	db, name, err := p.tableName()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.end(); err != nil {
+		return nil, err
+	}
+	_, t, err := s.table(db, name)
+	if err != nil {
+		return nil, err
+	}
+	res := newResult("Table", "Create Table")
+	res.add(t.Name, s.createTable(t))
+	return res, nil
+}
+
+// createTable returns the CREATE TABLE statement of t
+func (s *Session) createTable(t *Table) string {
+	var lines, keys []string
+	autoIncrement := -1
+	for i, c := range t.Columns {
+		line := fmt.Sprintf("  `%s` %s", c.Name, c.ColumnType())
+		if !c.Null {
+			line += " NOT NULL"
+		}
+		switch {
+		case c.Default != nil && (isNumericType(c.DataType()) || strings.EqualFold(*c.Default, "CURRENT_TIMESTAMP")):
+			line += " DEFAULT " + *c.Default
+		case c.Default != nil:
+			line += " DEFAULT '" + strings.ReplaceAll(*c.Default, "'", "''") + "'"
+		case c.Null && c.wireType().code != 0xfc:
+			line += " DEFAULT NULL"
+		}
+		if c.Extra != "" {
+			line += " " + strings.ToUpper(c.Extra)
+		}
+		if strings.Contains(strings.ToLower(c.Extra), "auto_increment") {
+			autoIncrement = i
+		}
+		lines = append(lines, line)
+		switch c.Key {
+		case "PRI":
+			keys = append([]string{fmt.Sprintf("  PRIMARY KEY (`%s`)", c.Name)}, keys...)
+		case "UNI":
+			keys = append(keys, fmt.Sprintf("  UNIQUE KEY `%s` (`%s`)", c.Name, c.Name))
+		case "MUL":
+			keys = append(keys, fmt.Sprintf("  KEY `%s` (`%s`)", c.Name, c.Name))
+		}
+	}
+	options := " ENGINE=InnoDB"
+	if autoIncrement >= 0 {
+		options += fmt.Sprintf(" AUTO_INCREMENT=%d", nextID(t, autoIncrement))
+	}
+	vars := s.variables()
+	options += " DEFAULT CHARSET=" + vars["character_set_server"]
+	if strings.HasPrefix(s.Version, "8.") {
+		options += " COLLATE=" + vars["collation_server"]
+	}
+	return fmt.Sprintf("CREATE TABLE `%s` (\n%s\n)%s", t.Name, strings.Join(append(lines, keys...), ",\n"), options)
+}
@@ -0,0 +1,252 @@
+package mysql
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestSession() *Session {
+	return &Session{
+		Schema:       NewSchema(Builtin()),
+		Version:      "5.7.35-0ubuntu0.18.04.1",
+		User:         "root",
+		Host:         "203.0.113.7",
+		Hostname:     "db01",
+		ConnectionID: 7,
+		sleep:        func(time.Duration) {},
+	}
+}
+
+// format renders the rows of res one per line, values separated by |
+func format(res *Result) string {
+	var lines []string
+	for _, row := range res.Rows {
+		values := make([]string, len(row))
+		for i, v := range row {
+			if v == nil {
+				values[i] = "NULL"
+			} else {
+				values[i] = *v
+			}
+		}
+		lines = append(lines, strings.Join(values, "|"))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestQueries(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"SELECT @@version_comment LIMIT 1", "(Ubuntu)"},
+		{"select version(), database(), user()", "5.7.35-0ubuntu0.18.04.1|NULL|root@203.0.113.7"},
+		{"SELECT 1+2*3, 7 DIV 2, 'a' 'b', CONCAT('x', NULL), IFNULL(NULL, 'y')", "7|3|ab|NULL|y"},
+		{"SELECT 0x61646d696e = 'ADMIN', 'abc' LIKE 'A_%', 3 BETWEEN 1 AND 2, 2 IN (1, 2), NULL IS NULL", "1|1|0|1|1"},
+		{"SELECT CASE WHEN 1 > 2 THEN 'a' ELSE 'b' END, CAST('12abc' AS SIGNED), HEX('A'), MD5('a'), SUBSTRING('hello', 2, 3)", "b|12|41|0cc175b9c0f1b6a831c399e269772661|ell"},
+		{"SHOW DATABASES", "information_schema\nmysql\nperformance_schema\nproduction\nsys\ntest\nwordpress"},
+		{"SHOW DATABASES LIKE '%pro%'", "production"},
+		{"SHOW TABLES FROM wordpress", "wp_options\nwp_posts\nwp_usermeta\nwp_users"},
+		{"SELECT user_login, user_email FROM wordpress.wp_users WHERE ID < 3 ORDER BY ID DESC", "editor|m.keller@example.com\nadmin|admin@example.com"},
+		{"SELECT COUNT(*), MAX(id), MIN(city) FROM production.customers", "16|1015|Berlin"},
+		{"SELECT status, COUNT(*) AS n FROM production.orders GROUP BY status ORDER BY n DESC, status LIMIT 1", "delivered|8"},
+		{"SELECT DISTINCT country FROM production.customers", "DE"},
+		{"SELECT schema_name FROM information_schema.schemata WHERE schema_name NOT IN ('information_schema', 'mysql', 'performance_schema', 'sys')", "production\ntest\nwordpress"},
+		{"SELECT table_name FROM information_schema.tables WHERE table_schema = database()", ""},
+		{"select column_name from INFORMATION_SCHEMA.COLUMNS where table_schema='production' and table_name='api_keys' limit 2 offset 2", "owner\napi_key"},
+		{"SELECT user, host FROM mysql.user WHERE user = (SELECT 'root')", "root|localhost"},
+		{"SELECT 1 UNION SELECT 1 UNION ALL SELECT 2", "1\n2"},
+		{"SELECT 1 LIMIT 1, 9223372036854775807", ""},
+		{"SELECT 1 LIMIT 9223372036854775807 OFFSET 9223372036854775807", ""},
+		{"SELECT 1 LIMIT 0, 9223372036854775807", "1"},
+		{"SELECT 1 UNION SELECT 2 LIMIT 9223372036854775807", "1\n2"},
+		{"SHOW VARIABLES LIKE 'secure_file_priv'", "secure_file_priv|/var/lib/mysql-files/"},
+		{"SHOW VARIABLES WHERE Variable_name = 'hostname'", "hostname|db01"},
+		{"SELECT SLEEP(5) /* blind injection */ # comment", "0"},
+		{"/*!40101 SELECT 42 */", "42"},
+		{"SELECT LOAD_FILE('/etc/passwd')", "NULL"},
+	}
+	for _, tt := range tests {
+		s := newTestSession()
+		res, _, err := s.Query(tt.query)
+		if err != nil {
+			t.Errorf("%s: %v", tt.query, err)
+			continue
+		}
+		if got := format(res); got != tt.want {
+			t.Errorf("%s:\n got %q\nwant %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestQueryErrors(t *testing.T) {
+	tests := []struct {
+		query string
+		code  uint16
+		msg   string
+	}{
+		{"", 1065, "Query was empty"},
+		{"SELEC 1", 1064, "near 'SELEC 1' at line 1"},
+		{"SELECT * FROM users", 1046, "No database selected"},
+		{"SELECT * FROM wordpress.users", 1146, "Table 'wordpress.users' doesn't exist"},
+		{"SELECT nope FROM wordpress.wp_users", 1054, "Unknown column 'nope' in 'field list'"},
+		{"USE customers", 1049, "Unknown database 'customers'"},
+		{"SELECT '<?php system($_GET[c]); ?>' INTO OUTFILE '/var/www/html/x.php'", 1290, "--secure-file-priv"},
+		{"SELECT 1 UNION SELECT 1, 2", 1222, "different number of columns"},
+		{"SELECT lib_mysqludf_sys_info()", 1305, "FUNCTION lib_mysqludf_sys_info does not exist"},
+		{"SELECT (SELECT user FROM mysql.user)", 1242, "Subquery returns more than 1 row"},
+		{"SELECT @@nonexistent", 1193, "Unknown system variable 'nonexistent'"},
+		{"DROP DATABASE information_schema", 1044, "Access denied for user 'root'@'203.0.113.7'"},
+		{"CREATE DATABASE wordpress", 1007, "database exists"},
+		{"DROP TABLE test.t", 1051, "Unknown table 'test.t'"},
+		{"SELECT 1; SELECT 2", 1064, "near 'SELECT 2' at line 1"},
+	}
+	for _, tt := range tests {
+		s := newTestSession()
+		_, _, err := s.Query(tt.query)
+		var e *Error
+		if !errors.As(err, &e) {
+			t.Errorf("%q: got %v, want error %d", tt.query, err, tt.code)
+			continue
+		}
+		if e.Code != tt.code || !strings.Contains(e.Message, tt.msg) {
+			t.Errorf("%q: got %d %q, want %d containing %q", tt.query, e.Code, e.Message, tt.code, tt.msg)
+		}
+	}
+}
+
+func TestSessionWrites(t *testing.T) {
+	s := newTestSession()
+	schema := s.Schema
+	steps := []struct {
+		query    string
+		affected uint64
+		want     string
+	}{
+		{"CREATE DATABASE loot", 1, ""},
+		{"USE loot", 0, ""},
+		{"CREATE TABLE creds (id INT NOT NULL AUTO_INCREMENT, user VARCHAR(64) NOT NULL, pass VARCHAR(64) DEFAULT 'x', PRIMARY KEY (id)) ENGINE=InnoDB", 0, ""},
+		{"INSERT INTO creds (user, pass) VALUES ('a', 'b'), ('c', NULL)", 2, ""},
+		{"INSERT INTO creds SET user = 'e'", 1, ""},
+		{"SELECT LAST_INSERT_ID(), id, user, pass FROM creds", 0, "3|1|a|b\n3|2|c|NULL\n3|3|e|x"},
+		{"UPDATE creds SET pass = UPPER(user) WHERE id > 1", 2, ""},
+		{"DELETE FROM creds WHERE user = 'a'", 1, ""},
+		{"SELECT * FROM creds", 0, "2|c|C\n3|e|E"},
+		{"SHOW COLUMNS FROM creds", 0, "id|int|NO|PRI|NULL|auto_increment\nuser|varchar(64)|NO||NULL|\npass|varchar(64)|YES||x|"},
+		{"SELECT table_rows FROM information_schema.tables WHERE table_schema = 'loot'", 0, "2"},
+		{"UPDATE mysql.user SET authentication_string = '' WHERE user = 'root'", 1, ""},
+		{"DROP DATABASE loot", 1, ""},
+		{"SELECT DATABASE()", 0, "NULL"},
+	}
+	for _, step := range steps {
+		res, affected, err := s.Query(step.query)
+		if err != nil {
+			t.Fatalf("%s: %v", step.query, err)
+		}
+		if affected != step.affected {
+			t.Errorf("%s: %d rows affected, want %d", step.query, affected, step.affected)
+		}
+		if res != nil && format(res) != step.want {
+			t.Errorf("%s:\n got %q\nwant %q", step.query, format(res), step.want)
+		}
+	}
+
+	// Other sessions see the schema unchanged
+	other := newTestSession()
+	other.Schema = schema
+	res, _, err := other.Query("SELECT authentication_string FROM mysql.user WHERE user = 'root'")
+	if err != nil || format(res) == "" {
+		t.Errorf("the write leaked to another session: %q, %v", format(res), err)
+	}
+}
+
+func TestSessionQuota(t *testing.T) {
+	s := newTestSession()
+	s.Quota = 4096
+	for _, q := range []string{"CREATE TABLE test.t (v VARCHAR(255))", "INSERT INTO test.t VALUES ('0123456789abcdef')"} {
+		if _, _, err := s.Query(q); err != nil {
+			t.Fatalf("%s: %v", q, err)
+		}
+	}
+	// Each copy doubles the table until it no longer fits
+	var err error
+	for i := 0; i < 20 && err == nil; i++ {
+		_, _, err = s.Query("INSERT INTO test.t SELECT * FROM test.t")
+	}
+	var e *Error
+	if !errors.As(err, &e) || e.Code != 1114 || e.Message != "The table 't' is full" {
+		t.Fatalf("got %v, want error 1114", err)
+	}
+	res, _, err := s.Query("SELECT COUNT(*) FROM test.t")
+	if err != nil || format(res) != "256" {
+		t.Errorf("got %q, %v; want the table as before the failed insert", format(res), err)
+	}
+	if _, _, err := s.Query("UPDATE test.t SET v = REPEAT('x', 255)"); !errors.As(err, &e) || e.Code != 1114 {
+		t.Errorf("UPDATE past the quota: got %v, want error 1114", err)
+	}
+	if _, affected, err := s.Query("DELETE FROM test.t"); err != nil || affected != 256 {
+		t.Errorf("DELETE: %d, %v", affected, err)
+	}
+}
+
+func TestQueryLimits(t *testing.T) {
+	s := newTestSession()
+	res, _, err := s.Query("SELECT REPEAT(REPEAT(REPEAT('a', 1024), 1024), 1024), LENGTH(REPEAT(REPEAT('a', 1024), 1024))")
+	if err != nil || format(res) != "NULL|1048576" {
+		t.Errorf("got %q, %v; want NULL past max_allowed_packet", format(res), err)
+	}
+	res, _, err = s.Query("SELECT LENGTH(GROUP_CONCAT(REPEAT(user_email, 100))) FROM wordpress.wp_users")
+	if err != nil || format(res) != "1024" {
+		t.Errorf("got %q, %v; want GROUP_CONCAT cut at 1024", format(res), err)
+	}
+	big := "SELECT REPEAT(REPEAT(REPEAT('a', 1024), 1024), 16)"
+	_, _, err = s.Query(big + " UNION ALL " + big + " UNION ALL " + big)
+	var e *Error
+	if !errors.As(err, &e) || e.Code != 1041 {
+		t.Errorf("got %v, want error 1041 for a result too large", err)
+	}
+}
+
+func TestShowCreateTable(t *testing.T) {
+	s := newTestSession()
+	res, _, err := s.Query("SHOW CREATE TABLE wordpress.wp_users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	create := *res.Rows[0][1]
+	for _, want := range []string{"CREATE TABLE `wp_users` (", "`ID` bigint(20) unsigned NOT NULL AUTO_INCREMENT", "PRIMARY KEY (`ID`)", "AUTO_INCREMENT=5", "DEFAULT CHARSET=utf8"} {
+		if !strings.Contains(create, want) {
+			t.Errorf("CREATE TABLE misses %q:\n%s", want, create)
+		}
+	}
+}
+
+func TestLoadDir(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("crm.json", `{"tables": [{"name": "leads", "columns": [{"name": "id", "type": "int"}, {"name": "email"}], "rows": [[1, "a@example.com"], [2, null]]}]}`)
+	dbs, err := LoadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newTestSession()
+	s.Schema = NewSchema(dbs)
+	res, _, err := s.Query("SELECT id FROM crm.leads WHERE email IS NULL")
+	if err != nil || format(res) != "2" {
+		t.Errorf("got %v, %v", res, err)
+	}
+
+	write("bad.json", `{"tables": [{"name": "t", "columns": [{"name": "a"}], "rows": [[1, 2]]}]}`)
+	if _, err := LoadDir(dir); err == nil || !strings.Contains(err.Error(), "bad.json") {
+		t.Errorf("got %v, want an error for bad.json", err)
+	}
+}
@@ -0,0 +1,611 @@
+package mysql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Error is an error the server reports to the client
+type Error struct {
+	Code    uint16
+	State   string // SQLSTATE
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("ERROR %d (%s): %s", e.Code, e.State, e.Message)
+}
+
+func errorf(code uint16, state, format string, args ...any) *Error {
+	return &Error{Code: code, State: state, Message: fmt.Sprintf(format, args...)}
+}
+
+// Result is a result set. NULL values are nil.
+type Result struct {
+	Columns []*Column
+	Rows    [][]*string
+}
+
+// reserved are the keywords that cannot name columns or be aliases
+// without quotes, among those the parser knows
+var reserved = make(map[string]bool)
+
+func init() {
+	for _, kw := range strings.Fields(`ALL AND AS ASC BETWEEN BY CASE CROSS DESC DISTINCT DIV ELSE END
+		EXISTS FOR FROM GROUP HAVING IN INNER INTO IS JOIN LEFT LIKE LIMIT LOCK MOD NATURAL NOT NULL
+		ON OR ORDER OUTER REGEXP RIGHT RLIKE SELECT SET STRAIGHT_JOIN THEN UNION USING VALUES WHEN
+		WHERE XOR`) {
+		reserved[kw] = true
+	}
+}
+
+// parser parses a statement from its tokens
+type parser struct {
+	q       string
+	toks    []token
+	i       int
+	product string // MySQL or MariaDB, for syntax errors
+}
+
+func (p *parser) peek() token { return p.toks[p.i] }
+
+// peekAt returns the token n after the next one
+func (p *parser) peekAt(n int) token {
+	if p.i+n < len(p.toks) {
+		return p.toks[p.i+n]
+	}
+	return p.toks[len(p.toks)-1]
+}
+
+func (p *parser) next() token {
+	t := p.toks[p.i]
+	if t.kind != tokEOF {
+		p.i++
+	}
+	return t
+}
+
+// accept consumes the next token if it is the keyword kw
+func (p *parser) accept(kw string) bool {
+	if p.peek().is(kw) {
+		p.i++
+		return true
+	}
+	return false
+}
+
+// acceptOp consumes the next token if it is op
+func (p *parser) acceptOp(op string) bool {
+	if p.peek().isOp(op) {
+		p.i++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(kw string) error {
+	if !p.accept(kw) {
+		return p.errSyntax()
+	}
+	return nil
+}
+
+func (p *parser) expectOp(op string) error {
+	if !p.acceptOp(op) {
+		return p.errSyntax()
+	}
+	return nil
+}
+
+// skipRest consumes the rest of a statement the persona does not run
+func (p *parser) skipRest() {
+	p.i = len(p.toks) - 1
+}
+
+// errSyntax reports a syntax error at the next token
+func (p *parser) errSyntax() error {
+	return syntaxError(p.q, p.peek().pos, p.product)
+}
+
+// syntaxError returns the error for a statement that cannot be parsed from
+// pos on
+func syntaxError(q string, pos int, product string) *Error {
+	near := q[pos:]
+	if len(near) > 80 {
+		near = near[:80]
+	}
+	line := strings.Count(q[:pos], "\n") + 1
+	return errorf(1064, "42000", "You have an error in your SQL syntax; check the manual that corresponds to your %s server version for the right syntax to use near '%s' at line %d", product, near, line)
+}
+
+// ident parses an identifier
+func (p *parser) ident() (string, error) {
+	t := p.peek()
+	if t.kind == tokQuotedIdent || t.kind == tokIdent && !reserved[strings.ToUpper(t.text)] {
+		p.i++
+		return t.text, nil
+	}
+	return "", p.errSyntax()
+}
+
+// tableName parses a table name, qualified or not
+func (p *parser) tableName() (db, name string, err error) {
+	if name, err = p.ident(); err != nil {
+		return "", "", err
+	}
+	if p.acceptOp(".") {
+		db = name
+		if name, err = p.ident(); err != nil {
+			return "", "", err
+		}
+	}
+	return db, name, nil
+}
+
+// alias parses an optional alias, with or without AS
+func (p *parser) alias() (string, error) {
+	if p.accept("AS") {
+		if t := p.peek(); t.kind == tokString {
+			p.i++
+			return t.text, nil
+		}
+		return p.ident()
+	}
+	switch t := p.peek(); {
+	case t.kind == tokQuotedIdent, t.kind == tokString:
+		p.i++
+		return t.text, nil
+	case t.kind == tokIdent && !reserved[strings.ToUpper(t.text)]:
+		p.i++
+		return t.text, nil
+	}
+	return "", nil
+}
+
+// Expressions, from the lowest precedence to the highest
+
+func (p *parser) parseExpr() (expr, error) {
+	l, err := p.parseXor()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("OR") || p.acceptOp("||") {
+		r, err := p.parseXor()
+		if err != nil {
+			return nil, err
+		}
+		l = &binaryExpr{op: "OR", l: l, r: r}
+	}
+	return l, nil
+}
+
+func (p *parser) parseXor() (expr, error) {
+	l, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("XOR") {
+		r, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l = &binaryExpr{op: "XOR", l: l, r: r}
+	}
+	return l, nil
+}
+
+func (p *parser) parseAnd() (expr, error) {
+	l, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("AND") || p.acceptOp("&&") {
+		r, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		l = &binaryExpr{op: "AND", l: l, r: r}
+	}
+	return l, nil
+}
+
+func (p *parser) parseNot() (expr, error) {
+	if p.accept("NOT") {
+		x, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryExpr{op: "NOT", x: x}, nil
+	}
+	return p.parseComparison()
+}
+
+var comparisons = []string{"=", "<=>", "<>", "!=", "<=", ">=", "<", ">"}
+
+func (p *parser) parseComparison() (expr, error) {
+	l, err := p.parseBinary(0)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		if t.kind == tokOp {
+			op := ""
+			for _, c := range comparisons {
+				if t.text == c {
+					op = c
+				}
+			}
+			if op == "" {
+				return l, nil
+			}
+			p.i++
+			r, err := p.parseBinary(0)
+			if err != nil {
+				return nil, err
+			}
+			l = &binaryExpr{op: op, l: l, r: r}
+			continue
+		}
+		if p.accept("IS") {
+			is := &isExpr{x: l, not: p.accept("NOT")}
+			switch {
+			case p.accept("NULL"), p.accept("UNKNOWN"):
+				is.what = "NULL"
+			case p.accept("TRUE"):
+				is.what = "TRUE"
+			case p.accept("FALSE"):
+				is.what = "FALSE"
+			default:
+				return nil, p.errSyntax()
+			}
+			l = is
+			continue
+		}
+		not := false
+		if t.is("NOT") {
+			if n := p.peekAt(1); !n.is("LIKE") && !n.is("IN") && !n.is("BETWEEN") && !n.is("REGEXP") && !n.is("RLIKE") {
+				return l, nil
+			}
+			p.i++
+			not = true
+		}
+		switch {
+		case p.accept("LIKE"):
+			r, err := p.parseBinary(0)
+			if err != nil {
+				return nil, err
+			}
+			if p.accept("ESCAPE") {
+				p.next()
+			}
+			l = &likeExpr{x: l, pattern: r, not: not}
+		case p.accept("REGEXP"), p.accept("RLIKE"):
+			r, err := p.parseBinary(0)
+			if err != nil {
+				return nil, err
+			}
+			l = &binaryExpr{op: "REGEXP", l: l, r: r}
+			if not {
+				l = &unaryExpr{op: "NOT", x: l}
+			}
+		case p.accept("BETWEEN"):
+			lo, err := p.parseBinary(0)
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("AND"); err != nil {
+				return nil, err
+			}
+			hi, err := p.parseBinary(0)
+			if err != nil {
+				return nil, err
+			}
+			l = &betweenExpr{x: l, lo: lo, hi: hi, not: not}
+		case p.accept("IN"):
+			in := &inExpr{x: l, not: not}
+			if err := p.expectOp("("); err != nil {
+				return nil, err
+			}
+			if p.peek().is("SELECT") {
+				if in.sub, err = p.parseSelect(); err != nil {
+					return nil, err
+				}
+			} else if in.list, err = p.exprList(); err != nil {
+				return nil, err
+			}
+			if err := p.expectOp(")"); err != nil {
+				return nil, err
+			}
+			l = in
+		default:
+			return l, nil
+		}
+	}
+}
+
+// binaryLevels are the arithmetic and bit operators by precedence
+var binaryLevels = [][]string{{"|"}, {"&"}, {"<<", ">>"}, {"+", "-"}, {"*", "/", "%", "DIV", "MOD"}, {"^"}}
+
+func (p *parser) parseBinary(level int) (expr, error) {
+	if level == len(binaryLevels) {
+		return p.parseUnary()
+	}
+	l, err := p.parseBinary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t, op := p.peek(), ""
+		for _, o := range binaryLevels[level] {
+			if t.isOp(o) || t.is(o) {
+				op = o
+			}
+		}
+		if op == "" {
+			return l, nil
+		}
+		p.i++
+		r, err := p.parseBinary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		l = &binaryExpr{op: op, l: l, r: r}
+	}
+}
+
+func (p *parser) parseUnary() (expr, error) {
+	t := p.peek()
+	for _, op := range []string{"-", "+", "~", "!"} {
+		if t.isOp(op) {
+			p.i++
+			x, err := p.parseUnary()
+			if err != nil {
+				return nil, err
+			}
+			if op == "!" {
+				op = "NOT"
+			}
+			return &unaryExpr{op: op, x: x}, nil
+		}
+	}
+	if t.is("BINARY") && p.peekAt(1).kind != tokEOF {
+		p.i++
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryExpr{op: "BINARY", x: x}, nil
+	}
+	x, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	if p.accept("COLLATE") {
+		if _, err := p.ident(); err != nil {
+			return nil, err
+		}
+	}
+	return x, nil
+}
+
+// niladic are the functions called without parentheses
+var niladic = map[string]bool{"CURRENT_USER": true, "CURRENT_TIMESTAMP": true, "CURRENT_DATE": true,
+	"LOCALTIME": true, "LOCALTIMESTAMP": true}
+
+func (p *parser) parsePrimary() (expr, error) {
+	t := p.next()
+	switch t.kind {
+	case tokNumber:
+		return &literal{v: value{s: t.text, num: true}}, nil
+	case tokString:
+		s := t.text
+		// Adjacent strings are one
+		for p.peek().kind == tokString {
+			s += p.next().text
+		}
+		return &literal{v: str(s)}, nil
+	case tokHex:
+		return &literal{v: str(t.text)}, nil
+	case tokSysVar:
+		return &sysVar{name: t.text}, nil
+	case tokUserVar:
+		return &userVar{name: t.text}, nil
+	case tokOp:
+		if !t.isOp("(") {
+			break
+		}
+		if p.peek().is("SELECT") {
+			sel, err := p.parseSelect()
+			if err != nil {
+				return nil, err
+			}
+			return &subquery{sel: sel}, p.expectOp(")")
+		}
+		x, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		return x, p.expectOp(")")
+	case tokIdent, tokQuotedIdent:
+		upper := strings.ToUpper(t.text)
+		if t.kind == tokIdent {
+			switch {
+			case upper == "NULL":
+				return &literal{v: null}, nil
+			case upper == "TRUE":
+				return &literal{v: num(1)}, nil
+			case upper == "FALSE":
+				return &literal{v: num(0)}, nil
+			case upper == "CASE":
+				return p.parseCase()
+			case upper == "EXISTS":
+				if err := p.expectOp("("); err != nil {
+					return nil, err
+				}
+				sel, err := p.parseSelect()
+				if err != nil {
+					return nil, err
+				}
+				return &existsExpr{sel: sel}, p.expectOp(")")
+			case (upper == "CAST" || upper == "CONVERT") && p.peek().isOp("("):
+				return p.parseCast(upper)
+			case (strings.HasPrefix(t.text, "_") || upper == "N") && p.peek().kind == tokString:
+				// A character set introducer such as _utf8'text'
+				return p.parsePrimary()
+			case p.peek().isOp("("):
+				return p.parseCall(upper)
+			case niladic[upper]:
+				return &call{name: upper}, nil
+			case reserved[upper]:
+				return nil, syntaxError(p.q, t.pos, p.product)
+			}
+		}
+		ref := &columnRef{name: t.text}
+		for p.peek().isOp(".") && p.peekAt(1).kind != tokOp {
+			p.i++
+			name, err := p.ident()
+			if err != nil {
+				return nil, err
+			}
+			ref.table, ref.name = ref.name, name
+		}
+		return ref, nil
+	}
+	return nil, syntaxError(p.q, t.pos, p.product)
+}
+
+// exprList parses expressions separated by commas
+func (p *parser) exprList() ([]expr, error) {
+	var list []expr
+	for {
+		x, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, x)
+		if !p.acceptOp(",") {
+			return list, nil
+		}
+	}
+}
+
+func (p *parser) parseCall(name string) (expr, error) {
+	p.i++ // (
+	c := &call{name: name}
+	if name == "COUNT" && p.acceptOp("*") {
+		c.star = true
+		return c, p.expectOp(")")
+	}
+	c.distinct = p.accept("DISTINCT")
+	if p.acceptOp(")") {
+		return c, nil
+	}
+	var err error
+	if c.args, err = p.exprList(); err != nil {
+		return nil, err
+	}
+	// SUBSTRING(s FROM pos FOR len)
+	if len(c.args) == 1 && p.accept("FROM") {
+		for _, kw := range []string{"", "FOR"} {
+			if kw != "" && !p.accept(kw) {
+				break
+			}
+			x, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			c.args = append(c.args, x)
+		}
+	}
+	if name == "GROUP_CONCAT" && p.accept("SEPARATOR") {
+		p.next()
+	}
+	return c, p.expectOp(")")
+}
+
+// parseCast parses CAST(x AS type) and CONVERT(x, type) or CONVERT(x USING charset)
+func (p *parser) parseCast(name string) (expr, error) {
+	p.i++ // (
+	x, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	c := &castExpr{x: x, typ: "CHAR"}
+	switch {
+	case name == "CONVERT" && p.accept("USING"):
+		if _, err := p.ident(); err != nil {
+			return nil, err
+		}
+	case name == "CAST" && p.accept("AS"), name == "CONVERT" && p.acceptOp(","):
+		t := p.next()
+		if t.kind != tokIdent {
+			return nil, syntaxError(p.q, t.pos, p.product)
+		}
+		c.typ = strings.ToUpper(t.text)
+		if p.peek().is("INTEGER") || p.peek().is("INT") {
+			p.i++ // SIGNED INTEGER
+		}
+		if p.acceptOp("(") {
+			for !p.acceptOp(")") {
+				if p.next().kind == tokEOF {
+					return nil, p.errSyntax()
+				}
+			}
+		}
+		if p.accept("CHARACTER") {
+			if err := p.expect("SET"); err != nil {
+				return nil, err
+			}
+			p.next()
+		}
+	default:
+		return nil, p.errSyntax()
+	}
+	return c, p.expectOp(")")
+}
+
+func (p *parser) parseCase() (expr, error) {
+	c := &caseExpr{}
+	var err error
+	if !p.peek().is("WHEN") {
+		if c.operand, err = p.parseExpr(); err != nil {
+			return nil, err
+		}
+	}
+	for p.accept("WHEN") {
+		cond, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("THEN"); err != nil {
+			return nil, err
+		}
+		then, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		c.whens = append(c.whens, [2]expr{cond, then})
+	}
+	if len(c.whens) == 0 {
+		return nil, p.errSyntax()
+	}
+	if p.accept("ELSE") {
+		if c.els, err = p.parseExpr(); err != nil {
+			return nil, err
+		}
+	}
+	return c, p.expect("END")
+}
+
+// limitValue parses the number of a LIMIT clause
+func (p *parser) limitValue() (int, error) {
+	t := p.peek()
+	n, err := strconv.Atoi(t.text)
+	if t.kind != tokNumber || err != nil {
+		return 0, p.errSyntax()
+	}
+	p.i++
+	return n, nil
+}
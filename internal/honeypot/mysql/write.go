@@ -0,0 +1,858 @@
+package mysql
+
+import (
+	"errors"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// with returns the schema with the database called name replaced by db, or
+// dropped if db is nil
+func (s *Schema) with(name string, db *Database) *Schema {
+	dbs := maps.Clone(s.dbs)
+	delete(dbs, infoSchema)
+	if db == nil {
+		delete(dbs, name)
+	} else {
+		dbs[name] = db
+	}
+	return NewSchema(dbs)
+}
+
+// with returns the database with the table called name replaced by t, added
+// if there is none, or dropped if t is nil
+func (d *Database) with(name string, t *Table) *Database {
+	nd := &Database{Name: d.Name}
+	found := false
+	for _, old := range d.Tables {
+		if old.Name != name {
+			nd.Tables = append(nd.Tables, old)
+			continue
+		}
+		found = true
+		if t != nil {
+			nd.Tables = append(nd.Tables, t)
+		}
+	}
+	if !found && t != nil {
+		nd.Tables = append(nd.Tables, t)
+	}
+	return nd
+}
+
+// writable returns the table to write and its database, refusing to write
+// information_schema
+func (s *Session) writable(db, name string) (*Database, *Table, error) {
+	dbName, t, err := s.table(db, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	if dbName == infoSchema {
+		return nil, nil, s.errDatabaseDenied(infoSchema)
+	}
+	return s.Schema.Database(dbName), t, nil
+}
+
+func (s *Session) errDatabaseDenied(db string) error {
+	return errorf(1044, "42000", "Access denied for user '%s'@'%s' to database '%s'", s.User, s.Host, db)
+}
+
+// charge counts size bytes of rows written to table against the session's
+// quota, failing as a full disk would if they exceed it
+func (s *Session) charge(table string, size int64) error {
+	if s.Quota > 0 && s.written+size > s.Quota {
+		return errorf(1114, "HY000", "The table '%s' is full", table)
+	}
+	s.written += size
+	return nil
+}
+
+// rowSize returns the bytes of the values of row
+func rowSize(row []*string) int64 {
+	var n int64
+	for _, v := range row {
+		if v != nil {
+			n += int64(len(*v))
+		}
+	}
+	return n
+}
+
+// store replaces table t of database d in the session's schema
+func (s *Session) store(d *Database, t *Table) {
+	s.Schema = s.Schema.with(d.Name, d.with(t.Name, t))
+}
+
+// autoIncrement returns the position of the auto_increment column of t, or -1
+func autoIncrement(t *Table) int {
+	for i, c := range t.Columns {
+		if strings.Contains(strings.ToLower(c.Extra), "auto_increment") {
+			return i
+		}
+	}
+	return -1
+}
+
+// nextID returns the next value of the auto_increment column i of t
+func nextID(t *Table, i int) uint64 {
+	var id uint64
+	for _, row := range t.Rows {
+		if row[i] != nil {
+			id = max(id, uint64(str(*row[i]).float()))
+		}
+	}
+	return id + 1
+}
+
+// primaryKey returns the position of the primary key column of t, or -1 if
+// it has none or the key has several columns
+func primaryKey(t *Table) int {
+	key := -1
+	for i, c := range t.Columns {
+		if c.Key == "PRI" && key >= 0 {
+			return -1
+		} else if c.Key == "PRI" {
+			key = i
+		}
+	}
+	return key
+}
+
+// zeroValue is the value of a column a row has no value for
+func zeroValue(c *Column) *string {
+	switch {
+	case c.Default != nil:
+		v := *c.Default
+		return &v
+	case c.Null:
+		return nil
+	case isNumericType(c.DataType()):
+		v := "0"
+		return &v
+	}
+	v := ""
+	return &v
+}
+
+func (s *Session) insert(p *parser, replace bool) (*Result, uint64, error) {
+	ignore := false
+	for _, m := range []string{"LOW_PRIORITY", "DELAYED", "HIGH_PRIORITY", "IGNORE"} {
+		if p.accept(m) {
+			ignore = ignore || m == "IGNORE"
+		}
+	}
+	p.accept("INTO")
+	dbName, name, err := p.tableName()
+	if err != nil {
+		return nil, 0, err
+	}
+	var names []string
+	if p.peek().isOp("(") && !p.peekAt(1).is("SELECT") {
+		p.i++
+		for {
+			name, err := p.ident()
+			if err != nil {
+				return nil, 0, err
+			}
+			names = append(names, name)
+			if !p.acceptOp(",") {
+				break
+			}
+		}
+		if err := p.expectOp(")"); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	var values [][]expr
+	var sel *selectStmt
+	switch {
+	case p.accept("VALUES"), p.accept("VALUE"):
+		for {
+			if err := p.expectOp("("); err != nil {
+				return nil, 0, err
+			}
+			var row []expr
+			if !p.acceptOp(")") {
+				if row, err = p.exprList(); err != nil {
+					return nil, 0, err
+				}
+				if err := p.expectOp(")"); err != nil {
+					return nil, 0, err
+				}
+			}
+			values = append(values, row)
+			if !p.acceptOp(",") {
+				break
+			}
+		}
+	case p.accept("SET"):
+		var row []expr
+		for {
+			name, err := p.ident()
+			if err != nil {
+				return nil, 0, err
+			}
+			if err := p.expectOp("="); err != nil {
+				return nil, 0, err
+			}
+			x, err := p.parseExpr()
+			if err != nil {
+				return nil, 0, err
+			}
+			names, row = append(names, name), append(row, x)
+			if !p.acceptOp(",") {
+				break
+			}
+		}
+		values = append(values, row)
+	case p.peek().is("SELECT"), p.peek().isOp("("):
+		parens := p.acceptOp("(")
+		if sel, err = p.parseSelect(); err != nil {
+			return nil, 0, err
+		}
+		if parens {
+			if err := p.expectOp(")"); err != nil {
+				return nil, 0, err
+			}
+		}
+	default:
+		return nil, 0, p.errSyntax()
+	}
+	if p.accept("ON") {
+		// ON DUPLICATE KEY UPDATE; duplicates are ignored instead
+		p.skipRest()
+	}
+	if err := p.end(); err != nil {
+		return nil, 0, err
+	}
+
+	d, t, err := s.writable(dbName, name)
+	if err != nil {
+		return nil, 0, err
+	}
+	columns := make([]int, 0, len(t.Columns))
+	if names == nil {
+		for i := range t.Columns {
+			columns = append(columns, i)
+		}
+	}
+	for _, name := range names {
+		i := t.Column(name)
+		if i < 0 {
+			return nil, 0, errorf(1054, "42S22", "Unknown column '%s' in 'field list'", name)
+		}
+		columns = append(columns, i)
+	}
+
+	var rows [][]value
+	ctx := &evalCtx{s: s, clause: "field list"}
+	for _, exprs := range values {
+		if len(exprs) != len(columns) && !(len(exprs) == 0 && names == nil) {
+			return nil, 0, errorf(1136, "21S01", "Column count doesn't match value count at row %d", len(rows)+1)
+		}
+		row := make([]value, len(exprs))
+		for i, x := range exprs {
+			if row[i], err = ctx.eval(x); err != nil {
+				return nil, 0, err
+			}
+		}
+		rows = append(rows, row)
+	}
+	if sel != nil {
+		res, err := s.runSelect(sel)
+		if err != nil {
+			return nil, 0, err
+		}
+		if len(res.Columns) != len(columns) {
+			return nil, 0, errorf(1136, "21S01", "Column count doesn't match value count at row 1")
+		}
+		for _, r := range res.Rows {
+			row := make([]value, len(r))
+			for i, v := range r {
+				row[i] = fromPtr(v)
+			}
+			rows = append(rows, row)
+		}
+	}
+
+	nt := &Table{Name: t.Name, Columns: t.Columns, Rows: slices.Clone(t.Rows)}
+	auto, pk := autoIncrement(t), primaryKey(t)
+	var affected uint64
+	var size int64
+	s.lastInsertID = 0
+	for _, values := range rows {
+		row := make([]*string, len(t.Columns))
+		for i, c := range t.Columns {
+			row[i] = zeroValue(c)
+		}
+		if auto >= 0 {
+			row[auto] = nil
+		}
+		for i, v := range values {
+			row[columns[i]] = v.ptr()
+		}
+		if auto >= 0 && (row[auto] == nil || *row[auto] == "0") {
+			id := nextID(nt, auto)
+			v := strconv.FormatUint(id, 10)
+			row[auto] = &v
+			if s.lastInsertID == 0 {
+				s.lastInsertID = id
+			}
+		}
+		if pk >= 0 && row[pk] != nil {
+			dup := slices.IndexFunc(nt.Rows, func(r []*string) bool {
+				return r[pk] != nil && compare(str(*r[pk]), str(*row[pk])) == 0
+			})
+			switch {
+			case dup >= 0 && replace:
+				nt.Rows = slices.Delete(nt.Rows, dup, dup+1)
+				affected++
+			case dup >= 0 && ignore:
+				continue
+			case dup >= 0:
+				return nil, 0, errorf(1062, "23000", "Duplicate entry '%s' for key 'PRIMARY'", *row[pk])
+			}
+		}
+		nt.Rows = append(nt.Rows, row)
+		size += rowSize(row)
+		affected++
+	}
+	if err := s.charge(t.Name, size); err != nil {
+		return nil, 0, err
+	}
+	s.store(d, nt)
+	return nil, affected, nil
+}
+
+// assignment is col = expr of UPDATE
+type assignment struct {
+	column int
+	x      expr
+}
+
+// parseWhereLimit parses the WHERE, ORDER BY and LIMIT clauses of UPDATE and
+// DELETE. The order is ignored.
+func (p *parser) parseWhereLimit() (where expr, limit int, err error) {
+	limit = -1
+	if p.accept("WHERE") {
+		if where, err = p.parseExpr(); err != nil {
+			return nil, 0, err
+		}
+	}
+	if p.accept("ORDER") {
+		if err := p.expect("BY"); err != nil {
+			return nil, 0, err
+		}
+		for {
+			if _, err := p.parseExpr(); err != nil {
+				return nil, 0, err
+			}
+			if !p.accept("DESC") {
+				p.accept("ASC")
+			}
+			if !p.acceptOp(",") {
+				break
+			}
+		}
+	}
+	if p.accept("LIMIT") {
+		if limit, err = p.limitValue(); err != nil {
+			return nil, 0, err
+		}
+	}
+	return where, limit, p.end()
+}
+
+// matches reports whether row matches the WHERE clause of UPDATE or DELETE
+func matches(ctx *evalCtx, where expr, row []*string) (bool, error) {
+	if where == nil {
+		return true, nil
+	}
+	ctx.row = row
+	v, err := ctx.eval(where)
+	return v.truth(), err
+}
+
+func (s *Session) update(p *parser) (*Result, uint64, error) {
+	if !p.accept("LOW_PRIORITY") {
+		p.accept("IGNORE")
+	}
+	dbName, name, err := p.tableName()
+	if err != nil {
+		return nil, 0, err
+	}
+	alias, err := p.alias()
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := p.expect("SET"); err != nil {
+		return nil, 0, err
+	}
+	type target struct {
+		name string
+		x    expr
+	}
+	var targets []target
+	for {
+		name, err := p.ident()
+		if err != nil {
+			return nil, 0, err
+		}
+		if p.acceptOp(".") {
+			if name, err = p.ident(); err != nil {
+				return nil, 0, err
+			}
+		}
+		if err := p.expectOp("="); err != nil {
+			return nil, 0, err
+		}
+		x, err := p.parseExpr()
+		if err != nil {
+			return nil, 0, err
+		}
+		targets = append(targets, target{name, x})
+		if !p.acceptOp(",") {
+			break
+		}
+	}
+	where, limit, err := p.parseWhereLimit()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	d, t, err := s.writable(dbName, name)
+	if err != nil {
+		return nil, 0, err
+	}
+	var assignments []assignment
+	for _, tg := range targets {
+		i := t.Column(tg.name)
+		if i < 0 {
+			return nil, 0, errorf(1054, "42S22", "Unknown column '%s' in 'field list'", tg.name)
+		}
+		assignments = append(assignments, assignment{i, tg.x})
+	}
+	if alias == "" {
+		alias = t.Name
+	}
+	ctx := &evalCtx{s: s, table: t, db: d.Name, alias: alias, clause: "where clause"}
+	nt := &Table{Name: t.Name, Columns: t.Columns, Rows: slices.Clone(t.Rows)}
+	var affected uint64
+	var size int64
+	for i, row := range nt.Rows {
+		if limit >= 0 && affected >= uint64(limit) {
+			break
+		}
+		ok, err := matches(ctx, where, row)
+		if err != nil {
+			return nil, 0, err
+		}
+		if !ok {
+			continue
+		}
+		updated := slices.Clone(row)
+		ctx.clause = "field list"
+		for _, a := range assignments {
+			v, err := ctx.eval(a.x)
+			if err != nil {
+				return nil, 0, err
+			}
+			updated[a.column] = v.ptr()
+		}
+		ctx.clause = "where clause"
+		if rowKey(updated) != rowKey(row) {
+			nt.Rows[i] = updated
+			size += rowSize(updated)
+			affected++
+		}
+	}
+	if err := s.charge(t.Name, size); err != nil {
+		return nil, 0, err
+	}
+	s.store(d, nt)
+	return nil, affected, nil
+}
+
+func (s *Session) delete(p *parser) (*Result, uint64, error) {
+	for _, m := range []string{"LOW_PRIORITY", "QUICK", "IGNORE"} {
+		p.accept(m)
+	}
+	if err := p.expect("FROM"); err != nil {
+		return nil, 0, err
+	}
+	dbName, name, err := p.tableName()
+	if err != nil {
+		return nil, 0, err
+	}
+	where, limit, err := p.parseWhereLimit()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	d, t, err := s.writable(dbName, name)
+	if err != nil {
+		return nil, 0, err
+	}
+	ctx := &evalCtx{s: s, table: t, db: d.Name, alias: t.Name, clause: "where clause"}
+	nt := &Table{Name: t.Name, Columns: t.Columns}
+	var affected uint64
+	for _, row := range t.Rows {
+		ok, err := matches(ctx, where, row)
+		if err != nil {
+			return nil, 0, err
+		}
+		if ok && (limit < 0 || affected < uint64(limit)) {
+			affected++
+			continue
+		}
+		nt.Rows = append(nt.Rows, row)
+	}
+	s.store(d, nt)
+	return nil, affected, nil
+}
+
+func (s *Session) truncate(p *parser) (*Result, uint64, error) {
+	p.accept("TABLE")
+	dbName, name, err := p.tableName()
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := p.end(); err != nil {
+		return nil, 0, err
+	}
+	d, t, err := s.writable(dbName, name)
+	if err != nil {
+		return nil, 0, err
+	}
+	s.store(d, &Table{Name: t.Name, Columns: t.Columns})
+	return nil, 0, nil
+}
+
+// ifExists parses IF EXISTS, or IF NOT EXISTS if not is set
+func (p *parser) ifExists(not bool) (bool, error) {
+	if !p.accept("IF") {
+		return false, nil
+	}
+	if not {
+		if err := p.expect("NOT"); err != nil {
+			return false, err
+		}
+	}
+	return true, p.expect("EXISTS")
+}
+
+func (s *Session) create(p *parser) (*Result, uint64, error) {
+	p.accept("TEMPORARY")
+	switch {
+	case p.accept("DATABASE"), p.accept("SCHEMA"):
+		ifNotExists, err := p.ifExists(true)
+		if err != nil {
+			return nil, 0, err
+		}
+		name, err := p.ident()
+		if err != nil {
+			return nil, 0, err
+		}
+		p.skipRest() // Character set and collation
+		if s.Schema.Database(name) != nil {
+			if ifNotExists {
+				return nil, 0, nil
+			}
+			return nil, 0, errorf(1007, "HY000", "Can't create database '%s'; database exists", name)
+		}
+		s.Schema = s.Schema.with(name, &Database{Name: name})
+		return nil, 1, nil
+	case p.accept("TABLE"):
+		ifNotExists, err := p.ifExists(true)
+		if err != nil {
+			return nil, 0, err
+		}
+		dbName, name, err := p.tableName()
+		if err != nil {
+			return nil, 0, err
+		}
+		t := &Table{Name: name}
+		if p.accept("LIKE") {
+			likeDB, likeName, err := p.tableName()
+			if err != nil {
+				return nil, 0, err
+			}
+			_, like, err := s.table(likeDB, likeName)
+			if err != nil {
+				return nil, 0, err
+			}
+			t.Columns = like.Columns
+		} else if t.Columns, err = p.parseTableDefs(); err != nil {
+			return nil, 0, err
+		}
+		p.skipRest() // Table options
+		if dbName == "" {
+			dbName = s.Database
+		}
+		if dbName == "" {
+			return nil, 0, errorf(1046, "3D000", "No database selected")
+		}
+		d := s.Schema.Database(dbName)
+		switch {
+		case d == nil:
+			return nil, 0, errorf(1049, "42000", "Unknown database '%s'", dbName)
+		case d.Name == infoSchema:
+			return nil, 0, s.errDatabaseDenied(infoSchema)
+		case d.Table(name) != nil && ifNotExists:
+			return nil, 0, nil
+		case d.Table(name) != nil:
+			return nil, 0, errorf(1050, "42S01", "Table '%s' already exists", name)
+		}
+		s.store(d, t)
+		return nil, 0, nil
+	}
+	// Users, indexes, views, routines and the rest
+	p.skipRest()
+	return nil, 0, nil
+}
+
+// columnAttributes end the type of a column definition
+var columnAttributes = map[string]bool{"NOT": true, "NULL": true, "DEFAULT": true, "AUTO_INCREMENT": true,
+	"PRIMARY": true, "UNIQUE": true, "KEY": true, "COMMENT": true, "COLLATE": true, "CHARACTER": true,
+	"CHARSET": true, "ON": true, "GENERATED": true, "AS": true, "REFERENCES": true, "CHECK": true}
+
+// parseTableDefs parses the column and index definitions of CREATE TABLE
+func (p *parser) parseTableDefs() ([]*Column, error) {
+	if err := p.expectOp("("); err != nil {
+		return nil, err
+	}
+	var columns []*Column
+	keys := make(map[string]string)
+	for {
+		t := p.peek()
+		switch {
+		case t.is("PRIMARY") || t.is("UNIQUE") || t.is("KEY") || t.is("INDEX") || t.is("CONSTRAINT") ||
+			t.is("FOREIGN") || t.is("FULLTEXT") || t.is("SPATIAL") || t.is("CHECK"):
+			key := ""
+			switch {
+			case t.is("PRIMARY"):
+				key = "PRI"
+			case t.is("UNIQUE"):
+				key = "UNI"
+			case t.is("KEY") || t.is("INDEX"):
+				key = "MUL"
+			}
+			// Skip to the column list, then record the first column
+			for !p.peek().isOp("(") && p.peek().kind != tokEOF {
+				p.i++
+			}
+			p.i++
+			if first := p.peek(); key != "" && (first.kind == tokIdent || first.kind == tokQuotedIdent) {
+				if keys[strings.ToLower(first.text)] == "" || key == "PRI" {
+					keys[strings.ToLower(first.text)] = key
+				}
+			}
+			if err := p.skipDefinition(1); err != nil {
+				return nil, err
+			}
+		default:
+			c, err := p.parseColumnDef()
+			if err != nil {
+				return nil, err
+			}
+			for _, other := range columns {
+				if strings.EqualFold(other.Name, c.Name) {
+					return nil, errorf(1060, "42S21", "Duplicate column name '%s'", c.Name)
+				}
+			}
+			columns = append(columns, c)
+		}
+		if !p.acceptOp(",") {
+			break
+		}
+	}
+	if err := p.expectOp(")"); err != nil {
+		return nil, err
+	}
+	for _, c := range columns {
+		if key := keys[strings.ToLower(c.Name)]; key != "" && c.Key == "" {
+			c.Key = key
+			if key == "PRI" {
+				c.Null = false
+			}
+		}
+	}
+	return columns, nil
+}
+
+// skipDefinition skips the rest of a definition, up to the comma or closing
+// parenthesis at its level. depth is the number of parentheses open.
+func (p *parser) skipDefinition(depth int) error {
+	for {
+		t := p.peek()
+		switch {
+		case t.kind == tokEOF:
+			return p.errSyntax()
+		case t.isOp("("):
+			depth++
+		case t.isOp(")") && depth == 0, t.isOp(",") && depth == 0:
+			return nil
+		case t.isOp(")"):
+			depth--
+		}
+		p.i++
+	}
+}
+
+func (p *parser) parseColumnDef() (*Column, error) {
+	name, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	start := p.peek()
+	if start.kind != tokIdent {
+		return nil, p.errSyntax()
+	}
+	for depth := 0; ; p.i++ {
+		t := p.peek()
+		if t.kind == tokEOF || depth == 0 && (t.isOp(",") || t.isOp(")") || t.kind == tokIdent && t != start && columnAttributes[strings.ToUpper(t.text)]) {
+			break
+		}
+		switch {
+		case t.isOp("("):
+			depth++
+		case t.isOp(")"):
+			depth--
+		}
+	}
+	c := &Column{Name: name, Type: strings.TrimSpace(p.q[start.pos:p.peek().pos]), Null: true}
+	for {
+		t := p.peek()
+		switch {
+		case t.kind == tokEOF:
+			return nil, p.errSyntax()
+		case t.isOp(",") || t.isOp(")"):
+			return c, nil
+		case t.is("NOT") && p.peekAt(1).is("NULL"):
+			c.Null = false
+			p.i++
+		case t.is("NULL"):
+			c.Null = true
+		case t.is("DEFAULT"):
+			p.i++
+			v := p.peek()
+			neg := v.isOp("-")
+			if neg {
+				p.i++
+				v = p.peek()
+			}
+			switch {
+			case v.kind == tokString || v.kind == tokNumber:
+				s := v.text
+				if neg {
+					s = "-" + s
+				}
+				c.Default = &s
+			case v.is("NULL"):
+				c.Default = nil
+			case v.kind == tokIdent:
+				// CURRENT_TIMESTAMP, with or without a precision
+				s := strings.ToUpper(v.text)
+				c.Default = &s
+				if p.peekAt(1).isOp("(") {
+					for p.i++; !p.peek().isOp(")") && p.peek().kind != tokEOF; p.i++ {
+					}
+				}
+			}
+		case t.is("AUTO_INCREMENT"):
+			c.Extra = "auto_increment"
+		case t.is("PRIMARY"):
+			c.Key, c.Null = "PRI", false
+		case t.is("UNIQUE"):
+			if c.Key == "" {
+				c.Key = "UNI"
+			}
+		case t.isOp("("):
+			p.i++
+			if err := p.skipDefinition(0); err != nil {
+				return nil, err
+			}
+		}
+		p.i++
+	}
+}
+
+func (s *Session) drop(p *parser) (*Result, uint64, error) {
+	p.accept("TEMPORARY")
+	switch {
+	case p.accept("DATABASE"), p.accept("SCHEMA"):
+		ifExists, err := p.ifExists(false)
+		if err != nil {
+			return nil, 0, err
+		}
+		name, err := p.ident()
+		if err != nil {
+			return nil, 0, err
+		}
+		if err := p.end(); err != nil {
+			return nil, 0, err
+		}
+		d := s.Schema.Database(name)
+		switch {
+		case d == nil && ifExists:
+			return nil, 0, nil
+		case d == nil:
+			return nil, 0, errorf(1008, "HY000", "Can't drop database '%s'; database doesn't exist", name)
+		case d.Name == infoSchema:
+			return nil, 0, s.errDatabaseDenied(infoSchema)
+		}
+		s.Schema = s.Schema.with(d.Name, nil)
+		if s.Database == d.Name {
+			s.Database = ""
+		}
+		return nil, uint64(len(d.Tables)), nil
+	case p.accept("TABLE"), p.accept("TABLES"):
+		ifExists, err := p.ifExists(false)
+		if err != nil {
+			return nil, 0, err
+		}
+		type ref struct{ db, name string }
+		var refs []ref
+		for {
+			db, name, err := p.tableName()
+			if err != nil {
+				return nil, 0, err
+			}
+			refs = append(refs, ref{db, name})
+			if !p.acceptOp(",") {
+				break
+			}
+		}
+		if !p.accept("RESTRICT") {
+			p.accept("CASCADE")
+		}
+		if err := p.end(); err != nil {
+			return nil, 0, err
+		}
+		var unknown []string
+		for _, r := range refs {
+			d, t, err := s.writable(r.db, r.name)
+			var e *Error
+			if err != nil && errors.As(err, &e) && e.Code == 1146 {
+				db := r.db
+				if db == "" {
+					db = s.Database
+				}
+				unknown = append(unknown, db+"."+r.name)
+				continue
+			} else if err != nil {
+				return nil, 0, err
+			}
+			s.Schema = s.Schema.with(d.Name, d.with(t.Name, nil))
+		}
+		if len(unknown) > 0 && !ifExists {
+			return nil, 0, errorf(1051, "42S02", "Unknown table '%s'", strings.Join(unknown, ","))
+		}
+		return nil, 0, nil
+	}
+	p.skipRest()
+	return nil, 0, nil
+}
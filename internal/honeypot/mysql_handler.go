@@ -1,186 +1,265 @@
 package honeypot
 
 import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
 	"fmt"
 	"net"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"phantom-grid/internal/honeypot/mysql"
 	"phantom-grid/internal/logger"
 )
 
-// handleMySQL simulates professional MySQL server interaction
-func (h *Handler) handleMySQL(conn net.Conn, remote, t string) {
-	ip := strings.Split(remote, ":")[0]
-	h.logChan <- fmt.Sprintf("[%s] MySQL connection attempt from %s", t, ip)
-	logger.LogAttack(ip, "MySQL_CONNECTION")
-
-	// Send initial handshake packet
-	handshake := []byte{
-		0x4a, 0x00, 0x00, 0x00, // Packet length
-		0x0a,                   // Protocol version (10)
-	}
-	handshake = append(handshake, []byte("8.0.27")...)
-	handshake = append(handshake, 0x00)
-	handshake = append(handshake, []byte{0x01, 0x00, 0x00, 0x00, 0x40, 0x41, 0x51, 0x27, 0x4a, 0x4b, 0x5c, 0x5d}...)
-	handshake = append(handshake, []byte("mysql_native_password")...)
-	handshake = append(handshake, 0x00)
-	conn.Write(handshake)
-
-	buf := make([]byte, 4096)
-	authenticated := false
-	username := ""
+// mysqlTimeout bounds waiting for the handshake response and for each
+// command, as wait_timeout does on a real server
+const mysqlTimeout = 5 * time.Minute
 
-	for {
-		n, err := conn.Read(buf)
-		if err != nil || n == 0 {
-			return
-		}
+// mysqlDefaultVersion is announced when the banner has no version
+const mysqlDefaultVersion = "5.7.35-0ubuntu0.18.04.1"
 
-		// Parse login packet
-		if !authenticated && n > 4 {
-			usernameLen := int(buf[4])
-			if usernameLen > 0 && usernameLen < 32 && n > 5+usernameLen {
-				username = string(buf[5 : 5+usernameLen])
-				h.logChan <- fmt.Sprintf("[%s] MySQL LOGIN: username='%s'", t, username)
-				logger.LogAttack(ip, fmt.Sprintf("MySQL_LOGIN: user=%s", username))
-
-				// Extract password if present
-				passwordStart := 5 + usernameLen + 1
-				if n > passwordStart {
-					passwordLen := int(buf[passwordStart])
-					if passwordLen > 0 && passwordLen < 255 && n > passwordStart+1 {
-						password := string(buf[passwordStart+1 : passwordStart+1+passwordLen])
-						h.logChan <- fmt.Sprintf("[%s] MySQL PASSWORD attempt (length: %d)", t, len(password))
-						logger.LogAttack(ip, fmt.Sprintf("MySQL_PASSWORD: length=%d", len(password)))
-					}
-				}
-
-				// Send error packet
-				errorPacket := []byte{0xff, 0x15, 0x04, 0x23, 0x28, 0x30, 0x30, 0x30, 0x30, 0x34}
-				errorPacket = append(errorPacket, []byte("Access denied for user '"+username+"'@'"+ip+"' (using password: YES)")...)
-				errorPacket = append(errorPacket, 0x00)
-				conn.Write(errorPacket)
-				time.Sleep(200 * time.Millisecond)
-
-				// Try to continue as if authenticated for command simulation
-				authenticated = true
-				conn.Write([]byte{0x07, 0x00, 0x00, 0x02, 0x00, 0x00, 0x00, 0x02, 0x00, 0x00, 0x00}) // OK packet
-				continue
-			}
-		}
+// mysqlPasswords are tried against the hashed responses of the native
+// plugin, along with the configured passwords, the empty one and the user
+// name. Only the password that was used can be recovered from a hash.
+var mysqlPasswords = []string{"root", "toor", "mysql", "password", "123456", "admin", "12345678", "qwerty", "123", "1234"}
 
-		// Parse commands after authentication
-		if authenticated && n > 4 {
-			command := buf[4]
-			commandStr := string(buf[5:n])
-
-			h.logChan <- fmt.Sprintf("[%s] MySQL COMMAND: %s", t, commandStr)
-			logger.LogAttack(ip, fmt.Sprintf("MySQL: %s", commandStr))
-
-			switch command {
-			case 0x03: // COM_QUERY
-				query := strings.ToUpper(strings.TrimSpace(commandStr))
-				response := h.handleMySQLQuery(query, ip, t)
-				conn.Write(response)
-			case 0x01: // COM_QUIT
-				conn.Write([]byte{0x07, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x02, 0x00, 0x00, 0x00})
-				return
-			default:
-				// Unknown command
-				errorPacket := []byte{0xff, 0x15, 0x04, 0x23, 0x28, 0x30, 0x30, 0x30, 0x30, 0x34}
-				errorPacket = append(errorPacket, []byte("Unknown command")...)
-				errorPacket = append(errorPacket, 0x00)
-				conn.Write(errorPacket)
-			}
+// mysqlKey is the RSA key caching_sha2_password clients encrypt their
+// password with. It is generated once, when first needed.
+var mysqlKey = sync.OnceValues(func() (*rsa.PrivateKey, error) {
+	return rsa.GenerateKey(rand.Reader, 2048)
+})
+
+// mysqlConnections numbers connections, as a server that has been up for a
+// while would
+var mysqlConnections atomic.Uint32
+
+// mysqlVersion returns the server version a MySQL banner announces
+func mysqlVersion(banner string) string {
+	version, _, _ := strings.Cut(strings.TrimPrefix(banner, "\x0a"), "\x00")
+	if version == "" {
+		return mysqlDefaultVersion
+	}
+	return version
+}
+
+// mysqlPlugin returns the default authentication plugin of a server version
+func mysqlPlugin(version string) string {
+	if strings.HasPrefix(version, "8.") {
+		return mysql.PluginCachingSHA2
+	}
+	return mysql.PluginNative
+}
+
+// mysqlCandidates returns the passwords tried against a hashed response
+func (h *Handler) mysqlCandidates(user string) []string {
+	candidates := []string{"", user}
+	for _, cred := range h.login.Credentials {
+		if cred.Password != "*" {
+			candidates = append(candidates, cred.Password)
 		}
+	}
+	return append(candidates, mysqlPasswords...)
+}
 
-		time.Sleep(100 * time.Millisecond)
+// hostname returns the host name of the fake server, the one in the shell's
+// /etc/hostname
+func (h *Handler) hostname() string {
+	data, err := h.sessionFS().ReadFile("/etc/hostname")
+	if name := strings.TrimSpace(string(data)); err == nil && name != "" {
+		return name
 	}
+	return "localhost"
 }
 
-func (h *Handler) handleMySQLQuery(query string, ip, t string) []byte {
-	query = strings.ToUpper(strings.TrimSpace(query))
-
-	// SHOW DATABASES
-	if strings.HasPrefix(query, "SHOW DATABASES") {
-		databases := []string{"information_schema", "mysql", "performance_schema", "sys", "wordpress", "production", "test"}
-		response := []byte{0x01, 0x00, 0x00, 0x01, 0x01} // Column count: 1
-		response = append(response, []byte{0x27, 0x00, 0x00, 0x02, 0x03, 0x64, 0x65, 0x66}...) // Column definition
-		response = append(response, []byte("Database")...)
-		response = append(response, 0x00)
-		for _, db := range databases {
-			response = append(response, []byte(fmt.Sprintf("%c%s", len(db), db))...)
+// handleMySQL speaks the MySQL client/server protocol: it authenticates the
+// client, learning its password, then answers its queries from the fake
+// schema. Every query is logged and sent as a command event.
+func (h *Handler) handleMySQL(conn net.Conn, remote, t string) {
+	ip := extractIP(remote)
+	version := h.mysqlVersion
+	if version == "" {
+		version = mysqlDefaultVersion
+	}
+	plugin := mysqlPlugin(version)
+	scramble := mysql.NewScramble()
+	id := 8 + mysqlConnections.Add(1)
+
+	c := mysql.NewConn(conn)
+	if err := c.WriteHandshake(mysql.Handshake{Version: version, ConnectionID: id, Scramble: scramble, Plugin: plugin}); err != nil {
+		return
+	}
+	conn.SetReadDeadline(time.Now().Add(mysqlTimeout))
+	pkt, err := c.ReadPacket()
+	if err != nil {
+		return
+	}
+	resp, err := mysql.ParseHandshakeResponse(pkt)
+	if errors.Is(err, mysql.ErrSSLRequest) {
+		// TLS is not offered, so only clients that require it ask
+		h.logChan <- fmt.Sprintf("[%s] MYSQL TLS REQUEST: %s", t, ip)
+		return
+	}
+	if err != nil {
+		c.WriteError(&mysql.Error{Code: 1043, State: "08S01", Message: "Bad handshake"})
+		return
+	}
+	if len(resp.Attributes) > 0 {
+		keys := make([]string, 0, len(resp.Attributes))
+		for k := range resp.Attributes {
+			keys = append(keys, k)
 		}
-		return response
-	}
-
-	// USE database
-	if strings.HasPrefix(query, "USE ") {
-		dbName := strings.TrimSpace(strings.TrimPrefix(query, "USE"))
-		h.logChan <- fmt.Sprintf("[%s] MySQL USE DATABASE: %s", t, dbName)
-		return []byte{0x07, 0x00, 0x00, 0x02, 0x00, 0x00, 0x00, 0x02, 0x00, 0x00, 0x00} // OK packet
-	}
-
-	// SELECT queries
-	if strings.HasPrefix(query, "SELECT") {
-		if strings.Contains(query, "FROM USERS") || strings.Contains(query, "FROM USER") {
-			// Simulate user table
-			response := []byte{0x01, 0x00, 0x00, 0x01, 0x03} // 3 columns
-			response = append(response, []byte("id")...)
-			response = append(response, 0x00)
-			response = append(response, []byte("username")...)
-			response = append(response, 0x00)
-			response = append(response, []byte("password")...)
-			response = append(response, 0x00)
-			// Add some fake rows
-			response = append(response, []byte{0x01, 0x00, 0x00, 0x02, 0x31, 0x61, 0x64, 0x6d, 0x69, 0x6e, 0x2a, 0x2a, 0x2a}...)
-			return response
+		sort.Strings(keys)
+		attrs := make([]string, len(keys))
+		for i, k := range keys {
+			attrs[i] = k + "=" + resp.Attributes[k]
 		}
-		if strings.Contains(query, "VERSION()") {
-			return []byte{0x01, 0x00, 0x00, 0x01, 0x01, 0x07, 0x00, 0x00, 0x02, 0x38, 0x2e, 0x30, 0x2e, 0x32, 0x37} // "8.0.27"
+		h.logChan <- fmt.Sprintf("[%s] MYSQL CLIENT: %s | %s", t, ip, strings.Join(attrs, ", "))
+	}
+
+	var key *rsa.PrivateKey
+	if plugin == mysql.PluginCachingSHA2 {
+		if key, err = mysqlKey(); err != nil {
+			h.logChan <- fmt.Sprintf("[WARN] Cannot generate MySQL RSA key: %v", err)
 		}
-		// Generic SELECT response
-		return []byte{0x01, 0x00, 0x00, 0x01, 0x00} // Empty result
-	}
-
-	// SHOW TABLES
-	if strings.HasPrefix(query, "SHOW TABLES") {
-		tables := []string{"users", "posts", "comments", "settings", "logs"}
-		response := []byte{0x01, 0x00, 0x00, 0x01, 0x01} // Column count
-		response = append(response, []byte("Tables_in_database")...)
-		response = append(response, 0x00)
-		for _, table := range tables {
-			response = append(response, []byte(fmt.Sprintf("%c%s", len(table), table))...)
+	}
+	login, err := c.Authenticate(resp, scramble, plugin, key, h.mysqlCandidates(resp.User))
+	password := login.Password
+	if !login.Known {
+		password = "*"
+	}
+	// Clients reconnect to retry, so every connection is a first attempt
+	accepted := err == nil && h.login.Accepts(login.User, password, 1)
+	if login.Known {
+		h.logChan <- fmt.Sprintf("[%s] MYSQL LOGIN: %s | User: %s | Password: %q | Plugin: %s | Accepted: %t", t, ip, login.User, login.Password, login.Plugin, accepted)
+		logger.LogAttack(ip, fmt.Sprintf("MYSQL_LOGIN: user=%s, pass=%s", login.User, login.Password))
+	} else {
+		h.logChan <- fmt.Sprintf("[%s] MYSQL LOGIN: %s | User: %s | Password: unknown (hash %x) | Plugin: %s | Accepted: %t", t, ip, login.User, login.Response, login.Plugin, accepted)
+		logger.LogAttack(ip, fmt.Sprintf("MYSQL_LOGIN: user=%s, hash=%x", login.User, login.Response))
+	}
+	if err != nil {
+		return
+	}
+	if !accepted {
+		using := "NO"
+		if len(login.Response) > 0 {
+			using = "YES"
+		}
+		c.WriteError(&mysql.Error{Code: 1045, State: "28000", Message: fmt.Sprintf("Access denied for user '%s'@'%s' (using password: %s)", login.User, ip, using)})
+		return
+	}
+
+	s := &mysql.Session{
+		Schema:       h.mysqlSchema,
+		Version:      version,
+		User:         login.User,
+		Host:         ip,
+		Hostname:     h.hostname(),
+		ConnectionID: id,
+		Quota:        h.mysqlConfig.SessionQuota,
+	}
+	if s.Schema == nil {
+		s.Schema = mysql.NewSchema(mysql.Builtin())
+	}
+	if login.Database != "" {
+		if err := s.UseDatabase(login.Database); err != nil {
+			c.WriteError(err.(*mysql.Error))
+			return
+		}
+	}
+	if c.WriteOK(0, 0) != nil {
+		return
+	}
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(mysqlTimeout))
+		cmd, err := c.ReadCommand()
+		if err != nil || len(cmd) == 0 {
+			return
+		}
+		switch cmd[0] {
+		case mysql.ComQuit:
+			return
+		case mysql.ComQuery:
+			err = h.mysqlQuery(c, s, string(cmd[1:]), ip, t)
+		case mysql.ComInitDB:
+			name := string(cmd[1:])
+			h.logChan <- fmt.Sprintf("[%s] MYSQL USE: %s | DB: %s", t, ip, name)
+			if uerr := s.UseDatabase(name); uerr != nil {
+				err = c.WriteError(uerr.(*mysql.Error))
+			} else {
+				err = c.WriteOK(0, 0)
+			}
+		case mysql.ComFieldList:
+			table, _, _ := bytes.Cut(cmd[1:], []byte{0})
+			columns, qerr := s.FieldList(string(table))
+			if qerr != nil {
+				err = c.WriteError(qerr.(*mysql.Error))
+			} else {
+				err = c.WriteColumns(columns, true)
+			}
+		case mysql.ComPing, mysql.ComResetConnection:
+			err = c.WriteOK(0, 0)
+		case mysql.ComSetOption:
+			err = c.WriteEOF()
+		case mysql.ComStatistics:
+			err = c.WritePacket([]byte(s.Statistics()))
+		default:
+			err = c.WriteError(&mysql.Error{Code: 1047, State: "08S01", Message: "Unknown command"})
 		}
-		return response
-	}
-
-	// SHOW CREATE TABLE
-	if strings.HasPrefix(query, "SHOW CREATE TABLE") {
-		tableName := strings.TrimSpace(strings.TrimPrefix(query, "SHOW CREATE TABLE"))
-		h.logChan <- fmt.Sprintf("[%s] MySQL SHOW CREATE TABLE: %s", t, tableName)
-		response := []byte{0x01, 0x00, 0x00, 0x01, 0x02} // 2 columns
-		response = append(response, []byte("Table")...)
-		response = append(response, 0x00)
-		response = append(response, []byte("Create Table")...)
-		response = append(response, 0x00)
-		createSQL := fmt.Sprintf("CREATE TABLE `%s` (`id` int(11) NOT NULL AUTO_INCREMENT, PRIMARY KEY (`id`))", tableName)
-		response = append(response, []byte(fmt.Sprintf("%c%s", len(createSQL), createSQL))...)
-		return response
-	}
-
-	// INSERT, UPDATE, DELETE
-	if strings.HasPrefix(query, "INSERT") || strings.HasPrefix(query, "UPDATE") || strings.HasPrefix(query, "DELETE") {
-		h.logChan <- fmt.Sprintf("[%s] MySQL MODIFY QUERY: %s", t, query)
-		logger.LogAttack(ip, fmt.Sprintf("MySQL_MODIFY: %s", query))
-		return []byte{0x07, 0x00, 0x00, 0x02, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00} // OK - 1 row affected
-	}
-
-	// Default error response
-	errorPacket := []byte{0xff, 0x15, 0x04, 0x23, 0x28, 0x30, 0x30, 0x30, 0x30, 0x34}
-	errorPacket = append(errorPacket, []byte("You have an error in your SQL syntax")...)
-	errorPacket = append(errorPacket, 0x00)
-	return errorPacket
+		if err != nil {
+			return
+		}
+	}
 }
 
+// mysqlQuery runs a query in the session, answers it and captures it
+func (h *Handler) mysqlQuery(c *mysql.Conn, s *mysql.Session, query, ip, t string) error {
+	// The database the query ran in, not the one a USE switched to
+	db := s.Database
+	if db == "" {
+		db = "none"
+	}
+	res, affected, err := s.Query(query)
+
+	event := logger.NewSecurityEvent(logger.EventTypeCommand, query).
+		WithSourceIP(ip).
+		WithService("mysql").
+		WithCommand(query).
+		WithMetadata("user", s.User).
+		WithMetadata("database", db)
+	if h.port > 0 {
+		event.WithPort(h.port)
+	}
+	var outcome string
+	if err != nil {
+		e := err.(*mysql.Error)
+		outcome = fmt.Sprintf("Error: %d", e.Code)
+		event.WithMetadata("error", e.Code)
+	} else if res != nil {
+		outcome = fmt.Sprintf("Rows: %d", len(res.Rows))
+		event.WithMetadata("rows", len(res.Rows))
+	} else {
+		outcome = fmt.Sprintf("Affected: %d", affected)
+		event.WithMetadata("affected", affected)
+	}
+	h.logChan <- fmt.Sprintf("[%s] MYSQL QUERY: %s | DB: %s | %s | Query: %q", t, ip, db, outcome, query)
+	logger.LogAttack(ip, fmt.Sprintf("MYSQL_QUERY: user=%s, db=%s, query=%s", s.User, db, query))
+	if h.events != nil {
+		h.events(event)
+	}
+
+	switch {
+	case err != nil:
+		return c.WriteError(err.(*mysql.Error))
+	case res != nil:
+		return c.WriteResult(res)
+	default:
+		return c.WriteOK(affected, s.LastInsertID())
+	}
+}
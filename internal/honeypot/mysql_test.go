@@ -0,0 +1,149 @@
+package honeypot
+
+import (
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+
+	"phantom-grid/internal/config"
+	"phantom-grid/internal/honeypot/mysql"
+	"phantom-grid/internal/logger"
+)
+
+// mysqlClient connects to h's MySQL persona and logs in with a clear text
+// password, returning the connection and the answer to the login
+func mysqlClient(t *testing.T, h *Handler, user, password, db string) (net.Conn, []byte) {
+	t.Helper()
+	server, client := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer server.Close()
+		h.handleMySQL(server, "203.0.113.7:40000", "12:00:00")
+	}()
+	t.Cleanup(func() {
+		client.Close()
+		<-done
+	})
+
+	c := mysql.NewConn(client)
+	if _, err := c.ReadPacket(); err != nil {
+		t.Fatalf("Failed to read handshake: %v", err)
+	}
+	caps := mysql.ClientProtocol41 | mysql.ClientSecureConnection | mysql.ClientPluginAuth | mysql.ClientConnectWithDB
+	b := binary.LittleEndian.AppendUint32(nil, caps)
+	b = binary.LittleEndian.AppendUint32(b, mysql.MaxPacketSize)
+	b = append(b, 0x21)
+	b = append(b, make([]byte, 23)...)
+	b = append(append(b, user...), 0)
+	b = append(append(b, byte(len(password)+1)), password...)
+	b = append(b, 0)
+	b = append(append(b, db...), 0)
+	b = append(append(b, mysql.PluginClearText...), 0)
+	if err := c.WritePacket(b); err != nil {
+		t.Fatalf("Failed to send handshake response: %v", err)
+	}
+	answer, err := c.ReadPacket()
+	if err != nil {
+		t.Fatalf("Failed to read login answer: %v", err)
+	}
+	return client, answer
+}
+
+// mysqlCommand sends a command and returns the packets of the answer, up to
+// the one that ends it
+func mysqlCommand(t *testing.T, conn net.Conn, cmd byte, arg string) [][]byte {
+	t.Helper()
+	c := mysql.NewConn(conn)
+	if err := c.WritePacket(append([]byte{cmd}, arg...)); err != nil {
+		t.Fatalf("Failed to send command: %v", err)
+	}
+	var packets [][]byte
+	eofs := 0
+	for {
+		pkt, err := c.ReadPacket()
+		if err != nil {
+			t.Fatalf("Failed to read answer: %v", err)
+		}
+		packets = append(packets, pkt)
+		if len(packets) == 1 && (pkt[0] == 0x00 || pkt[0] == 0xff) {
+			return packets
+		}
+		// A result set ends with its second EOF packet
+		if pkt[0] == 0xfe && len(pkt) < 9 {
+			if eofs++; eofs == 2 {
+				return packets
+			}
+		}
+	}
+}
+
+func TestMySQLPersona(t *testing.T) {
+	chdirTemp(t)
+	logChan := make(chan string, 100)
+	h := NewHandler(logChan)
+	h.login = config.LoginConfiguration{Policy: config.LoginPolicyList, Credentials: []config.Credential{{User: "root", Password: "toor"}}, MaxAttempts: 3}
+	h.mysqlVersion = "5.7.35-0ubuntu0.18.04.1"
+	h.port = 3306
+	var events []*logger.SecurityEvent
+	h.events = func(e *logger.SecurityEvent) { events = append(events, e) }
+
+	conn, answer := mysqlClient(t, h, "root", "toor", "production")
+	if answer[0] != 0x00 {
+		t.Fatalf("login answer %q, want OK", answer)
+	}
+
+	packets := mysqlCommand(t, conn, mysql.ComQuery, "SELECT COUNT(*) FROM customers")
+	// Column count, its definition, EOF, the row and EOF
+	if len(packets) != 5 || string(packets[3]) != "\x0216" {
+		t.Errorf("got %q, want one row with 16", packets)
+	}
+	if packets := mysqlCommand(t, conn, mysql.ComQuery, "SELECT * FROM nope"); packets[0][0] != 0xff {
+		t.Errorf("got %q, want an error", packets[0])
+	}
+	if packets := mysqlCommand(t, conn, mysql.ComInitDB, "wordpress"); packets[0][0] != 0x00 {
+		t.Errorf("COM_INIT_DB answered %q, want OK", packets[0])
+	}
+	if packets := mysqlCommand(t, conn, mysql.ComPing, ""); packets[0][0] != 0x00 {
+		t.Errorf("COM_PING answered %q, want OK", packets[0])
+	}
+
+	if len(events) != 2 {
+		t.Fatalf("got %d events, want one per query", len(events))
+	}
+	e := events[0]
+	if e.EventType != logger.EventTypeCommand || e.Service != "mysql" || e.Command != "SELECT COUNT(*) FROM customers" || e.Port != 3306 {
+		t.Errorf("got event %+v", e)
+	}
+	if e.Metadata["database"] != "production" || e.Metadata["rows"] != 1 {
+		t.Errorf("got metadata %v", e.Metadata)
+	}
+	if events[1].Metadata["error"] != uint16(1146) {
+		t.Errorf("got metadata %v, want error 1146", events[1].Metadata)
+	}
+
+	var login bool
+	for len(logChan) > 0 {
+		if msg := <-logChan; strings.Contains(msg, "MYSQL LOGIN") {
+			login = strings.Contains(msg, `Password: "toor"`) && strings.Contains(msg, "Accepted: true")
+		}
+	}
+	if !login {
+		t.Error("the login was not logged with its password")
+	}
+}
+
+func TestMySQLPersonaDenied(t *testing.T) {
+	chdirTemp(t)
+	h := NewHandler(make(chan string, 100))
+	h.login = config.LoginConfiguration{Policy: config.LoginPolicyList, Credentials: []config.Credential{{User: "root", Password: "toor"}}, MaxAttempts: 3}
+
+	_, answer := mysqlClient(t, h, "root", "123456", "")
+	if answer[0] != 0xff || binary.LittleEndian.Uint16(answer[1:]) != 1045 {
+		t.Fatalf("got %q, want error 1045", answer)
+	}
+	if !strings.Contains(string(answer), "Access denied for user 'root'@'203.0.113.7' (using password: YES)") {
+		t.Errorf("got %q", answer)
+	}
+}
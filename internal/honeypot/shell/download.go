@@ -0,0 +1,404 @@
+package shell
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// Fetcher downloads url for the command named command, which would send
+// userAgent over HTTP. It returns ErrNotFetched if downloads are not made;
+// the command then only pretends to download.
+type Fetcher func(command, url, userAgent string) (*Download, error)
+
+// Download is what a Fetcher got
+type Download struct {
+	Data        []byte
+	Status      int // HTTP status; 0 for other protocols
+	ContentType string
+}
+
+// ErrNotFetched is returned by a Fetcher that leaves a URL alone
+var ErrNotFetched = errors.New("not fetched")
+
+// registerDownloadCommands adds the emulators of the programs droppers fetch
+// their payloads with
+func registerDownloadCommands(r *Registry) {
+	r.Register(wget, "wget")
+	r.Register(curl, "curl")
+	r.Register(tftp, "tftp")
+	r.Register(ftpget, "ftpget")
+}
+
+// fetch downloads url with the session's Fetcher
+func (s *Session) fetch(command, url, userAgent string) (*Download, error) {
+	if s.Fetch == nil {
+		return nil, ErrNotFetched
+	}
+	return s.Fetch(command, url, userAgent)
+}
+
+// save writes a download to the file p, or to standard output if p is "-"
+func (ctx *Context) save(p string, data []byte, appending bool) error {
+	if p == "-" {
+		_, err := ctx.Stdout.Write(data)
+		return err
+	}
+	w, err := ctx.Session.create(p, appending)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// parseOptions separates options from operands. Single-letter options may be
+// grouped, as in -qO-; those in withArg take an argument, attached or as the
+// next word. long maps long options to the letters they stand for; others
+// are ignored. Options are returned with their argument, or "" if they take
+// none.
+func parseOptions(args []string, withArg string, long map[string]byte) (map[byte]string, []string) {
+	opts := make(map[byte]string)
+	var operands []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--":
+			return opts, append(operands, args[i+1:]...)
+		case strings.HasPrefix(arg, "--"):
+			name, value, hasValue := strings.Cut(arg[2:], "=")
+			c, ok := long[name]
+			if !ok {
+				continue
+			}
+			if strings.IndexByte(withArg, c) >= 0 && !hasValue && i+1 < len(args) {
+				i++
+				value = args[i]
+			}
+			opts[c] = value
+		case len(arg) > 1 && arg[0] == '-':
+			for j := 1; j < len(arg); j++ {
+				c := arg[j]
+				if strings.IndexByte(withArg, c) < 0 {
+					opts[c] = ""
+					continue
+				}
+				value := arg[j+1:]
+				if value == "" && i+1 < len(args) {
+					i++
+					value = args[i]
+				}
+				opts[c] = value
+				break
+			}
+		default:
+			operands = append(operands, arg)
+		}
+	}
+	return opts, operands
+}
+
+// parseURL parses a URL given to wget or curl, which take URLs without a
+// scheme as HTTP
+func parseURL(raw string) (*url.URL, error) {
+	if !strings.Contains(raw, "://") {
+		raw = "http://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err == nil && u.Host == "" {
+		err = fmt.Errorf("%s: missing host", raw)
+	}
+	return u, err
+}
+
+// hostPort returns the host and port u connects to
+func hostPort(u *url.URL) (string, string) {
+	port := u.Port()
+	if port == "" {
+		port = map[string]string{"https": "443", "ftp": "21", "tftp": "69"}[u.Scheme]
+	}
+	if port == "" {
+		port = "80"
+	}
+	return u.Hostname(), port
+}
+
+// humanSize formats a size as wget does, e.g. 1.2K or 34M
+func humanSize(n int) string {
+	v := float64(n)
+	for _, unit := range []string{"K", "M", "G"} {
+		v /= 1024
+		if v < 1024 || unit == "G" {
+			if v < 10 {
+				return fmt.Sprintf("%.1f%s", v, unit)
+			}
+			return fmt.Sprintf("%.0f%s", v, unit)
+		}
+	}
+	return ""
+}
+
+var wgetLong = map[string]byte{
+	"output-document": 'O', "output-file": 'o', "quiet": 'q', "user-agent": 'U',
+	"directory-prefix": 'P', "tries": 't', "timeout": 'T', "execute": 'e',
+}
+
+// wget downloads URLs with the session's Fetcher, printing what wget 1.20
+// does. Without one it pretends, and saves nothing.
+func wget(ctx *Context) int {
+	opts, operands := parseOptions(ctx.Args, "OoPUtTe", wgetLong)
+	if len(operands) == 0 {
+		ctx.Errorf("wget: missing URL\nUsage: wget [OPTION]... [URL]...\n\nTry `wget --help' for more options.\n")
+		return 1
+	}
+	log := ctx.Stderr
+	if _, quiet := opts['q']; quiet {
+		log = io.Discard
+	}
+	userAgent := "Wget/1.20.3 (linux-gnu)"
+	if ua, ok := opts['U']; ok {
+		userAgent = ua
+	}
+	output, toOutput := opts['O']
+
+	status := 0
+	for i, raw := range operands {
+		u, err := parseURL(raw)
+		if err != nil {
+			fmt.Fprintf(log, "%s: Invalid URL %s: Missing host\n", raw, raw)
+			status = 1
+			continue
+		}
+		name := output
+		if !toOutput {
+			name = path.Base(u.Path)
+			if u.Path == "" || strings.HasSuffix(u.Path, "/") {
+				name = "index.html"
+			}
+		}
+		host, port := hostPort(u)
+
+		fmt.Fprintf(log, "--%s--  %s\n", time.Now().Format("2006-01-02 15:04:05"), u)
+		ctx.Sleep(300 * time.Millisecond)
+		d, err := ctx.Session.fetch(ctx.Name, u.String(), userAgent)
+		if errors.Is(err, ErrNotFetched) {
+			fakeWget(ctx, log, host, name)
+			continue
+		}
+		if err != nil {
+			fmt.Fprintf(log, "Connecting to %s:%s... failed: Connection refused.\n", host, port)
+			status = 4
+			continue
+		}
+		fmt.Fprintf(log, "Connecting to %s:%s... connected.\n", host, port)
+		if d.Status != 0 {
+			fmt.Fprintf(log, "HTTP request sent, awaiting response... %d %s\n", d.Status, http.StatusText(d.Status))
+			if d.Status >= 400 {
+				fmt.Fprintf(log, "%s ERROR %d: %s.\n\n", time.Now().Format("2006-01-02 15:04:05"), d.Status, http.StatusText(d.Status))
+				status = 8
+				continue
+			}
+		} else {
+			fmt.Fprintf(log, "==> RETR %s ... done.\n", path.Base(u.Path))
+		}
+		length := fmt.Sprintf("Length: %d", len(d.Data))
+		if len(d.Data) >= 1024 {
+			length += " (" + humanSize(len(d.Data)) + ")"
+		}
+		if d.ContentType != "" {
+			length += " [" + d.ContentType + "]"
+		}
+		fmt.Fprintf(log, "%s\n", length)
+		if name == "-" {
+			fmt.Fprintf(log, "Saving to: 'STDOUT'\n\n")
+		} else {
+			fmt.Fprintf(log, "Saving to: '%s'\n\n", name)
+		}
+
+		// Files named with -O get every URL, one after the other
+		if err := ctx.save(name, d.Data, toOutput && i > 0); err != nil {
+			fmt.Fprintf(log, "Cannot write to '%s' (%s).\n", name, ErrText(err))
+			status = 3
+			continue
+		}
+		ctx.Sleep(100 * time.Millisecond)
+		fmt.Fprintf(log, "%-15s 100%%[===================>] %7d  --.-KB/s    in 0s\n\n", name, len(d.Data))
+		if name == "-" {
+			fmt.Fprintf(log, "%s (12.3 MB/s) - written to stdout [%d/%d]\n\n", time.Now().Format("2006-01-02 15:04:05"), len(d.Data), len(d.Data))
+		} else {
+			fmt.Fprintf(log, "%s (12.3 MB/s) - '%s' saved [%d/%d]\n\n", time.Now().Format("2006-01-02 15:04:05"), name, len(d.Data), len(d.Data))
+		}
+	}
+	return status
+}
+
+// fakeWget prints a download of 1024 bytes that never happened
+func fakeWget(ctx *Context, log io.Writer, host, name string) {
+	fmt.Fprintf(log, "Connecting to %s... connected.\n", host)
+	ctx.Sleep(200 * time.Millisecond)
+	fmt.Fprintf(log, "HTTP request sent, awaiting response... 200 OK\n")
+	fmt.Fprintf(log, "Length: 1024 (1.0K) [application/octet-stream]\n")
+	fmt.Fprintf(log, "Saving to: '%s'\n\n", name)
+	ctx.Sleep(100 * time.Millisecond)
+	fmt.Fprintf(log, "%-15s 100%%[===================>]   1.00K  --.-KB/s    in 0s\n\n", name)
+	fmt.Fprintf(log, "%s (12.3 MB/s) - '%s' saved [1024/1024]\n\n", time.Now().Format("2006-01-02 15:04:05"), name)
+}
+
+var curlLong = map[string]byte{
+	"output": 'o', "remote-name": 'O', "silent": 's', "show-error": 'S', "fail": 'f',
+	"user-agent": 'A', "header": 'H', "data": 'd', "request": 'X', "user": 'u',
+	"max-time": 'm', "proxy": 'x', "referer": 'e', "cookie": 'b', "cookie-jar": 'c',
+}
+
+// curl downloads URLs with the session's Fetcher, writing them to standard
+// output or the file given with -o or -O. Without a Fetcher it prints
+// nothing, as if the body were empty.
+func curl(ctx *Context) int {
+	opts, operands := parseOptions(ctx.Args, "AbcdeEFHKmorTuwxXyY", curlLong)
+	if len(operands) == 0 {
+		ctx.Errorf("curl: try 'curl --help' or 'curl --manual' for more information\n")
+		return 2
+	}
+	log := ctx.Stderr
+	_, silent := opts['s']
+	_, showError := opts['S']
+	if silent && !showError {
+		log = io.Discard
+	}
+	userAgent := "curl/7.68.0"
+	if ua, ok := opts['A']; ok {
+		userAgent = ua
+	}
+	_, fail := opts['f']
+
+	status := 0
+	for i, raw := range operands {
+		u, err := parseURL(raw)
+		if err != nil {
+			fmt.Fprintf(log, "curl: (3) URL using bad/illegal format or missing URL\n")
+			status = 3
+			continue
+		}
+		// -o and -O name the file of the first URL
+		name := "-"
+		if output, ok := opts['o']; ok && i == 0 {
+			name = output
+		} else if _, ok := opts['O']; ok && i == 0 {
+			if name = path.Base(u.Path); u.Path == "" || strings.HasSuffix(u.Path, "/") {
+				fmt.Fprintf(log, "curl: Remote file name has no length!\n")
+				status = 23
+				continue
+			}
+		}
+
+		d, err := ctx.Session.fetch(ctx.Name, u.String(), userAgent)
+		if errors.Is(err, ErrNotFetched) {
+			ctx.Sleep(300 * time.Millisecond)
+			continue
+		}
+		if err != nil {
+			host, port := hostPort(u)
+			fmt.Fprintf(log, "curl: (7) Failed to connect to %s port %s: Connection refused\n", host, port)
+			status = 7
+			continue
+		}
+		if fail && d.Status >= 400 {
+			fmt.Fprintf(log, "curl: (22) The requested URL returned error: %d %s\n", d.Status, http.StatusText(d.Status))
+			status = 22
+			continue
+		}
+		if err := ctx.save(name, d.Data, false); err != nil {
+			fmt.Fprintf(log, "Warning: Failed to create the file %s: %s\n", name, ErrText(err))
+			fmt.Fprintf(log, "curl: (23) Failed writing body (0 != %d)\n", len(d.Data))
+			status = 23
+		}
+	}
+	return status
+}
+
+// tftp emulates busybox tftp. Files are got with the session's Fetcher;
+// puts, and gets without a Fetcher, succeed silently.
+func tftp(ctx *Context) int {
+	opts, operands := parseOptions(ctx.Args, "lrb", nil)
+	remote, local := opts['r'], opts['l']
+	if remote == "" {
+		remote = local
+	}
+	if len(operands) == 0 || remote == "" {
+		ctx.Errorf("BusyBox v1.30.1 (Ubuntu 1:1.30.1-4ubuntu6.4) multi-call binary.\n\nUsage: tftp [OPTIONS] HOST [PORT]\n")
+		return 1
+	}
+	if local == "" {
+		local = path.Base(remote)
+	}
+	if _, get := opts['g']; !get {
+		ctx.Sleep(300 * time.Millisecond)
+		return 0
+	}
+
+	host := operands[0]
+	if len(operands) > 1 {
+		host = net.JoinHostPort(host, operands[1])
+	} else if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	d, err := ctx.Session.fetch(ctx.Name, "tftp://"+host+"/"+strings.TrimPrefix(remote, "/"), "")
+	if errors.Is(err, ErrNotFetched) {
+		ctx.Sleep(300 * time.Millisecond)
+		return 0
+	}
+	if err != nil {
+		ctx.Errorf("tftp: timeout\n")
+		return 1
+	}
+	if err := ctx.save(local, d.Data, false); err != nil {
+		ctx.Errorf("tftp: can't open '%s': %s\n", local, ErrText(err))
+		return 1
+	}
+	return 0
+}
+
+// ftpget emulates busybox ftpget: ftpget [-u USER] [-p PASS] [-P PORT] HOST
+// [LOCAL_FILE] REMOTE_FILE. Without a Fetcher it succeeds silently.
+func ftpget(ctx *Context) int {
+	opts, operands := parseOptions(ctx.Args, "upP", map[string]byte{"username": 'u', "password": 'p', "port": 'P'})
+	if len(operands) < 2 {
+		ctx.Errorf("BusyBox v1.30.1 (Ubuntu 1:1.30.1-4ubuntu6.4) multi-call binary.\n\nUsage: ftpget [OPTIONS] HOST [LOCAL_FILE] REMOTE_FILE\n")
+		return 1
+	}
+	host, remote := operands[0], operands[len(operands)-1]
+	local := path.Base(remote)
+	if len(operands) > 2 {
+		local = operands[1]
+	}
+	u := &url.URL{Scheme: "ftp", Host: host, Path: "/" + strings.TrimPrefix(remote, "/")}
+	if port, ok := opts['P']; ok {
+		u.Host = net.JoinHostPort(host, port)
+	} else if strings.Contains(host, ":") {
+		u.Host = "[" + host + "]"
+	}
+	if user, ok := opts['u']; ok {
+		u.User = url.UserPassword(user, opts['p'])
+	}
+
+	d, err := ctx.Session.fetch(ctx.Name, u.String(), "")
+	if errors.Is(err, ErrNotFetched) {
+		ctx.Sleep(300 * time.Millisecond)
+		return 0
+	}
+	if err != nil {
+		ctx.Errorf("ftpget: can't connect to remote host (%s): Connection refused\n", host)
+		return 1
+	}
+	if err := ctx.save(local, d.Data, false); err != nil {
+		ctx.Errorf("ftpget: can't open '%s': %s\n", local, ErrText(err))
+		return 1
+	}
+	return 0
+}
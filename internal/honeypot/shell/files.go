@@ -0,0 +1,895 @@
+package shell
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"phantom-grid/internal/honeypot/vfs"
+)
+
+// registerFileCommands adds the emulators of the programs that work on files
+func registerFileCommands(r *Registry) {
+	r.Register(ls, "ls", "dir")
+	r.Register(find, "find")
+	r.Register(stat, "stat")
+	r.Register(mkdir, "mkdir")
+	r.Register(rmdir, "rmdir")
+	r.Register(rm, "rm")
+	r.Register(touch, "touch")
+	r.Register(chmod, "chmod")
+	r.Register(chown, "chown", "chgrp")
+	r.Register(cp, "cp")
+	r.Register(mv, "mv")
+	r.Register(ln, "ln")
+	r.Register(readlink, "readlink")
+	r.Register(tee, "tee")
+}
+
+// modeString formats a mode as ls -l does, e.g. drwxr-xr-x
+func modeString(m fs.FileMode) string {
+	b := []byte("----------")
+	switch {
+	case m.IsDir():
+		b[0] = 'd'
+	case m&fs.ModeSymlink != 0:
+		b[0] = 'l'
+	case m&fs.ModeCharDevice != 0:
+		b[0] = 'c'
+	case m&fs.ModeDevice != 0:
+		b[0] = 'b'
+	case m&fs.ModeNamedPipe != 0:
+		b[0] = 'p'
+	case m&fs.ModeSocket != 0:
+		b[0] = 's'
+	}
+	const rwx = "rwxrwxrwx"
+	for i := 0; i < 9; i++ {
+		if m&(1<<uint(8-i)) != 0 {
+			b[i+1] = rwx[i]
+		}
+	}
+	special := func(i int, set bool, exec, noExec byte) {
+		if !set {
+			return
+		}
+		if b[i] == 'x' {
+			b[i] = exec
+		} else {
+			b[i] = noExec
+		}
+	}
+	special(3, m&fs.ModeSetuid != 0, 's', 'S')
+	special(6, m&fs.ModeSetgid != 0, 's', 'S')
+	special(9, m&fs.ModeSticky != 0, 't', 'T')
+	return string(b)
+}
+
+// idName returns the name of a user or group ID from /etc/passwd or
+// /etc/group, or the number if it has none
+func (s *Session) idName(db string, id int) string {
+	data, _ := s.FS.ReadFile(db)
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Split(line, ":")
+		if len(fields) > 2 && fields[2] == strconv.Itoa(id) {
+			return fields[0]
+		}
+	}
+	return strconv.Itoa(id)
+}
+
+// lookupID returns the ID of a user or group named in /etc/passwd or
+// /etc/group; numbers are taken as they are
+func (s *Session) lookupID(db, name string) (int, bool) {
+	if id, err := strconv.Atoi(name); err == nil && id >= 0 {
+		return id, true
+	}
+	data, _ := s.FS.ReadFile(db)
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Split(line, ":")
+		if len(fields) > 2 && fields[0] == name {
+			id, err := strconv.Atoi(fields[2])
+			return id, err == nil
+		}
+	}
+	return 0, false
+}
+
+// lsTime formats a modification time as ls -l does: recent ones with the
+// time, older ones with the year
+func lsTime(t time.Time) string {
+	if time.Since(t) > 180*24*time.Hour || time.Until(t) > time.Hour {
+		return t.Format("Jan _2  2006")
+	}
+	return t.Format("Jan _2 15:04")
+}
+
+func ls(ctx *Context) int {
+	s := ctx.Session
+	flags, operands := splitFlags(ctx.Args)
+	long := strings.ContainsAny(flags, "lgno")
+	all := strings.Contains(flags, "a")
+	almostAll := strings.Contains(flags, "A")
+	dirsAsFiles := strings.Contains(flags, "d")
+	if len(operands) == 0 {
+		operands = []string{"."}
+	}
+
+	// Files named on the command line are listed first, then directories
+	var files []vfs.FileInfo
+	var dirs []string
+	status := 0
+	for _, op := range operands {
+		fi, err := s.FS.Stat(s.Resolve(op))
+		if err != nil {
+			ctx.Errorf("ls: cannot access '%s': %s\n", op, ErrText(err))
+			status = 2
+			continue
+		}
+		if fi.IsDir() && !dirsAsFiles {
+			dirs = append(dirs, op)
+			continue
+		}
+		if long {
+			fi, _ = s.FS.Lstat(s.Resolve(op))
+		}
+		fi.Name = op
+		files = append(files, fi)
+	}
+
+	list := func(infos []vfs.FileInfo) {
+		if !long {
+			names := make([]string, len(infos))
+			for i, fi := range infos {
+				names[i] = fi.Name
+			}
+			if len(names) > 0 {
+				ctx.Printf("%s\n", strings.Join(names, "  "))
+			}
+			return
+		}
+		var lines [][]string
+		widths := make([]int, 4)
+		for _, fi := range infos {
+			fields := []string{
+				strconv.Itoa(fi.Nlink),
+				s.idName("/etc/passwd", fi.UID),
+				s.idName("/etc/group", fi.GID),
+				strconv.FormatInt(fi.Size, 10),
+			}
+			for i, f := range fields {
+				if len(f) > widths[i] {
+					widths[i] = len(f)
+				}
+			}
+			lines = append(lines, fields)
+		}
+		for i, fi := range infos {
+			f := lines[i]
+			name := fi.Name
+			if fi.Mode&fs.ModeSymlink != 0 {
+				name += " -> " + fi.Target
+			}
+			ctx.Printf("%s %*s %-*s %-*s %*s %s %s\n", modeString(fi.Mode),
+				widths[0], f[0], widths[1], f[1], widths[2], f[2], widths[3], f[3], lsTime(fi.ModTime), name)
+		}
+	}
+
+	list(files)
+	for i, op := range dirs {
+		if len(operands) > 1 {
+			if i > 0 || len(files) > 0 {
+				ctx.Printf("\n")
+			}
+			ctx.Printf("%s:\n", op)
+		}
+		abs := s.Resolve(op)
+		fi, _ := s.FS.Stat(abs)
+		if !s.Access(fi, 4) {
+			ctx.Errorf("ls: cannot open directory '%s': Permission denied\n", op)
+			status = 2
+			continue
+		}
+		entries, _ := s.FS.ReadDir(abs)
+		var shown []vfs.FileInfo
+		if all {
+			dot, _ := s.FS.Stat(abs)
+			dotdot, _ := s.FS.Stat(path.Dir(abs))
+			dot.Name, dotdot.Name = ".", ".."
+			shown = append(shown, dot, dotdot)
+		}
+		blocks := int64(0)
+		for _, e := range entries {
+			if strings.HasPrefix(e.Name, ".") && !all && !almostAll {
+				continue
+			}
+			shown = append(shown, e)
+		}
+		for _, e := range shown {
+			blocks += (e.Size + 4095) / 4096 * 4
+		}
+		if long {
+			ctx.Printf("total %d\n", blocks)
+		}
+		list(shown)
+	}
+	return status
+}
+
+func find(ctx *Context) int {
+	s := ctx.Session
+	var roots []string
+	i := 0
+	for ; i < len(ctx.Args) && !strings.HasPrefix(ctx.Args[i], "-"); i++ {
+		roots = append(roots, ctx.Args[i])
+	}
+	if len(roots) == 0 {
+		roots = []string{"."}
+	}
+	name, kind, maxDepth := "", "", 32
+	for ; i < len(ctx.Args); i++ {
+		if i+1 >= len(ctx.Args) {
+			break
+		}
+		switch ctx.Args[i] {
+		case "-name", "-iname":
+			i++
+			name = ctx.Args[i]
+		case "-type":
+			i++
+			kind = ctx.Args[i]
+		case "-maxdepth":
+			i++
+			if n, err := strconv.Atoi(ctx.Args[i]); err == nil {
+				maxDepth = n
+			}
+		}
+	}
+
+	match := func(fi vfs.FileInfo) bool {
+		if name != "" {
+			if ok, err := path.Match(name, fi.Name); err != nil || !ok {
+				return false
+			}
+		}
+		switch kind {
+		case "f":
+			return fi.Mode.IsRegular()
+		case "d":
+			return fi.IsDir()
+		case "l":
+			return fi.Mode&fs.ModeSymlink != 0
+		}
+		return true
+	}
+
+	status := 0
+	var walk func(abs, shown string, fi vfs.FileInfo, depth int)
+	walk = func(abs, shown string, fi vfs.FileInfo, depth int) {
+		if match(fi) {
+			ctx.Printf("%s\n", shown)
+		}
+		if !fi.IsDir() || depth >= maxDepth {
+			return
+		}
+		if !s.Access(fi, 4) {
+			ctx.Errorf("find: '%s': Permission denied\n", shown)
+			status = 1
+			return
+		}
+		entries, _ := s.FS.ReadDir(abs)
+		for _, e := range entries {
+			walk(path.Join(abs, e.Name), strings.TrimSuffix(shown, "/")+"/"+e.Name, e, depth+1)
+		}
+	}
+	for _, root := range roots {
+		abs := s.Resolve(root)
+		fi, err := s.FS.Stat(abs)
+		if err != nil {
+			ctx.Errorf("find: '%s': %s\n", root, ErrText(err))
+			status = 1
+			continue
+		}
+		fi.Name = path.Base(abs)
+		walk(abs, root, fi, 0)
+	}
+	return status
+}
+
+func stat(ctx *Context) int {
+	s := ctx.Session
+	_, operands := splitFlags(ctx.Args)
+	if len(operands) == 0 {
+		ctx.Errorf("stat: missing operand\n")
+		return 1
+	}
+	status := 0
+	for _, op := range operands {
+		fi, err := s.FS.Lstat(s.Resolve(op))
+		if err != nil {
+			ctx.Errorf("stat: cannot statx '%s': %s\n", op, ErrText(err))
+			status = 1
+			continue
+		}
+		kind := "regular file"
+		switch {
+		case fi.IsDir():
+			kind = "directory"
+		case fi.Mode&fs.ModeSymlink != 0:
+			kind = "symbolic link"
+			op += " -> " + fi.Target
+		case fi.Mode&fs.ModeCharDevice != 0:
+			kind = "character special file"
+		case fi.Size == 0:
+			kind = "regular empty file"
+		}
+		stamp := fi.ModTime.Format("2006-01-02 15:04:05.000000000 -0700")
+		ctx.Printf("  File: %s\n", op)
+		ctx.Printf("  Size: %-15d Blocks: %-10d IO Block: 4096   %s\n", fi.Size, (fi.Size+4095)/4096*8, kind)
+		ctx.Printf("Device: 801h/2049d\tInode: %-11d Links: %d\n", fi.Ino, fi.Nlink)
+		ctx.Printf("Access: (%04o/%s)  Uid: (%5d/%8s)   Gid: (%5d/%8s)\n", fi.Mode.Perm(), modeString(fi.Mode),
+			fi.UID, s.idName("/etc/passwd", fi.UID), fi.GID, s.idName("/etc/group", fi.GID))
+		ctx.Printf("Access: %s\nModify: %s\nChange: %s\n Birth: -\n", stamp, stamp, stamp)
+	}
+	return status
+}
+
+// fileOp runs fn on each operand, reporting failures as "name: what 'op': error"
+func fileOp(ctx *Context, operands []string, what string, fn func(abs string) error) int {
+	if len(operands) == 0 {
+		ctx.Errorf("%s: missing operand\n", ctx.Name)
+		return 1
+	}
+	status := 0
+	for _, op := range operands {
+		if err := fn(ctx.Session.Resolve(op)); err != nil {
+			what := what
+			var e *opError
+			if errors.As(err, &e) {
+				what = e.what
+			}
+			ctx.Errorf("%s: %s '%s': %s\n", ctx.Name, what, op, ErrText(err))
+			status = 1
+		}
+	}
+	return status
+}
+
+// opError is an error that fileOp reports with its own description of what
+// failed
+type opError struct {
+	what string
+	err  error
+}
+
+func (e *opError) Error() string { return e.err.Error() }
+func (e *opError) Unwrap() error { return e.err }
+
+// writable fails with EACCES unless the directory holding abs may be changed
+func (s *Session) writable(abs string) error {
+	fi, err := s.FS.Stat(path.Dir(abs))
+	if err == nil && !s.Access(fi, 2) {
+		return pathErr("open", abs, syscall.EACCES)
+	}
+	return nil
+}
+
+func mkdir(ctx *Context) int {
+	s := ctx.Session
+	parents := false
+	perm := fs.FileMode(0755)
+	var operands []string
+	for i := 0; i < len(ctx.Args); i++ {
+		switch arg := ctx.Args[i]; {
+		case arg == "-m" && i+1 < len(ctx.Args):
+			i++
+			if m, err := strconv.ParseUint(ctx.Args[i], 8, 32); err == nil {
+				perm = fs.FileMode(m)
+			}
+		case strings.HasPrefix(arg, "-") && len(arg) > 1:
+			parents = parents || strings.Contains(arg, "p")
+		default:
+			operands = append(operands, arg)
+		}
+	}
+	return fileOp(ctx, operands, "cannot create directory", func(abs string) error {
+		if err := s.writable(abs); err != nil {
+			return err
+		}
+		if parents {
+			return s.FS.MkdirAll(abs, perm)
+		}
+		return s.FS.Mkdir(abs, perm)
+	})
+}
+
+func rmdir(ctx *Context) int {
+	s := ctx.Session
+	_, operands := splitFlags(ctx.Args)
+	return fileOp(ctx, operands, "failed to remove", func(abs string) error {
+		if fi, err := s.FS.Lstat(abs); err == nil && !fi.IsDir() {
+			return pathErr("rmdir", abs, syscall.ENOTDIR)
+		}
+		if err := s.writable(abs); err != nil {
+			return err
+		}
+		return s.FS.Remove(abs)
+	})
+}
+
+func rm(ctx *Context) int {
+	s := ctx.Session
+	flags, operands := splitFlags(ctx.Args)
+	recursive := strings.ContainsAny(flags, "rR") || strings.Contains(flags, "recursive")
+	force := strings.Contains(flags, "f")
+	preserveRoot := !strings.Contains(flags, "no-preserve-root")
+	if len(operands) == 0 && force {
+		return 0
+	}
+	status := 0
+	for _, op := range operands {
+		abs := s.Resolve(op)
+		fi, err := s.FS.Lstat(abs)
+		switch {
+		case err != nil:
+			if !force {
+				ctx.Errorf("rm: cannot remove '%s': %s\n", op, ErrText(err))
+				status = 1
+			}
+			continue
+		case fi.IsDir() && !recursive:
+			ctx.Errorf("rm: cannot remove '%s': Is a directory\n", op)
+			status = 1
+			continue
+		case abs == "/" && preserveRoot:
+			ctx.Errorf("rm: it is dangerous to operate recursively on '/'\n")
+			ctx.Errorf("rm: use --no-preserve-root to override this failsafe\n")
+			status = 1
+			continue
+		}
+		if err := s.writable(abs); err != nil {
+			ctx.Errorf("rm: cannot remove '%s': %s\n", op, ErrText(err))
+			status = 1
+			continue
+		}
+		if abs == "/" {
+			// The root itself stays; everything in it goes
+			entries, _ := s.FS.ReadDir("/")
+			for _, e := range entries {
+				s.FS.RemoveAll("/" + e.Name)
+			}
+			continue
+		}
+		s.FS.RemoveAll(abs)
+	}
+	return status
+}
+
+func touch(ctx *Context) int {
+	s := ctx.Session
+	_, operands := splitFlags(ctx.Args)
+	return fileOp(ctx, operands, "cannot touch", func(abs string) error {
+		if _, err := s.FS.Stat(abs); err == nil {
+			if err := s.checkWrite(abs); err != nil {
+				return err
+			}
+			return s.FS.Chtimes(abs, s.FS.Now())
+		}
+		if err := s.writable(abs); err != nil {
+			return err
+		}
+		return s.FS.WriteFile(abs, nil, 0644)
+	})
+}
+
+// parseMode applies a chmod mode, octal or symbolic like u+x,go-w, to m
+func parseMode(spec string, m fs.FileMode) (fs.FileMode, bool) {
+	if n, err := strconv.ParseUint(spec, 8, 32); err == nil {
+		return fs.FileMode(n&0777) | modeSpecial(n), n <= 07777
+	}
+	for _, clause := range strings.Split(spec, ",") {
+		i := strings.IndexAny(clause, "+-=")
+		if i < 0 {
+			return m, false
+		}
+		who, op, perms := clause[:i], clause[i], clause[i+1:]
+		var mask fs.FileMode
+		if who == "" || strings.Contains(who, "a") {
+			who = "ugo"
+		}
+		for _, w := range who {
+			switch w {
+			case 'u':
+				mask |= 0700 | fs.ModeSetuid
+			case 'g':
+				mask |= 0070 | fs.ModeSetgid
+			case 'o':
+				mask |= 0007 | fs.ModeSticky
+			default:
+				return m, false
+			}
+		}
+		var bits fs.FileMode
+		for _, p := range perms {
+			switch p {
+			case 'r':
+				bits |= 0444
+			case 'w':
+				bits |= 0222
+			case 'x':
+				bits |= 0111
+			case 'X':
+				if m.IsDir() || m&0111 != 0 {
+					bits |= 0111
+				}
+			case 's':
+				bits |= fs.ModeSetuid | fs.ModeSetgid
+			case 't':
+				bits |= fs.ModeSticky
+			default:
+				return m, false
+			}
+		}
+		bits &= mask
+		switch op {
+		case '+':
+			m |= bits
+		case '-':
+			m &^= bits
+		case '=':
+			m = m&^mask | bits
+		}
+	}
+	return m, true
+}
+
+// modeSpecial converts the setuid, setgid and sticky bits of an octal mode
+func modeSpecial(n uint64) fs.FileMode {
+	var m fs.FileMode
+	if n&04000 != 0 {
+		m |= fs.ModeSetuid
+	}
+	if n&02000 != 0 {
+		m |= fs.ModeSetgid
+	}
+	if n&01000 != 0 {
+		m |= fs.ModeSticky
+	}
+	return m
+}
+
+// walkTree calls fn on abs and, if recursive, everything under it
+func (s *Session) walkTree(abs string, recursive bool, fn func(abs string, fi vfs.FileInfo) error) error {
+	fi, err := s.FS.Lstat(abs)
+	if err != nil {
+		return err
+	}
+	if fi.Mode&fs.ModeSymlink == 0 {
+		if err := fn(abs, fi); err != nil {
+			return err
+		}
+	}
+	if recursive && fi.IsDir() {
+		entries, _ := s.FS.ReadDir(abs)
+		for _, e := range entries {
+			if err := s.walkTree(path.Join(abs, e.Name), true, fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// owned fails with EPERM unless the user owns the file, as changing its
+// mode requires
+func (s *Session) owned(abs string, fi vfs.FileInfo) error {
+	if s.UID != 0 && s.UID != fi.UID {
+		return &opError{"changing permissions of", pathErr("chmod", abs, syscall.EPERM)}
+	}
+	return nil
+}
+
+func chmod(ctx *Context) int {
+	s := ctx.Session
+	var recursive bool
+	var rest []string
+	for _, arg := range ctx.Args {
+		// Symbolic modes like -x look like flags
+		if arg == "-R" || arg == "--recursive" {
+			recursive = true
+		} else if arg != "-f" && arg != "-v" {
+			rest = append(rest, arg)
+		}
+	}
+	if len(rest) < 2 {
+		ctx.Errorf("chmod: missing operand\n")
+		return 1
+	}
+	spec := rest[0]
+	if _, ok := parseMode(spec, 0); !ok {
+		ctx.Errorf("chmod: invalid mode: '%s'\n", spec)
+		return 1
+	}
+	return fileOp(ctx, rest[1:], "cannot access", func(abs string) error {
+		if _, err := s.FS.Stat(abs); err != nil {
+			return err
+		}
+		return s.walkTree(abs, recursive, func(p string, fi vfs.FileInfo) error {
+			if err := s.owned(p, fi); err != nil {
+				return err
+			}
+			m, _ := parseMode(spec, fi.Mode)
+			return s.FS.Chmod(p, m)
+		})
+	})
+}
+
+func chown(ctx *Context) int {
+	s := ctx.Session
+	flags, operands := splitFlags(ctx.Args)
+	recursive := strings.ContainsAny(flags, "R")
+	if len(operands) < 2 {
+		ctx.Errorf("%s: missing operand\n", ctx.Name)
+		return 1
+	}
+	spec := operands[0]
+	uid, gid := -1, -1
+	user, group, _ := strings.Cut(spec, ":")
+	if ctx.Name == "chgrp" {
+		user, group = "", spec
+	}
+	if user != "" {
+		id, ok := s.lookupID("/etc/passwd", user)
+		if !ok {
+			ctx.Errorf("%s: invalid user: '%s'\n", ctx.Name, spec)
+			return 1
+		}
+		uid = id
+	}
+	if group != "" {
+		id, ok := s.lookupID("/etc/group", group)
+		if !ok {
+			ctx.Errorf("%s: invalid group: '%s'\n", ctx.Name, spec)
+			return 1
+		}
+		gid = id
+	}
+	return fileOp(ctx, operands[1:], "cannot access", func(abs string) error {
+		if _, err := s.FS.Stat(abs); err != nil {
+			return err
+		}
+		return s.walkTree(abs, recursive, func(p string, fi vfs.FileInfo) error {
+			if s.UID != 0 {
+				return &opError{"changing ownership of", pathErr("chown", p, syscall.EPERM)}
+			}
+			return s.FS.Chown(p, uid, gid)
+		})
+	})
+}
+
+// target returns where src goes when copied or moved to dst: into dst if it
+// is a directory
+func (s *Session) target(src, dst string) string {
+	if s.IsDir(dst) {
+		return path.Join(dst, path.Base(src))
+	}
+	return dst
+}
+
+// copyTree copies the file or directory src to dst
+func (s *Session) copyTree(src, dst string, recursive bool) error {
+	fi, err := s.FS.Stat(src)
+	if err != nil {
+		return err
+	}
+	if !s.Access(fi, 4) {
+		return pathErr("open", src, syscall.EACCES)
+	}
+	if err := s.writable(dst); err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		data, err := s.FS.ReadFile(src)
+		if err != nil {
+			return err
+		}
+		if err := s.FS.WriteFile(dst, data, fi.Mode.Perm()); err != nil {
+			return err
+		}
+		return s.FS.Chmod(dst, fi.Mode)
+	}
+	if !recursive {
+		return errOmitDir
+	}
+	if dst == src || strings.HasPrefix(dst, src+"/") {
+		return fmt.Errorf("cannot copy a directory, '%s', into itself", src)
+	}
+	if !s.IsDir(dst) {
+		if err := s.FS.Mkdir(dst, fi.Mode.Perm()); err != nil {
+			return err
+		}
+	}
+	entries, _ := s.FS.ReadDir(src)
+	for _, e := range entries {
+		if err := s.copyTree(path.Join(src, e.Name), path.Join(dst, e.Name), true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var errOmitDir = errors.New("omitting directory")
+
+func cp(ctx *Context) int {
+	s := ctx.Session
+	flags, operands := splitFlags(ctx.Args)
+	recursive := strings.ContainsAny(flags, "rRa")
+	if len(operands) < 2 {
+		ctx.Errorf("cp: missing destination file operand after '%s'\n", strings.Join(operands, " "))
+		return 1
+	}
+	dst := s.Resolve(operands[len(operands)-1])
+	srcs := operands[:len(operands)-1]
+	if len(srcs) > 1 && !s.IsDir(dst) {
+		ctx.Errorf("cp: target '%s' is not a directory\n", operands[len(operands)-1])
+		return 1
+	}
+	status := 0
+	for _, op := range srcs {
+		src := s.Resolve(op)
+		err := s.copyTree(src, s.target(src, dst), recursive)
+		switch {
+		case err == errOmitDir:
+			ctx.Errorf("cp: -r not specified; omitting directory '%s'\n", op)
+		case err != nil && s.Exists(src):
+			ctx.Errorf("cp: cannot create regular file '%s': %s\n", operands[len(operands)-1], ErrText(err))
+		case err != nil:
+			ctx.Errorf("cp: cannot stat '%s': %s\n", op, ErrText(err))
+		default:
+			continue
+		}
+		status = 1
+	}
+	return status
+}
+
+func mv(ctx *Context) int {
+	s := ctx.Session
+	_, operands := splitFlags(ctx.Args)
+	if len(operands) < 2 {
+		ctx.Errorf("mv: missing destination file operand after '%s'\n", strings.Join(operands, " "))
+		return 1
+	}
+	dst := s.Resolve(operands[len(operands)-1])
+	srcs := operands[:len(operands)-1]
+	if len(srcs) > 1 && !s.IsDir(dst) {
+		ctx.Errorf("mv: target '%s' is not a directory\n", operands[len(operands)-1])
+		return 1
+	}
+	status := 0
+	for _, op := range srcs {
+		src := s.Resolve(op)
+		if !s.Exists(src) {
+			ctx.Errorf("mv: cannot stat '%s': No such file or directory\n", op)
+			status = 1
+			continue
+		}
+		to := s.target(src, dst)
+		err := s.writable(src)
+		if err == nil {
+			err = s.writable(to)
+		}
+		if err == nil {
+			err = s.FS.Rename(src, to)
+		}
+		if err != nil {
+			ctx.Errorf("mv: cannot move '%s' to '%s': %s\n", op, operands[len(operands)-1], ErrText(err))
+			status = 1
+		}
+	}
+	return status
+}
+
+func ln(ctx *Context) int {
+	s := ctx.Session
+	flags, operands := splitFlags(ctx.Args)
+	symbolic := strings.Contains(flags, "s")
+	force := strings.Contains(flags, "f")
+	if len(operands) == 0 {
+		ctx.Errorf("ln: missing file operand\n")
+		return 1
+	}
+	target := operands[0]
+	link := path.Base(target)
+	if len(operands) > 1 {
+		link = operands[1]
+	}
+	abs := s.target(target, s.Resolve(link))
+	if force {
+		if fi, err := s.FS.Lstat(abs); err == nil && !fi.IsDir() {
+			s.FS.Remove(abs)
+		}
+	}
+	if err := s.writable(abs); err != nil {
+		ctx.Errorf("ln: failed to create symbolic link '%s': %s\n", link, ErrText(err))
+		return 1
+	}
+	if symbolic {
+		if err := s.FS.Symlink(target, abs); err != nil {
+			ctx.Errorf("ln: failed to create symbolic link '%s': %s\n", link, ErrText(err))
+			return 1
+		}
+		return 0
+	}
+	// Hard links are made as copies
+	src := s.Resolve(target)
+	if s.Exists(abs) {
+		ctx.Errorf("ln: failed to create hard link '%s': File exists\n", link)
+		return 1
+	}
+	if err := s.copyTree(src, abs, false); err != nil {
+		if err == errOmitDir {
+			ctx.Errorf("ln: %s: hard link not allowed for directory\n", target)
+		} else {
+			ctx.Errorf("ln: failed to access '%s': %s\n", target, ErrText(err))
+		}
+		return 1
+	}
+	return 0
+}
+
+func readlink(ctx *Context) int {
+	s := ctx.Session
+	flags, operands := splitFlags(ctx.Args)
+	canonical := strings.ContainsAny(flags, "fem")
+	status := 0
+	for _, op := range operands {
+		abs := s.Resolve(op)
+		if canonical {
+			// Follow links one at a time, as the file system does
+			for i := 0; i < 40; i++ {
+				target, err := s.FS.Readlink(abs)
+				if err != nil {
+					break
+				}
+				if !path.IsAbs(target) {
+					target = path.Join(path.Dir(abs), target)
+				}
+				abs = target
+			}
+			ctx.Printf("%s\n", abs)
+			continue
+		}
+		target, err := s.FS.Readlink(abs)
+		if err != nil {
+			status = 1
+			continue
+		}
+		ctx.Printf("%s\n", target)
+	}
+	return status
+}
+
+func tee(ctx *Context) int {
+	s := ctx.Session
+	flags, operands := splitFlags(ctx.Args)
+	writers := []io.Writer{ctx.Stdout}
+	status := 0
+	for _, op := range operands {
+		w, err := s.create(op, strings.Contains(flags, "a"))
+		if err != nil {
+			ctx.Errorf("tee: %s: %s\n", op, ErrText(err))
+			status = 1
+			continue
+		}
+		writers = append(writers, w)
+	}
+	io.Copy(io.MultiWriter(writers...), ctx.Stdin)
+	return status
+}
@@ -0,0 +1,139 @@
+package shell
+
+import (
+	"bytes"
+	"io/fs"
+	"testing"
+)
+
+func TestFileCommands(t *testing.T) {
+	tests := []struct {
+		name       string
+		lines      []string // Run in order; only the output of the last is checked
+		want       string
+		wantStatus int
+	}{
+		{"mkdir", []string{"mkdir /tmp/a", "ls -d /tmp/a"}, "/tmp/a\n", 0},
+		{"mkdir exists", []string{"mkdir /tmp"}, "mkdir: cannot create directory '/tmp': File exists\n", 1},
+		{"mkdir missing parent", []string{"mkdir /tmp/a/b"}, "mkdir: cannot create directory '/tmp/a/b': No such file or directory\n", 1},
+		{"mkdir -p", []string{"mkdir -p /tmp/a/b/c", "cd /tmp/a/b/c && pwd"}, "/tmp/a/b/c\n", 0},
+		{"rmdir not empty", []string{"rmdir /etc"}, "rmdir: failed to remove '/etc': Directory not empty\n", 1},
+		{"rmdir file", []string{"rmdir /etc/passwd"}, "rmdir: failed to remove '/etc/passwd': Not a directory\n", 1},
+		{"touch", []string{"cd /tmp", "touch a b", "ls"}, "a  b  bot  run.sh\n", 0},
+		{"touch missing dir", []string{"touch /nope/a"}, "touch: cannot touch '/nope/a': No such file or directory\n", 1},
+		{"rm", []string{"rm /tmp/bot", "ls /tmp"}, "run.sh\n", 0},
+		{"rm missing", []string{"rm /tmp/nope"}, "rm: cannot remove '/tmp/nope': No such file or directory\n", 1},
+		{"rm -f missing", []string{"rm -f /tmp/nope"}, "", 0},
+		{"rm dir", []string{"rm /tmp"}, "rm: cannot remove '/tmp': Is a directory\n", 1},
+		{"rm -rf", []string{"rm -rf /var", "ls /"}, "etc  home  root  tmp\n", 0},
+		{"rm -rf root refused", []string{"rm -rf /"}, "rm: it is dangerous to operate recursively on '/'\nrm: use --no-preserve-root to override this failsafe\n", 1},
+		{"rm -rf root", []string{"rm -rf --no-preserve-root /", "ls -a /"}, ".  ..\n", 0},
+		{"mv", []string{"mv /tmp/run.sh /tmp/x.sh", "ls /tmp"}, "bot  x.sh\n", 0},
+		{"mv into dir", []string{"mv /tmp/bot /root", "ls /root"}, "bot  notes.txt\n", 0},
+		{"mv missing", []string{"mv /nope /tmp"}, "mv: cannot stat '/nope': No such file or directory\n", 1},
+		{"cp", []string{"cp /root/notes.txt /tmp/n", "cat /tmp/n"}, "one\ntwo\nthree\n", 0},
+		{"cp keeps mode", []string{"cp /tmp/bot /root/bot2", "stat /root/bot2 | grep Access: | head -1"}, "Access: (0755/-rwxr-xr-x)  Uid: (    0/    root)   Gid: (    0/    root)\n", 0},
+		{"cp dir without -r", []string{"cp /etc /tmp"}, "cp: -r not specified; omitting directory '/etc'\n", 1},
+		{"cp -r", []string{"cp -r /home /tmp/h", "find /tmp/h"}, "/tmp/h\n/tmp/h/user\n/tmp/h/user/.bashrc\n", 0},
+		{"cp missing", []string{"cp /nope /tmp"}, "cp: cannot stat '/nope': No such file or directory\n", 1},
+		{"ln -s", []string{"ln -s /etc/passwd /tmp/p", "head -1 /tmp/p"}, "root:x:0:0:root:/root:/bin/bash\n", 0},
+		{"ln -s listing", []string{"ln -s /etc/passwd /tmp/p", "ls -l /tmp/p"}, "lrwxrwxrwx 1 root root 11 Dec 15  2021 /tmp/p -> /etc/passwd\n", 0},
+		{"ln -s into dir", []string{"ln -s /etc/passwd /tmp", "readlink /tmp/passwd"}, "/etc/passwd\n", 0},
+		{"ln -s exists", []string{"ln -s /etc /tmp/bot"}, "ln: failed to create symbolic link '/tmp/bot': File exists\n", 1},
+		{"ln -sf", []string{"ln -sf /etc /tmp/bot", "readlink -f /tmp/bot"}, "/etc\n", 0},
+		{"cd through symlink", []string{"ln -s /var/log /tmp/l", "cd /tmp/l && ls"}, "auth.log\n", 0},
+		{"chmod octal", []string{"chmod 600 /tmp/run.sh", "ls -l /tmp/run.sh"}, "-rw------- 1 root root 17 Dec 15  2021 /tmp/run.sh\n", 0},
+		{"chmod symbolic", []string{"chmod u+x,go-r /tmp/run.sh", "ls -l /tmp/run.sh"}, "-rwx------ 1 root root 17 Dec 15  2021 /tmp/run.sh\n", 0},
+		{"chmod minus looks like a flag", []string{"chmod -x /tmp/bot", "ls -l /tmp/bot"}, "-rw-r--r-- 1 root root 8 Dec 15  2021 /tmp/bot\n", 0},
+		{"chmod setuid", []string{"chmod 4755 /tmp/bot", "ls -l /tmp/bot"}, "-rwsr-xr-x 1 root root 8 Dec 15  2021 /tmp/bot\n", 0},
+		{"chmod sticky", []string{"chmod +t /tmp", "ls -ld /tmp"}, "drwxr-xr-t 2 root root 4096 Dec 15  2021 /tmp\n", 0},
+		{"chmod invalid", []string{"chmod z+q /tmp/bot"}, "chmod: invalid mode: 'z+q'\n", 1},
+		{"chmod missing", []string{"chmod 777 /nope"}, "chmod: cannot access '/nope': No such file or directory\n", 1},
+		{"chown", []string{"chown user:shadow /tmp/bot", "ls -l /tmp/bot"}, "-rwxr-xr-x 1 user shadow 8 Dec 15  2021 /tmp/bot\n", 0},
+		{"chown unknown", []string{"chown mallory /tmp/bot"}, "chown: invalid user: 'mallory'\n", 1},
+		{"chgrp numeric", []string{"chgrp 4242 /tmp/bot", "ls -l /tmp/bot"}, "-rwxr-xr-x 1 root 4242 8 Dec 15  2021 /tmp/bot\n", 0},
+		{"ls -l dir", []string{"ls -l /etc"}, "total 12\n-rw-r--r-- 1 root root    36 Dec 15  2021 group\n-rw-r--r-- 1 root root   119 Dec 15  2021 passwd\n-rw-r----- 1 root shadow  37 Dec 15  2021 shadow\n", 0},
+		{"ls files then dirs", []string{"ls /etc/passwd /home"}, "/etc/passwd\n\n/home:\nuser\n", 0},
+		{"tee", []string{"echo x | tee /tmp/t", "cat /tmp/t"}, "x\n", 0},
+		{"tee -a", []string{"echo x > /tmp/t", "echo y | tee -a /tmp/t > /dev/null", "cat /tmp/t"}, "x\ny\n", 0},
+		{"find type", []string{"find / -type d -name 'u*'"}, "/home/user\n", 0},
+		{"find maxdepth", []string{"find /home -maxdepth 1"}, "/home\n/home/user\n", 0},
+		{"stat", []string{"stat /etc/passwd | head -2"}, "  File: /etc/passwd\n  Size: 119             Blocks: 8          IO Block: 4096   regular file\n", 0},
+		{"echo -e dropper", []string{`echo -ne '\x7f\x45\x4c\x46' > /tmp/.d`, "wc -c /tmp/.d"}, "4 /tmp/.d\n", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestSession(t)
+			var out bytes.Buffer
+			status := 0
+			for _, line := range tt.lines {
+				out.Reset()
+				status = s.Run(&out, line)
+			}
+			if out.String() != tt.want {
+				t.Errorf("output = %q, want %q", out.String(), tt.want)
+			}
+			if status != tt.wantStatus {
+				t.Errorf("status = %d, want %d", status, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestPermissions(t *testing.T) {
+	tests := []struct {
+		name       string
+		line       string
+		want       string
+		wantStatus int
+	}{
+		{"read shadow", "cat /etc/shadow", "cat: /etc/shadow: Permission denied\n", 1},
+		{"read passwd", "head -1 /etc/passwd", "root:x:0:0:root:/root:/bin/bash\n", 0},
+		{"cd root", "cd /root", "-bash: cd: /root: Permission denied\n", 1},
+		{"list root", "ls /root", "ls: cannot open directory '/root': Permission denied\n", 2},
+		{"write etc", "echo x > /etc/x", "-bash: /etc/x: Permission denied\n", 1},
+		{"write passwd", "echo x >> /etc/passwd", "-bash: /etc/passwd: Permission denied\n", 1},
+		{"rm in etc", "rm -f /etc/passwd", "rm: cannot remove '/etc/passwd': Permission denied\n", 1},
+		{"mkdir in etc", "mkdir /etc/x", "mkdir: cannot create directory '/etc/x': Permission denied\n", 1},
+		{"chmod not owned", "chmod 777 /etc/passwd", "chmod: changing permissions of '/etc/passwd': Operation not permitted\n", 1},
+		{"write home", "echo x > ~/x && cat ~/x", "x\n", 0},
+		{"run other's binary", "/tmp/bot", "-bash: /tmp/bot: cannot execute binary file: Exec format error\n", 126},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestSession(t)
+			s.User, s.UID, s.GID, s.Cwd = "user", 1000, 1000, "/home/user"
+			s.Env["HOME"] = "/home/user"
+			var out bytes.Buffer
+			status := s.Run(&out, tt.line)
+			if out.String() != tt.want {
+				t.Errorf("output = %q, want %q", out.String(), tt.want)
+			}
+			if status != tt.wantStatus {
+				t.Errorf("status = %d, want %d", status, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestModeString(t *testing.T) {
+	tests := []struct {
+		mode fs.FileMode
+		want string
+	}{
+		{0644, "-rw-r--r--"},
+		{fs.ModeDir | 0755, "drwxr-xr-x"},
+		{fs.ModeSymlink | 0777, "lrwxrwxrwx"},
+		{fs.ModeDevice | fs.ModeCharDevice | 0666, "crw-rw-rw-"},
+		{fs.ModeSetuid | 0755, "-rwsr-xr-x"},
+		{fs.ModeSetuid | 0644, "-rwSr--r--"},
+		{fs.ModeDir | fs.ModeSticky | 0777, "drwxrwxrwt"},
+	}
+	for _, tt := range tests {
+		if got := modeString(tt.mode); got != tt.want {
+			t.Errorf("modeString(%v) = %q, want %q", tt.mode, got, tt.want)
+		}
+	}
+}
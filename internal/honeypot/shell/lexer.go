@@ -0,0 +1,168 @@
+package shell
+
+import (
+	"strings"
+)
+
+// Token kinds
+const (
+	tokWord = iota
+	tokOp
+	tokRedirect
+)
+
+type token struct {
+	kind int
+	word Word   // tokWord
+	op   string // tokOp and tokRedirect
+	fd   int    // tokRedirect
+}
+
+// lex splits a command line into words, control operators (|, ;, &, &&, ||)
+// and redirections, keeping the quoting of each word
+func lex(line string) ([]token, error) {
+	var (
+		toks []token
+		word Word
+		cur  strings.Builder
+		// inWord is set once a word has started, so "" is an empty word
+		inWord bool
+	)
+	flushPart := func(quote byte) {
+		if cur.Len() > 0 || quote != quoteNone {
+			word = append(word, wordPart{text: cur.String(), quote: quote})
+		}
+		cur.Reset()
+	}
+	endWord := func() {
+		flushPart(quoteNone)
+		if inWord {
+			toks = append(toks, token{kind: tokWord, word: word})
+		}
+		word = nil
+		inWord = false
+	}
+
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			endWord()
+
+		case c == '#' && !inWord:
+			// Comment to the end of the line
+			endWord()
+			return toks, nil
+
+		case c == '\\':
+			inWord = true
+			if i+1 < len(line) {
+				i++
+				flushPart(quoteNone)
+				cur.WriteByte(line[i])
+				flushPart(quoteSingle)
+			}
+
+		case c == '\'':
+			inWord = true
+			end := strings.IndexByte(line[i+1:], '\'')
+			if end < 0 {
+				return nil, &SyntaxError{"unexpected EOF while looking for matching `''"}
+			}
+			flushPart(quoteNone)
+			cur.WriteString(line[i+1 : i+1+end])
+			flushPart(quoteSingle)
+			i += end + 1
+
+		case c == '"':
+			inWord = true
+			flushPart(quoteNone)
+			j := i + 1
+			for ; j < len(line) && line[j] != '"'; j++ {
+				// Backslash only escapes these inside double quotes
+				if line[j] == '\\' && j+1 < len(line) && strings.IndexByte("$`\"\\", line[j+1]) >= 0 {
+					flushPart(quoteDouble)
+					cur.WriteByte(line[j+1])
+					flushPart(quoteSingle)
+					j++
+					continue
+				}
+				cur.WriteByte(line[j])
+			}
+			if j >= len(line) {
+				return nil, &SyntaxError{"unexpected EOF while looking for matching `\"'"}
+			}
+			flushPart(quoteDouble)
+			i = j
+
+		case c == '|' || c == ';' || c == '&':
+			// &> and &>> redirect both outputs
+			if c == '&' && i+1 < len(line) && line[i+1] == '>' {
+				endWord()
+				op, n := redirectOp(line[i+1:])
+				toks = append(toks, token{kind: tokRedirect, op: op, fd: -1})
+				i += n
+				continue
+			}
+			endWord()
+			op := string(c)
+			if (c == '|' || c == '&') && i+1 < len(line) && line[i+1] == c {
+				op += string(c)
+				i++
+			}
+			toks = append(toks, token{kind: tokOp, op: op})
+
+		case c == '>' || c == '<':
+			// A word of digits right before the operator is the descriptor
+			fd := 1
+			if c == '<' {
+				fd = 0
+			}
+			if inWord && len(word) == 0 && isDigits(cur.String()) {
+				fd = atoi(cur.String())
+				cur.Reset()
+				inWord = false
+			}
+			endWord()
+			op, n := redirectOp(line[i:])
+			toks = append(toks, token{kind: tokRedirect, op: op, fd: fd})
+			i += n - 1
+
+		default:
+			inWord = true
+			cur.WriteByte(c)
+		}
+	}
+	endWord()
+	return toks, nil
+}
+
+// redirectOp returns the redirection operator at the start of s and its length
+func redirectOp(s string) (string, int) {
+	for _, op := range []string{">>", ">&", ">", "<"} {
+		if strings.HasPrefix(s, op) {
+			return op, len(op)
+		}
+	}
+	return s[:1], 1
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func atoi(s string) int {
+	n := 0
+	for _, r := range s {
+		n = n*10 + int(r-'0')
+	}
+	return n
+}
@@ -0,0 +1,211 @@
+package shell
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// MaxLineLength is the longest line a LineReader returns. As in the Linux
+// terminal driver, characters typed past it are dropped.
+const MaxLineLength = 4095
+
+// LineReader is the line discipline of a terminal. With echo on it edits the
+// line as readline would: the cursor moves with the arrow keys, backspace
+// deletes before it and up and down walk the history. With echo off it
+// reads cooked lines, as clients without a terminal send them.
+type LineReader struct {
+	r *bufio.Reader
+	w io.Writer
+
+	// History recalled with the up and down arrows, oldest first
+	History []string
+
+	afterCR bool // The last line ended with CR, so a following LF is skipped
+}
+
+// NewLineReader reads lines from r, echoing to w
+func NewLineReader(r io.Reader, w io.Writer) *LineReader {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	return &LineReader{r: br, w: w}
+}
+
+// ReadLine reads a line without its terminator. Ctrl-C discards the line and
+// returns "", Ctrl-D on an empty line returns io.EOF.
+func (l *LineReader) ReadLine(echo bool) (string, error) {
+	if !echo {
+		line, err := l.readCooked()
+		if l.afterCR && line == "\n" {
+			line, err = l.readCooked()
+		}
+		l.afterCR = false
+		if err != nil && line == "" {
+			return "", err
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+
+	var line []byte
+	cursor := 0
+	recalled := len(l.History) // Index in History of the line shown; len when new
+	var draft []byte           // Line being typed before walking the history
+
+	// replace shows s in place of the current line
+	replace := func(s []byte) {
+		l.moveLeft(cursor)
+		io.WriteString(l.w, "\x1b[K")
+		l.w.Write(s)
+		line = append(line[:0:0], s...)
+		cursor = len(line)
+	}
+
+	for {
+		b, err := l.r.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		// A CR LF pair is one newline
+		if l.afterCR && (b == '\n' || b == 0) {
+			l.afterCR = false
+			continue
+		}
+		l.afterCR = false
+		switch b {
+		case '\r', '\n':
+			l.afterCR = b == '\r'
+			io.WriteString(l.w, "\r\n")
+			return string(line), nil
+
+		case 0x7f, 0x08: // Backspace
+			if cursor == 0 {
+				continue
+			}
+			line = append(line[:cursor-1], line[cursor:]...)
+			cursor--
+			io.WriteString(l.w, "\b")
+			l.redrawTail(line[cursor:])
+
+		case 0x03: // Ctrl-C
+			io.WriteString(l.w, "^C\r\n")
+			return "", nil
+
+		case 0x04: // Ctrl-D
+			if len(line) == 0 {
+				return "", io.EOF
+			}
+
+		case 0x15: // Ctrl-U deletes up to the cursor
+			rest := append([]byte(nil), line[cursor:]...)
+			l.moveLeft(cursor)
+			line, cursor = rest, 0
+			l.redrawTail(line)
+
+		case 0x01: // Ctrl-A
+			l.moveLeft(cursor)
+			cursor = 0
+
+		case 0x05: // Ctrl-E
+			l.w.Write(line[cursor:])
+			cursor = len(line)
+
+		case 0x1b:
+			switch l.escape() {
+			case 'A':
+				if recalled > 0 {
+					if recalled == len(l.History) {
+						draft = append([]byte(nil), line...)
+					}
+					recalled--
+					replace([]byte(l.History[recalled]))
+				}
+			case 'B':
+				if recalled < len(l.History) {
+					recalled++
+					if recalled == len(l.History) {
+						replace(draft)
+					} else {
+						replace([]byte(l.History[recalled]))
+					}
+				}
+			case 'C':
+				if cursor < len(line) {
+					l.w.Write(line[cursor : cursor+1])
+					cursor++
+				}
+			case 'D':
+				if cursor > 0 {
+					l.moveLeft(1)
+					cursor--
+				}
+			case 'H':
+				l.moveLeft(cursor)
+				cursor = 0
+			case 'F':
+				l.w.Write(line[cursor:])
+				cursor = len(line)
+			}
+
+		default:
+			if b < 0x20 || len(line) >= MaxLineLength {
+				continue
+			}
+			line = append(line, 0)
+			copy(line[cursor+1:], line[cursor:])
+			line[cursor] = b
+			l.w.Write([]byte{b})
+			cursor++
+			if cursor < len(line) {
+				l.redrawTail(line[cursor:])
+			}
+		}
+	}
+}
+
+// readCooked reads up to and including the next newline, keeping at most
+// MaxLineLength bytes of it
+func (l *LineReader) readCooked() (string, error) {
+	var line []byte
+	for {
+		chunk, err := l.r.ReadSlice('\n')
+		line = append(line, chunk[:min(len(chunk), MaxLineLength-len(line))]...)
+		if err != bufio.ErrBufferFull {
+			return string(line), err
+		}
+	}
+}
+
+// escape reads the rest of an escape sequence such as ESC [ A and returns its
+// final byte, or 0 for sequences that are not understood
+func (l *LineReader) escape() byte {
+	next, err := l.r.ReadByte()
+	if err != nil || next != '[' && next != 'O' {
+		return 0
+	}
+	for {
+		c, err := l.r.ReadByte()
+		if err != nil {
+			return 0
+		}
+		if c >= 0x40 && c <= 0x7e {
+			return c
+		}
+	}
+}
+
+// moveLeft moves the terminal cursor n columns left
+func (l *LineReader) moveLeft(n int) {
+	if n > 0 {
+		io.WriteString(l.w, strings.Repeat("\b", n))
+	}
+}
+
+// redrawTail rewrites the line from the cursor on after an edit, clearing
+// what is left of the old line, and puts the cursor back
+func (l *LineReader) redrawTail(tail []byte) {
+	l.w.Write(tail)
+	io.WriteString(l.w, "\x1b[K")
+	l.moveLeft(len(tail))
+}
@@ -0,0 +1,94 @@
+package shell
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLineReader(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		echo    bool
+		history []string
+		want    []string // Lines read until the input runs out
+	}{
+		{"cooked", "ls -la\nwhoami\r\n", false, nil, []string{"ls -la", "whoami"}},
+		{"cooked last line without newline", "id", false, nil, []string{"id"}},
+		{"cr", "ls\r", true, nil, []string{"ls"}},
+		{"crlf is one line", "ls\r\nid\r\n", true, nil, []string{"ls", "id"}},
+		{"cr nul is one line", "ls\r\x00id\r", true, nil, []string{"ls", "id"}},
+		{"empty lines", "\r\r", true, nil, []string{"", ""}},
+		{"backspace", "lss\x7f -l\r", true, nil, []string{"ls -l"}},
+		{"backspace at start", "\x7f\x08id\r", true, nil, []string{"id"}},
+		{"left arrow inserts", "l -a\x1b[D\x1b[D\x1b[Ds\r", true, nil, []string{"ls -a"}},
+		{"backspace after left arrow", "wxhoami\x1b[D\x1b[D\x1b[D\x1b[D\x1b[D\x7f\r", true, nil, []string{"whoami"}},
+		{"right arrow", "ab\x1b[D\x1b[Cc\r", true, nil, []string{"abc"}},
+		{"right arrow at end", "ab\x1b[Cc\r", true, nil, []string{"abc"}},
+		{"home and end", "bc\x1b[Ha\x1b[Fd\r", true, nil, []string{"abcd"}},
+		{"ctrl-a ctrl-e", "bc\x01a\x05d\r", true, nil, []string{"abcd"}},
+		{"ctrl-u", "rm -rf /\x15id\r", true, nil, []string{"id"}},
+		{"ctrl-c", "rm -rf\x03id\r", true, nil, []string{"", "id"}},
+		{"ctrl-d ends", "\x04id\r", true, nil, nil},
+		{"ctrl-d in line is ignored", "i\x04d\r", true, nil, []string{"id"}},
+		{"up recalls", "\x1b[A\r", true, []string{"ls", "uname -a"}, []string{"uname -a"}},
+		{"up twice", "\x1b[A\x1b[A\r", true, []string{"ls", "uname -a"}, []string{"ls"}},
+		{"up past oldest", "\x1b[A\x1b[A\x1b[A\r", true, []string{"ls", "uname -a"}, []string{"ls"}},
+		{"down restores draft", "wh\x1b[A\x1b[Boami\r", true, []string{"ls"}, []string{"whoami"}},
+		{"recalled line is editable", "\x1b[A\x7f\x7fid\r", true, []string{"ls -l"}, []string{"ls id"}},
+		{"application cursor keys", "\x1bOA\r", true, []string{"ps"}, []string{"ps"}},
+		{"other escapes ignored", "a\x1b[3~b\r", true, nil, []string{"ab"}},
+		{"control characters ignored", "a\x07b\r", true, nil, []string{"ab"}},
+		{"long line is cut", strings.Repeat("a", MaxLineLength+10) + "\rid\r", true, nil, []string{strings.Repeat("a", MaxLineLength), "id"}},
+		{"long cooked line is cut", strings.Repeat("a", 3*MaxLineLength) + "\nid\n", false, nil, []string{strings.Repeat("a", MaxLineLength), "id"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewLineReader(strings.NewReader(tt.input), io.Discard)
+			l.History = tt.history
+			var got []string
+			for {
+				line, err := l.ReadLine(tt.echo)
+				if err != nil {
+					break
+				}
+				got = append(got, line)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("lines = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLineReaderEcho(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		echo  bool
+		want  string
+	}{
+		{"typed", "id\r", true, "id\r\n"},
+		{"not echoed", "secret\n", false, ""},
+		{"backspace", "ab\x7f\r", true, "ab\b\x1b[K\r\n"},
+		{"insert redraws the tail", "ac\x1b[Db\r", true, "ac\bb" + "c\x1b[K\b" + "\r\n"},
+		{"ctrl-c", "ab\x03", true, "ab^C\r\n"},
+		{"history replaces the line", "x\x1b[A\r", true, "x\b\x1b[Kls\r\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			l := NewLineReader(strings.NewReader(tt.input), &out)
+			l.History = []string{"ls"}
+			l.ReadLine(tt.echo)
+			if out.String() != tt.want {
+				t.Errorf("echo = %q, want %q", out.String(), tt.want)
+			}
+		})
+	}
+}
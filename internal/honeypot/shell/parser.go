@@ -0,0 +1,176 @@
+package shell
+
+import (
+	"fmt"
+	"strings"
+)
+
+// quoting of a word part, which decides how it is expanded
+const (
+	quoteNone   = 0
+	quoteSingle = '\''
+	quoteDouble = '"'
+)
+
+// wordPart is a run of a word quoted the same way
+type wordPart struct {
+	text  string
+	quote byte
+}
+
+// Word is a shell word before expansion, e.g. "$HOME"/'x' is two parts
+type Word []wordPart
+
+// literal returns the word with quotes removed and nothing expanded
+func (w Word) literal() string {
+	var sb strings.Builder
+	for _, p := range w {
+		sb.WriteString(p.text)
+	}
+	return sb.String()
+}
+
+// Redirect is an I/O redirection such as 2>/dev/null or >>log
+type Redirect struct {
+	FD     int    // Redirected descriptor
+	Op     string // ">", ">>", "<" or ">&"
+	Target Word   // File, or descriptor number for ">&"
+}
+
+// Assign is a NAME=value prefix of a command
+type Assign struct {
+	Name  string
+	Value Word
+}
+
+// SimpleCommand is a command: assignments, words and redirections
+type SimpleCommand struct {
+	Assigns   []Assign
+	Words     []Word
+	Redirects []Redirect
+}
+
+// Pipeline is a sequence of commands connected by '|'
+type Pipeline struct {
+	Commands []*SimpleCommand
+	Op       string // How the next pipeline runs: ";", "&&", "||" or "&"
+}
+
+// List is a parsed command line
+type List []*Pipeline
+
+// SyntaxError is a command line bash would refuse to run
+type SyntaxError struct {
+	msg string
+}
+
+func (e *SyntaxError) Error() string {
+	return e.msg
+}
+
+func unexpected(tok string) error {
+	if tok == "" {
+		tok = "newline"
+	}
+	return &SyntaxError{fmt.Sprintf("syntax error near unexpected token `%s'", tok)}
+}
+
+// Parse parses a command line
+func Parse(line string) (List, error) {
+	toks, err := lex(line)
+	if err != nil {
+		return nil, err
+	}
+
+	var list List
+	p := &Pipeline{}
+	cmd := &SimpleCommand{}
+	// Whether a word was seen since the last operator, so 'a | | b' and
+	// '; ls' are rejected
+	empty := func() bool {
+		return len(cmd.Words) == 0 && len(cmd.Assigns) == 0 && len(cmd.Redirects) == 0
+	}
+
+	for i := 0; i < len(toks); i++ {
+		tok := toks[i]
+		switch tok.kind {
+		case tokWord:
+			if len(cmd.Words) == 0 {
+				if a, ok := assignment(tok.word); ok {
+					cmd.Assigns = append(cmd.Assigns, a)
+					continue
+				}
+			}
+			cmd.Words = append(cmd.Words, tok.word)
+
+		case tokRedirect:
+			if i+1 >= len(toks) || toks[i+1].kind != tokWord {
+				next := ""
+				if i+1 < len(toks) {
+					next = toks[i+1].op
+				}
+				return nil, unexpected(next)
+			}
+			i++
+			cmd.Redirects = append(cmd.Redirects, Redirect{FD: tok.fd, Op: tok.op, Target: toks[i].word})
+
+		case tokOp:
+			if empty() {
+				return nil, unexpected(tok.op)
+			}
+			p.Commands = append(p.Commands, cmd)
+			cmd = &SimpleCommand{}
+			if tok.op == "|" {
+				continue
+			}
+			p.Op = tok.op
+			list = append(list, p)
+			p = &Pipeline{}
+		}
+	}
+
+	if !empty() {
+		p.Commands = append(p.Commands, cmd)
+	} else if len(p.Commands) > 0 {
+		// A trailing '|', '&&' or '||' needs another command
+		return nil, unexpected("")
+	}
+	if len(p.Commands) > 0 {
+		p.Op = ";"
+		list = append(list, p)
+	}
+	if n := len(list); n > 0 && (list[n-1].Op == "&&" || list[n-1].Op == "||") {
+		return nil, unexpected("")
+	}
+	return list, nil
+}
+
+// assignment recognizes NAME=value, where NAME is unquoted
+func assignment(w Word) (Assign, bool) {
+	if len(w) == 0 || w[0].quote != quoteNone {
+		return Assign{}, false
+	}
+	name, value, ok := strings.Cut(w[0].text, "=")
+	if !ok || !isName(name) {
+		return Assign{}, false
+	}
+	rest := Word{}
+	if value != "" {
+		rest = append(rest, wordPart{text: value})
+	}
+	return Assign{Name: name, Value: append(rest, w[1:]...)}, true
+}
+
+// isName reports whether s is a valid variable name
+func isName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, r := range s {
+		if r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || i > 0 && r >= '0' && r <= '9' {
+			continue
+		}
+		return false
+	}
+	return true
+}
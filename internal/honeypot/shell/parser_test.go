@@ -0,0 +1,155 @@
+package shell
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// describe renders a parsed line compactly: words are bracketed, pipelines
+// joined by their operators
+func describe(list List) string {
+	var sb strings.Builder
+	for _, p := range list {
+		for i, c := range p.Commands {
+			if i > 0 {
+				sb.WriteString(" | ")
+			}
+			var parts []string
+			for _, a := range c.Assigns {
+				parts = append(parts, a.Name+"="+a.Value.literal())
+			}
+			for _, w := range c.Words {
+				parts = append(parts, "["+w.literal()+"]")
+			}
+			for _, r := range c.Redirects {
+				fd := strconv.Itoa(r.FD)
+				if r.FD == -1 {
+					fd = "&"
+				}
+				parts = append(parts, fd+r.Op+r.Target.literal())
+			}
+			sb.WriteString(strings.Join(parts, " "))
+		}
+		sb.WriteString(" " + p.Op + " ")
+	}
+	return strings.TrimSpace(sb.String())
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want string
+	}{
+		{"words", "ls -la /tmp", "[ls] [-la] [/tmp] ;"},
+		{"extra spaces", "  uname   -a ", "[uname] [-a] ;"},
+		{"single quotes", "echo 'a  b' c", "[echo] [a  b] [c] ;"},
+		{"double quotes", `echo "x y"z`, "[echo] [x yz] ;"},
+		{"escaped space", `cat my\ file`, "[cat] [my file] ;"},
+		{"escaped quote", `echo \"hi\"`, `[echo] ["hi"] ;`},
+		{"operators inside quotes", `echo "a;b|c&&d"`, "[echo] [a;b|c&&d] ;"},
+		{"pipeline", "cat /etc/passwd | grep root | wc -l", "[cat] [/etc/passwd] | [grep] [root] | [wc] [-l] ;"},
+		{"sequence", "cd /tmp; ls", "[cd] [/tmp] ; [ls] ;"},
+		{"and or", "true && echo y || echo n", "[true] && [echo] [y] || [echo] [n] ;"},
+		{"background", "sleep 1 & echo x", "[sleep] [1] & [echo] [x] ;"},
+		{"no spaces around operators", "a;b&&c|d", "[a] ; [b] && [c] | [d] ;"},
+		{"redirect stdout", "echo x >/tmp/a", "[echo] [x] 1>/tmp/a ;"},
+		{"append", "echo x >> /tmp/a", "[echo] [x] 1>>/tmp/a ;"},
+		{"redirect stderr", "ls 2>/dev/null", "[ls] 2>/dev/null ;"},
+		{"dup", "ls 2>&1", "[ls] 2>&1 ;"},
+		{"both", "ls &>/dev/null", "[ls] &>/dev/null ;"},
+		{"input", "sh < x.sh", "[sh] 0<x.sh ;"},
+		{"digits in a word", "echo a2>x", "[echo] [a2] 1>x ;"},
+		{"assignment", "FOO=bar", "FOO=bar ;"},
+		{"assignment before command", "LANG=C ls", "LANG=C [ls] ;"},
+		{"assignment after command is a word", "echo A=b", "[echo] [A=b] ;"},
+		{"quoted assignment value", `A="x y"`, "A=x y ;"},
+		{"comment", "ls # list", "[ls] ;"},
+		{"hash inside word", "echo a#b", "[echo] [a#b] ;"},
+		{"trailing semicolon", "ls;", "[ls] ;"},
+		{"empty", "   ", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			list, err := Parse(tt.line)
+			if err != nil {
+				t.Fatalf("Parse(%q) error: %v", tt.line, err)
+			}
+			if got := describe(list); got != tt.want {
+				t.Errorf("Parse(%q) = %s, want %s", tt.line, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		line string
+		want string
+	}{
+		{"; ls", "syntax error near unexpected token `;'"},
+		{"ls | | wc", "syntax error near unexpected token `|'"},
+		{"ls &&", "syntax error near unexpected token `newline'"},
+		{"ls |", "syntax error near unexpected token `newline'"},
+		{"ls >", "syntax error near unexpected token `newline'"},
+		{"echo > ;", "syntax error near unexpected token `;'"},
+		{"echo 'open", "unexpected EOF while looking for matching `''"},
+		{`echo "open`, "unexpected EOF while looking for matching `\"'"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			_, err := Parse(tt.line)
+			if err == nil {
+				t.Fatalf("Parse(%q) succeeded, want error", tt.line)
+			}
+			if err.Error() != tt.want {
+				t.Errorf("Parse(%q) error = %q, want %q", tt.line, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpandWord(t *testing.T) {
+	s := NewSession(nil, NewRegistry())
+	s.Env["X"] = "a b"
+	s.status = 3
+
+	tests := []struct {
+		line string
+		want []string
+	}{
+		{"echo $X", []string{"echo", "a b"}},
+		{"echo ${X}y", []string{"echo", "a by"}},
+		{`echo "$X"`, []string{"echo", "a b"}},
+		{"echo '$X'", []string{"echo", "$X"}},
+		{`echo \$X`, []string{"echo", "$X"}},
+		{"echo $?", []string{"echo", "3"}},
+		{"echo $UNSET.", []string{"echo", "."}},
+		{"echo $", []string{"echo", "$"}},
+		{"cd ~", []string{"cd", "/root"}},
+		{"cd ~/x", []string{"cd", "/root/x"}},
+		{"echo '~'", []string{"echo", "~"}},
+		{"echo a~", []string{"echo", "a~"}},
+		{"echo $HOME/x", []string{"echo", "/root/x"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			list, err := Parse(tt.line)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, w := range list[0].Commands[0].Words {
+				got = append(got, s.expandWord(w))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expand %q = %q, want %q", tt.line, got, tt.want)
+			}
+		})
+	}
+}
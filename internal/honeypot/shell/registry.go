@@ -0,0 +1,94 @@
+package shell
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"time"
+)
+
+// Command emulates a program. It returns the exit status.
+type Command func(ctx *Context) int
+
+// Context is what a command runs with
+type Context struct {
+	Session *Session
+	Name    string   // Name the command was invoked as
+	Args    []string // Arguments after the name
+	Stdin   io.Reader
+	Stdout  io.Writer
+	Stderr  io.Writer
+}
+
+// Printf writes to the command's standard output
+func (c *Context) Printf(format string, args ...interface{}) {
+	fmt.Fprintf(c.Stdout, format, args...)
+}
+
+// Errorf writes to the command's standard error
+func (c *Context) Errorf(format string, args ...interface{}) {
+	fmt.Fprintf(c.Stderr, format, args...)
+}
+
+// Sleep pauses for d, as a real program doing work would
+func (c *Context) Sleep(d time.Duration) {
+	c.Session.Sleep(d)
+}
+
+// Registry maps command names to their emulators
+type Registry struct {
+	commands map[string]Command
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{commands: make(map[string]Command)}
+}
+
+// DefaultRegistry returns a registry with the shell builtins and the
+// emulators of common commands
+func DefaultRegistry() *Registry {
+	r := NewRegistry()
+	registerBuiltins(r)
+	registerCommands(r)
+	registerFileCommands(r)
+	registerDownloadCommands(r)
+	return r
+}
+
+// Register adds cmd under each of names, replacing earlier registrations
+func (r *Registry) Register(cmd Command, names ...string) {
+	for _, name := range names {
+		r.commands[name] = cmd
+	}
+}
+
+// Lookup returns the emulator for name. Paths such as /bin/busybox resolve
+// to their base name.
+func (r *Registry) Lookup(name string) (Command, bool) {
+	cmd, ok := r.commands[name]
+	if !ok {
+		cmd, ok = r.commands[path.Base(name)]
+	}
+	return cmd, ok
+}
+
+// Names returns the registered command names in order
+func (r *Registry) Names() []string {
+	names := make([]string, 0, len(r.commands))
+	for name := range r.commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Clone returns a copy of the registry that can be extended independently
+func (r *Registry) Clone() *Registry {
+	c := NewRegistry()
+	for name, cmd := range r.commands {
+		c.commands[name] = cmd
+	}
+	return c
+}
@@ -0,0 +1,454 @@
+// Package shell emulates an interactive bash session for the honeypot
+// personas: a parser for command lines, a registry of command emulators,
+// per-session state and a terminal line discipline.
+package shell
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math/rand"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"phantom-grid/internal/honeypot/vfs"
+)
+
+// Session holds the state of one shell session
+type Session struct {
+	User     string
+	Hostname string
+	Cwd      string
+	Env      map[string]string
+	History  []string
+	UID      int
+	GID      int
+	PID      int  // Reported as $$
+	TTY      bool // Output goes to a terminal, so newlines are sent as CRLF
+	FS       *vfs.FS
+	Commands *Registry
+	Sleep    func(time.Duration) // Delays that make commands look real; tests replace it
+	Fetch    Fetcher             // Downloads for wget, curl, tftp and ftpget; nil to only pretend
+
+	status int // Exit status of the last command, $?
+	exited bool
+	depth  int // Nesting of scripts and sh -c
+}
+
+// NewSession starts a root login shell on fsys
+func NewSession(fsys *vfs.FS, commands *Registry) *Session {
+	return &Session{
+		User:     "root",
+		Hostname: "server",
+		Cwd:      "/root",
+		Env: map[string]string{
+			"HOME":    "/root",
+			"USER":    "root",
+			"LOGNAME": "root",
+			"SHELL":   "/bin/bash",
+			"PATH":    "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin",
+			"LANG":    "en_US.UTF-8",
+			"TERM":    "xterm",
+		},
+		PID:      20000 + rand.Intn(10000),
+		FS:       fsys,
+		Commands: commands,
+		Sleep:    time.Sleep,
+	}
+}
+
+// Getenv returns the value of a variable, or "" if it is not set
+func (s *Session) Getenv(name string) string {
+	switch name {
+	case "PWD":
+		return s.Cwd
+	case "HOSTNAME":
+		return s.Hostname
+	}
+	return s.Env[name]
+}
+
+// Setenv sets a variable
+func (s *Session) Setenv(name, value string) {
+	if name == "PWD" {
+		s.Cwd = value
+		return
+	}
+	s.Env[name] = value
+}
+
+// Status returns the exit status of the last command
+func (s *Session) Status() int {
+	return s.status
+}
+
+// Exited reports whether the session ended with exit or logout
+func (s *Session) Exited() bool {
+	return s.exited
+}
+
+// Prompt returns the bash prompt for the current user and directory
+func (s *Session) Prompt() string {
+	dir := s.Cwd
+	if home := s.Getenv("HOME"); home != "" && (dir == home || strings.HasPrefix(dir, home+"/")) {
+		dir = "~" + dir[len(home):]
+	}
+	sign := "$"
+	if s.User == "root" {
+		sign = "#"
+	}
+	return fmt.Sprintf("%s@%s:%s%s ", s.User, s.Hostname, dir, sign)
+}
+
+// Resolve returns the absolute path of p relative to the working directory
+func (s *Session) Resolve(p string) string {
+	if p == "~" || strings.HasPrefix(p, "~/") {
+		p = s.Getenv("HOME") + p[1:]
+	}
+	if !strings.HasPrefix(p, "/") {
+		p = s.Cwd + "/" + p
+	}
+	return path.Clean(p)
+}
+
+// IsDir reports whether p is a directory
+func (s *Session) IsDir(p string) bool {
+	fi, err := s.FS.Stat(s.Resolve(p))
+	return err == nil && fi.IsDir()
+}
+
+// Exists reports whether p exists
+func (s *Session) Exists(p string) bool {
+	_, err := s.FS.Lstat(s.Resolve(p))
+	return err == nil
+}
+
+// Access reports whether the user may read, write or execute (want is 4, 2
+// or 1) a file. root may do anything but execute files without an execute
+// bit.
+func (s *Session) Access(fi vfs.FileInfo, want fs.FileMode) bool {
+	perm := fi.Mode.Perm()
+	if s.UID == 0 {
+		return want != 1 || fi.IsDir() || perm&0111 != 0
+	}
+	switch {
+	case s.UID == fi.UID:
+		perm >>= 6
+	case s.GID == fi.GID:
+		perm >>= 3
+	}
+	return perm&want == want
+}
+
+// MaxHistory is the number of lines the history keeps, HISTSIZE in the
+// .bashrc of Debian and Ubuntu
+const MaxHistory = 1000
+
+// Run runs a command line, writing its output to w, and returns its exit status
+func (s *Session) Run(w io.Writer, line string) int {
+	if s.TTY {
+		w = crlfWriter{w}
+	}
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return s.status
+	}
+	s.History = append(s.History, line)
+	if len(s.History) > MaxHistory {
+		s.History = s.History[len(s.History)-MaxHistory:]
+	}
+	return s.runLine(w, w, line)
+}
+
+// maxDepth limits scripts running scripts, so a script that runs itself ends
+const maxDepth = 16
+
+// run runs a script of one or more lines without recording it, as for sh -c
+func (s *Session) run(stdout, stderr io.Writer, script string) int {
+	if s.depth >= maxDepth {
+		fmt.Fprintf(stderr, "-bash: fork: retry: Resource temporarily unavailable\n")
+		return 254
+	}
+	s.depth++
+	defer func() { s.depth-- }()
+	for _, line := range strings.Split(script, "\n") {
+		if s.exited {
+			break
+		}
+		if line = strings.TrimSpace(line); line != "" {
+			s.runLine(stdout, stderr, line)
+		}
+	}
+	return s.status
+}
+
+// runLine runs one command line
+func (s *Session) runLine(stdout, stderr io.Writer, line string) int {
+	list, err := Parse(line)
+	if err != nil {
+		fmt.Fprintf(stderr, "-bash: %v\n", err)
+		s.status = 2
+		return s.status
+	}
+
+	s.Sleep(time.Duration(50+rand.Intn(100)) * time.Millisecond)
+	for i, p := range list {
+		if s.exited {
+			break
+		}
+		if i > 0 {
+			switch list[i-1].Op {
+			case "&&":
+				if s.status != 0 {
+					continue
+				}
+			case "||":
+				if s.status == 0 {
+					continue
+				}
+			}
+		}
+		if p.Op == "&" {
+			// Jobs finish at once, but the job number is printed as usual
+			fmt.Fprintf(stderr, "[1] %d\n", s.PID+1+i)
+		}
+		s.status = s.runPipeline(stdout, stderr, p)
+	}
+	return s.status
+}
+
+// runPipeline runs the commands of p, feeding each one's output to the next
+func (s *Session) runPipeline(stdout, stderr io.Writer, p *Pipeline) int {
+	var stdin io.Reader = strings.NewReader("")
+	status := 0
+	for i, cmd := range p.Commands {
+		out := stdout
+		var buf *bytes.Buffer
+		if i < len(p.Commands)-1 {
+			buf = &bytes.Buffer{}
+			out = buf
+		}
+		status = s.runCommand(cmd, stdin, out, stderr)
+		if buf != nil {
+			stdin = buf
+		}
+	}
+	return status
+}
+
+// runCommand expands and runs a simple command
+func (s *Session) runCommand(c *SimpleCommand, stdin io.Reader, stdout, stderr io.Writer) int {
+	args := make([]string, len(c.Words))
+	for i, w := range c.Words {
+		args[i] = s.expandWord(w)
+	}
+
+	for _, r := range c.Redirects {
+		target := s.expandWord(r.Target)
+		switch r.Op {
+		case "<":
+			data, err := s.FS.ReadFile(s.Resolve(target))
+			if err != nil {
+				fmt.Fprintf(stderr, "-bash: %s: %s\n", target, ErrText(err))
+				return 1
+			}
+			stdin = bytes.NewReader(data)
+		case ">", ">>":
+			w, err := s.create(target, r.Op == ">>")
+			if err != nil {
+				fmt.Fprintf(stderr, "-bash: %s: %s\n", target, ErrText(err))
+				return 1
+			}
+			switch r.FD {
+			case 1:
+				stdout = w
+			case 2:
+				stderr = w
+			case -1:
+				stdout, stderr = w, w
+			}
+		case ">&":
+			switch {
+			case r.FD == 2 && target == "1":
+				stderr = stdout
+			case r.FD == 1 && target == "2":
+				stdout = stderr
+			}
+		}
+	}
+
+	if len(args) == 0 {
+		for _, a := range c.Assigns {
+			s.Setenv(a.Name, s.expandWord(a.Value))
+		}
+		return 0
+	}
+
+	// NAME=value before a command only applies to it
+	saved := make(map[string]*string)
+	for _, a := range c.Assigns {
+		if _, done := saved[a.Name]; !done {
+			if old, ok := s.Env[a.Name]; ok {
+				saved[a.Name] = &old
+			} else {
+				saved[a.Name] = nil
+			}
+		}
+		s.Setenv(a.Name, s.expandWord(a.Value))
+	}
+	defer func() {
+		for name, old := range saved {
+			if old == nil {
+				delete(s.Env, name)
+			} else {
+				s.Env[name] = *old
+			}
+		}
+	}()
+
+	return s.Exec(&Context{Session: s, Name: args[0], Args: args[1:], Stdin: stdin, Stdout: stdout, Stderr: stderr})
+}
+
+// create opens the file p for a redirection, truncating it unless appending
+func (s *Session) create(p string, appending bool) (io.Writer, error) {
+	abs := s.Resolve(p)
+	if abs == "/dev/null" {
+		return io.Discard, nil
+	}
+	if err := s.checkWrite(abs); err != nil {
+		return nil, err
+	}
+	if !appending {
+		if err := s.FS.WriteFile(abs, nil, 0644); err != nil {
+			return nil, err
+		}
+	}
+	return &fileWriter{fs: s.FS, path: abs}, nil
+}
+
+// checkWrite fails with EACCES if the user may not write the file abs, or
+// create it in its directory
+func (s *Session) checkWrite(abs string) error {
+	fi, err := s.FS.Stat(abs)
+	if err != nil {
+		fi, err = s.FS.Stat(path.Dir(abs))
+	}
+	if err == nil && !s.Access(fi, 2) {
+		return pathErr("open", abs, syscall.EACCES)
+	}
+	return nil
+}
+
+// fileWriter appends what a command writes to a file
+type fileWriter struct {
+	fs   *vfs.FS
+	path string
+}
+
+func (w *fileWriter) Write(p []byte) (int, error) {
+	if err := w.fs.AppendFile(w.path, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// binDirs hold the programs the registry emulates
+var binDirs = map[string]bool{
+	"/bin": true, "/sbin": true, "/usr/bin": true, "/usr/sbin": true,
+	"/usr/local/bin": true, "/usr/local/sbin": true,
+}
+
+// Exec runs the command ctx.Name. Emulators such as sudo and busybox use it
+// to run the command they wrap.
+func (s *Session) Exec(ctx *Context) int {
+	if strings.Contains(ctx.Name, "/") {
+		// Files the attacker put outside the system directories are theirs
+		// to run, even if named like a command
+		abs := s.Resolve(ctx.Name)
+		if s.Exists(abs) && !binDirs[path.Dir(abs)] {
+			return s.execFile(ctx, abs)
+		}
+	}
+	cmd, ok := s.Commands.Lookup(ctx.Name)
+	if !ok {
+		if strings.Contains(ctx.Name, "/") {
+			return s.execFile(ctx, s.Resolve(ctx.Name))
+		}
+		ctx.Errorf("-bash: %s: command not found\n", ctx.Name)
+		return 127
+	}
+	return cmd(ctx)
+}
+
+// execFile runs the file abs. Scripts run in the session; binaries are
+// reported as built for another architecture.
+func (s *Session) execFile(ctx *Context, abs string) int {
+	fi, err := s.FS.Stat(abs)
+	switch {
+	case err != nil:
+		ctx.Errorf("-bash: %s: %s\n", ctx.Name, ErrText(err))
+		return 127
+	case fi.IsDir():
+		ctx.Errorf("-bash: %s: Is a directory\n", ctx.Name)
+		return 126
+	case !s.Access(fi, 1) || !s.Access(fi, 4):
+		ctx.Errorf("-bash: %s: Permission denied\n", ctx.Name)
+		return 126
+	}
+	data, _ := s.FS.ReadFile(abs)
+	if !isText(data) {
+		ctx.Errorf("-bash: %s: cannot execute binary file: Exec format error\n", ctx.Name)
+		return 126
+	}
+	return s.run(ctx.Stdout, ctx.Stderr, string(data))
+}
+
+// isText reports whether data looks like a script rather than a binary
+func isText(data []byte) bool {
+	return utf8.Valid(data) && bytes.IndexByte(data, 0) < 0
+}
+
+func pathErr(op, p string, errno syscall.Errno) error {
+	return &fs.PathError{Op: op, Path: p, Err: errno}
+}
+
+// ErrText returns the message coreutils prints for a file system error,
+// e.g. "No such file or directory"
+func ErrText(err error) string {
+	msg := err.Error()
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		msg = errno.Error()
+	}
+	if msg == "" {
+		return msg
+	}
+	return strings.ToUpper(msg[:1]) + msg[1:]
+}
+
+// crlfWriter turns LF into CRLF, as a terminal in cooked mode does
+type crlfWriter struct {
+	w io.Writer
+}
+
+func (c crlfWriter) Write(p []byte) (int, error) {
+	if bytes.IndexByte(p, '\n') < 0 {
+		return c.w.Write(p)
+	}
+	out := make([]byte, 0, len(p)+8)
+	for i, b := range p {
+		if b == '\n' && (i == 0 || p[i-1] != '\r') {
+			out = append(out, '\r')
+		}
+		out = append(out, b)
+	}
+	if _, err := c.w.Write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
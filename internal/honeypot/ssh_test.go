@@ -0,0 +1,313 @@
+package honeypot
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"phantom-grid/internal/honeypot/recording"
+)
+
+// chdirTemp runs the test in a temporary directory, so audit logs written by
+// the handlers do not end up in the source tree
+func chdirTemp(t *testing.T) {
+	t.Helper()
+	oldWD, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Failed to get working directory: %v", err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatalf("Failed to chdir: %v", err)
+	}
+	t.Cleanup(func() { os.Chdir(oldWD) })
+}
+
+func TestLoadHostKeysPersist(t *testing.T) {
+	dir := t.TempDir()
+	first, err := loadHostKeys(dir)
+	if err != nil {
+		t.Fatalf("loadHostKeys() error = %v", err)
+	}
+	if len(first) != len(sshHostKeyTypes) {
+		t.Fatalf("loadHostKeys() returned %d keys, want %d", len(first), len(sshHostKeyTypes))
+	}
+	for _, kt := range sshHostKeyTypes {
+		info, err := os.Stat(dir + "/" + kt.file)
+		if err != nil {
+			t.Fatalf("host key %s not stored: %v", kt.file, err)
+		}
+		if info.Mode().Perm() != 0o600 {
+			t.Errorf("host key %s mode = %v, want 0600", kt.file, info.Mode().Perm())
+		}
+	}
+
+	second, err := loadHostKeys(dir)
+	if err != nil {
+		t.Fatalf("loadHostKeys() reload error = %v", err)
+	}
+	for i := range first {
+		a := ssh.FingerprintSHA256(first[i].PublicKey())
+		b := ssh.FingerprintSHA256(second[i].PublicKey())
+		if a != b {
+			t.Errorf("key %d changed across loads: %s != %s", i, a, b)
+		}
+	}
+}
+
+func TestParseKexInit(t *testing.T) {
+	msg := struct {
+		Cookie                  [16]byte
+		KexAlgos                []string
+		ServerHostKeyAlgos      []string
+		CiphersClientServer     []string
+		CiphersServerClient     []string
+		MACsClientServer        []string
+		MACsServerClient        []string
+		CompressionClientServer []string
+		CompressionServerClient []string
+		LanguagesClientServer   []string
+		LanguagesServerClient   []string
+		FirstKexFollows         bool
+		Reserved                uint32
+	}{
+		KexAlgos:                []string{"curve25519-sha256", "diffie-hellman-group14-sha256"},
+		ServerHostKeyAlgos:      []string{"ssh-ed25519"},
+		CiphersClientServer:     []string{"aes128-ctr"},
+		CiphersServerClient:     []string{"aes256-ctr"},
+		MACsClientServer:        []string{"hmac-sha2-256"},
+		MACsServerClient:        []string{"hmac-sha2-512"},
+		CompressionClientServer: []string{"none"},
+		CompressionServerClient: []string{"zlib"},
+	}
+	payload := append([]byte{msgKexInit}, ssh.Marshal(msg)...)
+
+	kex, err := parseKexInit(payload)
+	if err != nil {
+		t.Fatalf("parseKexInit() error = %v", err)
+	}
+	if strings.Join(kex.kex, ",") != "curve25519-sha256,diffie-hellman-group14-sha256" ||
+		strings.Join(kex.ciphers, ",") != "aes128-ctr" ||
+		strings.Join(kex.macs, ",") != "hmac-sha2-256" ||
+		strings.Join(kex.compression, ",") != "none" {
+		t.Errorf("parseKexInit() = %+v, want the client to server lists", kex)
+	}
+	// MD5 of "curve25519-sha256,diffie-hellman-group14-sha256;aes128-ctr;hmac-sha2-256;none"
+	if got, want := kex.hassh(), "fccfa11c7d95c9b773dd0ba461c6a7ef"; got != want {
+		t.Errorf("hassh() = %q, want %q", got, want)
+	}
+
+	if _, err := parseKexInit(payload[:40]); err == nil {
+		t.Error("parseKexInit() accepted a truncated message")
+	}
+	if _, err := parseKexInit([]byte{21, 0}); err == nil {
+		t.Error("parseKexInit() accepted another message type")
+	}
+}
+
+// dialSSHPersona logs in to h's SSH persona as admin. done is closed when
+// the persona returns.
+func dialSSHPersona(t *testing.T, h *Handler) (ssh.Conn, *ssh.Client, <-chan struct{}) {
+	t.Helper()
+	// Both sides send their version first, so net.Pipe would deadlock
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	server, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("Failed to accept: %v", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		h.handleSSH(server, "198.51.100.7:40000", "12:00:00")
+		server.Close()
+		close(done)
+	}()
+
+	conn, chans, reqs, err := ssh.NewClientConn(client, ln.Addr().String(), &ssh.ClientConfig{
+		User:            "admin",
+		Auth:            []ssh.AuthMethod{ssh.Password("hunter2")},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		ClientVersion:   "SSH-2.0-libssh_0.9.6",
+		Timeout:         5 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("SSH handshake failed: %v", err)
+	}
+	return conn, ssh.NewClient(conn, chans, reqs), done
+}
+
+func TestSSHPersona(t *testing.T) {
+	chdirTemp(t)
+	keys, err := loadHostKeys("")
+	if err != nil {
+		t.Fatalf("loadHostKeys() error = %v", err)
+	}
+
+	logChan := make(chan string, 100)
+	h := NewHandler(logChan)
+	h.sshHostKeys = keys
+	h.sshVersion = "SSH-2.0-OpenSSH_7.4"
+	h.recording.Dir = t.TempDir()
+
+	conn, sshClient, done := dialSSHPersona(t, h)
+	if got := string(conn.ServerVersion()); got != "SSH-2.0-OpenSSH_7.4" {
+		t.Errorf("server version = %q, want the persona banner", got)
+	}
+	session, err := sshClient.NewSession()
+	if err != nil {
+		t.Fatalf("NewSession() error = %v", err)
+	}
+	out, err := session.Output("whoami")
+	if err != nil {
+		t.Fatalf("exec whoami error = %v", err)
+	}
+	if strings.TrimSpace(string(out)) != "root" {
+		t.Errorf("whoami = %q, want root", out)
+	}
+
+	session, err = sshClient.NewSession()
+	if err != nil {
+		t.Fatalf("NewSession() error = %v", err)
+	}
+	if err := session.RequestSubsystem("sftp"); err == nil {
+		t.Error("sftp subsystem was accepted")
+	}
+	session.Close()
+
+	sshClient.Close()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("handleSSH() did not return after the client closed")
+	}
+
+	var logs []string
+	for len(logChan) > 0 {
+		logs = append(logs, <-logChan)
+	}
+	all := strings.Join(logs, "\n")
+	for _, want := range []string{
+		`SSH CLIENT: 198.51.100.7 | Version: SSH-2.0-libssh_0.9.6 | HASSH: `,
+		`SSH LOGIN: 198.51.100.7 | User: admin | Password: "hunter2" | Method: password`,
+		`SSH COMMAND: whoami`,
+		`SSH SUBSYSTEM: 198.51.100.7 requested sftp (refused)`,
+	} {
+		if !strings.Contains(all, want) {
+			t.Errorf("logs missing %q:\n%s", want, all)
+		}
+	}
+	for _, line := range logs {
+		if strings.Contains(line, "SSH CLIENT") && strings.HasSuffix(line, "HASSH: ") {
+			t.Errorf("client logged without a HASSH: %s", line)
+		}
+	}
+
+	// The exec session was recorded; the refused subsystem was not
+	infos, err := recording.List(h.recording.Dir)
+	if err != nil || len(infos) != 1 {
+		t.Fatalf("recordings = %+v, %v, want one", infos, err)
+	}
+	hdr := infos[0].Header
+	if hdr.Command != "whoami" || hdr.Session.User != "admin" || hdr.Session.Client != "SSH-2.0-libssh_0.9.6" || hdr.Session.IP != "198.51.100.7" {
+		t.Errorf("header = %+v", hdr)
+	}
+	if !strings.Contains(all, "SSH RECORDING: 198.51.100.7 | Session: "+hdr.Session.ID) {
+		t.Errorf("recording not logged:\n%s", all)
+	}
+	if matches, _ := recording.Search(infos[0].Path, "root"); len(matches) != 1 || matches[0].Type != recording.Output {
+		t.Errorf("Search(root) = %+v, want the output of whoami", matches)
+	}
+}
+
+func TestSSHShellWindowChange(t *testing.T) {
+	chdirTemp(t)
+	keys, err := loadHostKeys("")
+	if err != nil {
+		t.Fatalf("loadHostKeys() error = %v", err)
+	}
+	h := NewHandler(make(chan string, 1000))
+	h.sshHostKeys = keys
+	h.sshVersion = "SSH-2.0-OpenSSH_7.4"
+	h.recording.Dir = t.TempDir()
+	_, sshClient, done := dialSSHPersona(t, h)
+
+	session, err := sshClient.NewSession()
+	if err != nil {
+		t.Fatalf("NewSession() error = %v", err)
+	}
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		t.Fatalf("StdinPipe() error = %v", err)
+	}
+	var out bytes.Buffer
+	session.Stdout = &out
+	if err := session.RequestPty("xterm", 24, 80, ssh.TerminalModes{}); err != nil {
+		t.Fatalf("RequestPty() error = %v", err)
+	}
+	if err := session.Shell(); err != nil {
+		t.Fatalf("Shell() error = %v", err)
+	}
+	// More resizes than the connection buffers requests for an unread channel
+	for i := 0; i < 40; i++ {
+		if err := session.WindowChange(30+i, 100); err != nil {
+			t.Fatalf("WindowChange() error = %v", err)
+		}
+	}
+	stdin.Write([]byte("echo resized\nexit\n"))
+
+	waited := make(chan error, 1)
+	go func() { waited <- session.Wait() }()
+	select {
+	case err := <-waited:
+		if err != nil {
+			t.Errorf("Wait() error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the shell stalled after the terminal was resized")
+	}
+	if !strings.Contains(out.String(), "resized") {
+		t.Errorf("output = %q, want the echo", out.String())
+	}
+	sshClient.Close()
+	<-done
+
+	infos, err := recording.List(h.recording.Dir)
+	if err != nil || len(infos) != 1 {
+		t.Fatalf("recordings = %+v, %v, want one", infos, err)
+	}
+	f, err := os.Open(infos[0].Path)
+	if err != nil {
+		t.Fatalf("Failed to open the recording: %v", err)
+	}
+	defer f.Close()
+	r, err := recording.NewReader(f)
+	if err != nil {
+		t.Fatalf("NewReader() error = %v", err)
+	}
+	resizes := 0
+	for {
+		e, err := r.Next()
+		if err != nil {
+			break
+		}
+		if e.Type == recording.Resize {
+			resizes++
+		}
+	}
+	if resizes != 40 {
+		t.Errorf("recorded %d resizes, want 40", resizes)
+	}
+}
@@ -0,0 +1,119 @@
+package honeypot
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"phantom-grid/internal/config"
+	"phantom-grid/internal/logger"
+)
+
+// tarpitAlphabet is what the trickle is drawn from: printable bytes that look
+// like the start of a banner line but never complete one
+const tarpitAlphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_.=/ "
+
+// tarpit holds connections on honeypot mode tarpit ports open, sending one
+// byte per interval, within per-source and global limits
+type tarpit struct {
+	cfg    config.TarpitConfiguration
+	mu     sync.Mutex
+	active int
+	perIP  map[string]int
+}
+
+func newTarpit(cfg config.TarpitConfiguration) *tarpit {
+	return &tarpit{
+		cfg:   cfg,
+		perIP: make(map[string]int),
+	}
+}
+
+// acquire reserves a slot for ip, or returns false if a limit is reached
+func (t *tarpit) acquire(ip string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.cfg.MaxConnections > 0 && t.active >= t.cfg.MaxConnections {
+		return false
+	}
+	if t.cfg.MaxPerSource > 0 && t.perIP[ip] >= t.cfg.MaxPerSource {
+		return false
+	}
+	t.active++
+	t.perIP[ip]++
+	return true
+}
+
+func (t *tarpit) release(ip string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.active--
+	if t.perIP[ip]--; t.perIP[ip] <= 0 {
+		delete(t.perIP, ip)
+	}
+}
+
+// count returns the number of connections currently held
+func (t *tarpit) count() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.active
+}
+
+// hold trickles bytes to conn until the peer goes away or the flow timeout
+// passes. Input is never read, so the peer's send buffer fills up as well.
+func (t *tarpit) hold(conn net.Conn, interval, timeout time.Duration) time.Duration {
+	start := time.Now()
+	deadline := start.Add(timeout)
+	b := make([]byte, 1)
+	for time.Now().Before(deadline) {
+		b[0] = tarpitAlphabet[rand.Intn(len(tarpitAlphabet))]
+		conn.SetWriteDeadline(time.Now().Add(interval))
+		if _, err := conn.Write(b); err != nil {
+			break
+		}
+		time.Sleep(interval)
+	}
+	return time.Since(start)
+}
+
+// SetTarpit enables the honeypot side of the tarpit: connections for ports in
+// honeypot mode ranges are held open instead of getting a service persona
+func (h *Honeypot) SetTarpit(cfg config.TarpitConfiguration) {
+	h.tarpit = newTarpit(cfg)
+}
+
+// TarpitConnections returns the number of connections the honeypot is stalling
+func (h *Honeypot) TarpitConnections() int {
+	if h.tarpit == nil {
+		return 0
+	}
+	return h.tarpit.count()
+}
+
+// isTarpitPort reports whether connections for port are stalled by the honeypot
+func (h *Honeypot) isTarpitPort(port int) bool {
+	if h.tarpit == nil {
+		return false
+	}
+	mode, ok := h.tarpit.cfg.ModeForPort(port)
+	return ok && mode == config.TarpitModeHoneypot
+}
+
+// handleTarpit stalls a connection. Connections beyond the limits are closed
+// straight away so a single source cannot exhaust goroutines or descriptors.
+func (h *Honeypot) handleTarpit(conn net.Conn, ip string, port int, t string) {
+	if !h.tarpit.acquire(ip) {
+		return
+	}
+	defer h.tarpit.release(ip)
+
+	h.logChan <- fmt.Sprintf("[%s] TARPIT! IP: %s | Port: %d | Holding connection", t, ip, port)
+	logger.LogAttack(ip, fmt.Sprintf("TRAP_HIT_PORT_%d", port))
+
+	cfg := h.tarpit.cfg
+	held := h.tarpit.hold(conn, time.Duration(cfg.TrickleIntervalSeconds)*time.Second, time.Duration(cfg.FlowTimeoutSeconds)*time.Second)
+	h.logChan <- fmt.Sprintf("[%s] TARPIT released IP: %s | Port: %d | Held: %s", time.Now().Format("15:04:05"), ip, port, held.Round(time.Second))
+}
@@ -0,0 +1,89 @@
+package honeypot
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"phantom-grid/internal/config"
+)
+
+func TestTarpitLimits(t *testing.T) {
+	cfg := config.DefaultTarpitConfig()
+	cfg.MaxPerSource = 2
+	cfg.MaxConnections = 3
+	tp := newTarpit(cfg)
+
+	if !tp.acquire("198.51.100.1") || !tp.acquire("198.51.100.1") {
+		t.Fatal("connections within the source limit denied")
+	}
+	if tp.acquire("198.51.100.1") {
+		t.Error("connection beyond the per-source limit allowed")
+	}
+	if !tp.acquire("198.51.100.2") {
+		t.Fatal("other source denied")
+	}
+	if tp.acquire("198.51.100.3") {
+		t.Error("connection beyond the global limit allowed")
+	}
+	if tp.count() != 3 {
+		t.Errorf("count() = %d, want 3", tp.count())
+	}
+
+	tp.release("198.51.100.1")
+	if !tp.acquire("198.51.100.1") {
+		t.Error("released slot not reusable")
+	}
+}
+
+func TestTarpitHoldTrickles(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+
+	tp := newTarpit(config.DefaultTarpitConfig())
+	done := make(chan time.Duration)
+	go func() {
+		done <- tp.hold(server, 10*time.Millisecond, time.Second)
+	}()
+
+	buf := make([]byte, 8)
+	for i := 0; i < 3; i++ {
+		n, err := client.Read(buf)
+		if err != nil || n != 1 {
+			t.Fatalf("read %d: n = %d, err = %v; want one byte", i, n, err)
+		}
+		if !strings.ContainsRune(tarpitAlphabet, rune(buf[0])) {
+			t.Errorf("unexpected byte %q", buf[0])
+		}
+	}
+
+	client.Close()
+	select {
+	case held := <-done:
+		if held >= time.Second {
+			t.Errorf("hold() ran until the timeout after the peer closed")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("hold() did not return after the peer closed")
+	}
+}
+
+func TestIsTarpitPort(t *testing.T) {
+	h := New(make(chan string, 10))
+	if h.isTarpitPort(5900) {
+		t.Error("port tarpitted without configuration")
+	}
+
+	cfg := config.DefaultTarpitConfig()
+	cfg.Enabled = true
+	cfg.Ranges = []config.TarpitRange{{From: 5900, To: 5910, Mode: config.TarpitModeHoneypot}, {From: 6000, To: 6000, Mode: config.TarpitModeXDP}}
+	h.SetTarpit(cfg)
+
+	if !h.isTarpitPort(5905) {
+		t.Error("honeypot mode port not tarpitted")
+	}
+	if h.isTarpitPort(6000) {
+		t.Error("xdp mode port handled by the honeypot")
+	}
+}
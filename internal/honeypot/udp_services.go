@@ -0,0 +1,453 @@
+package honeypot
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// udpResponderForPort returns the emulator for a fake UDP port, or nil if the
+// port has none
+func udpResponderForPort(port int) udpResponder {
+	switch port {
+	case 53:
+		return respondDNS
+	case 123:
+		return respondNTP
+	case 161:
+		return respondSNMP
+	case 1900:
+		return respondSSDP
+	case 11211:
+		return respondMemcached
+	default:
+		return nil
+	}
+}
+
+// printable trims s to max bytes and replaces control characters so attacker
+// supplied strings cannot forge log lines
+func printable(s string, max int) string {
+	if len(s) > max {
+		s = s[:max]
+	}
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return '.'
+		}
+		return r
+	}, s)
+}
+
+// --- DNS ---
+
+const (
+	dnsHeaderLen   = 12
+	dnsTypeTXT     = 16
+	dnsClassChaos  = 3
+	dnsRcodeRefuse = 5
+)
+
+// dnsVersion is returned for version.bind CH TXT, a common fingerprinting query
+const dnsVersion = "9.11.4-P2-RedHat-9.11.4-26.P2.el7"
+
+// respondDNS behaves like an authoritative-only server: recursive queries are
+// refused, and version.bind reveals an old BIND release
+func respondDNS(req []byte, _ *net.UDPAddr) ([]byte, string) {
+	if len(req) < dnsHeaderLen || req[2]&0x80 != 0 {
+		return nil, "Malformed query"
+	}
+	if binary.BigEndian.Uint16(req[4:6]) == 0 {
+		return nil, "Empty query"
+	}
+
+	name, end, ok := dnsReadName(req, dnsHeaderLen)
+	if !ok || end+4 > len(req) {
+		return nil, "Malformed query"
+	}
+	qtype := binary.BigEndian.Uint16(req[end : end+2])
+	qclass := binary.BigEndian.Uint16(req[end+2 : end+4])
+	question := req[dnsHeaderLen : end+4]
+	detail := fmt.Sprintf("Query: %s type %d", printable(name, 128), qtype)
+
+	resp := make([]byte, dnsHeaderLen, dnsHeaderLen+len(question)+64)
+	copy(resp[0:2], req[0:2])    // ID
+	resp[2] = 0x80 | req[2]&0x79 // QR, opcode, RD
+	binary.BigEndian.PutUint16(resp[4:6], 1)
+	resp = append(resp, question...)
+
+	if strings.EqualFold(name, "version.bind") && qtype == dnsTypeTXT && qclass == dnsClassChaos {
+		resp[2] |= 0x04 // AA
+		binary.BigEndian.PutUint16(resp[6:8], 1)
+		resp = append(resp, 0xc0, dnsHeaderLen) // Pointer to the question name
+		resp = binary.BigEndian.AppendUint16(resp, dnsTypeTXT)
+		resp = binary.BigEndian.AppendUint16(resp, dnsClassChaos)
+		resp = binary.BigEndian.AppendUint32(resp, 0)
+		resp = binary.BigEndian.AppendUint16(resp, uint16(len(dnsVersion)+1))
+		resp = append(resp, byte(len(dnsVersion)))
+		resp = append(resp, dnsVersion...)
+		return resp, detail
+	}
+
+	resp[3] = dnsRcodeRefuse
+	return resp, detail
+}
+
+// dnsReadName decodes an uncompressed question name starting at off and
+// returns it with the offset just past it
+func dnsReadName(msg []byte, off int) (string, int, bool) {
+	var labels []string
+	for {
+		if off >= len(msg) {
+			return "", 0, false
+		}
+		n := int(msg[off])
+		off++
+		if n == 0 {
+			break
+		}
+		if n > 63 || off+n > len(msg) {
+			return "", 0, false
+		}
+		labels = append(labels, string(msg[off:off+n]))
+		off += n
+	}
+	return strings.Join(labels, "."), off, true
+}
+
+// --- NTP ---
+
+const (
+	ntpPacketLen   = 48
+	ntpModeClient  = 3
+	ntpModeServer  = 4
+	ntpModeControl = 6
+	ntpModePrivate = 7
+	ntpMonlist     = 42
+
+	// Seconds from the NTP epoch (1900) to the Unix epoch
+	ntpEpochOffset = 2208988800
+)
+
+// ntpRefID is the upstream server a stratum 2 server reports (time.cloudflare.com)
+var ntpRefID = []byte{162, 159, 200, 1}
+
+// respondNTP answers client requests as a stratum 2 server. Control (mode 6)
+// and private (mode 7, e.g. monlist) queries are the classic amplification
+// vectors and are only logged.
+func respondNTP(req []byte, _ *net.UDPAddr) ([]byte, string) {
+	if len(req) < 1 {
+		return nil, "Malformed packet"
+	}
+	version := req[0] >> 3 & 0x07
+	mode := req[0] & 0x07
+
+	switch mode {
+	case ntpModeClient:
+	case ntpModeControl:
+		return nil, fmt.Sprintf("Version: %d, Mode: control", version)
+	case ntpModePrivate:
+		if len(req) > 3 && req[3] == ntpMonlist {
+			return nil, fmt.Sprintf("Version: %d, Mode: private (monlist)", version)
+		}
+		return nil, fmt.Sprintf("Version: %d, Mode: private", version)
+	default:
+		return nil, fmt.Sprintf("Version: %d, Mode: %d", version, mode)
+	}
+	detail := fmt.Sprintf("Version: %d, Mode: client", version)
+	if len(req) < ntpPacketLen {
+		return nil, detail
+	}
+
+	now := time.Now()
+	resp := make([]byte, ntpPacketLen)
+	resp[0] = version<<3 | ntpModeServer
+	resp[1] = 2      // Stratum
+	resp[2] = req[2] // Poll interval
+	resp[3] = 0xe9   // Precision, 2^-23 s
+	resp[6] = 0x01   // Root delay, 1/256 s
+	resp[10] = 0x02  // Root dispersion, 2/256 s
+	copy(resp[12:16], ntpRefID)
+	ntpPutTime(resp[16:24], now.Add(-17*time.Minute))
+	copy(resp[24:32], req[40:48]) // Origin: the client's transmit timestamp
+	ntpPutTime(resp[32:40], now)
+	ntpPutTime(resp[40:48], now)
+	return resp, detail
+}
+
+// ntpPutTime writes t as a 64-bit NTP timestamp
+func ntpPutTime(b []byte, t time.Time) {
+	binary.BigEndian.PutUint32(b[0:4], uint32(t.Unix()+ntpEpochOffset))
+	binary.BigEndian.PutUint32(b[4:8], uint32(uint64(t.Nanosecond())<<32/1e9))
+}
+
+// --- SNMP ---
+
+const (
+	berInteger     = 0x02
+	berOctetString = 0x04
+	berOID         = 0x06
+	berSequence    = 0x30
+
+	snmpGetRequest     = 0xa0
+	snmpGetNextRequest = 0xa1
+	snmpGetResponse    = 0xa2
+
+	snmpNoSuchObject = 0x80
+	snmpEndOfMibView = 0x82
+)
+
+// snmpSysDescrOID is the encoded OID 1.3.6.1.2.1.1.1.0
+var snmpSysDescrOID = []byte{0x2b, 0x06, 0x01, 0x02, 0x01, 0x01, 0x01, 0x00}
+
+const snmpSysDescr = "Linux srv01 4.15.0-112-generic #113-Ubuntu SMP x86_64"
+
+// respondSNMP answers get and get-next requests that use a default community
+// string with sysDescr.0. Other communities are logged and ignored, as a real
+// agent would.
+func respondSNMP(req []byte, _ *net.UDPAddr) ([]byte, string) {
+	tag, msg, _, ok := berRead(req)
+	if !ok || tag != berSequence {
+		return nil, "Malformed message"
+	}
+
+	tag, version, rest, ok := berRead(msg)
+	if !ok || tag != berInteger {
+		return nil, "Malformed message"
+	}
+	tag, community, rest, ok := berRead(rest)
+	if !ok || tag != berOctetString {
+		return nil, "Malformed message"
+	}
+	detail := fmt.Sprintf("Community: %s", printable(string(community), 64))
+
+	pduType, pdu, _, ok := berRead(rest)
+	if !ok || (pduType != snmpGetRequest && pduType != snmpGetNextRequest) {
+		return nil, detail
+	}
+
+	tag, requestID, rest, ok := berRead(pdu)
+	if !ok || tag != berInteger {
+		return nil, detail
+	}
+	// Skip error-status and error-index
+	for i := 0; i < 2; i++ {
+		if _, _, rest, ok = berRead(rest); !ok {
+			return nil, detail
+		}
+	}
+	tag, bindings, _, ok := berRead(rest)
+	if !ok || tag != berSequence {
+		return nil, detail
+	}
+	tag, binding, _, ok := berRead(bindings)
+	if !ok || tag != berSequence {
+		return nil, detail
+	}
+	tag, oid, _, ok := berRead(binding)
+	if !ok || tag != berOID {
+		return nil, detail
+	}
+	detail += " | OID: " + oidString(oid)
+
+	if c := string(community); c != "public" && c != "private" {
+		return nil, detail
+	}
+
+	respOID, value := oid, berTLV(snmpNoSuchObject, nil)
+	switch {
+	case pduType == snmpGetRequest && bytes.Equal(oid, snmpSysDescrOID):
+		value = berTLV(berOctetString, []byte(snmpSysDescr))
+	case pduType == snmpGetNextRequest && bytes.Compare(oid, snmpSysDescrOID) < 0:
+		respOID, value = snmpSysDescrOID, berTLV(berOctetString, []byte(snmpSysDescr))
+	case pduType == snmpGetNextRequest:
+		value = berTLV(snmpEndOfMibView, nil)
+	}
+
+	varbind := berTLV(berSequence, append(berTLV(berOID, respOID), value...))
+	var body []byte
+	body = append(body, berTLV(berInteger, requestID)...)
+	body = append(body, berTLV(berInteger, []byte{0})...)
+	body = append(body, berTLV(berInteger, []byte{0})...)
+	body = append(body, berTLV(berSequence, varbind)...)
+
+	var resp []byte
+	resp = append(resp, berTLV(berInteger, version)...)
+	resp = append(resp, berTLV(berOctetString, community)...)
+	resp = append(resp, berTLV(snmpGetResponse, body)...)
+	return berTLV(berSequence, resp), detail
+}
+
+// berRead splits the first TLV off b
+func berRead(b []byte) (tag byte, value, rest []byte, ok bool) {
+	if len(b) < 2 {
+		return 0, nil, nil, false
+	}
+	tag = b[0]
+	length, off := int(b[1]), 2
+	if length&0x80 != 0 {
+		n := length & 0x7f
+		if n == 0 || n > 2 || len(b) < 2+n {
+			return 0, nil, nil, false
+		}
+		length = 0
+		for _, c := range b[2 : 2+n] {
+			length = length<<8 | int(c)
+		}
+		off += n
+	}
+	if off+length > len(b) {
+		return 0, nil, nil, false
+	}
+	return tag, b[off : off+length], b[off+length:], true
+}
+
+// berTLV encodes a single TLV with a definite length
+func berTLV(tag byte, value []byte) []byte {
+	out := []byte{tag}
+	switch n := len(value); {
+	case n < 0x80:
+		out = append(out, byte(n))
+	case n <= 0xff:
+		out = append(out, 0x81, byte(n))
+	default:
+		out = append(out, 0x82, byte(n>>8), byte(n))
+	}
+	return append(out, value...)
+}
+
+// oidString formats an encoded OID in dotted notation
+func oidString(oid []byte) string {
+	if len(oid) == 0 {
+		return ""
+	}
+	arcs := []string{fmt.Sprint(oid[0] / 40), fmt.Sprint(oid[0] % 40)}
+	var v uint64
+	for _, c := range oid[1:] {
+		v = v<<7 | uint64(c&0x7f)
+		if c&0x80 == 0 {
+			arcs = append(arcs, fmt.Sprint(v))
+			v = 0
+		}
+	}
+	return strings.Join(arcs, ".")
+}
+
+// --- SSDP ---
+
+const ssdpServer = "Linux/3.14 UPnP/1.0 MiniUPnPd/2.1"
+
+// respondSSDP answers M-SEARCH discovery requests as a consumer router would
+func respondSSDP(req []byte, remote *net.UDPAddr) ([]byte, string) {
+	lines := strings.Split(string(req), "\r\n")
+	if !strings.HasPrefix(lines[0], "M-SEARCH ") {
+		return nil, "Request: " + printable(lines[0], 64)
+	}
+
+	st, man := "", ""
+	for _, line := range lines[1:] {
+		key, value, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+		switch strings.ToUpper(strings.TrimSpace(key)) {
+		case "ST":
+			st = strings.TrimSpace(value)
+		case "MAN":
+			man = strings.TrimSpace(value)
+		}
+	}
+	detail := "M-SEARCH ST: " + printable(st, 128)
+	if !strings.Contains(man, "ssdp:discover") || st == "" {
+		return nil, detail
+	}
+	if st == "ssdp:all" {
+		st = "upnp:rootdevice"
+	}
+
+	const uuid = "uuid:4d696e69-444c-164e-9d41-b0c5ca7e5a91"
+	usn := uuid
+	if st != uuid {
+		usn = uuid + "::" + st
+	}
+
+	resp := "HTTP/1.1 200 OK\r\n" +
+		"CACHE-CONTROL: max-age=120\r\n" +
+		"ST: " + st + "\r\n" +
+		"USN: " + usn + "\r\n" +
+		"EXT:\r\n" +
+		"SERVER: " + ssdpServer + "\r\n" +
+		"LOCATION: http://" + net.JoinHostPort(localIPFor(remote), "5000") + "/rootDesc.xml\r\n" +
+		"\r\n"
+	return []byte(resp), detail
+}
+
+// localIPFor returns the local address used to reach remote
+func localIPFor(remote *net.UDPAddr) string {
+	conn, err := net.DialUDP("udp", nil, remote)
+	if err != nil {
+		return "192.168.1.1"
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP.String()
+}
+
+// --- Memcached ---
+
+const memcachedFrameLen = 8
+
+// respondMemcached speaks the memcached UDP protocol. The stats reply is the
+// classic amplification payload and is always larger than the amplification
+// cap allows, so it is built but never sent.
+func respondMemcached(req []byte, _ *net.UDPAddr) ([]byte, string) {
+	if len(req) <= memcachedFrameLen {
+		return nil, "Malformed frame"
+	}
+
+	line, _, _ := strings.Cut(string(req[memcachedFrameLen:]), "\r\n")
+	detail := "Command: " + printable(line, 128)
+
+	var body string
+	fields := strings.Fields(line)
+	switch {
+	case len(fields) == 0:
+		body = "ERROR\r\n"
+	case fields[0] == "version":
+		body = "VERSION 1.6.9\r\n"
+	case fields[0] == "get" || fields[0] == "gets":
+		body = "END\r\n"
+	case fields[0] == "stats":
+		body = memcachedStats()
+	default:
+		body = "ERROR\r\n"
+	}
+
+	// Frame header: request ID, sequence number 0, 1 datagram, reserved
+	resp := make([]byte, memcachedFrameLen, memcachedFrameLen+len(body))
+	copy(resp[0:2], req[0:2])
+	binary.BigEndian.PutUint16(resp[4:6], 1)
+	return append(resp, body...), detail
+}
+
+func memcachedStats() string {
+	stats := [][2]string{
+		{"pid", "1123"}, {"uptime", "8640213"}, {"time", "1700000000"},
+		{"version", "1.6.9"}, {"libevent", "2.1.12-stable"}, {"pointer_size", "64"},
+		{"rusage_user", "1520.113204"}, {"rusage_system", "3017.441052"},
+		{"max_connections", "1024"}, {"curr_connections", "12"},
+		{"total_connections", "581223"}, {"cmd_get", "93120455"},
+		{"cmd_set", "4120381"}, {"get_hits", "88172630"}, {"get_misses", "4947825"},
+		{"bytes_read", "9921308841"}, {"bytes_written", "183312990172"},
+		{"limit_maxbytes", "67108864"}, {"threads", "4"}, {"curr_items", "183002"},
+		{"total_items", "4120381"}, {"evictions", "0"},
+	}
+	var b strings.Builder
+	for _, s := range stats {
+		fmt.Fprintf(&b, "STAT %s %s\r\n", s[0], s[1])
+	}
+	b.WriteString("END\r\n")
+	return b.String()
+}
@@ -0,0 +1,225 @@
+package honeypot
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// dnsQuery builds a single-question DNS query
+func dnsQuery(id uint16, name string, qtype, qclass uint16) []byte {
+	q := make([]byte, 12)
+	binary.BigEndian.PutUint16(q[0:2], id)
+	q[2] = 0x01 // RD
+	binary.BigEndian.PutUint16(q[4:6], 1)
+	for _, label := range strings.Split(name, ".") {
+		q = append(q, byte(len(label)))
+		q = append(q, label...)
+	}
+	q = append(q, 0)
+	q = binary.BigEndian.AppendUint16(q, qtype)
+	return binary.BigEndian.AppendUint16(q, qclass)
+}
+
+// snmpGet builds an SNMPv2c get request for sysDescr.0
+func snmpGet(community string) []byte {
+	varbind := berTLV(berSequence, append(berTLV(berOID, snmpSysDescrOID), 0x05, 0x00))
+	var pdu []byte
+	pdu = append(pdu, berTLV(berInteger, []byte{0x12, 0x34})...)
+	pdu = append(pdu, berTLV(berInteger, []byte{0})...)
+	pdu = append(pdu, berTLV(berInteger, []byte{0})...)
+	pdu = append(pdu, berTLV(berSequence, varbind)...)
+
+	var msg []byte
+	msg = append(msg, berTLV(berInteger, []byte{1})...)
+	msg = append(msg, berTLV(berOctetString, []byte(community))...)
+	msg = append(msg, berTLV(snmpGetRequest, pdu)...)
+	return berTLV(berSequence, msg)
+}
+
+// ntpRequest builds a 48 byte NTP packet with the given version and mode
+func ntpRequest(version, mode byte) []byte {
+	req := make([]byte, ntpPacketLen)
+	req[0] = version<<3 | mode
+	return req
+}
+
+// ssdpSearch is a typical discovery request, as sent by nmap and UPnP clients
+const ssdpSearch = "M-SEARCH * HTTP/1.1\r\nHOST: 239.255.255.250:1900\r\nMAN: \"ssdp:discover\"\r\nMX: 1\r\nST: ssdp:all\r\n\r\n"
+
+func TestRespondDNS(t *testing.T) {
+	resp, detail := respondDNS(dnsQuery(0xbeef, "example.com", 1, 1), nil)
+	if resp == nil {
+		t.Fatal("respondDNS() returned no reply")
+	}
+	if binary.BigEndian.Uint16(resp[0:2]) != 0xbeef || resp[2]&0x80 == 0 {
+		t.Errorf("reply header = %x, want response with ID beef", resp[:4])
+	}
+	if resp[3]&0x0f != dnsRcodeRefuse {
+		t.Errorf("rcode = %d, want REFUSED", resp[3]&0x0f)
+	}
+	if !strings.Contains(detail, "example.com") {
+		t.Errorf("detail = %q", detail)
+	}
+
+	resp, _ = respondDNS(dnsQuery(1, "version.bind", dnsTypeTXT, dnsClassChaos), nil)
+	if resp == nil || !bytes.Contains(resp, []byte(dnsVersion)) || binary.BigEndian.Uint16(resp[6:8]) != 1 {
+		t.Errorf("version.bind reply = %q", resp)
+	}
+
+	if resp, _ := respondDNS([]byte{1, 2, 3}, nil); resp != nil {
+		t.Error("respondDNS() answered a truncated query")
+	}
+}
+
+func TestRespondNTP(t *testing.T) {
+	req := ntpRequest(4, ntpModeClient)
+	req[2] = 6
+	copy(req[40:48], []byte{0xe9, 0x1c, 0x5a, 0x10, 0x80, 0, 0, 0})
+
+	resp, detail := respondNTP(req, nil)
+	if len(resp) != ntpPacketLen {
+		t.Fatalf("reply length = %d, want %d", len(resp), ntpPacketLen)
+	}
+	if resp[0] != 4<<3|ntpModeServer || resp[1] != 2 || resp[2] != 6 {
+		t.Errorf("header = %x, want version 4 server, stratum 2, poll 6", resp[:4])
+	}
+	if !bytes.Equal(resp[24:32], req[40:48]) {
+		t.Errorf("origin timestamp = %x, want the request's transmit timestamp %x", resp[24:32], req[40:48])
+	}
+	sec := int64(binary.BigEndian.Uint32(resp[40:44])) - ntpEpochOffset
+	if d := time.Since(time.Unix(sec, 0)); d < -time.Second || d > 2*time.Second {
+		t.Errorf("transmit timestamp is %v off", d)
+	}
+	if detail != "Version: 4, Mode: client" {
+		t.Errorf("detail = %q", detail)
+	}
+
+	// Control and private queries, monlist among them, are never answered
+	monlist := ntpRequest(2, ntpModePrivate)
+	monlist[3] = ntpMonlist
+	for _, req := range [][]byte{ntpRequest(2, ntpModeControl), monlist, ntpRequest(4, ntpModeServer), {0x23}} {
+		if resp, _ := respondNTP(req, nil); resp != nil {
+			t.Errorf("respondNTP(%x) answered", req[:1])
+		}
+	}
+	if _, detail := respondNTP(monlist, nil); !strings.Contains(detail, "monlist") {
+		t.Errorf("monlist detail = %q", detail)
+	}
+}
+
+func TestRespondSNMP(t *testing.T) {
+	resp, detail := respondSNMP(snmpGet("public"), nil)
+	if resp == nil {
+		t.Fatal("respondSNMP() returned no reply")
+	}
+	if !strings.Contains(detail, "Community: public") || !strings.Contains(detail, "1.3.6.1.2.1.1.1.0") {
+		t.Errorf("detail = %q", detail)
+	}
+	if !bytes.Contains(resp, []byte{snmpGetResponse}) || !bytes.Contains(resp, []byte(snmpSysDescr)) {
+		t.Errorf("reply = %x, want GetResponse with sysDescr", resp)
+	}
+	if !bytes.Contains(resp, []byte{berInteger, 2, 0x12, 0x34}) {
+		t.Error("reply does not echo the request ID")
+	}
+
+	if resp, detail := respondSNMP(snmpGet("s3cret"), nil); resp != nil || !strings.Contains(detail, "s3cret") {
+		t.Errorf("unknown community: reply = %x, detail = %q", resp, detail)
+	}
+}
+
+func TestRespondSSDP(t *testing.T) {
+	remote := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 40000}
+
+	resp, _ := respondSSDP([]byte(ssdpSearch), remote)
+	if !strings.HasPrefix(string(resp), "HTTP/1.1 200 OK\r\n") || !strings.Contains(string(resp), "ST: upnp:rootdevice") {
+		t.Errorf("reply = %q", resp)
+	}
+	if !strings.Contains(string(resp), "LOCATION: http://127.0.0.1:5000/") {
+		t.Errorf("reply LOCATION does not use the local address: %q", resp)
+	}
+
+	if resp, _ := respondSSDP([]byte("NOTIFY * HTTP/1.1\r\n\r\n"), remote); resp != nil {
+		t.Error("respondSSDP() answered a NOTIFY")
+	}
+}
+
+func TestRespondMemcached(t *testing.T) {
+	frame := func(cmd string) []byte {
+		return append([]byte{0xab, 0xcd, 0, 0, 0, 1, 0, 0}, cmd...)
+	}
+
+	tests := []struct {
+		cmd  string
+		want string
+	}{
+		{"version\r\n", "VERSION 1.6.9\r\n"},
+		{"get key\r\n", "END\r\n"},
+		{"flush_all\r\n", "ERROR\r\n"},
+	}
+	for _, tt := range tests {
+		resp, _ := respondMemcached(frame(tt.cmd), nil)
+		if len(resp) < memcachedFrameLen || !bytes.Equal(resp[0:2], []byte{0xab, 0xcd}) {
+			t.Errorf("%q: bad frame header %x", tt.cmd, resp)
+			continue
+		}
+		if got := string(resp[memcachedFrameLen:]); got != tt.want {
+			t.Errorf("%q: reply = %q, want %q", tt.cmd, got, tt.want)
+		}
+	}
+
+	// The stats reply must never pass the amplification cap
+	req := frame("stats\r\n")
+	resp, _ := respondMemcached(req, nil)
+	if len(resp) <= udpMaxAmplification*len(req) {
+		t.Errorf("stats reply of %d bytes would be sent for a %d byte probe", len(resp), len(req))
+	}
+}
+
+func TestUDPRepliesWithinAmplificationCap(t *testing.T) {
+	remote := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 40000}
+	probes := map[string]struct {
+		respond udpResponder
+		req     []byte
+	}{
+		"dns":         {respondDNS, dnsQuery(1, "a.b", 1, 1)},
+		"dns version": {respondDNS, dnsQuery(1, "version.bind", dnsTypeTXT, dnsClassChaos)},
+		"ntp":         {respondNTP, ntpRequest(4, ntpModeClient)},
+		"snmp":        {respondSNMP, snmpGet("public")},
+		"ssdp":        {respondSSDP, []byte(ssdpSearch)},
+		"memcached":   {respondMemcached, append(make([]byte, memcachedFrameLen), "version\r\n"...)},
+	}
+	for name, p := range probes {
+		resp, _ := p.respond(p.req, remote)
+		if resp == nil {
+			t.Errorf("%s: no reply", name)
+			continue
+		}
+		if len(resp) > udpMaxAmplification*len(p.req) {
+			t.Errorf("%s: %d byte reply to a %d byte probe exceeds the cap", name, len(resp), len(p.req))
+		}
+	}
+}
+
+func TestUDPLimiter(t *testing.T) {
+	l := newUDPLimiter()
+	now := time.Now()
+
+	for i := 0; i < udpResponsesPerMinute; i++ {
+		if !l.allow("198.51.100.1", now) {
+			t.Fatalf("reply %d denied", i+1)
+		}
+	}
+	if l.allow("198.51.100.1", now) {
+		t.Error("reply beyond the per-source limit allowed")
+	}
+	if !l.allow("198.51.100.2", now) {
+		t.Error("other source denied")
+	}
+	if !l.allow("198.51.100.1", now.Add(time.Minute)) {
+		t.Error("limit not reset after the window")
+	}
+}
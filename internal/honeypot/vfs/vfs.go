@@ -0,0 +1,618 @@
+// Package vfs is the in-memory file system of the honeypot shell. A base
+// image is built once and shared; each session works on a copy-on-write
+// overlay of it, so what one attacker changes no other session sees. Files
+// written on an overlay are kept as artifacts, even if they are deleted
+// again.
+//
+// Errors are *fs.PathError values wrapping a syscall.Errno, whose message is
+// what coreutils prints.
+package vfs
+
+import (
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// maxSymlinks is how many symlinks a lookup follows before failing with
+// ELOOP, as on Linux
+const maxSymlinks = 40
+
+// layers numbers the layers inodes belong to
+var layers uint64
+
+func newLayer() uint64 {
+	return atomic.AddUint64(&layers, 1)
+}
+
+// inode is a file, directory or symlink. Inodes of another layer are shared
+// and never modified: they are copied into the layer first.
+type inode struct {
+	ino     uint64
+	mode    fs.FileMode
+	uid     int
+	gid     int
+	mtime   time.Time
+	data    []byte            // Contents of a file; replaced, never written in place
+	target  string            // Target of a symlink
+	entries map[string]*inode // Entries of a directory
+	layer   uint64
+}
+
+// copyTo returns a copy of n that belongs to layer
+func (n *inode) copyTo(layer uint64) *inode {
+	c := *n
+	c.layer = layer
+	if n.entries != nil {
+		c.entries = make(map[string]*inode, len(n.entries))
+		for name, child := range n.entries {
+			c.entries[name] = child
+		}
+	}
+	return &c
+}
+
+// FileInfo describes a file
+type FileInfo struct {
+	Name    string
+	Ino     uint64
+	Mode    fs.FileMode
+	UID     int
+	GID     int
+	Nlink   int
+	Size    int64
+	ModTime time.Time
+	Target  string // Target of a symlink
+}
+
+// IsDir reports whether the file is a directory
+func (fi FileInfo) IsDir() bool {
+	return fi.Mode.IsDir()
+}
+
+func (n *inode) info(name string) FileInfo {
+	fi := FileInfo{
+		Name:    name,
+		Ino:     n.ino,
+		Mode:    n.mode,
+		UID:     n.uid,
+		GID:     n.gid,
+		Nlink:   1,
+		Size:    int64(len(n.data)),
+		ModTime: n.mtime,
+		Target:  n.target,
+	}
+	switch {
+	case n.mode.IsDir():
+		fi.Size = 4096
+		fi.Nlink = 2
+		for _, child := range n.entries {
+			if child.mode.IsDir() {
+				fi.Nlink++
+			}
+		}
+	case n.mode&fs.ModeSymlink != 0:
+		fi.Size = int64(len(n.target))
+	}
+	return fi
+}
+
+// Artifact is a file written on the file system
+type Artifact struct {
+	Path    string // Where the file was last written or moved to
+	Data    []byte
+	Mode    fs.FileMode
+	Removed bool // The file was deleted afterwards
+}
+
+type artifact struct {
+	path    string
+	node    *inode
+	removed bool
+}
+
+// FS is an in-memory file system. Paths are absolute; relative paths are
+// taken from the root. It is safe for concurrent use.
+type FS struct {
+	// Now stamps the modification time of changed files
+	Now func() time.Time
+	// UID and GID own the files created
+	UID, GID int
+	// Quota is how many bytes may be written in all; 0 is unlimited. Writes
+	// beyond it fail with ENOSPC.
+	Quota int64
+
+	mu        sync.Mutex
+	root      *inode
+	layer     uint64
+	inos      *uint64 // Inode counter shared with overlays
+	written   int64
+	artifacts map[uint64]*artifact
+	order     []uint64 // Inodes of artifacts in the order first written
+}
+
+// New creates a file system with an empty root directory
+func New() *FS {
+	f := &FS{
+		Now:   time.Now,
+		layer: newLayer(),
+		inos:  new(uint64),
+	}
+	f.root = f.newInode(fs.ModeDir | 0755)
+	return f
+}
+
+// Overlay returns a copy-on-write view of the file system. Later changes to
+// either are not seen by the other. Files are copied when they change, so
+// an overlay costs nothing until it is written to.
+func (f *FS) Overlay() *FS {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	// f's inodes are now shared, so f copies them too before changing them
+	f.layer = newLayer()
+	return &FS{
+		Now:   f.Now,
+		UID:   f.UID,
+		GID:   f.GID,
+		Quota: f.Quota,
+		root:  f.root,
+		layer: newLayer(),
+		inos:  f.inos,
+	}
+}
+
+func (f *FS) newInode(mode fs.FileMode) *inode {
+	n := &inode{
+		ino:   atomic.AddUint64(f.inos, 1),
+		mode:  mode,
+		uid:   f.UID,
+		gid:   f.GID,
+		mtime: f.Now(),
+		layer: f.layer,
+	}
+	if mode.IsDir() {
+		n.entries = make(map[string]*inode)
+	}
+	return n
+}
+
+func pathError(op, p string, err error) error {
+	return &fs.PathError{Op: op, Path: p, Err: err}
+}
+
+// split returns the names in a clean absolute path
+func split(p string) []string {
+	p = strings.Trim(p, "/")
+	if p == "" {
+		return nil
+	}
+	return strings.Split(p, "/")
+}
+
+// resolve looks p up, following symlinks in its directories and, if follow
+// is set, in its last element. It returns the path with symlinks resolved and
+// the inode. If only the last element is missing, the inode is nil and the
+// error ENOENT, but the path is returned so the file can be created.
+func (f *FS) resolve(p string, follow bool) (string, *inode, error) {
+	p = path.Clean("/" + p)
+	links := 0
+lookup:
+	for {
+		cur, curPath := f.root, "/"
+		names := split(p)
+		for i, name := range names {
+			if !cur.mode.IsDir() {
+				return "", nil, syscall.ENOTDIR
+			}
+			child := cur.entries[name]
+			childPath := path.Join(curPath, name)
+			last := i == len(names)-1
+			if child == nil {
+				if last {
+					return childPath, nil, syscall.ENOENT
+				}
+				return "", nil, syscall.ENOENT
+			}
+			if child.mode&fs.ModeSymlink != 0 && (!last || follow) {
+				links++
+				if links > maxSymlinks {
+					return "", nil, syscall.ELOOP
+				}
+				target := child.target
+				if !path.IsAbs(target) {
+					target = path.Join(curPath, target)
+				}
+				p = path.Join(append([]string{target}, names[i+1:]...)...)
+				continue lookup
+			}
+			cur, curPath = child, childPath
+		}
+		return curPath, cur, nil
+	}
+}
+
+// lookup resolves p, which must exist
+func (f *FS) lookup(op, p string, follow bool) (string, *inode, error) {
+	cp, n, err := f.resolve(p, follow)
+	if err != nil {
+		return "", nil, pathError(op, p, err)
+	}
+	return cp, n, nil
+}
+
+// own returns the inode at the resolved path cp, first copying it and the
+// directories above it into this layer if they are shared
+func (f *FS) own(cp string) *inode {
+	if f.root.layer != f.layer {
+		f.root = f.root.copyTo(f.layer)
+	}
+	n := f.root
+	for _, name := range split(cp) {
+		child := n.entries[name]
+		if child.layer != f.layer {
+			child = child.copyTo(f.layer)
+			n.entries[name] = child
+		}
+		n = child
+	}
+	return n
+}
+
+// create adds a new inode at the resolved path cp, whose parent exists
+func (f *FS) create(cp string, mode fs.FileMode) *inode {
+	parent := f.own(path.Dir(cp))
+	n := f.newInode(mode)
+	parent.entries[path.Base(cp)] = n
+	parent.mtime = n.mtime
+	return n
+}
+
+// Stat describes the file p, following symlinks
+func (f *FS) Stat(p string) (FileInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	cp, n, err := f.lookup("stat", p, true)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	return n.info(path.Base(cp)), nil
+}
+
+// Lstat describes the file p; a symlink is described itself
+func (f *FS) Lstat(p string) (FileInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	cp, n, err := f.lookup("lstat", p, false)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	return n.info(path.Base(cp)), nil
+}
+
+// ReadFile returns the contents of the file p
+func (f *FS) ReadFile(p string) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, n, err := f.lookup("open", p, true)
+	if err != nil {
+		return nil, err
+	}
+	if n.mode.IsDir() {
+		return nil, pathError("read", p, syscall.EISDIR)
+	}
+	return append([]byte(nil), n.data...), nil
+}
+
+// ReadDir describes the entries of the directory p, sorted by name
+func (f *FS) ReadDir(p string) ([]FileInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, n, err := f.lookup("open", p, true)
+	if err != nil {
+		return nil, err
+	}
+	if !n.mode.IsDir() {
+		return nil, pathError("readdir", p, syscall.ENOTDIR)
+	}
+	infos := make([]FileInfo, 0, len(n.entries))
+	for name, child := range n.entries {
+		infos = append(infos, child.info(name))
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos, nil
+}
+
+// Readlink returns the target of the symlink p
+func (f *FS) Readlink(p string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, n, err := f.lookup("readlink", p, false)
+	if err != nil {
+		return "", err
+	}
+	if n.mode&fs.ModeSymlink == 0 {
+		return "", pathError("readlink", p, syscall.EINVAL)
+	}
+	return n.target, nil
+}
+
+// WriteFile writes data to the file p, creating it with perm if it does not
+// exist
+func (f *FS) WriteFile(p string, data []byte, perm fs.FileMode) error {
+	return f.write(p, data, perm, false)
+}
+
+// AppendFile appends data to the file p, creating it if it does not exist
+func (f *FS) AppendFile(p string, data []byte) error {
+	return f.write(p, data, 0644, true)
+}
+
+func (f *FS) write(p string, data []byte, perm fs.FileMode, appending bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.Quota > 0 && f.written+int64(len(data)) > f.Quota {
+		return pathError("write", p, syscall.ENOSPC)
+	}
+
+	cp, n, err := f.resolve(p, true)
+	switch {
+	case n == nil && cp != "":
+		n = f.create(cp, perm.Perm())
+	case err != nil:
+		return pathError("open", p, err)
+	case n.mode.IsDir():
+		return pathError("open", p, syscall.EISDIR)
+	case n.mode&fs.ModeDevice != 0:
+		// Writes to devices are discarded
+		return nil
+	default:
+		n = f.own(cp)
+	}
+
+	if appending {
+		// The old contents may be shared, so they are copied, not extended
+		n.data = append(n.data[:len(n.data):len(n.data)], data...)
+	} else {
+		n.data = append([]byte(nil), data...)
+	}
+	n.mtime = f.Now()
+	f.written += int64(len(data))
+
+	if f.artifacts == nil {
+		f.artifacts = make(map[uint64]*artifact)
+	}
+	a, ok := f.artifacts[n.ino]
+	if !ok {
+		a = &artifact{}
+		f.artifacts[n.ino] = a
+		f.order = append(f.order, n.ino)
+	}
+	a.path, a.node = cp, n
+	return nil
+}
+
+// Mkdir creates the directory p
+func (f *FS) Mkdir(p string, perm fs.FileMode) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.mkdir(p, perm)
+}
+
+func (f *FS) mkdir(p string, perm fs.FileMode) error {
+	cp, n, err := f.resolve(p, false)
+	switch {
+	case n != nil:
+		return pathError("mkdir", p, syscall.EEXIST)
+	case cp == "":
+		return pathError("mkdir", p, err)
+	}
+	f.create(cp, fs.ModeDir|perm.Perm())
+	return nil
+}
+
+// MkdirAll creates the directory p and any missing parents
+func (f *FS) MkdirAll(p string, perm fs.FileMode) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	dir := "/"
+	for _, name := range split(path.Clean("/" + p)) {
+		dir = path.Join(dir, name)
+		_, n, err := f.resolve(dir, true)
+		if n == nil {
+			if err := f.mkdir(dir, perm); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return pathError("mkdir", dir, err)
+		}
+		if !n.mode.IsDir() {
+			return pathError("mkdir", dir, syscall.ENOTDIR)
+		}
+	}
+	return nil
+}
+
+// Remove removes the file or empty directory p
+func (f *FS) Remove(p string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.remove(p, false)
+}
+
+// RemoveAll removes p and everything under it. It is not an error if p does
+// not exist.
+func (f *FS) RemoveAll(p string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, n, _ := f.resolve(p, false); n == nil {
+		return nil
+	}
+	return f.remove(p, true)
+}
+
+func (f *FS) remove(p string, all bool) error {
+	cp, n, err := f.lookup("remove", p, false)
+	if err != nil {
+		return err
+	}
+	if cp == "/" {
+		return pathError("remove", p, syscall.EBUSY)
+	}
+	if n.mode.IsDir() && len(n.entries) > 0 && !all {
+		return pathError("remove", p, syscall.ENOTEMPTY)
+	}
+	parent := f.own(path.Dir(cp))
+	delete(parent.entries, path.Base(cp))
+	parent.mtime = f.Now()
+	f.unlinked(n)
+	return nil
+}
+
+// unlinked marks the artifacts in the tree n as removed
+func (f *FS) unlinked(n *inode) {
+	if a, ok := f.artifacts[n.ino]; ok {
+		a.removed = true
+	}
+	for _, child := range n.entries {
+		f.unlinked(child)
+	}
+}
+
+// Rename moves oldpath to newpath, replacing a file or empty directory there
+func (f *FS) Rename(oldpath, newpath string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ocp, on, err := f.lookup("rename", oldpath, false)
+	if err != nil {
+		return err
+	}
+	ncp, nn, err := f.resolve(newpath, false)
+	if ncp == "" {
+		return pathError("rename", newpath, err)
+	}
+	if nn != nil {
+		switch {
+		case nn.mode.IsDir() && !on.mode.IsDir():
+			return pathError("rename", newpath, syscall.EISDIR)
+		case !nn.mode.IsDir() && on.mode.IsDir():
+			return pathError("rename", newpath, syscall.ENOTDIR)
+		case nn.mode.IsDir() && len(nn.entries) > 0:
+			return pathError("rename", newpath, syscall.ENOTEMPTY)
+		}
+	}
+	if ocp == ncp {
+		return nil
+	}
+	if ocp == "/" || strings.HasPrefix(ncp, ocp+"/") {
+		return pathError("rename", newpath, syscall.EINVAL)
+	}
+
+	oldParent := f.own(path.Dir(ocp))
+	delete(oldParent.entries, path.Base(ocp))
+	newParent := f.own(path.Dir(ncp))
+	newParent.entries[path.Base(ncp)] = on
+	oldParent.mtime, newParent.mtime = f.Now(), f.Now()
+	if nn != nil {
+		f.unlinked(nn)
+	}
+	if a, ok := f.artifacts[on.ino]; ok {
+		a.path = ncp
+	}
+	return nil
+}
+
+// Symlink creates the symlink link pointing to target
+func (f *FS) Symlink(target, link string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	cp, n, err := f.resolve(link, false)
+	switch {
+	case n != nil:
+		return pathError("symlink", link, syscall.EEXIST)
+	case cp == "":
+		return pathError("symlink", link, err)
+	}
+	f.create(cp, fs.ModeSymlink|0777).target = target
+	return nil
+}
+
+// Mknod creates the device file p. mode gives its type, e.g.
+// fs.ModeDevice|fs.ModeCharDevice, and permissions. Devices read as empty.
+func (f *FS) Mknod(p string, mode fs.FileMode) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	cp, n, err := f.resolve(p, false)
+	switch {
+	case n != nil:
+		return pathError("mknod", p, syscall.EEXIST)
+	case cp == "":
+		return pathError("mknod", p, err)
+	}
+	f.create(cp, mode&(fs.ModeDevice|fs.ModeCharDevice)|mode.Perm())
+	return nil
+}
+
+// Chmod sets the permission bits of p, following symlinks
+func (f *FS) Chmod(p string, mode fs.FileMode) error {
+	return f.change("chmod", p, func(n *inode) {
+		const bits = fs.ModePerm | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky
+		n.mode = n.mode&^bits | mode&bits
+	})
+}
+
+// Chown sets the owner and group of p, following symlinks. An ID of -1 is
+// left unchanged.
+func (f *FS) Chown(p string, uid, gid int) error {
+	return f.change("chown", p, func(n *inode) {
+		if uid >= 0 {
+			n.uid = uid
+		}
+		if gid >= 0 {
+			n.gid = gid
+		}
+	})
+}
+
+// Chtimes sets the modification time of p, following symlinks
+func (f *FS) Chtimes(p string, mtime time.Time) error {
+	return f.change("chtimes", p, func(n *inode) {
+		n.mtime = mtime
+	})
+}
+
+// change applies fn to the inode at p
+func (f *FS) change(op, p string, fn func(n *inode)) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	cp, _, err := f.lookup(op, p, true)
+	if err != nil {
+		return err
+	}
+	fn(f.own(cp))
+	return nil
+}
+
+// Artifacts returns the files written, in the order they were first written,
+// with their final contents
+func (f *FS) Artifacts() []Artifact {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	artifacts := make([]Artifact, 0, len(f.order))
+	for _, ino := range f.order {
+		a := f.artifacts[ino]
+		artifacts = append(artifacts, Artifact{
+			Path:    a.path,
+			Data:    a.node.data,
+			Mode:    a.node.mode,
+			Removed: a.removed,
+		})
+	}
+	return artifacts
+}
@@ -0,0 +1,225 @@
+package vfs
+
+import (
+	"errors"
+	"io/fs"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func newTestFS(t *testing.T) *FS {
+	t.Helper()
+	f := New()
+	for _, dir := range []string{"/etc", "/tmp", "/root/.ssh"} {
+		if err := f.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := f.WriteFile("/etc/passwd", []byte("root:x:0:0::/root:/bin/bash\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Symlink("/etc", "/cfg"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Symlink("../etc/passwd", "/tmp/pw"); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestOperations(t *testing.T) {
+	tests := []struct {
+		name    string
+		op      func(f *FS) error
+		wantErr error
+	}{
+		{"read through dir symlink", func(f *FS) error { _, err := f.ReadFile("/cfg/passwd"); return err }, nil},
+		{"read through relative symlink", func(f *FS) error { _, err := f.ReadFile("/tmp/pw"); return err }, nil},
+		{"read missing", func(f *FS) error { _, err := f.ReadFile("/etc/shadow"); return err }, syscall.ENOENT},
+		{"read dir", func(f *FS) error { _, err := f.ReadFile("/etc"); return err }, syscall.EISDIR},
+		{"read under file", func(f *FS) error { _, err := f.ReadFile("/etc/passwd/x"); return err }, syscall.ENOTDIR},
+		{"write new", func(f *FS) error { return f.WriteFile("/tmp/x", []byte("x"), 0644) }, nil},
+		{"write through dir symlink", func(f *FS) error { return f.WriteFile("/cfg/new", nil, 0644) }, nil},
+		{"write missing dir", func(f *FS) error { return f.WriteFile("/nope/x", nil, 0644) }, syscall.ENOENT},
+		{"write dir", func(f *FS) error { return f.WriteFile("/tmp", nil, 0644) }, syscall.EISDIR},
+		{"mkdir exists", func(f *FS) error { return f.Mkdir("/tmp", 0755) }, syscall.EEXIST},
+		{"mkdir missing parent", func(f *FS) error { return f.Mkdir("/a/b", 0755) }, syscall.ENOENT},
+		{"mkdir all", func(f *FS) error { return f.MkdirAll("/a/b/c", 0755) }, nil},
+		{"mkdir all through file", func(f *FS) error { return f.MkdirAll("/etc/passwd/x", 0755) }, syscall.ENOTDIR},
+		{"remove file", func(f *FS) error { return f.Remove("/etc/passwd") }, nil},
+		{"remove non-empty", func(f *FS) error { return f.Remove("/etc") }, syscall.ENOTEMPTY},
+		{"remove missing", func(f *FS) error { return f.Remove("/nope") }, syscall.ENOENT},
+		{"remove root", func(f *FS) error { return f.RemoveAll("/") }, syscall.EBUSY},
+		{"remove all missing", func(f *FS) error { return f.RemoveAll("/nope") }, nil},
+		{"rename into own subtree", func(f *FS) error { return f.Rename("/root", "/root/.ssh/x") }, syscall.EINVAL},
+		{"rename file over dir", func(f *FS) error { return f.Rename("/etc/passwd", "/tmp") }, syscall.EISDIR},
+		{"rename dir over file", func(f *FS) error { return f.Rename("/tmp", "/etc/passwd") }, syscall.ENOTDIR},
+		{"symlink exists", func(f *FS) error { return f.Symlink("x", "/etc/passwd") }, syscall.EEXIST},
+		{"mknod exists", func(f *FS) error { return f.Mknod("/tmp", fs.ModeDevice|fs.ModeCharDevice|0666) }, syscall.EEXIST},
+		{"write device", func(f *FS) error {
+			f.Mknod("/null", fs.ModeDevice|fs.ModeCharDevice|0666)
+			f.WriteFile("/null", []byte("x"), 0644)
+			if data, _ := f.ReadFile("/null"); len(data) != 0 {
+				return errors.New("device kept data")
+			}
+			return nil
+		}, nil},
+		{"readlink file", func(f *FS) error { _, err := f.Readlink("/etc/passwd"); return err }, syscall.EINVAL},
+		{"symlink loop", func(f *FS) error {
+			f.Symlink("/loop2", "/loop1")
+			f.Symlink("/loop1", "/loop2")
+			_, err := f.Stat("/loop1")
+			return err
+		}, syscall.ELOOP},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.op(newTestFS(t))
+			if tt.wantErr == nil && err != nil || tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("error = %v, want %v", err, tt.wantErr)
+			}
+			var pe *fs.PathError
+			if err != nil && !errors.As(err, &pe) {
+				t.Errorf("error %v is not a *fs.PathError", err)
+			}
+		})
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	f := newTestFS(t)
+	stamp := time.Date(2021, 12, 15, 10, 23, 0, 0, time.UTC)
+	f.Now = func() time.Time { return stamp }
+	f.UID, f.GID = 1000, 1000
+
+	f.WriteFile("/tmp/bot", []byte("\x7fELF"), 0644)
+	if err := f.Chmod("/tmp/bot", 0755); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := f.Stat("/tmp/bot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode != 0755 || fi.UID != 1000 || fi.GID != 1000 || fi.Size != 4 || !fi.ModTime.Equal(stamp) {
+		t.Errorf("Stat() = %+v", fi)
+	}
+
+	f.Chown("/tmp/bot", 0, -1)
+	if fi, _ := f.Stat("/tmp/bot"); fi.UID != 0 || fi.GID != 1000 {
+		t.Errorf("after chown uid, gid = %d, %d, want 0, 1000", fi.UID, fi.GID)
+	}
+
+	li, _ := f.Lstat("/tmp/pw")
+	if li.Mode&fs.ModeSymlink == 0 || li.Target != "../etc/passwd" || li.Size != int64(len("../etc/passwd")) {
+		t.Errorf("Lstat(symlink) = %+v", li)
+	}
+	if si, _ := f.Stat("/tmp/pw"); !si.Mode.IsRegular() {
+		t.Errorf("Stat(symlink) mode = %v, want the target's", si.Mode)
+	}
+	if di, _ := f.Stat("/root"); di.Nlink != 3 {
+		t.Errorf("Nlink of /root = %d, want 3", di.Nlink)
+	}
+
+	infos, _ := f.ReadDir("/tmp")
+	if len(infos) != 2 || infos[0].Name != "bot" || infos[1].Name != "pw" {
+		t.Errorf("ReadDir(/tmp) = %+v", infos)
+	}
+}
+
+func TestOverlayIsolation(t *testing.T) {
+	base := newTestFS(t)
+	a, b := base.Overlay(), base.Overlay()
+
+	a.WriteFile("/etc/passwd", []byte("changed\n"), 0644)
+	a.AppendFile("/tmp/dropper", []byte("x"))
+	a.Remove("/tmp/pw")
+	a.Rename("/root", "/home")
+	a.Chmod("/etc", 0700)
+
+	if data, _ := base.ReadFile("/etc/passwd"); string(data) != "root:x:0:0::/root:/bin/bash\n" {
+		t.Errorf("base /etc/passwd = %q", data)
+	}
+	if data, _ := b.ReadFile("/etc/passwd"); string(data) != "root:x:0:0::/root:/bin/bash\n" {
+		t.Errorf("other overlay /etc/passwd = %q", data)
+	}
+	for _, p := range []string{"/tmp/pw", "/root/.ssh"} {
+		if _, err := b.Lstat(p); err != nil {
+			t.Errorf("other overlay lost %s: %v", p, err)
+		}
+	}
+	if _, err := b.Stat("/tmp/dropper"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("other overlay sees /tmp/dropper: %v", err)
+	}
+	if fi, _ := base.Stat("/etc"); fi.Mode.Perm() != 0755 {
+		t.Errorf("base /etc mode = %v", fi.Mode)
+	}
+	if _, err := a.Stat("/home/.ssh"); err != nil {
+		t.Errorf("renamed directory lost its contents: %v", err)
+	}
+
+	// Changes to the base after the overlay was taken stay in the base
+	base.WriteFile("/etc/motd", []byte("hi"), 0644)
+	if _, err := a.Stat("/etc/motd"); err == nil {
+		t.Error("overlay sees a file written to the base later")
+	}
+	if len(base.Artifacts()) == 0 {
+		t.Error("base writes were not tracked")
+	}
+}
+
+func TestAppendDoesNotLeak(t *testing.T) {
+	base := New()
+	// Spare capacity in the base's slice must not be written by appends
+	base.WriteFile("/f", make([]byte, 1, 64), 0644)
+	a, b := base.Overlay(), base.Overlay()
+	a.AppendFile("/f", []byte("a"))
+	b.AppendFile("/f", []byte("b"))
+	if data, _ := a.ReadFile("/f"); string(data) != "\x00a" {
+		t.Errorf("overlay a = %q", data)
+	}
+	if data, _ := b.ReadFile("/f"); string(data) != "\x00b" {
+		t.Errorf("overlay b = %q", data)
+	}
+}
+
+func TestArtifacts(t *testing.T) {
+	f := newTestFS(t).Overlay()
+	// A dropper writing a binary with echo, then running and removing it
+	f.WriteFile("/tmp/.d", []byte("\x7fE"), 0644)
+	f.AppendFile("/tmp/.d", []byte("LF"))
+	f.Rename("/tmp/.d", "/tmp/dvrHelper")
+	f.Remove("/tmp/dvrHelper")
+	f.WriteFile("/root/.ssh/authorized_keys", []byte("ssh-rsa AAAA"), 0600)
+	f.Mkdir("/tmp/empty", 0755)
+
+	got := f.Artifacts()
+	want := []Artifact{
+		{Path: "/tmp/dvrHelper", Data: []byte("\x7fELF"), Mode: 0644, Removed: true},
+		{Path: "/root/.ssh/authorized_keys", Data: []byte("ssh-rsa AAAA"), Mode: 0600},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Artifacts() = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i].Path != want[i].Path || string(got[i].Data) != string(want[i].Data) || got[i].Mode != want[i].Mode || got[i].Removed != want[i].Removed {
+			t.Errorf("artifact %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestQuota(t *testing.T) {
+	f := New()
+	f.Quota = 10
+	if err := f.WriteFile("/a", make([]byte, 6), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// The quota counts bytes written, so overwriting does not free any
+	if err := f.WriteFile("/a", make([]byte, 6), 0644); !errors.Is(err, syscall.ENOSPC) {
+		t.Errorf("write over quota error = %v, want ENOSPC", err)
+	}
+	if err := f.AppendFile("/a", make([]byte, 4)); err != nil {
+		t.Errorf("write within quota error = %v", err)
+	}
+}
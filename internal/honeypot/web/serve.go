@@ -0,0 +1,118 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Page is what templates are rendered with
+type Page struct {
+	Host  string // Host the client asked for
+	Path  string
+	Query url.Values
+	Form  url.Values // Form fields of the body and the query
+	Now   time.Time
+	Vars  map[string]string // The site's variables
+}
+
+// Route returns the route that answers r, or nil
+func (s *Site) Route(r *http.Request) *Route {
+	for _, route := range s.Routes {
+		if route.Matches(r.Method, r.URL.Path) {
+			return route
+		}
+	}
+	return nil
+}
+
+// ServeHTTP answers r with the site's matching route, or its not found page.
+// The form of r is parsed if it was not already.
+func (s *Site) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Form == nil {
+		r.ParseForm()
+	}
+	page := &Page{
+		Host:  r.Host,
+		Path:  r.URL.Path,
+		Query: r.URL.Query(),
+		Form:  r.Form,
+		Now:   time.Now(),
+		Vars:  s.Vars,
+	}
+
+	h := w.Header()
+	if s.Server != "" {
+		h.Set("Server", s.Server)
+	}
+	for k, v := range s.Headers {
+		h.Set(k, v)
+	}
+
+	route := s.Route(r)
+	if route == nil {
+		s.respond(w, page, http.StatusNotFound, s.notFound)
+		return
+	}
+	for k, tmpl := range route.headers {
+		var v bytes.Buffer
+		if err := tmpl.Execute(&v, page); err == nil {
+			h.Set(k, v.String())
+		}
+	}
+	s.respond(w, page, route.Status, route.body)
+}
+
+// respond writes the status and the rendered body b, if there is one
+func (s *Site) respond(w http.ResponseWriter, page *Page, status int, b *body) {
+	if b == nil {
+		if status >= 400 {
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+		w.WriteHeader(status)
+		return
+	}
+	content := b.raw
+	if b.tmpl != nil {
+		var buf bytes.Buffer
+		if err := b.tmpl.Execute(&buf, page); err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		content = buf.Bytes()
+	}
+	w.Header().Set("Content-Type", b.contentType)
+	w.Header().Set("Content-Length", fmt.Sprint(len(content)))
+	w.WriteHeader(status)
+	w.Write(content)
+}
+
+// Credentials returns the user and password r submits to a login route of
+// the site. body is the request body, which has been read; the form of r
+// must have been parsed.
+func (s *Site) Credentials(r *http.Request, body []byte) (user, password string, ok bool) {
+	route := s.Route(r)
+	if route == nil || route.Login == nil {
+		return "", "", false
+	}
+	login := route.Login
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if strings.HasSuffix(contentType, "json") {
+		var fields map[string]interface{}
+		if json.Unmarshal(body, &fields) != nil {
+			return "", "", false
+		}
+		user, _ = fields[login.User].(string)
+		password, _ = fields[login.Password].(string)
+	} else {
+		user = r.PostForm.Get(login.User)
+		password = r.PostForm.Get(login.Password)
+	}
+	return user, password, user != "" || password != ""
+}
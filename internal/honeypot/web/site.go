@@ -0,0 +1,233 @@
+// Package web serves the sites of the HTTP persona. A site is a directory
+// with a site.json that maps request paths to files, e.g.
+//
+//	{
+//	  "server": "Apache/2.4.41 (Ubuntu)",
+//	  "ports": [80, 443],
+//	  "vars": {"version": "5.8.2"},
+//	  "routes": [
+//	    {"path": "/", "file": "index.html.tmpl"},
+//	    {"path": "/wp-login.php", "method": "POST", "file": "login.html.tmpl",
+//	     "login": {"user": "log", "password": "pwd"}},
+//	    {"path": "/wp-admin/*", "status": 302,
+//	     "headers": {"Location": "/wp-login.php?redirect_to={{.Path | urlquery}}"}}
+//	  ],
+//	  "not_found": "404.html"
+//	}
+//
+// Files ending in .tmpl, inline bodies and header values are text/template
+// templates rendered with a Page. Sites for WordPress, Jenkins, GitLab,
+// phpMyAdmin and Grafana are built in; more can be loaded from a directory.
+package web
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"maps"
+	"mime"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+// siteFile is the description of a site in its directory
+const siteFile = "site.json"
+
+//go:embed sites
+var builtin embed.FS
+
+// Site is a web application the HTTP persona pretends to run
+type Site struct {
+	Name     string            `json:"-"` // Name of the site's directory
+	Server   string            `json:"server"`
+	Headers  map[string]string `json:"headers"` // Sent with every response
+	Ports    []int             `json:"ports"`   // Ports the site is served on by default
+	Vars     map[string]string `json:"vars"`    // Available to templates as .Vars
+	Routes   []*Route          `json:"routes"`
+	NotFound string            `json:"not_found"` // File served with 404 when no route matches
+
+	notFound *body
+}
+
+// Route is the response to requests for a path
+type Route struct {
+	Path        string            `json:"path"`   // Exact path, or a prefix ending in '*'
+	Method      string            `json:"method"` // Empty matches any method
+	Status      int               `json:"status"` // 200 if zero
+	File        string            `json:"file"`
+	Body        string            `json:"body"` // Inline template used when there is no file
+	ContentType string            `json:"content_type"`
+	Headers     map[string]string `json:"headers"`
+	Login       *LoginForm        `json:"login"` // The request submits credentials
+
+	body    *body
+	headers map[string]*template.Template
+}
+
+// LoginForm names the fields of a login request. Form fields and the keys
+// of a JSON object are both looked up.
+type LoginForm struct {
+	User     string `json:"user"`
+	Password string `json:"password"`
+}
+
+// body is the content of a response: a template or raw bytes
+type body struct {
+	tmpl        *template.Template
+	raw         []byte
+	contentType string
+}
+
+// Matches reports whether the route answers a request for method and p
+func (r *Route) Matches(method, p string) bool {
+	if r.Method != "" && r.Method != method && !(r.Method == "GET" && method == "HEAD") {
+		return false
+	}
+	if prefix, ok := strings.CutSuffix(r.Path, "*"); ok {
+		return strings.HasPrefix(p, prefix)
+	}
+	return r.Path == p
+}
+
+// builtinSites parses the built-in sites once
+var builtinSites = sync.OnceValue(func() map[string]*Site {
+	sites, err := Load(builtin, "sites")
+	if err != nil {
+		panic(err)
+	}
+	return sites
+})
+
+// Builtin returns the built-in sites by name. Sites are not modified once
+// loaded, so they are shared; the map is the caller's.
+func Builtin() map[string]*Site {
+	return maps.Clone(builtinSites())
+}
+
+// LoadDir loads the sites in the subdirectories of dir
+func LoadDir(dir string) (map[string]*Site, error) {
+	return Load(os.DirFS(dir), ".")
+}
+
+// Load loads the sites in the subdirectories of dir in fsys. Subdirectories
+// without a site.json are skipped.
+func Load(fsys fs.FS, dir string) (map[string]*Site, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	sites := make(map[string]*Site)
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		siteFS, err := fs.Sub(fsys, path.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		if _, err := fs.Stat(siteFS, siteFile); err != nil {
+			continue
+		}
+		site, err := loadSite(siteFS, e.Name())
+		if err != nil {
+			return nil, fmt.Errorf("site %s: %w", e.Name(), err)
+		}
+		sites[site.Name] = site
+	}
+	return sites, nil
+}
+
+// loadSite reads site.json in fsys and the files it refers to
+func loadSite(fsys fs.FS, name string) (*Site, error) {
+	data, err := fs.ReadFile(fsys, siteFile)
+	if err != nil {
+		return nil, err
+	}
+	site := &Site{Name: name}
+	if err := json.Unmarshal(data, site); err != nil {
+		return nil, fmt.Errorf("%s: %w", siteFile, err)
+	}
+	for i, r := range site.Routes {
+		if r.Path == "" {
+			return nil, fmt.Errorf("route %d has no path", i)
+		}
+		r.Method = strings.ToUpper(r.Method)
+		if r.Status == 0 {
+			r.Status = 200
+		}
+		if r.File != "" {
+			r.body, err = loadBody(fsys, r.File)
+		} else if r.Body != "" {
+			r.body = &body{contentType: "text/plain; charset=utf-8"}
+			r.body.tmpl, err = template.New(r.Path).Parse(r.Body)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("route %s: %w", r.Path, err)
+		}
+		if r.body != nil && r.ContentType != "" {
+			r.body.contentType = r.ContentType
+		}
+		r.headers = make(map[string]*template.Template, len(r.Headers))
+		for k, v := range r.Headers {
+			if r.headers[k], err = template.New(k).Parse(v); err != nil {
+				return nil, fmt.Errorf("route %s: header %s: %w", r.Path, k, err)
+			}
+		}
+	}
+	if site.NotFound != "" {
+		if site.notFound, err = loadBody(fsys, site.NotFound); err != nil {
+			return nil, err
+		}
+	}
+	return site, nil
+}
+
+// loadBody reads the file name in fsys, parsing it if it is a template
+func loadBody(fsys fs.FS, name string) (*body, error) {
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	b := &body{}
+	base, isTemplate := strings.CutSuffix(name, ".tmpl")
+	if isTemplate {
+		if b.tmpl, err = template.New(name).Parse(string(data)); err != nil {
+			return nil, err
+		}
+	} else {
+		b.raw = data
+	}
+	b.contentType = mime.TypeByExtension(path.Ext(base))
+	if b.contentType == "" {
+		b.contentType = "text/html; charset=UTF-8"
+	}
+	return b, nil
+}
+
+// Names returns the names of sites, sorted
+func Names(sites map[string]*Site) []string {
+	names := make([]string, 0, len(sites))
+	for name := range sites {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ForPort returns the site that claims port, or nil. If several do, the
+// first by name wins.
+func ForPort(sites map[string]*Site, port int) *Site {
+	for _, name := range Names(sites) {
+		for _, p := range sites[name].Ports {
+			if p == port {
+				return sites[name]
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBuiltinSites(t *testing.T) {
+	sites := Builtin()
+	if got := strings.Join(Names(sites), " "); got != "gitlab grafana jenkins phpmyadmin wordpress" {
+		t.Fatalf("Builtin() = %s", got)
+	}
+
+	tests := []struct {
+		site, method, target, body string
+		status                     int
+		header, value              string // Expected response header
+		contains                   string // Expected in the body
+	}{
+		{"wordpress", "GET", "/", "", 200, "X-Powered-By", "PHP/7.4.3", `content="WordPress 5.8.2"`},
+		{"wordpress", "GET", "/wp-admin/plugins.php", "", 302, "Location", "http://example.com/wp-login.php?redirect_to=http%3A%2F%2Fexample.com%2Fwp-admin%2Fplugins.php&reauth=1", ""},
+		{"wordpress", "POST", "/wp-login.php", "log=admin&pwd=admin", 200, "Server", "Apache/2.4.41 (Ubuntu)", "username <strong>admin</strong> is incorrect"},
+		{"wordpress", "POST", "/wp-login.php", "log=%3Cscript%3E&pwd=x", 200, "", "", "username <strong>&lt;script&gt;</strong>"},
+		{"wordpress", "GET", "/xmlrpc.php", "", 405, "Allow", "POST", "accepts POST requests only"},
+		{"wordpress", "GET", "/wp-json/wp/v2/users", "", 200, "Content-Type", "application/json", `"slug":"admin"`},
+		{"wordpress", "GET", "/.env", "", 404, "Content-Type", "text/html; charset=utf-8", "<h1>Not Found</h1>"},
+		{"jenkins", "GET", "/script", "", 403, "X-Jenkins", "2.319.1", "/login?from=%2Fscript"},
+		{"jenkins", "POST", "/j_spring_security_check", "j_username=admin&j_password=admin", 302, "Location", "http://example.com/loginError", ""},
+		{"jenkins", "GET", "/loginError", "", 401, "", "", "Invalid username or password"},
+		{"gitlab", "GET", "/", "", 302, "Location", "https://example.com/users/sign_in", ""},
+		{"gitlab", "POST", "/users/sign_in", "user%5Blogin%5D=root&user%5Bpassword%5D=5iveL%21fe", 200, "", "", "Invalid login or password."},
+		{"gitlab", "GET", "/api/v4/version", "", 401, "Content-Type", "application/json", `{"message":"401 Unauthorized"}`},
+		{"phpmyadmin", "POST", "/phpmyadmin/index.php", "pma_username=root&pma_password=", 200, "", "", "Access denied for user 'root'@'localhost'"},
+		{"grafana", "GET", "/api/health", "", 200, "", "", `"version": "8.3.3"`},
+		{"grafana", "GET", "/d/abc/home", "", 302, "Set-Cookie", "redirect_to=%2Fd%2Fabc%2Fhome; Path=/; HttpOnly; SameSite=Lax", ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, "http://example.com"+tt.target, strings.NewReader(tt.body))
+		if tt.body != "" {
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		w := httptest.NewRecorder()
+		sites[tt.site].ServeHTTP(w, r)
+		name := tt.site + " " + tt.method + " " + tt.target
+		if w.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d", name, w.Code, tt.status)
+		}
+		if tt.header != "" && !strings.HasPrefix(w.Header().Get(tt.header), tt.value) {
+			t.Errorf("%s: %s = %q, want %q", name, tt.header, w.Header().Get(tt.header), tt.value)
+		}
+		if !strings.Contains(w.Body.String(), tt.contains) {
+			t.Errorf("%s: body does not contain %q:\n%s", name, tt.contains, w.Body.String())
+		}
+	}
+}
+
+func TestCredentials(t *testing.T) {
+	sites := Builtin()
+
+	form := url.Values{"log": {"admin"}, "pwd": {"hunter2"}}
+	r := httptest.NewRequest("POST", "/wp-login.php", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.ParseForm()
+	if user, password, ok := sites["wordpress"].Credentials(r, nil); !ok || user != "admin" || password != "hunter2" {
+		t.Errorf("form login = %q, %q, %t", user, password, ok)
+	}
+
+	body := []byte(`{"user":"admin","password":"prom-operator"}`)
+	r = httptest.NewRequest("POST", "/login", strings.NewReader(string(body)))
+	r.Header.Set("Content-Type", "application/json;charset=UTF-8")
+	if user, password, ok := sites["grafana"].Credentials(r, body); !ok || user != "admin" || password != "prom-operator" {
+		t.Errorf("JSON login = %q, %q, %t", user, password, ok)
+	}
+
+	r = httptest.NewRequest("GET", "/wp-login.php", nil)
+	r.ParseForm()
+	if _, _, ok := sites["wordpress"].Credentials(r, nil); ok {
+		t.Error("GET of the login page submits credentials")
+	}
+}
+
+func TestLoadDir(t *testing.T) {
+	dir := t.TempDir()
+	site := filepath.Join(dir, "router")
+	os.MkdirAll(site, 0755)
+	os.WriteFile(filepath.Join(site, "site.json"), []byte(`{
+		"server": "mini_httpd/1.30 26Oct2018",
+		"routes": [
+			{"path": "/cgi-bin/*", "method": "post", "status": 200, "body": "OK {{.Form.Get \"cmd\"}}"},
+			{"path": "/", "file": "index.htm"}
+		]
+	}`), 0644)
+	os.WriteFile(filepath.Join(site, "index.htm"), []byte("<title>{{not a template}}</title>"), 0644)
+	os.MkdirAll(filepath.Join(dir, "assets"), 0755) // Not a site
+
+	sites, err := LoadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sites) != 1 || sites["router"] == nil {
+		t.Fatalf("LoadDir() = %v", Names(sites))
+	}
+
+	w := httptest.NewRecorder()
+	sites["router"].ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Body.String() != "<title>{{not a template}}</title>" || w.Header().Get("Content-Type") != "text/html; charset=utf-8" {
+		t.Errorf("static file = %q (%s)", w.Body.String(), w.Header().Get("Content-Type"))
+	}
+
+	r := httptest.NewRequest("POST", "/cgi-bin/luci?cmd=reboot", nil)
+	w = httptest.NewRecorder()
+	sites["router"].ServeHTTP(w, r)
+	if w.Body.String() != "OK reboot" {
+		t.Errorf("inline template = %q", w.Body.String())
+	}
+
+	// With no not_found page, unknown paths get a plain 404
+	w = httptest.NewRecorder()
+	sites["router"].ServeHTTP(w, httptest.NewRequest("GET", "/cgi-bin/luci", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("GET of a POST route = %d, want 404", w.Code)
+	}
+
+	os.WriteFile(filepath.Join(site, "site.json"), []byte(`{"routes": [{"path": "/", "body": "{{.Nope"}]}`), 0644)
+	if _, err := LoadDir(dir); err == nil || !strings.Contains(err.Error(), "site router") {
+		t.Errorf("LoadDir() of a broken template = %v", err)
+	}
+}
@@ -16,6 +16,11 @@ const (
 	EventTypeOSMutation   EventType = "os_mutation"
 	EventTypeEgressBlock  EventType = "egress_block"
 	EventTypeConnection   EventType = "connection"
+	EventTypeAccessDenied EventType = "access_denied"
+	EventTypeRateLimited  EventType = "rate_limited"
+	EventTypeBlocked      EventType = "blocked"
+	EventTypeMetrics      EventType = "metrics"
+	EventTypeHTTPRequest  EventType = "http_request"
 	EventTypeSystem      EventType = "system"
 )
 
@@ -3,6 +3,7 @@ package logger
 import (
 	"encoding/json"
 	"os"
+	"sync"
 	"time"
 )
 
@@ -17,8 +18,28 @@ type AttackLog struct {
 // LogChannel is a channel for sending log messages
 var LogChannel = make(chan string, 100)
 
+var (
+	attackHookMu sync.RWMutex
+	attackHook   func(ip string, cmd string)
+)
+
+// SetAttackHook registers a function that is called for every logged attack.
+// Used by the ban policy engine to act on honeypot and SPA events.
+func SetAttackHook(hook func(ip string, cmd string)) {
+	attackHookMu.Lock()
+	defer attackHookMu.Unlock()
+	attackHook = hook
+}
+
 // LogAttack writes a structured AttackLog entry to disk
 func LogAttack(ip string, cmd string) {
+	attackHookMu.RLock()
+	hook := attackHook
+	attackHookMu.RUnlock()
+	if hook != nil {
+		hook(ip, cmd)
+	}
+
 	entry := AttackLog{
 		Timestamp:  time.Now().Format(time.RFC3339),
 		AttackerIP: ip,
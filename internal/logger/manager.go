@@ -83,6 +83,18 @@ func (m *Manager) parseLogMessage(msg string) *SecurityEvent {
 		return event
 	}
 
+	if strings.Contains(msg, "UDP PROBE") {
+		event := NewSecurityEvent(EventTypeTrapHit, msg)
+		event.RiskLevel = "MEDIUM"
+		return event
+	}
+
+	if strings.Contains(msg, "TARPIT!") {
+		event := NewSecurityEvent(EventTypeTrapHit, msg)
+		event.RiskLevel = "MEDIUM"
+		return event
+	}
+
 	if strings.Contains(msg, "COMMAND") {
 		// Extract command from message
 		event := NewSecurityEvent(EventTypeCommand, msg)
@@ -164,6 +176,16 @@ func (m *Manager) LogEvent(event *SecurityEvent) {
 	}
 }
 
+// ExportEvent sends a structured event to ELK only. Used for periodic
+// metrics that would flood the dashboard log.
+func (m *Manager) ExportEvent(event *SecurityEvent) {
+	if (m.outputMode == config.OutputModeELK || m.outputMode == config.OutputModeBoth) && m.elkExporter != nil {
+		if err := m.elkExporter.Export(event.ToMap()); err != nil {
+			log.Printf("[ELK] Failed to export event: %v", err)
+		}
+	}
+}
+
 // Close closes the logger manager
 func (m *Manager) Close() error {
 	close(m.logChan)
@@ -85,24 +85,26 @@ func SelectRandomService() string {
 // SelectServiceByPort selects service type based on port for realistic deception
 func SelectServiceByPort(port int) string {
 	switch port {
-	case 80, 443, 8080, 8443, 8000, 8888:
+	case 80, 443, 8080, 8443, 8000, 8888, 8081, 8008, 9090:
 		return "http"
-	case 3306, 5432, 1433, 1521:
+	case 3306, 5432, 1433, 1521, 3307:
 		return "mysql"
-	case 6379, 11211:
+	case 6379, 11211, 6380:
 		return "redis"
 	case 27017, 27018:
 		return "mysql"
-	case 21:
+	case 21, 2121:
 		return "ftp"
-	case 23:
+	case 23, 2323:
 		return "telnet"
-	case 3389, 5900:
+	case 22, 2222, 3389, 5900:
 		return "ssh"
 	case 9200, 5601:
 		return "http"
 	case 3000, 5000:
 		return "http"
+	case 5985, 5986: // WinRM is SOAP over HTTP(S)
+		return "http"
 	default:
 		return SelectRandomService()
 	}
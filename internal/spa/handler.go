@@ -18,6 +18,7 @@ type Handler struct {
 	staticToken string // Static token for legacy SPA mode (configurable)
 	udpConn     *net.UDPConn
 	stopChan    chan struct{}
+	onFailure   func(ip net.IP) // Called for every packet that fails authentication
 }
 
 // NewHandler creates a new SPA packet handler
@@ -36,6 +37,11 @@ func NewHandler(verifier *Verifier, mapLoader *MapLoader, logChan chan<- string,
 	}
 }
 
+// SetFailureHook sets a function called for every packet that fails authentication
+func (h *Handler) SetFailureHook(hook func(ip net.IP)) {
+	h.onFailure = hook
+}
+
 // Start starts the UDP listener for SPA packets
 func (h *Handler) Start() error {
 	addr := &net.UDPAddr{
@@ -229,6 +235,7 @@ func (h *Handler) processPacket(packetData []byte, clientIP net.IP) {
 		case h.logChan <- errMsg:
 		default:
 		}
+		h.reportFailure(clientIP)
 		return
 	}
 
@@ -241,6 +248,7 @@ func (h *Handler) processPacket(packetData []byte, clientIP net.IP) {
 		case h.logChan <- errMsg:
 		default:
 		}
+		h.reportFailure(clientIP)
 		return
 	}
 
@@ -309,3 +317,9 @@ func GetClientIPFromPacket(packetData []byte) (net.IP, error) {
 	return nil, fmt.Errorf("IP must come from UDP connection")
 }
 
+// reportFailure notifies the failure hook of a failed knock
+func (h *Handler) reportFailure(clientIP net.IP) {
+	if h.onFailure != nil {
+		h.onFailure(clientIP)
+	}
+}
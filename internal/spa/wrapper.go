@@ -7,9 +7,18 @@ type MapWrapper struct {
 	Map *ebpf.Map
 }
 
-// Lookup implements the specific Lookup signature required by SPAStatsProvider
+// Lookup implements the specific Lookup signature required by SPAStatsProvider.
+// The SPA counters are per-CPU maps, so the per-CPU values are summed.
 func (w *MapWrapper) Lookup(key uint32, value *uint64) error {
-	return w.Map.Lookup(key, value)
+	var values []uint64
+	if err := w.Map.Lookup(key, &values); err != nil {
+		return err
+	}
+	*value = 0
+	for _, v := range values {
+		*value += v
+	}
+	return nil
 }
 
 // PhantomObjectsWrapper wraps PhantomObjects to implement SPAStatsProvider